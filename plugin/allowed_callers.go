@@ -0,0 +1,78 @@
+package gcpsecrets
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// Kinds of allowed_callers entries, each given as "<kind>:<value>".
+const (
+	callerKindEntity    = "entity"
+	callerKindNamespace = "namespace"
+	callerKindAuthMount = "auth_mount"
+)
+
+// validateAllowedCaller checks that an allowed_callers entry is of a known
+// kind and has a value.
+func validateAllowedCaller(caller string) error {
+	parts := strings.SplitN(caller, ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return fmt.Errorf("%q must be of the form <kind>:<value>", caller)
+	}
+	switch parts[0] {
+	case callerKindEntity, callerKindNamespace, callerKindAuthMount:
+		return nil
+	default:
+		return fmt.Errorf("%q has unknown kind %q, must be one of %s, %s or %s", caller, parts[0], callerKindEntity, callerKindNamespace, callerKindAuthMount)
+	}
+}
+
+// requestCallers returns the allowed_callers entries the request matches:
+// its identity entity, the entity's namespace, and the auth mounts of the
+// entity's aliases.
+func requestCallers(entity *logical.Entity) []string {
+	callers := []string{fmt.Sprintf("%s:%s", callerKindEntity, entity.ID)}
+	if entity.NamespaceID != "" {
+		callers = append(callers, fmt.Sprintf("%s:%s", callerKindNamespace, entity.NamespaceID))
+	}
+	for _, alias := range entity.Aliases {
+		if alias.MountAccessor != "" {
+			callers = append(callers, fmt.Sprintf("%s:%s", callerKindAuthMount, alias.MountAccessor))
+		}
+	}
+	return callers
+}
+
+// checkAllowedCallers returns an error response if the role set has
+// allowed_callers and the request's identity entity matches none of them,
+// or nil if the secret may be issued.
+func (b *backend) checkAllowedCallers(req *logical.Request, rs *RoleSet) (*logical.Response, error) {
+	if len(rs.AllowedCallers) == 0 {
+		return nil, nil
+	}
+	if req.EntityID == "" {
+		return logical.ErrorResponse(fmt.Sprintf("role set '%s' only issues secrets to allowed_callers, and the request has no entity", rs.Name)), nil
+	}
+
+	entity, err := b.System().EntityInfo(req.EntityID)
+	if err != nil {
+		return nil, errwrap.Wrapf("unable to look up the request's identity entity: {{err}}", err)
+	}
+	if entity == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role set '%s' only issues secrets to allowed_callers, and the request's entity was not found", rs.Name)), nil
+	}
+
+	callers := requestCallers(entity)
+	for _, allowed := range rs.AllowedCallers {
+		for _, caller := range callers {
+			if caller == allowed {
+				return nil, nil
+			}
+		}
+	}
+	b.Logger().Warn("refused secret to caller not in allowed_callers", "roleset", rs.Name, "callers", callers)
+	return logical.ErrorResponse(fmt.Sprintf("role set '%s' only issues secrets to allowed_callers, and the request matches none of them (it is from %s)", rs.Name, strings.Join(callers, ", "))), nil
+}
@@ -0,0 +1,50 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// defaultAPITimeout is the deadline for each GCP API call if no api_timeout
+// is configured.
+const defaultAPITimeout = time.Minute
+
+// timeoutTransport gives each request a deadline of timeout, on top of any
+// deadline of the request's own context. The deadline covers reading the
+// response body.
+type timeoutTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	resp, err := t.base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		// Only report the timeout as ours if the caller's context is still
+		// live; otherwise the caller gave up first.
+		if ctx.Err() == context.DeadlineExceeded && req.Context().Err() == nil {
+			return nil, fmt.Errorf("GCP API request to %s%s timed out after %s (api_timeout)", req.URL.Host, req.URL.Path, t.timeout)
+		}
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose cancels the context of a request once its response body is
+// closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
@@ -0,0 +1,58 @@
+package gcpsecrets
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimeoutTransport(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-time.After(5 * time.Second):
+			case <-r.Context().Done():
+			}
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: &timeoutTransport{
+		base:    http.DefaultTransport,
+		timeout: 100 * time.Millisecond,
+	}}
+
+	resp, err := client.Get(srv.URL + "/fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || string(body) != "ok" {
+		t.Fatalf("expected fast response to be read, got %q (err: %v)", body, err)
+	}
+
+	_, err = client.Get(srv.URL + "/slow")
+	if err == nil || !strings.Contains(err.Error(), "timed out after 100ms (api_timeout)") {
+		t.Fatalf("expected api_timeout error, got %v", err)
+	}
+
+	// A caller whose own context is done gets its own error.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	req, err := http.NewRequest(http.MethodGet, srv.URL+"/slow", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.Do(req.WithContext(ctx))
+	if err == nil || strings.Contains(err.Error(), "api_timeout") {
+		t.Fatalf("expected context cancellation error, got %v", err)
+	}
+}
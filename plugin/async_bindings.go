@@ -0,0 +1,104 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"github.com/mitchellh/mapstructure"
+)
+
+const (
+	// Values of RoleSet.BindingsStatus.
+	bindingsStatusPending = "pending"
+	bindingsStatusApplied = "applied"
+	bindingsStatusFailed  = "failed"
+
+	// asyncBindingsTimeout bounds the first, immediate attempt to apply the
+	// bindings of a role set created with async_bindings. Later attempts are
+	// made on WAL rollback.
+	asyncBindingsTimeout = 10 * time.Minute
+)
+
+// bindingsStatus returns the role set's BindingsStatus, treating role sets
+// saved before it was added as applied.
+func (rs *RoleSet) bindingsStatus() string {
+	if rs.BindingsStatus == "" {
+		return bindingsStatusApplied
+	}
+	return rs.BindingsStatus
+}
+
+// bindingsPendingWarning returns a warning for secrets issued while the role
+// set's bindings aren't applied, or "" if they are.
+func (rs *RoleSet) bindingsPendingWarning() string {
+	switch rs.bindingsStatus() {
+	case bindingsStatusPending:
+		return fmt.Sprintf("role set '%s' bindings are still being applied, the secret's permissions may not be effective yet", rs.Name)
+	case bindingsStatusFailed:
+		return fmt.Sprintf("applying role set '%s' bindings failed and will be retried, the secret's permissions may not be effective yet: %s", rs.Name, rs.BindingsError)
+	default:
+		return ""
+	}
+}
+
+// applyBindingsInBackground makes the first attempt to apply the bindings of
+// a role set created with async_bindings, right after it is saved. If it
+// fails, the WAL entry is left for rollback to retry.
+func (b *backend) applyBindingsInBackground(s logical.Storage, entry *walApplyBindings, walId string) {
+	ctx, cancel := context.WithTimeout(context.Background(), asyncBindingsTimeout)
+	defer cancel()
+
+	if err := b.applyPendingBindings(ctx, s, entry); err != nil {
+		b.Logger().Warn("unable to apply role set bindings, will retry on WAL rollback", "roleset", entry.RoleSet, "error", err)
+		return
+	}
+	if err := framework.DeleteWAL(ctx, s, walId); err != nil {
+		b.Logger().Warn("unable to delete WAL entry for applied role set bindings", "roleset", entry.RoleSet, "error", err)
+	}
+}
+
+func (b *backend) applyBindingsRollback(ctx context.Context, req *logical.Request, data interface{}) error {
+	var entry walApplyBindings
+	if err := mapstructure.Decode(data, &entry); err != nil {
+		return err
+	}
+	return b.applyPendingBindings(ctx, req.Storage, &entry)
+}
+
+// applyPendingBindings applies the bindings of the role set in entry and
+// records the outcome in its BindingsStatus. It does nothing if the role set
+// was deleted, moved to another service account, or already has its bindings
+// applied.
+func (b *backend) applyPendingBindings(ctx context.Context, s logical.Storage, entry *walApplyBindings) error {
+	b.rolesetLock.Lock()
+	defer b.rolesetLock.Unlock()
+
+	rs, err := getRoleSet(entry.RoleSet, ctx, s)
+	if err != nil {
+		return err
+	}
+	if rs == nil || rs.AccountId == nil || rs.AccountId.ResourceName() != entry.AccountId.ResourceName() {
+		return nil
+	}
+	if rs.bindingsStatus() == bindingsStatusApplied {
+		return nil
+	}
+
+	if _, err := b.addMissingBindings(ctx, s, rs); err != nil {
+		rs.BindingsStatus = bindingsStatusFailed
+		rs.BindingsError = err.Error()
+		if saveErr := rs.save(ctx, s); saveErr != nil {
+			return multierror.Append(err, saveErr)
+		}
+		return err
+	}
+
+	rs.BindingsStatus = bindingsStatusApplied
+	rs.BindingsError = ""
+	rs.BindingsLastApplied = time.Now().UTC()
+	return rs.save(ctx, s)
+}
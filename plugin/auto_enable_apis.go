@@ -0,0 +1,178 @@
+package gcpsecrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	hclog "github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/serviceusage/v1"
+)
+
+const (
+	// serviceDisabledReason is the reason of the google.rpc.ErrorInfo
+	// detail of errors calling an API that isn't enabled in the project.
+	serviceDisabledReason = "SERVICE_DISABLED"
+
+	// apiEnableTimeout bounds how long enabling an API with
+	// auto_enable_apis may take.
+	apiEnableTimeout = 2 * time.Minute
+)
+
+// disabledService returns the API, such as "iam.googleapis.com", and the
+// project, as "projects/<number>", that the body of a GCP error response
+// reports the API is disabled in, or "" if the error isn't for a disabled
+// API.
+func disabledService(body []byte) (service, consumer string) {
+	var parsed struct {
+		Error struct {
+			Details []struct {
+				Type     string            `json:"@type"`
+				Reason   string            `json:"reason"`
+				Metadata map[string]string `json:"metadata"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return "", ""
+	}
+	for _, d := range parsed.Error.Details {
+		if !strings.HasSuffix(d.Type, "google.rpc.ErrorInfo") || d.Reason != serviceDisabledReason {
+			continue
+		}
+		if d.Metadata["service"] != "" && strings.HasPrefix(d.Metadata["consumer"], "projects/") {
+			return d.Metadata["service"], d.Metadata["consumer"]
+		}
+	}
+	return "", ""
+}
+
+// autoEnableTransport enables the API a GCP call failed for because it is
+// disabled in the project, using enable, and retries the call once. It is
+// only used with auto_enable_apis set.
+type autoEnableTransport struct {
+	base   http.RoundTripper
+	enable func(ctx context.Context, consumer, service string) error
+	logger hclog.Logger
+}
+
+func (t *autoEnableTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusForbidden {
+		return resp, err
+	}
+
+	body, readErr := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if readErr != nil {
+		return resp, nil
+	}
+	service, consumer := disabledService(body)
+	if service == "" {
+		return resp, nil
+	}
+
+	if err := t.enable(req.Context(), consumer, service); err != nil {
+		t.logger.Warn("unable to enable disabled GCP API", "api", service, "project", consumer, "error", err)
+		return resp, nil
+	}
+	t.logger.Warn("enabled disabled GCP API (auto_enable_apis)", "api", service, "project", consumer)
+	if enabled, ok := req.Context().Value(enabledAPIsKey{}).(*enabledAPIs); ok {
+		enabled.add(fmt.Sprintf("%s in %s", service, consumer))
+	}
+
+	retry := req.WithContext(req.Context())
+	if req.Body != nil {
+		if req.GetBody == nil {
+			return resp, nil
+		}
+		if retry.Body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	return t.base.RoundTrip(retry)
+}
+
+// enableAPI enables the API in the project with the Service Usage API,
+// waiting for the operation to finish.
+func enableAPI(ctx context.Context, usageC *serviceusage.Service, consumer, service string) error {
+	ctx, cancel := context.WithTimeout(ctx, apiEnableTimeout)
+	defer cancel()
+
+	op, err := usageC.Services.Enable(fmt.Sprintf("%s/services/%s", consumer, service), &serviceusage.EnableServiceRequest{}).Context(ctx).Do()
+	for err == nil && !op.Done {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(2 * time.Second):
+		}
+		op, err = usageC.Operations.Get(op.Name).Context(ctx).Do()
+	}
+	if err != nil {
+		return err
+	}
+	if op.Error != nil {
+		return errors.New(op.Error.Message)
+	}
+	return nil
+}
+
+// enabledAPIsKey is the context key of the enabledAPIs of a request.
+type enabledAPIsKey struct{}
+
+// enabledAPIs collects the APIs enabled while handling a request, so they
+// can be reported in its response.
+type enabledAPIs struct {
+	l    sync.Mutex
+	apis []string
+}
+
+func (e *enabledAPIs) add(api string) {
+	e.l.Lock()
+	defer e.l.Unlock()
+	e.apis = append(e.apis, api)
+}
+
+func (e *enabledAPIs) list() []string {
+	e.l.Lock()
+	defer e.l.Unlock()
+	apis := make([]string, len(e.apis))
+	copy(apis, e.apis)
+	sort.Strings(apis)
+	return apis
+}
+
+// withEnabledAPIs returns a context collecting the APIs enabled with it.
+func withEnabledAPIs(ctx context.Context) (context.Context, *enabledAPIs) {
+	enabled := &enabledAPIs{}
+	return context.WithValue(ctx, enabledAPIsKey{}, enabled), enabled
+}
+
+// reportEnabledAPIs adds the APIs enabled while handling a request to its
+// response as warnings, or to its error.
+func reportEnabledAPIs(resp *logical.Response, err error, enabled *enabledAPIs) (*logical.Response, error) {
+	apis := enabled.list()
+	if len(apis) == 0 {
+		return resp, err
+	}
+	msg := fmt.Sprintf("enabled GCP APIs that were disabled (auto_enable_apis): %s", strings.Join(apis, ", "))
+	if err != nil {
+		return resp, errwrap.Wrap(fmt.Errorf("%s; %s", err, msg), err)
+	}
+	if resp == nil {
+		resp = &logical.Response{}
+	}
+	resp.AddWarning(msg)
+	return resp, nil
+}
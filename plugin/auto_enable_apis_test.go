@@ -0,0 +1,111 @@
+package gcpsecrets
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+const testServiceDisabledBody = `{
+  "error": {
+    "code": 403,
+    "message": "Identity and Access Management (IAM) API has not been used in project 123 before or it is disabled.",
+    "status": "PERMISSION_DENIED",
+    "details": [
+      {
+        "@type": "type.googleapis.com/google.rpc.ErrorInfo",
+        "reason": "SERVICE_DISABLED",
+        "domain": "googleapis.com",
+        "metadata": {"service": "iam.googleapis.com", "consumer": "projects/123"}
+      }
+    ]
+  }
+}`
+
+func TestAutoEnableTransport(t *testing.T) {
+	t.Parallel()
+
+	enabledInGCP := false
+	var bodies []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		switch {
+		case r.URL.Path == "/denied":
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error": {"code": 403, "message": "permission denied"}}`))
+		case !enabledInGCP:
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(testServiceDisabledBody))
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+	defer srv.Close()
+
+	var enableCalls []string
+	enableErr := errors.New("serviceusage.services.enable denied")
+	transport := &autoEnableTransport{
+		base: http.DefaultTransport,
+		enable: func(ctx context.Context, consumer, service string) error {
+			enableCalls = append(enableCalls, consumer+"/services/"+service)
+			if enableErr != nil {
+				return enableErr
+			}
+			enabledInGCP = true
+			return nil
+		},
+		logger: hclog.NewNullLogger(),
+	}
+	client := &http.Client{Transport: transport}
+
+	// Failing to enable the API returns the original error.
+	resp, err := client.Post(srv.URL+"/call", "application/json", strings.NewReader(`{"a": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := ioutil.ReadAll(resp.Body); resp.StatusCode != http.StatusForbidden || string(body) != testServiceDisabledBody {
+		t.Fatalf("expected the original error when the API can't be enabled, got %d %q", resp.StatusCode, body)
+	}
+
+	// Other 403s don't enable anything.
+	if _, err := client.Get(srv.URL + "/denied"); err != nil {
+		t.Fatal(err)
+	}
+	if len(enableCalls) != 1 {
+		t.Fatalf("expected only disabled APIs to be enabled, got %v", enableCalls)
+	}
+
+	enableErr = nil
+	bodies = nil
+	ctx, enabled := withEnabledAPIs(context.Background())
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/call", strings.NewReader(`{"a": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = client.Do(req.WithContext(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := ioutil.ReadAll(resp.Body); resp.StatusCode != http.StatusOK || string(body) != "ok" {
+		t.Fatalf("expected the call to be retried once the API is enabled, got %d %q", resp.StatusCode, body)
+	}
+	if expected := []string{`{"a": 1}`, `{"a": 1}`}; !reflect.DeepEqual(bodies, expected) {
+		t.Fatalf("expected the retry to resend the request body, got %q", bodies)
+	}
+	if expected := []string{"iam.googleapis.com in projects/123"}; !reflect.DeepEqual(enabled.list(), expected) {
+		t.Fatalf("expected enabled APIs %v, got %v", expected, enabled.list())
+	}
+
+	resp2, _ := reportEnabledAPIs(nil, nil, enabled)
+	if resp2 == nil || len(resp2.Warnings) != 1 || !strings.Contains(resp2.Warnings[0], "iam.googleapis.com in projects/123") {
+		t.Fatalf("expected a warning naming the enabled API, got %#v", resp2)
+	}
+}
@@ -8,22 +8,33 @@ import (
 	"time"
 
 	"github.com/hashicorp/errwrap"
-	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/vault-plugin-auth-gcp/plugin/cache"
 	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/iamutil"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
 	"github.com/hashicorp/vault/sdk/helper/useragent"
 	"github.com/hashicorp/vault/sdk/logical"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/container/v1"
 	"google.golang.org/api/iam/v1"
+	"google.golang.org/api/iamcredentials/v1"
 	"google.golang.org/api/option"
+	"google.golang.org/api/serviceusage/v1"
+	"google.golang.org/api/storage/v1"
 )
 
 const (
 	// cacheTime is the duration for which to cache clients and credentials. This
 	// must be less than 60 minutes.
 	cacheTime = 30 * time.Minute
+
+	// walRollbackMinAge is the minimum age of a WAL entry before it is rolled
+	// back. This also acts as the overlap window for keys scheduled for
+	// deletion.
+	walRollbackMinAge = 5 * time.Minute
 )
 
 type backend struct {
@@ -36,6 +47,35 @@ type backend struct {
 	resources iamutil.ResourceParser
 
 	rolesetLock sync.Mutex
+
+	// tokens caches access tokens when cache_tokens is enabled.
+	tokens *tokenCache
+
+	// tokenCalls coalesces concurrent token generations when
+	// coalesce_token_requests is enabled.
+	tokenCalls *tokenCalls
+
+	// sharedTokenLocks serialize generating tokens for the shared token
+	// cache, by cache key.
+	sharedTokenLocks []*locksutil.LockEntry
+
+	// usage counts the GCP calls made for each role set.
+	usage *quotaUsage
+
+	// counters keeps issuance counters and GCP call latencies for
+	// config/metrics.
+	counters *pluginCounters
+
+	// reconcileLock guards lastReconcile, the last time role set bindings
+	// were reconciled periodically.
+	reconcileLock sync.Mutex
+	lastReconcile time.Time
+
+	// projectNumbersLock guards projectNumbers, the numbers of the projects
+	// looked up for key responses by project ID. Project numbers never
+	// change, so they are kept for the life of the backend.
+	projectNumbersLock sync.RWMutex
+	projectNumbers     map[string]string
 }
 
 // Factory returns a new backend as logical.Backend.
@@ -49,19 +89,30 @@ func Factory(ctx context.Context, conf *logical.BackendConfig) (logical.Backend,
 
 func Backend() *backend {
 	var b = &backend{
-		cache:     cache.New(),
-		resources: iamutil.GetEnabledResources(),
+		cache:            cache.New(),
+		resources:        iamutil.GetEnabledResources(),
+		tokens:           newTokenCache(),
+		tokenCalls:       newTokenCalls(),
+		sharedTokenLocks: locksutil.CreateLocks(),
+		usage:            newQuotaUsage(),
+		counters:         newPluginCounters(),
 	}
 
 	b.Backend = &framework.Backend{
 		BackendType: logical.TypeLogical,
 		Help:        strings.TrimSpace(backendHelp),
 		PathsSpecial: &logical.Paths{
+			Root: []string{
+				"key/override-lease-limit/*",
+			},
 			LocalStorage: []string{
 				framework.WALPrefix,
 			},
 			SealWrapStorage: []string{
 				"config",
+				// Key leases hold rotated keys until they are delivered.
+				keyLeaseStoragePrefix + "/",
+				sharedTokenStoragePrefix + "/",
 			},
 		},
 
@@ -69,22 +120,57 @@ func Backend() *backend {
 			[]*framework.Path{
 				pathConfig(b),
 				pathConfigRotateRoot(b),
+				pathConfigStatus(b),
+				pathConfigKeysAudit(b),
+				pathConfigQuotaUsage(b),
+				pathConfigMetrics(b),
+				pathConfigRevokeBefore(b),
+				pathConfigReconcileKeys(b),
+				pathConfigKeyMap(b),
+				pathConfigExport(b),
+				pathConfigExportRoleSets(b),
+				pathConfigImportRoleSets(b),
+				pathConfigParseBindings(b),
 				pathRoleSet(b),
 				pathRoleSetList(b),
+				pathRoleSetCompare(b),
+				pathRoleSetBatchDelete(b),
 				pathRoleSetRotateAccount(b),
 				pathRoleSetRotateKey(b),
+				pathRoleSetCheckPermissions(b),
+				pathRoleSetReconcile(b),
+				pathRoleSetBindings(b),
+				pathRoleSetUndelete(b),
+				pathRoleSetTransfer(b),
 				pathSecretAccessToken(b),
+				pathSecretAccessTokenExecCredential(b),
+				pathSecretAccessTokenKubeconfig(b),
+				pathSecretImpersonatedCredentials(b),
+				pathSecretAccessTokenDownscoped(b),
+				pathSecretAccessTokenBatch(b),
+				// Must come before key/:roleset, whose pattern it also matches.
+				pathSecretServiceAccountKeyRevokeByName(b),
 				pathSecretServiceAccountKey(b),
+				pathSecretServiceAccountKeyOverrideLeaseLimit(b),
+				pathSecretServiceAccountKeyRotate(b),
+				pathSecretServiceAccountKeyLeases(b),
+				pathSecretHMACKey(b),
+				pathSecretSignBlob(b),
+				pathSecretSignJwt(b),
+				pathSecretIdentityToken(b),
 			},
 		),
 		Secrets: []*framework.Secret{
 			secretAccessToken(b),
 			secretServiceAccountKey(b),
+			secretHMACKey(b),
 		},
 
+		InitializeFunc:    b.initialize,
+		PeriodicFunc:      b.periodicFunc,
 		Invalidate:        b.invalidate,
 		WALRollback:       b.walRollback,
-		WALRollbackMinAge: 5 * time.Minute,
+		WALRollbackMinAge: walRollbackMinAge,
 	}
 
 	return b
@@ -113,6 +199,109 @@ func (b *backend) IAMAdminClient(s logical.Storage) (*iam.Service, error) {
 	return client.(*iam.Service), nil
 }
 
+// IAMCredentialsClient returns a new IAM Service Account Credentials client.
+// The client is cached.
+func (b *backend) IAMCredentialsClient(s logical.Storage) (*iamcredentials.Service, error) {
+	httpClient, err := b.HTTPClient(s)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to create IAM Credentials HTTP client: {{err}}", err)
+	}
+
+	client, err := b.cache.Fetch("iamcredentials", cacheTime, func() (interface{}, error) {
+		opts := []option.ClientOption{option.WithHTTPClient(httpClient)}
+		cfg, err := getConfig(context.Background(), s)
+		if err != nil {
+			b.Logger().Warn("unable to read config, using the default credentials_endpoint", "error", err)
+		}
+		if cfg != nil && cfg.CredentialsEndpoint != "" {
+			opts = append(opts, option.WithEndpoint(cfg.CredentialsEndpoint))
+		}
+		client, err := iamcredentials.NewService(context.Background(), opts...)
+		if err != nil {
+			return nil, errwrap.Wrapf("failed to create IAM Credentials client: {{err}}", err)
+		}
+		client.UserAgent = useragent.String()
+
+		return client, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return client.(*iamcredentials.Service), nil
+}
+
+// ResourceManagerClient returns a new Cloud Resource Manager client. The
+// client is cached.
+func (b *backend) ResourceManagerClient(s logical.Storage) (*cloudresourcemanager.Service, error) {
+	httpClient, err := b.HTTPClient(s)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to create Resource Manager HTTP client: {{err}}", err)
+	}
+
+	client, err := b.cache.Fetch("cloudresourcemanager", cacheTime, func() (interface{}, error) {
+		client, err := cloudresourcemanager.NewService(context.Background(), option.WithHTTPClient(httpClient))
+		if err != nil {
+			return nil, errwrap.Wrapf("failed to create Resource Manager client: {{err}}", err)
+		}
+		client.UserAgent = useragent.String()
+
+		return client, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return client.(*cloudresourcemanager.Service), nil
+}
+
+// ContainerClient returns a new Kubernetes Engine client. The client is
+// cached.
+func (b *backend) ContainerClient(s logical.Storage) (*container.Service, error) {
+	httpClient, err := b.HTTPClient(s)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to create Kubernetes Engine HTTP client: {{err}}", err)
+	}
+
+	client, err := b.cache.Fetch("container", cacheTime, func() (interface{}, error) {
+		client, err := container.NewService(context.Background(), option.WithHTTPClient(httpClient))
+		if err != nil {
+			return nil, errwrap.Wrapf("failed to create Kubernetes Engine client: {{err}}", err)
+		}
+		client.UserAgent = useragent.String()
+
+		return client, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return client.(*container.Service), nil
+}
+
+// StorageClient returns a new Cloud Storage client. The client is cached.
+func (b *backend) StorageClient(s logical.Storage) (*storage.Service, error) {
+	httpClient, err := b.HTTPClient(s)
+	if err != nil {
+		return nil, errwrap.Wrapf("failed to create Cloud Storage HTTP client: {{err}}", err)
+	}
+
+	client, err := b.cache.Fetch("storage", cacheTime, func() (interface{}, error) {
+		client, err := storage.NewService(context.Background(), option.WithHTTPClient(httpClient))
+		if err != nil {
+			return nil, errwrap.Wrapf("failed to create Cloud Storage client: {{err}}", err)
+		}
+		client.UserAgent = useragent.String()
+
+		return client, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return client.(*storage.Service), nil
+}
+
 // HTTPClient returns a new http.Client that is authenticated using the provided
 // credentials. The underlying httpClient is cached among all clients.
 func (b *backend) HTTPClient(s logical.Storage) (*http.Client, error) {
@@ -123,8 +312,42 @@ func (b *backend) HTTPClient(s logical.Storage) (*http.Client, error) {
 
 	client, err := b.cache.Fetch("HTTPClient", cacheTime, func() (interface{}, error) {
 		b.Logger().Debug("creating oauth2 http client")
-		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, cleanhttp.DefaultClient())
-		return oauth2.NewClient(ctx, creds.TokenSource), nil
+		cfg, err := getConfig(context.Background(), s)
+		if err != nil {
+			b.Logger().Warn("unable to read config, using default api_timeout and proxy settings", "error", err)
+		}
+
+		ctx := context.WithValue(context.Background(), oauth2.HTTPClient, cfg.baseHTTPClient())
+		client := oauth2.NewClient(ctx, creds.TokenSource)
+		client.Transport = &latencyTransport{
+			base: &timeoutTransport{
+				base:    client.Transport,
+				timeout: cfg.apiTimeout(),
+			},
+			counters: b.counters,
+		}
+		if level := cfg.gcpLogLevel(); level != gcpLogLevelOff {
+			client.Transport = &loggingTransport{
+				base:   client.Transport,
+				level:  level,
+				logger: b.Logger().Named("gcp"),
+			}
+		}
+		if cfg != nil && cfg.AutoEnableAPIs {
+			usageC, err := serviceusage.NewService(ctx, option.WithHTTPClient(&http.Client{Transport: client.Transport}))
+			if err != nil {
+				return nil, errwrap.Wrapf("failed to create Service Usage client: {{err}}", err)
+			}
+			usageC.UserAgent = useragent.String()
+			client.Transport = &autoEnableTransport{
+				base: client.Transport,
+				enable: func(ctx context.Context, consumer, service string) error {
+					return enableAPI(ctx, usageC, consumer, service)
+				},
+				logger: b.Logger(),
+			}
+		}
+		return client, nil
 	})
 	if err != nil {
 		return nil, err
@@ -154,6 +377,15 @@ func (b *backend) credentials(s logical.Storage) (*google.Credentials, error) {
 		// Get creds from the config
 		credBytes := []byte(cfg.CredentialsRaw)
 
+		// Tokens are fetched with the context's HTTP client, which goes
+		// through the configured proxy.
+		ctx = context.WithValue(ctx, oauth2.HTTPClient, cfg.baseHTTPClient())
+
+		fallbacks, err := cfg.fallbackCredentials(ctx)
+		if err != nil {
+			return nil, err
+		}
+
 		// If credentials were provided, use those. Otherwise fall back to the
 		// default application credentials.
 		var creds *google.Credentials
@@ -164,12 +396,29 @@ func (b *backend) credentials(s logical.Storage) (*google.Credentials, error) {
 			}
 		} else {
 			creds, err = google.FindDefaultCredentials(ctx, iam.CloudPlatformScope)
-			if err != nil {
+			if err != nil && len(fallbacks) == 0 {
 				return nil, errwrap.Wrapf("failed to get default credentials: {{err}}", err)
 			}
 		}
 
-		return creds, err
+		// Tokens come from the first of the primary and fallback credentials
+		// that can get one.
+		if len(fallbacks) > 0 {
+			var sources []oauth2.TokenSource
+			if creds != nil {
+				sources = append(sources, creds.TokenSource)
+			} else {
+				b.Logger().Warn("unable to get default credentials, using fallback credentials only", "error", err)
+				creds = &google.Credentials{ProjectID: fallbacks[0].ProjectID}
+				sources = append(sources, errTokenSource{err: err})
+			}
+			for _, fc := range fallbacks {
+				sources = append(sources, fc.TokenSource)
+			}
+			creds.TokenSource = &failoverTokenSource{sources: sources, logger: b.Logger()}
+		}
+
+		return creds, nil
 	})
 	if err != nil {
 		return nil, err
@@ -177,9 +426,38 @@ func (b *backend) credentials(s logical.Storage) (*google.Credentials, error) {
 	return creds.(*google.Credentials), nil
 }
 
-// ClearCaches deletes all cached clients and credentials.
+// ClearCaches deletes all cached clients, credentials and access tokens.
 func (b *backend) ClearCaches() {
 	b.cache.Clear()
+	b.tokens.clear()
+}
+
+// periodicFunc runs the backend's background work: reconciling role set
+// bindings, deleting expired ephemeral role sets, rotating keys and cleaning
+// up orphaned role sets.
+func (b *backend) periodicFunc(ctx context.Context, req *logical.Request) error {
+	var merr *multierror.Error
+	if err := b.periodicReconcile(ctx, req); err != nil {
+		merr = multierror.Append(merr, err)
+	}
+	if err := b.cleanupEphemeralRoleSets(ctx, req.Storage); err != nil {
+		merr = multierror.Append(merr, err)
+	}
+	if err := b.rotateDueKeys(ctx, req.Storage); err != nil {
+		merr = multierror.Append(merr, err)
+	}
+	if err := b.cleanupOrphanedRoleSets(ctx, req.Storage); err != nil {
+		merr = multierror.Append(merr, err)
+	}
+	if err := b.cleanupRoleSetTransfers(ctx, req.Storage); err != nil {
+		merr = multierror.Append(merr, err)
+	}
+	if !b.cannotWriteSharedTokens() {
+		if err := cleanupSharedTokens(ctx, req.Storage, false); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+	return merr.ErrorOrNil()
 }
 
 // invalidate resets the plugin. This is called when a key is updated via
@@ -199,4 +477,10 @@ manage a dedicated Google Cloud service account.
 
 After mounting this secrets engine, you can configure the credentials using the
 "config/" endpoints. You can generate rolesets using the "rolesets/" endpoints.
+
+Requests GCP blocks with VPC Service Controls fail with an error explaining
+the perimeter denial and giving its unique identifier. The GCP APIs used
+take no access context: whether a request is allowed into a perimeter
+depends on the identity and network it comes from, so the perimeter needs an
+access level or ingress rule for the backend's credentials.
 `
@@ -2,11 +2,20 @@ package gcpsecrets
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
 	hclog "github.com/hashicorp/go-hclog"
 	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/container/v1"
+	"google.golang.org/api/iam/v1"
+	"google.golang.org/api/iamcredentials/v1"
+	"google.golang.org/api/option"
 )
 
 const (
@@ -31,3 +40,44 @@ func getTestBackend(tb testing.TB) (logical.Backend, logical.Storage) {
 	}
 	return b.(*backend), config.StorageView
 }
+
+// getTestBackendWithIAMServer returns a test backend whose IAM and IAM
+// Credentials clients talk to fake APIs served by the given handler.
+func getTestBackendWithIAMServer(tb testing.TB, h http.Handler) (logical.Backend, logical.Storage) {
+	tb.Helper()
+
+	srv := httptest.NewServer(h)
+	tb.Cleanup(srv.Close)
+
+	b, s := getTestBackend(tb)
+	gb := b.(*backend)
+	gb.cache.Fetch("credentials", cacheTime, func() (interface{}, error) {
+		return &google.Credentials{
+			TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "test"}),
+		}, nil
+	})
+	gb.cache.Fetch("HTTPClient", cacheTime, func() (interface{}, error) {
+		return srv.Client(), nil
+	})
+	if _, err := gb.cache.Fetch("iam", cacheTime, func() (interface{}, error) {
+		return iam.NewService(context.Background(), option.WithHTTPClient(srv.Client()), option.WithEndpoint(srv.URL+"/"))
+	}); err != nil {
+		tb.Fatal(err)
+	}
+	if _, err := gb.cache.Fetch("iamcredentials", cacheTime, func() (interface{}, error) {
+		return iamcredentials.NewService(context.Background(), option.WithHTTPClient(srv.Client()), option.WithEndpoint(srv.URL+"/"))
+	}); err != nil {
+		tb.Fatal(err)
+	}
+	if _, err := gb.cache.Fetch("cloudresourcemanager", cacheTime, func() (interface{}, error) {
+		return cloudresourcemanager.NewService(context.Background(), option.WithHTTPClient(srv.Client()), option.WithEndpoint(srv.URL+"/"))
+	}); err != nil {
+		tb.Fatal(err)
+	}
+	if _, err := gb.cache.Fetch("container", cacheTime, func() (interface{}, error) {
+		return container.NewService(context.Background(), option.WithHTTPClient(srv.Client()), option.WithEndpoint(srv.URL+"/"))
+	}); err != nil {
+		tb.Fatal(err)
+	}
+	return b, s
+}
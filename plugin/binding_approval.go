@@ -0,0 +1,129 @@
+package gcpsecrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-cleanhttp"
+)
+
+const (
+	defaultBindingApprovalTimeout = 10 * time.Second
+
+	// maxBindingApprovalResponseSize bounds how much of a webhook response
+	// is read.
+	maxBindingApprovalResponseSize = 64 * 1024
+)
+
+// bindingApprovalRequest is the body POSTed to the binding approval webhook.
+type bindingApprovalRequest struct {
+	RoleSet             string              `json:"roleset"`
+	Operation           string              `json:"operation"`
+	Project             string              `json:"project"`
+	SecretType          string              `json:"secret_type"`
+	ServiceAccountEmail string              `json:"service_account_email,omitempty"`
+	Bindings            map[string][]string `json:"bindings"`
+	BindingMembers      map[string][]string `json:"binding_members,omitempty"`
+	PreviousBindings    map[string][]string `json:"previous_bindings,omitempty"`
+	EntityID            string              `json:"entity_id,omitempty"`
+}
+
+// bindingApprovalResponse is the optional JSON body of a webhook response.
+// Allow is a pointer so a body without it doesn't deny.
+type bindingApprovalResponse struct {
+	Allow  *bool  `json:"allow"`
+	Reason string `json:"reason"`
+}
+
+// validateWebhookURL checks that a configured webhook is an absolute HTTP or
+// HTTPS URL. Empty means no webhook.
+func validateWebhookURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("webhook URL %q must have an http or https scheme", raw)
+	}
+	if u.Host == "" {
+		return errors.New("webhook URL must include a host")
+	}
+	return nil
+}
+
+// bindingApprovalTimeout returns the deadline for binding approval webhook
+// calls.
+func (c *config) bindingApprovalTimeout() time.Duration {
+	if c == nil || c.BindingApprovalTimeout <= 0 {
+		return defaultBindingApprovalTimeout
+	}
+	return c.BindingApprovalTimeout
+}
+
+// approveBindings asks the configured webhook whether the proposed bindings
+// may be applied. If they are denied, it returns why; it returns an error if
+// the webhook couldn't be called. Bindings are approved if no webhook is set.
+func (c *config) approveBindings(ctx context.Context, approval *bindingApprovalRequest) (string, error) {
+	if c == nil || c.BindingApprovalWebhook == "" {
+		return "", nil
+	}
+
+	body, err := json.Marshal(approval)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.bindingApprovalTimeout())
+	defer cancel()
+	httpReq, err := http.NewRequest(http.MethodPost, c.BindingApprovalWebhook, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	httpReq = httpReq.WithContext(ctx)
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := cleanhttp.DefaultClient().Do(httpReq)
+	if err != nil {
+		return "", errwrap.Wrapf("unable to call binding approval webhook: {{err}}", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBindingApprovalResponseSize))
+	if err != nil {
+		return "", errwrap.Wrapf("unable to read binding approval webhook response: {{err}}", err)
+	}
+
+	var decision bindingApprovalResponse
+	if len(bytes.TrimSpace(respBody)) > 0 {
+		// Bodies that aren't JSON are only reported as the reason of a
+		// denial.
+		if err := json.Unmarshal(respBody, &decision); err != nil {
+			decision.Reason = string(bytes.TrimSpace(respBody))
+		}
+	}
+
+	switch {
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		if decision.Reason != "" {
+			return fmt.Sprintf("webhook returned %s: %s", resp.Status, decision.Reason), nil
+		}
+		return fmt.Sprintf("webhook returned %s", resp.Status), nil
+	case decision.Allow != nil && !*decision.Allow:
+		if decision.Reason != "" {
+			return decision.Reason, nil
+		}
+		return "denied by webhook", nil
+	}
+	return "", nil
+}
@@ -0,0 +1,124 @@
+package gcpsecrets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestApproveBindings(t *testing.T) {
+	t.Parallel()
+
+	var status int
+	var body string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if status == 0 {
+			time.Sleep(300 * time.Millisecond)
+			return
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	cfg := &config{BindingApprovalWebhook: srv.URL, BindingApprovalTimeout: 100 * time.Millisecond}
+	approval := &bindingApprovalRequest{RoleSet: "rs", Bindings: map[string][]string{"projects/p": {"roles/viewer"}}}
+
+	cases := []struct {
+		status  int
+		body    string
+		denied  string
+		wantErr bool
+	}{
+		{http.StatusOK, "", "", false},
+		{http.StatusOK, `{"allow": true}`, "", false},
+		{http.StatusOK, `{"allow": false, "reason": "roles/owner is not allowed"}`, "roles/owner is not allowed", false},
+		{http.StatusOK, `{"allow": false}`, "denied by webhook", false},
+		{http.StatusForbidden, `{"reason": "no"}`, "webhook returned 403 Forbidden: no", false},
+		{http.StatusInternalServerError, "oops", "webhook returned 500 Internal Server Error: oops", false},
+		{0, "", "", true},
+	}
+	for _, tc := range cases {
+		status, body = tc.status, tc.body
+		denied, err := cfg.approveBindings(context.Background(), approval)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%d %q: expected error: %t, got: %v", tc.status, tc.body, tc.wantErr, err)
+		}
+		if denied != tc.denied {
+			t.Errorf("%d %q: expected denial %q, got %q", tc.status, tc.body, tc.denied, denied)
+		}
+	}
+
+	// Without a webhook, everything is approved.
+	if denied, err := (*config)(nil).approveBindings(context.Background(), approval); denied != "" || err != nil {
+		t.Errorf("expected approval without a webhook, got %q, %v", denied, err)
+	}
+}
+
+func TestPathRoleSet_BindingApproval(t *testing.T) {
+	t.Parallel()
+
+	var got bindingApprovalRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("unable to decode approval request: %v", err)
+		}
+		w.Write([]byte(`{"allow": false, "reason": "not on the approved list"}`))
+	}))
+	defer srv.Close()
+
+	b, storage := getTestBackend(t)
+	testConfigUpdate(t, b, storage, map[string]interface{}{
+		"binding_approval_webhook": srv.URL,
+	})
+
+	createRoleSet := func() *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "roleset/test-approval",
+			Data: map[string]interface{}{
+				"secret_type": SecretTypeKey,
+				"project":     "my-project",
+				"bindings":    `resource "projects/my-project" { roles = ["roles/viewer"] }`,
+			},
+			Storage: storage,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil || !resp.IsError() {
+			t.Fatalf("expected error response, got: %#v", resp)
+		}
+		return resp
+	}
+
+	resp := createRoleSet()
+	if !strings.Contains(resp.Error().Error(), "not on the approved list") {
+		t.Fatalf("expected the webhook's reason in the error, got: %v", resp.Error())
+	}
+	expected := bindingApprovalRequest{
+		RoleSet:    "test-approval",
+		Operation:  "create",
+		Project:    "my-project",
+		SecretType: SecretTypeKey,
+		Bindings:   map[string][]string{"projects/my-project": {"roles/viewer"}},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected approval request %#v, got %#v", expected, got)
+	}
+
+	// An unreachable webhook rejects the write by default.
+	srv.Close()
+	resp = createRoleSet()
+	if !strings.Contains(resp.Error().Error(), "could not be approved") {
+		t.Fatalf("expected the write to fail closed, got: %v", resp.Error())
+	}
+}
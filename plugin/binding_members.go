@@ -0,0 +1,148 @@
+package gcpsecrets
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/util"
+)
+
+// RoleMembers maps roles to the members granted them.
+type RoleMembers map[string]util.StringSet
+
+// sub returns the role and member pairs of rm that aren't in other.
+func (rm RoleMembers) sub(other RoleMembers) RoleMembers {
+	out := make(RoleMembers)
+	for role, members := range rm {
+		if left := members.Sub(other[role]); len(left) > 0 {
+			out[role] = left
+		}
+	}
+	return out
+}
+
+// intersect returns the role and member pairs in both rm and other.
+func (rm RoleMembers) intersect(other RoleMembers) RoleMembers {
+	out := make(RoleMembers)
+	for role, members := range rm {
+		if both := members.Intersection(other[role]); len(both) > 0 {
+			out[role] = both
+		}
+	}
+	return out
+}
+
+// asWAL returns the pairs in a form that survives the JSON round trip of WAL
+// entries.
+func (rm RoleMembers) asWAL() map[string][]string {
+	if len(rm) == 0 {
+		return nil
+	}
+	out := make(map[string][]string, len(rm))
+	for role, members := range rm {
+		out[role] = members.ToSlice()
+	}
+	return out
+}
+
+// asOutput returns the pairs with members sorted, for output.
+func (rm RoleMembers) asOutput() map[string][]string {
+	out := make(map[string][]string, len(rm))
+	for role, members := range rm {
+		list := members.ToSlice()
+		sort.Strings(list)
+		out[role] = list
+	}
+	return out
+}
+
+func roleMembersFromWAL(raw map[string][]string) RoleMembers {
+	rm := make(RoleMembers, len(raw))
+	for role, members := range raw {
+		rm[role] = util.ToSet(members)
+	}
+	return rm
+}
+
+// desiredMembers returns the pairs of the resource's roles and the
+// additional members the role set grants them to.
+func (rs *RoleSet) desiredMembers(rName string) RoleMembers {
+	members := rs.BindingMembers[rName]
+	if len(members) == 0 {
+		return nil
+	}
+	rm := make(RoleMembers)
+	for role := range rs.Bindings[rName] {
+		rm[role] = util.ToSet(members.ToSlice())
+	}
+	return rm
+}
+
+// addAddedMembers records that the pairs of rm were added on the resource.
+func (rs *RoleSet) addAddedMembers(rName string, rm RoleMembers) {
+	if rs.AddedMembers == nil {
+		rs.AddedMembers = make(map[string]RoleMembers)
+	}
+	if rs.AddedMembers[rName] == nil {
+		rs.AddedMembers[rName] = make(RoleMembers)
+	}
+	for role, members := range rm {
+		rs.AddedMembers[rName][role] = members.Union(rs.AddedMembers[rName][role])
+	}
+}
+
+// subAddedMembers returns, by resource, the pairs of added that aren't in
+// keep.
+func subAddedMembers(added, keep map[string]RoleMembers) map[string]RoleMembers {
+	out := make(map[string]RoleMembers, len(added))
+	for rName, rm := range added {
+		if left := rm.sub(keep[rName]); len(left) > 0 {
+			out[rName] = left
+		}
+	}
+	return out
+}
+
+// validateBindingMembers checks the format of the additional members of each
+// bound resource, returning a reason per invalid member.
+func validateBindingMembers(members map[string]util.StringSet) []string {
+	var reasons []string
+	for rName, ms := range members {
+		for m := range ms {
+			if err := validateIamMember(m); err != nil {
+				reasons = append(reasons, fmt.Sprintf("resource %q: %v", rName, err))
+			}
+		}
+	}
+	sort.Strings(reasons)
+	return reasons
+}
+
+// bindingMembersOutput returns the additional members of each bound resource,
+// sorted, for output.
+func bindingMembersOutput(members map[string]util.StringSet) map[string][]string {
+	out := make(map[string][]string, len(members))
+	for rName, ms := range nonEmptyMembers(members) {
+		list := ms.ToSlice()
+		sort.Strings(list)
+		out[rName] = list
+	}
+	return out
+}
+
+// sameBindingMembers returns whether a and b list the same additional members
+// for each resource, ignoring resources without any.
+func sameBindingMembers(a, b map[string]util.StringSet) bool {
+	return reflect.DeepEqual(bindingMembersOutput(a), bindingMembersOutput(b))
+}
+
+func nonEmptyMembers(members map[string]util.StringSet) map[string]util.StringSet {
+	out := make(map[string]util.StringSet, len(members))
+	for rName, ms := range members {
+		if len(ms) > 0 {
+			out[rName] = ms
+		}
+	}
+	return out
+}
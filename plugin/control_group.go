@@ -0,0 +1,20 @@
+package gcpsecrets
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// checkControlGroup returns an error response if the role set has
+// require_control_group set and the request wasn't approved through a
+// control group, or nil if the secret may be issued.
+func (rs *RoleSet) checkControlGroup(req *logical.Request) *logical.Response {
+	if !rs.RequireControlGroup {
+		return nil
+	}
+	if req.ControlGroup == nil || !req.ControlGroup.Approved {
+		return logical.ErrorResponse(fmt.Sprintf("role set '%s' requires requests to be approved through a control group before secrets are issued, and this request was not", rs.Name))
+	}
+	return nil
+}
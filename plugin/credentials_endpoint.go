@@ -0,0 +1,46 @@
+package gcpsecrets
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// defaultCredentialsEndpoint is the global IAM Credentials API endpoint.
+const defaultCredentialsEndpoint = "https://iamcredentials.googleapis.com/"
+
+// normalizeCredentialsEndpoint checks that a configured IAM Credentials
+// endpoint is an absolute HTTPS URL, and returns it with the trailing slash
+// the API clients expect of a base path. Empty means the global endpoint.
+func normalizeCredentialsEndpoint(raw string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "https" {
+		return "", fmt.Errorf("endpoint %q must have an https scheme", raw)
+	}
+	if u.Host == "" {
+		return "", errors.New("endpoint must include a host")
+	}
+	if u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return "", fmt.Errorf("endpoint %q can't have user info, a query or a fragment", raw)
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+	return u.String(), nil
+}
+
+// credentialsEndpoint returns the IAM Credentials API endpoint used to
+// generate tokens and signatures for role set service accounts.
+func (c *config) credentialsEndpoint() string {
+	if c == nil || c.CredentialsEndpoint == "" {
+		return defaultCredentialsEndpoint
+	}
+	return c.CredentialsEndpoint
+}
@@ -0,0 +1,98 @@
+package gcpsecrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+func TestNormalizeCredentialsEndpoint(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		raw      string
+		expected string
+		invalid  bool
+	}{
+		"empty":         {raw: "", expected: ""},
+		"regional":      {raw: "https://iamcredentials.europe-west1.rep.googleapis.com", expected: "https://iamcredentials.europe-west1.rep.googleapis.com/"},
+		"trailing path": {raw: "https://iamcredentials.example.com/", expected: "https://iamcredentials.example.com/"},
+		"http":          {raw: "http://iamcredentials.googleapis.com/", invalid: true},
+		"no host":       {raw: "https:///v1", invalid: true},
+		"query":         {raw: "https://iamcredentials.googleapis.com/?alt=json", invalid: true},
+	}
+	for name, tc := range cases {
+		endpoint, err := normalizeCredentialsEndpoint(tc.raw)
+		if tc.invalid {
+			if err == nil {
+				t.Errorf("%s: expected error, got %q", name, endpoint)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		} else if endpoint != tc.expected {
+			t.Errorf("%s: expected %q, got %q", name, tc.expected, endpoint)
+		}
+	}
+}
+
+func TestCredentialsEndpoint(t *testing.T) {
+	t.Parallel()
+
+	b, storage := getTestBackend(t)
+	endpoint := "https://iamcredentials.europe-west1.rep.googleapis.com/"
+	testConfigUpdate(t, b, storage, map[string]interface{}{
+		"credentials_endpoint": endpoint,
+	})
+
+	gb := b.(*backend)
+	gb.cache.Fetch("credentials", cacheTime, func() (interface{}, error) {
+		return &google.Credentials{
+			TokenSource: oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "test"}),
+		}, nil
+	})
+
+	credsC, err := gb.IAMCredentialsClient(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credsC.BasePath != endpoint {
+		t.Errorf("expected IAM Credentials client to use %q, got %q", endpoint, credsC.BasePath)
+	}
+	iamC, err := gb.IAMAdminClient(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iamC.BasePath != "https://iam.googleapis.com/" {
+		t.Errorf("expected IAM client to use the global endpoint, got %q", iamC.BasePath)
+	}
+
+	// Impersonated credentials files point clients at the same endpoint.
+	rs := testStoredKeyRoleSet(t, storage, "test-credsendpoint")
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      fmt.Sprintf("token/%s/impersonated-credentials", rs.Name),
+		Data:      map[string]interface{}{"source_credentials": `{"type": "authorized_user"}`},
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+	var creds map[string]interface{}
+	if err := json.Unmarshal([]byte(resp.Data["credentials"].(string)), &creds); err != nil {
+		t.Fatal(err)
+	}
+	expectedURL := endpoint + "v1/projects/-/serviceAccounts/" + rs.AccountId.EmailOrId + ":generateAccessToken"
+	if creds["service_account_impersonation_url"] != expectedURL {
+		t.Errorf("expected impersonation URL %q, got %v", expectedURL, creds["service_account_impersonation_url"])
+	}
+}
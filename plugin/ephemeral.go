@@ -0,0 +1,131 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// defaultEphemeralMaxAge is how long an ephemeral role set lives if no
+// ephemeral_max_age is given.
+const defaultEphemeralMaxAge = time.Hour
+
+// claimEphemeralRoleSet reserves the single secret an ephemeral role set may
+// issue, moving its deletion up to when a secret with the given TTL expires.
+// The returned release func undoes the claim and must be called if the
+// secret could not be issued. Role sets that aren't ephemeral are not
+// changed.
+func (b *backend) claimEphemeralRoleSet(ctx context.Context, s logical.Storage, rs *RoleSet, ttl time.Duration) (release func(), err error) {
+	if !rs.Ephemeral {
+		return func() {}, nil
+	}
+
+	b.rolesetLock.Lock()
+	defer b.rolesetLock.Unlock()
+
+	releaseLocked, err := b.claimEphemeralRoleSetLocked(ctx, s, rs, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		b.rolesetLock.Lock()
+		defer b.rolesetLock.Unlock()
+		releaseLocked()
+	}, nil
+}
+
+// claimEphemeralRoleSetLocked is claimEphemeralRoleSet for callers that
+// already hold rolesetLock, which must also be held when calling the
+// returned release func.
+func (b *backend) claimEphemeralRoleSetLocked(ctx context.Context, s logical.Storage, rs *RoleSet, ttl time.Duration) (release func(), err error) {
+	if !rs.Ephemeral {
+		return func() {}, nil
+	}
+
+	// Re-read the role set so concurrent requests can't both claim it.
+	stored, err := getRoleSet(rs.Name, ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	if stored == nil {
+		return nil, fmt.Errorf("role set '%s' does not exist", rs.Name)
+	}
+	if stored.EphemeralIssued {
+		return nil, fmt.Errorf("ephemeral role set '%s' has already issued its secret", rs.Name)
+	}
+
+	oldExpireTime := stored.EphemeralExpireTime
+	stored.EphemeralIssued = true
+	if expireTime := time.Now().Add(ttl); ttl > 0 && expireTime.Before(stored.EphemeralExpireTime) {
+		stored.EphemeralExpireTime = expireTime
+	}
+	if err := stored.save(ctx, s); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		stored, err := getRoleSet(rs.Name, ctx, s)
+		if err != nil || stored == nil {
+			return
+		}
+		stored.EphemeralIssued = false
+		stored.EphemeralExpireTime = oldExpireTime
+		if err := stored.save(ctx, s); err != nil {
+			b.Logger().Warn("unable to release ephemeral role set after failing to issue its secret", "roleset", rs.Name, "error", err)
+		}
+	}, nil
+}
+
+// secretLeaseTTL returns the TTL a secret's lease gets given the requested
+// TTL in seconds, falling back to the configured and then the mount's
+// default.
+func (b *backend) secretLeaseTTL(cfg *config, ttl int) time.Duration {
+	switch {
+	case ttl > 0:
+		return time.Duration(ttl) * time.Second
+	case cfg != nil && cfg.TTL > 0:
+		return cfg.TTL
+	default:
+		return b.System().DefaultLeaseTTL()
+	}
+}
+
+// cleanupEphemeralRoleSets deletes every ephemeral role set whose secret has
+// expired or that has reached its maximum age, along with its GCP resources.
+func (b *backend) cleanupEphemeralRoleSets(ctx context.Context, s logical.Storage) error {
+	// Only the active node of the primary cluster deletes role sets, which
+	// standbys and performance secondaries can't write.
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby | consts.ReplicationPerformanceSecondary) {
+		return nil
+	}
+
+	rsNames, err := s.List(ctx, fmt.Sprintf("%s/", rolesetStoragePrefix))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, rsName := range rsNames {
+		rs, err := getRoleSet(rsName, ctx, s)
+		if err != nil {
+			return err
+		}
+		if rs == nil || !rs.Ephemeral || now.Before(rs.EphemeralExpireTime) {
+			continue
+		}
+
+		warnings, _, err := b.deleteRoleSet(ctx, s, rs, true, keyCleanupRevoke)
+		if err != nil {
+			b.Logger().Warn("unable to delete expired ephemeral role set", "roleset", rsName, "error", err)
+			continue
+		}
+		for _, w := range warnings {
+			b.Logger().Warn("problem cleaning up expired ephemeral role set", "roleset", rsName, "warning", w)
+		}
+		b.Logger().Info("deleted expired ephemeral role set", "roleset", rsName)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-gcp-common/gcputil"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-multierror"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/iam/v1"
+)
+
+// failoverTokenSource returns a token from the first of its sources that
+// can produce one, so the backend keeps working on fallback credentials while
+// the primary ones are invalid, such as during their rotation.
+type failoverTokenSource struct {
+	sources []oauth2.TokenSource
+	logger  hclog.Logger
+}
+
+func (ts *failoverTokenSource) Token() (*oauth2.Token, error) {
+	var merr *multierror.Error
+	for i, src := range ts.sources {
+		tkn, err := src.Token()
+		if err == nil {
+			if i > 0 {
+				ts.logger.Warn("primary credentials failed, using fallback credentials", "fallback", i, "error", merr.ErrorOrNil())
+			}
+			return tkn, nil
+		}
+		merr = multierror.Append(merr, errwrap.Wrapf(fmt.Sprintf("%s: {{err}}", credentialsLabel(i)), err))
+	}
+	return nil, merr.ErrorOrNil()
+}
+
+// errTokenSource stands in for credentials that couldn't be loaded.
+type errTokenSource struct {
+	err error
+}
+
+func (ts errTokenSource) Token() (*oauth2.Token, error) {
+	return nil, ts.err
+}
+
+// fallbackCredentials parses the config's fallback credentials, in order.
+// ctx carries the HTTP client their tokens are fetched with.
+func (c *config) fallbackCredentials(ctx context.Context) ([]*google.Credentials, error) {
+	if c == nil {
+		return nil, nil
+	}
+	creds := make([]*google.Credentials, 0, len(c.FallbackCredentialsRaw))
+	for i, raw := range c.FallbackCredentialsRaw {
+		fc, err := google.CredentialsFromJSON(ctx, []byte(raw), iam.CloudPlatformScope)
+		if err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("failed to parse %s: {{err}}", credentialsLabel(i+1)), err)
+		}
+		creds = append(creds, fc)
+	}
+	return creds, nil
+}
+
+// fallbackClientEmails returns the client emails of the config's fallback
+// credentials, in order.
+func (c *config) fallbackClientEmails() []string {
+	emails := []string{}
+	if c == nil {
+		return emails
+	}
+	for _, raw := range c.FallbackCredentialsRaw {
+		email := ""
+		if creds, err := gcputil.Credentials(raw); err == nil {
+			email = creds.ClientEmail
+		}
+		emails = append(emails, email)
+	}
+	return emails
+}
+
+// credentialsLabel names the credentials at index i of the ordered list of
+// primary and fallback credentials.
+func credentialsLabel(i int) string {
+	if i == 0 {
+		return "primary credentials"
+	}
+	return fmt.Sprintf("fallback credentials %d", i)
+}
@@ -0,0 +1,33 @@
+package gcpsecrets
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"golang.org/x/oauth2"
+)
+
+func TestFailoverTokenSource(t *testing.T) {
+	t.Parallel()
+
+	ts := &failoverTokenSource{
+		sources: []oauth2.TokenSource{
+			errTokenSource{err: errors.New("primary expired")},
+			oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "fallback"}),
+		},
+		logger: hclog.NewNullLogger(),
+	}
+	tkn, err := ts.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tkn.AccessToken != "fallback" {
+		t.Fatalf("expected token from fallback credentials, got %q", tkn.AccessToken)
+	}
+
+	ts.sources = ts.sources[:1]
+	if _, err := ts.Token(); err == nil {
+		t.Fatal("expected error when every source fails")
+	}
+}
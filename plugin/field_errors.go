@@ -0,0 +1,48 @@
+package gcpsecrets
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// fieldErrors collects why fields of a request are invalid, so they can all
+// be reported at once instead of stopping at the first.
+type fieldErrors struct {
+	fields  []string
+	reasons map[string][]string
+}
+
+// add records a reason the field is invalid.
+func (fe *fieldErrors) add(field, format string, args ...interface{}) {
+	if fe.reasons == nil {
+		fe.reasons = make(map[string][]string)
+	}
+	if _, ok := fe.reasons[field]; !ok {
+		fe.fields = append(fe.fields, field)
+	}
+	fe.reasons[field] = append(fe.reasons[field], fmt.Sprintf(format, args...))
+}
+
+// response returns an error response listing every invalid field, or nil if
+// there are none. After a summary line, the error has a line per reason,
+// "* <field>: <reason>", in the order they were found. Error responses can't
+// carry other data, so this is the form clients can parse.
+func (fe *fieldErrors) response() *logical.Response {
+	if len(fe.fields) == 0 {
+		return nil
+	}
+
+	var lines []string
+	for _, field := range fe.fields {
+		for _, reason := range fe.reasons[field] {
+			lines = append(lines, fmt.Sprintf("* %s: %s", field, reason))
+		}
+	}
+	noun := "field"
+	if len(fe.fields) > 1 {
+		noun = "fields"
+	}
+	return logical.ErrorResponse(fmt.Sprintf("%d invalid %s:\n%s", len(fe.fields), noun, strings.Join(lines, "\n")))
+}
@@ -0,0 +1,101 @@
+package gcpsecrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+// Values of gcp_log_level.
+const (
+	gcpLogLevelOff   = "off"
+	gcpLogLevelError = "error"
+	gcpLogLevelDebug = "debug"
+)
+
+// loggingTransport logs GCP API calls: failed ones at gcpLogLevelError, and
+// all of them at gcpLogLevelDebug. Only the method, host, path, status,
+// latency and, for failures, the GCP error are logged. Query strings and
+// successful response bodies are not, as they may hold tokens or keys.
+type loggingTransport struct {
+	base   http.RoundTripper
+	level  string
+	logger hclog.Logger
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	args := []interface{}{
+		"method", req.Method,
+		"host", req.URL.Host,
+		"path", req.URL.Path,
+		"latency", time.Since(start),
+	}
+
+	switch {
+	case err != nil:
+		t.logger.Error("GCP API call failed", append(args, "error", err)...)
+	case resp.StatusCode >= http.StatusBadRequest:
+		args = append(args, "status", resp.StatusCode)
+		body, readErr := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if readErr == nil {
+			args = append(args, gcpErrorLogArgs(body)...)
+		}
+		t.logger.Error("GCP API call failed", args...)
+	case t.level == gcpLogLevelDebug:
+		t.logger.Debug("GCP API call", append(args, "status", resp.StatusCode)...)
+	}
+	return resp, err
+}
+
+// gcpErrorLogArgs returns the status, message and reasons of the GCP error
+// in a response body as logger arguments, to tell permission and quota
+// errors apart.
+func gcpErrorLogArgs(body []byte) []interface{} {
+	var parsed struct {
+		Error struct {
+			Message string `json:"message"`
+			Status  string `json:"status"`
+			Details []struct {
+				Reason string `json:"reason"`
+			} `json:"details"`
+			Errors []struct {
+				Reason string `json:"reason"`
+			} `json:"errors"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &parsed); err != nil {
+		return nil
+	}
+	var reasons []string
+	for _, d := range parsed.Error.Details {
+		if d.Reason != "" {
+			reasons = append(reasons, d.Reason)
+		}
+	}
+	for _, e := range parsed.Error.Errors {
+		if e.Reason != "" {
+			reasons = append(reasons, e.Reason)
+		}
+	}
+	return []interface{}{
+		"gcp_status", parsed.Error.Status,
+		"gcp_message", parsed.Error.Message,
+		"gcp_reasons", reasons,
+	}
+}
+
+// gcpLogLevel returns which GCP API calls are logged.
+func (c *config) gcpLogLevel() string {
+	if c == nil || c.GCPLogLevel == "" {
+		return gcpLogLevelOff
+	}
+	return c.GCPLogLevel
+}
@@ -0,0 +1,64 @@
+package gcpsecrets
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	hclog "github.com/hashicorp/go-hclog"
+)
+
+func TestLoggingTransport(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/denied" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error": {"code": 429, "message": "Quota exceeded for quota metric 'Key creations'", "status": "RESOURCE_EXHAUSTED", "details": [{"@type": "type.googleapis.com/google.rpc.ErrorInfo", "reason": "RATE_LIMIT_EXCEEDED"}]}}`))
+			return
+		}
+		w.Write([]byte(`{"privateKeyData": "c2VjcmV0LWtleQ=="}`))
+	}))
+	defer srv.Close()
+
+	get := func(level, path string) string {
+		t.Helper()
+		var buf bytes.Buffer
+		client := &http.Client{Transport: &loggingTransport{
+			base:   http.DefaultTransport,
+			level:  level,
+			logger: hclog.New(&hclog.LoggerOptions{Output: &buf, Level: hclog.Debug}),
+		}}
+		resp, err := client.Get(srv.URL + path + "?access_token=tkn")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return buf.String()
+	}
+
+	if logged := get(gcpLogLevelError, "/keys"); logged != "" {
+		t.Fatalf("expected successful calls not to be logged at error level, got %q", logged)
+	}
+
+	logged := get(gcpLogLevelError, "/denied")
+	for _, expected := range []string{"[ERROR]", "GCP API call failed", "path=/denied", "status=429", "gcp_status=RESOURCE_EXHAUSTED", "Quota exceeded", "RATE_LIMIT_EXCEEDED"} {
+		if !strings.Contains(logged, expected) {
+			t.Errorf("expected failed call log to contain %q, got %q", expected, logged)
+		}
+	}
+
+	logged = get(gcpLogLevelDebug, "/keys")
+	for _, expected := range []string{"[DEBUG]", "method=GET", "path=/keys", "status=200", "latency="} {
+		if !strings.Contains(logged, expected) {
+			t.Errorf("expected debug log to contain %q, got %q", expected, logged)
+		}
+	}
+	for _, secret := range []string{"tkn", "c2VjcmV0LWtleQ"} {
+		if strings.Contains(logged, secret) {
+			t.Errorf("expected debug log not to contain %q, got %q", secret, logged)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package iamutil
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -42,6 +43,72 @@ func (h *ApiHandle) DoSetRequest(ctx context.Context, r Resource, data io.Reader
 	return h.doRequest(ctx, req, out)
 }
 
+// DoTestPermissionsRequest calls testIamPermissions on the resource with the
+// given permissions and returns the subset the caller has.
+func (h *ApiHandle) DoTestPermissionsRequest(ctx context.Context, r Resource, permissions []string) ([]string, error) {
+	config := r.GetConfig()
+	if !SupportsTestPermissions(r) {
+		return nil, fmt.Errorf("resource type %q does not support testing IAM permissions", config.TypeKey)
+	}
+	method := RestMethod{
+		HttpMethod: http.MethodPost,
+		BaseURL:    config.SetMethod.BaseURL,
+		Path:       strings.Replace(config.SetMethod.Path, "setIamPolicy", "testIamPermissions", 1),
+	}
+
+	data, err := json.Marshal(map[string][]string{"permissions": permissions})
+	if err != nil {
+		return nil, err
+	}
+	req, err := constructRequest(r, &method, bytes.NewReader(data))
+	if err != nil {
+		return nil, errwrap.Wrapf("Unable to construct TestIamPermissions request: {{err}}", err)
+	}
+
+	var out struct {
+		Permissions []string `json:"permissions"`
+	}
+	if err := h.doRequest(ctx, req, &out); err != nil {
+		return nil, err
+	}
+	return out.Permissions, nil
+}
+
+// SupportsTestPermissions returns whether DoTestPermissionsRequest can be
+// used on the resource, which is the case for resources whose IAM policy is
+// set with a setIamPolicy method.
+func SupportsTestPermissions(r Resource) bool {
+	return strings.Contains(r.GetConfig().SetMethod.Path, "setIamPolicy")
+}
+
+// permissionServices maps the services whose IAM permissions are not
+// prefixed with the service's API name to the prefix they use.
+var permissionServices = map[string]string{
+	"bigtableadmin":        "bigtable",
+	"cloudbilling":         "billing",
+	"cloudresourcemanager": "resourcemanager",
+}
+
+// permissionCollections maps the collections whose IAM permissions are not
+// named after the collection to the name they use.
+var permissionCollections = map[string]string{
+	"billingAccounts": "accounts",
+}
+
+// SetIamPolicyPermission returns the IAM permission needed to set the IAM
+// policy of the resource, such as "pubsub.topics.setIamPolicy".
+func SetIamPolicyPermission(r Resource) string {
+	config := r.GetConfig()
+	service, collection := config.Service, config.Name
+	if s, ok := permissionServices[service]; ok {
+		service = s
+	}
+	if c, ok := permissionCollections[collection]; ok {
+		collection = c
+	}
+	return fmt.Sprintf("%s.%s.setIamPolicy", service, collection)
+}
+
 func (h *ApiHandle) doRequest(ctx context.Context, req *http.Request, out interface{}) error {
 	if req.Header == nil {
 		req.Header = make(http.Header)
@@ -52,11 +119,19 @@ func (h *ApiHandle) doRequest(ctx context.Context, req *http.Request, out interf
 
 	resp, err := h.c.Do(req.WithContext(ctx))
 	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return errwrap.Wrapf("request aborted: {{err}}", ctxErr)
+		}
 		return err
 	}
 	defer googleapi.CloseBody(resp)
 
 	if err := googleapi.CheckResponse(resp); err != nil {
+		// CheckResponse drops the headers of JSON errors, which carry the
+		// request ID needed to trace the failure.
+		if gErr, ok := err.(*googleapi.Error); ok && gErr.Header == nil {
+			gErr.Header = resp.Header
+		}
 		return err
 	}
 
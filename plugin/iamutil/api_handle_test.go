@@ -3,7 +3,9 @@ package iamutil
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
@@ -114,3 +116,93 @@ func verifyIamResource_GetSetPolicy(t *testing.T, resourceType string,
 	}
 	t.Fatal("could not find added in new policy, set unsuccessful")
 }
+
+type blockingTransport struct{}
+
+func (blockingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	<-req.Context().Done()
+	return nil, req.Context().Err()
+}
+
+func TestApiHandle_ContextCancelled(t *testing.T) {
+	relId, err := gcputil.ParseRelativeName("projects/p/serviceAccounts/sa@p.iam.gserviceaccount.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rConfig := generatedResources["projects/serviceAccounts"]["iam"]["v1"]
+	r := &IamResource{
+		relativeId: relId,
+		config:     &rConfig,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	h := GetApiHandle(&http.Client{Transport: blockingTransport{}}, "")
+	var out map[string]interface{}
+	err = h.DoGetRequest(ctx, r, &out)
+	if err == nil {
+		t.Fatal("expected error from cancelled request")
+	}
+	if !strings.Contains(err.Error(), "request aborted") {
+		t.Errorf("expected request aborted error, got: %v", err)
+	}
+}
+
+type testPermissionsTransport struct {
+	t *testing.T
+}
+
+func (tr testPermissionsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !strings.HasSuffix(req.URL.Path, ":testIamPermissions") {
+		tr.t.Errorf("unexpected request path %q", req.URL.Path)
+	}
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(`{"permissions": ["iam.serviceAccounts.get"]}`)),
+	}, nil
+}
+
+func TestApiHandle_TestPermissions(t *testing.T) {
+	relId, err := gcputil.ParseRelativeName("projects/p/serviceAccounts/sa@p.iam.gserviceaccount.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rConfig := generatedResources["projects/serviceAccounts"]["iam"]["v1"]
+	r := &IamResource{
+		relativeId: relId,
+		config:     &rConfig,
+	}
+
+	h := GetApiHandle(&http.Client{Transport: testPermissionsTransport{t: t}}, "")
+	granted, err := h.DoTestPermissionsRequest(context.Background(), r, []string{"iam.serviceAccounts.get", "iam.serviceAccounts.delete"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(granted) != 1 || granted[0] != "iam.serviceAccounts.get" {
+		t.Errorf("expected only iam.serviceAccounts.get to be granted, got %v", granted)
+	}
+}
+
+func TestSetIamPolicyPermission(t *testing.T) {
+	cases := map[string]string{
+		"projects":                 "resourcemanager.projects.setIamPolicy",
+		"projects/topics":          "pubsub.topics.setIamPolicy",
+		"projects/serviceAccounts": "iam.serviceAccounts.setIamPolicy",
+		"billingAccounts":          "billing.accounts.setIamPolicy",
+	}
+	for typeKey, expected := range cases {
+		if len(generatedResources[typeKey]) == 0 {
+			t.Fatalf("no generated resources of type %q", typeKey)
+		}
+		for _, versions := range generatedResources[typeKey] {
+			for _, rConfig := range versions {
+				rConfig := rConfig
+				if perm := SetIamPolicyPermission(&IamResource{config: &rConfig}); perm != expected {
+					t.Errorf("%s: expected %q, got %q", typeKey, expected, perm)
+				}
+			}
+		}
+	}
+}
@@ -107,3 +107,87 @@ func (p *Policy) ChangedBindings(toAdd *PolicyDelta, toRemove *PolicyDelta) (cha
 	}
 	return false, p
 }
+
+// HasMember returns whether the policy grants role to member without a
+// condition.
+func (p *Policy) HasMember(role, member string) bool {
+	for _, bind := range p.Bindings {
+		if bind.Role != role || bind.Condition != nil {
+			continue
+		}
+		for _, m := range bind.Members {
+			if m == member {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AddMembers grants each role in roleMembers, a map of role to members, to
+// its members. Conditional bindings are left as they are.
+func (p *Policy) AddMembers(roleMembers map[string]util.StringSet) (changed bool, updated *Policy) {
+	return p.changeMembers(roleMembers, true)
+}
+
+// RemoveMembers revokes each role in roleMembers, a map of role to members,
+// from its members. Conditional bindings are left as they are.
+func (p *Policy) RemoveMembers(roleMembers map[string]util.StringSet) (changed bool, updated *Policy) {
+	return p.changeMembers(roleMembers, false)
+}
+
+func (p *Policy) changeMembers(roleMembers map[string]util.StringSet, add bool) (bool, *Policy) {
+	if len(roleMembers) == 0 {
+		return false, p
+	}
+
+	changed := false
+	seen := make(util.StringSet)
+	newBindings := make([]*Binding, 0, len(p.Bindings)+len(roleMembers))
+	for _, bind := range p.Bindings {
+		members, ok := roleMembers[bind.Role]
+		if !ok || bind.Condition != nil {
+			newBindings = append(newBindings, bind)
+			continue
+		}
+		seen.Add(bind.Role)
+
+		memberSet := util.ToSet(bind.Members)
+		before := len(memberSet)
+		if add {
+			memberSet = memberSet.Union(members)
+		} else {
+			memberSet = memberSet.Sub(members)
+		}
+		if len(memberSet) != before {
+			changed = true
+		}
+		if len(memberSet) > 0 {
+			newBindings = append(newBindings, &Binding{
+				Role:    bind.Role,
+				Members: memberSet.ToSlice(),
+			})
+		}
+	}
+
+	if add {
+		for role, members := range roleMembers {
+			if !seen.Includes(role) && len(members) > 0 {
+				changed = true
+				newBindings = append(newBindings, &Binding{
+					Role:    role,
+					Members: members.ToSlice(),
+				})
+			}
+		}
+	}
+
+	if !changed {
+		return false, p
+	}
+	return true, &Policy{
+		Bindings: newBindings,
+		Etag:     p.Etag,
+		Version:  p.Version,
+	}
+}
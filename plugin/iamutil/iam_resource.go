@@ -41,9 +41,25 @@ func (r *IamResource) SetIamPolicy(ctx context.Context, h *ApiHandle, p *Policy)
 		return nil, fmt.Errorf("request format from generated IAM config invalid JSON: %s", reqJson)
 	}
 
-	var policy Policy
-	if err := h.DoSetRequest(ctx, r, strings.NewReader(reqJson), &policy); err != nil {
+	var respJson json.RawMessage
+	if err := h.DoSetRequest(ctx, r, strings.NewReader(reqJson), &respJson); err != nil {
 		return nil, errwrap.Wrapf("unable to set policy: {{err}}", err)
 	}
+
+	// Some APIs apply the policy asynchronously and return a long-running
+	// operation; wait for it so the policy is in effect once we return.
+	if op, ok := parseOperation(respJson); ok {
+		if respJson, err = h.waitForOperation(ctx, &r.config.SetMethod, op); err != nil {
+			return nil, errwrap.Wrapf("unable to set policy: {{err}}", err)
+		}
+		if len(respJson) == 0 {
+			return r.GetIamPolicy(ctx, h)
+		}
+	}
+
+	var policy Policy
+	if err := json.Unmarshal(respJson, &policy); err != nil {
+		return nil, errwrap.Wrapf("unable to decode policy: {{err}}", err)
+	}
 	return &policy, nil
 }
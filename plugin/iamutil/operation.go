@@ -0,0 +1,110 @@
+package iamutil
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"google.golang.org/api/googleapi"
+)
+
+var (
+	// operationTimeout bounds how long to wait for a long-running operation
+	// returned by a set request to complete.
+	operationTimeout = 2 * time.Minute
+
+	// operationPollInterval is the initial wait between polls of a
+	// long-running operation, doubled after each poll up to
+	// operationMaxPollInterval.
+	operationPollInterval    = time.Second
+	operationMaxPollInterval = 10 * time.Second
+)
+
+// operation is a google.longrunning.Operation, returned instead of the
+// result by some APIs.
+type operation struct {
+	Name     string          `json:"name"`
+	Done     bool            `json:"done"`
+	Error    *operationError `json:"error"`
+	Response json.RawMessage `json:"response"`
+}
+
+type operationError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// parseOperation returns the long-running operation in a response body, or
+// false if the body is not one.
+func parseOperation(body json.RawMessage) (*operation, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil, false
+	}
+	_, hasDone := fields["done"]
+	_, hasMetadata := fields["metadata"]
+	if _, hasName := fields["name"]; !hasName || !(hasDone || hasMetadata) {
+		return nil, false
+	}
+
+	var op operation
+	if err := json.Unmarshal(body, &op); err != nil || op.Name == "" {
+		return nil, false
+	}
+	return &op, true
+}
+
+// waitForOperation polls the operation until it is done and returns its
+// response. restMethod is the method that returned the operation, used to
+// find the service and API version to poll.
+func (h *ApiHandle) waitForOperation(ctx context.Context, restMethod *RestMethod, op *operation) (json.RawMessage, error) {
+	version := strings.SplitN(restMethod.Path, "/", 2)[0]
+	opURL := googleapi.ResolveRelative(restMethod.BaseURL, version+"/"+op.Name)
+
+	waitCtx, cancel := context.WithTimeout(ctx, operationTimeout)
+	defer cancel()
+
+	// done reports why waitCtx is done: the caller's cancellation or
+	// deadline is returned as is, and only our own timeout is reported as
+	// one.
+	done := func() error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return fmt.Errorf("timed out after %s waiting for operation %q to complete", operationTimeout, op.Name)
+	}
+
+	interval := operationPollInterval
+	for !op.Done {
+		select {
+		case <-waitCtx.Done():
+			return nil, done()
+		case <-time.After(interval):
+		}
+		if interval *= 2; interval > operationMaxPollInterval {
+			interval = operationMaxPollInterval
+		}
+
+		req, err := http.NewRequest(http.MethodGet, opURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		var next operation
+		if err := h.doRequest(waitCtx, req, &next); err != nil {
+			if waitCtx.Err() != nil {
+				return nil, done()
+			}
+			return nil, errwrap.Wrapf(fmt.Sprintf("unable to get status of operation %q: {{err}}", op.Name), err)
+		}
+		op = &next
+	}
+
+	if op.Error != nil {
+		return nil, fmt.Errorf("operation %q failed with code %d: %s", op.Name, op.Error.Code, op.Error.Message)
+	}
+	return op.Response, nil
+}
@@ -0,0 +1,113 @@
+package iamutil
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-gcp-common/gcputil"
+)
+
+func testOperationResource(t *testing.T, h http.Handler) (*IamResource, *ApiHandle) {
+	t.Helper()
+
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	relId, err := gcputil.ParseRelativeName("projects/my-project/widgets/my-widget")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := genericRestResource("widgets", relId.TypeKey)
+	cfg.GetMethod.BaseURL = srv.URL + "/"
+	cfg.SetMethod.BaseURL = srv.URL + "/"
+	return &IamResource{relativeId: relId, config: cfg}, GetApiHandle(srv.Client(), "")
+}
+
+func TestIamResource_SetIamPolicyWaitsForOperation(t *testing.T) {
+	oldInterval := operationPollInterval
+	operationPollInterval = time.Millisecond
+	defer func() { operationPollInterval = oldInterval }()
+
+	polls := 0
+	r, h := testOperationResource(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch req.URL.Path {
+		case "/v1/projects/my-project/widgets/my-widget:setIamPolicy":
+			w.Write([]byte(`{"name": "operations/op1", "metadata": {}}`))
+		case "/v1/operations/op1":
+			if polls++; polls < 3 {
+				w.Write([]byte(`{"name": "operations/op1", "done": false}`))
+				return
+			}
+			w.Write([]byte(`{"name": "operations/op1", "done": true, "response": {"etag": "abc", "bindings": [{"role": "roles/viewer", "members": ["user:me@example.com"]}]}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	p, err := r.SetIamPolicy(context.Background(), h, &Policy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if polls != 3 {
+		t.Errorf("expected operation to be polled until done, got %d polls", polls)
+	}
+	if p.Etag != "abc" || len(p.Bindings) != 1 || p.Bindings[0].Role != "roles/viewer" {
+		t.Errorf("expected policy from operation response, got %+v", p)
+	}
+}
+
+func TestIamResource_SetIamPolicyOperationErrors(t *testing.T) {
+	oldInterval, oldTimeout := operationPollInterval, operationTimeout
+	operationPollInterval, operationTimeout = time.Millisecond, 50*time.Millisecond
+	defer func() { operationPollInterval, operationTimeout = oldInterval, oldTimeout }()
+
+	cases := map[string]struct {
+		op       string
+		expected string
+	}{
+		"failed":    {`{"name": "operations/op1", "done": true, "error": {"code": 7, "message": "denied"}}`, "denied"},
+		"timed out": {`{"name": "operations/op1", "done": false}`, "timed out"},
+	}
+	for name, tc := range cases {
+		r, h := testOperationResource(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if strings.HasSuffix(req.URL.Path, ":setIamPolicy") {
+				w.Write([]byte(`{"name": "operations/op1", "done": false}`))
+				return
+			}
+			fmt.Fprint(w, tc.op)
+		}))
+
+		_, err := r.SetIamPolicy(context.Background(), h, &Policy{})
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("%s: expected error containing %q, got %v", name, tc.expected, err)
+		}
+	}
+}
+
+func TestIamResource_SetIamPolicyOperationCanceled(t *testing.T) {
+	oldInterval := operationPollInterval
+	operationPollInterval = time.Millisecond
+	defer func() { operationPollInterval = oldInterval }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r, h := testOperationResource(t, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if !strings.HasSuffix(req.URL.Path, ":setIamPolicy") {
+			cancel()
+		}
+		w.Write([]byte(`{"name": "operations/op1", "done": false}`))
+	}))
+
+	_, err := r.SetIamPolicy(ctx, h, &Policy{})
+	if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) || strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected the caller's cancellation to be returned, got %v", err)
+	}
+}
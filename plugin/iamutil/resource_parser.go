@@ -80,6 +80,33 @@ func (apis GeneratedResources) GetRestConfig(rawName string, fullName *gcputil.F
 	return nil, fmt.Errorf(resourceParsingErrorTmpl, rawName, errorMultipleServices)
 }
 
+// genericRestResource returns the config for a resource type of a service
+// exposing the standard IAM v1 getIamPolicy and setIamPolicy methods on the
+// resource's relative name, as most Google APIs do.
+func genericRestResource(service, typeKey string) *RestResource {
+	baseURL := fmt.Sprintf("https://%s.googleapis.com/", service)
+	tkns := strings.Split(typeKey, "/")
+	return &RestResource{
+		Name:                      tkns[len(tkns)-1],
+		TypeKey:                   typeKey,
+		Service:                   service,
+		IsPreferredVersion:        true,
+		Parameters:                []string{"resource"},
+		CollectionReplacementKeys: map[string]string{},
+		GetMethod: RestMethod{
+			HttpMethod: "POST",
+			BaseURL:    baseURL,
+			Path:       "v1/{+resource}:getIamPolicy",
+		},
+		SetMethod: RestMethod{
+			HttpMethod:    "POST",
+			BaseURL:       baseURL,
+			Path:          "v1/{+resource}:setIamPolicy",
+			RequestFormat: `{"policy": %s}`,
+		},
+	}
+}
+
 func (apis GeneratedResources) Parse(rawName string) (Resource, error) {
 	rUrl, err := url.Parse(rawName)
 	if err != nil {
@@ -121,7 +148,12 @@ func (apis GeneratedResources) Parse(rawName string) (Resource, error) {
 		},
 		prefix)
 	if err != nil {
-		return nil, err
+		// Full resource names of types we have no generated config for fall
+		// back to the standard IAM v1 methods of the named service.
+		if _, ok := apis[relName.TypeKey][service]; service == "" || ok {
+			return nil, err
+		}
+		cfg = genericRestResource(service, relName.TypeKey)
 	}
 	switch cfg.TypeKey {
 	case "projects/dataset":
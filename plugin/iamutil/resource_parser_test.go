@@ -79,6 +79,35 @@ func TestEnabledIamResources_FullName(t *testing.T) {
 	}
 }
 
+func TestEnabledIamResources_GenericFullName(t *testing.T) {
+	enabledApis := GetEnabledResources()
+
+	cases := map[string]string{
+		"//secretmanager.googleapis.com/projects/my-project/secrets/my-secret":        "https://secretmanager.googleapis.com/v1/projects/my-project/secrets/my-secret:getIamPolicy",
+		"//widgets.googleapis.com/projects/my-project/locations/us/widgets/my-widget": "https://widgets.googleapis.com/v1/projects/my-project/locations/us/widgets/my-widget:getIamPolicy",
+	}
+	for name, expected := range cases {
+		resource, err := enabledApis.Parse(name)
+		if err != nil {
+			t.Errorf("failed to parse %s: %v", name, err)
+			continue
+		}
+		req, err := constructRequest(resource, &resource.GetConfig().GetMethod, nil)
+		if err != nil {
+			t.Errorf("failed to construct request for %s: %v", name, err)
+			continue
+		}
+		if req.URL.String() != expected {
+			t.Errorf("expected get request for %s to %s, got %s", name, expected, req.URL)
+		}
+	}
+
+	// Relative names don't name a service to fall back to.
+	if _, err := enabledApis.Parse("projects/my-project/locations/us/widgets/my-widget"); err == nil {
+		t.Errorf("expected error for relative name of unsupported resource type")
+	}
+}
+
 func constructSelfLink(relName string, cfg RestResource) (string, error) {
 	reqUrl := cfg.GetMethod.BaseURL + cfg.GetMethod.Path
 
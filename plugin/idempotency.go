@@ -0,0 +1,99 @@
+package gcpsecrets
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	idempotencyStoragePrefix = "idempotency"
+
+	// idempotencyTokenTTL is how long a successful role set create can be
+	// replayed with the same idempotency token.
+	idempotencyTokenTTL = 24 * time.Hour
+)
+
+// idempotencyRecord records that a role set was created by a request with the
+// given idempotency token. Only a hash of the token is stored.
+type idempotencyRecord struct {
+	RoleSet    string
+	ExpireTime time.Time
+}
+
+func idempotencyStorageKey(rsName, token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return fmt.Sprintf("%s/%s/%s", idempotencyStoragePrefix, rsName, hex.EncodeToString(sum[:]))
+}
+
+// getIdempotencyRecord returns the unexpired record for the given role set and
+// token, or nil if there is none.
+func getIdempotencyRecord(ctx context.Context, s logical.Storage, rsName, token string) (*idempotencyRecord, error) {
+	entry, err := s.Get(ctx, idempotencyStorageKey(rsName, token))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	rec := &idempotencyRecord{}
+	if err := entry.DecodeJSON(rec); err != nil {
+		return nil, err
+	}
+	if time.Now().After(rec.ExpireTime) {
+		return nil, s.Delete(ctx, entry.Key)
+	}
+	return rec, nil
+}
+
+// putIdempotencyRecord saves a record for the given role set and token, and
+// prunes any expired records for the role set.
+func putIdempotencyRecord(ctx context.Context, s logical.Storage, rsName, token string) error {
+	if err := pruneIdempotencyRecords(ctx, s, rsName, false); err != nil {
+		return err
+	}
+
+	entry, err := logical.StorageEntryJSON(idempotencyStorageKey(rsName, token), &idempotencyRecord{
+		RoleSet:    rsName,
+		ExpireTime: time.Now().Add(idempotencyTokenTTL),
+	})
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// pruneIdempotencyRecords deletes expired idempotency records for the given
+// role set, or all of them if all is true.
+func pruneIdempotencyRecords(ctx context.Context, s logical.Storage, rsName string, all bool) error {
+	prefix := fmt.Sprintf("%s/%s/", idempotencyStoragePrefix, rsName)
+	keys, err := s.List(ctx, prefix)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if !all {
+			entry, err := s.Get(ctx, prefix+k)
+			if err != nil {
+				return err
+			}
+			if entry == nil {
+				continue
+			}
+			rec := &idempotencyRecord{}
+			if err := entry.DecodeJSON(rec); err == nil && time.Now().Before(rec.ExpireTime) {
+				continue
+			}
+		}
+		if err := s.Delete(ctx, prefix+k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,17 @@
+package gcpsecrets
+
+import (
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// checkIssuanceAllowed returns an error response if the role set's
+// require_control_group or allowed_callers forbid issuing a secret for the
+// request, or nil if it may be issued. Every path issuing keys, tokens, signatures or other
+// credentials under a role set calls it, so the role set's conditions hold
+// whichever path is used.
+func (b *backend) checkIssuanceAllowed(req *logical.Request, rs *RoleSet) (*logical.Response, error) {
+	if resp := rs.checkControlGroup(req); resp != nil {
+		return resp, nil
+	}
+	return b.checkAllowedCallers(req, rs)
+}
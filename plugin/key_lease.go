@@ -0,0 +1,186 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	keyLeaseStoragePrefix = "key_lease"
+
+	// Bounds on client-supplied key lease metadata.
+	maxKeyMetadataPairs       = 16
+	maxKeyMetadataKeyLength   = 64
+	maxKeyMetadataValueLength = 256
+)
+
+// keyLease tracks a service account key issued as a secret. Vault's lease
+// table is not visible to the backend, so this is how outstanding keys for a
+// role set are found. Entries are written when a key is issued and removed
+// when its lease is revoked. Vault assigns the lease ID only after the
+// backend returns the secret, so leases are tracked by key name instead.
+type keyLease struct {
+	RoleSet    string
+	KeyName    string
+	IssueTime  time.Time
+	ExpireTime time.Time
+
+	// Metadata is client-supplied metadata given when the key was issued.
+	Metadata map[string]string
+
+	// Scopes are the OAuth scopes the key was said to be intended for when
+	// it was issued. They are informational only.
+	Scopes []string
+
+	// KeyType and KeyAlgorithm are what the key was issued with, and are
+	// used for the keys that replace it on rotation.
+	KeyType      string
+	KeyAlgorithm string
+
+	// Rotations is how many times the key has been replaced, because of the
+	// role set's key_rotation_period or through key/:roleset/rotate, and
+	// LastRotated when it last was.
+	Rotations   int
+	LastRotated time.Time
+
+	// PendingKeyName and PendingKeyData are the key that replaces KeyName on
+	// rotation, until it is returned by the next renewal of the lease.
+	PendingKeyName string
+	PendingKeyData string
+}
+
+// matches returns true if the lease's metadata has every pair in selector.
+func (kl *keyLease) matches(selector map[string]string) bool {
+	for k, v := range selector {
+		if actual, ok := kl.Metadata[k]; !ok || actual != v {
+			return false
+		}
+	}
+	return true
+}
+
+func validateKeyMetadata(metadata map[string]string) error {
+	if len(metadata) > maxKeyMetadataPairs {
+		return fmt.Errorf("at most %d pairs are allowed, got %d", maxKeyMetadataPairs, len(metadata))
+	}
+	for k, v := range metadata {
+		if k == "" {
+			return fmt.Errorf("keys cannot be empty")
+		}
+		if len(k) > maxKeyMetadataKeyLength {
+			return fmt.Errorf("key %q is longer than %d characters", k, maxKeyMetadataKeyLength)
+		}
+		if len(v) > maxKeyMetadataValueLength {
+			return fmt.Errorf("value for key %q is longer than %d characters", k, maxKeyMetadataValueLength)
+		}
+	}
+	return nil
+}
+
+func keyLeaseStorageKey(rsName, keyName string) string {
+	return fmt.Sprintf("%s/%s/%s", keyLeaseStoragePrefix, rsName, path.Base(keyName))
+}
+
+func (kl *keyLease) save(ctx context.Context, s logical.Storage) error {
+	entry, err := logical.StorageEntryJSON(keyLeaseStorageKey(kl.RoleSet, kl.KeyName), kl)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+func getKeyLease(ctx context.Context, s logical.Storage, rsName, keyName string) (*keyLease, error) {
+	entry, err := s.Get(ctx, keyLeaseStorageKey(rsName, keyName))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	kl := &keyLease{}
+	if err := entry.DecodeJSON(kl); err != nil {
+		return nil, err
+	}
+	return kl, nil
+}
+
+func deleteKeyLease(ctx context.Context, s logical.Storage, rsName, keyName string) error {
+	return s.Delete(ctx, keyLeaseStorageKey(rsName, keyName))
+}
+
+// listKeyLeases returns all tracked key leases for the given role set.
+func listKeyLeases(ctx context.Context, s logical.Storage, rsName string) ([]*keyLease, error) {
+	prefix := fmt.Sprintf("%s/%s/", keyLeaseStoragePrefix, rsName)
+	keyIds, err := s.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	leases := make([]*keyLease, 0, len(keyIds))
+	for _, keyId := range keyIds {
+		entry, err := s.Get(ctx, prefix+keyId)
+		if err != nil {
+			return nil, err
+		}
+		if entry == nil {
+			continue
+		}
+
+		kl := &keyLease{}
+		if err := entry.DecodeJSON(kl); err != nil {
+			return nil, err
+		}
+		leases = append(leases, kl)
+	}
+	return leases, nil
+}
+
+// findKeyLease returns the tracked lease of the key with the given full GCP
+// name under any role set, or nil if the key isn't tracked.
+func findKeyLease(ctx context.Context, s logical.Storage, keyName string) (*keyLease, error) {
+	rsNames, err := s.List(ctx, keyLeaseStoragePrefix+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rsName := range rsNames {
+		kl, err := getKeyLease(ctx, s, strings.TrimSuffix(rsName, "/"), keyName)
+		if err != nil {
+			return nil, err
+		}
+		if kl != nil && kl.KeyName == keyName {
+			return kl, nil
+		}
+	}
+	return nil, nil
+}
+
+// countActiveKeyLeases returns the number of tracked key leases across all
+// role sets that have not expired.
+func countActiveKeyLeases(ctx context.Context, s logical.Storage) (int, error) {
+	rsNames, err := s.List(ctx, keyLeaseStoragePrefix+"/")
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	count := 0
+	for _, rsName := range rsNames {
+		leases, err := listKeyLeases(ctx, s, strings.TrimSuffix(rsName, "/"))
+		if err != nil {
+			return 0, err
+		}
+		for _, kl := range leases {
+			if kl.ExpireTime.IsZero() || kl.ExpireTime.After(now) {
+				count++
+			}
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,192 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/iam/v1"
+)
+
+const (
+	// minKeyRotationPeriod is the shortest key_rotation_period allowed. New
+	// keys only reach their holders when leases are renewed, so rotating
+	// more often than leases are renewed would pile up undelivered keys.
+	minKeyRotationPeriod = time.Hour
+
+	// keyRotationOverlap is how long a rotated key stays valid after its
+	// replacement is returned on renewal, so holders can switch over.
+	keyRotationOverlap = time.Hour
+)
+
+// rotationDue returns true if the lease's key is due to be replaced under
+// the given rotation period. Keys whose replacement hasn't been picked up yet
+// and expired leases are never rotated.
+func (kl *keyLease) rotationDue(period time.Duration, now time.Time) bool {
+	if period <= 0 || kl.PendingKeyName != "" {
+		return false
+	}
+	if !kl.ExpireTime.IsZero() && !kl.ExpireTime.After(now) {
+		return false
+	}
+	last := kl.LastRotated
+	if last.IsZero() {
+		last = kl.IssueTime
+	}
+	return !now.Before(last.Add(period))
+}
+
+// rotateDueKeys creates replacement keys for the outstanding key leases of
+// every role set with a key_rotation_period whose keys are due. Failures for
+// one lease don't stop the others from being rotated.
+func (b *backend) rotateDueKeys(ctx context.Context, s logical.Storage) error {
+	// Only the active node of the primary cluster rotates keys. Key leases
+	// are replicated storage, which standbys and performance secondaries
+	// can't write, and rotating there would race the primary.
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby | consts.ReplicationPerformanceSecondary) {
+		return nil
+	}
+
+	rsNames, err := s.List(ctx, fmt.Sprintf("%s/", rolesetStoragePrefix))
+	if err != nil {
+		return err
+	}
+
+	var iamC *iam.Service
+	var merr *multierror.Error
+	now := time.Now()
+	for _, rsName := range rsNames {
+		rs, err := getRoleSet(rsName, ctx, s)
+		if err != nil {
+			merr = multierror.Append(merr, err)
+			continue
+		}
+		if rs == nil || rs.SecretType != SecretTypeKey || rs.KeyRotationPeriod <= 0 || rs.AccountId == nil {
+			continue
+		}
+
+		leases, err := listKeyLeases(ctx, s, rsName)
+		if err != nil {
+			merr = multierror.Append(merr, errwrap.Wrapf(fmt.Sprintf("unable to list key leases of role set '%s': {{err}}", rsName), err))
+			continue
+		}
+		for _, kl := range leases {
+			if !kl.rotationDue(rs.KeyRotationPeriod, now) {
+				continue
+			}
+			if iamC == nil {
+				if iamC, err = b.IAMAdminClient(s); err != nil {
+					return multierror.Append(merr, err)
+				}
+			}
+			if err := b.rotateLeaseKey(ctx, s, iamC, rs, kl); err != nil {
+				merr = multierror.Append(merr, errwrap.Wrapf(fmt.Sprintf("unable to rotate key %q: {{err}}", kl.KeyName), err))
+				continue
+			}
+			b.Logger().Info("rotated service account key", "roleset", rsName, "key_name", kl.KeyName, "new_key_name", kl.PendingKeyName)
+		}
+	}
+	return merr.ErrorOrNil()
+}
+
+// rotateLeaseKey creates the key that replaces the lease's key and records
+// it as pending, to be returned on the lease's next renewal.
+func (b *backend) rotateLeaseKey(ctx context.Context, s logical.Storage, iamC *iam.Service, rs *RoleSet, kl *keyLease) error {
+	// Leases saved before these were recorded were issued with the defaults.
+	if kl.KeyType == "" {
+		kl.KeyType = privateKeyTypeJson
+	}
+	if kl.KeyAlgorithm == "" {
+		kl.KeyAlgorithm = keyAlgorithmRSA2k
+	}
+
+	key, err := b.createValidKey(ctx, iamC, rs, kl.KeyType, kl.KeyAlgorithm)
+	if err != nil {
+		return err
+	}
+
+	kl.PendingKeyName = key.Name
+	kl.PendingKeyData = key.PrivateKeyData
+	kl.Rotations++
+	kl.LastRotated = time.Now().UTC()
+	if err := kl.save(ctx, s); err != nil {
+		if _, delErr := iamC.Projects.ServiceAccounts.Keys.Delete(key.Name).Context(ctx).Do(); delErr != nil && !isGoogleAccountKeyNotFoundErr(delErr) {
+			b.Logger().Warn("unable to delete untracked key", "key_name", key.Name, "error", delErr)
+		}
+		return errwrap.Wrapf("unable to save key lease: {{err}}", err)
+	}
+	return nil
+}
+
+// deliverRotatedKey returns the pending replacement key of a renewed lease,
+// if there is one, in the renewal response, and points the lease at it. The
+// old key is deleted after keyRotationOverlap.
+func (b *backend) deliverRotatedKey(ctx context.Context, req *logical.Request, resp *logical.Response) error {
+	rsName, err := secretRoleSetName(ctx, req.Storage, req.Secret)
+	if err != nil || rsName == "" {
+		return err
+	}
+	keyName, ok := req.Secret.InternalData["key_name"].(string)
+	if !ok {
+		return nil
+	}
+
+	kl, err := getKeyLease(ctx, req.Storage, rsName, keyName)
+	if err != nil || kl == nil || kl.PendingKeyName == "" {
+		return err
+	}
+
+	newKl := *kl
+	newKl.KeyName = kl.PendingKeyName
+	newKl.PendingKeyName = ""
+	newKl.PendingKeyData = ""
+	if err := newKl.save(ctx, req.Storage); err != nil {
+		return errwrap.Wrapf("unable to save key lease for rotated key: {{err}}", err)
+	}
+	if err := deleteKeyLease(ctx, req.Storage, rsName, keyName); err != nil {
+		return errwrap.Wrapf("unable to delete key lease for replaced key: {{err}}", err)
+	}
+	if err := deferKeyDeletion(ctx, req.Storage, rsName, keyName, time.Now().Add(keyRotationOverlap)); err != nil {
+		b.Logger().Warn("unable to schedule deletion of replaced key, revoke it with key/revoke-by-name", "key_name", keyName, "error", err)
+	}
+
+	resp.Data = map[string]interface{}{
+		"private_key_data": kl.PendingKeyData,
+		"key_algorithm":    newKl.KeyAlgorithm,
+		"key_type":         newKl.KeyType,
+	}
+	if len(newKl.Scopes) > 0 {
+		resp.Data["scopes"] = newKl.Scopes
+	}
+	setKeyFingerprint(resp)
+	resp.Secret.InternalData["key_name"] = newKl.KeyName
+	resp.Secret.InternalData["key_rotations"] = newKl.Rotations
+	format, _ := req.Secret.InternalData["output_format"].(string)
+	encoding, _ := req.Secret.InternalData["output_encoding"].(string)
+	setKeyOutput(resp, format, encoding)
+	resp.AddWarning(fmt.Sprintf("the key was rotated, use the new key in this response; the old key %q will be deleted in about %s", keyName, keyRotationOverlap))
+	return nil
+}
+
+// deletePendingRotatedKey deletes the replacement key created for the lease
+// by rotation, if it hasn't been returned by a renewal yet.
+func (b *backend) deletePendingRotatedKey(ctx context.Context, s logical.Storage, rsName, keyName string) error {
+	kl, err := getKeyLease(ctx, s, rsName, keyName)
+	if err != nil || kl == nil || kl.PendingKeyName == "" {
+		return err
+	}
+
+	iamC, err := b.IAMAdminClient(s)
+	if err != nil {
+		return err
+	}
+	_, err = iamC.Projects.ServiceAccounts.Keys.Delete(kl.PendingKeyName).Context(ctx).Do()
+	if err != nil && !isGoogleAccountKeyNotFoundErr(err) {
+		return err
+	}
+	return nil
+}
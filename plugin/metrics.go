@@ -0,0 +1,50 @@
+package gcpsecrets
+
+import (
+	"context"
+	"strconv"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+var (
+	metricIssuanceSuccess = []string{"gcp", "issuance", "success"}
+	metricIssuanceFailure = []string{"gcp", "issuance", "failure"}
+)
+
+// recordIssuance counts the issuance of a secret under the role set, as a
+// failure if err is the error that stopped it. Counters are labeled with the
+// secret type, the role set name unless disable_metrics_roleset_label is set,
+// and for failures the status code of the GCP error behind err. They are
+// emitted through go-metrics and kept in b.counters for config/metrics.
+func (b *backend) recordIssuance(ctx context.Context, s logical.Storage, rs *RoleSet, err error) {
+	labels := []metrics.Label{{Name: "secret_type", Value: rs.SecretType}}
+	if !b.disableMetricsRoleSetLabel(ctx, s) {
+		labels = append(labels, metrics.Label{Name: "roleset", Value: rs.Name})
+	}
+
+	if err == nil {
+		metrics.IncrCounterWithLabels(metricIssuanceSuccess, 1, labels)
+		b.counters.incr(metricIssuanceSuccess, labels)
+		return
+	}
+	labels = append(labels, metrics.Label{Name: "status_code", Value: gcpStatusCode(err)})
+	metrics.IncrCounterWithLabels(metricIssuanceFailure, 1, labels)
+	b.counters.incr(metricIssuanceFailure, labels)
+}
+
+// gcpStatusCode returns the HTTP status code of the GCP API or OAuth2 error
+// behind err, or "unknown" if it didn't come from a GCP response.
+func gcpStatusCode(err error) string {
+	if gErr, ok := errwrap.GetType(err, &googleapi.Error{}).(*googleapi.Error); ok && gErr != nil {
+		return strconv.Itoa(gErr.Code)
+	}
+	if rErr, ok := errwrap.GetType(err, &oauth2.RetrieveError{}).(*oauth2.RetrieveError); ok && rErr != nil && rErr.Response != nil {
+		return strconv.Itoa(rErr.Response.StatusCode)
+	}
+	return "unknown"
+}
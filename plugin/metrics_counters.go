@@ -0,0 +1,118 @@
+package gcpsecrets
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// gcpLatencyBuckets are the upper bounds, in seconds, of the buckets GCP API
+// call latencies are counted in.
+var gcpLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// counterKey identifies a counter by its name and labels, sorted by label
+// name.
+type counterKey struct {
+	name   string
+	labels string
+}
+
+// latencyHistogram counts GCP API call latencies in gcpLatencyBuckets.
+type latencyHistogram struct {
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// pluginCounters keeps the plugin's counters and GCP API call latencies in
+// memory, for config/metrics to serialize for scrape-based monitoring
+// alongside the go-metrics sinks. Like go-metrics counters, they are per
+// Vault node and reset when the plugin restarts.
+type pluginCounters struct {
+	l         sync.Mutex
+	counters  map[counterKey]uint64
+	latencies map[string]*latencyHistogram
+}
+
+func newPluginCounters() *pluginCounters {
+	return &pluginCounters{
+		counters:  make(map[counterKey]uint64),
+		latencies: make(map[string]*latencyHistogram),
+	}
+}
+
+// incr increments the counter with the given name and labels.
+func (c *pluginCounters) incr(name []string, labels []metrics.Label) {
+	key := counterKey{name: strings.Join(name, "_"), labels: formatMetricLabels(labels)}
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.counters[key]++
+}
+
+// observeLatency records the latency of a call to the given GCP service.
+func (c *pluginCounters) observeLatency(service string, d time.Duration) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	h, ok := c.latencies[service]
+	if !ok {
+		h = &latencyHistogram{buckets: make([]uint64, len(gcpLatencyBuckets))}
+		c.latencies[service] = h
+	}
+	seconds := d.Seconds()
+	for i, le := range gcpLatencyBuckets {
+		if seconds <= le {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// formatMetricLabels formats labels as the inside of the braces of a
+// Prometheus sample, sorted by name.
+func formatMetricLabels(labels []metrics.Label) string {
+	sorted := make([]metrics.Label, len(labels))
+	copy(sorted, labels)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	parts := make([]string, 0, len(sorted))
+	for _, l := range sorted {
+		parts = append(parts, fmt.Sprintf(`%s="%s"`, l.Name, metricLabelEscaper.Replace(l.Value)))
+	}
+	return strings.Join(parts, ",")
+}
+
+// metricLabelEscaper escapes label values for the Prometheus text format.
+var metricLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// latencyTransport records the latency of each GCP API call in counters,
+// by service.
+type latencyTransport struct {
+	base     http.RoundTripper
+	counters *pluginCounters
+}
+
+func (t *latencyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	t.counters.observeLatency(gcpServiceName(req.URL.Host), time.Since(start))
+	return resp, err
+}
+
+// gcpServiceName returns the GCP service an API host belongs to, such as
+// "iam" for iam.googleapis.com, or "iamcredentials" for a regional endpoint
+// like iamcredentials.europe-west1.rep.googleapis.com.
+func gcpServiceName(host string) string {
+	if i := strings.Index(host, ".googleapis.com"); i > 0 {
+		host = host[:i]
+		if j := strings.Index(host, "."); j > 0 {
+			return host[:j]
+		}
+	}
+	return host
+}
@@ -0,0 +1,135 @@
+package gcpsecrets
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/googleapi"
+)
+
+func TestGCPStatusCode(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		err      error
+		expected string
+	}{
+		"api error":     {&googleapi.Error{Code: 403}, "403"},
+		"wrapped":       {errwrap.Wrapf("unable to create key: {{err}}", &googleapi.Error{Code: 429}), "429"},
+		"oauth2 error":  {errwrap.Wrapf("got error while creating OAuth2 token: {{err}}", &oauth2.RetrieveError{Response: &http.Response{StatusCode: 400}}), "400"},
+		"non-gcp error": {errors.New("boom"), "unknown"},
+	}
+	for name, tc := range cases {
+		if actual := gcpStatusCode(tc.err); actual != tc.expected {
+			t.Errorf("%s: expected status code %q, got %q", name, tc.expected, actual)
+		}
+	}
+}
+
+func TestRecordIssuance(t *testing.T) {
+	sink := metrics.NewInmemSink(time.Hour, time.Hour)
+	cfg := metrics.DefaultConfig("")
+	cfg.EnableHostname = false
+	cfg.EnableRuntimeMetrics = false
+	if _, err := metrics.NewGlobal(cfg, sink); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+	gb := b.(*backend)
+	rs := &RoleSet{Name: "test-metrics", SecretType: SecretTypeKey}
+
+	gb.recordIssuance(ctx, storage, rs, nil)
+	gb.recordIssuance(ctx, storage, rs, &googleapi.Error{Code: 403})
+	testConfigUpdate(t, b, storage, map[string]interface{}{
+		"disable_metrics_roleset_label": true,
+	})
+	gb.recordIssuance(ctx, storage, rs, nil)
+
+	counters := sink.Data()[0].Counters
+	find := func(name string, labels map[string]string) {
+		t.Helper()
+		for _, c := range counters {
+			cLabels := map[string]string{}
+			for _, l := range c.Labels {
+				cLabels[l.Name] = l.Value
+			}
+			if c.Name == name && reflect.DeepEqual(cLabels, labels) {
+				if c.Count != 1 {
+					t.Fatalf("expected %s %v to be counted once, got %d", name, labels, c.Count)
+				}
+				return
+			}
+		}
+		t.Fatalf("counter %s %v not found in %v", name, labels, counters)
+	}
+	find("gcp.issuance.success", map[string]string{"secret_type": SecretTypeKey, "roleset": rs.Name})
+	find("gcp.issuance.failure", map[string]string{"secret_type": SecretTypeKey, "roleset": rs.Name, "status_code": "403"})
+	find("gcp.issuance.success", map[string]string{"secret_type": SecretTypeKey})
+}
+
+func TestPathConfigMetrics(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+	gb := b.(*backend)
+
+	rs := &RoleSet{Name: "test-\"metrics\"", SecretType: SecretTypeAccessToken}
+	gb.recordIssuance(ctx, storage, rs, nil)
+	gb.recordIssuance(ctx, storage, rs, nil)
+	gb.recordIssuance(ctx, storage, rs, &googleapi.Error{Code: 429})
+	gb.counters.observeLatency(gcpServiceName("iam.googleapis.com"), 200*time.Millisecond)
+	for _, path := range []string{"key_lease/rs1/key1", "key_lease/rs1/key2", "key_lease/rs2/key1"} {
+		if err := storage.Put(ctx, &logical.StorageEntry{Key: path, Value: []byte("{}")}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config/metrics",
+		Storage:   storage,
+	})
+	if err != nil || resp.IsError() {
+		t.Fatalf("unable to read metrics: %v, %#v", err, resp)
+	}
+	if resp.Data[logical.HTTPContentType] != "text/plain; version=0.0.4" {
+		t.Fatalf("expected a Prometheus text response, got %v", resp.Data[logical.HTTPContentType])
+	}
+
+	expected := `# TYPE vault_gcp_issuance_failure_total counter
+vault_gcp_issuance_failure_total{roleset="test-\"metrics\"",secret_type="access_token",status_code="429"} 1
+# TYPE vault_gcp_issuance_success_total counter
+vault_gcp_issuance_success_total{roleset="test-\"metrics\"",secret_type="access_token"} 2
+# TYPE vault_gcp_key_leases gauge
+vault_gcp_key_leases{roleset="rs1"} 2
+vault_gcp_key_leases{roleset="rs2"} 1
+# TYPE vault_gcp_api_request_duration_seconds histogram
+vault_gcp_api_request_duration_seconds_bucket{service="iam",le="0.05"} 0
+vault_gcp_api_request_duration_seconds_bucket{service="iam",le="0.1"} 0
+vault_gcp_api_request_duration_seconds_bucket{service="iam",le="0.25"} 1
+vault_gcp_api_request_duration_seconds_bucket{service="iam",le="0.5"} 1
+vault_gcp_api_request_duration_seconds_bucket{service="iam",le="1"} 1
+vault_gcp_api_request_duration_seconds_bucket{service="iam",le="2.5"} 1
+vault_gcp_api_request_duration_seconds_bucket{service="iam",le="5"} 1
+vault_gcp_api_request_duration_seconds_bucket{service="iam",le="10"} 1
+vault_gcp_api_request_duration_seconds_bucket{service="iam",le="30"} 1
+vault_gcp_api_request_duration_seconds_bucket{service="iam",le="60"} 1
+vault_gcp_api_request_duration_seconds_bucket{service="iam",le="+Inf"} 1
+vault_gcp_api_request_duration_seconds_sum{service="iam"} 0.2
+vault_gcp_api_request_duration_seconds_count{service="iam"} 1
+`
+	if body := string(resp.Data[logical.HTTPRawBody].([]byte)); body != expected {
+		t.Fatalf("expected metrics:\n%s\ngot:\n%s", expected, body)
+	}
+}
@@ -0,0 +1,175 @@
+package gcpsecrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-gcp-common/gcputil"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	constraintAllowedPolicyMemberDomains       = "constraints/iam.allowedPolicyMemberDomains"
+	constraintDisableServiceAccountKeyCreation = "constraints/iam.disableServiceAccountKeyCreation"
+
+	// permissionCreateKeys is the permission needed to create service
+	// account keys.
+	permissionCreateKeys = "iam.serviceAccountKeys.create"
+)
+
+// bindingProjectRegex finds the project a bound resource belongs to in its
+// resource name. "_", used by some APIs for any project, doesn't match.
+var bindingProjectRegex = regexp.MustCompile(`(?:^|/)projects/([a-z][-a-z0-9]{4,28}[a-z0-9])(?:/|$)`)
+
+// checkOrgPolicies does a best-effort check of the role set against the org
+// policies in effect on its service account's project and the projects of
+// its bound resources, to catch obvious violations before GCP rejects the
+// IAM policy changes. Violations are returned as an error; policies that
+// couldn't be read are returned as warnings.
+func (b *backend) checkOrgPolicies(ctx context.Context, s logical.Storage, rs *RoleSet, project string, bindings ResourceBindings) ([]string, error) {
+	crmC, err := b.ResourceManagerClient(s)
+	if err != nil {
+		return []string{fmt.Sprintf("unable to check org policies: %v", err)}, nil
+	}
+
+	var warnings []string
+	var merr *multierror.Error
+	getPolicy := func(project, constraint string) *cloudresourcemanager.OrgPolicy {
+		p, err := crmC.Projects.GetEffectiveOrgPolicy("projects/"+project, &cloudresourcemanager.GetEffectiveOrgPolicyRequest{
+			Constraint: constraint,
+		}).Context(ctx).Do()
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("unable to check org policy %s on project %q: %v", constraint, project, err))
+			return nil
+		}
+		return p
+	}
+
+	// Access token role sets use a key to generate tokens, so both key and
+	// access token role sets need to be able to create keys.
+	if rs.SecretType == SecretTypeKey || rs.SecretType == SecretTypeAccessToken {
+		if p := getPolicy(project, constraintDisableServiceAccountKeyCreation); p != nil && p.BooleanPolicy != nil && p.BooleanPolicy.Enforced {
+			merr = multierror.Append(merr, fmt.Errorf("project %q enforces %s, so keys for '%s' role sets can't be created", project, constraintDisableServiceAccountKeyCreation, rs.SecretType))
+		}
+	}
+
+	for _, p := range policyProjects(project, bindings) {
+		policy := getPolicy(p, constraintAllowedPolicyMemberDomains)
+		if policy == nil || policy.ListPolicy == nil {
+			continue
+		}
+		lp := policy.ListPolicy
+		switch {
+		case lp.AllValues == "DENY":
+			merr = multierror.Append(merr, fmt.Errorf("project %q denies all members through %s, so no bindings can be added to its resources", p, constraintAllowedPolicyMemberDomains))
+			continue
+		case lp.AllValues == "ALLOW" || len(lp.AllowedValues) == 0:
+			continue
+		}
+
+		// Token creators are bound on the service account, in its project.
+		if p != project {
+			continue
+		}
+		var unchecked []string
+		for _, member := range rs.TokenCreators {
+			switch member {
+			case "allUsers", "allAuthenticatedUsers":
+				merr = multierror.Append(merr, fmt.Errorf("project %q restricts members to allowed domains through %s, so token_creators cannot include %q", p, constraintAllowedPolicyMemberDomains, member))
+			default:
+				if !strings.HasPrefix(member, "serviceAccount:") {
+					unchecked = append(unchecked, member)
+				}
+			}
+		}
+		if len(unchecked) > 0 {
+			warnings = append(warnings, fmt.Sprintf("project %q restricts members to the customers %s through %s; make sure token_creators %s belong to them",
+				p, strings.Join(lp.AllowedValues, ", "), constraintAllowedPolicyMemberDomains, strings.Join(unchecked, ", ")))
+		}
+	}
+
+	return warnings, merr.ErrorOrNil()
+}
+
+// policyProjects returns the service account's project and the projects of
+// the bound resources, sorted, without duplicates.
+func policyProjects(project string, bindings ResourceBindings) []string {
+	seen := map[string]bool{project: true}
+	for rName := range bindings {
+		if m := bindingProjectRegex.FindStringSubmatch(rName); m != nil {
+			seen[m[1]] = true
+		}
+	}
+
+	projects := make([]string, 0, len(seen))
+	for p := range seen {
+		projects = append(projects, p)
+	}
+	sort.Strings(projects)
+	return projects
+}
+
+// keyCreationError describes why a key for the service account couldn't be
+// created, telling key creation disabled by an org policy constraint apart
+// from credentials lacking the permission to create keys. Other errors are
+// returned as is; the GCP error can always be retrieved from the result.
+func keyCreationError(account *gcputil.ServiceAccountId, err error) error {
+	gErr, ok := errwrap.GetType(err, &googleapi.Error{}).(*googleapi.Error)
+	if !ok || gErr == nil || account == nil {
+		return err
+	}
+
+	if constraint := keyCreationConstraint(gErr); constraint != "" {
+		return errwrap.Wrap(fmt.Errorf("key creation for service account %s is disabled by the org policy constraint %s in effect on project %q, so neither keys nor access tokens can be issued for it; an org policy administrator must exempt the project from %s (%s)",
+			account.EmailOrId, constraint, account.Project, constraint, gErr.Message), err)
+	}
+	if gErr.Code == http.StatusForbidden && strings.Contains(gErr.Message, permissionCreateKeys) {
+		return errwrap.Wrap(fmt.Errorf("the configured credentials lack the %s permission on service account %s; grant them %s on it or its project (%s)",
+			permissionCreateKeys, account.EmailOrId, serviceAccountKeyAdminRole, gErr.Message), err)
+	}
+	return err
+}
+
+// keyCreationConstraint returns the org policy constraint reported as
+// violated in the google.rpc.PreconditionFailure details of a failed key
+// creation, or "" if it wasn't refused for disabling key creation.
+func keyCreationConstraint(gErr *googleapi.Error) string {
+	var body struct {
+		Error struct {
+			Details []struct {
+				Type       string `json:"@type"`
+				Violations []struct {
+					Type string `json:"type"`
+				} `json:"violations"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(gErr.Body), &body); err == nil {
+		for _, d := range body.Error.Details {
+			if !strings.HasSuffix(d.Type, "google.rpc.PreconditionFailure") {
+				continue
+			}
+			// Managed constraints, such as
+			// constraints/iam.managed.disableServiceAccountKeyCreation,
+			// are named differently but have the same effect.
+			for _, v := range d.Violations {
+				if strings.HasPrefix(v.Type, "constraints/") && strings.HasSuffix(v.Type, "disableServiceAccountKeyCreation") {
+					return v.Type
+				}
+			}
+		}
+	}
+	if strings.Contains(gErr.Message, constraintDisableServiceAccountKeyCreation) {
+		return constraintDisableServiceAccountKeyCreation
+	}
+	return ""
+}
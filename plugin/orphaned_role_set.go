@@ -0,0 +1,121 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/iamutil"
+	"github.com/hashicorp/vault/sdk/helper/useragent"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// Values of key_cleanup_on_delete.
+	keyCleanupRevoke = "revoke"
+	keyCleanupExpire = "expire"
+
+	orphanedRoleSetStoragePrefix = "orphaned_roleset"
+)
+
+// An orphaned role set is one deleted with key_cleanup_on_delete=expire while
+// it had outstanding key leases. Its service account and bindings must outlive
+// it for the keys to keep working, so the role set is kept under
+// orphanedRoleSetStoragePrefix until the last of the leases is revoked, and
+// then cleaned up.
+
+func saveOrphanedRoleSet(ctx context.Context, s logical.Storage, rs *RoleSet) error {
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("%s/%s", orphanedRoleSetStoragePrefix, rs.Name), rs)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+func getOrphanedRoleSet(ctx context.Context, s logical.Storage, rsName string) (*RoleSet, error) {
+	entry, err := s.Get(ctx, fmt.Sprintf("%s/%s", orphanedRoleSetStoragePrefix, rsName))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	rs := &RoleSet{}
+	if err := entry.DecodeJSON(rs); err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// cleanupOrphanedRoleSet deletes the service account and bindings of the
+// orphaned role set with the given name once it has no key leases left. It
+// does nothing if there is no such role set.
+func (b *backend) cleanupOrphanedRoleSet(ctx context.Context, s logical.Storage, rsName string) error {
+	b.rolesetLock.Lock()
+	defer b.rolesetLock.Unlock()
+
+	rs, err := getOrphanedRoleSet(ctx, s, rsName)
+	if err != nil || rs == nil {
+		return err
+	}
+	leases, err := listKeyLeases(ctx, s, rsName)
+	if err != nil {
+		return err
+	}
+	if len(leases) > 0 {
+		return nil
+	}
+
+	disableAccount := b.disableServiceAccountOnDelete(ctx, s)
+	var bindingWals map[string]string
+	if rs.AccountId != nil {
+		if bindingWals, err = putRoleSetAccountWALs(ctx, s, rs, disableAccount, time.Time{}); err != nil {
+			return err
+		}
+	}
+	if err := s.Delete(ctx, fmt.Sprintf("%s/%s", orphanedRoleSetStoragePrefix, rsName)); err != nil {
+		return err
+	}
+	if rs.AccountId == nil {
+		return nil
+	}
+
+	httpC, err := b.HTTPClient(s)
+	if err != nil {
+		return err
+	}
+	iamAdmin, err := b.IAMAdminClient(s)
+	if err != nil {
+		return err
+	}
+	apiHandle := iamutil.GetApiHandle(httpC, useragent.String())
+	warnings, lingering := b.deleteRoleSetAccount(ctx, s, iamAdmin, apiHandle, rs, disableAccount, false, bindingWals)
+	for _, w := range warnings {
+		b.Logger().Warn("problem cleaning up orphaned role set", "roleset", rsName, "warning", w)
+	}
+	if len(lingering) > 0 {
+		b.Logger().Warn("orphaned role set bindings remain, they will be removed by WAL rollback", "roleset", rsName, "resources", lingering)
+	}
+	b.Logger().Info("cleaned up orphaned role set after its last key lease", "roleset", rsName, "service_account", rs.AccountId.ResourceName())
+	return nil
+}
+
+// cleanupOrphanedRoleSets cleans up the orphaned role sets that have no key
+// leases left, in case cleaning up after their last lease failed.
+func (b *backend) cleanupOrphanedRoleSets(ctx context.Context, s logical.Storage) error {
+	rsNames, err := s.List(ctx, orphanedRoleSetStoragePrefix+"/")
+	if err != nil {
+		return err
+	}
+
+	var merr *multierror.Error
+	for _, rsName := range rsNames {
+		if err := b.cleanupOrphanedRoleSet(ctx, s, rsName); err != nil {
+			merr = multierror.Append(merr, errwrap.Wrapf(fmt.Sprintf("unable to clean up orphaned role set '%s': {{err}}", rsName), err))
+		}
+	}
+	return merr.ErrorOrNil()
+}
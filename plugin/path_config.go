@@ -3,9 +3,11 @@ package gcpsecrets
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/go-gcp-common/gcputil"
+	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/util"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 )
@@ -18,6 +20,10 @@ func pathConfig(b *backend) *framework.Path {
 				Type:        framework.TypeString,
 				Description: `GCP IAM service account credentials JSON with permissions to create new service accounts and set IAM policies`,
 			},
+			"fallback_credentials": {
+				Type:        framework.TypeStringSlice,
+				Description: "Ordered list of GCP IAM service account credentials JSON to use, in turn, when the primary credentials can't get an access token, such as while they are being rotated. Replaces any previous list; an empty list removes them.",
+			},
 			"ttl": {
 				Type:        framework.TypeDurationSecond,
 				Description: "Default lease for generated keys. If <= 0, will use system default.",
@@ -26,6 +32,195 @@ func pathConfig(b *backend) *framework.Path {
 				Type:        framework.TypeDurationSecond,
 				Description: "Maximum time a service account key is valid for. If <= 0, will use system default.",
 			},
+			"key_revocation_grace": {
+				Type: framework.TypeDurationSecond,
+				Description: "Time a service account key stays valid in GCP after its lease is revoked, for workloads that cache credentials briefly. " +
+					"The lease is revoked immediately and the key is deleted in the background once the grace period has passed. " +
+					"Defaults to 0, deleting the key on revocation.",
+			},
+			"max_bindings_per_roleset": {
+				Type:        framework.TypeInt,
+				Description: fmt.Sprintf("Maximum number of role bindings (role and resource pairs) a role set may have. Role sets with more are rejected on create and update. Must be positive. Defaults to %d.", defaultMaxBindingsPerRoleSet),
+			},
+			"max_binding_retries": {
+				Type:        framework.TypeInt,
+				Description: fmt.Sprintf("Number of times to retry setting an IAM policy when it fails because the policy was changed concurrently (etag conflict). Must be positive. Defaults to %d.", defaultMaxBindingRetries),
+			},
+			"binding_removal_rate": {
+				Type:        framework.TypeInt,
+				Description: "Maximum number of IAM policy updates per second made to remove the bindings of a deleted or replaced service account. 0, the default, means no limit.",
+			},
+			"service_account_suffix_length": {
+				Type: framework.TypeInt,
+				Description: fmt.Sprintf("Length of the random suffix of generated service account IDs, between %d and %d. "+
+					"The role set name is truncated to fit the %d character limit on IDs. Defaults to %d.",
+					minServiceAccountSuffixLen, maxServiceAccountSuffixLen, serviceAccountMaxLen, defaultServiceAccountSuffixLen),
+			},
+			"service_account_id_attempts": {
+				Type:        framework.TypeInt,
+				Description: fmt.Sprintf("Number of service account IDs to generate and try when creating a role set's service account, if generated IDs are already taken. Must be positive. Defaults to %d.", defaultServiceAccountIdAttempts),
+			},
+			"sa_name_template": {
+				Type: framework.TypeString,
+				Description: "Template for the IDs of service accounts created for role sets, using the variables {{roleset}}, {{random}} and {{project}}. " +
+					"Must include {{random}}, which is service_account_suffix_length random characters. Defaults to generating IDs as \"vault<roleset>-{{random}}\".",
+			},
+			"permission_denied_template": {
+				Type:        framework.TypeString,
+				Description: "Template of the error returned when GCP denies changing the IAM policy of a bound resource, using the variables {{admin_email}}, {{resource}}, {{permission}} and {{error}}, e.g. to add a runbook link. Defaults to a message naming them.",
+			},
+			"reconcile_interval": {
+				Type:        framework.TypeDurationSecond,
+				Description: "How often to re-add role set bindings missing from live IAM policies. Defaults to 0, disabling periodic reconciliation.",
+			},
+			"reap_untracked_keys": {
+				Type:        framework.TypeBool,
+				Description: "If true, periodic reconciliation deletes user-managed keys on role set service accounts that Vault doesn't track and that are older than untracked_key_max_age. Only safe if Vault is the sole manager of those keys. Requires reconcile_interval. Defaults to false.",
+			},
+			"untracked_key_max_age": {
+				Type:        framework.TypeDurationSecond,
+				Description: fmt.Sprintf("Age past which untracked keys are deleted when reap_untracked_keys is set. Must be at least %s when reaping is enabled.", minUntrackedKeyMaxAge),
+			},
+			"max_active_key_leases": {
+				Type:        framework.TypeInt,
+				Description: "Maximum number of active service account key leases across all role sets. Once reached, new keys are refused until leases are revoked or expire. Defaults to 0, meaning no limit.",
+			},
+			"cache_tokens": {
+				Type:        framework.TypeBool,
+				Description: "If true, access tokens are cached and returned again to later requests for the same role set and scopes while they remain valid. Defaults to false.",
+			},
+			"token_expiry_alignment": {
+				Type:        framework.TypeDurationSecond,
+				Description: fmt.Sprintf("If set, the reported expiry of access tokens is rounded down to a multiple of this duration, e.g. 60 for whole minutes. At most %s. Defaults to 0, reporting the exact expiry.", gcpMaxAccessTokenTTL),
+			},
+			"coalesce_token_requests": {
+				Type:        framework.TypeBool,
+				Description: "If true, concurrent requests for an access token of the same role set, scopes and lifetime share a single GCP call and all get the token it returns. Defaults to false.",
+			},
+			"shared_token_cache": {
+				Type:        framework.TypeBool,
+				Description: "If true, cached access tokens are kept in storage, so every node of the cluster returns the same token instead of each generating its own. Requires cache_tokens. Defaults to false.",
+			},
+			"prefetch_tokens": {
+				Type:        framework.TypeBool,
+				Description: "If true, when the backend starts, access tokens are generated in the background for every access token role set and cached, so first requests are fast. Requires cache_tokens. Defaults to false.",
+			},
+			"disable_sa_on_delete": {
+				Type:        framework.TypeBool,
+				Description: "If true, deleting a role set disables its service account and removes its bindings and keys instead of deleting the account, keeping it for audit log attribution. Defaults to false.",
+			},
+			"reject_disabled_sa_keys": {
+				Type:        framework.TypeBool,
+				Description: "If true, issuing a key for a role set whose service account is disabled fails, rather than returning a key that can't authenticate. Defaults to true.",
+			},
+			"sa_deletion_delay": {
+				Type:        framework.TypeDurationSecond,
+				Description: "How long after a role set is deleted its service account is deleted, or disabled, during which undelete-roleset/:name restores the role set. Bindings are removed immediately. Defaults to 0 (the account is removed immediately).",
+			},
+			"disable_metrics_roleset_label": {
+				Type:        framework.TypeBool,
+				Description: "If true, the gcp.issuance.success and gcp.issuance.failure metrics are not labeled with the role set name, to limit their cardinality. Defaults to false.",
+			},
+			"warn_broad_scopes": {
+				Type:        framework.TypeBool,
+				Description: fmt.Sprintf("If true, writing a role set whose token_scopes include %q returns a warning. Defaults to false.", cloudPlatformScope),
+			},
+			"require_narrow_scopes": {
+				Type:        framework.TypeBool,
+				Description: fmt.Sprintf("If true, role sets whose token_scopes include %q are rejected. Defaults to false.", cloudPlatformScope),
+			},
+			"required_scopes": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "OAuth scopes added to every token generated for access token role sets, on top of their token_scopes, such as a scope services need to identify the caller. This broadens every token, so keep it to scopes all callers need.",
+			},
+			"warn_unmatched_scopes": {
+				Type:        framework.TypeBool,
+				Description: "If true, writing an access token role set whose token_scopes are for services none of its bound roles are for returns a warning. Best effort; only predefined roles are matched to scopes. Defaults to false.",
+			},
+			"deny_self_escalating_bindings": {
+				Type:        framework.TypeBool,
+				Description: fmt.Sprintf("If true, role sets whose bindings grant their own service account %s or %s on itself are rejected instead of getting a warning. Defaults to false.", serviceAccountKeyAdminRole, serviceAccountTokenCreatorRole),
+			},
+			"default_secret_type": {
+				Type:        framework.TypeString,
+				Description: fmt.Sprintf("Type of secret generated by role sets created without a secret_type. One of '%s', '%s' or '%s'. Defaults to '%s'.", SecretTypeAccessToken, SecretTypeKey, SecretTypeHMACKey, SecretTypeAccessToken),
+			},
+			"empty_scopes_behavior": {
+				Type:        framework.TypeString,
+				Description: fmt.Sprintf(`What to do with access token role sets without token_scopes. %q (the default) rejects them, %q gives them the %q scope with a warning.`, emptyScopesReject, emptyScopesDefaultCloudPlatform, cloudPlatformScope),
+			},
+			"api_timeout": {
+				Type:        framework.TypeDurationSecond,
+				Description: fmt.Sprintf("Deadline for each GCP API call, so slow responses fail fast. Must be positive. Defaults to %s.", defaultAPITimeout),
+			},
+			"gcp_log_level": {
+				Type:        framework.TypeString,
+				Description: fmt.Sprintf("Which GCP API calls are logged: %q (the default) logs none, %q logs failed calls with the GCP error, and %q also logs successful calls. Calls are logged with their method, path, status and latency, never their tokens or keys.", gcpLogLevelOff, gcpLogLevelError, gcpLogLevelDebug),
+			},
+			"auto_enable_apis": {
+				Type:        framework.TypeBool,
+				Description: "If true, a GCP call failing because its API is disabled in the project enables the API with the Service Usage API and is retried once. Needs serviceusage.services.enable on the project. Defaults to false.",
+			},
+			"sa_fallback_projects": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Project IDs to create role set service accounts in, in order, when the role set's project has reached its service account quota. Bindings are unaffected. Replaces any previous list; an empty list removes them.",
+			},
+			"project_denylist": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Project IDs role sets may not use, either for their service account or in their bindings. Role sets using them are rejected on create and update.",
+			},
+			"project_allowlist": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "If set, the only project IDs role sets may use, either for their service account or in their bindings. Role sets using other projects are rejected on create and update.",
+			},
+			"wildcard_project_parent": {
+				Type:        framework.TypeString,
+				Description: `Folder or organization, as "folders/<id>" or "organizations/<id>", whose projects role set bindings can name with a wildcard, e.g. "projects/team-a-*". Wildcards are rejected while unset.`,
+			},
+			"key_cleanup_on_delete": {
+				Type:        framework.TypeString,
+				Description: fmt.Sprintf(`What to do with the keys of active leases when a role set is deleted with "force". %q (the default) deletes them; %q leaves them valid until their leases are revoked or expire, keeping the service account until then.`, keyCleanupRevoke, keyCleanupExpire),
+			},
+			"http_proxy": {
+				Type:        framework.TypeString,
+				Description: "URL of the proxy for plain HTTP requests to GCP. Defaults to the HTTP_PROXY environment variable of the Vault server.",
+			},
+			"https_proxy": {
+				Type:        framework.TypeString,
+				Description: "URL of the proxy for HTTPS requests to GCP, which includes all API calls and token fetches. Falls back to http_proxy if only that is set. Defaults to the HTTPS_PROXY environment variable of the Vault server.",
+			},
+			"credentials_endpoint": {
+				Type:        framework.TypeString,
+				Description: fmt.Sprintf("HTTPS URL of the IAM Credentials API endpoint used to generate tokens and signatures for role set service accounts, such as a regional endpoint. Other GCP APIs are unaffected. Defaults to the global endpoint, %s.", defaultCredentialsEndpoint),
+			},
+			"binding_approval_webhook": {
+				Type:        framework.TypeString,
+				Description: "URL the proposed bindings of role set creates and updates are POSTed to for approval before they are applied. A non-2xx response or a JSON body with \"allow\": false rejects the write. Unset by default.",
+			},
+			"binding_approval_timeout": {
+				Type:        framework.TypeDurationSecond,
+				Description: fmt.Sprintf("Deadline for each call to binding_approval_webhook. Must be positive. Defaults to %s.", defaultBindingApprovalTimeout),
+			},
+			"binding_approval_fail_open": {
+				Type:        framework.TypeBool,
+				Description: "If true, bindings are applied with a warning when binding_approval_webhook can't be called, such as on a timeout. Defaults to false, rejecting the write.",
+			},
+			"revocation_policy": {
+				Type: framework.TypeString,
+				Description: fmt.Sprintf(`How to handle a failure to delete a service account key on lease revocation. `+
+					`With %q (the default), revocation fails and Vault retries it until the key is deleted. `+
+					`With %q, the failure is logged, the lease is revoked and deletion is retried later in the background. `+
+					`This keeps leases from getting stuck while GCP is unreachable, at the cost of the key remaining valid `+
+					`in GCP for some time after Vault reports it revoked.`, revocationPolicyStrict, revocationPolicyBestEffort),
+			},
+			"renew_past_max_ttl": {
+				Type:        framework.TypeString,
+				Description: fmt.Sprintf(`What to do when renewing a key lease would take it past its max TTL. %q (the default) renews it up to the max TTL; %q refuses the renewal, telling the client to request a new key.`, renewPastMaxTTLClamp, renewPastMaxTTLError),
+			},
+			"cap_ttl_to_token": {
+				Type:        framework.TypeBool,
+				Description: "If true, the leases of keys and HMAC keys, and the lifetime of access tokens, are capped to the remaining TTL of the Vault token requesting them, with a warning when shortened. Defaults to false.",
+			},
 		},
 
 		Operations: map[logical.Operation]framework.OperationHandler{
@@ -51,10 +246,72 @@ func (b *backend) pathConfigRead(ctx context.Context, req *logical.Request, data
 		return nil, nil
 	}
 
+	effectiveTTL, ttlSource := cfg.TTL, ttlSourceConfig
+	if effectiveTTL <= 0 {
+		effectiveTTL, ttlSource = b.System().DefaultLeaseTTL(), ttlSourceMount
+	}
+	effectiveMaxTTL, maxTTLSource := cfg.MaxTTL, ttlSourceConfig
+	if effectiveMaxTTL <= 0 {
+		effectiveMaxTTL, maxTTLSource = b.System().MaxLeaseTTL(), ttlSourceMount
+	}
+	if effectiveMaxTTL > 0 && effectiveTTL > effectiveMaxTTL {
+		effectiveTTL = effectiveMaxTTL
+	}
+
 	return &logical.Response{
 		Data: map[string]interface{}{
-			"ttl":     int64(cfg.TTL / time.Second),
-			"max_ttl": int64(cfg.MaxTTL / time.Second),
+			"ttl":                           int64(cfg.TTL / time.Second),
+			"max_ttl":                       int64(cfg.MaxTTL / time.Second),
+			"effective_ttl":                 int64(effectiveTTL / time.Second),
+			"effective_max_ttl":             int64(effectiveMaxTTL / time.Second),
+			"ttl_source":                    ttlSource,
+			"max_ttl_source":                maxTTLSource,
+			"revocation_policy":             cfg.revocationPolicy(),
+			"renew_past_max_ttl":            cfg.renewPastMaxTTL(),
+			"cap_ttl_to_token":              cfg.CapTTLToToken,
+			"key_revocation_grace":          int64(cfg.KeyRevocationGrace / time.Second),
+			"max_binding_retries":           cfg.maxBindingRetries(),
+			"max_bindings_per_roleset":      cfg.maxBindingsPerRoleSet(),
+			"binding_removal_rate":          cfg.BindingRemovalRate,
+			"service_account_suffix_length": cfg.serviceAccountSuffixLength(),
+			"service_account_id_attempts":   cfg.serviceAccountIdAttempts(),
+			"sa_name_template":              cfg.ServiceAccountNameTemplate,
+			"permission_denied_template":    cfg.permissionDeniedTemplate(),
+			"warn_broad_scopes":             cfg.WarnBroadScopes,
+			"require_narrow_scopes":         cfg.RequireNarrowScopes,
+			"empty_scopes_behavior":         cfg.emptyScopesBehavior(),
+			"required_scopes":               cfg.RequiredScopes,
+			"default_secret_type":           cfg.defaultSecretType(),
+			"deny_self_escalating_bindings": cfg.DenySelfEscalatingBindings,
+			"warn_unmatched_scopes":         cfg.WarnUnmatchedScopes,
+			"reconcile_interval":            int64(cfg.ReconcileInterval / time.Second),
+			"reap_untracked_keys":           cfg.ReapUntrackedKeys,
+			"untracked_key_max_age":         int64(cfg.UntrackedKeyMaxAge / time.Second),
+			"max_active_key_leases":         cfg.maxActiveKeyLeases(),
+			"cache_tokens":                  cfg.CacheTokens,
+			"prefetch_tokens":               cfg.PrefetchTokens,
+			"shared_token_cache":            cfg.SharedTokenCache,
+			"coalesce_token_requests":       cfg.CoalesceTokenRequests,
+			"token_expiry_alignment":        int64(cfg.TokenExpiryAlignment / time.Second),
+			"disable_sa_on_delete":          cfg.DisableServiceAccountOnDelete,
+			"sa_deletion_delay":             int64(cfg.ServiceAccountDeletionDelay / time.Second),
+			"reject_disabled_sa_keys":       cfg.rejectDisabledServiceAccountKeys(),
+			"disable_metrics_roleset_label": cfg.DisableMetricsRoleSetLabel,
+			"api_timeout":                   int64(cfg.apiTimeout() / time.Second),
+			"auto_enable_apis":              cfg.AutoEnableAPIs,
+			"gcp_log_level":                 cfg.gcpLogLevel(),
+			"sa_fallback_projects":          cfg.ServiceAccountFallbackProjects,
+			"project_denylist":              cfg.ProjectDenylist,
+			"project_allowlist":             cfg.ProjectAllowlist,
+			"wildcard_project_parent":       cfg.WildcardProjectParent,
+			"key_cleanup_on_delete":         cfg.keyCleanupOnDelete(),
+			"http_proxy":                    cfg.HTTPProxy,
+			"binding_approval_webhook":      cfg.BindingApprovalWebhook,
+			"binding_approval_timeout":      int64(cfg.bindingApprovalTimeout() / time.Second),
+			"binding_approval_fail_open":    cfg.BindingApprovalFailOpen,
+			"https_proxy":                   cfg.HTTPSProxy,
+			"credentials_endpoint":          cfg.credentialsEndpoint(),
+			"fallback_client_emails":        cfg.fallbackClientEmails(),
 		},
 	}, nil
 }
@@ -67,6 +324,8 @@ func (b *backend) pathConfigWrite(ctx context.Context, req *logical.Request, dat
 	if cfg == nil {
 		cfg = &config{}
 	}
+	wasSharedTokenCache := cfg.sharedTokenCache()
+	oldCredentials, oldCredentialsEndpoint := cfg.CredentialsRaw, cfg.credentialsEndpoint()
 
 	credentialsRaw, setNewCreds := data.GetOk("credentials")
 	if setNewCreds {
@@ -77,6 +336,20 @@ func (b *backend) pathConfigWrite(ctx context.Context, req *logical.Request, dat
 		cfg.CredentialsRaw = credentialsRaw.(string)
 	}
 
+	fallbackRaw, setFallbackCreds := data.GetOk("fallback_credentials")
+	if setFallbackCreds {
+		fallbacks := fallbackRaw.([]string)
+		for i, raw := range fallbacks {
+			if _, err := gcputil.Credentials(raw); err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("invalid fallback_credentials JSON file at index %d: %v", i, err)), nil
+			}
+		}
+		cfg.FallbackCredentialsRaw = nil
+		if len(fallbacks) > 0 {
+			cfg.FallbackCredentialsRaw = fallbacks
+		}
+	}
+
 	// Update token TTL.
 	ttlRaw, ok := data.GetOk("ttl")
 	if ok {
@@ -89,6 +362,372 @@ func (b *backend) pathConfigWrite(ctx context.Context, req *logical.Request, dat
 		cfg.MaxTTL = time.Duration(maxTTLRaw.(int)) * time.Second
 	}
 
+	graceRaw, ok := data.GetOk("key_revocation_grace")
+	if ok {
+		if graceRaw.(int) < 0 {
+			return logical.ErrorResponse("key_revocation_grace cannot be negative"), nil
+		}
+		cfg.KeyRevocationGrace = time.Duration(graceRaw.(int)) * time.Second
+	}
+
+	retriesRaw, ok := data.GetOk("max_binding_retries")
+	if ok {
+		if retriesRaw.(int) <= 0 {
+			return logical.ErrorResponse("max_binding_retries must be a positive integer"), nil
+		}
+		cfg.MaxBindingRetries = retriesRaw.(int)
+	}
+
+	removalRateRaw, ok := data.GetOk("binding_removal_rate")
+	if ok {
+		if removalRateRaw.(int) < 0 {
+			return logical.ErrorResponse("binding_removal_rate cannot be negative"), nil
+		}
+		cfg.BindingRemovalRate = removalRateRaw.(int)
+	}
+
+	maxBindingsRaw, ok := data.GetOk("max_bindings_per_roleset")
+	if ok {
+		if maxBindingsRaw.(int) <= 0 {
+			return logical.ErrorResponse("max_bindings_per_roleset must be a positive integer"), nil
+		}
+		cfg.MaxBindingsPerRoleSet = maxBindingsRaw.(int)
+	}
+
+	suffixLenRaw, ok := data.GetOk("service_account_suffix_length")
+	if ok {
+		suffixLen := suffixLenRaw.(int)
+		if suffixLen < minServiceAccountSuffixLen || suffixLen > maxServiceAccountSuffixLen {
+			return logical.ErrorResponse(fmt.Sprintf("service_account_suffix_length must be between %d and %d, leaving room for the %q prefix of service account IDs", minServiceAccountSuffixLen, maxServiceAccountSuffixLen, serviceAccountIdPrefix)), nil
+		}
+		cfg.ServiceAccountSuffixLength = suffixLen
+	}
+
+	idAttemptsRaw, ok := data.GetOk("service_account_id_attempts")
+	if ok {
+		idAttempts := idAttemptsRaw.(int)
+		if idAttempts <= 0 {
+			return logical.ErrorResponse("service_account_id_attempts must be a positive integer"), nil
+		}
+		cfg.ServiceAccountIdAttempts = idAttempts
+	}
+
+	nameTmplRaw, ok := data.GetOk("sa_name_template")
+	if ok {
+		cfg.ServiceAccountNameTemplate = strings.TrimSpace(nameTmplRaw.(string))
+	}
+	// The suffix length is part of the rendered ID, so recheck the template
+	// if either changed.
+	if cfg.ServiceAccountNameTemplate != "" {
+		if err := validateServiceAccountNameTemplate(cfg.ServiceAccountNameTemplate, cfg.serviceAccountSuffixLength()); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
+
+	deniedTmplRaw, ok := data.GetOk("permission_denied_template")
+	if ok {
+		tmpl := strings.TrimSpace(deniedTmplRaw.(string))
+		if err := validatePermissionDeniedTemplate(tmpl); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		cfg.PermissionDeniedTemplate = tmpl
+	}
+
+	reconcileRaw, ok := data.GetOk("reconcile_interval")
+	if ok {
+		if reconcileRaw.(int) < 0 {
+			return logical.ErrorResponse("reconcile_interval cannot be negative"), nil
+		}
+		cfg.ReconcileInterval = time.Duration(reconcileRaw.(int)) * time.Second
+	}
+
+	reapRaw, ok := data.GetOk("reap_untracked_keys")
+	if ok {
+		cfg.ReapUntrackedKeys = reapRaw.(bool)
+	}
+	maxAgeRaw, ok := data.GetOk("untracked_key_max_age")
+	if ok {
+		if maxAgeRaw.(int) < 0 {
+			return logical.ErrorResponse("untracked_key_max_age cannot be negative"), nil
+		}
+		cfg.UntrackedKeyMaxAge = time.Duration(maxAgeRaw.(int)) * time.Second
+	}
+	if cfg.ReapUntrackedKeys && cfg.UntrackedKeyMaxAge < minUntrackedKeyMaxAge {
+		return logical.ErrorResponse(fmt.Sprintf("untracked_key_max_age must be at least %s when reap_untracked_keys is set", minUntrackedKeyMaxAge)), nil
+	}
+
+	maxLeasesRaw, ok := data.GetOk("max_active_key_leases")
+	if ok {
+		if maxLeasesRaw.(int) < 0 {
+			return logical.ErrorResponse("max_active_key_leases cannot be negative"), nil
+		}
+		cfg.MaxActiveKeyLeases = maxLeasesRaw.(int)
+	}
+
+	cacheTokensRaw, ok := data.GetOk("cache_tokens")
+	if ok {
+		cfg.CacheTokens = cacheTokensRaw.(bool)
+		if !cfg.CacheTokens {
+			b.tokens.clear()
+		}
+	}
+
+	prefetchRaw, ok := data.GetOk("prefetch_tokens")
+	if ok {
+		cfg.PrefetchTokens = prefetchRaw.(bool)
+	}
+	var warnings []string
+	if cfg.PrefetchTokens && !cfg.CacheTokens {
+		warnings = append(warnings, "prefetch_tokens has no effect unless cache_tokens is also set")
+	}
+	if cfg.ReapUntrackedKeys && cfg.ReconcileInterval <= 0 {
+		warnings = append(warnings, "reap_untracked_keys has no effect unless reconcile_interval is also set")
+	}
+
+	coalesceRaw, ok := data.GetOk("coalesce_token_requests")
+	if ok {
+		cfg.CoalesceTokenRequests = coalesceRaw.(bool)
+	}
+
+	sharedRaw, ok := data.GetOk("shared_token_cache")
+	if ok {
+		cfg.SharedTokenCache = sharedRaw.(bool)
+	}
+	if cfg.SharedTokenCache && !cfg.CacheTokens {
+		warnings = append(warnings, "shared_token_cache has no effect unless cache_tokens is also set")
+	}
+
+	disableRaw, ok := data.GetOk("disable_sa_on_delete")
+	if ok {
+		cfg.DisableServiceAccountOnDelete = disableRaw.(bool)
+	}
+
+	rejectDisabledRaw, ok := data.GetOk("reject_disabled_sa_keys")
+	if ok {
+		cfg.AllowDisabledServiceAccountKeys = !rejectDisabledRaw.(bool)
+	}
+
+	deletionDelayRaw, ok := data.GetOk("sa_deletion_delay")
+	if ok {
+		if deletionDelayRaw.(int) < 0 {
+			return logical.ErrorResponse("sa_deletion_delay cannot be negative"), nil
+		}
+		cfg.ServiceAccountDeletionDelay = time.Duration(deletionDelayRaw.(int)) * time.Second
+	}
+
+	alignmentRaw, ok := data.GetOk("token_expiry_alignment")
+	if ok {
+		alignment := time.Duration(alignmentRaw.(int)) * time.Second
+		if alignment < 0 || alignment > gcpMaxAccessTokenTTL {
+			return logical.ErrorResponse(fmt.Sprintf("token_expiry_alignment must be between 0 and %s", gcpMaxAccessTokenTTL)), nil
+		}
+		cfg.TokenExpiryAlignment = alignment
+	}
+
+	disableLabelRaw, ok := data.GetOk("disable_metrics_roleset_label")
+	if ok {
+		cfg.DisableMetricsRoleSetLabel = disableLabelRaw.(bool)
+	}
+
+	warnScopesRaw, ok := data.GetOk("warn_broad_scopes")
+	if ok {
+		cfg.WarnBroadScopes = warnScopesRaw.(bool)
+	}
+
+	requireScopesRaw, ok := data.GetOk("require_narrow_scopes")
+	if ok {
+		cfg.RequireNarrowScopes = requireScopesRaw.(bool)
+	}
+
+	warnUnmatchedRaw, ok := data.GetOk("warn_unmatched_scopes")
+	if ok {
+		cfg.WarnUnmatchedScopes = warnUnmatchedRaw.(bool)
+	}
+
+	denySelfRaw, ok := data.GetOk("deny_self_escalating_bindings")
+	if ok {
+		cfg.DenySelfEscalatingBindings = denySelfRaw.(bool)
+	}
+
+	secretTypeRaw, ok := data.GetOk("default_secret_type")
+	if ok {
+		switch secretType := secretTypeRaw.(string); secretType {
+		case SecretTypeKey, SecretTypeAccessToken, SecretTypeHMACKey:
+			cfg.DefaultSecretType = secretType
+		default:
+			return logical.ErrorResponse(fmt.Sprintf("invalid default_secret_type %q, must be one of %q, %q or %q", secretType, SecretTypeAccessToken, SecretTypeKey, SecretTypeHMACKey)), nil
+		}
+	}
+
+	emptyScopesRaw, ok := data.GetOk("empty_scopes_behavior")
+	if ok {
+		switch behavior := emptyScopesRaw.(string); behavior {
+		case emptyScopesReject, emptyScopesDefaultCloudPlatform:
+			cfg.EmptyScopesBehavior = behavior
+		default:
+			return logical.ErrorResponse(fmt.Sprintf("invalid empty_scopes_behavior %q, must be one of %q or %q", behavior, emptyScopesReject, emptyScopesDefaultCloudPlatform)), nil
+		}
+	}
+
+	requiredScopesRaw, ok := data.GetOk("required_scopes")
+	if ok {
+		scopes, err := normalizeScopes(requiredScopesRaw.([]string))
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid required_scopes: %v", err)), nil
+		}
+		cfg.RequiredScopes = scopes
+	}
+	if cfg.RequireNarrowScopes && util.ToSet(cfg.RequiredScopes).Includes(cloudPlatformScope) {
+		return logical.ErrorResponse(fmt.Sprintf("required_scopes cannot include %q, require_narrow_scopes is set", cloudPlatformScope)), nil
+	}
+
+	// API calls go through a cached HTTP client, which is rebuilt with the
+	// new timeout.
+	apiTimeoutRaw, newAPITimeout := data.GetOk("api_timeout")
+	if newAPITimeout {
+		if apiTimeoutRaw.(int) <= 0 {
+			return logical.ErrorResponse("api_timeout must be a positive duration"), nil
+		}
+		cfg.APITimeout = time.Duration(apiTimeoutRaw.(int)) * time.Second
+	}
+
+	// The cached HTTP client is rebuilt with or without enabling APIs.
+	autoEnableRaw, newAutoEnable := data.GetOk("auto_enable_apis")
+	if newAutoEnable {
+		cfg.AutoEnableAPIs = autoEnableRaw.(bool)
+	}
+
+	// And with the new logging of API calls.
+	logLevelRaw, newLogLevel := data.GetOk("gcp_log_level")
+	if newLogLevel {
+		switch level := logLevelRaw.(string); level {
+		case gcpLogLevelOff, gcpLogLevelError, gcpLogLevelDebug:
+			cfg.GCPLogLevel = level
+		default:
+			return logical.ErrorResponse(fmt.Sprintf("invalid gcp_log_level %q, must be one of %q, %q or %q", level, gcpLogLevelOff, gcpLogLevelError, gcpLogLevelDebug)), nil
+		}
+	}
+
+	for field, list := range map[string]*[]string{
+		"sa_fallback_projects": &cfg.ServiceAccountFallbackProjects,
+		"project_denylist":     &cfg.ProjectDenylist,
+		"project_allowlist":    &cfg.ProjectAllowlist,
+	} {
+		raw, ok := data.GetOk(field)
+		if !ok {
+			continue
+		}
+		projects := raw.([]string)
+		if err := validateProjectList(projects); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid %s: %v", field, err)), nil
+		}
+		if len(projects) == 0 {
+			projects = nil
+		}
+		*list = projects
+	}
+
+	parentRaw, ok := data.GetOk("wildcard_project_parent")
+	if ok {
+		parent := strings.TrimSpace(parentRaw.(string))
+		if parent != "" && !wildcardProjectParentRe.MatchString(parent) {
+			return logical.ErrorResponse(fmt.Sprintf(`invalid wildcard_project_parent %q, must be "folders/<id>" or "organizations/<id>"`, parent)), nil
+		}
+		cfg.WildcardProjectParent = parent
+	}
+
+	keyCleanupRaw, ok := data.GetOk("key_cleanup_on_delete")
+	if ok {
+		switch keyCleanup := keyCleanupRaw.(string); keyCleanup {
+		case keyCleanupRevoke, keyCleanupExpire:
+			cfg.KeyCleanupOnDelete = keyCleanup
+		default:
+			return logical.ErrorResponse(fmt.Sprintf("invalid key_cleanup_on_delete %q, must be one of %q or %q", keyCleanup, keyCleanupRevoke, keyCleanupExpire)), nil
+		}
+	}
+
+	// As with api_timeout, the cached clients are rebuilt to use new proxies.
+	newProxy := false
+	for field, proxy := range map[string]*string{
+		"http_proxy":  &cfg.HTTPProxy,
+		"https_proxy": &cfg.HTTPSProxy,
+	} {
+		raw, ok := data.GetOk(field)
+		if !ok {
+			continue
+		}
+		if err := validateProxyURL(raw.(string)); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid %s: %v", field, err)), nil
+		}
+		*proxy = raw.(string)
+		newProxy = true
+	}
+
+	endpointRaw, newCredsEndpoint := data.GetOk("credentials_endpoint")
+	if newCredsEndpoint {
+		endpoint, err := normalizeCredentialsEndpoint(strings.TrimSpace(endpointRaw.(string)))
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid credentials_endpoint: %v", err)), nil
+		}
+		cfg.CredentialsEndpoint = endpoint
+	}
+
+	webhookRaw, ok := data.GetOk("binding_approval_webhook")
+	if ok {
+		webhook := strings.TrimSpace(webhookRaw.(string))
+		if err := validateWebhookURL(webhook); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid binding_approval_webhook: %v", err)), nil
+		}
+		cfg.BindingApprovalWebhook = webhook
+	}
+
+	approvalTimeoutRaw, ok := data.GetOk("binding_approval_timeout")
+	if ok {
+		if approvalTimeoutRaw.(int) <= 0 {
+			return logical.ErrorResponse("binding_approval_timeout must be a positive duration"), nil
+		}
+		cfg.BindingApprovalTimeout = time.Duration(approvalTimeoutRaw.(int)) * time.Second
+	}
+
+	failOpenRaw, ok := data.GetOk("binding_approval_fail_open")
+	if ok {
+		cfg.BindingApprovalFailOpen = failOpenRaw.(bool)
+	}
+
+	policyRaw, ok := data.GetOk("revocation_policy")
+	if ok {
+		switch policy := policyRaw.(string); policy {
+		case revocationPolicyStrict, revocationPolicyBestEffort:
+			cfg.RevocationPolicy = policy
+		default:
+			return logical.ErrorResponse(fmt.Sprintf("invalid revocation_policy %q, must be one of %q or %q", policy, revocationPolicyStrict, revocationPolicyBestEffort)), nil
+		}
+	}
+
+	renewRaw, ok := data.GetOk("renew_past_max_ttl")
+	if ok {
+		switch renew := renewRaw.(string); renew {
+		case renewPastMaxTTLClamp, renewPastMaxTTLError:
+			cfg.RenewPastMaxTTL = renew
+		default:
+			return logical.ErrorResponse(fmt.Sprintf("invalid renew_past_max_ttl %q, must be one of %q or %q", renew, renewPastMaxTTLClamp, renewPastMaxTTLError)), nil
+		}
+	}
+
+	capTTLRaw, ok := data.GetOk("cap_ttl_to_token")
+	if ok {
+		cfg.CapTTLToToken = capTTLRaw.(bool)
+	}
+
+	// Tokens cached in storage are secrets, so they aren't left behind once
+	// the shared cache is no longer used, nor reused once they may have
+	// been generated with other credentials or through another endpoint.
+	if (wasSharedTokenCache && !cfg.sharedTokenCache()) || cfg.CredentialsRaw != oldCredentials || cfg.credentialsEndpoint() != oldCredentialsEndpoint {
+		if err := cleanupSharedTokens(ctx, req.Storage, true); err != nil {
+			return nil, err
+		}
+	}
+
 	entry, err := logical.StorageEntryJSON("config", cfg)
 	if err != nil {
 		return nil, err
@@ -98,17 +737,409 @@ func (b *backend) pathConfigWrite(ctx context.Context, req *logical.Request, dat
 		return nil, err
 	}
 
-	if setNewCreds {
+	if setNewCreds || setFallbackCreds || newAPITimeout || newAutoEnable || newLogLevel || newProxy || newCredsEndpoint {
 		b.ClearCaches()
 	}
+	if len(warnings) > 0 {
+		return &logical.Response{Warnings: warnings}, nil
+	}
 	return nil, nil
 }
 
+const (
+	// ttlSourceConfig and ttlSourceMount describe where an effective TTL
+	// reported on config read comes from.
+	ttlSourceConfig = "config"
+	ttlSourceMount  = "mount"
+
+	revocationPolicyStrict     = "strict"
+	revocationPolicyBestEffort = "best_effort"
+
+	// Values of renew_past_max_ttl.
+	renewPastMaxTTLClamp = "clamp"
+	renewPastMaxTTLError = "error"
+
+	// Values of empty_scopes_behavior.
+	emptyScopesReject               = "reject"
+	emptyScopesDefaultCloudPlatform = "default_cloud_platform"
+
+	defaultMaxBindingRetries = 5
+
+	defaultMaxBindingsPerRoleSet = 1000
+)
+
 type config struct {
 	CredentialsRaw string
 
+	// FallbackCredentialsRaw are credentials to use, in order, when
+	// CredentialsRaw can't get an access token.
+	FallbackCredentialsRaw []string
+
 	TTL    time.Duration
 	MaxTTL time.Duration
+
+	RevocationPolicy   string
+	RenewPastMaxTTL    string
+	KeyRevocationGrace time.Duration
+	MaxBindingRetries  int
+
+	// CapTTLToToken caps secret leases and access token lifetimes to the
+	// remaining TTL of the requesting Vault token.
+	CapTTLToToken bool
+
+	MaxBindingsPerRoleSet int
+
+	// BindingRemovalRate, if positive, caps the IAM policy updates per
+	// second made to remove bindings.
+	BindingRemovalRate int
+
+	ServiceAccountSuffixLength int
+	ServiceAccountNameTemplate string
+	ServiceAccountIdAttempts   int
+
+	// ServiceAccountFallbackProjects are the projects service accounts are
+	// created in, in order, when a role set's project is out of quota.
+	ServiceAccountFallbackProjects []string
+
+	PermissionDeniedTemplate string
+
+	ReconcileInterval time.Duration
+
+	ReapUntrackedKeys  bool
+	UntrackedKeyMaxAge time.Duration
+
+	MaxActiveKeyLeases int
+
+	CacheTokens      bool
+	PrefetchTokens   bool
+	SharedTokenCache bool
+
+	// CoalesceTokenRequests makes concurrent requests for the same access
+	// token share a single GCP call.
+	CoalesceTokenRequests bool
+
+	TokenExpiryAlignment time.Duration
+
+	DisableServiceAccountOnDelete bool
+
+	// AllowDisabledServiceAccountKeys is the inverse of
+	// reject_disabled_sa_keys, so that it defaults to true.
+	AllowDisabledServiceAccountKeys bool
+
+	// ServiceAccountDeletionDelay, if set, defers removing the service
+	// account of a deleted role set, which can be undeleted until then.
+	ServiceAccountDeletionDelay time.Duration
+
+	DisableMetricsRoleSetLabel bool
+
+	WarnBroadScopes     bool
+	RequireNarrowScopes bool
+	EmptyScopesBehavior string
+	RequiredScopes      []string
+	WarnUnmatchedScopes bool
+
+	DefaultSecretType string
+
+	DenySelfEscalatingBindings bool
+
+	APITimeout time.Duration
+
+	// AutoEnableAPIs enables APIs that GCP calls fail for because they are
+	// disabled, and retries the calls.
+	AutoEnableAPIs bool
+
+	GCPLogLevel string
+
+	ProjectDenylist  []string
+	ProjectAllowlist []string
+
+	// WildcardProjectParent is the folder or organization whose projects
+	// are matched by wildcard project resources in bindings.
+	WildcardProjectParent string
+
+	KeyCleanupOnDelete string
+
+	HTTPProxy  string
+	HTTPSProxy string
+
+	CredentialsEndpoint string
+
+	BindingApprovalWebhook  string
+	BindingApprovalTimeout  time.Duration
+	BindingApprovalFailOpen bool
+}
+
+// apiTimeout returns the deadline for each GCP API call.
+func (c *config) apiTimeout() time.Duration {
+	if c == nil || c.APITimeout <= 0 {
+		return defaultAPITimeout
+	}
+	return c.APITimeout
+}
+
+// warnBroadScopes returns whether role sets with the cloud-platform scope get
+// a warning.
+func (c *config) warnBroadScopes() bool {
+	return c != nil && c.WarnBroadScopes
+}
+
+// requireNarrowScopes returns whether role sets with the cloud-platform scope
+// are rejected.
+func (c *config) requireNarrowScopes() bool {
+	return c != nil && c.RequireNarrowScopes
+}
+
+// warnUnmatchedScopes returns whether access token role sets with scopes for
+// services they have no roles for get a warning.
+func (c *config) warnUnmatchedScopes() bool {
+	return c != nil && c.WarnUnmatchedScopes
+}
+
+// rejectDisabledServiceAccountKeys returns whether issuing keys for role
+// sets whose service account is disabled fails.
+func (c *config) rejectDisabledServiceAccountKeys() bool {
+	return c == nil || !c.AllowDisabledServiceAccountKeys
+}
+
+// denySelfEscalatingBindings returns whether role sets whose bindings grant
+// their service account key or token creation on itself are rejected.
+func (c *config) denySelfEscalatingBindings() bool {
+	return c != nil && c.DenySelfEscalatingBindings
+}
+
+// emptyScopesBehavior returns what to do with access token role sets without
+// scopes, defaulting to rejecting them.
+func (c *config) emptyScopesBehavior() string {
+	if c == nil || c.EmptyScopesBehavior == "" {
+		return emptyScopesReject
+	}
+	return c.EmptyScopesBehavior
+}
+
+// defaultSecretType returns the secret type of role sets created without
+// one.
+func (c *config) defaultSecretType() string {
+	if c == nil || c.DefaultSecretType == "" {
+		return SecretTypeAccessToken
+	}
+	return c.DefaultSecretType
+}
+
+// defaultTokenScopes returns the scopes an access token role set without any
+// gets, with a warning to return about them, or an error saying why it gets
+// none.
+func (c *config) defaultTokenScopes() ([]string, string, error) {
+	if c.emptyScopesBehavior() != emptyScopesDefaultCloudPlatform {
+		return nil, "", fmt.Errorf("empty_scopes_behavior is %q", c.emptyScopesBehavior())
+	}
+	if c.requireNarrowScopes() {
+		return nil, "", fmt.Errorf("empty_scopes_behavior is %q, but require_narrow_scopes is set", emptyScopesDefaultCloudPlatform)
+	}
+	return []string{cloudPlatformScope}, fmt.Sprintf("no token_scopes given, using %q because empty_scopes_behavior is %q; this allows access to all Google Cloud APIs the service account has roles for, set token_scopes to narrow it", cloudPlatformScope, emptyScopesDefaultCloudPlatform), nil
+}
+
+// maxActiveKeyLeases returns the limit on active key leases, or 0 if there
+// is none.
+func (c *config) maxActiveKeyLeases() int {
+	if c == nil {
+		return 0
+	}
+	return c.MaxActiveKeyLeases
+}
+
+// serviceAccountSuffixLength returns the length of the random suffix of
+// generated service account IDs.
+func (c *config) serviceAccountSuffixLength() int {
+	if c == nil || c.ServiceAccountSuffixLength <= 0 {
+		return defaultServiceAccountSuffixLen
+	}
+	return c.ServiceAccountSuffixLength
+}
+
+// serviceAccountIdAttempts returns how many generated service account IDs
+// are tried when they are already taken.
+func (c *config) serviceAccountIdAttempts() int {
+	if c == nil || c.ServiceAccountIdAttempts <= 0 {
+		return defaultServiceAccountIdAttempts
+	}
+	return c.ServiceAccountIdAttempts
+}
+
+// serviceAccountNameTemplate returns the template for generated service
+// account IDs, or "" to use the default naming.
+func (c *config) serviceAccountNameTemplate() string {
+	if c == nil {
+		return ""
+	}
+	return c.ServiceAccountNameTemplate
+}
+
+// coalesceTokenRequests returns whether concurrent requests for the same
+// access token share a single GCP call.
+func (c *config) coalesceTokenRequests() bool {
+	return c != nil && c.CoalesceTokenRequests
+}
+
+// sharedTokenCache returns whether cached access tokens are kept in storage.
+func (c *config) sharedTokenCache() bool {
+	return c != nil && c.CacheTokens && c.SharedTokenCache
+}
+
+// cacheTokens returns whether access tokens are cached, false if the config
+// cannot be read.
+func (b *backend) cacheTokens(ctx context.Context, s logical.Storage) bool {
+	cfg, err := getConfig(ctx, s)
+	if err != nil {
+		b.Logger().Warn("unable to read config, not caching access tokens", "error", err)
+	}
+	return cfg != nil && cfg.CacheTokens
+}
+
+// withRequiredScopes returns scopes with the configured required_scopes
+// appended, without duplicates.
+func (c *config) withRequiredScopes(scopes []string) []string {
+	if c == nil || len(c.RequiredScopes) == 0 {
+		return scopes
+	}
+	return appendUniqueScopes(append([]string(nil), scopes...), c.RequiredScopes...)
+}
+
+// tokenExpiryAlignment returns the duration reported token expiries are
+// rounded down to a multiple of, 0 (no rounding) if the config cannot be
+// read.
+func (b *backend) tokenExpiryAlignment(ctx context.Context, s logical.Storage) time.Duration {
+	cfg, err := getConfig(ctx, s)
+	if err != nil {
+		b.Logger().Warn("unable to read config, reporting exact token expiry", "error", err)
+	}
+	if cfg == nil {
+		return 0
+	}
+	return cfg.TokenExpiryAlignment
+}
+
+// disableMetricsRoleSetLabel returns whether issuance metrics leave out the
+// role set name, false if the config cannot be read.
+func (b *backend) disableMetricsRoleSetLabel(ctx context.Context, s logical.Storage) bool {
+	cfg, err := getConfig(ctx, s)
+	if err != nil {
+		b.Logger().Warn("unable to read config, labeling metrics with role set names", "error", err)
+	}
+	return cfg != nil && cfg.DisableMetricsRoleSetLabel
+}
+
+// disableServiceAccountOnDelete returns whether deleting a role set disables
+// its service account rather than deleting it, false if the config cannot be
+// read.
+func (b *backend) disableServiceAccountOnDelete(ctx context.Context, s logical.Storage) bool {
+	cfg, err := getConfig(ctx, s)
+	if err != nil {
+		b.Logger().Warn("unable to read config, deleting service account on role set deletion", "error", err)
+	}
+	return cfg != nil && cfg.DisableServiceAccountOnDelete
+}
+
+// serviceAccountDeletionDelay returns how long the service account of a
+// deleted role set is kept, 0 (not kept) if the config cannot be read.
+func (b *backend) serviceAccountDeletionDelay(ctx context.Context, s logical.Storage) time.Duration {
+	cfg, err := getConfig(ctx, s)
+	if err != nil {
+		b.Logger().Warn("unable to read config, removing service account on role set deletion", "error", err)
+	}
+	if cfg == nil {
+		return 0
+	}
+	return cfg.ServiceAccountDeletionDelay
+}
+
+// maxBindingsPerRoleSet returns the maximum number of role and resource
+// pairs a role set may bind.
+func (c *config) maxBindingsPerRoleSet() int {
+	if c == nil || c.MaxBindingsPerRoleSet <= 0 {
+		return defaultMaxBindingsPerRoleSet
+	}
+	return c.MaxBindingsPerRoleSet
+}
+
+// maxBindingRetries returns how many times to retry an IAM policy update on
+// an etag conflict.
+func (c *config) maxBindingRetries() int {
+	if c == nil || c.MaxBindingRetries <= 0 {
+		return defaultMaxBindingRetries
+	}
+	return c.MaxBindingRetries
+}
+
+// maxBindingRetries returns the configured number of IAM policy update
+// retries, or the default if the config cannot be read.
+func (b *backend) maxBindingRetries(ctx context.Context, s logical.Storage) int {
+	cfg, err := getConfig(ctx, s)
+	if err != nil {
+		b.Logger().Warn("unable to read config, using default max_binding_retries", "error", err)
+	}
+	return cfg.maxBindingRetries()
+}
+
+// bindingRemovalInterval returns the minimum time between IAM policy
+// updates removing bindings, or 0 if they aren't rate limited.
+func (c *config) bindingRemovalInterval() time.Duration {
+	if c == nil || c.BindingRemovalRate <= 0 {
+		return 0
+	}
+	return time.Second / time.Duration(c.BindingRemovalRate)
+}
+
+// bindingRemovalInterval returns the configured minimum time between IAM
+// policy updates removing bindings, or 0 if the config cannot be read.
+func (b *backend) bindingRemovalInterval(ctx context.Context, s logical.Storage) time.Duration {
+	cfg, err := getConfig(ctx, s)
+	if err != nil {
+		b.Logger().Warn("unable to read config, not rate limiting binding removal", "error", err)
+	}
+	return cfg.bindingRemovalInterval()
+}
+
+// keyRevocationGrace returns how long to keep a key after revoking its lease.
+func (c *config) keyRevocationGrace() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return c.KeyRevocationGrace
+}
+
+// revocationPolicy returns the configured revocation policy, defaulting to
+// strict.
+func (c *config) revocationPolicy() string {
+	if c == nil || c.RevocationPolicy == "" {
+		return revocationPolicyStrict
+	}
+	return c.RevocationPolicy
+}
+
+// renewPastMaxTTL returns what to do with key lease renewals past the max
+// TTL, defaulting to clamping them.
+func (c *config) renewPastMaxTTL() string {
+	if c == nil || c.RenewPastMaxTTL == "" {
+		return renewPastMaxTTLClamp
+	}
+	return c.RenewPastMaxTTL
+}
+
+// keyCleanupOnDelete returns what to do with the keys of active leases when
+// a role set is deleted, defaulting to revoking them.
+func (c *config) keyCleanupOnDelete() string {
+	if c == nil || c.KeyCleanupOnDelete == "" {
+		return keyCleanupRevoke
+	}
+	return c.KeyCleanupOnDelete
+}
+
+func (b *backend) keyCleanupOnDelete(ctx context.Context, s logical.Storage) string {
+	cfg, err := getConfig(ctx, s)
+	if err != nil {
+		b.Logger().Warn("unable to read config, revoking keys on role set deletion", "error", err)
+	}
+	return cfg.keyCleanupOnDelete()
 }
 
 func getConfig(ctx context.Context, s logical.Storage) (*config, error) {
@@ -136,4 +1167,312 @@ const pathConfigHelpDesc = `
 The GCP backend requires credentials for managing IAM service accounts and keys
 and IAM policies on various GCP resources. This endpoint is used to configure
 those credentials as well as default values for the backend in general.
+
+Reading the configuration never returns credentials. Along with the
+configured "ttl" and "max_ttl" (0 if unset), it returns the effective values
+used for new leases ("effective_ttl" and "effective_max_ttl") and whether
+each comes from this configuration or is inherited from the mount's system
+defaults ("ttl_source" and "max_ttl_source").
+
+"revocation_policy" controls what happens when a service account key cannot
+be deleted while revoking its lease. "strict" keeps the lease until the key
+is deleted; "best_effort" revokes the lease anyway and leaves deletion of the
+key to a background rollback, so the key may stay valid for a while after
+its lease is gone.
+
+"renew_past_max_ttl" controls key lease renewals that would extend a lease
+past its max TTL. "clamp" renews the lease only up to the max TTL, with a
+warning, and "error" refuses the renewal so the client knows to request a
+new key. Either way, a lease can't be renewed once it reaches its max TTL.
+Renewal only extends the lease: a key that GCP gave an expiry, for example
+through the iam.serviceAccountKeyExpiryHours org policy constraint, stops
+working at that expiry whatever its lease's TTL.
+
+"cap_ttl_to_token" keeps credentials from outliving the Vault token that
+requested them: the lease of a service account key or HMAC key, and the
+lifetime of an access token from token/:roleset, is capped to the token's
+remaining TTL, with a warning when that shortens it. Access tokens capped
+this way are generated with the shorter lifetime through the IAM
+Credentials API, as for a short "ttl" on token/:roleset, so GCP stops
+accepting them when the Vault token expires. Renewals of a capped lease are
+capped to the same expiry. The remaining TTL is worked out from
+the token's creation time, TTL and explicit max TTL, so a token that has
+since been renewed is treated as expiring at the end of its original TTL.
+Tokens without a TTL, such as root tokens, don't cap anything.
+
+"key_revocation_grace" keeps a service account key valid for the given time
+after its lease is revoked. Deletion happens in the background, so the key
+may outlive the grace period by a few minutes.
+
+"fallback_credentials" is an ordered list of credentials JSON files to use
+when the primary "credentials" (or the default application credentials, if
+none are set) can't get an access token, for example while they are being
+rotated. Each access token is taken from the first credentials in the list
+that can get one, and the primary ones are tried again when it expires. They
+should have the same permissions as the primary credentials. Reading the
+config returns their "fallback_client_emails", and config/status reports
+which of them currently work.
+
+"api_timeout" is the deadline for each call to a GCP API, including reading
+its response, on top of the deadline of the Vault request making it. Calls
+that take longer fail with an error saying they timed out. It defaults to
+one minute.
+
+"auto_enable_apis" is meant for fresh projects in trusted environments.
+When a GCP call fails because its API (such as IAM, Resource Manager or IAM
+Credentials) isn't enabled in the project, the API is enabled with the
+Service Usage API and the call is retried once. The backend's credentials
+need serviceusage.services.enable on the project, which lets them turn on
+any API there and incur its costs, so this is off by default. Each API
+enabled is logged, and reported in a warning on the response, or in the
+error if the request still failed, as "<api> in projects/<number>". APIs
+can take a few minutes to become usable after being enabled, so the retry
+may still fail.
+
+"gcp_log_level" logs the backend's GCP API calls through the plugin's logger,
+for debugging. With "error", failed calls are logged at error level with
+their method, host, path, status, latency and the status, message and
+reasons of the GCP error, which tell permission errors from quota errors.
+With "debug", successful calls are also logged, at debug level, without
+their responses. Query strings and response bodies of successful calls are
+never logged, as they can hold tokens or key material. Calls made with a
+role set's credentials, such as generating its access tokens, aren't
+logged.
+
+"token_expiry_alignment" rounds the "expires_at_seconds" and "token_ttl"
+reported with access tokens down to a multiple of the given duration, for
+caches keyed on aligned expiries. The reported expiry is never later than
+the token's real one; tokens that would be reported as already expired get
+their exact expiry instead. The token itself is unchanged.
+
+"project_denylist" and "project_allowlist" keep role sets away from projects
+this backend should not manage, such as production projects from a
+non-production Vault. Writing a role set whose service account project or
+bound resources are in a denied project, or in a project not on a non-empty
+allowlist, fails. Bound folders and organizations are not checked. Existing
+role sets are unaffected until updated.
+
+"wildcard_project_parent" lets role set bindings name many similarly named
+projects at once with a wildcard in the project ID of a project resource,
+such as "projects/team-a-*" or
+"//cloudresourcemanager.googleapis.com/projects/team-a-*". When bindings are
+written, the pattern is matched against the active projects directly under
+this folder or organization, which the backend's credentials need to be
+able to list, and replaced by a binding on each matching project. The
+concrete projects are stored, read back in "wildcard_expansions", and used
+from then on: projects created later are not bound until the role set's
+bindings are written again with different content, and updates and deletion
+act on exactly the projects that were bound.
+
+"sa_fallback_projects" lists projects, in order, to create a role set's
+service account in when its own project has reached GCP's limit on service
+accounts. Only creating the account moves; its bindings stay on the
+resources given and the role set's "project" is unchanged, while
+"service_account_project" on read gives the project the account is in. A
+warning on the write names the fallback project used. The backend's
+credentials need to be able to create service accounts and keys in these
+projects too. Rotating the account tries the role set's project again first.
+
+"key_cleanup_on_delete" is the default for deleting role sets with active
+key leases using "force": "revoke" deletes the keys with the role set, while
+"expire" leaves them valid until their leases are revoked or expire and
+removes the service account and bindings after the last of them.
+
+"http_proxy" and "https_proxy" send requests to GCP through a proxy, for
+networks where the Vault server's proxy environment variables aren't picked
+up. They apply to all API calls and to fetching tokens for the configured
+credentials; since all of these use HTTPS, "https_proxy" is used, or
+"http_proxy" if only it is set. Setting either to an empty string unsets
+it. If neither is set, the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment
+variables are used.
+
+"credentials_endpoint" sends the IAM Credentials API calls that generate
+access tokens, ID tokens and signatures for role set service accounts to
+another endpoint, such as a regional one for latency or data residency. It
+is also the endpoint in the files from token/:roleset/impersonated-credentials.
+Only the IAM Credentials client uses it: calls managing service accounts,
+keys and IAM policies still go to the global endpoints. Setting it to an
+empty string goes back to the global endpoint.
+
+"binding_approval_webhook" has an external service approve role set
+bindings before they are applied. On each create or update of a role set
+that changes its bindings, a JSON object with the role set's name,
+"operation" ("create" or "update"), project, secret_type, proposed
+"bindings" and "binding_members", and, on update, its service account email
+and "previous_bindings" is POSTed to the URL, along with the "entity_id" of
+the client if any. A non-2xx response, or a JSON body with "allow": false,
+rejects the write with the response's "reason" if given; other 2xx
+responses approve it. The webhook is called before any GCP change is made.
+Calls time out after "binding_approval_timeout" (default 10s). If the
+webhook can't be called, the write is rejected, unless
+"binding_approval_fail_open" is set, in which case the bindings are applied
+with a warning. The webhook is not called through the proxies above.
+
+"max_binding_retries" sets how many times an IAM policy update is retried
+when another writer changed the policy in between (an etag conflict).
+Projects with many concurrent IAM changes may need a higher value.
+
+"binding_removal_rate" caps the IAM policy updates per second made to
+remove the bindings of a deleted role set, or of the service account
+replaced by a role set update, so deleting a role set bound to many
+resources doesn't exhaust the IAM quota of their projects. Rate limited
+updates are still retried with backoff. Bindings that can't be removed are
+reported, and removed later by WAL rollback.
+
+"max_bindings_per_roleset" guards against pathological role sets: writing
+bindings with more role and resource pairs than this fails, before any IAM
+policy is changed. It defaults to 1000.
+
+"service_account_suffix_length" sets the length of the random suffix of the
+IDs of service accounts created for role sets. IDs are limited to 30
+characters, so longer suffixes leave less of the role set name in the ID.
+If a generated ID is already taken, such as by a role set created at the
+same time, a new one is generated, up to "service_account_id_attempts"
+(default 5) IDs in all before the write fails.
+
+"sa_name_template" replaces the default "vault<roleset>-<random>" IDs with a
+template using {{roleset}}, {{random}} and {{project}}, for example
+"{{project}}-{{roleset}}-{{random}}". {{random}} is required so IDs are
+unique, and is service_account_suffix_length characters long. The role set
+name is lowercased, has other characters replaced by hyphens and is
+truncated to fit. Templates that cannot render to a valid ID are rejected.
+
+When GCP denies changing the IAM policy of a bound resource, the error
+returned is rendered from "permission_denied_template", which can use
+{{admin_email}}, the email of the backend's credentials, {{resource}}, the
+bound resource, {{permission}}, the permission GCP says is missing or else
+the setIamPolicy permission of the resource's type, and {{error}}, the GCP
+error. Organizations can use it to point operators at internal runbooks.
+Writing an empty template restores the default.
+
+"reconcile_interval" enables periodic reconciliation of role set bindings:
+roles missing from the live IAM policies of bound resources are re-added,
+as with "roleset/:name/reconcile". Reconciliation runs on Vault's periodic
+tick, so it may start up to a minute later than the interval.
+
+"reap_untracked_keys" makes periodic reconciliation also delete user-managed
+keys on the service accounts of role sets, including orphaned role sets,
+that Vault doesn't track and that are older than "untracked_key_max_age",
+as reported "untracked" by "config/keys-audit". It assumes Vault is the sole
+manager of those keys, and deletes keys created outside of Vault. Keys
+created before this version of the backend started tracking key leases,
+which Vault may have issued without tracking them, are never deleted; the
+time tracking started is recorded when the backend is first initialized,
+or else on the first reaping. Review config/keys-audit before enabling it.
+It is off by default, only runs on the active node, and logs every key it
+deletes.
+
+"max_active_key_leases" is a safety valve against runaway clients: once
+that many service account key leases are active across all role sets, new
+keys, including rotated ones, are refused until some are revoked or
+expire. Administrators can bypass it with key/override-lease-limit/:roleset,
+which requires the "sudo" capability. Keys issued before lease tracking was
+added are not counted.
+
+"cache_tokens" makes token/:roleset return the same access token to repeated
+requests for a role set and set of scopes, until it has less than five
+minutes left, instead of generating a new one each time. Requests may pass
+"force_new" to get a new token regardless. Cached tokens are kept in memory
+only and are no longer returned once the role set key is rotated.
+
+"prefetch_tokens" warms the "cache_tokens" cache when the backend starts,
+such as after Vault is restarted or unsealed: a token with the default scopes
+and lifetime is generated for every access token role set, in the
+background, so startup doesn't wait on GCP. Each node only prefetches for
+itself, and prefetching costs a token request per role set on every start.
+Failures are logged and the tokens are generated on first request instead.
+
+"shared_token_cache" keeps the "cache_tokens" cache in storage, seal-wrapped
+where supported, so that all nodes of a cluster, including performance
+standbys, return the same token rather than each generating its own. A
+token in storage is reused until it has less than five minutes left. Only
+the active node generates and writes tokens: a performance standby that
+finds no usable token forwards the request to it, and the active node
+generates one token per role set and scopes at a time, checking storage
+again before calling GCP. Nodes still cache tokens in memory, and expired
+tokens are removed from storage periodically. Unsetting shared_token_cache
+or cache_tokens deletes the tokens kept in storage, as does changing
+"credentials" or "credentials_endpoint".
+
+"coalesce_token_requests" protects GCP quota from bursts of requests for the
+same token, such as many clients starting at once: while an access token is
+being generated for a role set, scopes and lifetime, other requests for the
+same one on the node wait for it and get the same token, instead of each
+calling GCP. If generation fails, every waiting request gets the error, and
+the next request tries again. It works with or without "cache_tokens"; with
+it, only requests that miss the cache are coalesced. Ephemeral role sets
+are never coalesced.
+
+"warn_broad_scopes" and "require_narrow_scopes" are guardrails against
+granting the broad cloud-platform scope in role set token_scopes: the first
+returns a warning when a role set is written with it, the second rejects the
+role set. They only apply when token_scopes are written, so existing role
+sets are unaffected until updated.
+
+OAuth scopes and IAM roles are independent: a token needs both a scope
+covering an API and a role granting permissions on it. With
+"warn_unmatched_scopes" set, writing the token_scopes or bindings of an
+access token role set returns a warning naming the scopes for services
+none of its bound roles are for, such as the BigQuery scope with only
+storage roles. It's a heuristic on predefined role names, so role sets
+with basic or custom roles aren't checked.
+
+"default_secret_type" is the secret_type of role sets created without one,
+"access_token" by default. Role sets written with a secret_type keep it, and
+role sets created with the default still need the fields of their type,
+such as token_scopes for "access_token".
+
+"empty_scopes_behavior" decides what happens to access token role sets
+without token_scopes, which GCP can't issue tokens for. With "reject", the
+default, writing one fails, as does generating a token for one stored
+before scopes were required. With "default_cloud_platform", they get the
+cloud-platform scope instead, with a warning each time; this is rejected
+anyway if "require_narrow_scopes" is set.
+
+"required_scopes" are OAuth scopes added to every access token generated,
+whatever the role set's token_scopes or the scopes requested, for scopes
+such as userinfo.email that services need from every caller. They are
+added when tokens are generated, so they apply to existing role sets too
+and aren't stored in or read back with token_scopes. Each one broadens
+every token this backend issues, so only list scopes all callers need;
+cloud-platform is rejected if "require_narrow_scopes" is set. Scopes must
+be https URLs, or one of "openid", "email" or "profile".
+
+A role set whose bindings grant its own service account
+roles/iam.serviceAccountKeyAdmin or roles/iam.serviceAccountTokenCreator on
+its project could create keys or tokens for itself that Vault doesn't
+track. Writing such bindings returns a warning,
+or fails if "deny_self_escalating_bindings" is set. Only bindings on the
+service account's project are checked, not grants inherited from folders
+or organizations.
+
+"disable_sa_on_delete" makes deleting a role set disable its service account
+instead of deleting it, for organizations that keep service accounts for
+audit log attribution. Its bindings, token creators and keys are still
+removed. Service accounts replaced when a role set's bindings change are
+deleted as before.
+
+"sa_deletion_delay" keeps the service account of a deleted role set for the
+given duration before deleting, or disabling, it, so workloads still using
+it fail gracefully and an accidental deletion can be reverted by writing to
+undelete-roleset/:name. The role set's bindings and token creators are
+removed immediately, and its leased keys as usual; undeleting restores the
+bindings and token creators, along with the role set and the key access
+token role sets generate tokens with. The account is removed by WAL
+rollback, so it may be kept a few minutes past the delay. Role sets whose
+service account is kept for the keys of active leases
+(key_cleanup_on_delete=expire) aren't delayed further.
+
+Keys of a disabled service account can't authenticate, whether it was
+disabled by "disable_sa_on_delete" or outside of Vault. With
+"reject_disabled_sa_keys", on by default, issuing a key for a role set whose
+service account is disabled fails with an error saying so instead of
+returning a key that doesn't work. Access tokens aren't affected, since GCP
+already refuses to generate them for disabled accounts.
+
+Every token and key issued is counted in the gcp.issuance.success metric,
+and every one that fails in gcp.issuance.failure, labeled with the
+secret_type, the roleset name and, for failures, the status_code of the GCP
+error ("unknown" if GCP didn't return one). Role set names can make these
+metrics high-cardinality; "disable_metrics_roleset_label" drops that label.
 `
@@ -0,0 +1,157 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/iam/v1"
+)
+
+func pathConfigExport(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/export",
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathConfigExportRead,
+			},
+		},
+
+		HelpSynopsis:    pathConfigExportHelpSyn,
+		HelpDescription: pathConfigExportHelpDesc,
+	}
+}
+
+func (b *backend) pathConfigExportRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	rsNames, err := req.Storage.List(ctx, fmt.Sprintf("%s/", rolesetStoragePrefix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(rsNames)
+
+	// GCP lookups are best effort; without a client every role set is
+	// reported as unreachable.
+	iamAdmin, clientErr := b.IAMAdminClient(req.Storage)
+
+	rolesets := make(map[string]interface{}, len(rsNames))
+	unreachable := make([]string, 0)
+	for _, rsName := range rsNames {
+		rs, err := getRoleSet(rsName, ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if rs == nil {
+			continue
+		}
+
+		out, err := b.exportRoleSet(ctx, req.Storage, iamAdmin, clientErr, rs)
+		if err != nil {
+			return nil, err
+		}
+		if !out["gcp_reachable"].(bool) {
+			unreachable = append(unreachable, rsName)
+		}
+		rolesets[rsName] = out
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"rolesets":             rolesets,
+			"unreachable_rolesets": unreachable,
+		},
+	}, nil
+}
+
+// exportRoleSet returns the inventory of a single role set. Errors looking up
+// the role set's resources in GCP are reported in the output rather than
+// returned.
+func (b *backend) exportRoleSet(ctx context.Context, s logical.Storage, iamAdmin *iam.Service, clientErr error, rs *RoleSet) (map[string]interface{}, error) {
+	out := map[string]interface{}{
+		"secret_type": rs.SecretType,
+		"bindings":    rs.Bindings.asOutput(),
+	}
+	if len(rs.BindingMembers) > 0 {
+		out["binding_members"] = bindingMembersOutput(rs.BindingMembers)
+	}
+	if rs.TokenGen != nil {
+		out["token_scopes"] = rs.TokenGen.Scopes
+		out["token_key_name"] = rs.TokenGen.KeyName
+	}
+	if len(rs.TokenCreators) > 0 {
+		out["token_creators"] = rs.TokenCreators
+	}
+
+	leases, err := listKeyLeases(ctx, s, rs.Name)
+	if err != nil {
+		return nil, err
+	}
+	keyLeases := make([]map[string]interface{}, 0, len(leases))
+	for _, kl := range leases {
+		klOut := map[string]interface{}{
+			"key_name":   kl.KeyName,
+			"issue_time": kl.IssueTime.Format(time.RFC3339),
+		}
+		if !kl.ExpireTime.IsZero() {
+			klOut["expire_time"] = kl.ExpireTime.Format(time.RFC3339)
+		}
+		keyLeases = append(keyLeases, klOut)
+	}
+	out["key_leases"] = keyLeases
+
+	var gcpErrs []string
+	if rs.AccountId == nil {
+		gcpErrs = append(gcpErrs, "role set has no associated service account")
+	} else {
+		out["project"] = rs.project()
+		out["service_account_email"] = rs.AccountId.EmailOrId
+
+		if clientErr != nil {
+			gcpErrs = append(gcpErrs, fmt.Sprintf("unable to create IAM client: %v", clientErr))
+		} else {
+			sa, err := iamAdmin.Projects.ServiceAccounts.Get(rs.AccountId.ResourceName()).Context(ctx).Do()
+			if err != nil {
+				gcpErrs = append(gcpErrs, fmt.Sprintf("unable to get service account: %v", err))
+			} else {
+				out["service_account_unique_id"] = sa.UniqueId
+
+				keys, err := listUserManagedKeys(ctx, iamAdmin, sa.Name)
+				if err != nil {
+					gcpErrs = append(gcpErrs, fmt.Sprintf("unable to list service account keys: %v", err))
+				} else {
+					keyNames := make([]string, 0, len(keys))
+					for _, k := range keys {
+						keyNames = append(keyNames, k.Name)
+					}
+					sort.Strings(keyNames)
+					out["gcp_key_names"] = keyNames
+				}
+			}
+		}
+	}
+
+	out["gcp_reachable"] = len(gcpErrs) == 0
+	if len(gcpErrs) > 0 {
+		out["gcp_errors"] = gcpErrs
+	}
+	return out, nil
+}
+
+const pathConfigExportHelpSyn = `
+Export an inventory of the GCP resources managed by this backend.
+`
+
+const pathConfigExportHelpDesc = `
+This endpoint returns a snapshot of every role set and the GCP resources it
+manages: its service account, bound resources and roles, the key used to
+generate access tokens, and the keys issued under leases. No secrets are
+included.
+
+Service account details and existing keys are looked up in GCP on a best
+effort basis. Any role set whose resources could not be reached has
+"gcp_reachable" set to false with the errors in "gcp_errors", and is listed
+in "unreachable_rolesets".
+`
@@ -0,0 +1,120 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathConfigKeyMap(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/key-map",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathConfigKeyMapRead,
+			},
+		},
+
+		HelpSynopsis:    pathConfigKeyMapHelpSyn,
+		HelpDescription: pathConfigKeyMapHelpDesc,
+	}
+}
+
+func (b *backend) pathConfigKeyMapRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	keys := make(map[string]interface{})
+
+	// Key leases are listed by role set name, which covers keys of orphaned
+	// role sets as well as of current ones.
+	rsNames, err := req.Storage.List(ctx, keyLeaseStoragePrefix+"/")
+	if err != nil {
+		return nil, err
+	}
+	for _, rsName := range rsNames {
+		rsName = strings.TrimSuffix(rsName, "/")
+		leases, err := listKeyLeases(ctx, req.Storage, rsName)
+		if err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("unable to list key leases of role set '%s': {{err}}", rsName), err)
+		}
+		for _, kl := range leases {
+			keys[kl.KeyName] = keyMapEntry(kl, keyAuditLeased)
+			if kl.PendingKeyName != "" {
+				keys[kl.PendingKeyName] = keyMapEntry(kl, keyAuditPendingRotation)
+			}
+		}
+	}
+
+	tokenRsNames, err := req.Storage.List(ctx, fmt.Sprintf("%s/", rolesetStoragePrefix))
+	if err != nil {
+		return nil, err
+	}
+	for _, rsName := range tokenRsNames {
+		rs, err := getRoleSet(rsName, ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if rs == nil || rs.TokenGen == nil || rs.TokenGen.KeyName == "" {
+			continue
+		}
+		keys[rs.TokenGen.KeyName] = map[string]interface{}{
+			"key_id":  keyID(rs.TokenGen.KeyName),
+			"roleset": rs.Name,
+			"status":  keyAuditTokenGenerator,
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"keys": keys,
+		},
+	}, nil
+}
+
+// keyMapEntry returns what config/key-map reports about a key tracked by the
+// given lease.
+func keyMapEntry(kl *keyLease, status string) map[string]interface{} {
+	entry := map[string]interface{}{
+		"key_id":     keyID(kl.KeyName),
+		"roleset":    kl.RoleSet,
+		"status":     status,
+		"issue_time": kl.IssueTime.Format(time.RFC3339),
+	}
+	if status == keyAuditPendingRotation {
+		entry["key_id"] = keyID(kl.PendingKeyName)
+		entry["replaces_key_name"] = kl.KeyName
+	}
+	if !kl.ExpireTime.IsZero() {
+		entry["expire_time"] = kl.ExpireTime.Format(time.RFC3339)
+	}
+	return entry
+}
+
+const pathConfigKeyMapHelpSyn = `
+Map the GCP names of keys tracked by Vault to their role sets and leases
+`
+
+const pathConfigKeyMapHelpDesc = `
+GCP doesn't let service account keys be named or labeled: their only
+identifier is the key ID at the end of their name, and fields such as
+keyOrigin are set by GCP. To find which Vault lease a key seen in the GCP
+console belongs to, this path returns every key tracked by the backend in
+"keys", by full key name, with:
+
+	key_id       the key ID shown in the GCP console
+	roleset      the role set the key was issued for
+	status       leased, pending_rotation or token_generator, as reported
+	             by config/keys-audit
+	issue_time   when the key was first issued
+	expire_time  when the key's lease expires, if known
+
+Keys created to replace a leased key on rotation, not yet returned by a
+renewal, also have "replaces_key_name". The key an access token role set
+generates tokens with has only key_id, roleset and status.
+
+Unlike config/keys-audit, this path reads Vault storage only and makes no
+GCP calls, so keys created outside of Vault are not listed.
+`
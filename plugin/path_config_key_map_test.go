@@ -0,0 +1,55 @@
+package gcpsecrets
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestConfigKeyMap(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+	rs := testStoredKeyRoleSet(t, storage, "test-keymap")
+	saName := rs.AccountId.ResourceName()
+
+	issued := time.Now().UTC().Truncate(time.Second)
+	kl := &keyLease{
+		RoleSet:        rs.Name,
+		KeyName:        saName + "/keys/leased",
+		IssueTime:      issued,
+		PendingKeyName: saName + "/keys/pending",
+	}
+	if err := kl.save(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config/key-map",
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+
+	keys := resp.Data["keys"].(map[string]interface{})
+	if len(keys) != 2 {
+		t.Fatalf("expected two keys, got %v", keys)
+	}
+	leased := keys[kl.KeyName].(map[string]interface{})
+	if leased["key_id"] != "leased" || leased["roleset"] != rs.Name ||
+		leased["status"] != keyAuditLeased || leased["issue_time"] != issued.Format(time.RFC3339) {
+		t.Errorf("unexpected leased key entry: %v", leased)
+	}
+	pending := keys[kl.PendingKeyName].(map[string]interface{})
+	if pending["key_id"] != "pending" || pending["status"] != keyAuditPendingRotation || pending["replaces_key_name"] != kl.KeyName {
+		t.Errorf("unexpected pending key entry: %v", pending)
+	}
+}
@@ -0,0 +1,221 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// Values of the status of keys reported by config/keys-audit.
+	keyAuditLeased          = "leased"
+	keyAuditPendingRotation = "pending_rotation"
+	keyAuditTokenGenerator  = "token_generator"
+	keyAuditPendingDeletion = "pending_deletion"
+	keyAuditUntracked       = "untracked"
+)
+
+func pathConfigKeysAudit(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/keys-audit",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathConfigKeysAuditRead,
+			},
+		},
+
+		HelpSynopsis:    pathConfigKeysAuditHelpSyn,
+		HelpDescription: pathConfigKeysAuditHelpDesc,
+	}
+}
+
+// keyAuditEntry is what the backend knows about a key, by key ID.
+type keyAuditEntry struct {
+	status string
+	lease  *keyLease
+}
+
+func (b *backend) pathConfigKeysAuditRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	roleSets, known, err := trackedKeys(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	iamC, err := b.IAMAdminClient(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &logical.Response{}
+	keys := make([]map[string]interface{}, 0)
+	untracked := make([]string, 0)
+	for _, rs := range roleSets {
+		if rs.AccountId == nil {
+			continue
+		}
+		gcpKeys, err := listUserManagedKeys(ctx, iamC, rs.AccountId.ResourceName())
+		if err != nil {
+			resp.AddWarning(fmt.Sprintf("unable to list keys of role set '%s' service account %s: %v", rs.Name, rs.AccountId.EmailOrId, b.withGoogleRequestID(req.Path, err)))
+			continue
+		}
+
+		for _, k := range gcpKeys {
+			entry := known[keyID(k.Name)]
+			if entry == nil {
+				entry = &keyAuditEntry{status: keyAuditUntracked}
+				untracked = append(untracked, k.Name)
+			}
+
+			key := map[string]interface{}{
+				"key_name":              k.Name,
+				"roleset":               rs.Name,
+				"service_account_email": rs.AccountId.EmailOrId,
+				"status":                entry.status,
+				"valid_after_time":      k.ValidAfterTime,
+				"valid_before_time":     k.ValidBeforeTime,
+			}
+			if kl := entry.lease; kl != nil {
+				key["issue_time"] = kl.IssueTime.Format(time.RFC3339)
+				if !kl.ExpireTime.IsZero() {
+					key["expire_time"] = kl.ExpireTime.Format(time.RFC3339)
+				}
+			}
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(untracked)
+	if len(untracked) > 0 {
+		resp.AddWarning(fmt.Sprintf("%d keys on service accounts managed by this backend are not tracked by Vault and may have been created outside of it: %s", len(untracked), strings.Join(untracked, ", ")))
+	}
+	resp.Data = map[string]interface{}{
+		"keys":           keys,
+		"untracked_keys": untracked,
+	}
+	return resp, nil
+}
+
+// trackedKeys returns the role sets whose service accounts are managed by
+// this backend, including orphaned role sets, and the keys Vault tracks on
+// them, by key ID.
+func trackedKeys(ctx context.Context, s logical.Storage) ([]*RoleSet, map[string]*keyAuditEntry, error) {
+	rsNames, err := s.List(ctx, fmt.Sprintf("%s/", rolesetStoragePrefix))
+	if err != nil {
+		return nil, nil, err
+	}
+	orphanNames, err := s.List(ctx, fmt.Sprintf("%s/", orphanedRoleSetStoragePrefix))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	known, err := deferredKeyDeletions(ctx, s)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var roleSets []*RoleSet
+	for _, rsName := range rsNames {
+		rs, err := getRoleSet(rsName, ctx, s)
+		if err != nil {
+			return nil, nil, err
+		}
+		if rs != nil {
+			roleSets = append(roleSets, rs)
+		}
+	}
+	for _, rsName := range orphanNames {
+		rs, err := getOrphanedRoleSet(ctx, s, rsName)
+		if err != nil {
+			return nil, nil, err
+		}
+		if rs != nil {
+			roleSets = append(roleSets, rs)
+		}
+	}
+
+	for _, rs := range roleSets {
+		if rs.TokenGen != nil && rs.TokenGen.KeyName != "" {
+			known[keyID(rs.TokenGen.KeyName)] = &keyAuditEntry{status: keyAuditTokenGenerator}
+		}
+		leases, err := listKeyLeases(ctx, s, rs.Name)
+		if err != nil {
+			return nil, nil, errwrap.Wrapf(fmt.Sprintf("unable to list key leases of role set '%s': {{err}}", rs.Name), err)
+		}
+		for _, kl := range leases {
+			known[keyID(kl.KeyName)] = &keyAuditEntry{status: keyAuditLeased, lease: kl}
+			if kl.PendingKeyName != "" {
+				known[keyID(kl.PendingKeyName)] = &keyAuditEntry{status: keyAuditPendingRotation, lease: kl}
+			}
+		}
+	}
+	return roleSets, known, nil
+}
+
+// deferredKeyDeletions returns the keys whose deletion was deferred to WAL
+// rollback, by key ID.
+func deferredKeyDeletions(ctx context.Context, s logical.Storage) (map[string]*keyAuditEntry, error) {
+	walIds, err := framework.ListWAL(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*keyAuditEntry)
+	for _, walId := range walIds {
+		wal, err := framework.GetWAL(ctx, s, walId)
+		if err != nil {
+			return nil, err
+		}
+		if wal == nil || wal.Kind != walTypeAccountKey {
+			continue
+		}
+		var entry walAccountKey
+		if err := decodeWAL(wal.Data, &entry); err != nil {
+			return nil, err
+		}
+		if entry.KeyName != "" {
+			keys[keyID(entry.KeyName)] = &keyAuditEntry{status: keyAuditPendingDeletion}
+		}
+	}
+	return keys, nil
+}
+
+// keyID returns the ID of the key with the given full GCP name. Key IDs are
+// unique, while the project part of a name may be given as "-".
+func keyID(keyName string) string {
+	return keyName[strings.LastIndex(keyName, "/")+1:]
+}
+
+const pathConfigKeysAuditHelpSyn = `
+Report every user-managed key on the service accounts of this backend's role sets
+`
+
+const pathConfigKeysAuditHelpDesc = `
+This path lists the user-managed keys of the service account of every role
+set, including role sets deleted with key_cleanup_on_delete=expire whose
+service accounts are kept for outstanding keys, and reports for each whether
+Vault tracks it:
+
+	leased            issued as a secret with an active lease
+	pending_rotation  created to replace a leased key on rotation, not yet
+	                  returned by a renewal
+	token_generator   the key an access token role set generates tokens with
+	pending_deletion  revoked or replaced, and due to be deleted by rollback
+	untracked         unknown to Vault
+
+Untracked keys were created outside of Vault, leaked by a failure to track
+them, or issued before the backend tracked key leases, and are the ones to
+investigate. They are also listed in "untracked_keys" and in a warning.
+Service accounts whose keys can't be listed are reported as warnings.
+Untracked keys older than a maximum age can be deleted automatically by
+setting "reap_untracked_keys" on the config endpoint, which spares keys
+created before key lease tracking started.
+
+This makes a GCP API call per role set, so it can be slow with many role
+sets.
+`
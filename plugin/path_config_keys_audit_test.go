@@ -0,0 +1,89 @@
+package gcpsecrets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/iam/v1"
+)
+
+func TestConfigKeysAudit(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var rs *RoleSet
+	b, storage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		saName := rs.AccountId.ResourceName()
+		if r.Method != http.MethodGet || r.URL.Path != "/v1/"+saName+"/keys" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&iam.ListServiceAccountKeysResponse{
+			Keys: []*iam.ServiceAccountKey{
+				{Name: saName + "/keys/leased", KeyType: keyTypeUserManaged},
+				{Name: saName + "/keys/revoked", KeyType: keyTypeUserManaged},
+				{Name: saName + "/keys/manual", KeyType: keyTypeUserManaged},
+			},
+		})
+	}))
+	rs = testStoredKeyRoleSet(t, storage, "test-keysaudit")
+	saName := rs.AccountId.ResourceName()
+
+	kl := &keyLease{RoleSet: rs.Name, KeyName: saName + "/keys/leased", IssueTime: time.Now().UTC()}
+	if err := kl.save(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	if err := deferKeyDeletion(ctx, storage, rs.Name, saName+"/keys/revoked", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	// WALs of other kinds are skipped.
+	if _, err := framework.PutWAL(ctx, storage, walTypeIamPolicy, &walIamPolicy{RoleSet: rs.Name}); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config/keys-audit",
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+
+	statuses := make(map[string]interface{})
+	for _, k := range resp.Data["keys"].([]map[string]interface{}) {
+		statuses[k["key_name"].(string)] = k["status"]
+	}
+	expected := map[string]interface{}{
+		saName + "/keys/leased":  keyAuditLeased,
+		saName + "/keys/revoked": keyAuditPendingDeletion,
+		saName + "/keys/manual":  keyAuditUntracked,
+	}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Fatalf("expected key statuses %v, got %v", expected, statuses)
+	}
+	if untracked := resp.Data["untracked_keys"]; !reflect.DeepEqual(untracked, []string{saName + "/keys/manual"}) {
+		t.Fatalf("unexpected untracked_keys %v", untracked)
+	}
+	if len(resp.Warnings) != 1 {
+		t.Fatalf("expected a warning about the untracked key, got %v", resp.Warnings)
+	}
+}
+
+func TestKeyID(t *testing.T) {
+	t.Parallel()
+
+	if id := keyID("projects/-/serviceAccounts/sa@my-project.iam.gserviceaccount.com/keys/abc123"); id != "abc123" {
+		t.Fatalf("expected key ID abc123, got %q", id)
+	}
+}
@@ -0,0 +1,152 @@
+package gcpsecrets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// metricsNamePrefix prefixes the names of metrics served by config/metrics,
+// as Vault prefixes plugin metrics sent to go-metrics sinks.
+const metricsNamePrefix = "vault_"
+
+func pathConfigMetrics(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/metrics",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathConfigMetricsRead,
+			},
+		},
+
+		HelpSynopsis:    pathConfigMetricsHelpSyn,
+		HelpDescription: pathConfigMetricsHelpDesc,
+	}
+}
+
+func (b *backend) pathConfigMetricsRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	leases, err := activeKeyLeases(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			logical.HTTPContentType: "text/plain; version=0.0.4",
+			logical.HTTPRawBody:     b.counters.prometheusText(leases),
+			logical.HTTPStatusCode:  http.StatusOK,
+		},
+	}, nil
+}
+
+// activeKeyLeases returns the number of key leases of each role set.
+func activeKeyLeases(ctx context.Context, s logical.Storage) (map[string]int, error) {
+	rsNames, err := s.List(ctx, keyLeaseStoragePrefix+"/")
+	if err != nil {
+		return nil, err
+	}
+	leases := make(map[string]int, len(rsNames))
+	for _, rsName := range rsNames {
+		keyIds, err := s.List(ctx, keyLeaseStoragePrefix+"/"+rsName)
+		if err != nil {
+			return nil, err
+		}
+		if len(keyIds) > 0 {
+			leases[strings.TrimSuffix(rsName, "/")] = len(keyIds)
+		}
+	}
+	return leases, nil
+}
+
+// prometheusText serializes the counters, latencies and the given key lease
+// counts per role set in the Prometheus text exposition format.
+func (c *pluginCounters) prometheusText(keyLeases map[string]int) []byte {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	var buf bytes.Buffer
+	byName := make(map[string][]counterKey)
+	var names []string
+	for key := range c.counters {
+		if _, ok := byName[key.name]; !ok {
+			names = append(names, key.name)
+		}
+		byName[key.name] = append(byName[key.name], key)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		keys := byName[name]
+		sort.Slice(keys, func(i, j int) bool { return keys[i].labels < keys[j].labels })
+		metric := metricsNamePrefix + name + "_total"
+		fmt.Fprintf(&buf, "# TYPE %s counter\n", metric)
+		for _, key := range keys {
+			fmt.Fprintf(&buf, "%s{%s} %d\n", metric, key.labels, c.counters[key])
+		}
+	}
+
+	if len(keyLeases) > 0 {
+		metric := metricsNamePrefix + "gcp_key_leases"
+		fmt.Fprintf(&buf, "# TYPE %s gauge\n", metric)
+		rsNames := make([]string, 0, len(keyLeases))
+		for rsName := range keyLeases {
+			rsNames = append(rsNames, rsName)
+		}
+		sort.Strings(rsNames)
+		for _, rsName := range rsNames {
+			labels := formatMetricLabels([]metrics.Label{{Name: "roleset", Value: rsName}})
+			fmt.Fprintf(&buf, "%s{%s} %d\n", metric, labels, keyLeases[rsName])
+		}
+	}
+
+	if len(c.latencies) > 0 {
+		metric := metricsNamePrefix + "gcp_api_request_duration_seconds"
+		fmt.Fprintf(&buf, "# TYPE %s histogram\n", metric)
+		services := make([]string, 0, len(c.latencies))
+		for service := range c.latencies {
+			services = append(services, service)
+		}
+		sort.Strings(services)
+		for _, service := range services {
+			h := c.latencies[service]
+			labels := formatMetricLabels([]metrics.Label{{Name: "service", Value: service}})
+			for i, le := range gcpLatencyBuckets {
+				fmt.Fprintf(&buf, "%s_bucket{%s,le=\"%g\"} %d\n", metric, labels, le, h.buckets[i])
+			}
+			fmt.Fprintf(&buf, "%s_bucket{%s,le=\"+Inf\"} %d\n", metric, labels, h.count)
+			fmt.Fprintf(&buf, "%s_sum{%s} %g\n", metric, labels, h.sum)
+			fmt.Fprintf(&buf, "%s_count{%s} %d\n", metric, labels, h.count)
+		}
+	}
+	return buf.Bytes()
+}
+
+const pathConfigMetricsHelpSyn = `
+Serve the plugin's counters in the Prometheus text format
+`
+
+const pathConfigMetricsHelpDesc = `
+This path returns the plugin's internal counters in the Prometheus text
+exposition format, for monitoring that scrapes HTTP rather than receiving
+go-metrics sinks:
+
+	vault_gcp_issuance_success_total          secrets issued, by secret type
+	                                          and role set
+	vault_gcp_issuance_failure_total          failed issuances, also by the
+	                                          status code of the GCP error
+	vault_gcp_key_leases                      outstanding key leases per role
+	                                          set
+	vault_gcp_api_request_duration_seconds    histogram of GCP API call
+	                                          latencies, by service
+
+The issuance counters carry the same labels as those sent to go-metrics,
+so "disable_metrics_roleset_label" drops the role set from them too.
+Counters and latencies are kept in memory by each Vault node for its own
+requests, and are reset when the plugin restarts; key leases are read from
+storage.
+`
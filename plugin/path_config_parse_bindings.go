@@ -0,0 +1,77 @@
+package gcpsecrets
+
+import (
+	"context"
+
+	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/util"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathConfigParseBindings(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/parse-bindings",
+		Fields: map[string]*framework.FieldSchema{
+			"bindings": {
+				Type:        framework.TypeString,
+				Description: "Required. Bindings document to parse, in the same HCL or JSON format (optionally base64-encoded) as role set bindings.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathConfigParseBindings,
+			},
+		},
+		HelpSynopsis:    pathConfigParseBindingsHelpSyn,
+		HelpDescription: pathConfigParseBindingsHelpDesc,
+	}
+}
+
+func (b *backend) pathConfigParseBindings(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	bindingsRaw := d.Get("bindings").(string)
+	if bindingsRaw == "" {
+		return logical.ErrorResponse("bindings are required"), nil
+	}
+
+	var fe fieldErrors
+	parsed, members, err := util.ParseBindingsWithMembers(bindingsRaw)
+	switch {
+	case err != nil:
+		fe.add("bindings", "unable to parse bindings: %v", err)
+	case len(parsed) == 0:
+		fe.add("bindings", "unable to parse any bindings from given bindings HCL")
+	default:
+		if _, err := b.parseBindingResources(parsed); err != nil {
+			fe.add("bindings", "unsupported resources: %v", err)
+		}
+		for _, reason := range validateBindingMembers(members) {
+			fe.add("bindings", "invalid member of %s", reason)
+		}
+	}
+	if resp := fe.response(); resp != nil {
+		return resp, nil
+	}
+
+	bindings := ResourceBindings(parsed)
+	data := map[string]interface{}{
+		"bindings":       bindings.asOutput(),
+		"resource_count": len(bindings),
+		"role_count":     bindings.count(),
+	}
+	if members := bindingMembersOutput(members); len(members) > 0 {
+		data["binding_members"] = members
+	}
+	return &logical.Response{Data: data}, nil
+}
+
+const pathConfigParseBindingsHelpSyn = `Validate and normalize a bindings document without applying it.`
+const pathConfigParseBindingsHelpDesc = `
+This path parses a bindings document the way role set creates and updates
+do, and returns it in the canonical form role sets are read back in: each
+resource mapped to its sorted list of roles, with any additional members in
+"binding_members". Resources the backend can't manage and malformed members
+are reported as errors, with the line of each parse error.
+
+Nothing is written and no GCP calls are made, so the resources and roles
+aren't checked to exist; role set writes still do that.
+`
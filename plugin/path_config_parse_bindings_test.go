@@ -0,0 +1,88 @@
+package gcpsecrets
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestConfigParseBindings(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+
+	parse := func(bindings string) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config/parse-bindings",
+			Storage:   storage,
+			Data:      map[string]interface{}{"bindings": bindings},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil {
+			t.Fatal("expected a response")
+		}
+		return resp
+	}
+
+	resp := parse(`
+resource "projects/my-project" {
+  roles = ["roles/viewer", "roles/browser"]
+}
+resource "projects/my-project" {
+  roles = ["roles/viewer"]
+  members = ["group:admins@example.com"]
+}`)
+	if resp.IsError() {
+		t.Fatalf("unexpected error: %v", resp.Error())
+	}
+	expected := map[string][]string{
+		"projects/my-project": {"roles/browser", "roles/viewer"},
+	}
+	if !reflect.DeepEqual(resp.Data["bindings"], expected) {
+		t.Errorf("expected bindings %v, got %v", expected, resp.Data["bindings"])
+	}
+	if resp.Data["resource_count"] != 1 || resp.Data["role_count"] != 2 {
+		t.Errorf("expected 1 resource and 2 roles, got %v and %v", resp.Data["resource_count"], resp.Data["role_count"])
+	}
+	expectedMembers := map[string][]string{
+		"projects/my-project": {"group:admins@example.com"},
+	}
+	if !reflect.DeepEqual(resp.Data["binding_members"], expectedMembers) {
+		t.Errorf("expected binding members %v, got %v", expectedMembers, resp.Data["binding_members"])
+	}
+
+	// JSON bindings parse to the same canonical form.
+	resp = parse(`{"resource": {"projects/my-project": {"roles": ["roles/viewer", "roles/browser"]}}}`)
+	if resp.IsError() {
+		t.Fatalf("unexpected error: %v", resp.Error())
+	}
+	if !reflect.DeepEqual(resp.Data["bindings"], expected) {
+		t.Errorf("expected JSON bindings %v, got %v", expected, resp.Data["bindings"])
+	}
+
+	for name, tc := range map[string]struct {
+		bindings string
+		errMsg   string
+	}{
+		"syntax":       {`resource "projects/my-project" {`, "unable to parse"},
+		"no bindings":  {`# nothing here`, "unable to parse any bindings"},
+		"bad resource": {`resource "projects/my-project/notAResource/foo" { roles = ["roles/viewer"] }`, "projects/my-project/notAResource/foo"},
+		"bad member":   {`resource "projects/my-project" { roles = ["roles/viewer"] members = ["admins"] }`, "invalid member"},
+	} {
+		resp := parse(tc.bindings)
+		if !resp.IsError() {
+			t.Errorf("%s: expected error response, got %v", name, resp.Data)
+			continue
+		}
+		if !strings.Contains(resp.Error().Error(), tc.errMsg) {
+			t.Errorf("%s: expected %q to contain %q", name, resp.Error().Error(), tc.errMsg)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/iam/v1"
+)
+
+func pathConfigReconcileKeys(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/reconcile-keys",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathConfigReconcileKeysWrite,
+			},
+		},
+
+		HelpSynopsis:    pathConfigReconcileKeysHelpSyn,
+		HelpDescription: pathConfigReconcileKeysHelpDesc,
+	}
+}
+
+func (b *backend) pathConfigReconcileKeysWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	// Key leases are listed by role set name, which covers keys of orphaned
+	// role sets as well as of current ones.
+	rsNames, err := req.Storage.List(ctx, keyLeaseStoragePrefix+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	iamAdmin, err := b.IAMAdminClient(req.Storage)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	resp := &logical.Response{}
+	checked, total := 0, 0
+	rolesets := make(map[string]interface{})
+	for _, rsName := range rsNames {
+		rsName = strings.TrimSuffix(rsName, "/")
+		leases, err := listKeyLeases(ctx, req.Storage, rsName)
+		if err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("unable to list key leases of role set '%s': {{err}}", rsName), err)
+		}
+
+		missing := make([]string, 0)
+		for _, kl := range leases {
+			checked++
+			gone, err := keyIsGone(ctx, iamAdmin, kl.KeyName)
+			if err != nil {
+				resp.AddWarning(fmt.Sprintf("unable to look up key %s of role set '%s': %v", kl.KeyName, rsName, b.withGoogleRequestID(req.Path, err)))
+				continue
+			}
+			if !gone {
+				continue
+			}
+
+			// The lease can no longer be renewed into the rotated key, so
+			// don't leave that behind either.
+			if err := b.deletePendingRotatedKey(ctx, req.Storage, rsName, kl.KeyName); err != nil {
+				resp.AddWarning(fmt.Sprintf("unable to delete undelivered rotated key %s of missing key %s: %v", kl.PendingKeyName, kl.KeyName, b.withGoogleRequestID(req.Path, err)))
+				continue
+			}
+			if err := deleteKeyLease(ctx, req.Storage, rsName, kl.KeyName); err != nil {
+				return nil, errwrap.Wrapf("unable to delete key lease: {{err}}", err)
+			}
+			b.Logger().Info("stopped tracking service account key deleted outside of Vault", "key_name", kl.KeyName, "roleset", rsName)
+
+			missing = append(missing, kl.KeyName)
+		}
+		if len(missing) == 0 {
+			continue
+		}
+
+		total += len(missing)
+		rolesets[rsName] = map[string]interface{}{
+			"reconciled": len(missing),
+			"key_names":  missing,
+		}
+		if err := b.cleanupOrphanedRoleSet(ctx, req.Storage, rsName); err != nil {
+			b.Logger().Warn("unable to clean up orphaned role set", "roleset", rsName, "error", err)
+		}
+	}
+
+	if total > 0 {
+		resp.AddWarning("the missing keys are no longer tracked, but their Vault leases remain until they expire or are revoked with sys/leases/revoke")
+	}
+
+	resp.Data = map[string]interface{}{
+		"checked":    checked,
+		"reconciled": total,
+		"rolesets":   rolesets,
+	}
+	return resp, nil
+}
+
+// keyIsGone returns whether the service account key no longer exists. GCP
+// answers 403 for keys of deleted service accounts, but also when the
+// credentials may not read the key, so a 403 only counts if the service
+// account itself is gone.
+func keyIsGone(ctx context.Context, iamAdmin *iam.Service, keyName string) (bool, error) {
+	_, err := iamAdmin.Projects.ServiceAccounts.Keys.Get(keyName).Context(ctx).Do()
+	switch {
+	case err == nil:
+		return false, nil
+	case isGoogleApiErrorWithCodes(err, http.StatusNotFound):
+		return true, nil
+	case !isGoogleAccountKeyNotFoundErr(err):
+		return false, err
+	}
+
+	saName := path.Dir(path.Dir(keyName))
+	if _, saErr := iamAdmin.Projects.ServiceAccounts.Get(saName).Context(ctx).Do(); isGoogleApiErrorWithCodes(saErr, http.StatusNotFound) {
+		return true, nil
+	}
+	return false, err
+}
+
+const pathConfigReconcileKeysHelpSyn = `
+Stop tracking service account keys that were deleted outside of Vault
+`
+
+const pathConfigReconcileKeysHelpDesc = `
+Keys issued as secrets may be deleted outside of Vault, such as in the GCP
+console, leaving leases behind for keys that no longer exist. This path
+looks up every key tracked by the backend, including keys of role sets
+deleted with key_cleanup_on_delete=expire, and stops tracking the keys that
+GCP reports as not found, along with deleting any replacement key created
+by rotation that hasn't been delivered yet. Role sets kept only for such
+keys are then cleaned up, and the missing keys no longer count toward
+max_active_key_leases or block the deletion of their role set.
+
+It returns the number of keys looked up ("checked") and of missing keys
+("reconciled"), and for each role set with missing keys their number and
+names ("rolesets"). The backend can't revoke Vault leases, and doesn't
+know their IDs, so the leases of missing keys expire on their own;
+revoking them with sys/leases/revoke does nothing else. Keys that can't be looked up are reported
+as warnings and left tracked, so the request can be repeated; a key the
+configured credentials may not read only counts as missing if its service
+account no longer exists either.
+`
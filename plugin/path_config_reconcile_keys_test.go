@@ -0,0 +1,87 @@
+package gcpsecrets
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestConfigReconcileKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var saName string
+	b, storage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		name := strings.TrimPrefix(r.URL.Path, "/v1/")
+		switch {
+		case r.Method != http.MethodGet:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case name == saName+"/keys/present", name == saName:
+			w.Write([]byte(`{}`))
+		case name == saName+"/keys/deleted":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": 404, "message": "not found"}}`))
+		default:
+			// Unreadable keys, whose service account still exists.
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error": {"code": 403, "message": "permission denied"}}`))
+		}
+	}))
+	rs := testStoredKeyRoleSet(t, storage, "test-reconcilekeys")
+	saName = rs.AccountId.ResourceName()
+
+	leases := []*keyLease{
+		{RoleSet: rs.Name, KeyName: saName + "/keys/present"},
+		{RoleSet: rs.Name, KeyName: saName + "/keys/deleted"},
+		{RoleSet: rs.Name, KeyName: saName + "/keys/forbidden"},
+	}
+	for _, kl := range leases {
+		if err := kl.save(ctx, storage); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/reconcile-keys",
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+
+	if resp.Data["checked"] != 3 || resp.Data["reconciled"] != 1 {
+		t.Fatalf("expected 3 keys checked and 1 reconciled, got %v", resp.Data)
+	}
+	expected := map[string]interface{}{
+		rs.Name: map[string]interface{}{
+			"reconciled": 1,
+			"key_names":  []string{saName + "/keys/deleted"},
+		},
+	}
+	if !reflect.DeepEqual(resp.Data["rolesets"], expected) {
+		t.Fatalf("expected %v, got %v", expected, resp.Data["rolesets"])
+	}
+	// The unreadable key is warned about, along with the remaining lease.
+	if len(resp.Warnings) != 2 {
+		t.Fatalf("expected 2 warnings, got %v", resp.Warnings)
+	}
+
+	for key, kept := range map[string]bool{"present": true, "deleted": false, "forbidden": true} {
+		kl, err := getKeyLease(ctx, storage, rs.Name, saName+"/keys/"+key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (kl != nil) != kept {
+			t.Errorf("expected lease of key %q kept: %t, got %v", key, kept, kl)
+		}
+	}
+}
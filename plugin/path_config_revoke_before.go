@@ -0,0 +1,168 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathConfigRevokeBefore(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/revoke-before",
+		Fields: map[string]*framework.FieldSchema{
+			"before": {
+				Type:        framework.TypeString,
+				Description: `Required. RFC 3339 timestamp, such as "2020-01-02T15:04:05Z". Keys issued before it are deleted.`,
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathConfigRevokeBeforeWrite,
+			},
+		},
+
+		HelpSynopsis:    pathConfigRevokeBeforeHelpSyn,
+		HelpDescription: pathConfigRevokeBeforeHelpDesc,
+	}
+}
+
+func (b *backend) pathConfigRevokeBeforeWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	beforeRaw := d.Get("before").(string)
+	if beforeRaw == "" {
+		return logical.ErrorResponse("before is required"), nil
+	}
+	before, err := time.Parse(time.RFC3339, beforeRaw)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid before %q, must be an RFC 3339 timestamp: %v", beforeRaw, err)), nil
+	}
+
+	// Key leases are listed by role set name, which covers keys of orphaned
+	// role sets as well as of current ones.
+	rsNames, err := req.Storage.List(ctx, keyLeaseStoragePrefix+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	iamAdmin, err := b.IAMAdminClient(req.Storage)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	resp := &logical.Response{}
+	total := 0
+	rolesets := make(map[string]interface{})
+	for _, rsName := range rsNames {
+		rsName = strings.TrimSuffix(rsName, "/")
+		leases, err := listKeyLeases(ctx, req.Storage, rsName)
+		if err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("unable to list key leases of role set '%s': {{err}}", rsName), err)
+		}
+
+		revoked := make([]string, 0)
+		for _, kl := range leases {
+			if !kl.IssueTime.Before(before) {
+				continue
+			}
+			if err := b.deletePendingRotatedKey(ctx, req.Storage, rsName, kl.KeyName); err != nil {
+				resp.AddWarning(fmt.Sprintf("unable to delete undelivered rotated key %s of key %s: %v", kl.PendingKeyName, kl.KeyName, b.withGoogleRequestID(req.Path, err)))
+				continue
+			}
+			_, err := iamAdmin.Projects.ServiceAccounts.Keys.Delete(kl.KeyName).Context(ctx).Do()
+			if err != nil && !isGoogleAccountKeyNotFoundErr(err) {
+				resp.AddWarning(fmt.Sprintf("unable to delete key %s of role set '%s': %v", kl.KeyName, rsName, b.withGoogleRequestID(req.Path, err)))
+				continue
+			}
+			if err := deleteKeyLease(ctx, req.Storage, rsName, kl.KeyName); err != nil {
+				return nil, errwrap.Wrapf("unable to delete key lease: {{err}}", err)
+			}
+			b.Logger().Info("deleted service account key issued before cutoff", "key_name", kl.KeyName, "roleset", rsName, "issue_time", kl.IssueTime)
+
+			revoked = append(revoked, kl.KeyName)
+		}
+		if len(revoked) == 0 {
+			continue
+		}
+
+		total += len(revoked)
+		rolesets[rsName] = map[string]interface{}{
+			"revoked":   len(revoked),
+			"key_names": revoked,
+		}
+		if err := b.cleanupOrphanedRoleSet(ctx, req.Storage, rsName); err != nil {
+			b.Logger().Warn("unable to clean up orphaned role set", "roleset", rsName, "error", err)
+		}
+	}
+
+	nonRevocable, err := tokenRoleSetsIssuingBefore(ctx, req.Storage, before, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if len(nonRevocable) > 0 {
+		resp.AddWarning(fmt.Sprintf("access tokens issued before the cutoff by role sets %s can't be revoked and remain valid until they expire, at the latest %s", strings.Join(nonRevocable, ", "), before.Add(gcpMaxAccessTokenTTL).UTC().Format(time.RFC3339)))
+	}
+	if total > 0 {
+		resp.AddWarning("the keys are deleted, but their Vault leases remain until they expire or are revoked with sys/leases/revoke")
+	}
+
+	resp.Data = map[string]interface{}{
+		"before":                       before.UTC().Format(time.RFC3339),
+		"revoked":                      total,
+		"rolesets":                     rolesets,
+		"non_revocable_token_rolesets": nonRevocable,
+	}
+	return resp, nil
+}
+
+// tokenRoleSetsIssuingBefore returns the names of access token role sets
+// whose tokens issued before the cutoff may still be valid at now.
+func tokenRoleSetsIssuingBefore(ctx context.Context, s logical.Storage, before, now time.Time) ([]string, error) {
+	names := make([]string, 0)
+	if !before.Add(gcpMaxAccessTokenTTL).After(now) {
+		return names, nil
+	}
+
+	rsNames, err := s.List(ctx, fmt.Sprintf("%s/", rolesetStoragePrefix))
+	if err != nil {
+		return nil, err
+	}
+	for _, rsName := range rsNames {
+		rs, err := getRoleSet(rsName, ctx, s)
+		if err != nil {
+			return nil, err
+		}
+		if rs != nil && rs.SecretType == SecretTypeAccessToken {
+			names = append(names, rs.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+const pathConfigRevokeBeforeHelpSyn = `
+Delete every service account key issued before a cutoff time
+`
+
+const pathConfigRevokeBeforeHelpDesc = `
+After a compromise, this path deletes every service account key issued as a
+secret by this backend before "before", an RFC 3339 timestamp, along with
+any replacement key created by rotation that hasn't been delivered yet.
+Keys of role sets deleted with key_cleanup_on_delete=expire are included.
+
+It returns the number of keys deleted ("revoked"), and for each role set
+with deleted keys their number and names ("rolesets"). The keys stop
+working right away, but the backend can't revoke Vault leases, and doesn't
+know their IDs, so the leases expire on their own; revoking them with
+sys/leases/revoke does nothing else. Keys that can't be deleted are
+reported as warnings and left tracked, so the request can be repeated.
+
+Access tokens can't be revoked. If tokens issued before the cutoff may
+still be valid, the access token role sets that issued them are listed in
+"non_revocable_token_rolesets" and in a warning with the latest time they
+expire.
+`
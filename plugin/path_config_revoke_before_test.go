@@ -0,0 +1,83 @@
+package gcpsecrets
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestConfigRevokeBefore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var l sync.Mutex
+	var deleted []string
+	b, storage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodDelete {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		l.Lock()
+		deleted = append(deleted, r.URL.Path[len("/v1/"):])
+		l.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte("{}"))
+	}))
+	rs := testStoredKeyRoleSet(t, storage, "test-revokebefore")
+	saName := rs.AccountId.ResourceName()
+
+	cutoff := time.Now().UTC().Add(-time.Hour)
+	leases := []*keyLease{
+		{RoleSet: rs.Name, KeyName: saName + "/keys/old", IssueTime: cutoff.Add(-time.Minute)},
+		{RoleSet: rs.Name, KeyName: saName + "/keys/new", IssueTime: cutoff.Add(time.Minute)},
+	}
+	for _, kl := range leases {
+		if err := kl.save(ctx, storage); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/revoke-before",
+		Data:      map[string]interface{}{"before": cutoff.Format(time.RFC3339)},
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+
+	if resp.Data["revoked"] != 1 {
+		t.Fatalf("expected one key revoked, got %v", resp.Data["revoked"])
+	}
+	if !reflect.DeepEqual(deleted, []string{saName + "/keys/old"}) {
+		t.Fatalf("expected only the old key to be deleted, got %v", deleted)
+	}
+	if kl, err := getKeyLease(ctx, storage, rs.Name, saName+"/keys/old"); err != nil || kl != nil {
+		t.Fatalf("expected old key lease to be deleted, got %v, %v", kl, err)
+	}
+	if kl, err := getKeyLease(ctx, storage, rs.Name, saName+"/keys/new"); err != nil || kl == nil {
+		t.Fatalf("expected new key lease to be kept, got %v, %v", kl, err)
+	}
+
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/revoke-before",
+		Data:      map[string]interface{}{"before": "yesterday"},
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error response for invalid timestamp, got %#v", resp)
+	}
+}
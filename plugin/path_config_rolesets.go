@@ -0,0 +1,308 @@
+package gcpsecrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/util"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// importableRoleSetFields are the role set fields included in exported
+// definitions and accepted on import. Everything else about a role set, such
+// as its service account and keys, is created on import.
+var importableRoleSetFields = map[string]bool{
+	"name":                true,
+	"project":             true,
+	"secret_type":         true,
+	"bindings":            true,
+	"token_scopes":        true,
+	"token_creators":      true,
+	"max_token_ttl":       true,
+	"default_audience":    true,
+	"allowed_audiences":   true,
+	"key_rotation_period": true,
+	"key_renewable":       true,
+}
+
+func pathConfigExportRoleSets(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/export-rolesets",
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathConfigExportRoleSetsRead,
+			},
+		},
+
+		HelpSynopsis:    pathConfigExportRoleSetsHelpSyn,
+		HelpDescription: pathConfigExportRoleSetsHelpDesc,
+	}
+}
+
+func pathConfigImportRoleSets(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/import-rolesets",
+		Fields: map[string]*framework.FieldSchema{
+			"rolesets": {
+				Type:        framework.TypeString,
+				Description: `Required. JSON list of role set definitions, as returned in "rolesets" by config/export-rolesets.`,
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathConfigImportRoleSets,
+			},
+		},
+
+		HelpSynopsis:    pathConfigImportRoleSetsHelpSyn,
+		HelpDescription: pathConfigImportRoleSetsHelpDesc,
+	}
+}
+
+func (b *backend) pathConfigExportRoleSetsRead(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	rsNames, err := req.Storage.List(ctx, fmt.Sprintf("%s/", rolesetStoragePrefix))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(rsNames)
+
+	defs := make([]map[string]interface{}, 0, len(rsNames))
+	for _, rsName := range rsNames {
+		rs, err := getRoleSet(rsName, ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if rs == nil {
+			continue
+		}
+		defs = append(defs, roleSetDefinition(rs))
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"rolesets": defs,
+		},
+	}, nil
+}
+
+// roleSetDefinition returns the declarative definition of the role set, in
+// the form accepted by config/import-rolesets.
+func roleSetDefinition(rs *RoleSet) map[string]interface{} {
+	def := map[string]interface{}{
+		"name":        rs.Name,
+		"secret_type": rs.SecretType,
+		"bindings":    bindingsHCL(rs.Bindings, rs.BindingMembers),
+	}
+	if rs.AccountId != nil {
+		def["project"] = rs.project()
+	}
+	if rs.TokenGen != nil && rs.SecretType == SecretTypeAccessToken {
+		def["token_scopes"] = rs.TokenGen.Scopes
+	}
+	if len(rs.TokenCreators) > 0 {
+		def["token_creators"] = rs.TokenCreators
+	}
+	if rs.MaxTokenTTL > 0 {
+		def["max_token_ttl"] = int64(rs.MaxTokenTTL / time.Second)
+	}
+	if rs.DefaultAudience != "" {
+		def["default_audience"] = rs.DefaultAudience
+	}
+	if len(rs.AllowedAudiences) > 0 {
+		def["allowed_audiences"] = rs.AllowedAudiences
+	}
+	if rs.KeyRotationPeriod > 0 {
+		def["key_rotation_period"] = int64(rs.KeyRotationPeriod / time.Second)
+	}
+	if rs.DisableKeyRenewal {
+		def["key_renewable"] = false
+	}
+	return def
+}
+
+// bindingsHCL formats bindings and their additional members as HCL, with
+// resources, roles and members sorted.
+func bindingsHCL(rb ResourceBindings, members map[string]util.StringSet) string {
+	var buf bytes.Buffer
+	for _, rName := range sortedResourceNames(rb) {
+		roles := rb[rName].ToSlice()
+		sort.Strings(roles)
+		quoted := make([]string, len(roles))
+		for i, role := range roles {
+			quoted[i] = strconv.Quote(role)
+		}
+
+		fmt.Fprintf(&buf, "resource %s {\n", strconv.Quote(rName))
+		fmt.Fprintf(&buf, "  roles = [%s]\n", strings.Join(quoted, ", "))
+		if ms := members[rName]; len(ms) > 0 {
+			list := ms.ToSlice()
+			sort.Strings(list)
+			quotedMembers := make([]string, len(list))
+			for i, m := range list {
+				quotedMembers[i] = strconv.Quote(m)
+			}
+			fmt.Fprintf(&buf, "  members = [%s]\n", strings.Join(quotedMembers, ", "))
+		}
+		buf.WriteString("}\n")
+	}
+	return buf.String()
+}
+
+func sortedResourceNames(rb ResourceBindings) []string {
+	names := make([]string, 0, len(rb))
+	for rName := range rb {
+		names = append(names, rName)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (b *backend) pathConfigImportRoleSets(ctx context.Context, req *logical.Request, data *framework.FieldData) (*logical.Response, error) {
+	raw := data.Get("rolesets").(string)
+	if raw == "" {
+		return logical.ErrorResponse("rolesets is required"), nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader([]byte(raw)))
+	dec.UseNumber()
+	var defs []map[string]interface{}
+	if err := dec.Decode(&defs); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("unable to parse rolesets JSON: %v", err)), nil
+	}
+
+	// Check every definition before importing any.
+	seen := make(map[string]bool, len(defs))
+	for i, def := range defs {
+		name, _ := def["name"].(string)
+		if name == "" {
+			return logical.ErrorResponse(fmt.Sprintf("role set %d has no name", i)), nil
+		}
+		if seen[name] {
+			return logical.ErrorResponse(fmt.Sprintf("role set '%s' is defined more than once", name)), nil
+		}
+		seen[name] = true
+		for field := range def {
+			if !importableRoleSetFields[field] {
+				return logical.ErrorResponse(fmt.Sprintf("role set '%s' has unknown field %q", name, field)), nil
+			}
+		}
+	}
+
+	created := make([]string, 0)
+	updated := make([]string, 0)
+	failed := make(map[string]string)
+	var warnings []string
+	for _, def := range defs {
+		name := def["name"].(string)
+		op, resp, err := b.importRoleSet(ctx, req.Storage, def)
+		if err != nil {
+			return nil, errwrap.Wrapf(fmt.Sprintf("unable to import role set '%s': {{err}}", name), err)
+		}
+		if resp != nil && resp.IsError() {
+			failed[name] = resp.Error().Error()
+			continue
+		}
+		if resp != nil {
+			for _, w := range resp.Warnings {
+				warnings = append(warnings, fmt.Sprintf("role set '%s': %s", name, w))
+			}
+		}
+		if op == logical.CreateOperation {
+			created = append(created, name)
+		} else {
+			updated = append(updated, name)
+		}
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"created": created,
+			"updated": updated,
+			"failed":  failed,
+		},
+		Warnings: warnings,
+	}, nil
+}
+
+// importRoleSet creates or updates a role set from its definition, going
+// through the same validation as writes to roleset/:name.
+func (b *backend) importRoleSet(ctx context.Context, s logical.Storage, def map[string]interface{}) (logical.Operation, *logical.Response, error) {
+	name := def["name"].(string)
+	existing, err := getRoleSet(name, ctx, s)
+	if err != nil {
+		return "", nil, err
+	}
+
+	raw := make(map[string]interface{}, len(def))
+	for k, v := range def {
+		raw[k] = v
+	}
+
+	op := logical.CreateOperation
+	if existing != nil {
+		op = logical.UpdateOperation
+
+		// Bindings are compared by their raw text on update, so bindings
+		// that are equivalent but formatted differently would needlessly
+		// replace the service account.
+		if bRaw, ok := raw["bindings"].(string); ok {
+			binds, members, err := util.ParseBindingsWithMembers(bRaw)
+			if err == nil && reflect.DeepEqual(ResourceBindings(binds), existing.Bindings) && sameBindingMembers(members, existing.BindingMembers) {
+				delete(raw, "bindings")
+			}
+		}
+	}
+
+	fd := &framework.FieldData{
+		Raw:    raw,
+		Schema: pathRoleSet(b).Fields,
+	}
+	resp, err := b.pathRoleSetCreateUpdate(ctx, &logical.Request{
+		Operation: op,
+		Path:      fmt.Sprintf("%s/%s", rolesetStoragePrefix, name),
+		Storage:   s,
+	}, fd)
+	return op, resp, err
+}
+
+const pathConfigExportRoleSetsHelpSyn = `
+Export the definitions of all role sets, for import with config/import-rolesets.
+`
+
+const pathConfigExportRoleSetsHelpDesc = `
+This endpoint returns the declarative definition of every role set in
+"rolesets": its name, project, secret_type, bindings (as HCL), and any
+token_scopes, token_creators, max_token_ttl, default_audience,
+allowed_audiences, key_rotation_period and key_renewable. Service accounts,
+keys and other secrets are not included.
+
+The list can be passed as-is, as JSON, to config/import-rolesets on this or
+another mount to back up, restore or promote role sets between environments.
+`
+
+const pathConfigImportRoleSetsHelpSyn = `
+Create or update role sets from definitions exported by config/export-rolesets.
+`
+
+const pathConfigImportRoleSetsHelpDesc = `
+This endpoint takes "rolesets", a JSON list of role set definitions as
+returned by config/export-rolesets, and writes each one as if it were
+written to roleset/:name. Role sets that don't exist are created, with new
+service accounts; existing role sets are updated, and their service accounts
+are only replaced if their bindings changed.
+
+All definitions are checked for unknown fields and duplicate names before
+any is imported. Role sets that fail to import are reported in "failed"
+with their errors and don't stop the others from being imported.
+`
@@ -0,0 +1,211 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/go-gcp-common/gcputil"
+	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/util"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/iam/v1"
+)
+
+// adminCapability is something the backend's credentials need to be able to
+// do for some secret types, checked through the IAM permissions it requires
+// on a project.
+type adminCapability struct {
+	name        string
+	permissions []string
+	secretTypes string
+}
+
+var adminCapabilities = []adminCapability{
+	{
+		name: "create_keys",
+		permissions: []string{
+			"iam.serviceAccounts.create",
+			"iam.serviceAccountKeys.create",
+			"iam.serviceAccountKeys.delete",
+		},
+		secretTypes: fmt.Sprintf("'%s' and '%s' role sets", SecretTypeKey, SecretTypeAccessToken),
+	},
+	{
+		name: "apply_bindings",
+		permissions: []string{
+			"resourcemanager.projects.getIamPolicy",
+			"resourcemanager.projects.setIamPolicy",
+		},
+		secretTypes: "role sets bound to the project",
+	},
+	{
+		name: "impersonate",
+		permissions: []string{
+			"iam.serviceAccounts.getAccessToken",
+			"iam.serviceAccounts.signBlob",
+			"iam.serviceAccounts.signJwt",
+			"iam.serviceAccounts.getOpenIdToken",
+		},
+		secretTypes: "sign/, identity-token/ and downscoped token paths, and checking role set permissions",
+	},
+}
+
+func pathConfigStatus(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/status",
+		Fields: map[string]*framework.FieldSchema{
+			"project": {
+				Type:        framework.TypeString,
+				Description: "Project to check permissions on. Defaults to the project of the configured credentials.",
+			},
+		},
+
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathConfigStatusRead,
+			},
+		},
+
+		HelpSynopsis:    pathConfigStatusHelpSyn,
+		HelpDescription: pathConfigStatusHelpDesc,
+	}
+}
+
+func (b *backend) pathConfigStatusRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	project := d.Get("project").(string)
+	if project == "" {
+		creds, err := b.credentials(req.Storage)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("unable to load credentials: %v", err)), nil
+		}
+		project = creds.ProjectID
+	}
+	if project == "" {
+		return logical.ErrorResponse("project is required, the configured credentials do not name one"), nil
+	}
+
+	var permissions []string
+	for _, c := range adminCapabilities {
+		permissions = append(permissions, c.permissions...)
+	}
+
+	crmC, err := b.ResourceManagerClient(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	tResp, err := crmC.Projects.TestIamPermissions(project, &cloudresourcemanager.TestIamPermissionsRequest{
+		Permissions: permissions,
+	}).Context(ctx).Do()
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("unable to test permissions on project %q: %v", project, b.withGoogleRequestID(req.Path, err))), nil
+	}
+
+	granted := util.ToSet(tResp.Permissions)
+	denied := util.ToSet(permissions).Sub(granted).ToSlice()
+	sort.Strings(tResp.Permissions)
+	sort.Strings(denied)
+
+	resp := &logical.Response{}
+	capabilities := make(map[string]interface{}, len(adminCapabilities))
+	for _, c := range adminCapabilities {
+		var missing []string
+		for _, p := range c.permissions {
+			if !granted.Includes(p) {
+				missing = append(missing, p)
+			}
+		}
+		capabilities[c.name] = len(missing) == 0
+		if len(missing) > 0 {
+			resp.AddWarning(fmt.Sprintf("credentials lack %s on project %q, needed for %s", strings.Join(missing, ", "), project, c.secretTypes))
+		}
+	}
+
+	resp.Data = map[string]interface{}{
+		"project":             project,
+		"capabilities":        capabilities,
+		"denied_permissions":  denied,
+		"granted_permissions": tResp.Permissions,
+	}
+
+	health, err := b.credentialsHealth(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if len(health) > 0 {
+		resp.Data["credentials"] = health
+		for i, h := range health {
+			if !h["healthy"].(bool) {
+				resp.AddWarning(fmt.Sprintf("%s (%s) can't get an access token: %s", credentialsLabel(i), h["client_email"], h["error"]))
+			}
+		}
+	}
+	return resp, nil
+}
+
+// credentialsHealth tries to get an access token with each of the primary
+// and fallback credentials, in order, if fallback credentials are
+// configured. It returns nothing otherwise, as the primary credentials are
+// already exercised by the permission check.
+func (b *backend) credentialsHealth(ctx context.Context, s logical.Storage) ([]map[string]interface{}, error) {
+	cfg, err := getConfig(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil || len(cfg.FallbackCredentialsRaw) == 0 {
+		return nil, nil
+	}
+
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, cfg.baseHTTPClient())
+	raws := append([]string{cfg.CredentialsRaw}, cfg.FallbackCredentialsRaw...)
+	health := make([]map[string]interface{}, 0, len(raws))
+	for _, raw := range raws {
+		h := map[string]interface{}{
+			"client_email": "",
+			"healthy":      false,
+		}
+		var creds *google.Credentials
+		if raw == "" {
+			h["client_email"] = "default application credentials"
+			creds, err = google.FindDefaultCredentials(ctx, iam.CloudPlatformScope)
+		} else {
+			if parsed, err := gcputil.Credentials(raw); err == nil {
+				h["client_email"] = parsed.ClientEmail
+			}
+			creds, err = google.CredentialsFromJSON(ctx, []byte(raw), iam.CloudPlatformScope)
+		}
+		if err == nil {
+			_, err = creds.TokenSource.Token()
+		}
+		if err != nil {
+			h["error"] = err.Error()
+		} else {
+			h["healthy"] = true
+		}
+		health = append(health, h)
+	}
+	return health, nil
+}
+
+const pathConfigStatusHelpSyn = `
+Report which operations the configured GCP credentials are permitted to do
+`
+
+const pathConfigStatusHelpDesc = `
+This path calls testIamPermissions on a project as the backend's credentials,
+and reports whether they can create service accounts and keys ("create_keys"),
+apply IAM bindings ("apply_bindings"), and act as service accounts to
+generate tokens and signatures ("impersonate"). Missing permissions are
+listed in "denied_permissions" and as warnings naming the secret types that
+need them, so operators can tell which role sets the backend can support
+before configuring them.
+
+"project" defaults to the project of the configured credentials. Only
+permissions granted on the project itself or inherited by it are seen;
+permissions granted on individual service accounts or other resources are
+not, so a denied permission may still be available for some resources.
+`
@@ -0,0 +1,80 @@
+package gcpsecrets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/cloudresourcemanager/v1"
+)
+
+func TestConfigStatus(t *testing.T) {
+	t.Parallel()
+
+	b, storage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/projects/my-project:testIamPermissions" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var req cloudresourcemanager.TestIamPermissionsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// Token creator, but no key admin.
+		var granted []string
+		for _, p := range req.Permissions {
+			switch p {
+			case "iam.serviceAccountKeys.create", "iam.serviceAccountKeys.delete":
+			default:
+				granted = append(granted, p)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&cloudresourcemanager.TestIamPermissionsResponse{Permissions: granted})
+	}))
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config/status",
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error without a project, got %#v", resp)
+	}
+
+	resp, err = b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config/status",
+		Data:      map[string]interface{}{"project": "my-project"},
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+
+	expected := map[string]interface{}{
+		"create_keys":    false,
+		"apply_bindings": true,
+		"impersonate":    true,
+	}
+	if !reflect.DeepEqual(resp.Data["capabilities"], expected) {
+		t.Errorf("expected capabilities %v, got %v", expected, resp.Data["capabilities"])
+	}
+	expectedDenied := []string{"iam.serviceAccountKeys.create", "iam.serviceAccountKeys.delete"}
+	if !reflect.DeepEqual(resp.Data["denied_permissions"], expectedDenied) {
+		t.Errorf("expected denied permissions %v, got %v", expectedDenied, resp.Data["denied_permissions"])
+	}
+	if len(resp.Warnings) != 1 {
+		t.Errorf("expected a warning for the missing capability, got %v", resp.Warnings)
+	}
+}
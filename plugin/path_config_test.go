@@ -2,10 +2,16 @@ package gcpsecrets
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/util"
 	"github.com/hashicorp/vault/sdk/helper/jsonutil"
 	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/iam/v1"
 )
 
 func TestConfig(t *testing.T) {
@@ -33,8 +39,58 @@ func TestConfig(t *testing.T) {
 	})
 
 	expected := map[string]interface{}{
-		"ttl":     int64(0),
-		"max_ttl": int64(0),
+		"ttl":                           int64(0),
+		"max_ttl":                       int64(0),
+		"effective_ttl":                 int64(defaultLeaseTTLHr * 3600),
+		"effective_max_ttl":             int64(maxLeaseTTLHr * 3600),
+		"ttl_source":                    ttlSourceMount,
+		"max_ttl_source":                ttlSourceMount,
+		"revocation_policy":             revocationPolicyStrict,
+		"renew_past_max_ttl":            renewPastMaxTTLClamp,
+		"cap_ttl_to_token":              false,
+		"key_revocation_grace":          int64(0),
+		"max_binding_retries":           defaultMaxBindingRetries,
+		"service_account_suffix_length": defaultServiceAccountSuffixLen,
+		"service_account_id_attempts":   defaultServiceAccountIdAttempts,
+		"reconcile_interval":            int64(0),
+		"reap_untracked_keys":           false,
+		"untracked_key_max_age":         int64(0),
+		"max_active_key_leases":         0,
+		"cache_tokens":                  false,
+		"prefetch_tokens":               false,
+		"shared_token_cache":            false,
+		"coalesce_token_requests":       false,
+		"fallback_client_emails":        []string{},
+		"token_expiry_alignment":        int64(0),
+		"disable_metrics_roleset_label": false,
+		"disable_sa_on_delete":          false,
+		"sa_deletion_delay":             int64(0),
+		"reject_disabled_sa_keys":       true,
+		"sa_name_template":              "",
+		"permission_denied_template":    defaultPermissionDeniedTemplate,
+		"warn_broad_scopes":             false,
+		"require_narrow_scopes":         false,
+		"empty_scopes_behavior":         emptyScopesReject,
+		"required_scopes":               []string(nil),
+		"default_secret_type":           SecretTypeAccessToken,
+		"deny_self_escalating_bindings": false,
+		"warn_unmatched_scopes":         false,
+		"api_timeout":                   int64(defaultAPITimeout / time.Second),
+		"auto_enable_apis":              false,
+		"gcp_log_level":                 gcpLogLevelOff,
+		"sa_fallback_projects":          []string(nil),
+		"project_denylist":              []string(nil),
+		"project_allowlist":             []string(nil),
+		"wildcard_project_parent":       "",
+		"key_cleanup_on_delete":         keyCleanupRevoke,
+		"http_proxy":                    "",
+		"https_proxy":                   "",
+		"credentials_endpoint":          defaultCredentialsEndpoint,
+		"max_bindings_per_roleset":      defaultMaxBindingsPerRoleSet,
+		"binding_removal_rate":          0,
+		"binding_approval_webhook":      "",
+		"binding_approval_timeout":      int64(defaultBindingApprovalTimeout / time.Second),
+		"binding_approval_fail_open":    false,
 	}
 
 	testConfigRead(t, b, reqStorage, expected)
@@ -43,7 +99,139 @@ func TestConfig(t *testing.T) {
 	})
 
 	expected["ttl"] = int64(50)
+	expected["effective_ttl"] = int64(50)
+	expected["ttl_source"] = ttlSourceConfig
 	testConfigRead(t, b, reqStorage, expected)
+
+	testConfigUpdate(t, b, reqStorage, map[string]interface{}{
+		"ttl":     "2h",
+		"max_ttl": "1h",
+	})
+
+	expected["ttl"] = int64(7200)
+	expected["max_ttl"] = int64(3600)
+	expected["effective_ttl"] = int64(3600)
+	expected["effective_max_ttl"] = int64(3600)
+	expected["max_ttl_source"] = ttlSourceConfig
+	testConfigRead(t, b, reqStorage, expected)
+
+	testConfigUpdate(t, b, reqStorage, map[string]interface{}{
+		"revocation_policy": revocationPolicyBestEffort,
+	})
+
+	expected["revocation_policy"] = revocationPolicyBestEffort
+	testConfigRead(t, b, reqStorage, expected)
+
+	testConfigUpdate(t, b, reqStorage, map[string]interface{}{
+		"key_revocation_grace": "90s",
+	})
+
+	expected["key_revocation_grace"] = int64(90)
+	testConfigRead(t, b, reqStorage, expected)
+
+	testConfigUpdate(t, b, reqStorage, map[string]interface{}{
+		"max_binding_retries": 10,
+	})
+
+	expected["max_binding_retries"] = 10
+	testConfigRead(t, b, reqStorage, expected)
+
+	testConfigUpdate(t, b, reqStorage, map[string]interface{}{
+		"service_account_suffix_length": 6,
+	})
+
+	expected["service_account_suffix_length"] = 6
+	testConfigRead(t, b, reqStorage, expected)
+
+	testConfigUpdate(t, b, reqStorage, map[string]interface{}{
+		"reconcile_interval": "1h",
+	})
+
+	expected["reconcile_interval"] = int64(3600)
+	testConfigRead(t, b, reqStorage, expected)
+
+	testConfigUpdate(t, b, reqStorage, map[string]interface{}{
+		"reap_untracked_keys":   true,
+		"untracked_key_max_age": "168h",
+	})
+
+	expected["reap_untracked_keys"] = true
+	expected["untracked_key_max_age"] = int64(168 * 3600)
+	testConfigRead(t, b, reqStorage, expected)
+
+	testConfigUpdate(t, b, reqStorage, map[string]interface{}{
+		"cache_tokens": true,
+	})
+
+	expected["cache_tokens"] = true
+	testConfigRead(t, b, reqStorage, expected)
+
+	testConfigUpdate(t, b, reqStorage, map[string]interface{}{
+		"sa_name_template": "svc-{{roleset}}-{{random}}",
+	})
+
+	expected["sa_name_template"] = "svc-{{roleset}}-{{random}}"
+	testConfigRead(t, b, reqStorage, expected)
+
+	testConfigUpdate(t, b, reqStorage, map[string]interface{}{
+		"api_timeout": "15s",
+	})
+
+	expected["api_timeout"] = int64(15)
+	testConfigRead(t, b, reqStorage, expected)
+}
+
+func TestConfig_InvalidValues(t *testing.T) {
+	t.Parallel()
+
+	b, reqStorage := getTestBackend(t)
+
+	cases := map[string]interface{}{
+		"revocation_policy":             "sometimes",
+		"renew_past_max_ttl":            "extend",
+		"credentials_endpoint":          "http://iamcredentials.googleapis.com/",
+		"max_binding_retries":           0,
+		"service_account_suffix_length": maxServiceAccountSuffixLen + 1,
+		"service_account_id_attempts":   0,
+		"max_active_key_leases":         -1,
+		"reap_untracked_keys":           true,
+		"sa_name_template":              "{{roleset}}-{{random}}-{{bogus}}",
+		"permission_denied_template":    "{{resource}} {{runbook}}",
+		"api_timeout":                   0,
+		"gcp_log_level":                 "trace",
+		"sa_fallback_projects":          "overflow-project,x",
+		"project_denylist":              "prod-project,Not_A_Project",
+		"project_allowlist":             "x",
+		"wildcard_project_parent":       "folders/team-a",
+		"key_cleanup_on_delete":         "never",
+		"http_proxy":                    "proxy.internal:3128",
+		"https_proxy":                   "http://",
+		"max_bindings_per_roleset":      0,
+		"token_expiry_alignment":        7200,
+		"fallback_credentials":          []string{"not json"},
+		"empty_scopes_behavior":         "ignore",
+		"required_scopes":               "http://www.googleapis.com/auth/userinfo.email",
+		"default_secret_type":           "password",
+		"binding_removal_rate":          -1,
+		"binding_approval_webhook":      "ftp://policy.internal/approve",
+		"binding_approval_timeout":      0,
+	}
+	for field, value := range cases {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config",
+			Data: map[string]interface{}{
+				field: value,
+			},
+			Storage: reqStorage,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil || !resp.IsError() {
+			t.Errorf("expected error for invalid %s %v, got: %#v", field, value, resp)
+		}
+	}
 }
 
 func testConfigUpdate(t *testing.T, b logical.Backend, s logical.Storage, d map[string]interface{}) {
@@ -89,7 +277,7 @@ func testConfigRead(t *testing.T, b logical.Backend, s logical.Storage, expected
 
 		if !ok {
 			t.Errorf(`expected data["%s"] = %v but was not included in read output"`, k, expectedV)
-		} else if expectedV != actualV {
+		} else if !reflect.DeepEqual(expectedV, actualV) {
 			t.Errorf(`expected data["%s"] = %v, instead got %v"`, k, expectedV, actualV)
 		}
 	}
@@ -98,3 +286,218 @@ func testConfigRead(t *testing.T, b logical.Backend, s logical.Storage, expected
 		t.FailNow()
 	}
 }
+
+func TestConfig_Export(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, reqStorage := getTestBackend(t)
+
+	credJson, err := jsonutil.EncodeJSON(map[string]interface{}{
+		"type":           "service_account",
+		"client_email":   "testUser@google.com",
+		"client_id":      "user123",
+		"private_key_id": "privateKey123",
+		"private_key":    "iAmAPrivateKey",
+		"project_id":     "project123",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testConfigUpdate(t, b, reqStorage, map[string]interface{}{
+		"credentials": string(credJson),
+	})
+
+	rs := testStoredKeyRoleSet(t, reqStorage, "test-export")
+	kl := &keyLease{
+		RoleSet:   rs.Name,
+		KeyName:   rs.AccountId.ResourceName() + "/keys/abc123",
+		IssueTime: time.Now(),
+	}
+	if err := kl.save(ctx, reqStorage); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config/export",
+		Storage:   reqStorage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected export response: %#v", resp)
+	}
+
+	rolesets := resp.Data["rolesets"].(map[string]interface{})
+	out, ok := rolesets[rs.Name].(map[string]interface{})
+	if !ok {
+		t.Fatalf("expected role set %q in export, got: %v", rs.Name, rolesets)
+	}
+	if out["service_account_email"] != rs.AccountId.EmailOrId {
+		t.Errorf("expected service account %q, got %v", rs.AccountId.EmailOrId, out["service_account_email"])
+	}
+	if leases := out["key_leases"].([]map[string]interface{}); len(leases) != 1 || leases[0]["key_name"] != kl.KeyName {
+		t.Errorf("expected key lease for %q, got %v", kl.KeyName, leases)
+	}
+
+	// The fake credentials cannot reach GCP, so the role set must be flagged.
+	if out["gcp_reachable"] != false {
+		t.Errorf("expected role set to be flagged as unreachable")
+	}
+	if unreachable := resp.Data["unreachable_rolesets"].([]string); len(unreachable) != 1 || unreachable[0] != rs.Name {
+		t.Errorf("expected unreachable_rolesets to be [%s], got %v", rs.Name, unreachable)
+	}
+}
+
+func TestConfig_ExportExcludesSystemManagedKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var rs *RoleSet
+	var keyTypesFilter string
+	b, reqStorage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		saName := rs.AccountId.ResourceName()
+		switch r.URL.Path {
+		case "/v1/" + saName:
+			json.NewEncoder(w).Encode(&iam.ServiceAccount{Name: saName, Email: rs.AccountId.EmailOrId})
+		case "/v1/" + saName + "/keys":
+			keyTypesFilter = r.URL.Query().Get("keyTypes")
+			// Return both kinds regardless of the filter.
+			json.NewEncoder(w).Encode(&iam.ListServiceAccountKeysResponse{
+				Keys: []*iam.ServiceAccountKey{
+					{Name: saName + "/keys/user", KeyType: keyTypeUserManaged},
+					{Name: saName + "/keys/system", KeyType: "SYSTEM_MANAGED"},
+				},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	rs = testStoredKeyRoleSet(t, reqStorage, "test-exportkeys")
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config/export",
+		Storage:   reqStorage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected export response: %#v", resp)
+	}
+
+	if keyTypesFilter != keyTypeUserManaged {
+		t.Errorf("expected keys to be listed with keyTypes=%s, got %q", keyTypeUserManaged, keyTypesFilter)
+	}
+	out := resp.Data["rolesets"].(map[string]interface{})[rs.Name].(map[string]interface{})
+	expected := []string{rs.AccountId.ResourceName() + "/keys/user"}
+	if actual := out["gcp_key_names"]; !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected gcp_key_names %v, got %v (errors: %v)", expected, actual, out["gcp_errors"])
+	}
+}
+
+func TestConfig_ExportImportRoleSets(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, reqStorage := getTestBackend(t)
+
+	rs := testStoredKeyRoleSet(t, reqStorage, "test-exportrs")
+	rs.Bindings["projects/my-project"].Add("roles/browser")
+	rs.RawBindings = `{"resource":{"projects/my-project":{"roles":["roles/viewer","roles/browser"]}}}`
+	if err := rs.save(ctx, reqStorage); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config/export-rolesets",
+		Storage:   reqStorage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected export response: %#v", resp)
+	}
+
+	defs := resp.Data["rolesets"].([]map[string]interface{})
+	if len(defs) != 1 {
+		t.Fatalf("expected 1 role set definition, got %v", defs)
+	}
+	def := defs[0]
+	if def["name"] != rs.Name || def["project"] != "my-project" || def["secret_type"] != SecretTypeKey {
+		t.Errorf("unexpected role set definition: %v", def)
+	}
+	binds, err := util.ParseBindings(def["bindings"].(string))
+	if err != nil {
+		t.Fatalf("exported bindings are not valid HCL: %v", err)
+	}
+	if !reflect.DeepEqual(ResourceBindings(binds), rs.Bindings) {
+		t.Errorf("expected exported bindings %v, got %v", rs.Bindings, binds)
+	}
+
+	// Importing the export unchanged must not replace the service account,
+	// even though the stored raw bindings were written as JSON.
+	exported, err := json.Marshal(defs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "config/import-rolesets",
+		Data:      map[string]interface{}{"rolesets": string(exported)},
+		Storage:   reqStorage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected import response: %#v", resp)
+	}
+	if failed := resp.Data["failed"].(map[string]string); len(failed) > 0 {
+		t.Fatalf("unexpected import failures: %v", failed)
+	}
+	if updated := resp.Data["updated"].([]string); len(updated) != 1 || updated[0] != rs.Name {
+		t.Errorf("expected %q to be updated, got %v", rs.Name, updated)
+	}
+	imported, err := getRoleSet(rs.Name, ctx, reqStorage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imported.AccountId.EmailOrId != rs.AccountId.EmailOrId {
+		t.Errorf("expected service account %q to be kept, got %q", rs.AccountId.EmailOrId, imported.AccountId.EmailOrId)
+	}
+}
+
+func TestConfig_ImportRoleSetsInvalid(t *testing.T) {
+	t.Parallel()
+
+	b, reqStorage := getTestBackend(t)
+
+	cases := map[string]string{
+		"not json":        `resource "projects/p" {}`,
+		"no name":         `[{"project": "p"}]`,
+		"duplicate names": `[{"name": "a"}, {"name": "a"}]`,
+		"unknown field":   `[{"name": "a", "service_account_email": "a@p.iam.gserviceaccount.com"}]`,
+	}
+	for name, rolesets := range cases {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "config/import-rolesets",
+			Data:      map[string]interface{}{"rolesets": rolesets},
+			Storage:   reqStorage,
+		})
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+			continue
+		}
+		if resp == nil || !resp.IsError() {
+			t.Errorf("%s: expected error response, got %#v", name, resp)
+		}
+	}
+}
@@ -4,15 +4,18 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/iamutil"
 	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/util"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/hashicorp/vault/sdk/helper/useragent"
 	"github.com/hashicorp/vault/sdk/logical"
 	"google.golang.org/api/iam/v1"
-	"google.golang.org/api/option"
 )
 
 const (
@@ -29,12 +32,11 @@ func pathRoleSet(b *backend) *framework.Path {
 			},
 			"secret_type": {
 				Type:        framework.TypeString,
-				Description: fmt.Sprintf("Type of secret generated for this role set. Defaults to '%s'", SecretTypeAccessToken),
-				Default:     SecretTypeAccessToken,
+				Description: fmt.Sprintf("Type of secret generated for this role set. One of '%s', '%s' or '%s'. Defaults to the config's default_secret_type, itself defaulting to '%s'. May be changed on update; changing to '%s' requires token_scopes.", SecretTypeAccessToken, SecretTypeKey, SecretTypeHMACKey, SecretTypeAccessToken, SecretTypeAccessToken),
 			},
 			"project": {
 				Type:        framework.TypeString,
-				Description: "Name of the GCP project that this roleset's service account will belong to.",
+				Description: "Name of the GCP project that this roleset's service account will belong to. Bindings may reference resources in other projects.",
 			},
 			"bindings": {
 				Type:        framework.TypeString,
@@ -44,6 +46,86 @@ func pathRoleSet(b *backend) *framework.Path {
 				Type:        framework.TypeCommaStringSlice,
 				Description: `List of OAuth scopes to assign to credentials generated under this role set`,
 			},
+			"token_services": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `List of GCP service names, such as "storage" or "bigquery", whose OAuth scopes are added to token_scopes. Unknown names are rejected.`,
+			},
+			"max_token_ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: fmt.Sprintf("Maximum lifetime of access tokens generated under this role set. Tokens that must expire within an hour are generated through the IAM Credentials API, which requires the backend's credentials to have roles/iam.serviceAccountTokenCreator on the service account. Only valid for '%s' role sets. Defaults to 0 (no cap beyond GCP's own token lifetime).", SecretTypeAccessToken),
+			},
+			"key_rotation_period": {
+				Type:        framework.TypeDurationSecond,
+				Description: fmt.Sprintf("How often keys issued under this role set are replaced with new keys, which are returned when their leases are next renewed. Only valid for '%s' role sets. At least %s; defaults to 0 (keys are not rotated).", SecretTypeKey, minKeyRotationPeriod),
+			},
+			"key_renewable": {
+				Type:        framework.TypeBool,
+				Description: fmt.Sprintf("Whether the leases of keys issued under this role set can be renewed, up to the config's max_ttl. Only valid for '%s' role sets. Defaults to true.", SecretTypeKey),
+			},
+			"token_creators": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `List of members (e.g. "user:me@example.com", "group:admins@example.com") granted roles/iam.serviceAccountTokenCreator on this role set's service account, allowing them to impersonate it.`,
+			},
+			"idempotency_token": {
+				Type:        framework.TypeString,
+				Description: "Only used on create. If a previous create of this role set with the same token succeeded, the existing role set is returned instead of creating another service account.",
+			},
+			"default_audience": {
+				Type:        framework.TypeString,
+				Description: `Audience of ID tokens generated through "identity-token/:roleset" when the request doesn't give one. Must be an absolute URL or a host name.`,
+			},
+			"allowed_audiences": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Audiences ID tokens can be generated for through "identity-token/:roleset". If empty, any audience is allowed.`,
+			},
+			"require_control_group": {
+				Type:        framework.TypeBool,
+				Description: "If true, keys, tokens, signatures and other credentials are only issued for requests approved through a Vault Enterprise control group. Defaults to false.",
+			},
+			"required_metadata": {
+				Type:        framework.TypeKVPairs,
+				Description: fmt.Sprintf("Key-value pairs the identity entity of a request must have in its metadata for access tokens to be issued. Only valid for '%s' role sets.", SecretTypeAccessToken),
+			},
+			"allowed_callers": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Callers keys, tokens, signatures and other credentials are issued to, as "entity:<entity ID>", "namespace:<namespace ID>" or "auth_mount:<auth mount accessor>", matched against the identity entity of the request. If empty, any caller is allowed.`,
+			},
+			"allow_token_batch": {
+				Type:        framework.TypeBool,
+				Description: fmt.Sprintf(`If true, access tokens can also be generated for the role set through "token-batch". Only valid for '%s' role sets. Defaults to false.`, SecretTypeAccessToken),
+			},
+			"ephemeral": {
+				Type:        framework.TypeBool,
+				Description: "Only used on create. If true, the role set issues a single secret and is deleted, along with its service account, bindings and keys, once that secret expires or the role set reaches ephemeral_max_age. Ephemeral role sets can't be used by the signing paths, which issue nothing leased.",
+			},
+			"ephemeral_max_age": {
+				Type:        framework.TypeDurationSecond,
+				Description: fmt.Sprintf("Only used on create of an ephemeral role set. Time after which it is deleted even if its secret hasn't expired. Defaults to %s.", defaultEphemeralMaxAge),
+			},
+			"async_bindings": {
+				Type:        framework.TypeBool,
+				Description: `Only used on create. If true, the service account is created and the role set saved without waiting for its bindings to be applied, which happens in the background. "bindings_status" on read shows whether they have been.`,
+			},
+			"check_org_policy": {
+				Type:        framework.TypeBool,
+				Description: "If true, check the role set against the org policies in effect on its projects before changing any IAM policies, rejecting obvious violations. Best effort; policies that can't be read are reported as warnings. Defaults to false.",
+			},
+			"validate_resources": {
+				Type:        framework.TypeBool,
+				Description: "If true, also probe every bound resource with getIamPolicy before creating the service account, reporting per resource whether it doesn't exist or can't be accessed. Defaults to false.",
+			},
+			"force": {
+				Type:        framework.TypeBool,
+				Description: "Only used on delete. Delete the role set even if it has active key leases, deleting the keys as part of the role set deletion.",
+			},
+			"key_cleanup_on_delete": {
+				Type:        framework.TypeString,
+				Description: fmt.Sprintf(`Only used on delete with "force". %q to delete the keys of active leases now, %q to leave them valid until their leases are revoked or expire. Defaults to the config's key_cleanup_on_delete.`, keyCleanupRevoke, keyCleanupExpire),
+			},
+			"dry_run": {
+				Type:        framework.TypeBool,
+				Description: "Only used on delete. Return what deleting the role set would do, without deleting anything.",
+			},
 		},
 		ExistenceCheck: b.pathRoleSetExistenceCheck("name"),
 		Operations: map[logical.Operation]framework.OperationHandler{
@@ -155,16 +237,89 @@ func (b *backend) pathRoleSetRead(ctx context.Context, req *logical.Request, d *
 		"secret_type": rs.SecretType,
 		"bindings":    rs.Bindings.asOutput(),
 	}
+	if len(rs.BindingMembers) > 0 {
+		data["binding_members"] = bindingMembersOutput(rs.BindingMembers)
+	}
+	if len(rs.WildcardExpansions) > 0 {
+		data["wildcard_expansions"] = rs.WildcardExpansions
+	}
 
 	if rs.AccountId != nil {
 		data["service_account_email"] = rs.AccountId.EmailOrId
-		data["project"] = rs.AccountId.Project
+		data["project"] = rs.project()
+		data["service_account_project"] = rs.AccountId.Project
+	}
+	if rs.AccountUniqueId != "" {
+		data["service_account_unique_id"] = rs.AccountUniqueId
 	}
 
 	if rs.TokenGen != nil && rs.SecretType == SecretTypeAccessToken {
 		data["token_scopes"] = rs.TokenGen.Scopes
 	}
 
+	if len(rs.TokenCreators) > 0 {
+		data["token_creators"] = rs.TokenCreators
+	}
+
+	if rs.MaxTokenTTL > 0 {
+		data["max_token_ttl"] = int64(rs.MaxTokenTTL / time.Second)
+	}
+
+	if rs.DefaultAudience != "" {
+		data["default_audience"] = rs.DefaultAudience
+	}
+
+	if len(rs.AllowedAudiences) > 0 {
+		data["allowed_audiences"] = rs.AllowedAudiences
+	}
+
+	if rs.KeyRotationPeriod > 0 {
+		data["key_rotation_period"] = int64(rs.KeyRotationPeriod / time.Second)
+	}
+
+	if rs.SecretType == SecretTypeKey {
+		data["key_renewable"] = rs.keyRenewable()
+	}
+
+	if rs.RequireControlGroup {
+		data["require_control_group"] = true
+	}
+
+	if len(rs.RequiredMetadata) > 0 {
+		data["required_metadata"] = rs.RequiredMetadata
+	}
+
+	if len(rs.AllowedCallers) > 0 {
+		data["allowed_callers"] = rs.AllowedCallers
+	}
+
+	if rs.AllowTokenBatch {
+		data["allow_token_batch"] = true
+	}
+
+	if rs.Ephemeral {
+		data["ephemeral"] = true
+		data["ephemeral_expire_time"] = rs.EphemeralExpireTime.Format(time.RFC3339)
+		data["ephemeral_secret_issued"] = rs.EphemeralIssued
+	}
+
+	if !rs.BindingsLastApplied.IsZero() {
+		data["bindings_last_applied"] = rs.BindingsLastApplied.Format(time.RFC3339)
+	}
+	data["bindings_status"] = rs.bindingsStatus()
+	if rs.BindingsError != "" {
+		data["bindings_error"] = rs.BindingsError
+	}
+
+	if !rs.CreateTime.IsZero() {
+		data["create_time"] = rs.CreateTime.Format(time.RFC3339)
+		data["created_by"] = rs.CreatedBy.asOutput()
+	}
+	if !rs.LastModified.IsZero() {
+		data["last_modified"] = rs.LastModified.Format(time.RFC3339)
+		data["last_modified_by"] = rs.LastModifiedBy.asOutput()
+	}
+
 	return &logical.Response{
 		Data: data,
 	}, nil
@@ -185,84 +340,347 @@ func (b *backend) pathRoleSetDelete(ctx context.Context, req *logical.Request, d
 		return nil, nil
 	}
 
+	dryRun := d.Get("dry_run").(bool)
+
+	keyCleanup := b.keyCleanupOnDelete(ctx, req.Storage)
+	if raw, ok := d.GetOk("key_cleanup_on_delete"); ok {
+		keyCleanup = raw.(string)
+		if keyCleanup != keyCleanupRevoke && keyCleanup != keyCleanupExpire {
+			return logical.ErrorResponse(fmt.Sprintf("invalid key_cleanup_on_delete %q, must be %q or %q", keyCleanup, keyCleanupRevoke, keyCleanupExpire)), nil
+		}
+	}
+
+	if dryRun {
+		var activeLeases []*keyLease
+		if rs.SecretType == SecretTypeKey {
+			activeLeases, err = listKeyLeases(ctx, req.Storage, rsName)
+			if err != nil {
+				return nil, errwrap.Wrapf("unable to list active key leases: {{err}}", err)
+			}
+		}
+		resp := &logical.Response{
+			Data: roleSetDeletionPlan(rs, activeLeases, keyCleanup, b.disableServiceAccountOnDelete(ctx, req.Storage), b.serviceAccountDeletionDelay(ctx, req.Storage)),
+		}
+		if len(activeLeases) > 0 && !d.Get("force").(bool) {
+			resp.AddWarning(fmt.Sprintf("roleset has %d active leases; the deletion would be refused without force=true", len(activeLeases)))
+		}
+		return resp, nil
+	}
+
+	warnings, lingering, err := b.deleteRoleSet(ctx, req.Storage, rs, d.Get("force").(bool), keyCleanup)
+	if err != nil {
+		if leasesErr, ok := err.(*activeLeasesError); ok {
+			return logical.ErrorResponse(leasesErr.Error()), nil
+		}
+		return nil, err
+	}
+	if len(lingering) > 0 {
+		return &logical.Response{
+			Warnings: warnings,
+			Data: map[string]interface{}{
+				"lingering_bindings": lingering,
+			},
+		}, nil
+	}
+	if len(warnings) > 0 {
+		return &logical.Response{Warnings: warnings}, nil
+	}
+	return nil, nil
+}
+
+// activeLeasesError is returned by deleteRoleSet when the role set has
+// active key leases and the deletion wasn't forced.
+type activeLeasesError struct {
+	count int
+}
+
+func (e *activeLeasesError) Error() string {
+	return fmt.Sprintf("roleset has %d active leases; revoke them or pass force=true", e.count)
+}
+
+// deleteRoleSet deletes the role set and cleans up its GCP resources: its
+// service account, bindings and keys, including the keys of its active
+// leases. WAL entries are added first, so resources that fail to be cleaned
+// up are retried later; the failures are returned as warnings.
+//
+// The active leases are listed under rolesetLock, which key issuance holds
+// too, so no key issued concurrently is missed. Unless force is set, the
+// deletion is refused with an activeLeasesError if there are any.
+//
+// With keyCleanupExpire, the keys of active leases are left valid instead,
+// and the service account and bindings they depend on are kept as an
+// orphaned role set until the last of the leases is revoked.
+//
+// With sa_deletion_delay set, the service account, and the key access token
+// role sets generate tokens with, are kept until the delay passes, and the
+// role set is kept as a deleted role set that can be undeleted until then.
+func (b *backend) deleteRoleSet(ctx context.Context, s logical.Storage, rs *RoleSet, force bool, keyCleanup string) ([]string, []string, error) {
 	b.rolesetLock.Lock()
 	defer b.rolesetLock.Unlock()
 
-	if rs.AccountId != nil {
-		_, err := framework.PutWAL(ctx, req.Storage, walTypeAccount, &walAccount{
-			RoleSet: rsName,
-			Id:      *rs.AccountId,
-		})
-		if err != nil {
-			return nil, errwrap.Wrapf("unable to create WAL entry to clean up service account: {{err}}", err)
+	var activeLeases []*keyLease
+	if rs.SecretType == SecretTypeKey {
+		var err error
+		if activeLeases, err = listKeyLeases(ctx, s, rs.Name); err != nil {
+			return nil, nil, errwrap.Wrapf("unable to list active key leases: {{err}}", err)
+		}
+		if len(activeLeases) > 0 && !force {
+			return nil, nil, &activeLeasesError{count: len(activeLeases)}
 		}
+	}
 
-		for resName, roleSet := range rs.Bindings {
-			_, err := framework.PutWAL(ctx, req.Storage, walTypeIamPolicy, &walIamPolicy{
-				RoleSet:   rsName,
-				AccountId: *rs.AccountId,
-				Resource:  resName,
-				Roles:     roleSet.ToSlice(),
-			})
-			if err != nil {
-				return nil, errwrap.Wrapf("unable to create WAL entry to clean up service account bindings: {{err}}", err)
+	orphan := keyCleanup == keyCleanupExpire && len(activeLeases) > 0
+	disableAccount := b.disableServiceAccountOnDelete(ctx, s)
+	var deleteAfter time.Time
+	if delay := b.serviceAccountDeletionDelay(ctx, s); delay > 0 && !orphan && rs.AccountId != nil {
+		deleteAfter = time.Now().UTC().Add(delay)
+	}
+	keepAccount := !deleteAfter.IsZero()
+
+	var bindingWals map[string]string
+	if rs.AccountId != nil {
+		if !orphan {
+			var err error
+			if bindingWals, err = putRoleSetAccountWALs(ctx, s, rs, disableAccount, deleteAfter); err != nil {
+				return nil, nil, err
 			}
 		}
 
-		if rs.TokenGen != nil {
-			_, err := framework.PutWAL(ctx, req.Storage, walTypeAccount, &walAccountKey{
-				RoleSet:            rsName,
+		if rs.TokenGen != nil && !keepAccount {
+			_, err := framework.PutWAL(ctx, s, walTypeAccount, &walAccountKey{
+				RoleSet:            rs.Name,
 				ServiceAccountName: rs.AccountId.ResourceName(),
 				KeyName:            rs.TokenGen.KeyName,
 			})
 			if err != nil {
-				return nil, errwrap.Wrapf("unable to create WAL entry to clean up service account key: {{err}}", err)
+				return nil, nil, errwrap.Wrapf("unable to create WAL entry to clean up service account key: {{err}}", err)
 			}
 		}
 	}
 
-	if err := req.Storage.Delete(ctx, fmt.Sprintf("roleset/%s", nameRaw)); err != nil {
-		return nil, err
+	if orphan {
+		if err := saveOrphanedRoleSet(ctx, s, rs); err != nil {
+			return nil, nil, errwrap.Wrapf("unable to save orphaned role set: {{err}}", err)
+		}
+	}
+	if keepAccount {
+		if err := saveDeletedRoleSet(ctx, s, &deletedRoleSet{RoleSet: rs, DeleteAfter: deleteAfter}); err != nil {
+			return nil, nil, errwrap.Wrapf("unable to save deleted role set: {{err}}", err)
+		}
+	}
+
+	if err := s.Delete(ctx, fmt.Sprintf("%s/%s", rolesetStoragePrefix, rs.Name)); err != nil {
+		return nil, nil, err
+	}
+
+	if err := pruneIdempotencyRecords(ctx, s, rs.Name, true); err != nil {
+		return nil, nil, errwrap.Wrapf("unable to clean up idempotency tokens: {{err}}", err)
+	}
+
+	if !orphan {
+		for _, kl := range activeLeases {
+			if err := deleteKeyLease(ctx, s, rs.Name, kl.KeyName); err != nil {
+				return nil, nil, errwrap.Wrapf("unable to clean up key lease: {{err}}", err)
+			}
+		}
 	}
 
 	// Clean up resources:
-	httpC, err := b.HTTPClient(req.Storage)
+	httpC, err := b.HTTPClient(s)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
-	iamAdmin, err := iam.NewService(ctx, option.WithHTTPClient(httpC))
+	iamAdmin, err := b.IAMAdminClient(s)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	apiHandle := iamutil.GetApiHandle(httpC, useragent.String())
 
 	warnings := make([]string, 0)
 	if rs.AccountId != nil {
-		if err := b.deleteTokenGenKey(ctx, iamAdmin, rs.TokenGen); err != nil {
-			w := fmt.Sprintf("unable to delete key under service account %q (WAL entry to clean-up later has been added): %v", rs.AccountId.ResourceName(), err)
+		if !orphan {
+			for _, kl := range activeLeases {
+				_, err := iamAdmin.Projects.ServiceAccounts.Keys.Delete(kl.KeyName).Context(ctx).Do()
+				if err != nil && !isGoogleAccountKeyNotFoundErr(err) {
+					w := fmt.Sprintf("unable to delete leased key %q (it will be deleted along with the service account): %v", kl.KeyName, err)
+					warnings = append(warnings, w)
+				}
+			}
+		}
+
+		// A kept service account's key is deleted along with it.
+		if !keepAccount {
+			if err := b.deleteTokenGenKey(ctx, iamAdmin, rs.TokenGen); err != nil {
+				w := fmt.Sprintf("unable to delete key under service account %q (WAL entry to clean-up later has been added): %v", rs.AccountId.ResourceName(), err)
+				warnings = append(warnings, w)
+			}
+		}
+
+		if err := updateTokenCreators(ctx, iamAdmin, rs.AccountId, nil, rs.TokenCreators); err != nil {
+			w := fmt.Sprintf("unable to remove token creators from service account %q: %v", rs.AccountId.ResourceName(), err)
 			warnings = append(warnings, w)
 		}
 
+		if orphan {
+			warnings = append(warnings, fmt.Sprintf("%d keys were left to expire with their leases; service account %q and its bindings will be removed once the last of the leases is revoked", len(activeLeases), rs.AccountId.ResourceName()))
+			return warnings, nil, nil
+		}
+
+		accountWarnings, lingering := b.deleteRoleSetAccount(ctx, s, iamAdmin, apiHandle, rs, disableAccount, keepAccount, bindingWals)
+		warnings = append(warnings, accountWarnings...)
+		if keepAccount {
+			action := "deleted"
+			if disableAccount {
+				action = "disabled"
+			}
+			warnings = append(warnings, fmt.Sprintf("service account %q will be %s after %s; until then, write to undelete-roleset/%s to restore the role set", rs.AccountId.EmailOrId, action, deleteAfter.Format(time.RFC3339), rs.Name))
+		}
+		if len(lingering) > 0 {
+			warnings = append(warnings, fmt.Sprintf("bindings of service account %q remain on %d resources and will be removed by WAL rollback: %s", rs.AccountId.EmailOrId, len(lingering), strings.Join(lingering, ", ")))
+		}
+		return warnings, lingering, nil
+	}
+
+	return warnings, nil, nil
+}
+
+// roleSetDeletionPlan returns what deleteRoleSet would do with the same
+// arguments, for a dry run of the role set's deletion.
+func roleSetDeletionPlan(rs *RoleSet, activeLeases []*keyLease, keyCleanup string, disableAccount bool, deletionDelay time.Duration) map[string]interface{} {
+	orphan := keyCleanup == keyCleanupExpire && len(activeLeases) > 0
+
+	leasedKeys := make([]string, 0, len(activeLeases))
+	for _, kl := range activeLeases {
+		leasedKeys = append(leasedKeys, kl.KeyName)
+	}
+	sort.Strings(leasedKeys)
+
+	plan := map[string]interface{}{
+		"dry_run":               true,
+		"active_leases":         len(activeLeases),
+		"leased_keys":           leasedKeys,
+		"key_cleanup_on_delete": keyCleanup,
+	}
+	if len(activeLeases) > 0 {
+		if orphan {
+			plan["leased_keys_action"] = "expire"
+		} else {
+			plan["leased_keys_action"] = "delete"
+		}
+	}
+
+	if rs.AccountId == nil {
+		return plan
+	}
+
+	plan["service_account_email"] = rs.AccountId.EmailOrId
+	switch {
+	case orphan:
+		plan["service_account_action"] = "keep_until_leases_end"
+	case disableAccount:
+		plan["service_account_action"] = "disable"
+	default:
+		plan["service_account_action"] = "delete"
+	}
+	if !orphan && deletionDelay > 0 {
+		plan["sa_deletion_delay"] = int64(deletionDelay / time.Second)
+	}
+	if rs.TokenGen != nil {
+		plan["token_key_name"] = rs.TokenGen.KeyName
+	}
+	if len(rs.TokenCreators) > 0 {
+		plan["removed_token_creators"] = rs.TokenCreators
+	}
+
+	// Bindings are kept along with the service account while the keys of
+	// the active leases are left to expire.
+	removed := rs.Bindings.asOutput()
+	if orphan {
+		removed = map[string][]string{}
+	}
+	plan["removed_bindings"] = removed
+	if !orphan && len(rs.AddedMembers) > 0 {
+		members := make(map[string]map[string][]string, len(rs.AddedMembers))
+		for rName, rm := range rs.AddedMembers {
+			members[rName] = rm.asOutput()
+		}
+		plan["removed_binding_members"] = members
+	}
+	return plan
+}
+
+// putRoleSetAccountWALs adds WAL entries to delete, or disable, the role
+// set's service account, not before deleteAfter if it is set, and to remove
+// its bindings. The IDs of the entries removing bindings are returned by
+// resource name.
+func putRoleSetAccountWALs(ctx context.Context, s logical.Storage, rs *RoleSet, disableAccount bool, deleteAfter time.Time) (map[string]string, error) {
+	_, err := framework.PutWAL(ctx, s, walTypeAccount, &walAccount{
+		RoleSet:     rs.Name,
+		Id:          *rs.AccountId,
+		Disable:     disableAccount,
+		DeleteAfter: deleteAfter,
+	})
+	if err != nil {
+		return nil, errwrap.Wrapf("unable to create WAL entry to clean up service account: {{err}}", err)
+	}
+
+	bindingWals := make(map[string]string, len(rs.Bindings))
+	for resName, roleSet := range rs.Bindings {
+		walId, err := framework.PutWAL(ctx, s, walTypeIamPolicy, &walIamPolicy{
+			RoleSet:   rs.Name,
+			AccountId: *rs.AccountId,
+			Resource:  resName,
+			Roles:     roleSet.ToSlice(),
+			Members:   rs.AddedMembers[resName].asWAL(),
+		})
+		if err != nil {
+			return nil, errwrap.Wrapf("unable to create WAL entry to clean up service account bindings: {{err}}", err)
+		}
+		bindingWals[resName] = walId
+	}
+	return bindingWals, nil
+}
+
+// deleteRoleSetAccount deletes or disables the role set's service account,
+// unless keepAccount is set, and removes its bindings, returning failures as
+// warnings along with the resources whose bindings remain. The WAL entries of
+// bindingWals for the bindings that were removed are deleted, so only the
+// rest are retried.
+func (b *backend) deleteRoleSetAccount(ctx context.Context, s logical.Storage, iamAdmin *iam.Service, apiHandle *iamutil.ApiHandle, rs *RoleSet, disableAccount, keepAccount bool, bindingWals map[string]string) ([]string, []string) {
+	var warnings []string
+	switch {
+	case keepAccount:
+		// Its WAL entry removes it later.
+	case disableAccount:
+		if err := b.disableServiceAccount(ctx, iamAdmin, rs.AccountId); err != nil {
+			w := fmt.Sprintf("unable to disable service account %q (WAL entry to clean-up later has been added): %v", rs.AccountId.ResourceName(), err)
+			warnings = append(warnings, w)
+		}
+	default:
 		if err := b.deleteServiceAccount(ctx, iamAdmin, rs.AccountId); err != nil {
 			w := fmt.Sprintf("unable to delete service account %q (WAL entry to clean-up later has been added): %v", rs.AccountId.ResourceName(), err)
 			warnings = append(warnings, w)
 		}
+	}
 
-		if merr := b.removeBindings(ctx, apiHandle, rs.AccountId.EmailOrId, rs.Bindings); merr != nil {
-			for _, err := range merr.Errors {
-				w := fmt.Sprintf("unable to delete IAM policy bindings for service account %q (WAL entry to clean-up later has been added): %v", rs.AccountId.EmailOrId, err)
-				warnings = append(warnings, w)
-			}
+	lingering, merr := b.removeBindings(ctx, s, apiHandle, rs.AccountId.EmailOrId, rs.Bindings, rs.AddedMembers)
+	if merr != nil {
+		for _, err := range merr.Errors {
+			w := fmt.Sprintf("unable to delete IAM policy bindings for service account %q (WAL entry to clean-up later has been added): %v", rs.AccountId.EmailOrId, err)
+			warnings = append(warnings, w)
 		}
 	}
 
-	if len(warnings) > 0 {
-		return &logical.Response{Warnings: warnings}, nil
+	removed := make([]string, 0, len(bindingWals))
+	for resName, walId := range bindingWals {
+		if !strutil.StrListContains(lingering, resName) {
+			removed = append(removed, walId)
+		}
 	}
-
-	return nil, nil
+	tryDeleteWALs(ctx, s, removed...)
+	return warnings, lingering
 }
 
 func (b *backend) pathRoleSetCreateUpdate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
@@ -278,6 +696,22 @@ func (b *backend) pathRoleSetCreateUpdate(ctx context.Context, req *logical.Requ
 		return nil, err
 	}
 
+	idempotencyToken := d.Get("idempotency_token").(string)
+	if rs != nil && idempotencyToken != "" {
+		rec, err := getIdempotencyRecord(ctx, req.Storage, name, idempotencyToken)
+		if err != nil {
+			return nil, errwrap.Wrapf("unable to read idempotency token: {{err}}", err)
+		}
+		if rec != nil {
+			resp, err := b.pathRoleSetRead(ctx, req, d)
+			if err != nil || resp == nil {
+				return resp, err
+			}
+			resp.AddWarning("role set was already created with this idempotency_token, returning existing role set")
+			return resp, nil
+		}
+	}
+
 	if rs == nil {
 		rs = &RoleSet{
 			Name: name,
@@ -285,108 +719,463 @@ func (b *backend) pathRoleSetCreateUpdate(ctx context.Context, req *logical.Requ
 	}
 
 	isCreate := req.Operation == logical.CreateOperation
+	var oldSecretType string
+
+	if isCreate {
+		if resp, err := checkRoleSetNameReusable(ctx, req.Storage, name); resp != nil || err != nil {
+			return resp, err
+		}
+	}
+
+	// Only saved if the write succeeds.
+	now := time.Now().UTC()
+	if isCreate {
+		rs.CreateTime = now
+		rs.CreatedBy = requestActor(req)
+	}
+	rs.LastModified = now
+	rs.LastModifiedBy = requestActor(req)
+
+	cfg, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, errwrap.Wrapf("could not read backend config: {{err}}", err)
+	}
+
+	// Invalid fields are collected so they can all be reported at once.
+	var fe fieldErrors
 
 	// Secret type
 	if isCreate {
-		secretType := d.Get("secret_type").(string)
+		secretType := cfg.defaultSecretType()
+		if secretTypeRaw, ok := d.GetOk("secret_type"); ok {
+			secretType = secretTypeRaw.(string)
+		}
 		switch secretType {
-		case SecretTypeKey, SecretTypeAccessToken:
+		case SecretTypeKey, SecretTypeAccessToken, SecretTypeHMACKey:
 			rs.SecretType = secretType
 		default:
-			return logical.ErrorResponse(fmt.Sprintf(`invalid "secret_type" value: "%s"`, secretType)), nil
+			fe.add("secret_type", `invalid "secret_type" value: "%s"`, secretType)
 		}
 	} else {
 		secretTypeRaw, ok := d.GetOk("secret_type")
 		if ok && rs.SecretType != secretTypeRaw.(string) {
-			return logical.ErrorResponse("cannot change secret_type after roleset creation"), nil
+			switch secretType := secretTypeRaw.(string); secretType {
+			case SecretTypeKey, SecretTypeAccessToken, SecretTypeHMACKey:
+				oldSecretType = rs.SecretType
+				rs.SecretType = secretType
+			default:
+				fe.add("secret_type", `invalid "secret_type" value: "%s"`, secretType)
+			}
 		}
 	}
+	changedSecretType := oldSecretType != ""
 
 	// Project
 	var project string
 	projectRaw, ok := d.GetOk("project")
 	if ok {
 		project = projectRaw.(string)
-		if !isCreate && rs.AccountId.Project != project {
-			return logical.ErrorResponse(fmt.Sprintf("cannot change project for existing role set (old: %s, new: %s)", rs.AccountId.Project, project)), nil
-		}
-		if len(project) == 0 {
-			return logical.ErrorResponse("given empty project"), nil
+		switch {
+		case !isCreate && rs.project() != project:
+			fe.add("project", "cannot change project for existing role set (old: %s, new: %s)", rs.project(), project)
+		case len(project) == 0:
+			fe.add("project", "given empty project")
 		}
+	} else if isCreate {
+		fe.add("project", "project argument is required for new role set")
 	} else {
-		if isCreate {
-			return logical.ErrorResponse("project argument is required for new role set"), nil
-		}
-		project = rs.AccountId.Project
+		project = rs.project()
 	}
 
 	// Default scopes
 	var scopes []string
-	scopesRaw, ok := d.GetOk("token_scopes")
-	if ok {
+	// useDefaultScopes applies the config's empty_scopes_behavior to an
+	// access token role set written without scopes; reason is reported if
+	// they are rejected.
+	useDefaultScopes := func(reason string) {
+		defaults, warn, err := cfg.defaultTokenScopes()
+		if err != nil {
+			fe.add("token_scopes", "%s (%v)", reason, err)
+			return
+		}
+		scopes = defaults
+		warnings = append(warnings, warn)
+	}
+	scopesRaw, hasScopes := d.GetOk("token_scopes")
+	servicesRaw, hasServices := d.GetOk("token_services")
+	if hasScopes || hasServices {
 		if rs.SecretType != SecretTypeAccessToken {
 			warnings = []string{
-				fmt.Sprintf("ignoring token_scopes, only valid for '%s' secret type role set", SecretTypeAccessToken),
+				fmt.Sprintf("ignoring token_scopes and token_services, only valid for '%s' secret type role set", SecretTypeAccessToken),
 			}
 		}
-		scopes = scopesRaw.([]string)
-		if len(scopes) == 0 {
-			return logical.ErrorResponse("cannot provide empty token_scopes"), nil
+		if hasScopes {
+			scopes = scopesRaw.([]string)
 		}
-	} else if rs.SecretType == SecretTypeAccessToken {
-		if isCreate {
-			return logical.ErrorResponse("token_scopes must be provided for creating access token role set"), nil
+		if hasServices {
+			serviceScopes, err := scopesForServices(servicesRaw.([]string))
+			if err != nil {
+				fe.add("token_services", "%v", err)
+			}
+			scopes = appendUniqueScopes(scopes, serviceScopes...)
+		}
+		switch {
+		case len(scopes) == 0 && rs.SecretType == SecretTypeAccessToken:
+			useDefaultScopes("cannot provide empty token_scopes")
+		case len(scopes) == 0:
+			fe.add("token_scopes", "cannot provide empty token_scopes")
+		case rs.SecretType == SecretTypeAccessToken && util.ToSet(scopes).Includes(cloudPlatformScope):
+			switch {
+			case cfg.requireNarrowScopes():
+				fe.add("token_scopes", "token_scopes cannot include %q, require_narrow_scopes is set; use the scopes of the specific APIs needed instead", cloudPlatformScope)
+			case cfg.warnBroadScopes():
+				warnings = append(warnings, fmt.Sprintf("token_scopes includes %q, which allows access to all Google Cloud APIs the service account has roles for; consider narrower scopes", cloudPlatformScope))
+			}
 		}
-		if rs.TokenGen != nil {
+	} else if rs.SecretType == SecretTypeAccessToken {
+		switch {
+		case isCreate:
+			useDefaultScopes("token_scopes must be provided, directly or with token_services, for creating access token role set")
+		case changedSecretType:
+			useDefaultScopes(fmt.Sprintf("token_scopes must be provided, directly or with token_services, when changing secret_type to '%s'", SecretTypeAccessToken))
+		case rs.TokenGen != nil:
 			scopes = rs.TokenGen.Scopes
 		}
 	}
 
+	// Max token TTL
+	if maxTokenTTLRaw, ok := d.GetOk("max_token_ttl"); ok {
+		if rs.SecretType != SecretTypeAccessToken {
+			warnings = append(warnings, fmt.Sprintf("ignoring max_token_ttl, only valid for '%s' secret type role set", SecretTypeAccessToken))
+		} else if maxTokenTTLRaw.(int) < 0 {
+			fe.add("max_token_ttl", "max_token_ttl cannot be negative")
+		} else {
+			rs.MaxTokenTTL = time.Duration(maxTokenTTLRaw.(int)) * time.Second
+		}
+	}
+
+	// Key rotation period
+	if periodRaw, ok := d.GetOk("key_rotation_period"); ok {
+		period := time.Duration(periodRaw.(int)) * time.Second
+		switch {
+		case rs.SecretType != SecretTypeKey:
+			warnings = append(warnings, fmt.Sprintf("ignoring key_rotation_period, only valid for '%s' secret type role set", SecretTypeKey))
+		case period < 0:
+			fe.add("key_rotation_period", "key_rotation_period cannot be negative")
+		case period > 0 && period < minKeyRotationPeriod:
+			fe.add("key_rotation_period", "key_rotation_period must be at least %s", minKeyRotationPeriod)
+		default:
+			rs.KeyRotationPeriod = period
+		}
+	}
+
+	if renewableRaw, ok := d.GetOk("key_renewable"); ok {
+		if rs.SecretType != SecretTypeKey {
+			warnings = append(warnings, fmt.Sprintf("ignoring key_renewable, only valid for '%s' secret type role set", SecretTypeKey))
+		} else {
+			rs.DisableKeyRenewal = !renewableRaw.(bool)
+		}
+	}
+
+	if requireRaw, ok := d.GetOk("require_control_group"); ok {
+		rs.RequireControlGroup = requireRaw.(bool)
+	}
+
+	if metadataRaw, ok := d.GetOk("required_metadata"); ok {
+		metadata := metadataRaw.(map[string]string)
+		switch {
+		case rs.SecretType != SecretTypeAccessToken && len(metadata) > 0:
+			fe.add("required_metadata", "required_metadata is only valid for '%s' role sets", SecretTypeAccessToken)
+		case len(metadata) == 0:
+			rs.RequiredMetadata = nil
+		default:
+			if _, ok := metadata[""]; ok {
+				fe.add("required_metadata", "required_metadata keys cannot be empty")
+			}
+			rs.RequiredMetadata = metadata
+		}
+	}
+
+	if callersRaw, ok := d.GetOk("allowed_callers"); ok {
+		callers := callersRaw.([]string)
+		for _, caller := range callers {
+			if err := validateAllowedCaller(caller); err != nil {
+				fe.add("allowed_callers", "invalid allowed_callers: %v", err)
+			}
+		}
+		if len(callers) == 0 {
+			callers = nil
+		}
+		rs.AllowedCallers = callers
+	}
+
+	if batchRaw, ok := d.GetOk("allow_token_batch"); ok {
+		if batchRaw.(bool) && rs.SecretType != SecretTypeAccessToken {
+			fe.add("allow_token_batch", "allow_token_batch is only valid for '%s' role sets", SecretTypeAccessToken)
+		}
+		rs.AllowTokenBatch = batchRaw.(bool)
+	}
+
+	// Default ID token audience
+	if audienceRaw, ok := d.GetOk("default_audience"); ok {
+		if err := validateAudience(audienceRaw.(string)); err != nil {
+			fe.add("default_audience", "invalid default_audience: %v", err)
+		}
+		rs.DefaultAudience = audienceRaw.(string)
+	}
+
+	// Allowed ID token audiences
+	if allowedRaw, ok := d.GetOk("allowed_audiences"); ok {
+		allowed := make([]string, 0)
+		for _, aud := range allowedRaw.([]string) {
+			aud = strings.TrimSpace(aud)
+			if aud == "" {
+				continue
+			}
+			if err := validateAudience(aud); err != nil {
+				fe.add("allowed_audiences", "%v", err)
+				continue
+			}
+			if !strutil.StrListContains(allowed, aud) {
+				allowed = append(allowed, aud)
+			}
+		}
+		rs.AllowedAudiences = allowed
+	}
+	if rs.DefaultAudience != "" && !audienceAllowed(rs.AllowedAudiences, rs.DefaultAudience) {
+		fe.add("default_audience", "default_audience %q is not in allowed_audiences", rs.DefaultAudience)
+	}
+
+	// Ephemeral
+	if isCreate {
+		if d.Get("ephemeral").(bool) {
+			maxAge := defaultEphemeralMaxAge
+			if maxAgeRaw, ok := d.GetOk("ephemeral_max_age"); ok {
+				if maxAgeRaw.(int) <= 0 {
+					fe.add("ephemeral_max_age", "ephemeral_max_age must be positive")
+				}
+				maxAge = time.Duration(maxAgeRaw.(int)) * time.Second
+			}
+			rs.Ephemeral = true
+			rs.EphemeralExpireTime = time.Now().Add(maxAge)
+		} else if _, ok := d.GetOk("ephemeral_max_age"); ok {
+			warnings = append(warnings, "ignoring ephemeral_max_age, only valid for ephemeral role sets")
+		}
+	} else {
+		if _, ok := d.GetOk("ephemeral"); ok {
+			fe.add("ephemeral", "ephemeral and ephemeral_max_age can only be set when creating a role set")
+		}
+		if _, ok := d.GetOk("ephemeral_max_age"); ok {
+			fe.add("ephemeral_max_age", "ephemeral and ephemeral_max_age can only be set when creating a role set")
+		}
+	}
+
+	asyncBindings := d.Get("async_bindings").(bool)
+	if !isCreate {
+		if _, ok := d.GetOk("async_bindings"); ok {
+			fe.add("async_bindings", "async_bindings can only be set when creating a role set")
+		}
+	}
+
+	// Token creators
+	oldTokenCreators := rs.TokenCreators
+	tokenCreatorsRaw, newTokenCreators := d.GetOk("token_creators")
+	if newTokenCreators {
+		tokenCreators := tokenCreatorsRaw.([]string)
+		for _, member := range tokenCreators {
+			if err := validateIamMember(member); err != nil {
+				fe.add("token_creators", "invalid token_creators: %v", err)
+			}
+		}
+		rs.TokenCreators = tokenCreators
+	}
+
 	// Bindings
 	bRaw, newBindings := d.GetOk("bindings")
+	var bindings ResourceBindings
+	var bindingMembers map[string]util.StringSet
+	if newBindings {
+		bindingsRaw, ok := bRaw.(string)
+		switch {
+		case !ok:
+			fe.add("bindings", "bindings are not a string")
+		case bindingsRaw == "":
+			fe.add("bindings", "bindings are empty")
+		default:
+			parsed, members, err := util.ParseBindingsWithMembers(bindingsRaw)
+			switch {
+			case err != nil:
+				fe.add("bindings", "unable to parse bindings: %v", err)
+			case len(parsed) == 0:
+				fe.add("bindings", "unable to parse any bindings from given bindings HCL")
+			default:
+				bindings = parsed
+				bindingMembers = nonEmptyMembers(members)
+				for _, reason := range validateBindingMembers(members) {
+					fe.add("bindings", "invalid member of %s", reason)
+				}
+			}
+		}
+	} else if isCreate {
+		fe.add("bindings", "bindings are required for new role set")
+	}
+
+	if resp := fe.response(); resp != nil {
+		return resp, nil
+	}
+
+	if (isCreate || changedSecretType) && rs.SecretType == SecretTypeHMACKey {
+		if err := b.validateStorageAPI(ctx, req.Storage, project); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+	}
 
+	// Projects named with a wildcard are expanded only when bindings change,
+	// and the concrete resources are stored, so later updates and deletion
+	// act on exactly the projects that were bound.
+	var wildcardExpansions map[string][]string
 	if newBindings {
-		bindings, ok := bRaw.(string)
-		if !ok {
-			return logical.ErrorResponse("bindings are not a string"), nil
+		if rs.bindingHash() == getStringHash(bRaw.(string)) {
+			bindings, bindingMembers = rs.Bindings, rs.BindingMembers
+		} else {
+			expanded, expandedMembers, expansions, err := b.expandWildcardProjects(ctx, req.Storage, cfg, bindings, bindingMembers)
+			if err != nil {
+				return logical.ErrorResponse(fmt.Sprintf("invalid bindings: %v", err)), nil
+			}
+			bindings, bindingMembers, wildcardExpansions = expanded, expandedMembers, expansions
 		}
-		if bindings == "" {
-			return logical.ErrorResponse("bindings are empty"), nil
+	}
+
+	checkBindings := rs.Bindings
+	if newBindings {
+		checkBindings = bindings
+	}
+
+	if newBindings {
+		if pairs, limit := checkBindings.count(), cfg.maxBindingsPerRoleSet(); pairs > limit {
+			return logical.ErrorResponse(fmt.Sprintf("bindings have %d roles on %d resources, more than the max_bindings_per_roleset limit of %d", pairs, len(checkBindings), limit)), nil
+		}
+	}
+	if err := cfg.checkProjectAccess(project, checkBindings); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if newBindings {
+		if selfBindings := selfEscalatingBindings(project, checkBindings); len(selfBindings) > 0 {
+			msg := fmt.Sprintf("bindings grant the role set's service account roles on itself that let it create keys or tokens outside of Vault: %s", strings.Join(selfBindings, ", "))
+			if cfg.denySelfEscalatingBindings() {
+				return logical.ErrorResponse(msg + "; deny_self_escalating_bindings is set"), nil
+			}
+			warnings = append(warnings, msg)
+		}
+	}
+	if rs.SecretType == SecretTypeAccessToken && (hasScopes || hasServices || newBindings) && cfg.warnUnmatchedScopes() {
+		if unmatched := unmatchedScopes(scopes, checkBindings); len(unmatched) > 0 {
+			warnings = append(warnings, fmt.Sprintf("token_scopes include scopes for services none of the bound roles are for, so tokens can't use those APIs: %s", strings.Join(unmatched, ", ")))
+		}
+	}
+
+	if newBindings && rs.bindingHash() != getStringHash(bRaw.(string)) {
+		approval := &bindingApprovalRequest{
+			RoleSet:        rs.Name,
+			Operation:      string(req.Operation),
+			Project:        project,
+			SecretType:     rs.SecretType,
+			Bindings:       checkBindings.asOutput(),
+			BindingMembers: bindingMembersOutput(bindingMembers),
+			EntityID:       req.EntityID,
+		}
+		if !isCreate && rs.AccountId != nil {
+			approval.ServiceAccountEmail = rs.AccountId.EmailOrId
+			approval.PreviousBindings = rs.Bindings.asOutput()
+		}
+		denied, err := cfg.approveBindings(ctx, approval)
+		switch {
+		case err != nil && cfg.BindingApprovalFailOpen:
+			b.Logger().Warn("binding approval webhook failed, applying bindings anyway", "roleset", rs.Name, "error", err)
+			warnings = append(warnings, fmt.Sprintf("bindings were not approved, binding_approval_fail_open is set: %v", err))
+		case err != nil:
+			return logical.ErrorResponse(fmt.Sprintf("bindings could not be approved: %v", err)), nil
+		case denied != "":
+			return logical.ErrorResponse(fmt.Sprintf("bindings were denied by binding_approval_webhook: %s", denied)), nil
 		}
 	}
 
-	if isCreate && !newBindings {
-		return logical.ErrorResponse("bindings are required for new role set"), nil
+	if d.Get("check_org_policy").(bool) {
+		warns, err := b.checkOrgPolicies(ctx, req.Storage, rs, project, checkBindings)
+		warnings = append(warnings, warns...)
+		if err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("role set would violate org policy: %v", err)), nil
+		}
 	}
 
 	// If no new bindings or new bindings are exactly same as old bindings,
 	// just update the role set without rotating service account.
 	if !newBindings || rs.bindingHash() == getStringHash(bRaw.(string)) {
+		if newTokenCreators {
+			iamAdmin, err := b.IAMAdminClient(req.Storage)
+			if err != nil {
+				return nil, err
+			}
+			toAdd := util.ToSet(rs.TokenCreators).Sub(util.ToSet(oldTokenCreators))
+			toRemove := util.ToSet(oldTokenCreators).Sub(util.ToSet(rs.TokenCreators))
+			if err := updateTokenCreators(ctx, iamAdmin, rs.AccountId, toAdd.ToSlice(), toRemove.ToSlice()); err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+		}
+
+		// Changing secret type keeps the service account and bindings; only
+		// the key used to generate access tokens is added or removed.
+		if changedSecretType {
+			warn, err := b.changeRoleSetSecretType(ctx, req.Storage, rs, oldSecretType, scopes)
+			if err != nil {
+				return logical.ErrorResponse(err.Error()), nil
+			}
+			if warn != "" {
+				warnings = append(warnings, warn)
+			}
+		}
+
 		// Just save role with updated metadata:
 		if err := rs.save(ctx, req.Storage); err != nil {
 			return logical.ErrorResponse(err.Error()), nil
 		}
+		if len(warnings) > 0 {
+			return &logical.Response{Warnings: warnings}, nil
+		}
 		return nil, nil
 	}
 
 	// If new bindings, update service account.
-	var bindings ResourceBindings
-	bindings, err = util.ParseBindings(bRaw.(string))
-	if err != nil {
-		return logical.ErrorResponse(fmt.Sprintf("unable to parse bindings: %v", err)), nil
-	}
-	if len(bindings) == 0 {
-		return logical.ErrorResponse("unable to parse any bindings from given bindings HCL"), nil
-	}
 	rs.RawBindings = bRaw.(string)
+	rs.BindingMembers = bindingMembers
+	rs.WildcardExpansions = wildcardExpansions
 
-	updateWarns, err := b.saveRoleSetWithNewAccount(ctx, req.Storage, rs, project, bindings, scopes)
+	if d.Get("validate_resources").(bool) {
+		httpC, err := b.HTTPClient(req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		apiHandle := iamutil.GetApiHandle(httpC, useragent.String())
+		if err := b.probeBindingResources(ctx, apiHandle, bindings); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid bindings: %v", err)), nil
+		}
+	}
+
+	updateWarns, err := b.saveRoleSetWithNewAccount(ctx, req.Storage, rs, project, bindings, scopes, asyncBindings)
 	if updateWarns != nil {
 		warnings = append(warnings, updateWarns...)
 	}
 	if err != nil {
 		return logical.ErrorResponse(err.Error()), nil
-	} else if warnings != nil && len(warnings) > 0 {
+	}
+
+	if isCreate && idempotencyToken != "" {
+		if err := putIdempotencyRecord(ctx, req.Storage, name, idempotencyToken); err != nil {
+			warnings = append(warnings, fmt.Sprintf("unable to save idempotency_token, retries of this request will update the role set: %v", err))
+		}
+	}
+
+	if warnings != nil && len(warnings) > 0 {
 		return &logical.Response{Warnings: warnings}, nil
 	}
 	return nil, nil
@@ -416,11 +1205,17 @@ func (b *backend) pathRoleSetRotateAccount(ctx context.Context, req *logical.Req
 	}
 
 	var scopes []string
-	if rs.TokenGen != nil {
-		scopes = rs.TokenGen.Scopes
+	var scopesWarn string
+	if rs.SecretType == SecretTypeAccessToken {
+		if scopes, scopesWarn, err = b.roleSetTokenScopes(ctx, req.Storage, rs); err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
 	}
 
-	warnings, err := b.saveRoleSetWithNewAccount(ctx, req.Storage, rs, rs.AccountId.Project, nil, scopes)
+	warnings, err := b.saveRoleSetWithNewAccount(ctx, req.Storage, rs, rs.project(), nil, scopes, false)
+	if scopesWarn != "" {
+		warnings = append(warnings, scopesWarn)
+	}
 	if err != nil {
 		return logical.ErrorResponse(err.Error()), nil
 	} else if warnings != nil && len(warnings) > 0 {
@@ -447,16 +1242,22 @@ func (b *backend) pathRoleSetRotateKey(ctx context.Context, req *logical.Request
 	if rs.SecretType != SecretTypeAccessToken {
 		return logical.ErrorResponse("cannot rotate key for non-access-token role set"), nil
 	}
-	var scopes []string
-	if rs.TokenGen != nil {
-		scopes = rs.TokenGen.Scopes
+	scopes, scopesWarn, err := b.roleSetTokenScopes(ctx, req.Storage, rs)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
 	}
 	warn, err := b.saveRoleSetWithNewTokenKey(ctx, req.Storage, rs, scopes)
 	if err != nil {
 		return logical.ErrorResponse(err.Error()), nil
 	}
-	if warn != "" {
-		return &logical.Response{Warnings: []string{warn}}, nil
+	var warnings []string
+	for _, w := range []string{scopesWarn, warn} {
+		if w != "" {
+			warnings = append(warnings, w)
+		}
+	}
+	if len(warnings) > 0 {
+		return &logical.Response{Warnings: warnings}, nil
 	}
 	return nil, nil
 }
@@ -494,8 +1295,83 @@ resource "some/gcp/resource/uri" {
 		"roles/role3",
 		...
 	]
+	members = [
+		"group:admins@example.com",
+		...
+	]
 }
 
+The optional "members" list grants the resource's roles to members besides
+the role set's service account, as "user:", "group:", "serviceAccount:" or
+"domain:" followed by an email address or domain. They are granted and
+revoked together with the role set's bindings, and are read back in
+"binding_members". A member that already had a role before Vault granted
+it keeps it: only grants Vault added are revoked when the bindings change
+or the role set is deleted. Asynchronous bindings and reconcile add missing
+members like missing bindings.
+
+Bound resources are not limited to the role set's project; a single
+role set may bind roles on resources in several projects. The "project"
+parameter only determines where the role set's service account is created.
+Before the service account is created, every bound resource is parsed and
+checked with testIamPermissions for the permission to set its IAM policy,
+such as resourcemanager.projects.setIamPolicy, so that credentials that can
+only read a resource's policy are rejected up front. Resources without a
+testIamPermissions method, such as buckets, are only checked to have a
+readable IAM policy. If "validate_resources" is set, each bound resource is
+also probed with getIamPolicy, and the error for each tells a resource that
+doesn't exist apart from one the credentials lack access to.
+
+If "wildcard_project_parent" is set on the config, a project resource can
+name projects with a wildcard in its ID, such as "projects/team-a-*". It is
+expanded to the matching projects under that folder or organization when
+the bindings are written; the concrete projects are bound and stored, and
+read back in "wildcard_expansions" by pattern.
+
+Instead of scope URLs, access token role sets may list GCP services by name
+in "token_services", such as "storage" or "bigquery", and their scopes are
+added to "token_scopes", which is what the role set is read back with. Names
+ending in "-read" map to read-only scopes where the API has them, such as
+"storage-read". Unknown names are rejected with the list of known ones.
+Services whose APIs only accept the cloud-platform scope, such as Secret
+Manager, have no name and need "token_scopes".
+
+If fields are invalid, they are all reported in one error, which lists a
+"* <field>: <reason>" line for each reason after a summary line, so both
+people and automation can tell which fields to fix. Checks against GCP and
+the backend config are only made once the fields are valid.
+
+Changing the bindings of a role set creates a new service account with the
+new bindings, and the old account and its bindings are only removed once it
+is saved. If any step fails, such as applying a binding, the new account and
+the bindings already applied to it are removed before the error is returned,
+so the role set and its bindings in GCP stay as they were. Whatever can't be
+removed right away is retried by the backend's periodic rollback, and the
+error says so.
+
+The "secret_type" of an existing role set may be changed. The service
+account and bindings are kept; a key used to generate access tokens is
+created or deleted as needed. Secrets issued under the old type remain
+valid until their leases expire.
+
+Reading a role set returns "bindings_last_applied", the time its bindings
+were last successfully applied or reconciled, once that has happened.
+
+Setting "check_org_policy" checks the role set against org policies before
+any IAM policy is changed: creating keys (used by key and access token role
+sets) when iam.disableServiceAccountKeyCreation is enforced, binding
+resources in projects that deny all members through
+iam.allowedPolicyMemberDomains, and public token_creators where it restricts
+members. The check is best effort; it can't tell which customer a member
+belongs to, and policies it can't read only produce warnings.
+
+Creating a role set with "async_bindings" set returns once its service
+account is created, and applies its bindings in the background, retrying on
+failure. Until they are applied, "bindings_status" on read is "pending", or
+"failed" with the last error in "bindings_error", and secrets issued under
+the role set come with a warning that their permissions may not be effective
+yet. Otherwise it is "applied".
+
 The given resource can have the following
 
 * Project-level self link
@@ -520,6 +1396,14 @@ The given resource can have the following
 	Example (IAM service account):
 		//$SERVICE.googleapis.com/projects/my-project/serviceAccounts/myserviceaccount@...
 
+	Resource types this backend has no built-in support
+	for may still be given by full resource name, as long
+	as the service exposes the standard IAM v1
+	getIamPolicy/setIamPolicy methods.
+
+	Example (Secret Manager secret):
+		//secretmanager.googleapis.com/projects/my-project/secrets/my-secret
+
 * Relative Resource Name:
 	A URI path (path-noscheme) without the leading "/".
 	It identifies a resource within the API service.
@@ -531,6 +1415,82 @@ The given resource can have the following
 
 	Example (Pubsub subscription):
 		projects/myproject/subscriptions/mysub
+
+A role set that generates service account keys cannot be deleted while
+keys issued under it have active leases, unless "force" is set. Forcing the
+deletion deletes those keys; their leases can still be revoked afterwards.
+Keys issued before lease tracking was added are not counted.
+
+"key_cleanup_on_delete", or the config setting of the same name, controls
+what happens to those keys. With "revoke" (the default) they are deleted
+with the role set. With "expire" they stay valid until their leases are
+revoked or expire, and cannot be renewed; the service account and bindings
+they depend on are kept until then and removed with the last of the leases.
+The role set name cannot be reused in the meantime.
+
+Deleting a role set with "dry_run" set deletes nothing, and instead returns
+what the deletion would do: the bindings removed by resource
+("removed_bindings", and "removed_binding_members" for additional members
+Vault granted), whether the service account would be deleted, disabled or
+kept until the keys of active leases expire ("service_account_action"), and
+the number of active leases and keys affected. A dry run isn't refused for
+active leases without "force"; a warning says the deletion would be.
+
+Deleting a role set removes its bindings one resource at a time, at most
+"binding_removal_rate" (a config setting) resources per second. Bindings
+that can't be removed are listed in "lingering_bindings" and in a warning,
+and are removed later by WAL rollback.
+
+Reading a role set returns when it was created ("create_time") and last
+written ("last_modified"), and the entity ID and token display name of the
+clients that did so ("created_by" and "last_modified_by"), for role sets
+written since this was recorded.
+
+"key_renewable", true by default, decides whether the leases of keys issued
+under a key role set can be renewed. Renewals extend a lease by the config's
+"ttl", capped so it never outlives the config's "max_ttl" from when the key
+was issued, and are refused past it. With "key_renewable" set to false, new
+leases are issued non-renewable, and renewing leases issued before is
+refused with an error, so a new key has to be generated instead.
+
+"require_control_group" makes the role set refuse to issue or rotate keys,
+issue HMAC keys, access tokens, impersonated credentials or ID tokens, and
+sign blobs or JWTs unless the request was approved through a control group,
+as a guardrail for the riskiest role sets in case a policy doesn't require
+one. Control groups are a Vault Enterprise feature, and the approval is only
+visible to the secrets engine when it runs built into Vault: with the
+engine registered as an external plugin, such role sets issue nothing.
+
+"required_metadata" makes an access token role set only issue tokens to
+requests whose identity entity has all of the given key-value pairs in its
+metadata, set by operators on the entity, such as an environment or team.
+Vault doesn't pass client addresses or other connection details to secrets
+engines, so entity metadata is the request attribute the condition is on.
+Requests without an entity, such as those made with root tokens, are denied.
+
+"allowed_callers" restricts which requests a role set issues credentials
+to, on the same paths as require_control_group, complementing Vault
+policies on the paths. Entries are "entity:<entity ID>",
+"namespace:<namespace ID>" for entities created in a namespace, or
+"auth_mount:<auth mount accessor>" for entities with an alias on that auth
+mount. A request is allowed if its identity entity matches any entry. As with required_metadata, the entity is all the engine
+can observe about the caller, so requests without one are denied.
+
+"allow_token_batch" lets "token-batch" generate tokens for an access token
+role set. A policy granting token-batch can request tokens for any role set
+that allows it, whatever the policies on token/:roleset say, so only set it
+on role sets whose tokens may be handed to everyone with access to
+token-batch.
+
+An "ephemeral" role set is meant for one-off tasks: it issues a single
+secret, which cannot be renewed, and is deleted along with all of its GCP
+resources once that secret expires or "ephemeral_max_age" passes, whichever
+is first. Deletion happens in the background, up to a minute or so late.
+
+Creating a role set may be retried safely by passing the same
+"idempotency_token" on each attempt. If an earlier attempt succeeded, the
+existing role set is returned rather than being updated. Tokens are kept
+for 24 hours.
 `
 
 const pathListRoleSetHelpSyn = `List existing rolesets.`
@@ -549,4 +1509,8 @@ const pathRoleSetRotateKeyHelpDesc = `
 This path allows you to rotate (i.e. recreate) the service account key 
 used to generate access tokens under a given role set. This path only
 applies to role sets that generate access tokens and will not delete
-the associated service account.`
+the associated service account.
+
+This key is the only one the role set mints tokens with, so rotating it is
+the way to replace it on a schedule. Tokens issued with the old key stay
+valid until they expire.`
@@ -0,0 +1,174 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const defaultBatchDeleteInterval = time.Second
+
+func pathRoleSetBatchDelete(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "rolesets/batch-delete",
+		Fields: map[string]*framework.FieldSchema{
+			"names": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Names of the role sets to delete.",
+			},
+			"prefix": {
+				Type:        framework.TypeString,
+				Description: "Delete every role set whose name starts with this prefix.",
+			},
+			"force": {
+				Type:        framework.TypeBool,
+				Description: "Delete role sets even if they have active key leases, as on roleset/:name.",
+			},
+			"key_cleanup_on_delete": {
+				Type:        framework.TypeString,
+				Description: `What to do with the keys of active leases, "revoke" or "expire". Defaults to the backend's key_cleanup_on_delete.`,
+			},
+			"interval": {
+				Type:        framework.TypeDurationSecond,
+				Default:     int(defaultBatchDeleteInterval / time.Second),
+				Description: "Time to wait between deletions, to pace the calls made to GCP. Defaults to 1s.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathRoleSetBatchDelete,
+			},
+		},
+		HelpSynopsis:    pathRoleSetBatchDeleteHelpSyn,
+		HelpDescription: pathRoleSetBatchDeleteHelpDesc,
+	}
+}
+
+func (b *backend) pathRoleSetBatchDelete(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	names := d.Get("names").([]string)
+	prefix := d.Get("prefix").(string)
+	if len(names) == 0 && prefix == "" {
+		return logical.ErrorResponse("one of names or prefix is required"), nil
+	}
+	if len(names) > 0 && prefix != "" {
+		return logical.ErrorResponse("only one of names or prefix can be given"), nil
+	}
+
+	keyCleanup := b.keyCleanupOnDelete(ctx, req.Storage)
+	if raw, ok := d.GetOk("key_cleanup_on_delete"); ok {
+		keyCleanup = raw.(string)
+		if keyCleanup != keyCleanupRevoke && keyCleanup != keyCleanupExpire {
+			return logical.ErrorResponse(fmt.Sprintf("invalid key_cleanup_on_delete %q, must be %q or %q", keyCleanup, keyCleanupRevoke, keyCleanupExpire)), nil
+		}
+	}
+	interval := time.Duration(d.Get("interval").(int)) * time.Second
+	if interval < 0 {
+		return logical.ErrorResponse("interval cannot be negative"), nil
+	}
+	force := d.Get("force").(bool)
+
+	if prefix != "" {
+		rsNames, err := req.Storage.List(ctx, fmt.Sprintf("%s/", rolesetStoragePrefix))
+		if err != nil {
+			return nil, errwrap.Wrapf("unable to list role sets: {{err}}", err)
+		}
+		for _, name := range rsNames {
+			if strings.HasPrefix(name, prefix) {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+	}
+
+	results := make(map[string]interface{}, len(names))
+	var failed []string
+	for i, name := range names {
+		if _, ok := results[name]; ok {
+			continue
+		}
+		if i > 0 && interval > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(interval):
+			}
+		}
+
+		result := b.batchDeleteRoleSet(ctx, req.Storage, name, force, keyCleanup)
+		if _, ok := result["error"]; ok {
+			failed = append(failed, name)
+		}
+		results[name] = result
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"results": results,
+			"deleted": len(results) - len(failed),
+			"failed":  len(failed),
+		},
+	}
+	if len(names) == 0 {
+		resp.AddWarning(fmt.Sprintf("no role sets found with prefix %q", prefix))
+	}
+	if len(failed) > 0 {
+		resp.AddWarning(fmt.Sprintf("%d of %d role sets could not be deleted: %s", len(failed), len(results), strings.Join(failed, ", ")))
+	}
+	return resp, nil
+}
+
+// batchDeleteRoleSet deletes a single role set the way roleset/:name does,
+// returning its entry in the batch summary. Failures are recorded in the
+// entry rather than returned, so the rest of the batch still runs.
+func (b *backend) batchDeleteRoleSet(ctx context.Context, s logical.Storage, name string, force bool, keyCleanup string) map[string]interface{} {
+	fail := func(msg string) map[string]interface{} {
+		return map[string]interface{}{
+			"status": "failed",
+			"error":  msg,
+		}
+	}
+
+	rs, err := getRoleSet(name, ctx, s)
+	if err != nil {
+		return fail(fmt.Sprintf("unable to get role set: %v", err))
+	}
+	if rs == nil {
+		return fail("role set does not exist")
+	}
+
+	warnings, lingering, err := b.deleteRoleSet(ctx, s, rs, force, keyCleanup)
+	if err != nil {
+		return fail(err.Error())
+	}
+	result := map[string]interface{}{
+		"status": "deleted",
+	}
+	if len(warnings) > 0 {
+		result["warnings"] = warnings
+	}
+	if len(lingering) > 0 {
+		result["lingering_bindings"] = lingering
+	}
+	return result
+}
+
+const pathRoleSetBatchDeleteHelpSyn = `Delete several role sets at once.`
+const pathRoleSetBatchDeleteHelpDesc = `
+This path deletes the role sets given by name in "names", or every role set
+whose name starts with "prefix". Each role set is deleted as it would be on
+roleset/:name, with "force" and "key_cleanup_on_delete" applying to all of
+them.
+
+Deletions run one at a time, waiting "interval" between them so a large batch
+doesn't exhaust the GCP IAM quota. A role set that can't be deleted, for
+example because it has active leases and force isn't set, doesn't stop the
+batch; the response has a "results" entry for every role set with its status
+and, for failures, the error, along with counts of deleted and failed role
+sets.
+`
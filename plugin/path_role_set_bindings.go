@@ -0,0 +1,240 @@
+package gcpsecrets
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/go-gcp-common/gcputil"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	bindingsFormatJSON      = "json"
+	bindingsFormatTerraform = "terraform"
+)
+
+func pathRoleSetBindings(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: fmt.Sprintf("roleset/%s/bindings", framework.GenericNameRegex("name")),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role set.",
+			},
+			"format": {
+				Type:        framework.TypeString,
+				Description: fmt.Sprintf("Format of the bindings, %q (the default) or %q for google_*_iam_member resource blocks.", bindingsFormatJSON, bindingsFormatTerraform),
+				Default:     bindingsFormatJSON,
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathRoleSetBindingsRead,
+			},
+		},
+		HelpSynopsis:    pathRoleSetBindingsHelpSyn,
+		HelpDescription: pathRoleSetBindingsHelpDesc,
+	}
+}
+
+func (b *backend) pathRoleSetBindingsRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+	format := d.Get("format").(string)
+	if format != bindingsFormatJSON && format != bindingsFormatTerraform {
+		return logical.ErrorResponse(fmt.Sprintf("invalid format %q, must be %q or %q", format, bindingsFormatJSON, bindingsFormatTerraform)), nil
+	}
+
+	rs, err := getRoleSet(name, ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if rs == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role set '%s' does not exist", name)), nil
+	}
+	if rs.AccountId == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role set '%s' has no service account", name)), nil
+	}
+
+	if format == bindingsFormatJSON {
+		data := map[string]interface{}{
+			"service_account_email": rs.AccountId.EmailOrId,
+			"bindings":              rs.Bindings.asOutput(),
+		}
+		if len(rs.BindingMembers) > 0 {
+			data["binding_members"] = bindingMembersOutput(rs.BindingMembers)
+		}
+		return &logical.Response{Data: data}, nil
+	}
+
+	hcl, unsupported := terraformIamMembers(rs)
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"service_account_email": rs.AccountId.EmailOrId,
+			"terraform":             hcl,
+		},
+	}
+	if len(unsupported) > 0 {
+		resp.Data["unsupported_resources"] = unsupported
+		resp.AddWarning(fmt.Sprintf("%d bound resources have no known Terraform IAM member resource and were left out", len(unsupported)))
+	}
+	return resp, nil
+}
+
+// terraformIamMember describes the Terraform IAM member resource of a type
+// of GCP resource: its type, and the arguments naming the GCP resource as
+// built from the IDs of the resource's relative name.
+type terraformIamMember struct {
+	resourceType string
+	args         func(ids map[string]string, relName string) [][2]string
+}
+
+// terraformIamMemberTypes maps the type keys of GCP relative resource names to
+// their Terraform IAM member resources.
+var terraformIamMemberTypes = map[string]terraformIamMember{
+	"projects": {"google_project_iam_member", func(ids map[string]string, _ string) [][2]string {
+		return [][2]string{{"project", ids["projects"]}}
+	}},
+	"folders": {"google_folder_iam_member", func(_ map[string]string, relName string) [][2]string {
+		return [][2]string{{"folder", relName}}
+	}},
+	"organizations": {"google_organization_iam_member", func(ids map[string]string, _ string) [][2]string {
+		return [][2]string{{"org_id", ids["organizations"]}}
+	}},
+	"b": {"google_storage_bucket_iam_member", func(ids map[string]string, _ string) [][2]string {
+		return [][2]string{{"bucket", ids["b"]}}
+	}},
+	"projects/topics": {"google_pubsub_topic_iam_member", func(ids map[string]string, _ string) [][2]string {
+		return [][2]string{{"project", ids["projects"]}, {"topic", ids["topics"]}}
+	}},
+	"projects/subscriptions": {"google_pubsub_subscription_iam_member", func(ids map[string]string, _ string) [][2]string {
+		return [][2]string{{"project", ids["projects"]}, {"subscription", ids["subscriptions"]}}
+	}},
+	"projects/serviceAccounts": {"google_service_account_iam_member", func(_ map[string]string, relName string) [][2]string {
+		return [][2]string{{"service_account_id", relName}}
+	}},
+	"projects/secrets": {"google_secret_manager_secret_iam_member", func(ids map[string]string, _ string) [][2]string {
+		return [][2]string{{"project", ids["projects"]}, {"secret_id", ids["secrets"]}}
+	}},
+	"projects/datasets": {"google_bigquery_dataset_iam_member", func(ids map[string]string, _ string) [][2]string {
+		return [][2]string{{"project", ids["projects"]}, {"dataset_id", ids["datasets"]}}
+	}},
+	"projects/locations/keyRings": {"google_kms_key_ring_iam_member", func(_ map[string]string, relName string) [][2]string {
+		return [][2]string{{"key_ring_id", relName}}
+	}},
+	"projects/locations/keyRings/cryptoKeys": {"google_kms_crypto_key_iam_member", func(_ map[string]string, relName string) [][2]string {
+		return [][2]string{{"crypto_key_id", relName}}
+	}},
+}
+
+var terraformNameInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// terraformIamMembers returns the role set's bindings, including their
+// additional members, as HCL resource blocks, sorted by resource, role and
+// member. Bound resources of types without a known Terraform resource are
+// returned separately.
+func terraformIamMembers(rs *RoleSet) (string, []string) {
+	var buf bytes.Buffer
+	unsupported := make([]string, 0)
+	prefix := "vault_" + terraformNameInvalidChars.ReplaceAllString(rs.Name, "_")
+	count := 0
+	for _, rName := range sortedResourceNames(rs.Bindings) {
+		relName, err := relativeResourceName(rName)
+		var tf terraformIamMember
+		var ok bool
+		if err == nil && relName != nil {
+			tf, ok = terraformIamMemberTypes[relName.TypeKey]
+		}
+		if !ok {
+			unsupported = append(unsupported, rName)
+			continue
+		}
+
+		members := []string{"serviceAccount:" + rs.AccountId.EmailOrId}
+		extra := rs.BindingMembers[rName].ToSlice()
+		sort.Strings(extra)
+		members = append(members, extra...)
+
+		roles := rs.Bindings[rName].ToSlice()
+		sort.Strings(roles)
+		for _, role := range roles {
+			for _, member := range members {
+				fmt.Fprintf(&buf, "resource %s %s {\n", strconv.Quote(tf.resourceType), strconv.Quote(fmt.Sprintf("%s_%d", prefix, count)))
+				for _, arg := range tf.args(relName.IdTuples, relativeNamePath(relName)) {
+					fmt.Fprintf(&buf, "  %s = %s\n", arg[0], strconv.Quote(arg[1]))
+				}
+				fmt.Fprintf(&buf, "  role = %s\n", strconv.Quote(role))
+				fmt.Fprintf(&buf, "  member = %s\n", strconv.Quote(member))
+				buf.WriteString("}\n\n")
+				count++
+			}
+		}
+	}
+	return buf.String(), unsupported
+}
+
+// relativeResourceName returns the relative name of a bound resource given
+// as a self-link, full resource name or relative name, as accepted in
+// bindings.
+func relativeResourceName(rawName string) (*gcputil.RelativeResourceName, error) {
+	rUrl, err := url.Parse(rawName)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case rUrl.Scheme != "":
+		selfLink, err := gcputil.ParseProjectResourceSelfLink(rawName)
+		if err != nil {
+			return nil, err
+		}
+		return selfLink.RelativeResourceName, nil
+	case rUrl.Host != "":
+		fullName, err := gcputil.ParseFullResourceName(rawName)
+		if err != nil {
+			return nil, err
+		}
+		return fullName.RelativeResourceName, nil
+	default:
+		return gcputil.ParseRelativeName(rawName)
+	}
+}
+
+// relativeNamePath returns the relative name as a path, such as
+// "projects/my-project/serviceAccounts/my-sa@...".
+func relativeNamePath(relName *gcputil.RelativeResourceName) string {
+	parts := make([]string, 0, 2*len(relName.OrderedCollectionIds))
+	for _, collection := range relName.OrderedCollectionIds {
+		parts = append(parts, collection, relName.IdTuples[collection])
+	}
+	return strings.Join(parts, "/")
+}
+
+const pathRoleSetBindingsHelpSyn = `Read a role set's bindings, optionally as Terraform resources.`
+const pathRoleSetBindingsHelpDesc = `
+This path returns the bindings of the role set and the email of its service
+account. With "format" set to "json", the default, the bindings are
+returned in "bindings" by resource, as when reading the role set, along with
+any additional members in "binding_members".
+
+With "format" set to "terraform", they are returned in "terraform" as HCL
+resource blocks, one per role and member, such as:
+
+resource "google_project_iam_member" "vault_my-roleset_0" {
+  project = "my-project"
+  role = "roles/viewer"
+  member = "serviceAccount:vaultmy-roleset-1234@my-project.iam.gserviceaccount.com"
+}
+
+for projects, folders, organizations, storage buckets, Pub/Sub topics and
+subscriptions, service accounts, Secret Manager secrets, BigQuery datasets
+and KMS key rings and keys. Other bound resources are listed in
+"unsupported_resources" with a warning. The service account itself is
+managed by Vault and replaced when the bindings change, so it is referenced
+by email rather than as a Terraform resource.
+`
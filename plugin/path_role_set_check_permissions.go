@@ -0,0 +1,150 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/iamutil"
+	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/util"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/useragent"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/iamcredentials/v1"
+)
+
+const (
+	cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+	// checkPermissionsTokenLifetime is the lifetime of the token generated to
+	// test permissions as a role set's service account.
+	checkPermissionsTokenLifetime = "300s"
+)
+
+func pathRoleSetCheckPermissions(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: fmt.Sprintf("roleset/%s/check-permissions", framework.GenericNameRegex("name")),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role set.",
+			},
+			"resource": {
+				Type:        framework.TypeString,
+				Description: "Required. Resource to check permissions on, in the same format as resources in bindings.",
+			},
+			"permissions": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `Required. Permissions to check, e.g. "storage.objects.get".`,
+			},
+		},
+		ExistenceCheck: b.pathRoleSetExistenceCheck("name"),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathRoleSetCheckPermissions,
+			},
+		},
+		HelpSynopsis:    pathRoleSetCheckPermissionsHelpSyn,
+		HelpDescription: pathRoleSetCheckPermissionsHelpDesc,
+	}
+}
+
+func (b *backend) pathRoleSetCheckPermissions(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	rsName := d.Get("name").(string)
+	resName := d.Get("resource").(string)
+	if resName == "" {
+		return logical.ErrorResponse("resource is required"), nil
+	}
+	permissions := d.Get("permissions").([]string)
+	if len(permissions) == 0 {
+		return logical.ErrorResponse("permissions are required"), nil
+	}
+
+	rs, err := getRoleSet(rsName, ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if rs == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role set '%s' does not exist", rsName)), nil
+	}
+	if rs.AccountId == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role set '%s' is invalid, has no associated service account", rsName)), nil
+	}
+
+	resource, err := b.resources.Parse(resName)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("unable to parse resource %q: %v", resName, err)), nil
+	}
+
+	token, err := b.roleSetAccessToken(ctx, req.Storage, rs)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("unable to get a token for role set '%s' service account: %v", rsName, err)), nil
+	}
+
+	proxyCtx, err := proxyContext(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	httpC := oauth2.NewClient(proxyCtx, oauth2.StaticTokenSource(token))
+	apiHandle := iamutil.GetApiHandle(httpC, useragent.String())
+	granted, err := apiHandle.DoTestPermissionsRequest(ctx, resource, permissions)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("unable to test permissions on resource %q: %v", resName, err)), nil
+	}
+
+	denied := util.ToSet(permissions).Sub(util.ToSet(granted)).ToSlice()
+	sort.Strings(granted)
+	sort.Strings(denied)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"resource": resName,
+			"granted":  granted,
+			"denied":   denied,
+		},
+	}, nil
+}
+
+// roleSetAccessToken returns a short-lived cloud-platform access token for the
+// role set's service account. The role set's own token key is used if it has
+// one; otherwise the token is generated through the IAM Credentials API.
+func (b *backend) roleSetAccessToken(ctx context.Context, s logical.Storage, rs *RoleSet) (*oauth2.Token, error) {
+	if rs.TokenGen != nil && rs.TokenGen.B64KeyJSON != "" {
+		ctx, err := proxyContext(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+		return rs.TokenGen.getAccessToken(ctx, []string{cloudPlatformScope})
+	}
+
+	credsC, err := b.IAMCredentialsClient(s)
+	if err != nil {
+		return nil, errwrap.Wrapf("could not create IAM Credentials client: {{err}}", err)
+	}
+	resp, err := credsC.Projects.ServiceAccounts.GenerateAccessToken(signingAccountName(rs), &iamcredentials.GenerateAccessTokenRequest{
+		Scope:    []string{cloudPlatformScope},
+		Lifetime: checkPermissionsTokenLifetime,
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return &oauth2.Token{
+		AccessToken: resp.AccessToken,
+		TokenType:   "Bearer",
+	}, nil
+}
+
+const pathRoleSetCheckPermissionsHelpSyn = `Check which permissions a role set's service account has on a resource.`
+const pathRoleSetCheckPermissionsHelpDesc = `
+This path calls testIamPermissions on the given resource as the role set's
+service account, and returns which of the given permissions are granted and
+which are denied. Unlike inspecting the role set's bindings, this takes
+roles inherited from parent resources into account.
+
+A short-lived token for the service account is used. For role sets without
+their own token key, it is generated through the IAM Credentials API, which
+requires the backend's credentials to have
+roles/iam.serviceAccountTokenCreator on the service account.
+`
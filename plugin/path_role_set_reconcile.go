@@ -0,0 +1,236 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/iamutil"
+	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/util"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/useragent"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func pathRoleSetReconcile(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: fmt.Sprintf("roleset/%s/reconcile", framework.GenericNameRegex("name")),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role set.",
+			},
+		},
+		ExistenceCheck: b.pathRoleSetExistenceCheck("name"),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathRoleSetReconcile,
+			},
+		},
+		HelpSynopsis:    pathRoleSetReconcileHelpSyn,
+		HelpDescription: pathRoleSetReconcileHelpDesc,
+	}
+}
+
+func (b *backend) pathRoleSetReconcile(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	name := d.Get("name").(string)
+
+	added, err := b.reconcileRoleSet(ctx, req.Storage, name)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if added == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role set '%s' does not exist", name)), nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"added_bindings": added.asOutput(),
+		},
+	}, nil
+}
+
+// reconcileRoleSet re-adds any of the role set's bindings that are missing
+// from the live IAM policies of its bound resources, returning the bindings
+// that were re-added. It returns nil bindings if the role set does not exist.
+// Resources that fail to reconcile don't stop the others from being
+// reconciled; their errors are returned together.
+func (b *backend) reconcileRoleSet(ctx context.Context, s logical.Storage, rsName string) (ResourceBindings, error) {
+	b.rolesetLock.Lock()
+	defer b.rolesetLock.Unlock()
+
+	rs, err := getRoleSet(rsName, ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	if rs == nil {
+		return nil, nil
+	}
+	if rs.AccountId == nil {
+		return nil, fmt.Errorf("role set '%s' is invalid, has no associated service account", rsName)
+	}
+
+	added, err := b.addMissingBindings(ctx, s, rs)
+	if err != nil {
+		return added, err
+	}
+
+	rs.BindingsLastApplied = time.Now().UTC()
+	rs.BindingsStatus = bindingsStatusApplied
+	rs.BindingsError = ""
+	if err := rs.save(ctx, s); err != nil {
+		return added, errwrap.Wrapf("unable to record when bindings were reconciled: {{err}}", err)
+	}
+	return added, nil
+}
+
+// addMissingBindings adds any of the role set's bindings that are missing
+// from the IAM policies of its bound resources to them, returning the
+// bindings that were added. Resources that fail don't stop the others from
+// being updated; their errors are returned together.
+func (b *backend) addMissingBindings(ctx context.Context, s logical.Storage, rs *RoleSet) (ResourceBindings, error) {
+	httpC, err := b.HTTPClient(s)
+	if err != nil {
+		return nil, err
+	}
+	apiHandle := iamutil.GetApiHandle(httpC, useragent.String())
+	maxRetries := b.maxBindingRetries(ctx, s)
+
+	rNames := make([]string, 0, len(rs.Bindings))
+	for rName := range rs.Bindings {
+		rNames = append(rNames, rName)
+	}
+	sort.Strings(rNames)
+
+	added := make(ResourceBindings)
+	var merr *multierror.Error
+	for _, rName := range rNames {
+		if err := ctx.Err(); err != nil {
+			merr = multierror.Append(merr, errwrap.Wrapf("request aborted while adding bindings to IAM policies: {{err}}", err))
+			break
+		}
+
+		resource, err := b.resources.Parse(rName)
+		if err != nil {
+			merr = multierror.Append(merr, err)
+			continue
+		}
+
+		var missing util.StringSet
+		var missingMembers RoleMembers
+		_, err = setIamPolicyWithRetry(ctx, apiHandle, resource, maxRetries, func(p *iamutil.Policy) (bool, *iamutil.Policy) {
+			missing = missingRoles(p, rs.AccountId.EmailOrId, rs.Bindings[rName])
+			missingMembers = missingRoleMembers(p, rs.desiredMembers(rName))
+			if len(missing) == 0 && len(missingMembers) == 0 {
+				return false, p
+			}
+			_, p = p.AddBindings(&iamutil.PolicyDelta{
+				Roles: missing,
+				Email: rs.AccountId.EmailOrId,
+			})
+			_, p = p.AddMembers(missingMembers)
+			return true, p
+		})
+		if err != nil {
+			err = b.explainPermissionDenied(ctx, s, newSetIamPolicyError(rName, resource, err))
+			merr = multierror.Append(merr, errwrap.Wrapf(fmt.Sprintf("unable to add bindings to IAM policy for resource %q: {{err}}", rName), err))
+			continue
+		}
+		if len(missingMembers) > 0 {
+			rs.addAddedMembers(rName, missingMembers)
+		}
+		if len(missing) > 0 {
+			added[rName] = missing
+		}
+		if len(missing) > 0 || len(missingMembers) > 0 {
+			b.usage.record(rs.Name, usageBindingApplies, 1)
+		}
+	}
+	return added, merr.ErrorOrNil()
+}
+
+// missingRoles returns the roles that the policy doesn't grant the service
+// account unconditionally.
+func missingRoles(p *iamutil.Policy, email string, roles util.StringSet) util.StringSet {
+	member := fmt.Sprintf(iamutil.ServiceAccountMemberTmpl, email)
+	granted := make(util.StringSet)
+	for _, bind := range p.Bindings {
+		if bind.Condition == nil && util.ToSet(bind.Members).Includes(member) {
+			granted.Add(bind.Role)
+		}
+	}
+	return roles.Sub(granted)
+}
+
+// missingRoleMembers returns the pairs of desired that the policy doesn't
+// grant unconditionally.
+func missingRoleMembers(p *iamutil.Policy, desired RoleMembers) RoleMembers {
+	missing := make(RoleMembers)
+	for role, members := range desired {
+		for m := range members {
+			if !p.HasMember(role, m) {
+				if missing[role] == nil {
+					missing[role] = make(util.StringSet)
+				}
+				missing[role].Add(m)
+			}
+		}
+	}
+	return missing
+}
+
+// periodicReconcile reconciles the bindings of every role set, and reaps
+// untracked keys if enabled, if the configured reconcile_interval has passed
+// since the last run. Failures are logged rather than returned so they don't
+// affect other periodic work.
+func (b *backend) periodicReconcile(ctx context.Context, req *logical.Request) error {
+	cfg, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return err
+	}
+	if cfg == nil || cfg.ReconcileInterval <= 0 {
+		return nil
+	}
+
+	b.reconcileLock.Lock()
+	defer b.reconcileLock.Unlock()
+	if time.Since(b.lastReconcile) < cfg.ReconcileInterval {
+		return nil
+	}
+	b.lastReconcile = time.Now()
+
+	rsNames, err := req.Storage.List(ctx, fmt.Sprintf("%s/", rolesetStoragePrefix))
+	if err != nil {
+		return err
+	}
+	for _, rsName := range rsNames {
+		added, err := b.reconcileRoleSet(ctx, req.Storage, rsName)
+		if err != nil {
+			b.Logger().Warn("unable to reconcile role set bindings", "roleset", rsName, "error", err)
+		}
+		for rName, roles := range added {
+			b.Logger().Info("re-added missing role set bindings", "roleset", rsName, "resource", rName, "roles", roles.ToSlice())
+		}
+	}
+
+	if err := b.reapUntrackedKeys(ctx, req.Storage, cfg); err != nil {
+		b.Logger().Warn("unable to reap untracked keys", "error", err)
+	}
+	return nil
+}
+
+const pathRoleSetReconcileHelpSyn = `Re-add a role set's bindings that are missing from live IAM policies.`
+const pathRoleSetReconcileHelpDesc = `
+This path reads the current IAM policy of each resource bound by the role
+set and re-adds any of the role set's roles that its service account no
+longer has, for example because the policy was edited outside of Vault.
+The bindings that were re-added are returned as "added_bindings". If every
+resource was reconciled, the role set's "bindings_last_applied" is updated.
+
+Other members and roles in the policies are left untouched. Reconciling can
+also be done periodically for all role sets by setting "reconcile_interval"
+on the config endpoint.
+`
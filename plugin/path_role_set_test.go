@@ -2,16 +2,27 @@ package gcpsecrets
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/go-gcp-common/gcputil"
+	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/iamutil"
 	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/util"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/consts"
 	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/hashicorp/vault/sdk/logical"
 	"google.golang.org/api/cloudresourcemanager/v1"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iam/v1"
 	"google.golang.org/api/option"
 )
@@ -63,391 +74,2524 @@ func TestPathRoleSet_Basic(t *testing.T) {
 	// Verify service account exists and has given role on project
 	sa := getServiceAccount(t, td.IamAdmin, respData)
 	verifyProjectBinding(t, td, sa.Email, roles)
+	if act := respData["service_account_unique_id"]; act != sa.UniqueId {
+		t.Errorf("expected service_account_unique_id %q, got %v", sa.UniqueId, act)
+	}
+	if _, ok := respData["bindings_last_applied"]; !ok {
+		t.Errorf("expected bindings_last_applied to be set after applying bindings")
+	}
 
 	// 4. Delete role set
 	testRoleSetDelete(t, td, rsName, sa.Name)
 	verifyProjectBindingsRemoved(t, td, sa.Email, roles)
 }
 
-func TestPathRoleSet_UpdateKeyRoleSet(t *testing.T) {
-	rsName := "test-updatekeyrs"
-	initRoles := util.StringSet{
-		"roles/viewer": struct{}{},
+func TestPathRoleSet_ValidateBindingResources(t *testing.T) {
+	t.Parallel()
+
+	b, _ := getTestBackend(t)
+
+	// Resources in several projects are allowed, but every one of them must
+	// be a resource the backend knows how to manage. Parsing fails before
+	// any GCP call is made.
+	binds := ResourceBindings{
+		"projects/project-a/notAResource/foo": util.StringSet{"roles/viewer": struct{}{}},
+		"projects/project-b/notAResource/bar": util.StringSet{"roles/viewer": struct{}{}},
 	}
-	updatedRoles := util.StringSet{
-		"roles/browser":         struct{}{},
-		"roles/cloudsql.client": struct{}{},
+	_, err := b.(*backend).parseBindingResources(binds)
+	if err == nil {
+		t.Fatal("expected error for unsupported resources")
+	}
+	for res := range binds {
+		if !strings.Contains(err.Error(), res) {
+			t.Errorf("expected error to name resource %q, got: %v", res, err)
+		}
 	}
+}
 
-	// Initial test set up - backend, initial config, test resources in project
-	td := setupTest(t, "0s", "2h")
-	defer cleanup(t, td, rsName, initRoles.Union(updatedRoles))
+func TestPathRoleSet_ValidateBindingResourcesSetPermission(t *testing.T) {
+	t.Parallel()
 
-	projRes := fmt.Sprintf(testProjectResourceTemplate, td.Project)
+	var tested []string
+	writable := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Permissions []string `json:"permissions"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+			return
+		}
+		tested = append(tested, req.Permissions...)
+		w.Header().Set("Content-Type", "application/json")
+		if !writable {
+			w.Write([]byte(`{}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string][]string{"permissions": req.Permissions})
+	}))
+	defer srv.Close()
+
+	b, _ := getTestBackend(t)
+	b.(*backend).resources = testPermissionsResources{"buckets/my-bucket": &testPermissionsResource{baseURL: srv.URL}}
+	apiHandle := iamutil.GetApiHandle(srv.Client(), "")
+	binds := ResourceBindings{"buckets/my-bucket": util.StringSet{"roles/viewer": struct{}{}}}
+	if err := b.(*backend).validateBindingResources(context.Background(), apiHandle, binds); err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"storage.buckets.setIamPolicy"}; !reflect.DeepEqual(tested, expected) {
+		t.Fatalf("expected permissions %v to be tested, got %v", expected, tested)
+	}
 
-	// Create role set
-	expectedBinds := ResourceBindings{projRes: initRoles}
-	bindsRaw, err := util.BindingsHCL(expectedBinds)
-	if err != nil {
-		t.Fatalf("unable to convert resource bindings to HCL string: %v", err)
+	// Credentials that can only read the resource's IAM policy are rejected.
+	writable = false
+	err := b.(*backend).validateBindingResources(context.Background(), apiHandle, binds)
+	if err == nil || !strings.Contains(err.Error(), "lack storage.buckets.setIamPolicy") {
+		t.Fatalf("expected an error for a resource without setIamPolicy permission, got %v", err)
 	}
-	testRoleSetCreate(t, td, rsName,
-		map[string]interface{}{
-			"project":     td.Project,
-			"secret_type": SecretTypeKey,
-			"bindings":    bindsRaw,
-		})
+}
 
-	// Verify
-	respData := testRoleSetRead(t, td, rsName)
-	if respData == nil {
-		t.Fatalf("expected role set to have been created")
+func TestBindingResourceError(t *testing.T) {
+	t.Parallel()
+
+	wrap := func(code int) error {
+		return errwrap.Wrapf("unable to get policy: {{err}}", &googleapi.Error{Code: code, Message: "boom"})
+	}
+	cases := map[string]struct {
+		err    error
+		errMsg string
+	}{
+		"not found": {wrap(http.StatusNotFound), "does not exist"},
+		"forbidden": {wrap(http.StatusForbidden), "no access to IAM policy"},
+		"other":     {wrap(http.StatusInternalServerError), "unable to access IAM policy"},
+		"non-api":   {fmt.Errorf("connection refused"), "unable to access IAM policy"},
+	}
+	for name, tc := range cases {
+		err := bindingResourceError("projects/my-project", tc.err)
+		if !strings.Contains(err.Error(), tc.errMsg) {
+			t.Errorf("%s: expected %q to contain %q", name, err.Error(), tc.errMsg)
+		}
+		if !strings.Contains(err.Error(), "projects/my-project") {
+			t.Errorf("%s: expected %q to name the resource", name, err.Error())
+		}
 	}
-	verifyReadData(t, respData, map[string]interface{}{
-		"secret_type": SecretTypeKey,
-		"project":     td.Project,
-		"bindings":    expectedBinds,
-	})
+}
 
-	initSa := getServiceAccount(t, td.IamAdmin, respData)
-	verifyProjectBinding(t, td, initSa.Email, initRoles)
+func TestPathRoleSet_DeleteWithActiveLeases(t *testing.T) {
+	t.Parallel()
 
-	// Verify theses updates don't work:
-	errCases := []map[string]interface{}{
-		{
-			// new project should not be allowed
-			"project": "diff-proj",
-		},
-		{
-			// Cannot be applied to key role sets
-			"secret_type": SecretTypeAccessToken,
-		},
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+
+	rs := testStoredKeyRoleSet(t, storage, "test-activeleases")
+	kl := &keyLease{
+		RoleSet:   rs.Name,
+		KeyName:   rs.AccountId.ResourceName() + "/keys/abc123",
+		IssueTime: time.Now(),
 	}
-	for _, d := range errCases {
-		resp, err := td.B.HandleRequest(context.Background(), &logical.Request{
-			Operation: logical.UpdateOperation,
-			Path:      fmt.Sprintf("roleset/%s", rsName),
-			Data:      d,
-			Storage:   td.S,
-		})
-		if err == nil && (resp != nil && !resp.IsError()) {
-			t.Fatalf("expected update to fail; data: %v", d)
-		}
+	if err := kl.save(ctx, storage); err != nil {
+		t.Fatal(err)
 	}
 
-	// Update role set
-	expectedBinds = ResourceBindings{projRes: updatedRoles}
-	bindsRaw, err = util.BindingsHCL(expectedBinds)
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      fmt.Sprintf("roleset/%s", rs.Name),
+		Storage:   storage,
+	})
 	if err != nil {
-		t.Fatalf("unable to convert resource bindings to HCL string: %v", err)
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected delete with active leases to fail, got: %#v", resp)
+	}
+	if exp, act := "1 active leases", resp.Error().Error(); !strings.Contains(act, exp) {
+		t.Errorf("expected %q to contain %q", act, exp)
 	}
-	testRoleSetUpdate(t, td, rsName,
-		map[string]interface{}{
-			"project":     td.Project,
-			"secret_type": SecretTypeKey,
-			"bindings":    bindsRaw,
-		})
 
-	// Verify
-	respData = testRoleSetRead(t, td, rsName)
-	if respData == nil {
-		t.Fatalf("expected role set to have been created")
+	stored, err := getRoleSet(rs.Name, ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored == nil {
+		t.Fatal("expected role set to not have been deleted")
 	}
-	verifyReadData(t, respData, map[string]interface{}{
-		"secret_type": SecretTypeKey, // default
-		"project":     td.Project,
-		"bindings":    expectedBinds,
-	})
 
-	newSa := getServiceAccount(t, td.IamAdmin, respData)
-	if newSa.Name == initSa.Name {
-		t.Fatalf("expected role set to have new service account after update")
+	// Leases are listed again under the lock, so a key issued after the
+	// role set was read for deletion still refuses it.
+	issuedRs := testStoredKeyRoleSet(t, storage, "test-activeleases-issued")
+	kl = &keyLease{
+		RoleSet:   issuedRs.Name,
+		KeyName:   issuedRs.AccountId.ResourceName() + "/keys/def456",
+		IssueTime: time.Now(),
 	}
-	verifyProjectBinding(t, td, newSa.Email, updatedRoles)
+	if err := kl.save(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = b.(*backend).deleteRoleSet(ctx, storage, issuedRs, false, keyCleanupRevoke)
+	if _, ok := err.(*activeLeasesError); !ok {
+		t.Fatalf("expected active leases error, got: %v", err)
+	}
+	if stored, err := getRoleSet(issuedRs.Name, ctx, storage); err != nil || stored == nil {
+		t.Fatalf("expected role set to not have been deleted, got %v (err: %v)", stored, err)
+	}
+}
 
-	verifyServiceAccountDeleted(t, td.IamAdmin, initSa.Name)
-	verifyProjectBindingsRemoved(t, td, initSa.Email, updatedRoles)
+func TestPathRoleSet_IdempotencyToken(t *testing.T) {
+	t.Parallel()
 
-	// 4. Delete role set
-	testRoleSetDelete(t, td, rsName, newSa.Name)
-	verifyProjectBindingsRemoved(t, td, newSa.Email, updatedRoles)
-}
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
 
-func TestPathRoleSet_RotateKeyRoleSet(t *testing.T) {
-	rsName := "test-rotatekeyrs"
-	roles := util.StringSet{
-		"roles/viewer": struct{}{},
+	rs := testStoredKeyRoleSet(t, storage, "test-idempotency")
+	if err := putIdempotencyRecord(ctx, storage, rs.Name, "retry-token"); err != nil {
+		t.Fatal(err)
 	}
 
-	// Initial test set up - backend, initial config, test resources in project
-	td := setupTest(t, "0s", "2h")
-	defer cleanup(t, td, rsName, roles)
+	// Replaying the create with the same token returns the existing role set
+	// without applying the (different) bindings.
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      fmt.Sprintf("roleset/%s", rs.Name),
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"secret_type":       SecretTypeKey,
+			"project":           "my-project",
+			"bindings":          `resource "projects/my-project" { roles = ["roles/owner"] }`,
+			"idempotency_token": "retry-token",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("expected existing role set, got: %#v", resp)
+	}
+	if len(resp.Warnings) == 0 {
+		t.Errorf("expected warning about replayed create")
+	}
+	if resp.Data["service_account_email"] != rs.AccountId.EmailOrId {
+		t.Errorf("expected service account %q, got %v", rs.AccountId.EmailOrId, resp.Data["service_account_email"])
+	}
 
-	projRes := fmt.Sprintf(testProjectResourceTemplate, td.Project)
+	stored, err := getRoleSet(rs.Name, ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.bindingHash() != rs.bindingHash() {
+		t.Errorf("expected role set bindings to be unchanged")
+	}
 
-	// Create role set
-	expectedBinds := ResourceBindings{projRes: roles}
-	bindsRaw, err := util.BindingsHCL(expectedBinds)
+	// Role set deletion prunes all of its tokens.
+	if err := pruneIdempotencyRecords(ctx, storage, rs.Name, true); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := getIdempotencyRecord(ctx, storage, rs.Name, "retry-token")
 	if err != nil {
-		t.Fatalf("unable to convert resource bindings to HCL string: %v", err)
+		t.Fatal(err)
 	}
-	testRoleSetCreate(t, td, rsName,
-		map[string]interface{}{
-			"project":     td.Project,
-			"secret_type": SecretTypeKey,
-			"bindings":    bindsRaw,
-		})
+	if rec != nil {
+		t.Errorf("expected idempotency record to be removed")
+	}
+}
 
-	// Verify initial role set.
-	respData := testRoleSetRead(t, td, rsName)
-	if respData == nil {
-		t.Fatalf("expected role set to have been created")
+func TestPathRoleSet_ReadBindingsShape(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+
+	inputs := map[string]string{
+		"test-bindings-hcl": `
+			resource "projects/my-project" {
+				roles = ["roles/viewer", "roles/browser"]
+			}
+			resource "//pubsub.googleapis.com/projects/my-project/topics/t" {
+				roles = ["roles/pubsub.publisher"]
+			}`,
+		"test-bindings-json": `{
+			"resource": {
+				"//pubsub.googleapis.com/projects/my-project/topics/t": {
+					"roles": ["roles/pubsub.publisher"]
+				},
+				"projects/my-project": {
+					"roles": ["roles/browser", "roles/viewer"]
+				}
+			}
+		}`,
+	}
+	expected := map[string][]string{
+		"projects/my-project": {"roles/browser", "roles/viewer"},
+		"//pubsub.googleapis.com/projects/my-project/topics/t": {"roles/pubsub.publisher"},
 	}
-	initSa := getServiceAccount(t, td.IamAdmin, respData)
-	verifyProjectBinding(t, td, initSa.Email, roles)
 
-	// Rotate account and verify is new account.
-	testRoleSetRotate(t, td, rsName)
-	newSa := getServiceAccount(t, td.IamAdmin, testRoleSetRead(t, td, rsName))
-	if newSa.Name == initSa.Name {
-		t.Fatalf("expected role set to have new service account after rotation (update)")
+	for rsName, raw := range inputs {
+		bindings, err := util.ParseBindings(raw)
+		if err != nil {
+			t.Fatalf("%s: unable to parse bindings: %v", rsName, err)
+		}
+		rs := &RoleSet{
+			Name:        rsName,
+			SecretType:  SecretTypeKey,
+			RawBindings: raw,
+			Bindings:    bindings,
+			AccountId: &gcputil.ServiceAccountId{
+				Project:   "my-project",
+				EmailOrId: fmt.Sprintf("vault%s@my-project.iam.gserviceaccount.com", rsName),
+			},
+		}
+		if err := rs.save(ctx, storage); err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      fmt.Sprintf("roleset/%s", rsName),
+			Storage:   storage,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil || resp.IsError() {
+			t.Fatalf("%s: unexpected read response: %#v", rsName, resp)
+		}
+		if actual := resp.Data["bindings"]; !reflect.DeepEqual(actual, expected) {
+			t.Errorf("%s: expected bindings %v, got %v", rsName, expected, actual)
+		}
 	}
-	verifyProjectBinding(t, td, newSa.Email, roles)
+}
 
-	// Verify old account/bindings deleted.
-	verifyServiceAccountDeleted(t, td.IamAdmin, initSa.Name)
-	verifyProjectBindingsRemoved(t, td, initSa.Email, roles)
+func TestPathRoleSet_ReadUniqueId(t *testing.T) {
+	t.Parallel()
 
-	// Get RoleSet object for confirming key rotation:
-	rs, err := getRoleSet(rsName, context.Background(), td.S)
-	if rs.TokenGen != nil {
-		t.Fatalf("expected no token gen to have been created for key role set")
+	b, storage := getTestBackend(t)
+
+	rs := testStoredKeyRoleSet(t, storage, "test-uniqueid")
+	if _, ok := testRoleSetReadWithStorage(t, b, storage, rs.Name)["service_account_unique_id"]; ok {
+		t.Fatal("expected no service_account_unique_id for role set without a stored unique ID")
 	}
 
-	// 4. Delete role set
-	testRoleSetDelete(t, td, rsName, newSa.Name)
-	verifyProjectBindingsRemoved(t, td, newSa.Email, roles)
+	rs.AccountUniqueId = "123456789012345678901"
+	if err := rs.save(context.Background(), storage); err != nil {
+		t.Fatal(err)
+	}
+	if act := testRoleSetReadWithStorage(t, b, storage, rs.Name)["service_account_unique_id"]; act != rs.AccountUniqueId {
+		t.Fatalf("expected service_account_unique_id %q, got %v", rs.AccountUniqueId, act)
+	}
 }
 
-func TestPathRoleSet_UpdateTokenRoleSet(t *testing.T) {
-	rsName := "test-updatetokenrs"
-	initRoles := util.StringSet{
-		"roles/viewer": struct{}{},
+func TestPathRoleSet_ReadBindingsLastApplied(t *testing.T) {
+	t.Parallel()
+
+	b, storage := getTestBackend(t)
+
+	rs := testStoredKeyRoleSet(t, storage, "test-lastapplied")
+	if _, ok := testRoleSetReadWithStorage(t, b, storage, rs.Name)["bindings_last_applied"]; ok {
+		t.Fatal("expected no bindings_last_applied for role set whose bindings were never applied")
 	}
-	updatedRoles := util.StringSet{
-		"roles/browser":         struct{}{},
-		"roles/cloudsql.client": struct{}{},
+
+	rs.BindingsLastApplied = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	if err := rs.save(context.Background(), storage); err != nil {
+		t.Fatal(err)
+	}
+	if act := testRoleSetReadWithStorage(t, b, storage, rs.Name)["bindings_last_applied"]; act != "2020-01-02T03:04:05Z" {
+		t.Fatalf("expected bindings_last_applied %q, got %v", "2020-01-02T03:04:05Z", act)
 	}
+}
 
-	// Initial test set up - backend, initial config, test resources in project
-	td := setupTest(t, "0s", "2h")
-	defer cleanup(t, td, rsName, initRoles.Union(updatedRoles))
+func TestPathRoleSet_AsyncBindingsStatus(t *testing.T) {
+	t.Parallel()
 
-	projRes := fmt.Sprintf(testProjectResourceTemplate, td.Project)
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
 
-	// Create role set
-	expectedBinds := ResourceBindings{projRes: initRoles}
-	bindsRaw, err := util.BindingsHCL(expectedBinds)
-	if err != nil {
-		t.Fatalf("unable to convert resource bindings to HCL string: %v", err)
+	rs := testStoredKeyRoleSet(t, storage, "test-asyncbindings")
+	if act := testRoleSetReadWithStorage(t, b, storage, rs.Name)["bindings_status"]; act != bindingsStatusApplied {
+		t.Fatalf("expected bindings_status %q for role set saved without one, got %v", bindingsStatusApplied, act)
 	}
-	testRoleSetCreate(t, td, rsName,
-		map[string]interface{}{
-			"project":      td.Project,
-			"secret_type":  SecretTypeAccessToken,
-			"bindings":     bindsRaw,
-			"token_scopes": []string{"https://www.googleapis.com/auth/cloud-platform"},
-		})
-
-	// Verify
-	respData := testRoleSetRead(t, td, rsName)
-	if respData == nil {
-		t.Fatalf("expected role set to have been created")
+	if warn := rs.bindingsPendingWarning(); warn != "" {
+		t.Errorf("expected no warning for applied bindings, got %q", warn)
 	}
-	verifyReadData(t, respData, map[string]interface{}{
-		"secret_type":  SecretTypeAccessToken,
-		"project":      td.Project,
-		"bindings":     expectedBinds,
-		"token_scopes": []string{"https://www.googleapis.com/auth/cloud-platform"},
-	})
 
-	initSa := getServiceAccount(t, td.IamAdmin, respData)
-	verifyProjectBinding(t, td, initSa.Email, initRoles)
+	rs.BindingsStatus = bindingsStatusFailed
+	rs.BindingsError = "permission denied"
+	if err := rs.save(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	data := testRoleSetReadWithStorage(t, b, storage, rs.Name)
+	if data["bindings_status"] != bindingsStatusFailed || data["bindings_error"] != "permission denied" {
+		t.Fatalf("expected failed bindings_status with error, got %v, %v", data["bindings_status"], data["bindings_error"])
+	}
+	if warn := rs.bindingsPendingWarning(); !strings.Contains(warn, "permission denied") {
+		t.Errorf("expected warning to include the error, got %q", warn)
+	}
 
-	initK := verifyRoleSetTokenKey(t, td, rsName)
-	if !strings.HasPrefix(initK.Name, initSa.Name) {
-		t.Fatalf("expected token key to have been generated under initial service account")
+	// Entries for a service account the role set no longer uses, or for a
+	// deleted role set, are done with and leave the role set alone.
+	for _, entry := range []*walApplyBindings{
+		{RoleSet: rs.Name, AccountId: gcputil.ServiceAccountId{Project: "my-project", EmailOrId: "old@my-project.iam.gserviceaccount.com"}},
+		{RoleSet: "test-asyncbindings-deleted", AccountId: *rs.AccountId},
+	} {
+		if err := b.(*backend).applyPendingBindings(ctx, storage, entry); err != nil {
+			t.Errorf("expected no error for stale entry %v, got %v", entry, err)
+		}
+	}
+	if act := testRoleSetReadWithStorage(t, b, storage, rs.Name)["bindings_status"]; act != bindingsStatusFailed {
+		t.Errorf("expected bindings_status to be unchanged, got %v", act)
 	}
 
-	// Update role set
-	expectedBinds = ResourceBindings{projRes: updatedRoles}
-	bindsRaw, err = util.BindingsHCL(expectedBinds)
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      fmt.Sprintf("roleset/%s", rs.Name),
+		Data:      map[string]interface{}{"async_bindings": true},
+		Storage:   storage,
+	})
 	if err != nil {
-		t.Fatalf("unable to convert resource bindings to HCL string: %v", err)
+		t.Fatal(err)
 	}
-	testRoleSetUpdate(t, td, rsName,
-		map[string]interface{}{
-			"bindings": bindsRaw,
-			"token_scopes": []string{
-				"https://www.googleapis.com/auth/compute",
-				"https://www.googleapis.com/auth/compute.readonly",
-			},
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error setting async_bindings on update, got: %#v", resp)
+	}
+}
+
+func TestPathRoleSet_ModificationMetadata(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+
+	rs := testStoredKeyRoleSet(t, storage, "test-modmetadata")
+	data := testRoleSetReadWithStorage(t, b, storage, rs.Name)
+	for _, k := range []string{"create_time", "created_by", "last_modified", "last_modified_by"} {
+		if _, ok := data[k]; ok {
+			t.Errorf("expected no %s for role set saved without it", k)
+		}
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation:   logical.UpdateOperation,
+		Path:        fmt.Sprintf("roleset/%s", rs.Name),
+		Data:        map[string]interface{}{"key_rotation_period": "2h"},
+		EntityID:    "entity-1234",
+		DisplayName: "oidc-alice",
+		Storage:     storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != nil && resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+
+	data = testRoleSetReadWithStorage(t, b, storage, rs.Name)
+	expected := map[string]interface{}{"entity_id": "entity-1234", "display_name": "oidc-alice"}
+	if !reflect.DeepEqual(data["last_modified_by"], expected) {
+		t.Errorf("expected last_modified_by %v, got %v", expected, data["last_modified_by"])
+	}
+	if _, err := time.Parse(time.RFC3339, fmt.Sprint(data["last_modified"])); err != nil {
+		t.Errorf("expected RFC 3339 last_modified, got %v", data["last_modified"])
+	}
+	if _, ok := data["created_by"]; ok {
+		t.Errorf("expected updates not to set created_by")
+	}
+}
+
+func TestPathRoleSet_Compare(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+
+	rsA := testStoredKeyRoleSet(t, storage, "test-compare-a")
+	rsA.Bindings = ResourceBindings{
+		"projects/my-project": util.ToSet([]string{"roles/viewer", "roles/iam.securityReviewer"}),
+		"//storage.googleapis.com/projects/_/buckets/my-bucket": util.ToSet([]string{"roles/storage.objectViewer"}),
+	}
+	if err := rsA.save(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	rsB := testStoredKeyRoleSet(t, storage, "test-compare-b")
+	rsB.Bindings = ResourceBindings{
+		"//cloudresourcemanager.googleapis.com/projects/my-project": util.ToSet([]string{"roles/iam.securityReviewer", "roles/viewer"}),
+		"//storage.googleapis.com/projects/_/buckets/my-bucket":     util.ToSet([]string{"roles/storage.objectAdmin"}),
+	}
+	if err := rsB.save(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+
+	compare := func(nameA, nameB string) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "rolesets/compare",
+			Data:      map[string]interface{}{"a": nameA, "b": nameB},
+			Storage:   storage,
 		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
 
-	// Verify
-	respData = testRoleSetRead(t, td, rsName)
-	if respData == nil {
-		t.Fatalf("expected role set to have been created")
+	resp := compare(rsA.Name, rsB.Name)
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response: %#v", resp)
 	}
-	verifyReadData(t, respData, map[string]interface{}{
-		"secret_type": SecretTypeAccessToken,
-		"project":     td.Project,
-		"bindings":    expectedBinds,
-		"token_scopes": []string{
-			"https://www.googleapis.com/auth/compute",
-			"https://www.googleapis.com/auth/compute.readonly",
+	bucket := "//storage.googleapis.com/projects/_/buckets/my-bucket"
+	expectedA := map[string][]string{bucket: {"roles/storage.objectViewer"}}
+	expectedB := map[string][]string{bucket: {"roles/storage.objectAdmin"}}
+	if !reflect.DeepEqual(resp.Data["only_in_a"], expectedA) || !reflect.DeepEqual(resp.Data["only_in_b"], expectedB) {
+		t.Errorf("expected differences %v and %v, got %v and %v", expectedA, expectedB, resp.Data["only_in_a"], resp.Data["only_in_b"])
+	}
+	if resp.Data["identical"] != false {
+		t.Errorf("expected role sets not to be identical")
+	}
+
+	if resp := compare(rsA.Name, rsA.Name); resp.Data["identical"] != true {
+		t.Errorf("expected role set to be identical to itself, got %#v", resp.Data)
+	}
+	if resp := compare(rsA.Name, "test-compare-missing"); resp == nil || !resp.IsError() {
+		t.Errorf("expected error comparing with a missing role set, got %#v", resp)
+	}
+}
+
+func TestPathRoleSet_CheckOrgPolicies(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	policies := map[string]*cloudresourcemanager.OrgPolicy{
+		"key-project":        {BooleanPolicy: &cloudresourcemanager.BooleanPolicy{Enforced: true}},
+		"deny-project":       {ListPolicy: &cloudresourcemanager.ListPolicy{AllValues: "DENY"}},
+		"restricted-project": {ListPolicy: &cloudresourcemanager.ListPolicy{AllowedValues: []string{"C0abc123"}}},
+	}
+	b, storage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		var req cloudresourcemanager.GetEffectiveOrgPolicyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		project := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/projects/"), ":getEffectiveOrgPolicy")
+		if project == "unreadable-project" {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"error": {"code": 403, "message": "denied"}}`))
+			return
+		}
+		p := &cloudresourcemanager.OrgPolicy{Constraint: req.Constraint}
+		if configured, ok := policies[project]; ok {
+			isBool := req.Constraint == constraintDisableServiceAccountKeyCreation
+			if isBool == (configured.BooleanPolicy != nil) {
+				p = configured
+			}
+		}
+		json.NewEncoder(w).Encode(p)
+	}))
+
+	bindings := func(projects ...string) ResourceBindings {
+		rb := make(ResourceBindings)
+		for _, p := range projects {
+			rb[fmt.Sprintf(testProjectResourceTemplate, p)] = util.ToSet([]string{"roles/viewer"})
+		}
+		return rb
+	}
+	cases := map[string]struct {
+		rs          *RoleSet
+		project     string
+		bindings    ResourceBindings
+		expectErr   string
+		expectWarns int
+	}{
+		"no violations": {
+			rs:       &RoleSet{SecretType: SecretTypeKey},
+			project:  "my-project",
+			bindings: bindings("my-project", "other-project"),
+		},
+		"key creation disabled": {
+			rs:        &RoleSet{SecretType: SecretTypeAccessToken},
+			project:   "key-project",
+			bindings:  bindings("my-project"),
+			expectErr: "iam.disableServiceAccountKeyCreation",
+		},
+		"key creation disabled, hmac role set": {
+			rs:       &RoleSet{SecretType: SecretTypeHMACKey},
+			project:  "key-project",
+			bindings: bindings("my-project"),
+		},
+		"bound project denies all members": {
+			rs:        &RoleSet{SecretType: SecretTypeKey},
+			project:   "my-project",
+			bindings:  bindings("deny-project"),
+			expectErr: "deny-project",
+		},
+		"public token creator": {
+			rs:        &RoleSet{SecretType: SecretTypeKey, TokenCreators: []string{"allUsers"}},
+			project:   "restricted-project",
+			bindings:  bindings("my-project"),
+			expectErr: "allUsers",
+		},
+		"unchecked token creator": {
+			rs:          &RoleSet{SecretType: SecretTypeKey, TokenCreators: []string{"user:me@example.com"}},
+			project:     "restricted-project",
+			bindings:    bindings("my-project"),
+			expectWarns: 1,
 		},
+		"unreadable policy": {
+			rs:          &RoleSet{SecretType: SecretTypeHMACKey},
+			project:     "my-project",
+			bindings:    bindings("unreadable-project"),
+			expectWarns: 1,
+		},
+	}
+
+	for name, tc := range cases {
+		warns, err := b.(*backend).checkOrgPolicies(ctx, storage, tc.rs, tc.project, tc.bindings)
+		switch {
+		case tc.expectErr == "" && err != nil:
+			t.Errorf("%s: unexpected error: %v", name, err)
+		case tc.expectErr != "" && (err == nil || !strings.Contains(err.Error(), tc.expectErr)):
+			t.Errorf("%s: expected error containing %q, got %v", name, tc.expectErr, err)
+		}
+		if len(warns) != tc.expectWarns {
+			t.Errorf("%s: expected %d warnings, got %v", name, tc.expectWarns, warns)
+		}
+	}
+}
+
+func TestPolicyProjects(t *testing.T) {
+	t.Parallel()
+
+	rb := ResourceBindings{
+		"//cloudresourcemanager.googleapis.com/projects/other-project":           nil,
+		"//compute.googleapis.com/projects/my-project/zones/us-east1-b/disks/d1": nil,
+		"//storage.googleapis.com/projects/_/buckets/my-bucket":                  nil,
+	}
+	expected := []string{"my-project", "other-project"}
+	if act := policyProjects("my-project", rb); !reflect.DeepEqual(act, expected) {
+		t.Fatalf("expected projects %v, got %v", expected, act)
+	}
+}
+
+func TestPathRoleSet_ProjectAccessLists(t *testing.T) {
+	t.Parallel()
+
+	b, storage := getTestBackend(t)
+	testConfigUpdate(t, b, storage, map[string]interface{}{
+		"project_denylist": "prod-project",
 	})
-	newSa := getServiceAccount(t, td.IamAdmin, respData)
-	verifyProjectBinding(t, td, newSa.Email, updatedRoles)
-	newK := verifyRoleSetTokenKey(t, td, rsName)
-	if !strings.HasPrefix(newK.Name, newSa.Name) {
-		t.Fatalf("expected token key to have been generated under new service account")
+
+	bindings := fmt.Sprintf(`resource "%s" { roles = ["roles/viewer"] }`, fmt.Sprintf(testProjectResourceTemplate, "prod-project"))
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roleset/test-denied",
+		Data: map[string]interface{}{
+			"project":     "dev-project",
+			"secret_type": SecretTypeKey,
+			"bindings":    bindings,
+		},
+		Storage: storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "prod-project") {
+		t.Fatalf("expected role set bound in denied project to be rejected, got %#v", resp)
+	}
+
+	cases := map[string]struct {
+		cfg       *config
+		project   string
+		bound     []string
+		expectErr bool
+	}{
+		"no lists":             {&config{}, "prod-project", nil, false},
+		"denied sa project":    {&config{ProjectDenylist: []string{"prod-project"}}, "prod-project", nil, true},
+		"denied bound project": {&config{ProjectDenylist: []string{"prod-project"}}, "dev-project", []string{"prod-project"}, true},
+		"allowed projects":     {&config{ProjectAllowlist: []string{"dev-project", "test-project"}}, "dev-project", []string{"test-project"}, false},
+		"unlisted project":     {&config{ProjectAllowlist: []string{"dev-project"}}, "dev-project", []string{"test-project"}, true},
+		"denylist wins":        {&config{ProjectAllowlist: []string{"dev-project"}, ProjectDenylist: []string{"dev-project"}}, "dev-project", nil, true},
+	}
+	for name, tc := range cases {
+		rb := make(ResourceBindings)
+		for _, p := range tc.bound {
+			rb[fmt.Sprintf(testProjectResourceTemplate, p)] = util.ToSet([]string{"roles/viewer"})
+		}
+		if err := tc.cfg.checkProjectAccess(tc.project, rb); (err != nil) != tc.expectErr {
+			t.Errorf("%s: expected error %t, got %v", name, tc.expectErr, err)
+		}
+	}
+}
+
+func TestPathRoleSet_MaxBindings(t *testing.T) {
+	t.Parallel()
+
+	b, storage := getTestBackend(t)
+	testConfigUpdate(t, b, storage, map[string]interface{}{
+		"max_bindings_per_roleset": 2,
+	})
+	rs := testStoredKeyRoleSet(t, storage, "test-maxbindings")
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roleset/" + rs.Name,
+		Data: map[string]interface{}{
+			"bindings": `
+resource "projects/my-project" { roles = ["roles/viewer", "roles/iam.securityReviewer"] }
+resource "projects/other-project" { roles = ["roles/viewer"] }
+`,
+		},
+		Storage: storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "3 roles on 2 resources") {
+		t.Fatalf("expected bindings over the limit to be rejected, got %#v", resp)
+	}
+}
+
+func TestPathRoleSet_EphemeralClaim(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+
+	rs := testStoredKeyRoleSet(t, storage, "test-ephemeral")
+	rs.Ephemeral = true
+	rs.EphemeralExpireTime = time.Now().Add(time.Hour)
+	if err := rs.save(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+
+	release, err := b.(*backend).claimEphemeralRoleSet(ctx, storage, rs, 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.(*backend).claimEphemeralRoleSet(ctx, storage, rs, 10*time.Minute); err == nil {
+		t.Fatal("expected second claim of ephemeral role set to fail")
+	}
+
+	data := testRoleSetReadWithStorage(t, b, storage, rs.Name)
+	if data["ephemeral_secret_issued"] != true {
+		t.Errorf("expected ephemeral_secret_issued to be true, got %v", data["ephemeral_secret_issued"])
+	}
+	expireTime, err := time.Parse(time.RFC3339, data["ephemeral_expire_time"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expireTime.After(time.Now().Add(10 * time.Minute)) {
+		t.Errorf("expected expire time to be moved up to the secret's expiry, got %s", expireTime)
+	}
+
+	// Releasing the claim, as done when issuing the secret fails, allows
+	// another attempt.
+	release()
+	if _, err := b.(*backend).claimEphemeralRoleSet(ctx, storage, rs, 10*time.Minute); err != nil {
+		t.Fatalf("expected claim after release to succeed: %v", err)
+	}
+
+	// The role set isn't expired yet, so cleanup leaves it alone.
+	if err := b.(*backend).cleanupEphemeralRoleSets(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err := getRoleSet(rs.Name, ctx, storage); err != nil || stored == nil {
+		t.Fatalf("expected unexpired ephemeral role set to remain, got %v, %v", stored, err)
+	}
+}
+
+func TestPathRoleSet_EphemeralSigning(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+
+	rs := testStoredKeyRoleSet(t, storage, "test-ephemeral-signing")
+	rs.Ephemeral = true
+	rs.EphemeralExpireTime = time.Now().Add(-time.Minute)
+	if err := rs.save(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+
+	// Signing issues nothing leased, so ephemeral role sets can't sign.
+	for path, data := range map[string]map[string]interface{}{
+		"sign-blob/" + rs.Name: {"payload": "aGVsbG8="},
+		"sign-jwt/" + rs.Name:  {"claims": map[string]interface{}{"aud": "https://service.example.com"}},
+	} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      path,
+			Data:      data,
+			Storage:   storage,
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+		if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "is ephemeral") {
+			t.Fatalf("%s: expected signing with an ephemeral role set to be refused, got: %#v", path, resp)
+		}
+	}
+
+	// Standbys and performance secondaries leave the expired role set for
+	// the primary's active node to delete.
+	sysView := b.System().(*logical.StaticSystemView)
+	for _, state := range []consts.ReplicationState{consts.ReplicationPerformanceStandby, consts.ReplicationPerformanceSecondary} {
+		sysView.ReplicationStateVal = state
+		if err := b.(*backend).cleanupEphemeralRoleSets(ctx, storage); err != nil {
+			t.Fatal(err)
+		}
+		if stored, err := getRoleSet(rs.Name, ctx, storage); err != nil || stored == nil {
+			t.Fatalf("expected expired ephemeral role set to remain on %v, got %v, %v", state, stored, err)
+		}
+	}
+}
+
+func TestPathRoleSet_EphemeralOnlyOnCreate(t *testing.T) {
+	t.Parallel()
+
+	b, storage := getTestBackend(t)
+	rs := testStoredKeyRoleSet(t, storage, "test-ephemeralupdate")
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      fmt.Sprintf("roleset/%s", rs.Name),
+		Data:      map[string]interface{}{"ephemeral": true},
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error setting ephemeral on update, got: %#v", resp)
+	}
+}
+
+func TestPathRoleSet_MaxTokenTTL(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+
+	rs := testStoredKeyRoleSet(t, storage, "test-maxtokenttl")
+	rs.SecretType = SecretTypeAccessToken
+	rs.TokenGen = &TokenGenerator{Scopes: []string{"https://www.googleapis.com/auth/cloud-platform"}}
+	if err := rs.save(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      fmt.Sprintf("roleset/%s", rs.Name),
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"max_token_ttl": "15m",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != nil && resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+
+	respData := testRoleSetReadWithStorage(t, b, storage, rs.Name)
+	if respData["max_token_ttl"] != int64(900) {
+		t.Errorf("expected max_token_ttl 900, got %v", respData["max_token_ttl"])
+	}
+
+	// Key role sets ignore the cap with a warning.
+	keyRs := testStoredKeyRoleSet(t, storage, "test-maxtokenttl-key")
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      fmt.Sprintf("roleset/%s", keyRs.Name),
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"max_token_ttl": "15m",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || len(resp.Warnings) == 0 {
+		t.Errorf("expected warning for max_token_ttl on key role set, got: %#v", resp)
+	}
+}
+
+func testRoleSetReadWithStorage(t *testing.T, b logical.Backend, s logical.Storage, rsName string) map[string]interface{} {
+	t.Helper()
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      fmt.Sprintf("roleset/%s", rsName),
+		Storage:   s,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected read response: %#v", resp)
+	}
+	return resp.Data
+}
+
+func TestPathRoleSet_RequireNarrowScopes(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+
+	entry, err := logical.StorageEntryJSON("config", &config{RequireNarrowScopes: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roleset/test-narrowscopes",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"project":      "my-project",
+			"secret_type":  SecretTypeAccessToken,
+			"bindings":     `resource "projects/my-project" { roles = ["roles/viewer"] }`,
+			"token_scopes": []string{"https://www.googleapis.com/auth/devstorage.read_only", cloudPlatformScope},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected cloud-platform scope to be rejected, got: %#v", resp)
+	}
+	if act := resp.Error().Error(); !strings.Contains(act, "require_narrow_scopes") {
+		t.Errorf("expected %q to mention require_narrow_scopes", act)
+	}
+}
+
+func TestPathRoleSet_ChangeSecretTypeValidation(t *testing.T) {
+	t.Parallel()
+
+	b, storage := getTestBackend(t)
+	rs := testStoredKeyRoleSet(t, storage, "test-changesecrettype")
+
+	cases := map[string]struct {
+		data   map[string]interface{}
+		errMsg string
+	}{
+		"access_token without token_scopes": {
+			data:   map[string]interface{}{"secret_type": SecretTypeAccessToken},
+			errMsg: "token_scopes must be provided",
+		},
+		"invalid secret_type": {
+			data:   map[string]interface{}{"secret_type": "not_a_type"},
+			errMsg: `invalid "secret_type" value`,
+		},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			resp, err := b.HandleRequest(context.Background(), &logical.Request{
+				Operation: logical.UpdateOperation,
+				Path:      fmt.Sprintf("roleset/%s", rs.Name),
+				Data:      tc.data,
+				Storage:   storage,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp == nil || !resp.IsError() {
+				t.Fatalf("expected error, got: %#v", resp)
+			}
+			if act := resp.Error().Error(); !strings.Contains(act, tc.errMsg) {
+				t.Errorf("expected %q to contain %q", act, tc.errMsg)
+			}
+		})
+	}
+
+	stored, err := getRoleSet(rs.Name, context.Background(), storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.SecretType != SecretTypeKey {
+		t.Fatalf("expected secret_type to be unchanged, got %q", stored.SecretType)
+	}
+}
+
+func TestPathRoleSet_InvalidTokenCreators(t *testing.T) {
+	t.Parallel()
+
+	b, storage := getTestBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roleset/test-tokencreators",
+		Data: map[string]interface{}{
+			"project":        "my-project",
+			"secret_type":    SecretTypeKey,
+			"bindings":       `resource "projects/my-project" { roles = ["roles/viewer"] }`,
+			"token_creators": "user:me@example.com,nobody@example.com",
+		},
+		Storage: storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected invalid token_creators to be rejected, got: %#v", resp)
+	}
+	if exp, act := "nobody@example.com", resp.Error().Error(); !strings.Contains(act, exp) {
+		t.Errorf("expected %q to contain %q", act, exp)
+	}
+}
+
+func TestRoleSetServiceAccountName(t *testing.T) {
+	t.Parallel()
+
+	idRe := regexp.MustCompile(`^vault[a-zA-Z0-9-]*-[a-z0-9]+$`)
+	for _, suffixLen := range []int{minServiceAccountSuffixLen, defaultServiceAccountSuffixLen, maxServiceAccountSuffixLen} {
+		name, err := roleSetServiceAccountName("a-very-long-role-set-name_with.punctuation", suffixLen)
+		if err != nil {
+			t.Fatalf("suffix length %d: %v", suffixLen, err)
+		}
+		if len(name) > serviceAccountMaxLen {
+			t.Errorf("suffix length %d: %q is longer than %d characters", suffixLen, name, serviceAccountMaxLen)
+		}
+		if !idRe.MatchString(name) {
+			t.Errorf("suffix length %d: %q is not a valid service account ID", suffixLen, name)
+		}
+		if suffix := name[strings.LastIndex(name, "-")+1:]; len(suffix) != suffixLen {
+			t.Errorf("suffix length %d: got suffix %q", suffixLen, suffix)
+		}
+	}
+
+	if _, err := roleSetServiceAccountName("rs", maxServiceAccountSuffixLen+1); err == nil {
+		t.Error("expected error for suffix leaving no room for the prefix")
+	}
+}
+
+func TestRenderServiceAccountName(t *testing.T) {
+	t.Parallel()
+
+	idRe := regexp.MustCompile(`^[a-z][-a-z0-9]{4,28}[a-z0-9]$`)
+	valid := map[string]*regexp.Regexp{
+		"{{roleset}}-{{random}}":                  regexp.MustCompile(`^my-role-set-[a-z0-9]{10}$`),
+		"{{ project }}-{{random}}":                regexp.MustCompile(`^my-project-[a-z0-9]{10}$`),
+		"v-{{project}}-{{roleset}}-{{random}}":    regexp.MustCompile(`^v-my-project-my-rol-[a-z0-9]{10}$`),
+		"sa{{random}}":                            regexp.MustCompile(`^sa[a-z0-9]{10}$`),
+		"x{{roleset}}{{roleset}}-{{random}}-test": regexp.MustCompile(`^xmy-rolmy-rol-[a-z0-9]{10}-test$`),
+	}
+	for tmpl, expected := range valid {
+		if err := validateServiceAccountNameTemplate(tmpl, defaultServiceAccountSuffixLen); err != nil {
+			t.Errorf("%s: unexpected validation error: %v", tmpl, err)
+			continue
+		}
+		random, err := randomServiceAccountSuffix(defaultServiceAccountSuffixLen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, err := renderServiceAccountName(tmpl, "My_Role.Set", "my-project", random)
+		if err != nil {
+			t.Errorf("%s: %v", tmpl, err)
+			continue
+		}
+		if !idRe.MatchString(id) || !expected.MatchString(id) {
+			t.Errorf("%s: unexpected service account ID %q", tmpl, id)
+		}
+	}
+
+	invalid := []string{
+		"static-name",
+		"{{roleset}}-static",
+		"{{roleset}}-{{random}}-{{unknown}}",
+		"{{random}}",
+		"1{{roleset}}-{{random}}",
+		"{{random}}-",
+		"a-very-long-static-prefix-{{random}}",
+		"UPPER-{{random}}",
+	}
+	for _, tmpl := range invalid {
+		if err := validateServiceAccountNameTemplate(tmpl, defaultServiceAccountSuffixLen); err == nil {
+			t.Errorf("%s: expected validation error", tmpl)
+		}
+	}
+}
+
+func TestMissingRoles(t *testing.T) {
+	t.Parallel()
+
+	email := "vaultrs-abc@my-project.iam.gserviceaccount.com"
+	member := "serviceAccount:" + email
+	p := &iamutil.Policy{
+		Bindings: []*iamutil.Binding{
+			{Role: "roles/viewer", Members: []string{"user:me@example.com", member}},
+			{Role: "roles/browser", Members: []string{"user:me@example.com"}},
+			{Role: "roles/editor", Members: []string{member}, Condition: &iamutil.Condition{Expression: "true"}},
+		},
+	}
+	roles := util.ToSet([]string{"roles/viewer", "roles/browser", "roles/editor", "roles/owner"})
+
+	expected := util.ToSet([]string{"roles/browser", "roles/editor", "roles/owner"})
+	if actual := missingRoles(p, email, roles); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected missing roles %v, got %v", expected.ToSlice(), actual.ToSlice())
+	}
+}
+
+func TestPathRoleSet_ReconcileNotFound(t *testing.T) {
+	t.Parallel()
+
+	b, storage := getTestBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roleset/test-missing/reconcile",
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error reconciling missing role set, got: %#v", resp)
+	}
+}
+
+func TestValidateIamMember(t *testing.T) {
+	valid := []string{
+		"user:me@example.com",
+		"group:admins@example.com",
+		"serviceAccount:sa@my-project.iam.gserviceaccount.com",
+		"domain:example.com",
+	}
+	for _, m := range valid {
+		if err := validateIamMember(m); err != nil {
+			t.Errorf("expected %q to be valid, got error: %v", m, err)
+		}
+	}
+
+	invalid := []string{
+		"me@example.com",
+		"user:",
+		"allUsers",
+		"robot:me@example.com",
+	}
+	for _, m := range invalid {
+		if err := validateIamMember(m); err == nil {
+			t.Errorf("expected %q to be invalid", m)
+		}
+	}
+}
+
+// testStoredKeyRoleSet saves a key role set directly to storage, without
+// creating any GCP resources.
+func testStoredKeyRoleSet(t *testing.T, s logical.Storage, rsName string) *RoleSet {
+	t.Helper()
+
+	rs := &RoleSet{
+		Name:        rsName,
+		SecretType:  SecretTypeKey,
+		RawBindings: `resource "projects/my-project" { roles = ["roles/viewer"] }`,
+		Bindings: ResourceBindings{
+			"projects/my-project": util.StringSet{"roles/viewer": struct{}{}},
+		},
+		AccountId: &gcputil.ServiceAccountId{
+			Project:   "my-project",
+			EmailOrId: fmt.Sprintf("vault%s@my-project.iam.gserviceaccount.com", rsName),
+		},
+	}
+	if err := rs.save(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+	return rs
+}
+
+func TestPathRoleSet_UpdateKeyRoleSet(t *testing.T) {
+	rsName := "test-updatekeyrs"
+	initRoles := util.StringSet{
+		"roles/viewer": struct{}{},
+	}
+	updatedRoles := util.StringSet{
+		"roles/browser":         struct{}{},
+		"roles/cloudsql.client": struct{}{},
+	}
+
+	// Initial test set up - backend, initial config, test resources in project
+	td := setupTest(t, "0s", "2h")
+	defer cleanup(t, td, rsName, initRoles.Union(updatedRoles))
+
+	projRes := fmt.Sprintf(testProjectResourceTemplate, td.Project)
+
+	// Create role set
+	expectedBinds := ResourceBindings{projRes: initRoles}
+	bindsRaw, err := util.BindingsHCL(expectedBinds)
+	if err != nil {
+		t.Fatalf("unable to convert resource bindings to HCL string: %v", err)
+	}
+	testRoleSetCreate(t, td, rsName,
+		map[string]interface{}{
+			"project":     td.Project,
+			"secret_type": SecretTypeKey,
+			"bindings":    bindsRaw,
+		})
+
+	// Verify
+	respData := testRoleSetRead(t, td, rsName)
+	if respData == nil {
+		t.Fatalf("expected role set to have been created")
+	}
+	verifyReadData(t, respData, map[string]interface{}{
+		"secret_type": SecretTypeKey,
+		"project":     td.Project,
+		"bindings":    expectedBinds,
+	})
+
+	initSa := getServiceAccount(t, td.IamAdmin, respData)
+	verifyProjectBinding(t, td, initSa.Email, initRoles)
+
+	// Verify theses updates don't work:
+	errCases := []map[string]interface{}{
+		{
+			// new project should not be allowed
+			"project": "diff-proj",
+		},
+		{
+			// Cannot be applied to key role sets
+			"secret_type": SecretTypeAccessToken,
+		},
+	}
+	for _, d := range errCases {
+		resp, err := td.B.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      fmt.Sprintf("roleset/%s", rsName),
+			Data:      d,
+			Storage:   td.S,
+		})
+		if err == nil && (resp != nil && !resp.IsError()) {
+			t.Fatalf("expected update to fail; data: %v", d)
+		}
+	}
+
+	// Update role set
+	expectedBinds = ResourceBindings{projRes: updatedRoles}
+	bindsRaw, err = util.BindingsHCL(expectedBinds)
+	if err != nil {
+		t.Fatalf("unable to convert resource bindings to HCL string: %v", err)
+	}
+	testRoleSetUpdate(t, td, rsName,
+		map[string]interface{}{
+			"project":     td.Project,
+			"secret_type": SecretTypeKey,
+			"bindings":    bindsRaw,
+		})
+
+	// Verify
+	respData = testRoleSetRead(t, td, rsName)
+	if respData == nil {
+		t.Fatalf("expected role set to have been created")
+	}
+	verifyReadData(t, respData, map[string]interface{}{
+		"secret_type": SecretTypeKey, // default
+		"project":     td.Project,
+		"bindings":    expectedBinds,
+	})
+
+	newSa := getServiceAccount(t, td.IamAdmin, respData)
+	if newSa.Name == initSa.Name {
+		t.Fatalf("expected role set to have new service account after update")
+	}
+	verifyProjectBinding(t, td, newSa.Email, updatedRoles)
+
+	verifyServiceAccountDeleted(t, td.IamAdmin, initSa.Name)
+	verifyProjectBindingsRemoved(t, td, initSa.Email, updatedRoles)
+
+	// 4. Delete role set
+	testRoleSetDelete(t, td, rsName, newSa.Name)
+	verifyProjectBindingsRemoved(t, td, newSa.Email, updatedRoles)
+}
+
+func TestPathRoleSet_RotateKeyRoleSet(t *testing.T) {
+	rsName := "test-rotatekeyrs"
+	roles := util.StringSet{
+		"roles/viewer": struct{}{},
+	}
+
+	// Initial test set up - backend, initial config, test resources in project
+	td := setupTest(t, "0s", "2h")
+	defer cleanup(t, td, rsName, roles)
+
+	projRes := fmt.Sprintf(testProjectResourceTemplate, td.Project)
+
+	// Create role set
+	expectedBinds := ResourceBindings{projRes: roles}
+	bindsRaw, err := util.BindingsHCL(expectedBinds)
+	if err != nil {
+		t.Fatalf("unable to convert resource bindings to HCL string: %v", err)
+	}
+	testRoleSetCreate(t, td, rsName,
+		map[string]interface{}{
+			"project":     td.Project,
+			"secret_type": SecretTypeKey,
+			"bindings":    bindsRaw,
+		})
+
+	// Verify initial role set.
+	respData := testRoleSetRead(t, td, rsName)
+	if respData == nil {
+		t.Fatalf("expected role set to have been created")
+	}
+	initSa := getServiceAccount(t, td.IamAdmin, respData)
+	verifyProjectBinding(t, td, initSa.Email, roles)
+
+	// Rotate account and verify is new account.
+	testRoleSetRotate(t, td, rsName)
+	newSa := getServiceAccount(t, td.IamAdmin, testRoleSetRead(t, td, rsName))
+	if newSa.Name == initSa.Name {
+		t.Fatalf("expected role set to have new service account after rotation (update)")
+	}
+	verifyProjectBinding(t, td, newSa.Email, roles)
+
+	// Verify old account/bindings deleted.
+	verifyServiceAccountDeleted(t, td.IamAdmin, initSa.Name)
+	verifyProjectBindingsRemoved(t, td, initSa.Email, roles)
+
+	// Get RoleSet object for confirming key rotation:
+	rs, err := getRoleSet(rsName, context.Background(), td.S)
+	if rs.TokenGen != nil {
+		t.Fatalf("expected no token gen to have been created for key role set")
+	}
+
+	// 4. Delete role set
+	testRoleSetDelete(t, td, rsName, newSa.Name)
+	verifyProjectBindingsRemoved(t, td, newSa.Email, roles)
+}
+
+func TestPathRoleSet_UpdateTokenRoleSet(t *testing.T) {
+	rsName := "test-updatetokenrs"
+	initRoles := util.StringSet{
+		"roles/viewer": struct{}{},
+	}
+	updatedRoles := util.StringSet{
+		"roles/browser":         struct{}{},
+		"roles/cloudsql.client": struct{}{},
+	}
+
+	// Initial test set up - backend, initial config, test resources in project
+	td := setupTest(t, "0s", "2h")
+	defer cleanup(t, td, rsName, initRoles.Union(updatedRoles))
+
+	projRes := fmt.Sprintf(testProjectResourceTemplate, td.Project)
+
+	// Create role set
+	expectedBinds := ResourceBindings{projRes: initRoles}
+	bindsRaw, err := util.BindingsHCL(expectedBinds)
+	if err != nil {
+		t.Fatalf("unable to convert resource bindings to HCL string: %v", err)
+	}
+	testRoleSetCreate(t, td, rsName,
+		map[string]interface{}{
+			"project":      td.Project,
+			"secret_type":  SecretTypeAccessToken,
+			"bindings":     bindsRaw,
+			"token_scopes": []string{"https://www.googleapis.com/auth/cloud-platform"},
+		})
+
+	// Verify
+	respData := testRoleSetRead(t, td, rsName)
+	if respData == nil {
+		t.Fatalf("expected role set to have been created")
+	}
+	verifyReadData(t, respData, map[string]interface{}{
+		"secret_type":  SecretTypeAccessToken,
+		"project":      td.Project,
+		"bindings":     expectedBinds,
+		"token_scopes": []string{"https://www.googleapis.com/auth/cloud-platform"},
+	})
+
+	initSa := getServiceAccount(t, td.IamAdmin, respData)
+	verifyProjectBinding(t, td, initSa.Email, initRoles)
+
+	initK := verifyRoleSetTokenKey(t, td, rsName)
+	if !strings.HasPrefix(initK.Name, initSa.Name) {
+		t.Fatalf("expected token key to have been generated under initial service account")
+	}
+
+	// Update role set
+	expectedBinds = ResourceBindings{projRes: updatedRoles}
+	bindsRaw, err = util.BindingsHCL(expectedBinds)
+	if err != nil {
+		t.Fatalf("unable to convert resource bindings to HCL string: %v", err)
+	}
+	testRoleSetUpdate(t, td, rsName,
+		map[string]interface{}{
+			"bindings": bindsRaw,
+			"token_scopes": []string{
+				"https://www.googleapis.com/auth/compute",
+				"https://www.googleapis.com/auth/compute.readonly",
+			},
+		})
+
+	// Verify
+	respData = testRoleSetRead(t, td, rsName)
+	if respData == nil {
+		t.Fatalf("expected role set to have been created")
+	}
+	verifyReadData(t, respData, map[string]interface{}{
+		"secret_type": SecretTypeAccessToken,
+		"project":     td.Project,
+		"bindings":    expectedBinds,
+		"token_scopes": []string{
+			"https://www.googleapis.com/auth/compute",
+			"https://www.googleapis.com/auth/compute.readonly",
+		},
+	})
+	newSa := getServiceAccount(t, td.IamAdmin, respData)
+	verifyProjectBinding(t, td, newSa.Email, updatedRoles)
+	newK := verifyRoleSetTokenKey(t, td, rsName)
+	if !strings.HasPrefix(newK.Name, newSa.Name) {
+		t.Fatalf("expected token key to have been generated under new service account")
+	}
+
+	// Verify old account was deleted and is not being used anymore.
+	if newSa.Name == initSa.Name {
+		t.Fatalf("expected role set to have new service account after update")
+	}
+	verifyServiceAccountDeleted(t, td.IamAdmin, initSa.Name)
+	verifyProjectBindingsRemoved(t, td, initSa.Email, updatedRoles)
+
+	// 4. Delete role set
+	testRoleSetDelete(t, td, rsName, newSa.Name)
+	verifyProjectBindingsRemoved(t, td, newSa.Email, updatedRoles)
+}
+
+func TestPathRoleSet_RotateTokenRoleSet(t *testing.T) {
+	rsName := "test-rotatetokenrs"
+	roles := util.StringSet{
+		"roles/viewer": struct{}{},
+	}
+
+	// Initial test set up - backend, initial config, test resources in project
+	td := setupTest(t, "0s", "2h")
+	defer cleanup(t, td, rsName, roles)
+
+	projRes := fmt.Sprintf(testProjectResourceTemplate, td.Project)
+
+	// Create role set
+	expectedBinds := ResourceBindings{projRes: roles}
+	bindsRaw, err := util.BindingsHCL(expectedBinds)
+	if err != nil {
+		t.Fatalf("unable to convert resource bindings to HCL string: %v", err)
+	}
+	testRoleSetCreate(t, td, rsName,
+		map[string]interface{}{
+			"project":      td.Project,
+			"secret_type":  SecretTypeAccessToken,
+			"bindings":     bindsRaw,
+			"token_scopes": []string{"https://www.googleapis.com/auth/cloud-platform"},
+		})
+
+	// Verify initial role set.
+	respData := testRoleSetRead(t, td, rsName)
+	if respData == nil {
+		t.Fatalf("expected role set to have been created")
+	}
+	initSa := getServiceAccount(t, td.IamAdmin, respData)
+	verifyProjectBinding(t, td, initSa.Email, roles)
+
+	// Rotate account and verify is new account.
+	testRoleSetRotate(t, td, rsName)
+	newSa := getServiceAccount(t, td.IamAdmin, testRoleSetRead(t, td, rsName))
+	if newSa.Name == initSa.Name {
+		t.Fatalf("expected role set to have new service account after rotation (update)")
+	}
+	verifyProjectBinding(t, td, newSa.Email, roles)
+
+	// Verify old account/bindings deleted.
+	verifyServiceAccountDeleted(t, td.IamAdmin, initSa.Name)
+	verifyProjectBindingsRemoved(t, td, initSa.Email, roles)
+
+	// Get RoleSet object for confirming key rotation:
+	oldK := verifyRoleSetTokenKey(t, td, rsName)
+
+	// Rotate key only - should only change key, not service account
+	testRoleSetRotateKey(t, td, rsName)
+	saAfterRotate := getServiceAccount(t, td.IamAdmin, testRoleSetRead(t, td, rsName))
+	if saAfterRotate.Name != newSa.Name {
+		t.Fatalf("expected same service account (%s) after rotate key, instead got new account: %s", newSa.Name, saAfterRotate.Name)
+	}
+
+	// Verify old key was deleted
+	result, err := td.IamAdmin.Projects.ServiceAccounts.Keys.Get(oldK.Name).Do()
+	if err == nil && result != nil {
+		t.Fatalf("old key was supposed to be deleted but get succeded")
+	} else if err != nil && !isGoogleAccountKeyNotFoundErr(err) {
+		t.Fatalf("got an error while trying to confirm service account key was deleted: %v", err)
+	}
+
+	// Verify new key != old key
+	newK := verifyRoleSetTokenKey(t, td, rsName)
+	if newK.Name == oldK.Name {
+		t.Fatalf("expected new key to have been created in rotate")
+	}
+	if newK.PrivateKeyData == oldK.PrivateKeyData {
+		t.Fatalf("expected new key data to have been created and saved in rotate")
+	}
+
+	// 4. Delete role set
+	testRoleSetDelete(t, td, rsName, newSa.Name)
+	verifyProjectBindingsRemoved(t, td, newSa.Email, roles)
+}
+
+// Helpers for calling backend methods
+func testRoleSetCreate(t *testing.T, td *testData, rsName string, d map[string]interface{}) {
+	resp, err := td.B.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      fmt.Sprintf("roleset/%s", rsName),
+		Data:      d,
+		Storage:   td.S,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != nil && resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+}
+
+func testRoleSetRead(t *testing.T, td *testData, rsName string) map[string]interface{} {
+	resp, err := td.B.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      fmt.Sprintf("roleset/%s", rsName),
+		Storage:   td.S,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+	if resp == nil {
+		return nil
+	}
+
+	return resp.Data
+}
+
+func testRoleSetUpdate(t *testing.T, td *testData, rsName string, d map[string]interface{}) {
+	resp, err := td.B.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      fmt.Sprintf("roleset/%s", rsName),
+		Data:      d,
+		Storage:   td.S,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp != nil && resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+}
+
+func testRoleSetRotate(t *testing.T, td *testData, rsName string) {
+	resp, err := td.B.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      fmt.Sprintf("roleset/%s/rotate", rsName),
+		Storage:   td.S,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+	return
+}
+
+func testRoleSetRotateKey(t *testing.T, td *testData, rsName string) {
+	resp, err := td.B.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      fmt.Sprintf("roleset/%s/rotate-key", rsName),
+		Storage:   td.S,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.IsError() {
+		t.Fatal(resp.Error())
+	}
+	return
+}
+
+func testRoleSetDelete(t *testing.T, td *testData, rsName, saName string) {
+	resp, err := td.B.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      fmt.Sprintf("roleset/%s", rsName),
+		Storage:   td.S,
+	})
+
+	if err != nil {
+		t.Fatalf("unable to delete role set: %v", err)
+	} else if resp != nil {
+		if len(resp.Warnings) > 0 {
+			t.Logf("warnings returned from role set delete. Warnings:\n %s\n", strings.Join(resp.Warnings, ",\n"))
+		}
+		if resp.IsError() {
+			t.Fatalf("unable to delete role set: %v", resp.Error())
+		}
+	}
+
+	verifyServiceAccountDeleted(t, td.IamAdmin, saName)
+}
+
+// Test helpers
+func verifyReadData(t *testing.T, actual map[string]interface{}, expected map[string]interface{}) {
+	for k, v := range expected {
+		actV, ok := actual[k]
+		if !ok {
+			t.Errorf("key '%s' not found, expected: %v", k, v)
+		} else if k == "bindings" {
+			verifyReadBindings(t, v.(ResourceBindings), actV)
+		} else if k == "token_scopes" {
+			if !strutil.EquivalentSlices(v.([]string), actV.([]string)) {
+				t.Errorf("token scopes mismatch; expected: %v, actual: %v", v, actV)
+			}
+		} else if v != actV {
+			t.Errorf("mismatch for key '%s'; expected: %v, actual: %v", k, v, actV)
+		}
+	}
+
+	if t.Failed() {
+		t.FailNow()
+	}
+}
+
+func verifyReadBindings(t *testing.T, expected ResourceBindings, actualRaw interface{}) {
+	if actualRaw == nil && expected != nil {
+		t.Fatalf("expected bindings")
+	}
+	actual := actualRaw.(map[string][]string)
+	if len(actual) != len(expected) {
+		t.Fatalf("expected %d bindings, got %d bindings in role set", len(expected), len(actual))
+	}
+	for res, v := range expected {
+		actB, ok := actual[res]
+		if !ok {
+			t.Fatalf("expected bindings for resource %s", res)
+		}
+		if !util.ToSet(actB).Equals(v) {
+			t.Fatalf("could not find same bindings; expected: %v, actual: %v", v.ToSlice(), actB)
+		}
+	}
+}
+
+func verifyRoleSetTokenKey(t *testing.T, td *testData, rsName string) *iam.ServiceAccountKey {
+	rs, err := getRoleSet(rsName, context.Background(), td.S)
+	if rs.TokenGen == nil || rs.TokenGen.KeyName == "" {
+		t.Fatalf("expected token gen to have been created for access token role set")
+	}
+	keyData := rs.TokenGen.B64KeyJSON
+	key, err := td.IamAdmin.Projects.ServiceAccounts.Keys.Get(rs.TokenGen.KeyName).Do()
+	if err != nil {
+		t.Fatalf("could not confirm key for role set service account to generate tokens: %v", err)
+	}
+	key.PrivateKeyData = keyData
+	return key
+}
+
+func getServiceAccount(t *testing.T, iamAdmin *iam.Service, readData map[string]interface{}) *iam.ServiceAccount {
+	emailRaw, ok := readData["service_account_email"]
+	if !ok {
+		t.Fatalf("expected role set to have service account email in returned read")
+	}
+
+	proj, ok := readData["project"]
+	if !ok {
+		t.Fatalf("expected role set to have service project in returned read")
+	}
+
+	saName := fmt.Sprintf(gcputil.ServiceAccountTemplate, proj, emailRaw.(string))
+	sa, err := iamAdmin.Projects.ServiceAccounts.Get(saName).Do()
+	if err != nil {
+		if isGoogleAccountNotFoundErr(err) {
+			t.Fatalf("expected role set service account '%s' exists", saName)
+		} else {
+			t.Fatalf("unexpected error while trying to get role set service account '%s': %v", saName, err)
+		}
+	}
+	if sa == nil {
+		t.Fatalf("expected role set service account '%s' exists", saName)
+	}
+	return sa
+}
+
+func verifyServiceAccountDeleted(t *testing.T, iamAdmin *iam.Service, saName string) {
+	_, err := iamAdmin.Projects.ServiceAccounts.Get(saName).Do()
+	if err == nil || !isGoogleAccountNotFoundErr(err) {
+		t.Fatalf("expected service account '%s' to have been deleted", saName)
+	}
+}
+
+func verifyProjectBinding(t *testing.T, td *testData, email string, roleSet util.StringSet) {
+	found := roleSubsetBoundOnProject(t, td.HttpClient, td.Project, email, roleSet)
+	if len(roleSet) > len(found) {
+		notFound := roleSet.Sub(found)
+		for r := range notFound {
+			t.Errorf("role (%s) not bound to member (%s) for project '%s'", r, email, td.Project)
+		}
+		t.FailNow()
+	}
+}
+
+func verifyProjectBindingsRemoved(t *testing.T, td *testData, email string, roleSet util.StringSet) {
+	found := roleSubsetBoundOnProject(t, td.HttpClient, td.Project, email, roleSet)
+	for r := range found {
+		t.Errorf("role (%s) still bound to service account (%s) for project '%s'", r, email, td.Project)
+	}
+	if t.Failed() {
+		t.FailNow()
+	}
+}
+
+func roleSubsetBoundOnProject(t *testing.T, httpC *http.Client, project, email string, roleSet util.StringSet) util.StringSet {
+	if project == "" {
+		t.Fatalf("expected project")
+	}
+	member := fmt.Sprintf("serviceAccount:%s", email)
+
+	crm, err := cloudresourcemanager.New(httpC)
+	if err != nil {
+		t.Fatalf("[WARNING] Unable to ensure test project bindings deleted: %v", err)
+	}
+
+	p, err := crm.Projects.GetIamPolicy(project, &cloudresourcemanager.GetIamPolicyRequest{}).Do()
+	if err != nil {
+		t.Fatalf("[WARNING] Unable to ensure test project bindings deleted, could not get policy: %v", err)
+	}
+	found := make(util.StringSet)
+	for _, bind := range p.Bindings {
+		if roleSet.Includes(bind.Role) {
+			for _, m := range bind.Members {
+				if m == member {
+					found.Add(bind.Role)
+				}
+			}
+		}
+	}
+	return found
+}
+
+// Set up/Teardown
+type testData struct {
+	B          logical.Backend
+	S          logical.Storage
+	Project    string
+	HttpClient *http.Client
+	IamAdmin   *iam.Service
+}
+
+func setupTest(t *testing.T, ttl, maxTTL string) *testData {
+	proj := util.GetTestProject(t)
+	credsJson, creds := util.GetTestCredentials(t)
+	httpC, err := gcputil.GetHttpClient(creds, iam.CloudPlatformScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iamAdmin, err := iam.NewService(context.Background(), option.WithHTTPClient(httpC))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, reqStorage := getTestBackend(t)
+
+	testConfigUpdate(t, b, reqStorage, map[string]interface{}{
+		"credentials": credsJson,
+		"ttl":         ttl,
+		"max_ttl":     maxTTL,
+	})
+
+	return &testData{
+		B:          b,
+		S:          reqStorage,
+		Project:    proj,
+		HttpClient: httpC,
+		IamAdmin:   iamAdmin,
+	}
+}
+
+func cleanup(t *testing.T, td *testData, rsName string, roles util.StringSet) {
+	resp, err := td.IamAdmin.Projects.ServiceAccounts.List(fmt.Sprintf("projects/%s", td.Project)).Do()
+	if err != nil {
+		t.Logf("[WARNING] Could not clean up test service accounts for role set %s or projects/%s IAM policy bindings (did test fail?)", rsName, td.Project)
+		return
+	}
+
+	memberStrs := make(util.StringSet)
+	for _, sa := range resp.Accounts {
+		if sa.DisplayName == fmt.Sprintf(serviceAccountDisplayNameTmpl, rsName) {
+			memberStrs.Add("serviceAccount:" + sa.Email)
+			t.Logf("[WARNING] found test service account %s that should have been deleted, did test fail? Manually deleting...", sa.Name)
+			if _, err := td.IamAdmin.Projects.ServiceAccounts.Delete(sa.Name).Do(); err != nil {
+				if isGoogleAccountNotFoundErr(err) {
+					t.Logf("[WARNING] Disregard previous warning - manual delete returned 404, probably IAM eventual consistency")
+					continue
+				}
+				t.Logf("[WARNING] Auto-delete failed - manually clean up service account %s: %v", sa.Name, err)
+			}
+		}
+	}
+
+	crm, err := cloudresourcemanager.New(td.HttpClient)
+	if err != nil {
+		t.Logf("[WARNING] Unable to ensure test project bindings deleted: %v", err)
+		return
+	}
+
+	p, err := crm.Projects.GetIamPolicy(td.Project, &cloudresourcemanager.GetIamPolicyRequest{}).Do()
+	if err != nil {
+		t.Logf("[WARNING] Unable to ensure test project bindings deleted, could not get policy: %v", err)
+		return
+	}
+
+	var changesMade bool
+	found := make(util.StringSet)
+	for idx, b := range p.Bindings {
+		if roles.Includes(b.Role) {
+			members := make([]string, 0, len(b.Members))
+			for _, m := range b.Members {
+				if memberStrs.Includes(m) {
+					changesMade = true
+					found.Add(b.Role)
+				} else {
+					members = append(members, m)
+				}
+			}
+			p.Bindings[idx].Members = members
+		}
+	}
+
+	if !changesMade {
+		return
+	}
+
+	t.Logf("[WARNING] had to clean up some roles (%s) for test role set %s - should have been deleted (did test fail?)",
+		strings.Join(found.ToSlice(), ","), rsName)
+	if _, err := crm.Projects.SetIamPolicy(td.Project, &cloudresourcemanager.SetIamPolicyRequest{Policy: p}).Do(); err != nil {
+		t.Logf("[WARNING] Auto-delete failed - manually remove bindings on project %s: %v", td.Project, err)
+	}
+}
+
+// conflictingResource is an iamutil.Resource whose SetIamPolicy fails with an
+// etag conflict a fixed number of times before succeeding.
+type conflictingResource struct {
+	iamutil.Resource
+	conflicts int
+	sets      int
+}
+
+func (r *conflictingResource) GetIamPolicy(context.Context, *iamutil.ApiHandle) (*iamutil.Policy, error) {
+	return &iamutil.Policy{Etag: fmt.Sprintf("etag-%d", r.sets)}, nil
+}
+
+func (r *conflictingResource) SetIamPolicy(_ context.Context, _ *iamutil.ApiHandle, p *iamutil.Policy) (*iamutil.Policy, error) {
+	r.sets++
+	if r.sets <= r.conflicts {
+		return nil, errwrap.Wrapf("unable to set policy: {{err}}", &googleapi.Error{Code: http.StatusConflict})
+	}
+	return p, nil
+}
+
+// fakeResources is an iamutil.ResourceParser of in-memory resources.
+type fakeResources map[string]*fakeResource
+
+func (r fakeResources) Parse(name string) (iamutil.Resource, error) {
+	res, ok := r[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported resource %q", name)
+	}
+	return res, nil
+}
+
+// fakeResource is an iamutil.Resource whose policy is kept in memory.
+// SetIamPolicy fails with setErr if it is set.
+type fakeResource struct {
+	iamutil.Resource
+	policy iamutil.Policy
+	setErr error
+}
+
+func (r *fakeResource) GetIamPolicy(context.Context, *iamutil.ApiHandle) (*iamutil.Policy, error) {
+	p := &iamutil.Policy{}
+	for _, b := range r.policy.Bindings {
+		p.Bindings = append(p.Bindings, &iamutil.Binding{Role: b.Role, Members: append([]string(nil), b.Members...)})
+	}
+	return p, nil
+}
+
+func (r *fakeResource) GetConfig() *iamutil.RestResource {
+	return &iamutil.RestResource{Service: "cloudresourcemanager", TypeKey: "projects"}
+}
+
+func (r *fakeResource) SetIamPolicy(_ context.Context, _ *iamutil.ApiHandle, p *iamutil.Policy) (*iamutil.Policy, error) {
+	if r.setErr != nil {
+		return nil, r.setErr
+	}
+	r.policy = *p
+	return p, nil
+}
+
+func TestPathRoleSet_RollbackFailedBindings(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var l sync.Mutex
+	var created, deleted []string
+	b, storage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.Lock()
+		defer l.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/projects/my-project/serviceAccounts":
+			var req iam.CreateServiceAccountRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			email := fmt.Sprintf("%s@my-project.iam.gserviceaccount.com", req.AccountId)
+			created = append(created, email)
+			json.NewEncoder(w).Encode(&iam.ServiceAccount{
+				Name:  "projects/my-project/serviceAccounts/" + email,
+				Email: email,
+			})
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/v1/projects/my-project/serviceAccounts/"):
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/v1/projects/my-project/serviceAccounts/"))
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	ok := &fakeResource{}
+	b.(*backend).resources = fakeResources{
+		"projects/ok":     ok,
+		"projects/denied": {setErr: &googleapi.Error{Code: http.StatusForbidden, Message: "permission denied"}},
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roleset/test-rollback",
+		Data: map[string]interface{}{
+			"project":     "my-project",
+			"secret_type": SecretTypeKey,
+			"bindings":    `resource "projects/ok" { roles = ["roles/viewer"] } resource "projects/denied" { roles = ["roles/viewer"] }`,
+		},
+		Storage: storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "were removed") {
+		t.Fatalf("expected error reporting the rollback, got: %#v", resp)
+	}
+
+	if len(created) != 1 || !reflect.DeepEqual(created, deleted) {
+		t.Fatalf("expected the created service account %v to be deleted, got %v", created, deleted)
+	}
+	for _, binding := range ok.policy.Bindings {
+		if len(binding.Members) > 0 {
+			t.Fatalf("expected bindings applied before the failure to be removed, got %v", binding.Members)
+		}
+	}
+	if rs, err := getRoleSet("test-rollback", ctx, storage); err != nil || rs != nil {
+		t.Fatalf("expected no role set to be stored, got %v, %v", rs, err)
+	}
+	walIds, err := framework.ListWAL(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(walIds) != 0 {
+		t.Fatalf("expected no WAL entries left for rollback, got %d", len(walIds))
+	}
+}
+
+func TestPathRoleSet_DeleteLingeringBindings(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, storage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+
+	rs := testStoredKeyRoleSet(t, storage, "test-lingering")
+	rs.Bindings = ResourceBindings{
+		"projects/ok":     util.StringSet{"roles/viewer": struct{}{}},
+		"projects/denied": util.StringSet{"roles/viewer": struct{}{}},
+	}
+	if err := rs.save(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	policy := iamutil.Policy{Bindings: []*iamutil.Binding{
+		{Role: "roles/viewer", Members: []string{"serviceAccount:" + rs.AccountId.EmailOrId}},
+	}}
+	ok := &fakeResource{policy: policy}
+	b.(*backend).resources = fakeResources{
+		"projects/ok":     ok,
+		"projects/denied": {policy: policy, setErr: &googleapi.Error{Code: http.StatusForbidden, Message: "permission denied"}},
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "roleset/test-lingering",
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() || len(resp.Warnings) == 0 {
+		t.Fatalf("expected warnings about the lingering bindings, got: %#v", resp)
+	}
+	if !reflect.DeepEqual(resp.Data["lingering_bindings"], []string{"projects/denied"}) {
+		t.Fatalf("expected lingering bindings on projects/denied, got %v", resp.Data["lingering_bindings"])
+	}
+	for _, binding := range ok.policy.Bindings {
+		if len(binding.Members) > 0 {
+			t.Fatalf("expected bindings on projects/ok to be removed, got %v", binding.Members)
+		}
+	}
+
+	// Only the bindings that weren't removed are left for WAL rollback.
+	walIds, err := framework.ListWAL(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resources []string
+	for _, walId := range walIds {
+		wal, err := framework.GetWAL(ctx, storage, walId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wal == nil || wal.Kind != walTypeIamPolicy {
+			continue
+		}
+		var entry walIamPolicy
+		if err := decodeWAL(wal.Data, &entry); err != nil {
+			t.Fatal(err)
+		}
+		resources = append(resources, entry.Resource)
+	}
+	if !reflect.DeepEqual(resources, []string{"projects/denied"}) {
+		t.Fatalf("expected a WAL entry for projects/denied only, got %v", resources)
+	}
+}
+
+func TestPathRoleSet_BindingMembers(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, storage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+
+	rs := testStoredKeyRoleSet(t, storage, "test-members")
+	rs.Bindings = ResourceBindings{
+		"projects/p": util.StringSet{"roles/viewer": struct{}{}},
+	}
+	rs.BindingMembers = map[string]util.StringSet{
+		"projects/p": util.ToSet([]string{"user:added@example.com", "group:existing@example.com"}),
+	}
+
+	// The group already has the role, so Vault must leave it alone.
+	res := &fakeResource{policy: iamutil.Policy{Bindings: []*iamutil.Binding{
+		{Role: "roles/viewer", Members: []string{"group:existing@example.com"}},
+	}}}
+	resources := fakeResources{"projects/p": res}
+	b.(*backend).resources = resources
+
+	walIds, err := rs.updateIamPolicies(ctx, storage, resources, nil, rs.Bindings, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tryDeleteWALs(ctx, storage, walIds...)
+
+	sa := "serviceAccount:" + rs.AccountId.EmailOrId
+	for _, m := range []string{sa, "user:added@example.com", "group:existing@example.com"} {
+		if !res.policy.HasMember("roles/viewer", m) {
+			t.Fatalf("expected %s to have roles/viewer, got %v", m, res.policy.Bindings)
+		}
+	}
+	expected := map[string]RoleMembers{
+		"projects/p": {"roles/viewer": util.ToSet([]string{"user:added@example.com"})},
+	}
+	if !reflect.DeepEqual(rs.AddedMembers, expected) {
+		t.Fatalf("expected only the user to be recorded as added, got %v", rs.AddedMembers)
+	}
+	if err := rs.save(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "roleset/test-members",
+		Storage:   storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("unexpected error deleting role set: %v, %#v", err, resp)
+	}
+	for _, m := range []string{sa, "user:added@example.com"} {
+		if res.policy.HasMember("roles/viewer", m) {
+			t.Fatalf("expected %s to lose roles/viewer, got %v", m, res.policy.Bindings)
+		}
+	}
+	if !res.policy.HasMember("roles/viewer", "group:existing@example.com") {
+		t.Fatalf("expected the group to keep roles/viewer, got %v", res.policy.Bindings)
+	}
+}
+
+func TestRoleSet_NewServiceAccountFallbackProjects(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var mu sync.Mutex
+	var projects []string
+	b, storage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		parts := strings.Split(r.URL.Path, "/")
+		if r.Method != http.MethodPost || len(parts) != 5 || parts[4] != "serviceAccounts" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		project := parts[3]
+		projects = append(projects, project)
+		if project != "overflow-2" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error": {"code": 429, "message": "Maximum number of service accounts on project reached.", "status": "RESOURCE_EXHAUSTED"}}`))
+			return
+		}
+		var req iam.CreateServiceAccountRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("unable to decode create request: %v", err)
+		}
+		json.NewEncoder(w).Encode(&iam.ServiceAccount{
+			Name:      "projects/overflow-2/serviceAccounts/" + req.AccountId + "@overflow-2.iam.gserviceaccount.com",
+			Email:     req.AccountId + "@overflow-2.iam.gserviceaccount.com",
+			ProjectId: "overflow-2",
+		})
+	}))
+	gb := b.(*backend)
+	iamAdmin, err := gb.IAMAdminClient(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Without fallback projects, the quota error is returned.
+	rs := &RoleSet{Name: "test-fallback"}
+	failedWalId, _, err := gb.newServiceAccountWithFallback(ctx, storage, iamAdmin, rs, "my-project", nil)
+	if err == nil || !isServiceAccountQuotaError(err) {
+		t.Fatalf("expected a quota error, got: %v", err)
+	}
+	framework.DeleteWAL(ctx, storage, failedWalId)
+
+	projects = nil
+	cfg := &config{ServiceAccountFallbackProjects: []string{"my-project", "overflow-1", "overflow-2"}}
+	walId, warning, err := gb.newServiceAccountWithFallback(ctx, storage, iamAdmin, rs, "my-project", cfg)
+	if err != nil {
+		t.Fatalf("expected the service account to be created in a fallback project, got: %v", err)
+	}
+	if expected := []string{"my-project", "overflow-1", "overflow-2"}; !reflect.DeepEqual(projects, expected) {
+		t.Fatalf("expected projects to be tried in order %v, got %v", expected, projects)
+	}
+	if rs.AccountId.Project != "overflow-2" || rs.RequestedProject != "my-project" || rs.project() != "my-project" {
+		t.Fatalf("expected the account in overflow-2 for project my-project, got %v (requested project %q)", rs.AccountId, rs.RequestedProject)
+	}
+	if !strings.Contains(warning, `"overflow-2"`) {
+		t.Fatalf("expected a warning naming the fallback project, got %q", warning)
+	}
+	// Only the WAL entry of the account that was created is left.
+	walIds, err := framework.ListWAL(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(walIds, []string{walId}) {
+		t.Fatalf("expected only the WAL entry of the new account, got %v", walIds)
+	}
+}
+
+func TestRoleSet_NewServiceAccountIdCollision(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var mu sync.Mutex
+	var creates, collisions int
+	var accountIds []string
+	b, storage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/projects/my-project/serviceAccounts" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var req iam.CreateServiceAccountRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("unable to decode create request: %v", err)
+		}
+		creates++
+		accountIds = append(accountIds, req.AccountId)
+		if creates <= collisions {
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(`{"error": {"code": 409, "message": "Service account already exists within project.", "status": "ALREADY_EXISTS"}}`))
+			return
+		}
+		json.NewEncoder(w).Encode(&iam.ServiceAccount{
+			Name:      "projects/my-project/serviceAccounts/" + req.AccountId + "@my-project.iam.gserviceaccount.com",
+			Email:     req.AccountId + "@my-project.iam.gserviceaccount.com",
+			ProjectId: "my-project",
+		})
+	}))
+	iamAdmin, err := b.(*backend).IAMAdminClient(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The first generated ID is taken, so a new one is generated and used.
+	collisions = 1
+	rs := &RoleSet{Name: "test-collision"}
+	walId, err := rs.newServiceAccount(ctx, storage, iamAdmin, "my-project", "", defaultServiceAccountSuffixLen, 2)
+	if err != nil {
+		t.Fatalf("expected service account to be created on retry, got: %v", err)
+	}
+	if creates != 2 || accountIds[0] == accountIds[1] {
+		t.Fatalf("expected a second attempt with a new ID, got IDs %v", accountIds)
+	}
+	if rs.AccountId == nil || rs.AccountId.EmailOrId != accountIds[1]+"@my-project.iam.gserviceaccount.com" {
+		t.Fatalf("expected role set to use the second ID, got %v", rs.AccountId)
+	}
+	// The WAL entry for the taken ID must not be left for rollback to delete
+	// an account that isn't ours.
+	walIds, err := framework.ListWAL(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(walIds, []string{walId}) {
+		t.Fatalf("expected only the WAL entry of the new account, got %v", walIds)
+	}
+	framework.DeleteWAL(ctx, storage, walId)
+
+	// Attempts are bounded.
+	creates, collisions, accountIds = 0, 2, nil
+	rs = &RoleSet{Name: "test-collision"}
+	if _, err := rs.newServiceAccount(ctx, storage, iamAdmin, "my-project", "", defaultServiceAccountSuffixLen, 2); err == nil || !strings.Contains(err.Error(), "after 2 attempts") {
+		t.Fatalf("expected creation to fail after 2 attempts, got: %v", err)
+	}
+	if creates != 2 {
+		t.Fatalf("expected 2 attempts, got %d", creates)
+	}
+	if walIds, err := framework.ListWAL(ctx, storage); err != nil || len(walIds) != 0 {
+		t.Fatalf("expected no WAL entries, got %v (err: %v)", walIds, err)
+	}
+}
+
+func TestRoleSet_NewServiceAccountAssignedEmail(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	const assignedEmail = "vault-assigned@other-project.iam.gserviceaccount.com"
+	const assignedName = "projects/other-project/serviceAccounts/" + assignedEmail
+	b, storage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/projects/my-project/serviceAccounts":
+			// The create response omits the email, so the account is
+			// fetched again.
+			json.NewEncoder(w).Encode(&iam.ServiceAccount{Name: assignedName})
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/"+assignedName:
+			json.NewEncoder(w).Encode(&iam.ServiceAccount{
+				Name:      assignedName,
+				Email:     assignedEmail,
+				ProjectId: "other-project",
+				UniqueId:  "1234567890",
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	iamAdmin, err := b.(*backend).IAMAdminClient(storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rs := &RoleSet{Name: "test-assignedemail"}
+	walId, err := rs.newServiceAccount(ctx, storage, iamAdmin, "my-project", "", defaultServiceAccountSuffixLen, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := gcputil.ServiceAccountId{Project: "other-project", EmailOrId: assignedEmail}
+	if rs.AccountId == nil || *rs.AccountId != expected || rs.AccountUniqueId != "1234567890" {
+		t.Fatalf("expected role set to use the account GCP returned, got %v (unique ID %q)", rs.AccountId, rs.AccountUniqueId)
+	}
+
+	// The WAL entry for the templated email is replaced by one for the
+	// returned account, so rollback deletes the account that was created.
+	walIds, err := framework.ListWAL(ctx, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(walIds, []string{walId}) {
+		t.Fatalf("expected only the WAL entry of the returned account, got %v", walIds)
+	}
+	wal, err := framework.GetWAL(ctx, storage, walId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry walAccount
+	if wal == nil || wal.Kind != walTypeAccount {
+		t.Fatalf("expected service account WAL entry, got %#v", wal)
+	}
+	if err := decodeWAL(wal.Data, &entry); err != nil {
+		t.Fatal(err)
 	}
-
-	// Verify old account was deleted and is not being used anymore.
-	if newSa.Name == initSa.Name {
-		t.Fatalf("expected role set to have new service account after update")
+	if entry.RoleSet != rs.Name || entry.Id != expected {
+		t.Fatalf("expected WAL entry for %v, got %#v", expected, entry)
 	}
-	verifyServiceAccountDeleted(t, td.IamAdmin, initSa.Name)
-	verifyProjectBindingsRemoved(t, td, initSa.Email, updatedRoles)
-
-	// 4. Delete role set
-	testRoleSetDelete(t, td, rsName, newSa.Name)
-	verifyProjectBindingsRemoved(t, td, newSa.Email, updatedRoles)
 }
 
-func TestPathRoleSet_RotateTokenRoleSet(t *testing.T) {
-	rsName := "test-rotatetokenrs"
-	roles := util.StringSet{
-		"roles/viewer": struct{}{},
+func TestSetIamPolicyWithRetry(t *testing.T) {
+	t.Parallel()
+
+	addViewer := func(p *iamutil.Policy) (bool, *iamutil.Policy) {
+		return p.AddBindings(&iamutil.PolicyDelta{
+			Email: "sa@my-project.iam.gserviceaccount.com",
+			Roles: util.ToSet([]string{"roles/viewer"}),
+		})
 	}
 
-	// Initial test set up - backend, initial config, test resources in project
-	td := setupTest(t, "0s", "2h")
-	defer cleanup(t, td, rsName, roles)
+	r := &conflictingResource{conflicts: 2}
+	changed, err := setIamPolicyWithRetry(context.Background(), nil, r, 2, addViewer)
+	if err != nil {
+		t.Fatalf("expected update to succeed after retries, got: %v", err)
+	}
+	if !changed || r.sets != 3 {
+		t.Errorf("expected policy to be set on the third attempt, got %d attempts", r.sets)
+	}
 
-	projRes := fmt.Sprintf(testProjectResourceTemplate, td.Project)
+	r = &conflictingResource{conflicts: 2}
+	if _, err := setIamPolicyWithRetry(context.Background(), nil, r, 1, addViewer); err == nil {
+		t.Fatal("expected update to fail once retries are exhausted")
+	}
+	if r.sets != 2 {
+		t.Errorf("expected 2 attempts, got %d", r.sets)
+	}
+}
 
-	// Create role set
-	expectedBinds := ResourceBindings{projRes: roles}
-	bindsRaw, err := util.BindingsHCL(expectedBinds)
+func TestPathRoleSet_DeleteDisablesServiceAccount(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var rs *RoleSet
+	var disabled, deleted bool
+	b, reqStorage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		saName := rs.AccountId.ResourceName()
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/"+saName+":disable":
+			disabled = true
+			w.Write([]byte(`{}`))
+		case r.Method == http.MethodDelete && r.URL.Path == "/v1/"+saName:
+			deleted = true
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	entry, err := logical.StorageEntryJSON("config", &config{DisableServiceAccountOnDelete: true})
 	if err != nil {
-		t.Fatalf("unable to convert resource bindings to HCL string: %v", err)
+		t.Fatal(err)
 	}
-	testRoleSetCreate(t, td, rsName,
-		map[string]interface{}{
-			"project":      td.Project,
-			"secret_type":  SecretTypeAccessToken,
-			"bindings":     bindsRaw,
-			"token_scopes": []string{"https://www.googleapis.com/auth/cloud-platform"},
-		})
-
-	// Verify initial role set.
-	respData := testRoleSetRead(t, td, rsName)
-	if respData == nil {
-		t.Fatalf("expected role set to have been created")
+	if err := reqStorage.Put(ctx, entry); err != nil {
+		t.Fatal(err)
 	}
-	initSa := getServiceAccount(t, td.IamAdmin, respData)
-	verifyProjectBinding(t, td, initSa.Email, roles)
 
-	// Rotate account and verify is new account.
-	testRoleSetRotate(t, td, rsName)
-	newSa := getServiceAccount(t, td.IamAdmin, testRoleSetRead(t, td, rsName))
-	if newSa.Name == initSa.Name {
-		t.Fatalf("expected role set to have new service account after rotation (update)")
+	rs = testStoredKeyRoleSet(t, reqStorage, "test-disablesa")
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "roleset/" + rs.Name,
+		Storage:   reqStorage,
+	})
+	if err != nil {
+		t.Fatal(err)
 	}
-	verifyProjectBinding(t, td, newSa.Email, roles)
+	if resp != nil && resp.IsError() {
+		t.Fatalf("unexpected delete response: %#v", resp)
+	}
+	// Removing bindings isn't served by the test server and only warns.
+	if !disabled {
+		t.Errorf("expected service account to be disabled")
+	}
+	if deleted {
+		t.Errorf("expected service account not to be deleted")
+	}
+	if stored, err := getRoleSet(rs.Name, ctx, reqStorage); err != nil || stored != nil {
+		t.Errorf("expected role set to be deleted, got %v (err: %v)", stored, err)
+	}
+}
 
-	// Verify old account/bindings deleted.
-	verifyServiceAccountDeleted(t, td.IamAdmin, initSa.Name)
-	verifyProjectBindingsRemoved(t, td, initSa.Email, roles)
+func TestPathRoleSet_DeleteDryRun(t *testing.T) {
+	t.Parallel()
 
-	// Get RoleSet object for confirming key rotation:
-	oldK := verifyRoleSetTokenKey(t, td, rsName)
+	ctx := context.Background()
+	b, reqStorage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected GCP request in dry run: %s %s", r.Method, r.URL.Path)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
 
-	// Rotate key only - should only change key, not service account
-	testRoleSetRotateKey(t, td, rsName)
-	saAfterRotate := getServiceAccount(t, td.IamAdmin, testRoleSetRead(t, td, rsName))
-	if saAfterRotate.Name != newSa.Name {
-		t.Fatalf("expected same service account (%s) after rotate key, instead got new account: %s", newSa.Name, saAfterRotate.Name)
+	rs := testStoredKeyRoleSet(t, reqStorage, "test-dryrun")
+	rs.Bindings = ResourceBindings{
+		"projects/p": util.ToSet([]string{"roles/viewer"}),
+	}
+	if err := rs.save(ctx, reqStorage); err != nil {
+		t.Fatal(err)
+	}
+	keyName := rs.AccountId.ResourceName() + "/keys/abc123"
+	if err := (&keyLease{RoleSet: rs.Name, KeyName: keyName}).save(ctx, reqStorage); err != nil {
+		t.Fatal(err)
 	}
 
-	// Verify old key was deleted
-	result, err := td.IamAdmin.Projects.ServiceAccounts.Keys.Get(oldK.Name).Do()
-	if err == nil && result != nil {
-		t.Fatalf("old key was supposed to be deleted but get succeded")
-	} else if err != nil && !isGoogleAccountKeyNotFoundErr(err) {
-		t.Fatalf("got an error while trying to confirm service account key was deleted: %v", err)
+	for _, tc := range []struct {
+		keyCleanup string
+		action     string
+		bindings   map[string][]string
+	}{
+		{keyCleanupRevoke, "delete", map[string][]string{"projects/p": {"roles/viewer"}}},
+		{keyCleanupExpire, "keep_until_leases_end", map[string][]string{}},
+	} {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      "roleset/" + rs.Name,
+			Data: map[string]interface{}{
+				"dry_run":               true,
+				"key_cleanup_on_delete": tc.keyCleanup,
+			},
+			Storage: reqStorage,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil || resp.IsError() {
+			t.Fatalf("expected a deletion plan, got: %#v", resp)
+		}
+		if len(resp.Warnings) == 0 {
+			t.Errorf("expected a warning that force is needed")
+		}
+		if resp.Data["active_leases"] != 1 || !reflect.DeepEqual(resp.Data["leased_keys"], []string{keyName}) {
+			t.Errorf("expected the active lease in the plan, got %v", resp.Data)
+		}
+		if resp.Data["service_account_action"] != tc.action {
+			t.Errorf("expected service account action %q, got %v", tc.action, resp.Data["service_account_action"])
+		}
+		if !reflect.DeepEqual(resp.Data["removed_bindings"], tc.bindings) {
+			t.Errorf("expected removed bindings %v, got %v", tc.bindings, resp.Data["removed_bindings"])
+		}
 	}
 
-	// Verify new key != old key
-	newK := verifyRoleSetTokenKey(t, td, rsName)
-	if newK.Name == oldK.Name {
-		t.Fatalf("expected new key to have been created in rotate")
+	// Nothing was deleted or queued for rollback.
+	if stored, err := getRoleSet(rs.Name, ctx, reqStorage); err != nil || stored == nil {
+		t.Fatalf("expected role set to be kept, got %v (err: %v)", stored, err)
 	}
-	if newK.PrivateKeyData == oldK.PrivateKeyData {
-		t.Fatalf("expected new key data to have been created and saved in rotate")
+	if leases, err := listKeyLeases(ctx, reqStorage, rs.Name); err != nil || len(leases) != 1 {
+		t.Fatalf("expected key lease to be kept, got %v (err: %v)", leases, err)
+	}
+	if walIds, err := framework.ListWAL(ctx, reqStorage); err != nil || len(walIds) != 0 {
+		t.Fatalf("expected no WAL entries, got %v (err: %v)", walIds, err)
 	}
-
-	// 4. Delete role set
-	testRoleSetDelete(t, td, rsName, newSa.Name)
-	verifyProjectBindingsRemoved(t, td, newSa.Email, roles)
 }
 
-// Helpers for calling backend methods
-func testRoleSetCreate(t *testing.T, td *testData, rsName string, d map[string]interface{}) {
-	resp, err := td.B.HandleRequest(context.Background(), &logical.Request{
-		Operation: logical.CreateOperation,
-		Path:      fmt.Sprintf("roleset/%s", rsName),
-		Data:      d,
-		Storage:   td.S,
+func TestPathRoleSet_DeleteKeyCleanupExpire(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var mu sync.Mutex
+	var rs *RoleSet
+	var saDeleted, keyDeleted bool
+	b, reqStorage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		saName := rs.AccountId.ResourceName()
+		switch {
+		case r.Method == http.MethodDelete && r.URL.Path == "/v1/"+saName:
+			saDeleted = true
+			w.Write([]byte(`{}`))
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/v1/"+saName+"/keys/"):
+			keyDeleted = true
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	rs = testStoredKeyRoleSet(t, reqStorage, "test-keycleanup")
+	keyName := rs.AccountId.ResourceName() + "/keys/abc123"
+	if err := (&keyLease{RoleSet: rs.Name, KeyName: keyName}).save(ctx, reqStorage); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.DeleteOperation,
+		Path:      "roleset/" + rs.Name,
+		Data: map[string]interface{}{
+			"force":                 true,
+			"key_cleanup_on_delete": keyCleanupExpire,
+		},
+		Storage: reqStorage,
 	})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if resp != nil && resp.IsError() {
-		t.Fatal(resp.Error())
+	if resp == nil || resp.IsError() || len(resp.Warnings) == 0 {
+		t.Fatalf("expected delete to warn about the keys left to expire, got %#v", resp)
+	}
+	mu.Lock()
+	if saDeleted || keyDeleted {
+		t.Fatalf("expected service account and key to be kept, deleted service account: %t, key: %t", saDeleted, keyDeleted)
+	}
+	mu.Unlock()
+	if kl, err := getKeyLease(ctx, reqStorage, rs.Name, keyName); err != nil || kl == nil {
+		t.Fatalf("expected key lease to be kept, got %v (err: %v)", kl, err)
 	}
-}
 
-func testRoleSetRead(t *testing.T, td *testData, rsName string) map[string]interface{} {
-	resp, err := td.B.HandleRequest(context.Background(), &logical.Request{
-		Operation: logical.ReadOperation,
-		Path:      fmt.Sprintf("roleset/%s", rsName),
-		Storage:   td.S,
+	// The name can't be reused while the keys are outstanding.
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roleset/" + rs.Name,
+		Data: map[string]interface{}{
+			"project":  "my-project",
+			"bindings": fmt.Sprintf(`resource "%s" { roles = ["roles/viewer"] }`, fmt.Sprintf(testProjectResourceTemplate, "my-project")),
+		},
+		Storage: reqStorage,
 	})
-
 	if err != nil {
 		t.Fatal(err)
 	}
-
-	if resp.IsError() {
-		t.Fatal(resp.Error())
-	}
-	if resp == nil {
-		return nil
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "key_cleanup_on_delete") {
+		t.Fatalf("expected creating a role set with an orphaned name to fail, got %#v", resp)
 	}
 
-	return resp.Data
-}
-
-func testRoleSetUpdate(t *testing.T, td *testData, rsName string, d map[string]interface{}) {
-	resp, err := td.B.HandleRequest(context.Background(), &logical.Request{
-		Operation: logical.UpdateOperation,
-		Path:      fmt.Sprintf("roleset/%s", rsName),
-		Data:      d,
-		Storage:   td.S,
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.RevokeOperation,
+		Storage:   reqStorage,
+		Secret: &logical.Secret{
+			InternalData: map[string]interface{}{
+				"secret_type": SecretTypeKey,
+				"key_name":    keyName,
+				"role_set":    rs.Name,
+			},
+		},
 	})
 	if err != nil {
 		t.Fatal(err)
@@ -455,297 +2599,704 @@ func testRoleSetUpdate(t *testing.T, td *testData, rsName string, d map[string]i
 	if resp != nil && resp.IsError() {
 		t.Fatal(resp.Error())
 	}
+	mu.Lock()
+	if !keyDeleted || !saDeleted {
+		t.Errorf("expected key and service account to be deleted after the last lease, deleted service account: %t, key: %t", saDeleted, keyDeleted)
+	}
+	mu.Unlock()
+	if orphan, err := getOrphanedRoleSet(ctx, reqStorage, rs.Name); err != nil || orphan != nil {
+		t.Errorf("expected orphaned role set to be removed, got %v (err: %v)", orphan, err)
+	}
 }
 
-func testRoleSetRotate(t *testing.T, td *testData, rsName string) {
-	resp, err := td.B.HandleRequest(context.Background(), &logical.Request{
-		Operation: logical.UpdateOperation,
-		Path:      fmt.Sprintf("roleset/%s/rotate", rsName),
-		Storage:   td.S,
-	})
+func TestPathRoleSet_FieldErrors(t *testing.T) {
+	t.Parallel()
 
+	b, storage := getTestBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roleset/test-fielderrors",
+		Data: map[string]interface{}{
+			"secret_type":      SecretTypeAccessToken,
+			"default_audience": "not a url",
+			"token_creators":   "nobody@example.com",
+		},
+		Storage: storage,
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error response, got: %#v", resp)
+	}
 
-	if resp.IsError() {
-		t.Fatal(resp.Error())
+	lines := strings.Split(resp.Error().Error(), "\n")
+	if exp := "5 invalid fields:"; lines[0] != exp {
+		t.Fatalf("expected summary %q, got %q", exp, lines[0])
+	}
+	var fields []string
+	for _, line := range lines[1:] {
+		fields = append(fields, strings.SplitN(strings.TrimPrefix(line, "* "), ":", 2)[0])
+	}
+	expected := []string{"project", "token_scopes", "default_audience", "token_creators", "bindings"}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("expected errors for fields %v, got %v", expected, fields)
 	}
-	return
 }
 
-func testRoleSetRotateKey(t *testing.T, td *testData, rsName string) {
-	resp, err := td.B.HandleRequest(context.Background(), &logical.Request{
-		Operation: logical.UpdateOperation,
-		Path:      fmt.Sprintf("roleset/%s/rotate-key", rsName),
-		Storage:   td.S,
+func TestPathRoleSet_DefaultSecretType(t *testing.T) {
+	t.Parallel()
+
+	b, storage := getTestBackend(t)
+	testConfigUpdate(t, b, storage, map[string]interface{}{
+		"default_secret_type": SecretTypeKey,
 	})
 
-	if err != nil {
-		t.Fatal(err)
+	// fieldErrorsOf returns the fields a role set create without a project
+	// fails for, which depend on its secret type.
+	fieldErrorsOf := func(data map[string]interface{}) []string {
+		resp, err := b.HandleRequest(context.Background(), &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "roleset/test-defaultsecrettype",
+			Data:      data,
+			Storage:   storage,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp == nil || !resp.IsError() {
+			t.Fatalf("expected error response, got: %#v", resp)
+		}
+		var fields []string
+		for _, line := range strings.Split(resp.Error().Error(), "\n")[1:] {
+			fields = append(fields, strings.SplitN(strings.TrimPrefix(line, "* "), ":", 2)[0])
+		}
+		return fields
 	}
 
-	if resp.IsError() {
-		t.Fatal(resp.Error())
+	// Without secret_type, the role set is a key role set, which needs no
+	// token_scopes and can't have required_metadata.
+	fields := fieldErrorsOf(map[string]interface{}{
+		"required_metadata": map[string]string{"env": "prod"},
+	})
+	if expected := []string{"project", "required_metadata", "bindings"}; !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("expected errors for fields %v, got %v", expected, fields)
 	}
-	return
-}
 
-func testRoleSetDelete(t *testing.T, td *testData, rsName, saName string) {
-	resp, err := td.B.HandleRequest(context.Background(), &logical.Request{
-		Operation: logical.DeleteOperation,
-		Path:      fmt.Sprintf("roleset/%s", rsName),
-		Storage:   td.S,
+	// An explicit secret_type overrides the default, and still requires the
+	// fields of its type.
+	fields = fieldErrorsOf(map[string]interface{}{
+		"secret_type": SecretTypeAccessToken,
 	})
+	if expected := []string{"project", "token_scopes", "bindings"}; !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("expected errors for fields %v, got %v", expected, fields)
+	}
+}
+
+func TestPathRoleSet_BindingsTerraform(t *testing.T) {
+	t.Parallel()
 
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+
+	rs := testStoredKeyRoleSet(t, storage, "test-tf")
+	rs.Bindings = ResourceBindings{
+		"projects/my-project":                           util.ToSet([]string{"roles/viewer"}),
+		"//storage.googleapis.com/b/my-bucket":          util.ToSet([]string{"roles/storage.objectViewer"}),
+		"projects/my-project/serviceAccounts/sa@x.com":  util.ToSet([]string{"roles/iam.serviceAccountUser"}),
+		"projects/my-project/zones/us-east1-b/disks/d1": util.ToSet([]string{"roles/compute.viewer"}),
+	}
+	rs.BindingMembers = map[string]util.StringSet{
+		"projects/my-project/serviceAccounts/sa@x.com": util.ToSet([]string{"group:admins@example.com"}),
+	}
+	if err := rs.save(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "roleset/test-tf/bindings",
+		Data:      map[string]interface{}{"format": "terraform"},
+		Storage:   storage,
+	})
 	if err != nil {
-		t.Fatalf("unable to delete role set: %v", err)
-	} else if resp != nil {
-		if len(resp.Warnings) > 0 {
-			t.Logf("warnings returned from role set delete. Warnings:\n %s\n", strings.Join(resp.Warnings, ",\n"))
-		}
-		if resp.IsError() {
-			t.Fatalf("unable to delete role set: %v", resp.Error())
-		}
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response: %#v", resp)
 	}
 
-	verifyServiceAccountDeleted(t, td.IamAdmin, saName)
+	sa := rs.AccountId.EmailOrId
+	expected := `resource "google_storage_bucket_iam_member" "vault_test-tf_0" {
+  bucket = "my-bucket"
+  role = "roles/storage.objectViewer"
+  member = "serviceAccount:` + sa + `"
 }
 
-// Test helpers
-func verifyReadData(t *testing.T, actual map[string]interface{}, expected map[string]interface{}) {
-	for k, v := range expected {
-		actV, ok := actual[k]
-		if !ok {
-			t.Errorf("key '%s' not found, expected: %v", k, v)
-		} else if k == "bindings" {
-			verifyReadBindings(t, v.(ResourceBindings), actV)
-		} else if k == "token_scopes" {
-			if !strutil.EquivalentSlices(v.([]string), actV.([]string)) {
-				t.Errorf("token scopes mismatch; expected: %v, actual: %v", v, actV)
-			}
-		} else if v != actV {
-			t.Errorf("mismatch for key '%s'; expected: %v, actual: %v", k, v, actV)
-		}
+resource "google_project_iam_member" "vault_test-tf_1" {
+  project = "my-project"
+  role = "roles/viewer"
+  member = "serviceAccount:` + sa + `"
+}
+
+resource "google_service_account_iam_member" "vault_test-tf_2" {
+  service_account_id = "projects/my-project/serviceAccounts/sa@x.com"
+  role = "roles/iam.serviceAccountUser"
+  member = "serviceAccount:` + sa + `"
+}
+
+resource "google_service_account_iam_member" "vault_test-tf_3" {
+  service_account_id = "projects/my-project/serviceAccounts/sa@x.com"
+  role = "roles/iam.serviceAccountUser"
+  member = "group:admins@example.com"
+}
+
+`
+	if resp.Data["terraform"] != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, resp.Data["terraform"])
+	}
+	if unsupported := resp.Data["unsupported_resources"]; !reflect.DeepEqual(unsupported, []string{"projects/my-project/zones/us-east1-b/disks/d1"}) {
+		t.Fatalf("expected the disk to be unsupported, got %v", unsupported)
+	}
+	if len(resp.Warnings) != 1 {
+		t.Fatalf("expected a warning about the unsupported resource, got %v", resp.Warnings)
 	}
 
-	if t.Failed() {
-		t.FailNow()
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "roleset/test-tf/bindings",
+		Data:      map[string]interface{}{"format": "yaml"},
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error for invalid format, got: %#v", resp)
+	}
+}
+
+func TestSelfEscalatingBindings(t *testing.T) {
+	t.Parallel()
+
+	bindings := ResourceBindings{
+		"projects/my-project": util.ToSet([]string{"roles/viewer", serviceAccountKeyAdminRole}),
+		"//cloudresourcemanager.googleapis.com/projects/my-project": util.ToSet([]string{serviceAccountTokenCreatorRole}),
+		"projects/other-project":                                    util.ToSet([]string{serviceAccountKeyAdminRole}),
+		"projects/my-project/topics/my-topic":                       util.ToSet([]string{serviceAccountTokenCreatorRole}),
+	}
+	expected := []string{
+		serviceAccountKeyAdminRole + " on projects/my-project",
+		serviceAccountTokenCreatorRole + " on //cloudresourcemanager.googleapis.com/projects/my-project",
+	}
+	if actual := selfEscalatingBindings("my-project", bindings); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+
+	b, storage := getTestBackend(t)
+	testConfigUpdate(t, b, storage, map[string]interface{}{
+		"deny_self_escalating_bindings": true,
+	})
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roleset/test-selfescalation",
+		Data: map[string]interface{}{
+			"project":     "my-project",
+			"secret_type": SecretTypeKey,
+			"bindings":    `resource "projects/my-project" { roles = ["roles/iam.serviceAccountKeyAdmin"] }`,
+		},
+		Storage: storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "deny_self_escalating_bindings") {
+		t.Fatalf("expected self-escalating bindings to be rejected, got: %#v", resp)
 	}
 }
 
-func verifyReadBindings(t *testing.T, expected ResourceBindings, actualRaw interface{}) {
-	if actualRaw == nil && expected != nil {
-		t.Fatalf("expected bindings")
+func TestPathRoleSet_RecreateSurvivesRollback(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var mu sync.Mutex
+	var deleted []string
+	b, storage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodDelete {
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/v1/"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+
+	// The role set was deleted and recreated with a new service account
+	// before the cleanup of its predecessor was rolled back.
+	rs := testStoredKeyRoleSet(t, storage, "test-recreate")
+	rs.Bindings = ResourceBindings{
+		"projects/p": util.StringSet{"roles/viewer": struct{}{}},
 	}
-	actual := actualRaw.(map[string][]string)
-	if len(actual) != len(expected) {
-		t.Fatalf("expected %d bindings, got %d bindings in role set", len(expected), len(actual))
+	rs.BindingMembers = map[string]util.StringSet{
+		"projects/p": util.ToSet([]string{"group:g@example.com"}),
 	}
-	for res, v := range expected {
-		actB, ok := actual[res]
-		if !ok {
-			t.Fatalf("expected bindings for resource %s", res)
-		}
-		if !util.ToSet(actB).Equals(v) {
-			t.Fatalf("could not find same bindings; expected: %v, actual: %v", v.ToSlice(), actB)
+	if err := rs.save(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	oldAccount := gcputil.ServiceAccountId{Project: "my-project", EmailOrId: "vaulttest-recreate-old@my-project.iam.gserviceaccount.com"}
+
+	newSA := "serviceAccount:" + rs.AccountId.EmailOrId
+	oldSA := "serviceAccount:" + oldAccount.EmailOrId
+	res := &fakeResource{policy: iamutil.Policy{Bindings: []*iamutil.Binding{
+		{Role: "roles/viewer", Members: []string{oldSA, newSA, "group:g@example.com"}},
+	}}}
+	b.(*backend).resources = fakeResources{"projects/p": res}
+
+	for _, wal := range []struct {
+		kind string
+		data interface{}
+	}{
+		{walTypeAccount, &walAccount{RoleSet: rs.Name, Id: oldAccount}},
+		{walTypeIamPolicy, &walIamPolicy{
+			RoleSet:   rs.Name,
+			AccountId: oldAccount,
+			Resource:  "projects/p",
+			Roles:     []string{"roles/viewer"},
+			Members:   map[string][]string{"roles/viewer": {"group:g@example.com"}},
+		}},
+	} {
+		if _, err := framework.PutWAL(ctx, storage, wal.kind, wal.data); err != nil {
+			t.Fatal(err)
 		}
 	}
-}
 
-func verifyRoleSetTokenKey(t *testing.T, td *testData, rsName string) *iam.ServiceAccountKey {
-	rs, err := getRoleSet(rsName, context.Background(), td.S)
-	if rs.TokenGen == nil || rs.TokenGen.KeyName == "" {
-		t.Fatalf("expected token gen to have been created for access token role set")
-	}
-	keyData := rs.TokenGen.B64KeyJSON
-	key, err := td.IamAdmin.Projects.ServiceAccounts.Keys.Get(rs.TokenGen.KeyName).Do()
+	walIds, err := framework.ListWAL(ctx, storage)
 	if err != nil {
-		t.Fatalf("could not confirm key for role set service account to generate tokens: %v", err)
+		t.Fatal(err)
 	}
-	key.PrivateKeyData = keyData
-	return key
-}
-
-func getServiceAccount(t *testing.T, iamAdmin *iam.Service, readData map[string]interface{}) *iam.ServiceAccount {
-	emailRaw, ok := readData["service_account_email"]
-	if !ok {
-		t.Fatalf("expected role set to have service account email in returned read")
+	for _, id := range walIds {
+		wal, err := framework.GetWAL(ctx, storage, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := b.(*backend).walRollback(ctx, &logical.Request{Storage: storage}, wal.Kind, wal.Data); err != nil {
+			t.Fatal(err)
+		}
 	}
 
-	proj, ok := readData["project"]
-	if !ok {
-		t.Fatalf("expected role set to have service project in returned read")
+	if expected := []string{oldAccount.ResourceName()}; !reflect.DeepEqual(deleted, expected) {
+		t.Fatalf("expected only the old service account deleted, got %v", deleted)
+	}
+	if res.policy.HasMember("roles/viewer", oldSA) {
+		t.Fatalf("expected the old service account to lose roles/viewer, got %v", res.policy.Bindings)
+	}
+	for _, m := range []string{newSA, "group:g@example.com"} {
+		if !res.policy.HasMember("roles/viewer", m) {
+			t.Fatalf("expected %s to keep roles/viewer, got %v", m, res.policy.Bindings)
+		}
 	}
 
-	saName := fmt.Sprintf(gcputil.ServiceAccountTemplate, proj, emailRaw.(string))
-	sa, err := iamAdmin.Projects.ServiceAccounts.Get(saName).Do()
+	// The group is now removed along with the new role set.
+	stored, err := getRoleSet(rs.Name, ctx, storage)
 	if err != nil {
-		if isGoogleAccountNotFoundErr(err) {
-			t.Fatalf("expected role set service account '%s' exists", saName)
-		} else {
-			t.Fatalf("unexpected error while trying to get role set service account '%s': %v", saName, err)
-		}
+		t.Fatal(err)
 	}
-	if sa == nil {
-		t.Fatalf("expected role set service account '%s' exists", saName)
+	expected := map[string]RoleMembers{
+		"projects/p": {"roles/viewer": util.ToSet([]string{"group:g@example.com"})},
+	}
+	if !reflect.DeepEqual(stored.AddedMembers, expected) {
+		t.Fatalf("expected the group to be taken over, got %v", stored.AddedMembers)
 	}
-	return sa
 }
 
-func verifyServiceAccountDeleted(t *testing.T, iamAdmin *iam.Service, saName string) {
-	_, err := iamAdmin.Projects.ServiceAccounts.Get(saName).Do()
-	if err == nil || !isGoogleAccountNotFoundErr(err) {
-		t.Fatalf("expected service account '%s' to have been deleted", saName)
+func TestPathRoleSet_Transfer(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, storage := getTestBackend(t)
+	rs := testStoredKeyRoleSet(t, storage, "test-transfer-old")
+	kl := &keyLease{
+		RoleSet:   rs.Name,
+		KeyName:   rs.AccountId.ResourceName() + "/keys/key1",
+		IssueTime: time.Now(),
 	}
-}
+	if err := kl.save(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	testStoredKeyRoleSet(t, storage, "test-transfer-taken")
 
-func verifyProjectBinding(t *testing.T, td *testData, email string, roleSet util.StringSet) {
-	found := roleSubsetBoundOnProject(t, td.HttpClient, td.Project, email, roleSet)
-	if len(roleSet) > len(found) {
-		notFound := roleSet.Sub(found)
-		for r := range notFound {
-			t.Errorf("role (%s) not bound to member (%s) for project '%s'", r, email, td.Project)
+	transfer := func(name, newName string) *logical.Response {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "roleset/" + name + "/transfer",
+			Storage:   storage,
+			Data:      map[string]interface{}{"new_name": newName},
+		})
+		if err != nil {
+			t.Fatal(err)
 		}
-		t.FailNow()
+		return resp
 	}
-}
 
-func verifyProjectBindingsRemoved(t *testing.T, td *testData, email string, roleSet util.StringSet) {
-	found := roleSubsetBoundOnProject(t, td.HttpClient, td.Project, email, roleSet)
-	for r := range found {
-		t.Errorf("role (%s) still bound to service account (%s) for project '%s'", r, email, td.Project)
+	if resp := transfer(rs.Name, "test-transfer-taken"); resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "already exists") {
+		t.Fatalf("expected transfer to an existing role set to fail, got %#v", resp)
 	}
-	if t.Failed() {
-		t.FailNow()
+
+	// A role set sharing the service account, e.g. imported with it, keeps
+	// it from being transferred.
+	shared := testStoredKeyRoleSet(t, storage, "test-transfer-shared")
+	shared.AccountId = rs.AccountId
+	if err := shared.save(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	if resp := transfer(rs.Name, "test-transfer-new"); resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "role set 'test-transfer-shared'") {
+		t.Fatalf("expected transfer of a shared service account to fail, got %#v", resp)
+	}
+	if err := storage.Delete(ctx, "roleset/"+shared.Name); err != nil {
+		t.Fatal(err)
 	}
-}
 
-func roleSubsetBoundOnProject(t *testing.T, httpC *http.Client, project, email string, roleSet util.StringSet) util.StringSet {
-	if project == "" {
-		t.Fatalf("expected project")
+	resp := transfer(rs.Name, "test-transfer-new")
+	if resp == nil || resp.IsError() || resp.Data["key_leases"] != 1 {
+		t.Fatalf("expected transfer with one key lease, got %#v", resp)
+	}
+	if old, err := getRoleSet(rs.Name, ctx, storage); err != nil || old != nil {
+		t.Fatalf("expected the old role set to be gone, got %v (err: %v)", old, err)
+	}
+	moved, err := getRoleSet("test-transfer-new", ctx, storage)
+	if err != nil || moved == nil || moved.AccountId.ResourceName() != rs.AccountId.ResourceName() {
+		t.Fatalf("expected the role set under its new name with the same service account, got %v (err: %v)", moved, err)
+	}
+	if leases, err := listKeyLeases(ctx, storage, "test-transfer-new"); err != nil || len(leases) != 1 || leases[0].KeyName != kl.KeyName || leases[0].RoleSet != "test-transfer-new" {
+		t.Fatalf("expected the key lease to be moved, got %v (err: %v)", leases, err)
+	}
+	if leases, err := listKeyLeases(ctx, storage, rs.Name); err != nil || len(leases) != 0 {
+		t.Fatalf("expected no key leases under the old name, got %v (err: %v)", leases, err)
 	}
-	member := fmt.Sprintf("serviceAccount:%s", email)
 
-	crm, err := cloudresourcemanager.New(httpC)
-	if err != nil {
-		t.Fatalf("[WARNING] Unable to ensure test project bindings deleted: %v", err)
+	// Leases issued under the old name resolve to the new one.
+	secret := &logical.Secret{InternalData: map[string]interface{}{"role_set": rs.Name}}
+	if name, err := secretRoleSetName(ctx, storage, secret); err != nil || name != "test-transfer-new" {
+		t.Fatalf("expected the secret's role set to resolve to the new name, got %q (err: %v)", name, err)
 	}
 
-	p, err := crm.Projects.GetIamPolicy(project, &cloudresourcemanager.GetIamPolicyRequest{}).Do()
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roleset/" + rs.Name,
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"project":  "my-project",
+			"bindings": rs.RawBindings,
+		},
+	})
 	if err != nil {
-		t.Fatalf("[WARNING] Unable to ensure test project bindings deleted, could not get policy: %v", err)
+		t.Fatal(err)
 	}
-	found := make(util.StringSet)
-	for _, bind := range p.Bindings {
-		if roleSet.Includes(bind.Role) {
-			for _, m := range bind.Members {
-				if m == member {
-					found.Add(bind.Role)
-				}
-			}
-		}
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "was transferred to 'test-transfer-new'") {
+		t.Fatalf("expected the transferred name not to be reusable, got %#v", resp)
 	}
-	return found
-}
-
-// Set up/Teardown
-type testData struct {
-	B          logical.Backend
-	S          logical.Storage
-	Project    string
-	HttpClient *http.Client
-	IamAdmin   *iam.Service
-}
 
-func setupTest(t *testing.T, ttl, maxTTL string) *testData {
-	proj := util.GetTestProject(t)
-	credsJson, creds := util.GetTestCredentials(t)
-	httpC, err := gcputil.GetHttpClient(creds, iam.CloudPlatformScope)
+	// The transfer is kept while leases issued under the old name may
+	// still exist, and dropped once they can't.
+	gb := b.(*backend)
+	if err := gb.cleanupRoleSetTransfers(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	transferred, err := getRoleSetTransfer(ctx, storage, rs.Name)
+	if err != nil || transferred == nil {
+		t.Fatalf("expected the recent transfer to be kept, got %v (err: %v)", transferred, err)
+	}
+	transferred.TransferTime = time.Now().Add(-gb.System().MaxLeaseTTL() - time.Minute)
+	entry, err := logical.StorageEntryJSON(transferredRoleSetStoragePrefix+"/"+rs.Name, transferred)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if err := storage.Put(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := gb.cleanupRoleSetTransfers(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	if transferred, err := getRoleSetTransfer(ctx, storage, rs.Name); err != nil || transferred != nil {
+		t.Fatalf("expected the transfer to be dropped once its leases expired, got %v (err: %v)", transferred, err)
+	}
+	if resp, err := checkRoleSetNameReusable(ctx, storage, rs.Name); err != nil || resp != nil {
+		t.Fatalf("expected the old name to be reusable, got %#v (err: %v)", resp, err)
+	}
+}
 
-	iamAdmin, err := iam.NewService(context.Background(), option.WithHTTPClient(httpC))
-	if err != nil {
+func TestPathRoleSet_DeletionDelayUndelete(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var mu sync.Mutex
+	var deleted []string
+	b, storage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Method == http.MethodDelete {
+			deleted = append(deleted, strings.TrimPrefix(r.URL.Path, "/v1/"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	testConfigUpdate(t, b, storage, map[string]interface{}{
+		"sa_deletion_delay": "1h",
+	})
+
+	rs := testStoredKeyRoleSet(t, storage, "test-undelete")
+	rs.Bindings = ResourceBindings{
+		"projects/p": util.StringSet{"roles/viewer": struct{}{}},
+	}
+	if err := rs.save(ctx, storage); err != nil {
 		t.Fatal(err)
 	}
+	sa := "serviceAccount:" + rs.AccountId.EmailOrId
+	res := &fakeResource{policy: iamutil.Policy{Bindings: []*iamutil.Binding{
+		{Role: "roles/viewer", Members: []string{sa}},
+	}}}
+	b.(*backend).resources = fakeResources{"projects/p": res}
+
+	rollback := func(deleteAfter time.Time) {
+		t.Helper()
+		walIds, err := framework.ListWAL(ctx, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, id := range walIds {
+			wal, err := framework.GetWAL(ctx, storage, id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := framework.DeleteWAL(ctx, storage, id); err != nil {
+				t.Fatal(err)
+			}
+			if !deleteAfter.IsZero() {
+				wal.Data.(map[string]interface{})["DeleteAfter"] = deleteAfter.Format(time.RFC3339)
+			}
+			if err := b.(*backend).walRollback(ctx, &logical.Request{Storage: storage}, wal.Kind, wal.Data); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	deleteRoleSet := func() {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.DeleteOperation,
+			Path:      "roleset/test-undelete",
+			Storage:   storage,
+		})
+		if err != nil || resp == nil || resp.IsError() {
+			t.Fatalf("unexpected response deleting role set: %v, %#v", err, resp)
+		}
+		if len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], "undelete-roleset/test-undelete") {
+			t.Fatalf("expected a warning about undeleting, got %v", resp.Warnings)
+		}
+		if res.policy.HasMember("roles/viewer", sa) {
+			t.Fatalf("expected bindings to be removed immediately, got %v", res.policy.Bindings)
+		}
+	}
 
-	b, reqStorage := getTestBackend(t)
+	// Before the delay passes, the service account is kept.
+	deleteRoleSet()
+	rollback(time.Time{})
+	if len(deleted) != 0 {
+		t.Fatalf("expected the service account to be kept, got deletes %v", deleted)
+	}
 
-	testConfigUpdate(t, b, reqStorage, map[string]interface{}{
-		"credentials": credsJson,
-		"ttl":         ttl,
-		"max_ttl":     maxTTL,
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "undelete-roleset/test-undelete",
+		Storage:   storage,
 	})
+	if err != nil || resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response undeleting role set: %v, %#v", err, resp)
+	}
+	if stored, err := getRoleSet(rs.Name, ctx, storage); err != nil || stored == nil {
+		t.Fatalf("expected role set to be restored, got %v (err: %v)", stored, err)
+	}
+	if !res.policy.HasMember("roles/viewer", sa) {
+		t.Fatalf("expected bindings to be restored, got %v", res.policy.Bindings)
+	}
 
-	return &testData{
-		B:          b,
-		S:          reqStorage,
-		Project:    proj,
-		HttpClient: httpC,
-		IamAdmin:   iamAdmin,
+	// The service account's WAL entry is dropped, even once due.
+	rollback(time.Now().Add(-time.Minute))
+	if walIds, err := framework.ListWAL(ctx, storage); err != nil || len(walIds) != 0 {
+		t.Fatalf("expected no WAL entries left, got %v (err: %v)", walIds, err)
+	}
+	if len(deleted) != 0 {
+		t.Fatalf("expected the service account to be kept, got deletes %v", deleted)
 	}
-}
 
-func cleanup(t *testing.T, td *testData, rsName string, roles util.StringSet) {
-	resp, err := td.IamAdmin.Projects.ServiceAccounts.List(fmt.Sprintf("projects/%s", td.Project)).Do()
+	// Once the delay passes, the account is deleted and the role set can't
+	// be undeleted anymore.
+	deleteRoleSet()
+	rollback(time.Now().Add(-time.Minute))
+	if expected := []string{rs.AccountId.ResourceName()}; !reflect.DeepEqual(deleted, expected) {
+		t.Fatalf("expected the service account to be deleted, got deletes %v", deleted)
+	}
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "undelete-roleset/test-undelete",
+		Storage:   storage,
+	})
 	if err != nil {
-		t.Logf("[WARNING] Could not clean up test service accounts for role set %s or projects/%s IAM policy bindings (did test fail?)", rsName, td.Project)
-		return
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected undelete to fail, got %#v", resp)
 	}
+}
 
-	memberStrs := make(util.StringSet)
-	for _, sa := range resp.Accounts {
-		if sa.DisplayName == fmt.Sprintf(serviceAccountDisplayNameTmpl, rsName) {
-			memberStrs.Add("serviceAccount:" + sa.Email)
-			t.Logf("[WARNING] found test service account %s that should have been deleted, did test fail? Manually deleting...", sa.Name)
-			if _, err := td.IamAdmin.Projects.ServiceAccounts.Delete(sa.Name).Do(); err != nil {
-				if isGoogleAccountNotFoundErr(err) {
-					t.Logf("[WARNING] Disregard previous warning - manual delete returned 404, probably IAM eventual consistency")
-					continue
-				}
-				t.Logf("[WARNING] Auto-delete failed - manually clean up service account %s: %v", sa.Name, err)
-			}
+func TestPathRoleSet_PermissionDeniedTemplate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, storage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/projects/my-project/serviceAccounts":
+			var req iam.CreateServiceAccountRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			email := fmt.Sprintf("%s@my-project.iam.gserviceaccount.com", req.AccountId)
+			json.NewEncoder(w).Encode(&iam.ServiceAccount{
+				Name:  "projects/my-project/serviceAccounts/" + email,
+				Email: email,
+			})
+		case r.Method == http.MethodDelete:
+			w.Write([]byte(`{}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	denied := &fakeResource{setErr: &googleapi.Error{Code: http.StatusForbidden, Message: "Policy update access denied."}}
+	b.(*backend).resources = fakeResources{"projects/denied": denied}
+
+	createRoleSet := func() string {
+		t.Helper()
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.CreateOperation,
+			Path:      "roleset/test-denied",
+			Data: map[string]interface{}{
+				"project":     "my-project",
+				"secret_type": SecretTypeKey,
+				"bindings":    `resource "projects/denied" { roles = ["roles/viewer"] }`,
+			},
+			Storage: storage,
+		})
+		if err != nil {
+			t.Fatal(err)
 		}
+		if resp == nil || !resp.IsError() {
+			t.Fatalf("expected an error, got: %#v", resp)
+		}
+		return resp.Error().Error()
 	}
 
-	crm, err := cloudresourcemanager.New(td.HttpClient)
-	if err != nil {
-		t.Logf("[WARNING] Unable to ensure test project bindings deleted: %v", err)
-		return
+	// The permission is derived from the resource type if GCP doesn't name
+	// it.
+	msg := createRoleSet()
+	for _, expected := range []string{"default application credentials", "projects/denied", "resourcemanager.projects.setIamPolicy", "Policy update access denied."} {
+		if !strings.Contains(msg, expected) {
+			t.Errorf("expected error to contain %q, got %q", expected, msg)
+		}
 	}
 
-	p, err := crm.Projects.GetIamPolicy(td.Project, &cloudresourcemanager.GetIamPolicyRequest{}).Do()
-	if err != nil {
-		t.Logf("[WARNING] Unable to ensure test project bindings deleted, could not get policy: %v", err)
-		return
+	testConfigUpdate(t, b, storage, map[string]interface{}{
+		"permission_denied_template": "{{admin_email}} lacks {{permission}} on {{resource}}, see https://wiki.example.com/gcp-iam",
+	})
+	denied.setErr = &googleapi.Error{Code: http.StatusForbidden, Message: "Permission 'resourcemanager.folders.setIamPolicy' denied on resource."}
+	msg = createRoleSet()
+	if expected := "default application credentials lacks resourcemanager.folders.setIamPolicy on projects/denied, see https://wiki.example.com/gcp-iam"; !strings.HasPrefix(msg, expected) {
+		t.Errorf("expected error to start with %q, got %q", expected, msg)
 	}
+}
 
-	var changesMade bool
-	found := make(util.StringSet)
-	for idx, b := range p.Bindings {
-		if roles.Includes(b.Role) {
-			members := make([]string, 0, len(b.Members))
-			for _, m := range b.Members {
-				if memberStrs.Includes(m) {
-					changesMade = true
-					found.Add(b.Role)
-				} else {
-					members = append(members, m)
-				}
-			}
-			p.Bindings[idx].Members = members
+func TestPathRoleSet_BatchDelete(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, reqStorage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodDelete {
+			w.Write([]byte(`{}`))
+			return
 		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+
+	for _, name := range []string{"batch-a", "batch-b", "batch-leased", "other"} {
+		testStoredKeyRoleSet(t, reqStorage, name)
+	}
+	kl := &keyLease{
+		RoleSet:   "batch-leased",
+		KeyName:   "projects/my-project/serviceAccounts/vaultbatch-leased@my-project.iam.gserviceaccount.com/keys/abc123",
+		IssueTime: time.Now(),
+	}
+	if err := kl.save(ctx, reqStorage); err != nil {
+		t.Fatal(err)
 	}
 
-	if !changesMade {
-		return
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "rolesets/batch-delete",
+		Storage:   reqStorage,
+		Data: map[string]interface{}{
+			"prefix":   "batch-",
+			"interval": 0,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected batch delete response: %#v", resp)
+	}
+	if deleted, failed := resp.Data["deleted"], resp.Data["failed"]; deleted != 2 || failed != 1 {
+		t.Errorf("expected 2 deleted and 1 failed, got %v and %v", deleted, failed)
+	}
+	results := resp.Data["results"].(map[string]interface{})
+	if len(results) != 3 {
+		t.Fatalf("expected results for the 3 matching role sets, got %v", results)
+	}
+	leased := results["batch-leased"].(map[string]interface{})
+	if leased["status"] != "failed" || !strings.Contains(leased["error"].(string), "1 active leases") {
+		t.Errorf("expected batch-leased to fail on its active lease, got %v", leased)
+	}
+	for _, name := range []string{"batch-a", "batch-b"} {
+		if status := results[name].(map[string]interface{})["status"]; status != "deleted" {
+			t.Errorf("expected %s to be deleted, got %v", name, status)
+		}
+		if stored, err := getRoleSet(name, ctx, reqStorage); err != nil || stored != nil {
+			t.Errorf("expected role set %s to be deleted, got %v (err: %v)", name, stored, err)
+		}
+	}
+	for _, name := range []string{"batch-leased", "other"} {
+		if stored, err := getRoleSet(name, ctx, reqStorage); err != nil || stored == nil {
+			t.Errorf("expected role set %s to be kept (err: %v)", name, err)
+		}
 	}
 
-	t.Logf("[WARNING] had to clean up some roles (%s) for test role set %s - should have been deleted (did test fail?)",
-		strings.Join(found.ToSlice(), ","), rsName)
-	if _, err := crm.Projects.SetIamPolicy(td.Project, &cloudresourcemanager.SetIamPolicyRequest{Policy: p}).Do(); err != nil {
-		t.Logf("[WARNING] Auto-delete failed - manually remove bindings on project %s: %v", td.Project, err)
+	resp, err = b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "rolesets/batch-delete",
+		Storage:   reqStorage,
+		Data: map[string]interface{}{
+			"names":    "batch-leased,missing",
+			"force":    true,
+			"interval": 0,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results = resp.Data["results"].(map[string]interface{})
+	if status := results["batch-leased"].(map[string]interface{})["status"]; status != "deleted" {
+		t.Errorf("expected forced delete of batch-leased, got %v", results["batch-leased"])
+	}
+	if status := results["missing"].(map[string]interface{})["status"]; status != "failed" {
+		t.Errorf("expected missing role set to fail, got %v", results["missing"])
 	}
 }
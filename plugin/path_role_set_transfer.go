@@ -0,0 +1,293 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	transferredRoleSetStoragePrefix = "transferred_roleset"
+
+	// maxRoleSetTransfers bounds how many transfers are followed to find
+	// the role set a lease was issued under, in case of a cycle.
+	maxRoleSetTransfers = 32
+)
+
+// roleSetNameRegex matches role set names, as framework.GenericNameRegex
+// does in path patterns.
+var roleSetNameRegex = regexp.MustCompile(`^\w(([\w-.]+)?\w)?$`)
+
+// A roleSetTransfer records that a role set was renamed by
+// roleset/:name/transfer, so leases issued under the old name are renewed
+// and revoked with the new one. Transferred names can't be reused.
+type roleSetTransfer struct {
+	NewName      string
+	TransferTime time.Time
+}
+
+func getRoleSetTransfer(ctx context.Context, s logical.Storage, rsName string) (*roleSetTransfer, error) {
+	entry, err := s.Get(ctx, fmt.Sprintf("%s/%s", transferredRoleSetStoragePrefix, rsName))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	t := &roleSetTransfer{}
+	if err := entry.DecodeJSON(t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// secretRoleSetName returns the name of the role set a secret was issued
+// under, following any transfers since, or "" if the secret has none.
+func secretRoleSetName(ctx context.Context, s logical.Storage, secret *logical.Secret) (string, error) {
+	rsName, _ := secret.InternalData["role_set"].(string)
+	if rsName == "" {
+		return "", nil
+	}
+	for i := 0; i < maxRoleSetTransfers; i++ {
+		t, err := getRoleSetTransfer(ctx, s, rsName)
+		if err != nil {
+			return "", errwrap.Wrapf("unable to look up role set transfer: {{err}}", err)
+		}
+		if t == nil {
+			return rsName, nil
+		}
+		rsName = t.NewName
+	}
+	return "", fmt.Errorf("role set of secret was transferred more than %d times", maxRoleSetTransfers)
+}
+
+// cleanupRoleSetTransfers deletes the transfer records whose old name no
+// lease can refer to any more. Leases are issued under the old name until
+// the transfer, and Vault caps them at the mount's max lease TTL, so once
+// that long has passed since the transfer, they have all expired and the
+// name can be reused.
+func (b *backend) cleanupRoleSetTransfers(ctx context.Context, s logical.Storage) error {
+	rsNames, err := s.List(ctx, transferredRoleSetStoragePrefix+"/")
+	if err != nil {
+		return err
+	}
+
+	maxLeaseTTL := b.System().MaxLeaseTTL()
+	var merr *multierror.Error
+	for _, rsName := range rsNames {
+		t, err := getRoleSetTransfer(ctx, s, rsName)
+		if err != nil {
+			merr = multierror.Append(merr, errwrap.Wrapf(fmt.Sprintf("unable to read transfer of role set '%s': {{err}}", rsName), err))
+			continue
+		}
+		if t == nil || time.Since(t.TransferTime) < maxLeaseTTL {
+			continue
+		}
+		if err := s.Delete(ctx, fmt.Sprintf("%s/%s", transferredRoleSetStoragePrefix, rsName)); err != nil {
+			merr = multierror.Append(merr, errwrap.Wrapf(fmt.Sprintf("unable to delete transfer of role set '%s': {{err}}", rsName), err))
+			continue
+		}
+		b.Logger().Info("leases of transferred role set have expired, freed its old name", "roleset", rsName, "new_name", t.NewName)
+	}
+	return merr.ErrorOrNil()
+}
+
+func pathRoleSetTransfer(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: fmt.Sprintf("roleset/%s/transfer", framework.GenericNameRegex("name")),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role set to transfer.",
+			},
+			"new_name": {
+				Type:        framework.TypeString,
+				Description: "Name of the role set to transfer the service account, keys and leases to. Must not be in use.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathRoleSetTransfer,
+			},
+		},
+		HelpSynopsis:    pathRoleSetTransferHelpSyn,
+		HelpDescription: pathRoleSetTransferHelpDesc,
+	}
+}
+
+func (b *backend) pathRoleSetTransfer(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	rsName := d.Get("name").(string)
+	newName := d.Get("new_name").(string)
+	switch {
+	case newName == "":
+		return logical.ErrorResponse("new_name is required"), nil
+	case !roleSetNameRegex.MatchString(newName):
+		return logical.ErrorResponse(fmt.Sprintf("invalid new_name %q", newName)), nil
+	case newName == rsName:
+		return logical.ErrorResponse("new_name must differ from the role set's name"), nil
+	}
+
+	b.rolesetLock.Lock()
+	defer b.rolesetLock.Unlock()
+
+	rs, err := getRoleSet(rsName, ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if rs == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role set '%s' does not exist", rsName)), nil
+	}
+	if rs.BindingsStatus == bindingsStatusPending {
+		return logical.ErrorResponse(fmt.Sprintf("bindings of role set '%s' are still being applied, transfer it once they are", rsName)), nil
+	}
+	existing, err := getRoleSet(newName, ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return logical.ErrorResponse(fmt.Sprintf("role set '%s' already exists", newName)), nil
+	}
+	if resp, err := checkRoleSetNameReusable(ctx, req.Storage, newName); resp != nil || err != nil {
+		return resp, err
+	}
+	if rs.AccountId != nil {
+		owner, err := accountOwner(ctx, req.Storage, rs.AccountId.ResourceName(), rsName)
+		if err != nil {
+			return nil, err
+		}
+		if owner != "" {
+			return logical.ErrorResponse(fmt.Sprintf("service account %q of role set '%s' is also used by %s, refusing to transfer it", rs.AccountId.EmailOrId, rsName, owner)), nil
+		}
+	}
+
+	leases, err := listKeyLeases(ctx, req.Storage, rsName)
+	if err != nil {
+		return nil, err
+	}
+
+	// The role set is saved under its new name before anything under the
+	// old one is removed, so a failure part way leaves both, never neither.
+	rs.Name = newName
+	rs.LastModified = time.Now().UTC()
+	rs.LastModifiedBy = requestActor(req)
+	if err := rs.save(ctx, req.Storage); err != nil {
+		return nil, err
+	}
+	for _, kl := range leases {
+		keyName := kl.KeyName
+		kl.RoleSet = newName
+		if err := kl.save(ctx, req.Storage); err != nil {
+			return nil, errwrap.Wrapf("unable to move key lease: {{err}}", err)
+		}
+		if err := deleteKeyLease(ctx, req.Storage, rsName, keyName); err != nil {
+			return nil, errwrap.Wrapf("unable to move key lease: {{err}}", err)
+		}
+	}
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("%s/%s", transferredRoleSetStoragePrefix, rsName), &roleSetTransfer{
+		NewName:      newName,
+		TransferTime: time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Put(ctx, entry); err != nil {
+		return nil, err
+	}
+	if err := req.Storage.Delete(ctx, fmt.Sprintf("%s/%s", rolesetStoragePrefix, rsName)); err != nil {
+		return nil, err
+	}
+	if err := pruneIdempotencyRecords(ctx, req.Storage, rsName, true); err != nil {
+		b.Logger().Warn("unable to clean up idempotency tokens of transferred role set", "roleset", rsName, "error", err)
+	}
+	b.Logger().Info("transferred role set", "roleset", rsName, "new_name", newName, "key_leases", len(leases))
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"name":       newName,
+			"key_leases": len(leases),
+		},
+	}, nil
+}
+
+// checkRoleSetNameReusable returns an error response if a role set may not
+// be created with the name of one that no longer exists: if the name is kept
+// for the leases of a deleted role set, or of a transferred one.
+func checkRoleSetNameReusable(ctx context.Context, s logical.Storage, rsName string) (*logical.Response, error) {
+	orphan, err := getOrphanedRoleSet(ctx, s, rsName)
+	if err != nil {
+		return nil, errwrap.Wrapf("unable to check for orphaned role set: {{err}}", err)
+	}
+	if orphan != nil {
+		return logical.ErrorResponse(fmt.Sprintf("role set '%s' was deleted with key_cleanup_on_delete=%s and keys issued under it still have leases; revoke them (e.g. with sys/leases/revoke-prefix) before reusing the name", rsName, keyCleanupExpire)), nil
+	}
+
+	t, err := getRoleSetTransfer(ctx, s, rsName)
+	if err != nil {
+		return nil, errwrap.Wrapf("unable to check for role set transfer: {{err}}", err)
+	}
+	if t != nil {
+		return logical.ErrorResponse(fmt.Sprintf("role set '%s' was transferred to '%s', and leases issued under the old name are resolved to it, so the name can't be reused", rsName, t.NewName)), nil
+	}
+	return nil, nil
+}
+
+// accountOwner returns a description of the role sets other than rsName,
+// live or orphaned, that use the service account with the given resource
+// name, or "" if none do.
+func accountOwner(ctx context.Context, s logical.Storage, accountName, rsName string) (string, error) {
+	var owners []string
+	for _, prefix := range []string{rolesetStoragePrefix, orphanedRoleSetStoragePrefix} {
+		names, err := s.List(ctx, prefix+"/")
+		if err != nil {
+			return "", err
+		}
+		for _, name := range names {
+			if prefix == rolesetStoragePrefix && name == rsName {
+				continue
+			}
+			var other *RoleSet
+			if prefix == rolesetStoragePrefix {
+				other, err = getRoleSet(name, ctx, s)
+			} else {
+				other, err = getOrphanedRoleSet(ctx, s, name)
+			}
+			if err != nil {
+				return "", err
+			}
+			if other != nil && other.AccountId != nil && other.AccountId.ResourceName() == accountName {
+				kind := "role set"
+				if prefix == orphanedRoleSetStoragePrefix {
+					kind = "deleted role set"
+				}
+				owners = append(owners, fmt.Sprintf("%s '%s'", kind, name))
+			}
+		}
+	}
+	return strings.Join(owners, ", "), nil
+}
+
+const pathRoleSetTransferHelpSyn = `Rename a role set, keeping its service account, keys and leases.`
+const pathRoleSetTransferHelpDesc = `
+This path moves a role set to "new_name" without touching GCP: its service
+account, bindings, token creators and access token key stay as they are,
+and the keys issued under it are tracked under the new name. Leases issued
+under the old name keep working; they are renewed and revoked with the
+role set under its new name.
+
+The new name must not be in use, and the service account must not also be
+used by another role set, such as one imported with the same account.
+Role sets whose bindings are still being applied in the background can't
+be transferred until they are.
+
+Because outstanding leases refer to the old name, it can't be used for a
+new role set until they have all expired. The old name is freed in the
+background once the mount's max lease TTL has passed since the transfer.
+`
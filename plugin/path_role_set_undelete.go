@@ -0,0 +1,195 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-gcp-common/gcputil"
+	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/iamutil"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/useragent"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const deletedRoleSetStoragePrefix = "deleted_roleset"
+
+// A deleted role set is one deleted while sa_deletion_delay was set. Its
+// bindings are removed, but its service account is kept until DeleteAfter,
+// and the role set is kept under deletedRoleSetStoragePrefix until then so
+// it can be undeleted.
+type deletedRoleSet struct {
+	RoleSet     *RoleSet
+	DeleteAfter time.Time
+}
+
+func saveDeletedRoleSet(ctx context.Context, s logical.Storage, drs *deletedRoleSet) error {
+	entry, err := logical.StorageEntryJSON(fmt.Sprintf("%s/%s", deletedRoleSetStoragePrefix, drs.RoleSet.Name), drs)
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+func getDeletedRoleSet(ctx context.Context, s logical.Storage, rsName string) (*deletedRoleSet, error) {
+	entry, err := s.Get(ctx, fmt.Sprintf("%s/%s", deletedRoleSetStoragePrefix, rsName))
+	if err != nil {
+		return nil, err
+	}
+	if entry == nil {
+		return nil, nil
+	}
+
+	drs := &deletedRoleSet{}
+	if err := entry.DecodeJSON(drs); err != nil {
+		return nil, err
+	}
+	return drs, nil
+}
+
+// deleteDeletedRoleSet forgets the deleted role set with the given name if it
+// has the given service account, once the account is removed. The name may
+// have been reused and deleted again since, with another account.
+func deleteDeletedRoleSet(ctx context.Context, s logical.Storage, rsName string, account *gcputil.ServiceAccountId) error {
+	drs, err := getDeletedRoleSet(ctx, s, rsName)
+	if err != nil || drs == nil {
+		return err
+	}
+	if drs.RoleSet.AccountId == nil || drs.RoleSet.AccountId.ResourceName() != account.ResourceName() {
+		return nil
+	}
+	return s.Delete(ctx, fmt.Sprintf("%s/%s", deletedRoleSetStoragePrefix, rsName))
+}
+
+func pathRoleSetUndelete(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: fmt.Sprintf("undelete-roleset/%s", framework.GenericNameRegex("name")),
+		Fields: map[string]*framework.FieldSchema{
+			"name": {
+				Type:        framework.TypeString,
+				Description: "Name of the deleted role set.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathRoleSetUndeleteRead,
+			},
+			logical.UpdateOperation: &framework.PathOperation{
+				Callback: b.pathRoleSetUndeleteWrite,
+			},
+		},
+		HelpSynopsis:    pathRoleSetUndeleteHelpSyn,
+		HelpDescription: pathRoleSetUndeleteHelpDesc,
+	}
+}
+
+func (b *backend) pathRoleSetUndeleteRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	drs, err := getDeletedRoleSet(ctx, req.Storage, d.Get("name").(string))
+	if err != nil {
+		return nil, err
+	}
+	if drs == nil {
+		return nil, nil
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"service_account_email": drs.RoleSet.AccountId.EmailOrId,
+			"delete_after":          drs.DeleteAfter.Format(time.RFC3339),
+		},
+	}, nil
+}
+
+func (b *backend) pathRoleSetUndeleteWrite(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	rsName := d.Get("name").(string)
+
+	b.rolesetLock.Lock()
+	defer b.rolesetLock.Unlock()
+
+	drs, err := getDeletedRoleSet(ctx, req.Storage, rsName)
+	if err != nil {
+		return nil, err
+	}
+	if drs == nil {
+		return logical.ErrorResponse(fmt.Sprintf("no deleted role set '%s' is waiting for its service account to be removed", rsName)), nil
+	}
+	existing, err := getRoleSet(rsName, ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		return logical.ErrorResponse(fmt.Sprintf("role set '%s' was created again since it was deleted; delete it before undeleting the previous one", rsName)), nil
+	}
+
+	rs := drs.RoleSet
+	iamAdmin, err := b.IAMAdminClient(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := iamAdmin.Projects.ServiceAccounts.Get(rs.AccountId.ResourceName()).Context(ctx).Do(); err != nil {
+		if !isGoogleAccountNotFoundErr(err) {
+			return nil, errwrap.Wrapf("unable to look up service account: {{err}}", err)
+		}
+		if err := deleteDeletedRoleSet(ctx, req.Storage, rsName, rs.AccountId); err != nil {
+			return nil, err
+		}
+		return logical.ErrorResponse(fmt.Sprintf("service account %q of role set '%s' no longer exists, the role set can't be undeleted", rs.AccountId.EmailOrId, rsName)), nil
+	}
+
+	httpC, err := b.HTTPClient(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	apiHandle := iamutil.GetApiHandle(httpC, useragent.String())
+
+	// The bindings were removed on deletion, so they are applied as new.
+	// Until the role set is saved, their WAL entries remove them again on
+	// failure.
+	rs.AddedMembers = nil
+	walIds, err := rs.updateIamPolicies(ctx, req.Storage, b.resources, apiHandle, rs.Bindings, b.maxBindingRetries(ctx, req.Storage), nil)
+	b.usage.record(rs.Name, usageBindingApplies, len(walIds))
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("unable to restore bindings of role set '%s': %v", rsName, b.withGoogleRequestID(req.Path, b.explainPermissionDenied(ctx, req.Storage, err)))), nil
+	}
+	if err := updateTokenCreators(ctx, iamAdmin, rs.AccountId, rs.TokenCreators, nil); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("unable to restore token creators of role set '%s': %v", rsName, b.withGoogleRequestID(req.Path, err))), nil
+	}
+
+	now := time.Now().UTC()
+	rs.BindingsLastApplied = now
+	rs.BindingsStatus = bindingsStatusApplied
+	rs.BindingsError = ""
+	rs.LastModified = now
+	rs.LastModifiedBy = requestActor(req)
+	if err := rs.save(ctx, req.Storage); err != nil {
+		return nil, err
+	}
+	tryDeleteWALs(ctx, req.Storage, walIds...)
+
+	// The WAL entry removing the service account finds it in use again and
+	// is dropped on its next rollback.
+	if err := req.Storage.Delete(ctx, fmt.Sprintf("%s/%s", deletedRoleSetStoragePrefix, rsName)); err != nil {
+		return nil, err
+	}
+	b.Logger().Info("undeleted role set", "roleset", rsName, "service_account", rs.AccountId.EmailOrId)
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"service_account_email": rs.AccountId.EmailOrId,
+		},
+	}, nil
+}
+
+const pathRoleSetUndeleteHelpSyn = `Restore a role set deleted while sa_deletion_delay was set.`
+const pathRoleSetUndeleteHelpDesc = `
+With the config's "sa_deletion_delay" set, deleting a role set removes its
+bindings immediately but keeps its service account until the delay passes.
+Until then, reading this path returns the account's email and when it will
+be removed ("delete_after"), and writing to it restores the role set with
+the same service account: its bindings and token creators are granted
+again, and access token role sets keep generating tokens with the same key.
+Keys leased before the deletion aren't restored.
+
+Undeleting fails if a role set with the same name was created since, or if
+the service account no longer exists.
+`
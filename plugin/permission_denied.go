@@ -0,0 +1,156 @@
+package gcpsecrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-gcp-common/gcputil"
+	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/iamutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// Variables of permission_denied_template.
+	permissionDeniedVarAdminEmail = "admin_email"
+	permissionDeniedVarResource   = "resource"
+	permissionDeniedVarPermission = "permission"
+	permissionDeniedVarError      = "error"
+
+	defaultPermissionDeniedTemplate = "the backend's credentials ({{admin_email}}) are not allowed to change the IAM policy of {{resource}}: grant them a role with {{permission}} on the resource, or on its project, folder or organization. GCP error: {{error}}"
+)
+
+// setIamPolicyPermissionRegex matches the IAM policy permission a GCP error
+// message says is missing, such as "resourcemanager.projects.setIamPolicy".
+var setIamPolicyPermissionRegex = regexp.MustCompile(`\b[a-z][a-zA-Z0-9]*(?:\.[a-zA-Z0-9]+)+\.(?:set|get)IamPolicy\b`)
+
+// setIamPolicyError is an error changing the IAM policy of a bound resource.
+// Its message is the cause's; it only records the resource, so that
+// explainPermissionDenied can name it.
+type setIamPolicyError struct {
+	rName    string
+	resource iamutil.Resource
+	err      error
+}
+
+func (e *setIamPolicyError) Error() string {
+	return e.err.Error()
+}
+
+// newSetIamPolicyError wraps an error changing the IAM policy of the named
+// resource. The wrapped error can still be retrieved with errwrap.GetType.
+func newSetIamPolicyError(rName string, resource iamutil.Resource, err error) error {
+	return errwrap.Wrap(&setIamPolicyError{
+		rName:    rName,
+		resource: resource,
+		err:      err,
+	}, err)
+}
+
+// setIamPolicyPermission returns the permission needed to set the IAM policy
+// of the resource, derived from its service and type, e.g.
+// "storage.buckets.setIamPolicy".
+func setIamPolicyPermission(resource iamutil.Resource) string {
+	config := resource.GetConfig()
+	if config == nil || config.TypeKey == "" {
+		return "the setIamPolicy permission"
+	}
+	service := config.Service
+	if service == "cloudresourcemanager" {
+		service = "resourcemanager"
+	}
+	collections := strings.Split(config.TypeKey, "/")
+	return fmt.Sprintf("%s.%s.setIamPolicy", strings.TrimSuffix(service, "admin"), collections[len(collections)-1])
+}
+
+// explainPermissionDenied replaces the message of a 403 from GCP when
+// changing the IAM policy of a bound resource with the config's
+// permission_denied_template, naming the backend's service account, the
+// resource and the missing permission. The permission is taken from the GCP
+// error message if it names one. Other errors are returned as is.
+func (b *backend) explainPermissionDenied(ctx context.Context, s logical.Storage, err error) error {
+	spErr, ok := errwrap.GetType(err, &setIamPolicyError{}).(*setIamPolicyError)
+	if !ok || spErr == nil {
+		return err
+	}
+	gErr, ok := errwrap.GetType(err, &googleapi.Error{}).(*googleapi.Error)
+	if !ok || gErr == nil || gErr.Code != http.StatusForbidden {
+		return err
+	}
+
+	cfg, cfgErr := getConfig(ctx, s)
+	if cfgErr != nil {
+		b.Logger().Warn("unable to read config, using the default permission_denied_template", "error", cfgErr)
+	}
+
+	permission := setIamPolicyPermission(spErr.resource)
+	if p := setIamPolicyPermissionRegex.FindString(gErr.Message); p != "" {
+		permission = p
+	}
+	msg, renderErr := renderPermissionDenied(cfg.permissionDeniedTemplate(), map[string]string{
+		permissionDeniedVarAdminEmail: cfg.adminClientEmail(),
+		permissionDeniedVarResource:   spErr.rName,
+		permissionDeniedVarPermission: permission,
+		permissionDeniedVarError:      err.Error(),
+	})
+	if renderErr != nil {
+		return err
+	}
+	return errwrap.Wrap(errors.New(msg), err)
+}
+
+// validatePermissionDeniedTemplate checks that the template only uses known
+// variables.
+func validatePermissionDeniedTemplate(tmpl string) error {
+	_, err := renderPermissionDenied(tmpl, map[string]string{
+		permissionDeniedVarAdminEmail: "",
+		permissionDeniedVarResource:   "",
+		permissionDeniedVarPermission: "",
+		permissionDeniedVarError:      "",
+	})
+	return err
+}
+
+// renderPermissionDenied renders a permission_denied_template, replacing
+// each {{name}} with the value of vars[name].
+func renderPermissionDenied(tmpl string, vars map[string]string) (string, error) {
+	var unknown []string
+	msg := saNameTemplateVarRegex.ReplaceAllStringFunc(tmpl, func(v string) string {
+		value, ok := vars[saNameTemplateVarRegex.FindStringSubmatch(v)[1]]
+		if !ok {
+			unknown = append(unknown, v)
+		}
+		return value
+	})
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("permission_denied_template has unknown variables %s, must be one of {{%s}}, {{%s}}, {{%s}} or {{%s}}",
+			strings.Join(unknown, ", "), permissionDeniedVarAdminEmail, permissionDeniedVarResource, permissionDeniedVarPermission, permissionDeniedVarError)
+	}
+	return msg, nil
+}
+
+// permissionDeniedTemplate returns the template of errors for 403s changing
+// IAM policies.
+func (c *config) permissionDeniedTemplate() string {
+	if c == nil || c.PermissionDeniedTemplate == "" {
+		return defaultPermissionDeniedTemplate
+	}
+	return c.PermissionDeniedTemplate
+}
+
+// adminClientEmail returns the email of the backend's credentials, or a
+// description of them if they have none, such as default application
+// credentials on GCE.
+func (c *config) adminClientEmail() string {
+	if c != nil && c.CredentialsRaw != "" {
+		if creds, err := gcputil.Credentials(c.CredentialsRaw); err == nil && creds.ClientEmail != "" {
+			return creds.ClientEmail
+		}
+	}
+	return "default application credentials"
+}
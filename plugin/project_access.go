@@ -0,0 +1,50 @@
+package gcpsecrets
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/util"
+)
+
+// projectIdRegex matches GCP project IDs.
+var projectIdRegex = regexp.MustCompile(`^[a-z][-a-z0-9]{4,28}[a-z0-9]$`)
+
+// validateProjectList checks that every entry of a project allow- or
+// denylist is a well-formed project ID.
+func validateProjectList(projects []string) error {
+	var invalid []string
+	for _, p := range projects {
+		if !projectIdRegex.MatchString(p) {
+			invalid = append(invalid, fmt.Sprintf("%q", p))
+		}
+	}
+	if len(invalid) > 0 {
+		return fmt.Errorf("invalid project IDs %s", strings.Join(invalid, ", "))
+	}
+	return nil
+}
+
+// checkProjectAccess returns an error naming the projects a role set may not
+// use under the configured project_denylist and project_allowlist, given its
+// service account's project and its bindings. Bound resources outside of a
+// project, like folders and organizations, are not checked.
+func (c *config) checkProjectAccess(project string, bindings ResourceBindings) error {
+	if c == nil || (len(c.ProjectDenylist) == 0 && len(c.ProjectAllowlist) == 0) {
+		return nil
+	}
+
+	denied := util.ToSet(c.ProjectDenylist)
+	allowed := util.ToSet(c.ProjectAllowlist)
+	var blocked []string
+	for _, p := range policyProjects(project, bindings) {
+		if denied.Includes(p) || (len(allowed) > 0 && !allowed.Includes(p)) {
+			blocked = append(blocked, p)
+		}
+	}
+	if len(blocked) > 0 {
+		return fmt.Errorf("role set uses projects this backend may not manage (project_denylist/project_allowlist): %s", strings.Join(blocked, ", "))
+	}
+	return nil
+}
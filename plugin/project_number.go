@@ -0,0 +1,38 @@
+package gcpsecrets
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// projectNumber returns the number of the project with the given ID. Numbers
+// are looked up with the Resource Manager API once and then cached.
+func (b *backend) projectNumber(ctx context.Context, s logical.Storage, project string) (string, error) {
+	b.projectNumbersLock.RLock()
+	number, ok := b.projectNumbers[project]
+	b.projectNumbersLock.RUnlock()
+	if ok {
+		return number, nil
+	}
+
+	crmC, err := b.ResourceManagerClient(s)
+	if err != nil {
+		return "", err
+	}
+	p, err := crmC.Projects.Get(project).Context(ctx).Do()
+	if err != nil {
+		return "", errwrap.Wrapf("unable to get project: {{err}}", b.withGoogleRequestID("projects/"+project, err))
+	}
+	number = strconv.FormatInt(p.ProjectNumber, 10)
+
+	b.projectNumbersLock.Lock()
+	defer b.projectNumbersLock.Unlock()
+	if b.projectNumbers == nil {
+		b.projectNumbers = make(map[string]string)
+	}
+	b.projectNumbers[project] = number
+	return number, nil
+}
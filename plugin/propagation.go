@@ -0,0 +1,170 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/iamutil"
+	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/util"
+	"github.com/hashicorp/vault/sdk/helper/useragent"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/iam/v1"
+)
+
+const (
+	// propagationWaitTimeout bounds how long token/:roleset waits for the
+	// role set's permissions to take effect with wait_for_propagation.
+	propagationWaitTimeout = 2 * time.Minute
+
+	// maxTestedPermissions is the most permissions testIamPermissions
+	// accepts in one call.
+	maxTestedPermissions = 100
+)
+
+// waitForPropagation calls testIamPermissions as the role set's service
+// account on each of its bound resources, with backoff, until it has the
+// permissions of the roles bound there or propagationWaitTimeout passes.
+// The token has already been issued, so the permissions that are still
+// missing then, or that couldn't be checked, are returned as a warning
+// rather than an error.
+func (b *backend) waitForPropagation(ctx context.Context, s logical.Storage, rs *RoleSet) string {
+	iamC, err := b.IAMAdminClient(s)
+	if err != nil {
+		return fmt.Sprintf("unable to wait for permissions to propagate: %v", err)
+	}
+
+	var problems []string
+	resources := make(map[string]iamutil.Resource)
+	pending := make(map[string][]string)
+	rolePerms := make(map[string][]string)
+	for resName, roles := range rs.Bindings {
+		resource, err := b.resources.Parse(resName)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("unable to parse resource %q: %v", resName, err))
+			continue
+		}
+
+		var perms []string
+		for role := range roles {
+			if _, ok := rolePerms[role]; !ok {
+				if rolePerms[role], err = rolePermissions(ctx, iamC, role); err != nil {
+					problems = append(problems, fmt.Sprintf("unable to get the permissions of %s: %v", role, err))
+				}
+			}
+			perms = append(perms, rolePerms[role]...)
+		}
+		if perms = testablePermissions(resource, perms); len(perms) > 0 {
+			resources[resName] = resource
+			pending[resName] = perms
+		}
+	}
+	if len(pending) == 0 {
+		return propagationWarning(problems)
+	}
+
+	token, err := b.roleSetAccessToken(ctx, s, rs)
+	if err != nil {
+		return fmt.Sprintf("unable to wait for permissions to propagate, could not get a token for the service account: %v", err)
+	}
+	proxyCtx, err := proxyContext(ctx, s)
+	if err != nil {
+		return fmt.Sprintf("unable to wait for permissions to propagate: %v", err)
+	}
+	apiHandle := iamutil.GetApiHandle(oauth2.NewClient(proxyCtx, oauth2.StaticTokenSource(token)), useragent.String())
+
+	deadline := time.Now().Add(propagationWaitTimeout)
+	for attempt := 0; ; attempt++ {
+		for resName, perms := range pending {
+			granted, err := apiHandle.DoTestPermissionsRequest(ctx, resources[resName], perms)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("unable to test permissions on %q: %v", resName, err))
+				delete(pending, resName)
+				continue
+			}
+			if missing := util.ToSet(perms).Sub(util.ToSet(granted)).ToSlice(); len(missing) > 0 {
+				sort.Strings(missing)
+				pending[resName] = missing
+			} else {
+				delete(pending, resName)
+			}
+		}
+		if len(pending) == 0 {
+			return propagationWarning(problems)
+		}
+
+		delay := retryDelay(nil, attempt)
+		if time.Now().Add(delay).After(deadline) {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return propagationWarning(append(problems, fmt.Sprintf("stopped waiting: %v", ctx.Err())))
+		case <-time.After(delay):
+		}
+	}
+
+	for resName, missing := range pending {
+		problems = append(problems, fmt.Sprintf("%s still missing on %q after %s", strings.Join(missing, ", "), resName, propagationWaitTimeout))
+	}
+	return propagationWarning(problems)
+}
+
+// propagationWarning returns the warning reporting the given problems, if any.
+func propagationWarning(problems []string) string {
+	if len(problems) == 0 {
+		return ""
+	}
+	sort.Strings(problems)
+	return fmt.Sprintf("permissions of the role set may not have propagated yet, the token may not work right away: %s", strings.Join(problems, "; "))
+}
+
+// rolePermissions returns the permissions of a predefined or custom role.
+func rolePermissions(ctx context.Context, iamC *iam.Service, role string) ([]string, error) {
+	var r *iam.Role
+	var err error
+	switch {
+	case strings.HasPrefix(role, "projects/"):
+		r, err = iamC.Projects.Roles.Get(role).Context(ctx).Do()
+	case strings.HasPrefix(role, "organizations/"):
+		r, err = iamC.Organizations.Roles.Get(role).Context(ctx).Do()
+	default:
+		r, err = iamC.Roles.Get(role).Context(ctx).Do()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.IncludedPermissions, nil
+}
+
+// testablePermissions returns the permissions, sorted and at most
+// maxTestedPermissions of them, that can be tested on the resource.
+// Projects, folders and organizations accept any permission, since they
+// contain the resources permissions are for, but other resources only
+// accept the permissions of their own service.
+func testablePermissions(resource iamutil.Resource, permissions []string) []string {
+	config := resource.GetConfig()
+	if config == nil {
+		return nil
+	}
+	var prefix string
+	if config.Service != "cloudresourcemanager" {
+		prefix = strings.TrimSuffix(config.Service, "admin") + "."
+	}
+
+	set := make(util.StringSet)
+	for _, perm := range permissions {
+		if strings.HasPrefix(perm, prefix) {
+			set.Add(perm)
+		}
+	}
+	testable := set.ToSlice()
+	sort.Strings(testable)
+	if len(testable) > maxTestedPermissions {
+		testable = testable[:maxTestedPermissions]
+	}
+	return testable
+}
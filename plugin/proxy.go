@@ -0,0 +1,78 @@
+package gcpsecrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
+)
+
+// validateProxyURL checks that a configured proxy is an absolute URL with a
+// scheme Go's HTTP transport supports. Empty means no proxy.
+func validateProxyURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("proxy URL %q must have an http, https or socks5 scheme", raw)
+	}
+	if u.Host == "" {
+		return errors.New("proxy URL must include a host")
+	}
+	return nil
+}
+
+// proxy returns the function choosing the proxy for each request to GCP:
+// the configured proxies if any are set, or else the environment's.
+func (c *config) proxy() func(*http.Request) (*url.URL, error) {
+	if c == nil || (c.HTTPProxy == "" && c.HTTPSProxy == "") {
+		return http.ProxyFromEnvironment
+	}
+
+	// Both were validated when configured.
+	httpProxy, _ := url.Parse(c.HTTPProxy)
+	httpsProxy := httpProxy
+	if c.HTTPSProxy != "" {
+		httpsProxy, _ = url.Parse(c.HTTPSProxy)
+	}
+	return func(req *http.Request) (*url.URL, error) {
+		if req.URL.Scheme == "https" {
+			return httpsProxy, nil
+		}
+		if c.HTTPProxy == "" {
+			return nil, nil
+		}
+		return httpProxy, nil
+	}
+}
+
+// baseHTTPClient returns a client for requests to GCP, without credentials,
+// that goes through the configured proxy.
+func (c *config) baseHTTPClient() *http.Client {
+	client := cleanhttp.DefaultClient()
+	client.Transport.(*http.Transport).Proxy = c.proxy()
+	return client
+}
+
+// proxyContext returns ctx with the config's base HTTP client set as the
+// oauth2 HTTP client, so the tokens generated and the oauth2 clients created
+// with it go through the configured proxy.
+func proxyContext(ctx context.Context, s logical.Storage) (context.Context, error) {
+	cfg, err := getConfig(ctx, s)
+	if err != nil {
+		return nil, errwrap.Wrapf("could not read backend config: {{err}}", err)
+	}
+	return context.WithValue(ctx, oauth2.HTTPClient, cfg.baseHTTPClient()), nil
+}
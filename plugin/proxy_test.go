@@ -0,0 +1,114 @@
+package gcpsecrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestConfigProxy(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		cfg           *config
+		expectedHTTP  string
+		expectedHTTPS string
+	}{
+		"both": {
+			cfg:           &config{HTTPProxy: "http://proxy:3128", HTTPSProxy: "https://secure-proxy:3129"},
+			expectedHTTP:  "http://proxy:3128",
+			expectedHTTPS: "https://secure-proxy:3129",
+		},
+		"http only": {
+			cfg:           &config{HTTPProxy: "http://proxy:3128"},
+			expectedHTTP:  "http://proxy:3128",
+			expectedHTTPS: "http://proxy:3128",
+		},
+		"https only": {
+			cfg:           &config{HTTPSProxy: "socks5://proxy:1080"},
+			expectedHTTPS: "socks5://proxy:1080",
+		},
+	}
+	for name, tc := range cases {
+		proxy := tc.cfg.baseHTTPClient().Transport.(*http.Transport).Proxy
+		for scheme, expected := range map[string]string{"http": tc.expectedHTTP, "https": tc.expectedHTTPS} {
+			req, err := http.NewRequest(http.MethodGet, scheme+"://iam.googleapis.com/v1/projects", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			u, err := proxy(req)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			actual := ""
+			if u != nil {
+				actual = u.String()
+			}
+			if actual != expected {
+				t.Errorf("%s: expected %s proxy %q, got %q", name, scheme, expected, actual)
+			}
+		}
+	}
+}
+
+func TestSecrets_AccessTokenThroughProxy(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "proxied-token", "token_type": "Bearer", "expires_in": 3600}`))
+	}))
+	defer proxy.Close()
+
+	// The token endpoint is only reachable through the proxy.
+	var creds map[string]string
+	keyJSON, err := base64.StdEncoding.DecodeString(testKeyMaterial(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(keyJSON, &creds); err != nil {
+		t.Fatal(err)
+	}
+	creds["token_uri"] = "http://oauth2.invalid/token"
+	if keyJSON, err = json.Marshal(creds); err != nil {
+		t.Fatal(err)
+	}
+
+	b, storage := getTestBackend(t)
+	testConfigUpdate(t, b, storage, map[string]interface{}{
+		"http_proxy": proxy.URL,
+	})
+	rs := testStoredKeyRoleSet(t, storage, "test-proxy")
+	rs.SecretType = SecretTypeAccessToken
+	rs.TokenGen = &TokenGenerator{
+		KeyName:    rs.AccountId.ResourceName() + "/keys/key1",
+		B64KeyJSON: base64.StdEncoding.EncodeToString(keyJSON),
+		Scopes:     []string{cloudPlatformScope},
+	}
+	if err := rs.save(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "token/" + rs.Name,
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() || resp.Data["token"] != "proxied-token" {
+		t.Fatalf("expected the token to be generated through the proxy, got %#v", resp)
+	}
+	if len(proxied) != 1 || proxied[0] != creds["token_uri"] {
+		t.Errorf("expected the token request to go through the proxy, got %v", proxied)
+	}
+}
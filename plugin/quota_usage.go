@@ -0,0 +1,170 @@
+package gcpsecrets
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// quotaUsageBuckets is how many one minute buckets GCP calls are counted
+	// in, which is the rolling window config/quota-usage reports on.
+	quotaUsageBuckets = 60
+	quotaUsageWindow  = quotaUsageBuckets * time.Minute
+
+	// Kinds of GCP calls counted per role set.
+	usageTokenGenerations = "token_generations"
+	usageKeyCreations     = "key_creations"
+	usageBindingApplies   = "binding_applies"
+)
+
+var usageKinds = []string{usageTokenGenerations, usageKeyCreations, usageBindingApplies}
+
+// usageCounter counts calls in per-minute buckets over quotaUsageWindow.
+type usageCounter struct {
+	minutes [quotaUsageBuckets]int64
+	counts  [quotaUsageBuckets]int
+	last    time.Time
+}
+
+func (c *usageCounter) add(now time.Time, n int) {
+	m := now.Unix() / 60
+	i := m % quotaUsageBuckets
+	if c.minutes[i] != m {
+		c.minutes[i] = m
+		c.counts[i] = 0
+	}
+	c.counts[i] += n
+	c.last = now
+}
+
+// count returns the calls counted in the window ending at now.
+func (c *usageCounter) count(now time.Time) int {
+	m := now.Unix() / 60
+	total := 0
+	for i, minute := range c.minutes {
+		if minute > m-quotaUsageBuckets && minute <= m {
+			total += c.counts[i]
+		}
+	}
+	return total
+}
+
+// quotaUsage counts the GCP calls made for each role set, so usage can be
+// compared to GCP's per-project quotas. Counts are kept in memory only.
+type quotaUsage struct {
+	l        sync.Mutex
+	counters map[string]map[string]*usageCounter
+}
+
+func newQuotaUsage() *quotaUsage {
+	return &quotaUsage{
+		counters: make(map[string]map[string]*usageCounter),
+	}
+}
+
+// record counts n calls of the given kind for the role set.
+func (u *quotaUsage) record(rsName, kind string, n int) {
+	if n <= 0 {
+		return
+	}
+	u.l.Lock()
+	defer u.l.Unlock()
+
+	byKind, ok := u.counters[rsName]
+	if !ok {
+		byKind = make(map[string]*usageCounter, len(usageKinds))
+		u.counters[rsName] = byKind
+	}
+	c, ok := byKind[kind]
+	if !ok {
+		c = &usageCounter{}
+		byKind[kind] = c
+	}
+	c.add(time.Now(), n)
+}
+
+// report returns the calls of each kind per role set in the window ending at
+// now, with their rate per minute, and the totals across role sets. Role sets
+// with no calls in the window are dropped.
+func (u *quotaUsage) report(now time.Time) (map[string]interface{}, map[string]int) {
+	u.l.Lock()
+	defer u.l.Unlock()
+
+	rolesets := make(map[string]interface{}, len(u.counters))
+	totals := make(map[string]int, len(usageKinds))
+	for _, kind := range usageKinds {
+		totals[kind] = 0
+	}
+	for rsName, byKind := range u.counters {
+		usage := make(map[string]interface{}, len(byKind))
+		for kind, c := range byKind {
+			n := c.count(now)
+			if n == 0 {
+				continue
+			}
+			totals[kind] += n
+			usage[kind] = map[string]interface{}{
+				"count":      n,
+				"per_minute": float64(n) / quotaUsageWindow.Minutes(),
+				"last_call":  c.last.UTC().Format(time.RFC3339),
+			}
+		}
+		if len(usage) == 0 {
+			delete(u.counters, rsName)
+			continue
+		}
+		rolesets[rsName] = usage
+	}
+	return rolesets, totals
+}
+
+func pathConfigQuotaUsage(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "config/quota-usage",
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{
+				Callback: b.pathConfigQuotaUsageRead,
+			},
+		},
+
+		HelpSynopsis:    pathConfigQuotaUsageHelpSyn,
+		HelpDescription: pathConfigQuotaUsageHelpDesc,
+	}
+}
+
+func (b *backend) pathConfigQuotaUsageRead(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	rolesets, totals := b.usage.report(time.Now())
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"window":   int64(quotaUsageWindow / time.Second),
+			"rolesets": rolesets,
+			"totals":   totals,
+		},
+	}, nil
+}
+
+const pathConfigQuotaUsageHelpSyn = `
+Report GCP calls made for each role set over the last hour
+`
+
+const pathConfigQuotaUsageHelpDesc = `
+This path reports how many quota-consuming GCP calls were made for each role
+set over a rolling window of the last hour ("window", in seconds), to plan
+against GCP's per-project quotas:
+
+	token_generations  access tokens generated, not counting cached tokens
+	key_creations      service account keys created, including the keys
+	                   access token role sets generate tokens with
+	binding_applies    IAM policies updated to add role set bindings
+
+For each, "count" is the number of calls in the window, "per_minute" their
+average rate over it, and "last_call" the time of the latest. "totals" sums
+the counts across role sets.
+
+Counts are kept in memory by each Vault node for the calls it made, and are
+reset when the plugin restarts.
+`
@@ -0,0 +1,82 @@
+package gcpsecrets
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestUsageCounter(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	c := &usageCounter{}
+	c.add(start, 1)
+	c.add(start.Add(30*time.Second), 2)
+	c.add(start.Add(30*time.Minute), 1)
+
+	cases := map[string]struct {
+		now      time.Time
+		expected int
+	}{
+		"same minute":          {start.Add(45 * time.Second), 3},
+		"within window":        {start.Add(45 * time.Minute), 4},
+		"first minute expired": {start.Add(quotaUsageWindow), 1},
+		"all expired":          {start.Add(30*time.Minute + quotaUsageWindow), 0},
+	}
+	for name, tc := range cases {
+		if actual := c.count(tc.now); actual != tc.expected {
+			t.Errorf("%s: expected %d calls, got %d", name, tc.expected, actual)
+		}
+	}
+
+	// A bucket reused for a later minute starts over.
+	c.add(start.Add(quotaUsageWindow), 5)
+	if actual := c.count(start.Add(quotaUsageWindow)); actual != 6 {
+		t.Errorf("expected 6 calls after reusing a bucket, got %d", actual)
+	}
+}
+
+func TestConfigQuotaUsage(t *testing.T) {
+	t.Parallel()
+
+	b, storage := getTestBackend(t)
+	gb := b.(*backend)
+	gb.usage.record("rs1", usageTokenGenerations, 3)
+	gb.usage.record("rs1", usageKeyCreations, 1)
+	gb.usage.record("rs2", usageBindingApplies, 2)
+	gb.usage.record("rs2", usageKeyCreations, 0)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "config/quota-usage",
+		Storage:   storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+
+	totals := resp.Data["totals"].(map[string]int)
+	if totals[usageTokenGenerations] != 3 || totals[usageKeyCreations] != 1 || totals[usageBindingApplies] != 2 {
+		t.Fatalf("unexpected totals %v", totals)
+	}
+	rolesets := resp.Data["rolesets"].(map[string]interface{})
+	tokens := rolesets["rs1"].(map[string]interface{})[usageTokenGenerations].(map[string]interface{})
+	if tokens["count"] != 3 || tokens["per_minute"] != 3.0/60 {
+		t.Fatalf("unexpected token generation usage %v", tokens)
+	}
+	if _, ok := rolesets["rs2"].(map[string]interface{})[usageKeyCreations]; ok {
+		t.Fatalf("expected kinds without calls to be left out, got %v", rolesets["rs2"])
+	}
+
+	// Role sets without calls in the window are dropped.
+	gb.usage.report(time.Now().Add(2 * quotaUsageWindow))
+	if rolesets, _ := gb.usage.report(time.Now()); len(rolesets) != 0 {
+		t.Fatalf("expected role sets without recent calls to be dropped, got %v", rolesets)
+	}
+}
@@ -0,0 +1,119 @@
+package gcpsecrets
+
+import (
+	"context"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// minUntrackedKeyMaxAge is the lowest untracked_key_max_age accepted when
+// reaping is enabled, so keys being issued, which are briefly untracked
+// until their lease is saved, are never deleted.
+const minUntrackedKeyMaxAge = time.Hour
+
+// keyLeaseTrackingStorageKey holds when this backend started tracking key
+// leases. Keys created before then may have been issued by Vault without
+// being tracked, so they are never reaped.
+const keyLeaseTrackingStorageKey = "key_lease_tracking"
+
+type keyLeaseTracking struct {
+	StartTime time.Time
+}
+
+// keyLeaseTrackingStart returns when the backend started tracking key
+// leases, recording the current time if it wasn't recorded yet, which is
+// the earliest time it can be sure of.
+func keyLeaseTrackingStart(ctx context.Context, s logical.Storage) (time.Time, error) {
+	entry, err := s.Get(ctx, keyLeaseTrackingStorageKey)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if entry != nil {
+		tracking := &keyLeaseTracking{}
+		if err := entry.DecodeJSON(tracking); err != nil {
+			return time.Time{}, err
+		}
+		return tracking.StartTime, nil
+	}
+
+	tracking := &keyLeaseTracking{StartTime: time.Now().UTC()}
+	if entry, err = logical.StorageEntryJSON(keyLeaseTrackingStorageKey, tracking); err != nil {
+		return time.Time{}, err
+	}
+	if err := s.Put(ctx, entry); err != nil {
+		return time.Time{}, err
+	}
+	return tracking.StartTime, nil
+}
+
+// reapUntrackedKeys deletes the user-managed keys on the service accounts of
+// role sets that Vault doesn't track and that are older than the configured
+// untracked_key_max_age. Keys created before key leases were tracked are
+// kept. Every deletion is logged with the reason, and failures for one key
+// or service account don't stop the others.
+func (b *backend) reapUntrackedKeys(ctx context.Context, s logical.Storage, cfg *config) error {
+	if !cfg.ReapUntrackedKeys || cfg.UntrackedKeyMaxAge < minUntrackedKeyMaxAge {
+		return nil
+	}
+	// Only the active node deletes keys, so nodes don't race each other.
+	if b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+		return nil
+	}
+
+	trackingStart, err := keyLeaseTrackingStart(ctx, s)
+	if err != nil {
+		return err
+	}
+	roleSets, known, err := trackedKeys(ctx, s)
+	if err != nil {
+		return err
+	}
+
+	iamC, err := b.IAMAdminClient(s)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, rs := range roleSets {
+		if rs.AccountId == nil {
+			continue
+		}
+		gcpKeys, err := listUserManagedKeys(ctx, iamC, rs.AccountId.ResourceName())
+		if err != nil {
+			b.Logger().Warn("unable to list keys to reap untracked keys", "roleset", rs.Name, "service_account_email", rs.AccountId.EmailOrId, "error", err)
+			continue
+		}
+
+		for _, k := range gcpKeys {
+			if known[keyID(k.Name)] != nil {
+				continue
+			}
+			created, err := time.Parse(time.RFC3339, k.ValidAfterTime)
+			if err != nil {
+				b.Logger().Warn("not reaping untracked key with unknown creation time", "key_name", k.Name, "roleset", rs.Name, "valid_after_time", k.ValidAfterTime)
+				continue
+			}
+			age := now.Sub(created)
+			if age < cfg.UntrackedKeyMaxAge {
+				continue
+			}
+			if created.Before(trackingStart) {
+				b.Logger().Debug("not reaping untracked key created before key leases were tracked", "key_name", k.Name, "roleset", rs.Name, "valid_after_time", k.ValidAfterTime, "tracking_start", trackingStart.Format(time.RFC3339))
+				continue
+			}
+
+			b.Logger().Warn("deleting untracked service account key: reap_untracked_keys is set and the key is not tracked by Vault and is older than untracked_key_max_age",
+				"key_name", k.Name, "roleset", rs.Name, "service_account_email", rs.AccountId.EmailOrId,
+				"valid_after_time", k.ValidAfterTime, "age", age.Truncate(time.Second).String(), "untracked_key_max_age", cfg.UntrackedKeyMaxAge.String())
+			if _, err := iamC.Projects.ServiceAccounts.Keys.Delete(k.Name).Context(ctx).Do(); err != nil && !isGoogleAccountKeyNotFoundErr(err) {
+				b.Logger().Error("unable to delete untracked service account key", "key_name", k.Name, "roleset", rs.Name, "error", err)
+				continue
+			}
+			b.Logger().Warn("deleted untracked service account key", "key_name", k.Name, "roleset", rs.Name)
+		}
+	}
+	return nil
+}
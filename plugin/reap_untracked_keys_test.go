@@ -0,0 +1,99 @@
+package gcpsecrets
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/iam/v1"
+)
+
+func TestReapUntrackedKeys(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var rs *RoleSet
+	var l sync.Mutex
+	var deleted []string
+	pretracking := time.Now().Add(-96 * time.Hour).UTC().Format(time.RFC3339)
+	old := time.Now().Add(-48 * time.Hour).UTC().Format(time.RFC3339)
+	young := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	gb, storage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		saName := rs.AccountId.ResourceName()
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/"+saName+"/keys":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(&iam.ListServiceAccountKeysResponse{
+				Keys: []*iam.ServiceAccountKey{
+					{Name: saName + "/keys/leased", KeyType: keyTypeUserManaged, ValidAfterTime: old},
+					{Name: saName + "/keys/revoked", KeyType: keyTypeUserManaged, ValidAfterTime: old},
+					{Name: saName + "/keys/manual-old", KeyType: keyTypeUserManaged, ValidAfterTime: old},
+					{Name: saName + "/keys/manual-young", KeyType: keyTypeUserManaged, ValidAfterTime: young},
+					{Name: saName + "/keys/manual-unknown", KeyType: keyTypeUserManaged},
+					{Name: saName + "/keys/pre-tracking", KeyType: keyTypeUserManaged, ValidAfterTime: pretracking},
+				},
+			})
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, "/v1/"+saName+"/keys/"):
+			l.Lock()
+			deleted = append(deleted, keyID(r.URL.Path))
+			l.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte("{}"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	rs = testStoredKeyRoleSet(t, storage, "test-reap")
+	saName := rs.AccountId.ResourceName()
+
+	kl := &keyLease{RoleSet: rs.Name, KeyName: saName + "/keys/leased", IssueTime: time.Now().UTC()}
+	if err := kl.save(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	if err := deferKeyDeletion(ctx, storage, rs.Name, saName+"/keys/revoked", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reaping is off by default.
+	if err := gb.(*backend).reapUntrackedKeys(ctx, storage, &config{UntrackedKeyMaxAge: 24 * time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 0 {
+		t.Fatalf("expected no keys to be deleted with reaping disabled, got %v", deleted)
+	}
+
+	// Without a record of when key leases started being tracked, it is
+	// recorded as now, and every existing key may predate it.
+	reapCfg := &config{ReapUntrackedKeys: true, UntrackedKeyMaxAge: 24 * time.Hour}
+	if err := gb.(*backend).reapUntrackedKeys(ctx, storage, reapCfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 0 {
+		t.Fatalf("expected no keys to be deleted before lease tracking started, got %v", deleted)
+	}
+	if start, err := keyLeaseTrackingStart(ctx, storage); err != nil || time.Since(start) > time.Minute {
+		t.Fatalf("expected lease tracking start to be recorded as now, got %v (err: %v)", start, err)
+	}
+
+	entry, err := logical.StorageEntryJSON(keyLeaseTrackingStorageKey, &keyLeaseTracking{StartTime: time.Now().Add(-72 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Put(ctx, entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := gb.(*backend).reapUntrackedKeys(ctx, storage, reapCfg); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(deleted)
+	if expected := []string{"manual-old"}; !reflect.DeepEqual(deleted, expected) {
+		t.Fatalf("expected keys %v to be deleted, got %v", expected, deleted)
+	}
+}
@@ -0,0 +1,82 @@
+package gcpsecrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/googleapi"
+)
+
+// googleRequestIDHeaders are the response headers GCP APIs return request
+// identifiers in, in order of preference.
+var googleRequestIDHeaders = []string{
+	"X-Goog-Request-Id",
+	"X-Request-Id",
+	"X-Guploader-Uploadid",
+}
+
+// googleRequestID returns the identifier GCP assigned to the failed request
+// behind err, from its response headers or the google.rpc.RequestInfo in its
+// error details, or "" if err is not a GCP error or has no identifier.
+func googleRequestID(err error) string {
+	if err == nil {
+		return ""
+	}
+	gErr, ok := errwrap.GetType(err, &googleapi.Error{}).(*googleapi.Error)
+	if !ok || gErr == nil {
+		return ""
+	}
+
+	for _, h := range googleRequestIDHeaders {
+		if id := gErr.Header.Get(h); id != "" {
+			return id
+		}
+	}
+
+	var body struct {
+		Error struct {
+			Details []struct {
+				Type      string `json:"@type"`
+				RequestId string `json:"requestId"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(gErr.Body), &body); err != nil {
+		return ""
+	}
+	for _, d := range body.Error.Details {
+		if strings.HasSuffix(d.Type, "google.rpc.RequestInfo") && d.RequestId != "" {
+			return d.RequestId
+		}
+	}
+	return ""
+}
+
+// withGoogleRequestID adds the GCP request ID of err, if any, to its message
+// and logs it, so the failure can be found in GCP's logs. VPC Service
+// Controls denials are explained as well. The GCP error is still retrievable
+// from the returned error.
+func (b *backend) withGoogleRequestID(path string, err error) error {
+	err = explainVPCServiceControls(err)
+	id := googleRequestID(err)
+	if id == "" || strings.Contains(err.Error(), id) {
+		return err
+	}
+	b.Logger().Warn("GCP request failed", "path", path, "gcp_request_id", id, "error", err)
+	return errwrap.Wrap(fmt.Errorf("%s (GCP request ID: %s)", err, id), err)
+}
+
+// HandleRequest adds GCP request IDs to errors returned by the paths, and
+// reports the APIs enabled by auto_enable_apis while handling the request.
+func (b *backend) HandleRequest(ctx context.Context, req *logical.Request) (*logical.Response, error) {
+	ctx, enabled := withEnabledAPIs(ctx)
+	resp, err := b.Backend.HandleRequest(ctx, req)
+	if err != nil {
+		err = b.withGoogleRequestID(req.Path, err)
+	}
+	return reportEnabledAPIs(resp, err, enabled)
+}
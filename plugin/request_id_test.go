@@ -0,0 +1,86 @@
+package gcpsecrets
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/googleapi"
+)
+
+func TestGoogleRequestID(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		err      error
+		expected string
+	}{
+		"not a GCP error": {
+			err: errwrap.Wrapf("failed: {{err}}", http.ErrHandlerTimeout),
+		},
+		"no request ID": {
+			err: &googleapi.Error{Code: http.StatusForbidden, Body: `{"error": {"code": 403}}`},
+		},
+		"header": {
+			err: &googleapi.Error{
+				Code:   http.StatusForbidden,
+				Header: http.Header{"X-Goog-Request-Id": {"abc123"}},
+			},
+			expected: "abc123",
+		},
+		"request info detail": {
+			err: &googleapi.Error{
+				Code: http.StatusForbidden,
+				Body: `{"error": {"code": 403, "details": [{"@type": "type.googleapis.com/google.rpc.RequestInfo", "requestId": "def456"}]}}`,
+			},
+			expected: "def456",
+		},
+		"wrapped": {
+			err: errwrap.Wrapf("unable to create key: {{err}}", &googleapi.Error{
+				Code:   http.StatusInternalServerError,
+				Header: http.Header{"X-Request-Id": {"ghi789"}},
+			}),
+			expected: "ghi789",
+		},
+	}
+
+	for name, tc := range cases {
+		if id := googleRequestID(tc.err); id != tc.expected {
+			t.Errorf("%s: expected request ID %q, got %q", name, tc.expected, id)
+		}
+	}
+}
+
+func TestGoogleRequestID_ErrorResponse(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, storage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error": {"code": 500, "message": "internal error", "details": [{"@type": "type.googleapis.com/google.rpc.RequestInfo", "requestId": "req-12345"}]}}`))
+	}))
+
+	rs := testStoredKeyRoleSet(t, storage, "test-requestid")
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "key/revoke-by-name",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"key_name":         rs.AccountId.ResourceName() + "/keys/abc",
+			"delete_untracked": true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error response, got %#v", resp)
+	}
+	if msg := resp.Error().Error(); !strings.Contains(msg, "GCP request ID: req-12345") {
+		t.Errorf("expected error to include request ID, got %q", msg)
+	}
+}
@@ -0,0 +1,42 @@
+package gcpsecrets
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// checkRequiredMetadata returns an error response if the role set has
+// required_metadata and the identity entity of the request doesn't have all
+// of it in its metadata, or nil if the token may be issued.
+func (b *backend) checkRequiredMetadata(req *logical.Request, rs *RoleSet) (*logical.Response, error) {
+	if len(rs.RequiredMetadata) == 0 {
+		return nil, nil
+	}
+	if req.EntityID == "" {
+		return logical.ErrorResponse(fmt.Sprintf("role set '%s' only issues tokens to identity entities with required_metadata, and the request has no entity", rs.Name)), nil
+	}
+
+	entity, err := b.System().EntityInfo(req.EntityID)
+	if err != nil {
+		return nil, errwrap.Wrapf("unable to look up the request's identity entity: {{err}}", err)
+	}
+	if entity == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role set '%s' only issues tokens to identity entities with required_metadata, and the request's entity was not found", rs.Name)), nil
+	}
+
+	var missing []string
+	for k, v := range rs.RequiredMetadata {
+		if actual, ok := entity.Metadata[k]; !ok || actual != v {
+			missing = append(missing, fmt.Sprintf("%s=%s", k, v))
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return logical.ErrorResponse(fmt.Sprintf("role set '%s' only issues tokens to identity entities with required_metadata, and the request's entity lacks %s", rs.Name, strings.Join(missing, ", "))), nil
+	}
+	return nil, nil
+}
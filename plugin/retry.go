@@ -0,0 +1,119 @@
+package gcpsecrets
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-gcp-common/gcputil"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// Bounds of the exponential backoff used when GCP rate limits a request.
+	retryBaseDelay = 500 * time.Millisecond
+	retryMaxDelay  = 30 * time.Second
+
+	// newAccountMaxRetries is how many times an operation on a service
+	// account created moments ago is retried while GCP doesn't know about
+	// the account yet. With the backoff above, that waits about 15 seconds.
+	newAccountMaxRetries = 5
+)
+
+// isRateLimitErr returns true if err is a GCP 429 Too Many Requests error.
+func isRateLimitErr(err error) bool {
+	return isGoogleApiErrorWithCodes(err, http.StatusTooManyRequests)
+}
+
+// retryDelay returns how long to wait before retrying after the given error
+// on the given (zero-based) attempt. It uses exponential backoff, but never
+// waits less than the Retry-After given by GCP, if any.
+func retryDelay(err error, attempt int) time.Duration {
+	delay := retryMaxDelay
+	if attempt < 16 {
+		if d := retryBaseDelay << uint(attempt); d < retryMaxDelay {
+			delay = d
+		}
+	}
+
+	if gErr, ok := err.(*googleapi.Error); ok {
+		if retryAfter, ok := parseRetryAfter(gErr.Header, time.Now()); ok && retryAfter > delay {
+			delay = retryAfter
+		}
+	}
+	return delay
+}
+
+// parseRetryAfter parses a Retry-After header, given either as a number of
+// seconds or as an HTTP date.
+func parseRetryAfter(h http.Header, now time.Time) (time.Duration, bool) {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// isAccountNotPropagatedErr returns true if err is GCP reporting that the
+// given service account doesn't exist, as it does for a while after the
+// account is created. A 400 or 404 about anything else, such as a bound
+// resource that really doesn't exist, is not matched.
+func isAccountNotPropagatedErr(err error, account *gcputil.ServiceAccountId) bool {
+	gErr, ok := errwrap.GetType(err, &googleapi.Error{}).(*googleapi.Error)
+	if !ok || gErr == nil || account == nil {
+		return false
+	}
+	if gErr.Code != http.StatusBadRequest && gErr.Code != http.StatusNotFound {
+		return false
+	}
+
+	msg := strings.ToLower(gErr.Message)
+	if !strings.Contains(msg, "does not exist") && !strings.Contains(msg, "not found") && !strings.Contains(msg, "unknown service account") {
+		return false
+	}
+	return strings.Contains(msg, "service account") || (account.EmailOrId != "" && strings.Contains(msg, strings.ToLower(account.EmailOrId)))
+}
+
+// retryNewAccount runs op, an operation on the service account that was
+// just created, retrying it with backoff up to newAccountMaxRetries times
+// while it fails because the account hasn't propagated through GCP yet.
+// Any other error is returned immediately.
+func retryNewAccount(ctx context.Context, account *gcputil.ServiceAccountId, op func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := op()
+		if err == nil || attempt >= newAccountMaxRetries || !isAccountNotPropagatedErr(err, account) {
+			return err
+		}
+		if err := sleepContext(ctx, retryDelay(err, attempt)); err != nil {
+			return errwrap.Wrapf("request aborted while waiting for new service account to propagate: {{err}}", err)
+		}
+	}
+}
+
+// sleepContext waits for d, returning early with the context's error if it
+// is cancelled first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
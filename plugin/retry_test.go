@@ -0,0 +1,133 @@
+package gcpsecrets
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-gcp-common/gcputil"
+	"google.golang.org/api/googleapi"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	cases := map[string]struct {
+		value    string
+		expected time.Duration
+		ok       bool
+	}{
+		"missing":   {"", 0, false},
+		"seconds":   {"7", 7 * time.Second, true},
+		"http date": {now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second, true},
+		"past date": {now.Add(-time.Minute).Format(http.TimeFormat), 0, true},
+		"garbage":   {"soon", 0, false},
+	}
+	for name, tc := range cases {
+		h := http.Header{}
+		if tc.value != "" {
+			h.Set("Retry-After", tc.value)
+		}
+		d, ok := parseRetryAfter(h, now)
+		if ok != tc.ok || d != tc.expected {
+			t.Errorf("%s: expected (%s, %t), got (%s, %t)", name, tc.expected, tc.ok, d, ok)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	noHeader := &googleapi.Error{Code: http.StatusTooManyRequests}
+	if d := retryDelay(noHeader, 0); d != retryBaseDelay {
+		t.Errorf("expected first backoff of %s, got %s", retryBaseDelay, d)
+	}
+	if d := retryDelay(noHeader, 2); d != 4*retryBaseDelay {
+		t.Errorf("expected third backoff of %s, got %s", 4*retryBaseDelay, d)
+	}
+	if d := retryDelay(noHeader, 100); d != retryMaxDelay {
+		t.Errorf("expected backoff to be capped at %s, got %s", retryMaxDelay, d)
+	}
+
+	withHeader := &googleapi.Error{
+		Code:   http.StatusTooManyRequests,
+		Header: http.Header{"Retry-After": {"10"}},
+	}
+	if d := retryDelay(withHeader, 0); d != 10*time.Second {
+		t.Errorf("expected Retry-After of 10s to be honored, got %s", d)
+	}
+}
+
+func TestIsAccountNotPropagatedErr(t *testing.T) {
+	account := &gcputil.ServiceAccountId{Project: "my-project", EmailOrId: "vaulttest-1234@my-project.iam.gserviceaccount.com"}
+
+	cases := map[string]struct {
+		err      error
+		expected bool
+	}{
+		"binding member": {
+			&googleapi.Error{Code: http.StatusBadRequest, Message: "Service account vaulttest-1234@my-project.iam.gserviceaccount.com does not exist."}, true,
+		},
+		"account api": {
+			errwrap.Wrapf("unable to get service account IAM policy: {{err}}", &googleapi.Error{Code: http.StatusNotFound, Message: "Unknown service account"}), true,
+		},
+		"missing resource": {
+			&googleapi.Error{Code: http.StatusNotFound, Message: "The specified bucket does not exist."}, false,
+		},
+		"invalid argument": {
+			&googleapi.Error{Code: http.StatusBadRequest, Message: "Role roles/foo is not supported for this resource."}, false,
+		},
+		"permission denied": {
+			&googleapi.Error{Code: http.StatusForbidden, Message: "Permission iam.serviceAccounts.get denied on service account."}, false,
+		},
+	}
+	for name, tc := range cases {
+		if actual := isAccountNotPropagatedErr(tc.err, account); actual != tc.expected {
+			t.Errorf("%s: expected %t, got %t", name, tc.expected, actual)
+		}
+	}
+}
+
+func TestRetryNewAccount(t *testing.T) {
+	t.Parallel()
+
+	account := &gcputil.ServiceAccountId{Project: "my-project", EmailOrId: "vaulttest-1234@my-project.iam.gserviceaccount.com"}
+	notPropagated := &googleapi.Error{Code: http.StatusBadRequest, Message: "Service account vaulttest-1234@my-project.iam.gserviceaccount.com does not exist."}
+
+	// The account shows up after the first attempt.
+	calls := 0
+	err := retryNewAccount(context.Background(), account, func() error {
+		calls++
+		if calls == 1 {
+			return notPropagated
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected retry to succeed once the account propagated, got %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected 2 attempts, got %d", calls)
+	}
+
+	// A genuine not-found is returned without retrying.
+	calls = 0
+	notFound := &googleapi.Error{Code: http.StatusNotFound, Message: "The specified bucket does not exist."}
+	err = retryNewAccount(context.Background(), account, func() error {
+		calls++
+		return notFound
+	})
+	if err != notFound || calls != 1 {
+		t.Fatalf("expected the not-found error after 1 attempt, got %v after %d", err, calls)
+	}
+
+	// Retries stop when the context is cancelled.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = retryNewAccount(ctx, account, func() error {
+		return notPropagated
+	})
+	if err == nil || err == notPropagated {
+		t.Fatalf("expected the retry to be aborted, got %v", err)
+	}
+}
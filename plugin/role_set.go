@@ -2,13 +2,16 @@ package gcpsecrets
 
 import (
 	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"fmt"
-	"time"
-
+	"net/http"
 	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/go-gcp-common/gcputil"
@@ -16,14 +19,32 @@ import (
 	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/iamutil"
 	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/util"
 	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
 	"github.com/hashicorp/vault/sdk/helper/useragent"
 	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iam/v1"
 )
 
 const (
 	serviceAccountMaxLen          = 30
 	serviceAccountDisplayNameTmpl = "Service account for Vault secrets backend role set %s"
+
+	serviceAccountIdPrefix    = "vault"
+	serviceAccountSuffixChars = "abcdefghijklmnopqrstuvwxyz0123456789"
+
+	// Bounds of the random service account ID suffix. The minimum keeps
+	// collisions between role sets with similar names unlikely; the maximum
+	// leaves room for the prefix.
+	minServiceAccountSuffixLen     = 4
+	maxServiceAccountSuffixLen     = serviceAccountMaxLen - len(serviceAccountIdPrefix) - len("-")
+	defaultServiceAccountSuffixLen = 10
+
+	// defaultServiceAccountIdAttempts is how many service account IDs are
+	// tried by default when generated IDs are already taken.
+	defaultServiceAccountIdAttempts = 5
+
+	serviceAccountTokenCreatorRole = "roles/iam.serviceAccountTokenCreator"
 )
 
 type RoleSet struct {
@@ -33,8 +54,127 @@ type RoleSet struct {
 	RawBindings string
 	Bindings    ResourceBindings
 
+	// BindingMembers are the members, besides the service account, granted
+	// the roles of each bound resource, by resource name.
+	BindingMembers map[string]util.StringSet
+
+	// WildcardExpansions are the concrete project resources each bound
+	// resource naming projects with a wildcard was expanded to when the
+	// bindings were written, by pattern. Bindings has the concrete resources.
+	WildcardExpansions map[string][]string
+
+	// AddedMembers are the roles granted to BindingMembers that weren't
+	// already granted, by resource name. Only these are revoked.
+	AddedMembers map[string]RoleMembers
+
 	AccountId *gcputil.ServiceAccountId
 	TokenGen  *TokenGenerator
+
+	// RequestedProject is the project the role set was created for, if its
+	// service account is in one of the config's sa_fallback_projects
+	// instead. It is empty when the account is in the role set's project.
+	RequestedProject string
+
+	// AccountUniqueId is the numeric unique ID GCP assigned to the role set's
+	// service account. It is empty for role sets created before it was stored.
+	AccountUniqueId string
+
+	// TokenCreators are members granted roles/iam.serviceAccountTokenCreator
+	// on the role set's service account.
+	TokenCreators []string
+
+	// MaxTokenTTL, if set, caps the lifetime of access tokens generated for
+	// the role set.
+	MaxTokenTTL time.Duration
+
+	// DefaultAudience is the audience of ID tokens generated for the role set
+	// when a request doesn't give one.
+	DefaultAudience string
+
+	// AllowedAudiences, if set, are the only audiences ID tokens can be
+	// generated for under the role set.
+	AllowedAudiences []string
+
+	// KeyRotationPeriod, if set, is how often keys issued under the role set
+	// are replaced in the background. Only used by key role sets.
+	KeyRotationPeriod time.Duration
+
+	// DisableKeyRenewal makes the leases of keys issued under the role set
+	// non-renewable. Only used by key role sets.
+	DisableKeyRenewal bool
+
+	// RequireControlGroup refuses to issue keys and tokens for requests that
+	// weren't approved through a Vault control group.
+	RequireControlGroup bool
+
+	// RequiredMetadata are pairs the metadata of a request's identity entity
+	// must have for access tokens to be issued. Only used by access token
+	// role sets.
+	RequiredMetadata map[string]string
+
+	// AllowedCallers restricts which requests keys, tokens and other
+	// credentials are issued to, by identity entity, namespace or auth
+	// mount. See checkAllowedCallers.
+	AllowedCallers []string
+
+	// AllowTokenBatch lets token-batch generate tokens for the role set. Only
+	// used by access token role sets.
+	AllowTokenBatch bool
+
+	// Ephemeral role sets issue a single secret and are deleted, with their
+	// GCP resources, once EphemeralExpireTime passes. Issuing the secret
+	// moves EphemeralExpireTime up to when the secret expires.
+	Ephemeral           bool
+	EphemeralExpireTime time.Time
+	EphemeralIssued     bool
+
+	// BindingsLastApplied is when the role set's bindings were last
+	// successfully applied or reconciled. It is zero for role sets whose
+	// bindings haven't been applied since it was added.
+	BindingsLastApplied time.Time
+
+	// BindingsStatus is whether the role set's bindings have been applied to
+	// its service account, one of the bindingsStatus constants. Empty means
+	// applied. BindingsError is the last error applying them, if they failed.
+	BindingsStatus string
+	BindingsError  string
+
+	// CreateTime and CreatedBy record when and by whom the role set was
+	// created, LastModified and LastModifiedBy when and by whom it was last
+	// written. They are informational only, and zero for role sets saved
+	// before they were added.
+	CreateTime     time.Time
+	CreatedBy      roleSetActor
+	LastModified   time.Time
+	LastModifiedBy roleSetActor
+}
+
+// roleSetActor identifies the client that wrote a role set, as far as the
+// request tells: its entity, if it has one, and its token's display name.
+type roleSetActor struct {
+	EntityID    string
+	DisplayName string
+}
+
+func requestActor(req *logical.Request) roleSetActor {
+	return roleSetActor{
+		EntityID:    req.EntityID,
+		DisplayName: req.DisplayName,
+	}
+}
+
+// keyRenewable returns whether the leases of keys issued under the role set
+// can be renewed. Ephemeral role sets issue a single secret for their
+// lifetime, so their keys never are.
+func (rs *RoleSet) keyRenewable() bool {
+	return !rs.DisableKeyRenewal && !rs.Ephemeral
+}
+
+func (a roleSetActor) asOutput() map[string]interface{} {
+	return map[string]interface{}{
+		"entity_id":    a.EntityID,
+		"display_name": a.DisplayName,
+	}
 }
 
 func (rs *RoleSet) validate() error {
@@ -66,7 +206,7 @@ func (rs *RoleSet) validate() error {
 		} else if len(rs.TokenGen.Scopes) == 0 {
 			err = multierror.Append(err, fmt.Errorf("access token role set should have defined scopes"))
 		}
-	case SecretTypeKey:
+	case SecretTypeKey, SecretTypeHMACKey:
 		break
 	default:
 		err = multierror.Append(err, fmt.Errorf("unknown secret type: %s", rs.SecretType))
@@ -91,12 +231,12 @@ func (rs *RoleSet) bindingHash() string {
 	return getStringHash(rs.RawBindings)
 }
 
-func (rs *RoleSet) getServiceAccount(iamAdmin *iam.Service) (*iam.ServiceAccount, error) {
+func (rs *RoleSet) getServiceAccount(ctx context.Context, iamAdmin *iam.Service) (*iam.ServiceAccount, error) {
 	if rs.AccountId == nil {
 		return nil, fmt.Errorf("role set '%s' is invalid, has no associated service account", rs.Name)
 	}
 
-	account, err := iamAdmin.Projects.ServiceAccounts.Get(rs.AccountId.ResourceName()).Do()
+	account, err := iamAdmin.Projects.ServiceAccounts.Get(rs.AccountId.ResourceName()).Context(ctx).Do()
 	if err != nil {
 		return nil, fmt.Errorf("could not find service account: %v. If account was deleted, role set must be updated (write to roleset/%s/rotate) before generating new secrets", err, rs.Name)
 	} else if account == nil {
@@ -108,10 +248,24 @@ func (rs *RoleSet) getServiceAccount(iamAdmin *iam.Service) (*iam.ServiceAccount
 
 type ResourceBindings map[string]util.StringSet
 
+// count returns the number of role and resource pairs in the bindings.
+func (rb ResourceBindings) count() int {
+	n := 0
+	for _, roles := range rb {
+		n += len(roles)
+	}
+	return n
+}
+
+// asOutput returns the bindings in their canonical form for output: each
+// resource mapped to a sorted list of roles, independent of whether the
+// bindings were given as HCL or JSON.
 func (rb ResourceBindings) asOutput() map[string][]string {
 	out := make(map[string][]string)
 	for k, v := range rb {
-		out[k] = v.ToSlice()
+		roles := v.ToSlice()
+		sort.Strings(roles)
+		out[k] = roles
 	}
 	return out
 }
@@ -123,7 +277,22 @@ type TokenGenerator struct {
 	Scopes []string
 }
 
-func (b *backend) saveRoleSetWithNewAccount(ctx context.Context, s logical.Storage, rs *RoleSet, project string, newBinds ResourceBindings, scopes []string) (warning []string, err error) {
+// saveRoleSetWithNewAccount creates a new service account for the role set,
+// binds it and saves the role set, then cleans up the old account. If
+// asyncBindings is set, the bindings are applied in the background instead,
+// with the role set saved as pending until they are.
+func (b *backend) saveRoleSetWithNewAccount(ctx context.Context, s logical.Storage, rs *RoleSet, project string, newBinds ResourceBindings, scopes []string, asyncBindings bool) (warning []string, err error) {
+	// If the update fails, the new account and whatever was applied to it are
+	// rolled back right away instead of by periodic WAL rollback, so GCP is
+	// left matching the stored role set. Rollback takes rolesetLock, so this
+	// is deferred to run after it is released.
+	var newWals []string
+	defer func() {
+		if err != nil && len(newWals) > 0 {
+			err = b.rollbackNewAccount(s, newWals, err)
+		}
+	}()
+
 	b.rolesetLock.Lock()
 	defer b.rolesetLock.Unlock()
 
@@ -139,6 +308,12 @@ func (b *backend) saveRoleSetWithNewAccount(ctx context.Context, s logical.Stora
 
 	apiHandle := iamutil.GetApiHandle(httpC, useragent.String())
 
+	if newBinds != nil {
+		if err := b.validateBindingResources(ctx, apiHandle, newBinds); err != nil {
+			return nil, err
+		}
+	}
+
 	oldAccount := rs.AccountId
 	oldBindings := rs.Bindings
 	oldTokenKey := rs.TokenGen
@@ -149,33 +324,78 @@ func (b *backend) saveRoleSetWithNewAccount(ctx context.Context, s logical.Stora
 		return nil, errwrap.Wrapf("failed to create WAL for cleaning up old account: {{err}}", err)
 	}
 
-	newWals := make([]string, 0, len(newBinds)+2)
-	walId, err := rs.newServiceAccount(ctx, s, iamAdmin, project)
+	// Members granted roles for the old account that the new one keeps stay
+	// as added, so they're revoked with the new account.
+	oldAddedMembers := rs.AddedMembers
+	rs.AddedMembers = nil
+
+	newWals = make([]string, 0, len(newBinds)+2)
+	cfg, err := getConfig(ctx, s)
+	if err != nil {
+		b.Logger().Warn("unable to read config, using default service account naming", "error", err)
+	}
+	walId, fallbackWarning, err := b.newServiceAccountWithFallback(ctx, s, iamAdmin, rs, project, cfg)
+	if walId != "" {
+		newWals = append(newWals, walId)
+	}
 	if err != nil {
 		tryDeleteWALs(ctx, s, oldWals...)
 		return nil, err
 	}
-	newWals = append(newWals, walId)
 
 	binds := rs.Bindings
 	if newBinds != nil {
 		binds = newBinds
 		rs.Bindings = newBinds
 	}
-	walIds, err := rs.updateIamPolicies(ctx, s, b.resources, apiHandle, binds)
+	var applyWalId string
+	if asyncBindings {
+		applyWalId, err = framework.PutWAL(ctx, s, walTypeApplyBindings, &walApplyBindings{
+			RoleSet:   rs.Name,
+			AccountId: *rs.AccountId,
+		})
+		if err != nil {
+			tryDeleteWALs(ctx, s, oldWals...)
+			return nil, errwrap.Wrapf("failed to create WAL for applying bindings: {{err}}", err)
+		}
+		rs.BindingsStatus = bindingsStatusPending
+	} else {
+		walIds, err := rs.updateIamPolicies(ctx, s, b.resources, apiHandle, binds, b.maxBindingRetries(ctx, s), oldAddedMembers)
+		b.usage.record(rs.Name, usageBindingApplies, len(walIds))
+		newWals = append(newWals, walIds...)
+		if err != nil {
+			tryDeleteWALs(ctx, s, oldWals...)
+			return nil, b.explainPermissionDenied(ctx, s, err)
+		}
+		rs.BindingsLastApplied = time.Now().UTC()
+		rs.BindingsStatus = bindingsStatusApplied
+	}
+	rs.BindingsError = ""
+
+	err = retryNewAccount(ctx, rs.AccountId, func() error {
+		return updateTokenCreators(ctx, iamAdmin, rs.AccountId, rs.TokenCreators, nil)
+	})
 	if err != nil {
 		tryDeleteWALs(ctx, s, oldWals...)
-		return nil, err
+		return nil, errwrap.Wrapf("unable to grant token creators on new service account: {{err}}", err)
 	}
-	newWals = append(newWals, walIds...)
 
 	if rs.SecretType == SecretTypeAccessToken {
-		walId, err := rs.newKeyForTokenGen(ctx, s, iamAdmin, scopes)
+		var walId string
+		err := retryNewAccount(ctx, rs.AccountId, func() error {
+			var err error
+			walId, err = rs.newKeyForTokenGen(ctx, s, iamAdmin, scopes)
+			b.usage.record(rs.Name, usageKeyCreations, 1)
+			return err
+		})
 		if err != nil {
 			tryDeleteWALs(ctx, s, oldWals...)
 			return nil, err
 		}
 		newWals = append(newWals, walId)
+	} else {
+		// The old account's key, if any, is cleaned up with the account.
+		rs.TokenGen = nil
 	}
 
 	if err := rs.save(ctx, s); err != nil {
@@ -186,18 +406,28 @@ func (b *backend) saveRoleSetWithNewAccount(ctx context.Context, s logical.Stora
 	// Delete WALs for cleaning up new resources now that they have been saved.
 	tryDeleteWALs(ctx, s, newWals...)
 
+	if asyncBindings {
+		go b.applyBindingsInBackground(s, &walApplyBindings{
+			RoleSet:   rs.Name,
+			AccountId: *rs.AccountId,
+		}, applyWalId)
+	}
+
+	var warnings []string
+	if fallbackWarning != "" {
+		warnings = append(warnings, fallbackWarning)
+	}
+
 	// Try deleting old resources (WALs exist so we can ignore failures)
 	if oldAccount == nil || oldAccount.EmailOrId == "" {
 		// nothing to clean up
-		return nil, nil
+		return warnings, nil
 	}
 
 	// Return any errors as warnings so user knows immediate cleanup failed
-	warnings := make([]string, 0)
-	if errs := b.removeBindings(ctx, apiHandle, oldAccount.EmailOrId, oldBindings); errs != nil {
-		warnings = make([]string, len(errs.Errors), len(errs.Errors)+2)
-		for idx, err := range errs.Errors {
-			warnings[idx] = fmt.Sprintf("unable to immediately delete old binding (WAL cleanup entry has been added): %v", err)
+	if _, errs := b.removeBindings(ctx, s, apiHandle, oldAccount.EmailOrId, oldBindings, subAddedMembers(oldAddedMembers, rs.AddedMembers)); errs != nil {
+		for _, err := range errs.Errors {
+			warnings = append(warnings, fmt.Sprintf("unable to immediately delete old binding (WAL cleanup entry has been added): %v", err))
 		}
 	}
 	if err := b.deleteServiceAccount(ctx, iamAdmin, oldAccount); err != nil {
@@ -209,6 +439,39 @@ func (b *backend) saveRoleSetWithNewAccount(ctx context.Context, s logical.Stora
 	return warnings, nil
 }
 
+// rollbackNewAccount rolls back the given WAL entries for the resources
+// created by a role set update that failed with cause, newest first, and
+// returns the error to report for it. Entries that can't be rolled back now
+// are kept for periodic WAL rollback to retry.
+func (b *backend) rollbackNewAccount(s logical.Storage, walIds []string, cause error) error {
+	// The request may have been canceled, which must not stop the rollback.
+	ctx := context.Background()
+	req := &logical.Request{Storage: s}
+
+	var merr *multierror.Error
+	for i := len(walIds) - 1; i >= 0; i-- {
+		wal, err := framework.GetWAL(ctx, s, walIds[i])
+		if err != nil {
+			merr = multierror.Append(merr, err)
+			continue
+		}
+		if wal == nil {
+			continue
+		}
+		if err := b.walRollback(ctx, req, wal.Kind, wal.Data); err != nil {
+			merr = multierror.Append(merr, err)
+			continue
+		}
+		tryDeleteWALs(ctx, s, walIds[i])
+	}
+
+	if err := merr.ErrorOrNil(); err != nil {
+		b.Logger().Warn("unable to roll back failed role set update, will retry", "error", err)
+		return errwrap.Wrap(fmt.Errorf("%v; the role set is unchanged, but the new service account and its bindings couldn't all be removed yet and will be by a later rollback: %v", cause, err), cause)
+	}
+	return errwrap.Wrap(fmt.Errorf("%v; the role set is unchanged, and the new service account and any bindings applied to it were removed", cause), cause)
+}
+
 func (b *backend) saveRoleSetWithNewTokenKey(ctx context.Context, s logical.Storage, rs *RoleSet, scopes []string) (warning string, err error) {
 	b.rolesetLock.Lock()
 	defer b.rolesetLock.Unlock()
@@ -235,6 +498,7 @@ func (b *backend) saveRoleSetWithNewTokenKey(ctx context.Context, s logical.Stor
 	oldKeyGen := rs.TokenGen
 
 	newKeyWalId, err := rs.newKeyForTokenGen(ctx, s, iamAdmin, scopes)
+	b.usage.record(rs.Name, usageKeyCreations, 1)
 	if err != nil {
 		tryDeleteWALs(ctx, s, oldKeyWalId)
 		return "", err
@@ -254,6 +518,47 @@ func (b *backend) saveRoleSetWithNewTokenKey(ctx context.Context, s logical.Stor
 	return "", nil
 }
 
+// changeRoleSetSecretType saves the role set after its secret type was changed
+// from oldSecretType, keeping its service account and bindings. A key for
+// generating access tokens is created when switching to access tokens, and
+// deleted when switching away from them. Keys issued under the old secret
+// type are left alone until their leases end.
+func (b *backend) changeRoleSetSecretType(ctx context.Context, s logical.Storage, rs *RoleSet, oldSecretType string, scopes []string) (warning string, err error) {
+	if rs.SecretType == SecretTypeAccessToken {
+		return b.saveRoleSetWithNewTokenKey(ctx, s, rs, scopes)
+	}
+	if oldSecretType != SecretTypeAccessToken || rs.TokenGen == nil {
+		return "", rs.save(ctx, s)
+	}
+
+	b.rolesetLock.Lock()
+	defer b.rolesetLock.Unlock()
+
+	iamAdmin, err := b.IAMAdminClient(s)
+	if err != nil {
+		return "", err
+	}
+
+	oldKeyGen := rs.TokenGen
+	if _, err := framework.PutWAL(ctx, s, walTypeAccountKey, &walAccountKey{
+		RoleSet:            rs.Name,
+		KeyName:            oldKeyGen.KeyName,
+		ServiceAccountName: rs.AccountId.ResourceName(),
+	}); err != nil {
+		return "", errwrap.Wrapf("unable to create WAL for deleting old key: {{err}}", err)
+	}
+
+	rs.TokenGen = nil
+	if err := rs.save(ctx, s); err != nil {
+		return "", err
+	}
+
+	if err := b.deleteTokenGenKey(ctx, iamAdmin, oldKeyGen); err != nil {
+		return errwrap.Wrapf("unable to delete old key (delayed cleaned up WAL entry added): {{err}}", err).Error(), nil
+	}
+	return "", nil
+}
+
 func (rs *RoleSet) addWALsForCurrentAccount(ctx context.Context, s logical.Storage) ([]string, error) {
 	if rs.AccountId == nil {
 		return nil, nil
@@ -280,6 +585,7 @@ func (rs *RoleSet) addWALsForCurrentAccount(ctx context.Context, s logical.Stora
 			},
 			Resource: resource,
 			Roles:    roles.ToSlice(),
+			Members:  rs.AddedMembers[resource].asWAL(),
 		})
 		if err != nil {
 			return nil, err
@@ -301,34 +607,95 @@ func (rs *RoleSet) addWALsForCurrentAccount(ctx context.Context, s logical.Stora
 	return wals, nil
 }
 
-func (rs *RoleSet) newServiceAccount(ctx context.Context, s logical.Storage, iamAdmin *iam.Service, project string) (string, error) {
-	saEmailPrefix := roleSetServiceAccountName(rs.Name)
+// newServiceAccount creates a service account for the role set, named by
+// nameTmpl (a sa_name_template) if set or "vault<role set>-<random>"
+// otherwise. If the generated ID is already taken, such as by a role set
+// created concurrently, a new ID is generated, up to attempts IDs in all.
+func (rs *RoleSet) newServiceAccount(ctx context.Context, s logical.Storage, iamAdmin *iam.Service, project, nameTmpl string, suffixLen, attempts int) (string, error) {
 	projectName := fmt.Sprintf("projects/%s", project)
 	displayName := fmt.Sprintf(serviceAccountDisplayNameTmpl, rs.Name)
 
-	walId, err := framework.PutWAL(ctx, s, walTypeAccount, &walAccount{
-		RoleSet: rs.Name,
-		Id: gcputil.ServiceAccountId{
+	var walId string
+	var expectedId gcputil.ServiceAccountId
+	var sa *iam.ServiceAccount
+	var err error
+	for attempt := 1; ; attempt++ {
+		var saEmailPrefix string
+		if nameTmpl != "" {
+			var random string
+			if random, err = randomServiceAccountSuffix(suffixLen); err != nil {
+				return "", errwrap.Wrapf("unable to generate service account ID suffix: {{err}}", err)
+			}
+			saEmailPrefix, err = renderServiceAccountName(nameTmpl, rs.Name, project, random)
+		} else {
+			saEmailPrefix, err = roleSetServiceAccountName(rs.Name, suffixLen)
+		}
+		if err != nil {
+			return "", err
+		}
+
+		expectedId = gcputil.ServiceAccountId{
 			Project:   project,
 			EmailOrId: fmt.Sprintf("%s@%s.iam.gserviceaccount.com", saEmailPrefix, project),
-		},
-	})
-	if err != nil {
-		return "", errwrap.Wrapf("unable to create WAL entry for generating new service account: {{err}}", err)
+		}
+		walId, err = framework.PutWAL(ctx, s, walTypeAccount, &walAccount{
+			RoleSet: rs.Name,
+			Id:      expectedId,
+		})
+		if err != nil {
+			return "", errwrap.Wrapf("unable to create WAL entry for generating new service account: {{err}}", err)
+		}
+
+		sa, err = iamAdmin.Projects.ServiceAccounts.Create(
+			projectName, &iam.CreateServiceAccountRequest{
+				AccountId:      saEmailPrefix,
+				ServiceAccount: &iam.ServiceAccount{DisplayName: displayName},
+			}).Context(ctx).Do()
+		if err == nil {
+			break
+		}
+		if !isGoogleApiErrorWithCodes(err, http.StatusConflict) {
+			return walId, errwrap.Wrapf(fmt.Sprintf("unable to create new service account under project '%s': {{err}}", projectName), err)
+		}
+
+		// The generated ID is taken by an account that isn't ours, so its WAL
+		// entry must not be left for rollback to delete it.
+		framework.DeleteWAL(ctx, s, walId)
+		if attempt >= attempts {
+			return "", fmt.Errorf("unable to generate an unused service account ID under project '%s' after %d attempts, consider increasing service_account_suffix_length or service_account_id_attempts", projectName, attempt)
+		}
 	}
 
-	sa, err := iamAdmin.Projects.ServiceAccounts.Create(
-		projectName, &iam.CreateServiceAccountRequest{
-			AccountId:      saEmailPrefix,
-			ServiceAccount: &iam.ServiceAccount{DisplayName: displayName},
-		}).Do()
-	if err != nil {
-		return walId, errwrap.Wrapf(fmt.Sprintf("unable to create new service account under project '%s': {{err}}", projectName), err)
+	// The email GCP assigned is authoritative; don't assume it matches the
+	// one constructed for the WAL entry above.
+	if sa.Email == "" {
+		if sa, err = iamAdmin.Projects.ServiceAccounts.Get(sa.Name).Context(ctx).Do(); err != nil {
+			return walId, errwrap.Wrapf("unable to look up new service account: {{err}}", err)
+		}
 	}
-	rs.AccountId = &gcputil.ServiceAccountId{
+	accountId := &gcputil.ServiceAccountId{
 		Project:   project,
 		EmailOrId: sa.Email,
 	}
+	if sa.ProjectId != "" {
+		accountId.Project = sa.ProjectId
+	}
+
+	if accountId.ResourceName() != expectedId.ResourceName() {
+		// Point the WAL entry at the actual account so it can be cleaned up.
+		newWalId, err := framework.PutWAL(ctx, s, walTypeAccount, &walAccount{
+			RoleSet: rs.Name,
+			Id:      *accountId,
+		})
+		if err != nil {
+			return walId, errwrap.Wrapf("unable to create WAL entry for new service account: {{err}}", err)
+		}
+		framework.DeleteWAL(ctx, s, walId)
+		walId = newWalId
+	}
+
+	rs.AccountId = accountId
+	rs.AccountUniqueId = sa.UniqueId
 	return walId, nil
 }
 
@@ -345,10 +712,10 @@ func (rs *RoleSet) newKeyForTokenGen(ctx context.Context, s logical.Storage, iam
 	key, err := iamAdmin.Projects.ServiceAccounts.Keys.Create(rs.AccountId.ResourceName(),
 		&iam.CreateServiceAccountKeyRequest{
 			PrivateKeyType: privateKeyTypeJson,
-		}).Do()
+		}).Context(ctx).Do()
 	if err != nil {
 		framework.DeleteWAL(ctx, s, walId)
-		return "", err
+		return "", keyCreationError(rs.AccountId, err)
 	}
 	rs.TokenGen = &TokenGenerator{
 		KeyName:    key.Name,
@@ -358,9 +725,41 @@ func (rs *RoleSet) newKeyForTokenGen(ctx context.Context, s logical.Storage, iam
 	return walId, nil
 }
 
-func (rs *RoleSet) updateIamPolicies(ctx context.Context, s logical.Storage, enabledResources iamutil.ResourceParser, apiHandle *iamutil.ApiHandle, rb ResourceBindings) ([]string, error) {
+// updateIamPolicies grants the role set's service account, and the role set's
+// additional members, the roles of each resource in rb, with a WAL entry per
+// resource to undo it. Members are recorded in AddedMembers where they didn't
+// have a role before, or where keep says an earlier update added it.
+func (rs *RoleSet) updateIamPolicies(ctx context.Context, s logical.Storage, enabledResources iamutil.ResourceParser, apiHandle *iamutil.ApiHandle, rb ResourceBindings, maxRetries int, keep map[string]RoleMembers) ([]string, error) {
 	wals := make([]string, 0, len(rb))
 	for rName, roles := range rb {
+		if err := ctx.Err(); err != nil {
+			return wals, errwrap.Wrapf("request aborted while updating IAM policies: {{err}}", err)
+		}
+
+		resource, err := enabledResources.Parse(rName)
+		if err != nil {
+			return wals, err
+		}
+
+		desired := rs.desiredMembers(rName)
+		added := make(RoleMembers)
+		if len(desired) > 0 {
+			p, err := resource.GetIamPolicy(ctx, apiHandle)
+			if err != nil {
+				return wals, err
+			}
+			for role, members := range desired {
+				for m := range members {
+					if !p.HasMember(role, m) || keep[rName][role].Includes(m) {
+						if added[role] == nil {
+							added[role] = make(util.StringSet)
+						}
+						added[role].Add(m)
+					}
+				}
+			}
+		}
+
 		walId, err := framework.PutWAL(ctx, s, walTypeIamPolicy, &walIamPolicy{
 			RoleSet: rs.Name,
 			AccountId: gcputil.ServiceAccountId{
@@ -369,50 +768,274 @@ func (rs *RoleSet) updateIamPolicies(ctx context.Context, s logical.Storage, ena
 			},
 			Resource: rName,
 			Roles:    roles.ToSlice(),
+			Members:  added.asWAL(),
 		})
 		if err != nil {
 			return wals, err
 		}
 
-		resource, err := enabledResources.Parse(rName)
+		// The account was just created, so GCP may not accept it as a member
+		// yet.
+		var changed bool
+		err = retryNewAccount(ctx, rs.AccountId, func() error {
+			var err error
+			changed, err = setIamPolicyWithRetry(ctx, apiHandle, resource, maxRetries, func(p *iamutil.Policy) (bool, *iamutil.Policy) {
+				changedAccount, p := p.AddBindings(&iamutil.PolicyDelta{
+					Roles: roles,
+					Email: rs.AccountId.EmailOrId,
+				})
+				changedMembers, p := p.AddMembers(desired)
+				return changedAccount || changedMembers, p
+			})
+			return err
+		})
 		if err != nil {
-			return wals, err
+			// The policy may have been set before the error, so the entry
+			// is returned for rollback too.
+			return append(wals, walId), newSetIamPolicyError(rName, resource, err)
+		}
+		if len(added) > 0 {
+			rs.addAddedMembers(rName, added)
+		}
+		if !changed {
+			continue
 		}
+		wals = append(wals, walId)
+	}
+	return wals, nil
+}
+
+// setIamPolicyWithRetry reads the IAM policy of the resource, applies modify
+// to it and writes it back if changed. If the write fails because the policy
+// was changed concurrently (etag conflict), the read-modify-write is retried
+// immediately; if GCP rate limits the request, it is retried after a backoff
+// that honors any Retry-After. Either is retried up to maxRetries times.
+func setIamPolicyWithRetry(ctx context.Context, apiHandle *iamutil.ApiHandle, resource iamutil.Resource, maxRetries int, modify func(*iamutil.Policy) (bool, *iamutil.Policy)) (bool, error) {
+	for attempt := 0; ; attempt++ {
+		changed, err := func() (bool, error) {
+			p, err := resource.GetIamPolicy(ctx, apiHandle)
+			if err != nil {
+				return false, err
+			}
+
+			changed, newP := modify(p)
+			if !changed || newP == nil {
+				return false, nil
+			}
+
+			_, err = resource.SetIamPolicy(ctx, apiHandle, newP)
+			return err == nil, err
+		}()
+		if err == nil || attempt >= maxRetries {
+			return changed, err
+		}
+
+		// iamutil wraps API errors, so look for the underlying one.
+		gErr := errwrap.GetType(err, &googleapi.Error{})
+		switch {
+		case isGoogleApiErrorWithCodes(gErr, http.StatusConflict, http.StatusPreconditionFailed):
+		case isRateLimitErr(gErr):
+			if err := sleepContext(ctx, retryDelay(gErr, attempt)); err != nil {
+				return false, errwrap.Wrapf("request aborted while waiting to retry: {{err}}", err)
+			}
+		default:
+			return false, err
+		}
+	}
+}
 
-		p, err := resource.GetIamPolicy(ctx, apiHandle)
+// parseBindingResources parses every bound resource, returning the parsed
+// resources by name, or an error naming each resource the backend doesn't
+// know how to manage.
+func (b *backend) parseBindingResources(rb ResourceBindings) (map[string]iamutil.Resource, error) {
+	var merr *multierror.Error
+	resources := make(map[string]iamutil.Resource, len(rb))
+	for rName := range rb {
+		resource, err := b.resources.Parse(rName)
 		if err != nil {
-			return wals, err
+			merr = multierror.Append(merr, err)
+			continue
 		}
+		resources[rName] = resource
+	}
+	if err := merr.ErrorOrNil(); err != nil {
+		return nil, err
+	}
+	return resources, nil
+}
 
-		changed, newP := p.AddBindings(&iamutil.PolicyDelta{
-			Roles: roles,
-			Email: rs.AccountId.EmailOrId,
-		})
-		if !changed || newP == nil {
+// validateBindingResources checks that every bound resource can be parsed and
+// that the configured credentials have permission to set its IAM policy.
+// Bound resources may belong to any project, not just the project the
+// service account is created in, so each one is checked before any GCP
+// resources are created.
+func (b *backend) validateBindingResources(ctx context.Context, apiHandle *iamutil.ApiHandle, rb ResourceBindings) error {
+	resources, err := b.parseBindingResources(rb)
+	if err != nil {
+		return err
+	}
+
+	var merr *multierror.Error
+	for rName, resource := range resources {
+		// Some resources, such as buckets and datasets, don't have a
+		// testIamPermissions method alongside their IAM policy, so the best
+		// that can be checked is that their policy can be read.
+		if !iamutil.SupportsTestPermissions(resource) {
+			if _, err := resource.GetIamPolicy(ctx, apiHandle); err != nil {
+				merr = multierror.Append(merr, errwrap.Wrapf(fmt.Sprintf("unable to access IAM policy for resource %q: {{err}}", rName), err))
+			}
 			continue
 		}
 
-		if _, err := resource.SetIamPolicy(ctx, apiHandle, newP); err != nil {
-			return wals, err
+		perm := iamutil.SetIamPolicyPermission(resource)
+		granted, err := apiHandle.DoTestPermissionsRequest(ctx, resource, []string{perm})
+		if err != nil {
+			merr = multierror.Append(merr, errwrap.Wrapf(fmt.Sprintf("unable to test permissions on resource %q: {{err}}", rName), err))
+			continue
+		}
+		if !strutil.StrListContains(granted, perm) {
+			merr = multierror.Append(merr, fmt.Errorf("the configured credentials lack %s on resource %q", perm, rName))
 		}
-		wals = append(wals, walId)
 	}
-	return wals, nil
+	return merr.ErrorOrNil()
 }
 
-func roleSetServiceAccountName(rsName string) (name string) {
+// probeBindingResources checks that every bound resource exists and that its
+// IAM policy can be read with the configured credentials, returning an error
+// per resource that tells a missing resource apart from one that cannot be
+// accessed. It is only done when requested, as it reports every resource
+// before any GCP resources are created, where role set writes otherwise stop
+// at the first resource that cannot be bound.
+func (b *backend) probeBindingResources(ctx context.Context, apiHandle *iamutil.ApiHandle, rb ResourceBindings) error {
+	resources, err := b.parseBindingResources(rb)
+	if err != nil {
+		return err
+	}
+
+	rNames := make([]string, 0, len(resources))
+	for rName := range resources {
+		rNames = append(rNames, rName)
+	}
+	sort.Strings(rNames)
+
+	var merr *multierror.Error
+	for _, rName := range rNames {
+		if _, err := resources[rName].GetIamPolicy(ctx, apiHandle); err != nil {
+			merr = multierror.Append(merr, bindingResourceError(rName, err))
+		}
+	}
+	return merr.ErrorOrNil()
+}
+
+// bindingResourceError describes why the IAM policy of a bound resource could
+// not be read, telling a missing resource apart from one the configured
+// credentials cannot access.
+func bindingResourceError(rName string, err error) error {
+	gErr, ok := errwrap.GetType(err, &googleapi.Error{}).(*googleapi.Error)
+	if !ok {
+		return errwrap.Wrapf(fmt.Sprintf("unable to access IAM policy for resource %q: {{err}}", rName), err)
+	}
+
+	switch gErr.Code {
+	case http.StatusNotFound:
+		return fmt.Errorf("resource %q does not exist", rName)
+	case http.StatusForbidden:
+		// GCP also returns 403 for some resources that don't exist, such as
+		// projects, to avoid revealing whether they do.
+		return fmt.Errorf("no access to IAM policy for resource %q, it may not exist or the configured credentials lack permission to get its IAM policy: %s", rName, gErr.Message)
+	default:
+		return errwrap.Wrapf(fmt.Sprintf("unable to access IAM policy for resource %q: {{err}}", rName), gErr)
+	}
+}
+
+// updateTokenCreators changes which members have
+// roles/iam.serviceAccountTokenCreator on the service account itself, adding
+// the role for members in toAdd and removing it for members in toRemove.
+func updateTokenCreators(ctx context.Context, iamAdmin *iam.Service, account *gcputil.ServiceAccountId, toAdd, toRemove []string) error {
+	if account == nil || (len(toAdd) == 0 && len(toRemove) == 0) {
+		return nil
+	}
+
+	p, err := iamAdmin.Projects.ServiceAccounts.GetIamPolicy(account.ResourceName()).Context(ctx).Do()
+	if err != nil {
+		return errwrap.Wrapf("unable to get service account IAM policy: {{err}}", err)
+	}
+
+	members := make(util.StringSet)
+	bindings := make([]*iam.Binding, 0, len(p.Bindings)+1)
+	for _, bind := range p.Bindings {
+		if bind.Role == serviceAccountTokenCreatorRole && bind.Condition == nil {
+			members.Update(bind.Members...)
+			continue
+		}
+		bindings = append(bindings, bind)
+	}
+	members = members.Sub(util.ToSet(toRemove))
+	members.Update(toAdd...)
+	if len(members) > 0 {
+		bindings = append(bindings, &iam.Binding{
+			Role:    serviceAccountTokenCreatorRole,
+			Members: members.ToSlice(),
+		})
+	}
+	p.Bindings = bindings
+
+	_, err = iamAdmin.Projects.ServiceAccounts.SetIamPolicy(account.ResourceName(), &iam.SetIamPolicyRequest{Policy: p}).Context(ctx).Do()
+	if err != nil {
+		return errwrap.Wrapf("unable to set service account IAM policy: {{err}}", err)
+	}
+	return nil
+}
+
+// validateIamMember checks that the given IAM policy member has one of the
+// member types that can be granted a role.
+func validateIamMember(member string) error {
+	tkns := strings.SplitN(member, ":", 2)
+	if len(tkns) != 2 || tkns[1] == "" {
+		return fmt.Errorf("invalid member %q, expected format TYPE:ID", member)
+	}
+	switch tkns[0] {
+	case "user", "group", "serviceAccount", "domain":
+		return nil
+	default:
+		return fmt.Errorf(`invalid member %q, type must be one of "user", "group", "serviceAccount", "domain"`, member)
+	}
+}
+
+// roleSetServiceAccountName generates a service account ID for the role set,
+// made of "vault", the sanitized role set name truncated as needed, and a
+// random suffix of suffixLen characters.
+func roleSetServiceAccountName(rsName string, suffixLen int) (string, error) {
 	// Sanitize role name
 	reg := regexp.MustCompile("[^a-zA-Z0-9-]+")
 	rsName = reg.ReplaceAllString(rsName, "-")
 
-	intSuffix := fmt.Sprintf("%d", time.Now().Unix())
-	fullName := fmt.Sprintf("vault%s-%s", rsName, intSuffix)
-	name = fullName
-	if len(fullName) > serviceAccountMaxLen {
-		toTrunc := len(fullName) - serviceAccountMaxLen
-		name = fmt.Sprintf("vault%s-%s", rsName[:len(rsName)-toTrunc], intSuffix)
+	maxNameLen := serviceAccountMaxLen - len(serviceAccountIdPrefix) - len("-") - suffixLen
+	if maxNameLen < 0 {
+		return "", fmt.Errorf("service account suffix length %d leaves no room for the %q prefix in a %d character service account ID", suffixLen, serviceAccountIdPrefix, serviceAccountMaxLen)
+	}
+	if len(rsName) > maxNameLen {
+		rsName = rsName[:maxNameLen]
+	}
+
+	suffix, err := randomServiceAccountSuffix(suffixLen)
+	if err != nil {
+		return "", errwrap.Wrapf("unable to generate service account ID suffix: {{err}}", err)
+	}
+	return fmt.Sprintf("%s%s-%s", serviceAccountIdPrefix, rsName, suffix), nil
+}
+
+// randomServiceAccountSuffix returns n random characters valid at the end of
+// a service account ID.
+func randomServiceAccountSuffix(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = serviceAccountSuffixChars[int(b[i])%len(serviceAccountSuffixChars)]
 	}
-	return name
+	return string(b), nil
 }
 
 func getStringHash(bindingsRaw string) string {
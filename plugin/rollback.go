@@ -3,6 +3,8 @@ package gcpsecrets
 import (
 	"context"
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/go-gcp-common/gcputil"
@@ -21,6 +23,10 @@ const (
 	walTypeAccount    = "account"
 	walTypeAccountKey = "account_key"
 	walTypeIamPolicy  = "iam_policy"
+
+	// walTypeApplyBindings entries are rolled forward rather than back: they
+	// apply the bindings of a role set created with async_bindings.
+	walTypeApplyBindings = "apply_bindings"
 )
 
 func (b *backend) walRollback(ctx context.Context, req *logical.Request, kind string, data interface{}) error {
@@ -31,20 +37,50 @@ func (b *backend) walRollback(ctx context.Context, req *logical.Request, kind st
 		return b.serviceAccountKeyRollback(ctx, req, data)
 	case walTypeIamPolicy:
 		return b.serviceAccountPolicyRollback(ctx, req, data)
+	case walTypeApplyBindings:
+		return b.applyBindingsRollback(ctx, req, data)
 	default:
 		return fmt.Errorf("unknown type to rollback")
 	}
 }
 
+// decodeWAL decodes WAL entry data, which has been round-tripped through
+// JSON, into the given entry struct.
+func decodeWAL(data interface{}, entry interface{}) error {
+	d, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: mapstructure.StringToTimeHookFunc(time.RFC3339),
+		Result:     entry,
+	})
+	if err != nil {
+		return err
+	}
+	return d.Decode(data)
+}
+
 type walAccount struct {
 	RoleSet string
 	Id      gcputil.ServiceAccountId
+
+	// Disable, if set, disables the service account instead of deleting it.
+	Disable bool
+
+	// DeleteAfter, if set, defers deleting or disabling the service account
+	// until after this time. The role set can be undeleted until then.
+	DeleteAfter time.Time
 }
 
 type walAccountKey struct {
 	RoleSet            string
 	ServiceAccountName string
 	KeyName            string
+
+	// DeleteAfter, if set, defers deletion of the key until after this time.
+	DeleteAfter time.Time
+}
+
+type walApplyBindings struct {
+	RoleSet   string
+	AccountId gcputil.ServiceAccountId
 }
 
 type walIamPolicy struct {
@@ -52,6 +88,10 @@ type walIamPolicy struct {
 	AccountId gcputil.ServiceAccountId
 	Resource  string
 	Roles     []string
+
+	// Members are the roles granted to the role set's additional members,
+	// by role, that are revoked too.
+	Members map[string][]string
 }
 
 func (b *backend) serviceAccountRollback(ctx context.Context, req *logical.Request, data interface{}) error {
@@ -59,13 +99,13 @@ func (b *backend) serviceAccountRollback(ctx context.Context, req *logical.Reque
 	defer b.rolesetLock.Unlock()
 
 	var entry walAccount
-	if err := mapstructure.Decode(data, &entry); err != nil {
+	if err := decodeWAL(data, &entry); err != nil {
 		return err
 	}
 
 	// If account is still being used, WAL entry was not
-	// deleted properly after a successful operation.
-	// Remove WAL entry.
+	// deleted properly after a successful operation, or the
+	// role set was undeleted. Remove WAL entry.
 	rs, err := getRoleSet(entry.RoleSet, ctx, req.Storage)
 	if err != nil {
 		return err
@@ -75,13 +115,28 @@ func (b *backend) serviceAccountRollback(ctx context.Context, req *logical.Reque
 		return nil
 	}
 
+	if time.Now().Before(entry.DeleteAfter) {
+		// Not due yet; replace this entry with a new one to check again on
+		// a later rollback.
+		_, err := framework.PutWAL(ctx, req.Storage, walTypeAccount, &entry)
+		return err
+	}
+
 	// Delete service account.
 	iamC, err := b.IAMAdminClient(req.Storage)
 	if err != nil {
 		return err
 	}
 
-	return b.deleteServiceAccount(ctx, iamC, &entry.Id)
+	if entry.Disable {
+		err = b.disableServiceAccount(ctx, iamC, &entry.Id)
+	} else {
+		err = b.deleteServiceAccount(ctx, iamC, &entry.Id)
+	}
+	if err != nil {
+		return err
+	}
+	return deleteDeletedRoleSet(ctx, req.Storage, entry.RoleSet, &entry.Id)
 }
 
 func (b *backend) serviceAccountKeyRollback(ctx context.Context, req *logical.Request, data interface{}) error {
@@ -89,7 +144,14 @@ func (b *backend) serviceAccountKeyRollback(ctx context.Context, req *logical.Re
 	defer b.rolesetLock.Unlock()
 
 	var entry walAccountKey
-	if err := mapstructure.Decode(data, &entry); err != nil {
+	if err := decodeWAL(data, &entry); err != nil {
+		return err
+	}
+
+	if time.Now().Before(entry.DeleteAfter) {
+		// Not due yet; replace this entry with a new one to check again on
+		// a later rollback.
+		_, err := framework.PutWAL(ctx, req.Storage, walTypeAccountKey, &entry)
 		return err
 	}
 
@@ -103,7 +165,12 @@ func (b *backend) serviceAccountKeyRollback(ctx context.Context, req *logical.Re
 		return err
 	}
 
-	// If roleset is not nil, get key in use.
+	// If roleset is not nil, get key in use. A role set that was deleted
+	// and recreated under the same name has a different service account,
+	// and doesn't keep the keys of the old one from being deleted.
+	if rs != nil && rs.AccountId != nil && entry.ServiceAccountName != "" && rs.AccountId.ResourceName() != entry.ServiceAccountName {
+		rs = nil
+	}
 	if rs != nil {
 		if rs.SecretType == SecretTypeAccessToken {
 			// Don't clean keys if roleset generates key secrets.
@@ -123,7 +190,7 @@ func (b *backend) serviceAccountKeyRollback(ctx context.Context, req *logical.Re
 	if entry.KeyName == "" {
 		// If given an empty key name, this means the WAL entry was created before the key was created.
 		// We list all keys and then delete any not in use by the current roleset.
-		keys, err := iamC.Projects.ServiceAccounts.Keys.List(entry.ServiceAccountName).KeyTypes("USER_MANAGED").Do()
+		keys, err := listUserManagedKeys(ctx, iamC, entry.ServiceAccountName)
 		if err != nil {
 			// If service account already deleted, no need to clean up keys.
 			if isGoogleAccountNotFoundErr(err) {
@@ -132,13 +199,13 @@ func (b *backend) serviceAccountKeyRollback(ctx context.Context, req *logical.Re
 			return err
 		}
 
-		for _, k := range keys.Keys {
+		for _, k := range keys {
 			// Skip deleting keys still in use (empty keyInUse means no key is in use)
 			if k.Name == keyInUse {
 				continue
 			}
 
-			_, err = iamC.Projects.ServiceAccounts.Keys.Delete(entry.KeyName).Do()
+			_, err = iamC.Projects.ServiceAccounts.Keys.Delete(entry.KeyName).Context(ctx).Do()
 			if err != nil && !isGoogleAccountKeyNotFoundErr(err) {
 				return err
 			}
@@ -151,10 +218,22 @@ func (b *backend) serviceAccountKeyRollback(ctx context.Context, req *logical.Re
 		return nil
 	}
 
-	_, err = iamC.Projects.ServiceAccounts.Keys.Delete(entry.KeyName).Do()
+	_, err = iamC.Projects.ServiceAccounts.Keys.Delete(entry.KeyName).Context(ctx).Do()
 	if err != nil && !isGoogleAccountKeyNotFoundErr(err) {
 		return err
 	}
+
+	// The key may still be tracked, such as the old key of key/:roleset/rotate,
+	// whose lease is left to expire; stop tracking it along with any
+	// replacement created for it by rotation.
+	if entry.RoleSet != "" {
+		if err := b.deletePendingRotatedKey(ctx, req.Storage, entry.RoleSet, entry.KeyName); err != nil {
+			return err
+		}
+		if err := deleteKeyLease(ctx, req.Storage, entry.RoleSet, entry.KeyName); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -181,6 +260,23 @@ func (b *backend) serviceAccountPolicyRollback(ctx context.Context, req *logical
 			rolesToRemove = rolesToRemove.Sub(currRoles)
 		}
 	}
+	// Members are kept by the role set across service accounts.
+	membersToRemove := roleMembersFromWAL(entry.Members)
+	if rs != nil {
+		membersToRemove = membersToRemove.sub(rs.AddedMembers[entry.Resource])
+
+		// The role set may have been recreated under the same name before
+		// this entry was rolled back, and found members it wants already
+		// granted by its predecessor. It takes them over rather than lose
+		// them, so they're removed along with it instead.
+		if takeover := membersToRemove.intersect(rs.desiredMembers(entry.Resource)); len(takeover) > 0 {
+			rs.addAddedMembers(entry.Resource, takeover)
+			if err := rs.save(ctx, req.Storage); err != nil {
+				return err
+			}
+			membersToRemove = membersToRemove.sub(takeover)
+		}
+	}
 
 	r, err := b.resources.Parse(entry.Resource)
 	if err != nil {
@@ -197,33 +293,40 @@ func (b *backend) serviceAccountPolicyRollback(ctx context.Context, req *logical
 		return err
 	}
 
-	p, err := r.GetIamPolicy(ctx, apiHandle)
-	if err != nil {
-		return err
-	}
-
-	changed, newP := p.RemoveBindings(
-		&iamutil.PolicyDelta{
-			Email: entry.AccountId.EmailOrId,
-			Roles: rolesToRemove,
-		})
+	_, err = setIamPolicyWithRetry(ctx, apiHandle, r, b.maxBindingRetries(ctx, req.Storage), func(p *iamutil.Policy) (bool, *iamutil.Policy) {
+		changedAccount, p := p.RemoveBindings(
+			&iamutil.PolicyDelta{
+				Email: entry.AccountId.EmailOrId,
+				Roles: rolesToRemove,
+			})
+		changedMembers, p := p.RemoveMembers(membersToRemove)
+		return changedAccount || changedMembers, p
+	})
+	return err
+}
 
-	if !changed {
+func (b *backend) deleteServiceAccount(ctx context.Context, iamAdmin *iam.Service, account *gcputil.ServiceAccountId) error {
+	if account == nil || account.EmailOrId == "" {
 		return nil
 	}
 
-	_, err = r.SetIamPolicy(ctx, apiHandle, newP)
-	return err
+	_, err := iamAdmin.Projects.ServiceAccounts.Delete(account.ResourceName()).Context(ctx).Do()
+	if err != nil && !isGoogleAccountNotFoundErr(err) {
+		return errwrap.Wrapf("unable to delete service account: {{err}}", err)
+	}
+	return nil
 }
 
-func (b *backend) deleteServiceAccount(ctx context.Context, iamAdmin *iam.Service, account *gcputil.ServiceAccountId) error {
+// disableServiceAccount disables the service account, keeping it and its
+// history in GCP while preventing it from authenticating.
+func (b *backend) disableServiceAccount(ctx context.Context, iamAdmin *iam.Service, account *gcputil.ServiceAccountId) error {
 	if account == nil || account.EmailOrId == "" {
 		return nil
 	}
 
-	_, err := iamAdmin.Projects.ServiceAccounts.Delete(account.ResourceName()).Do()
+	_, err := iamAdmin.Projects.ServiceAccounts.Disable(account.ResourceName(), &iam.DisableServiceAccountRequest{}).Context(ctx).Do()
 	if err != nil && !isGoogleAccountNotFoundErr(err) {
-		return errwrap.Wrapf("unable to delete service account: {{err}}", err)
+		return errwrap.Wrapf("unable to disable service account: {{err}}", err)
 	}
 	return nil
 }
@@ -233,40 +336,63 @@ func (b *backend) deleteTokenGenKey(ctx context.Context, iamAdmin *iam.Service,
 		return nil
 	}
 
-	_, err := iamAdmin.Projects.ServiceAccounts.Keys.Delete(tgen.KeyName).Do()
+	_, err := iamAdmin.Projects.ServiceAccounts.Keys.Delete(tgen.KeyName).Context(ctx).Do()
 	if err != nil && !isGoogleAccountKeyNotFoundErr(err) {
 		return errwrap.Wrapf("unable to delete service account key: {{err}}", err)
 	}
 	return nil
 }
 
-func (b *backend) removeBindings(ctx context.Context, apiHandle *iamutil.ApiHandle, email string, bindings ResourceBindings) (allErr *multierror.Error) {
-	for resName, roles := range bindings {
-		resource, err := b.resources.Parse(resName)
-		if err != nil {
-			allErr = multierror.Append(allErr, errwrap.Wrapf(fmt.Sprintf("unable to delete role binding for resource '%s': {{err}}", resName), err))
-			continue
+// removeBindings removes the given bindings of the account, along with the
+// given roles of additional members, one IAM policy update per resource, in
+// order of resource name and paced to the configured binding_removal_rate.
+// It returns the resources whose bindings couldn't be removed, sorted, with
+// the reasons.
+func (b *backend) removeBindings(ctx context.Context, s logical.Storage, apiHandle *iamutil.ApiHandle, email string, bindings ResourceBindings, members map[string]RoleMembers) (lingering []string, allErr *multierror.Error) {
+	maxRetries := b.maxBindingRetries(ctx, s)
+	interval := b.bindingRemovalInterval(ctx, s)
+
+	resNames := make([]string, 0, len(bindings))
+	for resName := range bindings {
+		resNames = append(resNames, resName)
+	}
+	sort.Strings(resNames)
+
+	for i, resName := range resNames {
+		if err := ctx.Err(); err != nil {
+			allErr = multierror.Append(allErr, errwrap.Wrapf("request aborted while removing role bindings: {{err}}", err))
+			return append(lingering, resNames[i:]...), allErr
+		}
+		if i > 0 && interval > 0 {
+			if err := sleepContext(ctx, interval); err != nil {
+				allErr = multierror.Append(allErr, errwrap.Wrapf("request aborted while removing role bindings: {{err}}", err))
+				return append(lingering, resNames[i:]...), allErr
+			}
 		}
 
-		p, err := resource.GetIamPolicy(ctx, apiHandle)
+		resource, err := b.resources.Parse(resName)
 		if err != nil {
+			lingering = append(lingering, resName)
 			allErr = multierror.Append(allErr, errwrap.Wrapf(fmt.Sprintf("unable to delete role binding for resource '%s': {{err}}", resName), err))
 			continue
 		}
 
-		changed, newP := p.RemoveBindings(&iamutil.PolicyDelta{
-			Email: email,
-			Roles: roles,
+		roles := bindings[resName]
+		_, err = setIamPolicyWithRetry(ctx, apiHandle, resource, maxRetries, func(p *iamutil.Policy) (bool, *iamutil.Policy) {
+			changedAccount, p := p.RemoveBindings(&iamutil.PolicyDelta{
+				Email: email,
+				Roles: roles,
+			})
+			changedMembers, p := p.RemoveMembers(members[resName])
+			return changedAccount || changedMembers, p
 		})
-		if !changed {
-			continue
-		}
-		if _, err = resource.SetIamPolicy(ctx, apiHandle, newP); err != nil {
+		if err != nil {
+			lingering = append(lingering, resName)
 			allErr = multierror.Append(allErr, errwrap.Wrapf(fmt.Sprintf("unable to delete role binding for resource '%s': {{err}}", resName), err))
 			continue
 		}
 	}
-	return
+	return lingering, allErr
 }
 
 // This tries to clean up WALs that are no longer needed.
@@ -285,10 +411,36 @@ func isGoogleAccountNotFoundErr(err error) bool {
 	return isGoogleApiErrorWithCodes(err, 404)
 }
 
+// isGoogleAccountKeyNotFoundErr also takes a 403 as the key being gone, as
+// GCP returns for keys of deleted service accounts, except for requests
+// blocked by VPC Service Controls, which say nothing about the key.
 func isGoogleAccountKeyNotFoundErr(err error) bool {
+	if denied, _ := vpcServiceControlsDenial(err); denied {
+		return false
+	}
 	return isGoogleApiErrorWithCodes(err, 403, 404)
 }
 
+// listUserManagedKeys lists the user-managed keys of a service account.
+// Google-managed keys are never returned, so callers cleaning up keys can't
+// try to delete them; they are filtered out here too in case the API ignores
+// the filter.
+func listUserManagedKeys(ctx context.Context, iamC *iam.Service, saName string) ([]*iam.ServiceAccountKey, error) {
+	resp, err := iamC.Projects.ServiceAccounts.Keys.List(saName).KeyTypes(keyTypeUserManaged).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*iam.ServiceAccountKey, 0, len(resp.Keys))
+	for _, k := range resp.Keys {
+		if k.KeyType != "" && k.KeyType != keyTypeUserManaged {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
 func isGoogleApiErrorWithCodes(err error, validErrCodes ...int) bool {
 	if err == nil {
 		return false
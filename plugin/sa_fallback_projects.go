@@ -0,0 +1,87 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iam/v1"
+)
+
+// isServiceAccountQuotaError returns whether creating a service account
+// failed because its project has as many service accounts as it may.
+func isServiceAccountQuotaError(err error) bool {
+	gErr, ok := errwrap.GetType(err, &googleapi.Error{}).(*googleapi.Error)
+	if !ok || gErr == nil {
+		return false
+	}
+	msg := strings.ToLower(gErr.Message)
+	if strings.Contains(msg, "maximum number of service accounts") {
+		return true
+	}
+	return gErr.Code == http.StatusTooManyRequests && strings.Contains(msg, "quota")
+}
+
+// newServiceAccountWithFallback creates the role set's service account in
+// project or, if the project has reached its service account quota, in each
+// of the config's sa_fallback_projects in turn. It returns the WAL entry of
+// the new account and, if it is in a fallback project, a warning saying so.
+// The role set's bindings are unaffected, and its project stays the one
+// given, recorded in RequestedProject when the account is elsewhere.
+func (b *backend) newServiceAccountWithFallback(ctx context.Context, s logical.Storage, iamAdmin *iam.Service, rs *RoleSet, project string, cfg *config) (string, string, error) {
+	projects := append([]string{project}, cfg.serviceAccountFallbackProjects(project)...)
+	for i, p := range projects {
+		walId, err := rs.newServiceAccount(ctx, s, iamAdmin, p, cfg.serviceAccountNameTemplate(), cfg.serviceAccountSuffixLength(), cfg.serviceAccountIdAttempts())
+		if err == nil {
+			rs.RequestedProject = ""
+			if p == project {
+				return walId, "", nil
+			}
+			rs.RequestedProject = project
+			b.Logger().Warn("created service account in fallback project", "roleset", rs.Name, "project", project, "service_account_project", p)
+			return walId, fmt.Sprintf("project %q has reached its service account quota, so the service account was created in sa_fallback_projects project %q", project, p), nil
+		}
+		if i == len(projects)-1 || !isServiceAccountQuotaError(err) {
+			return walId, "", err
+		}
+
+		// No account was created, so there's nothing for rollback to do.
+		if walId != "" {
+			framework.DeleteWAL(ctx, s, walId)
+		}
+		b.Logger().Warn("project reached its service account quota, trying the next of sa_fallback_projects", "roleset", rs.Name, "project", p, "error", err)
+	}
+	return "", "", nil
+}
+
+// serviceAccountFallbackProjects returns the projects to create service
+// accounts in, in order, when project has reached its service account quota.
+func (c *config) serviceAccountFallbackProjects(project string) []string {
+	if c == nil {
+		return nil
+	}
+	projects := make([]string, 0, len(c.ServiceAccountFallbackProjects))
+	for _, p := range c.ServiceAccountFallbackProjects {
+		if p != project {
+			projects = append(projects, p)
+		}
+	}
+	return projects
+}
+
+// project returns the project the role set was created for, which its
+// service account is in unless it was created in a fallback project.
+func (rs *RoleSet) project() string {
+	if rs.RequestedProject != "" {
+		return rs.RequestedProject
+	}
+	if rs.AccountId == nil {
+		return ""
+	}
+	return rs.AccountId.Project
+}
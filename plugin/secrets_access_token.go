@@ -7,10 +7,25 @@ import (
 	"time"
 
 	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/util"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"google.golang.org/api/iamcredentials/v1"
+)
+
+const (
+	// gcpMaxAccessTokenTTL is the longest lifetime GCP grants an access token.
+	gcpMaxAccessTokenTTL = time.Hour
+
+	// tokenTTLWarnSlack is how much shorter than requested a token's lifetime
+	// may be, from time spent generating it, before a warning is returned.
+	tokenTTLWarnSlack = 30 * time.Second
+
+	// Values of time_format, how token expiry is presented.
+	timeFormatUnix    = "unix"
+	timeFormatRFC3339 = "rfc3339"
 )
 
 func pathSecretAccessToken(b *backend) *framework.Path {
@@ -21,6 +36,31 @@ func pathSecretAccessToken(b *backend) *framework.Path {
 				Type:        framework.TypeString,
 				Description: "Required. Name of the role set.",
 			},
+			"scopes": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: "Subset of the role set's token_scopes to restrict the token to. Defaults to all of the role set's scopes.",
+			},
+			"services": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: `GCP service names, such as "storage" or "bigquery", whose scopes are added to "scopes". Each of their scopes must be in the role set's token_scopes.`,
+			},
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Requested lifetime of the token, which GCP grants it. Capped to the role set's max_token_ttl and to the one hour GCP allows. Defaults to one hour.",
+			},
+			"lifetime": {
+				Type:        framework.TypeDurationSecond,
+				Description: `Alias for "ttl".`,
+			},
+			"force_new": {
+				Type:        framework.TypeBool,
+				Description: "If true, a new token is generated even if a cached one is still valid, and replaces it in the cache.",
+			},
+			"wait_for_propagation": {
+				Type:        framework.TypeBool,
+				Description: fmt.Sprintf("If true, after generating the token, wait up to %s until the role set's service account has the permissions of its bound roles, so the token works right away. Permissions still missing then are returned as a warning.", propagationWaitTimeout),
+			},
+			"time_format": timeFormatSchema(),
 		},
 		ExistenceCheck: b.pathRoleSetExistenceCheck("roleset"),
 		Operations: map[logical.Operation]framework.OperationHandler{
@@ -32,9 +72,120 @@ func pathSecretAccessToken(b *backend) *framework.Path {
 	}
 }
 
+func pathSecretAccessTokenExecCredential(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: fmt.Sprintf("token/%s/exec-credential", framework.GenericNameRegex("roleset")),
+		Fields: map[string]*framework.FieldSchema{
+			"roleset": {
+				Type:        framework.TypeString,
+				Description: "Required. Name of the role set.",
+			},
+		},
+		ExistenceCheck: b.pathRoleSetExistenceCheck("roleset"),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation: &framework.PathOperation{Callback: b.pathAccessTokenExecCredential},
+		},
+		HelpSynopsis:    pathTokenExecCredentialHelpSyn,
+		HelpDescription: pathTokenExecCredentialHelpDesc,
+	}
+}
+
 func (b *backend) pathAccessToken(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
 	rsName := d.Get("roleset").(string)
 
+	timeFormat, err := getTimeFormat(d)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	var scopes []string
+	if scopesRaw, ok := d.GetOk("scopes"); ok {
+		scopes = scopesRaw.([]string)
+	}
+	if servicesRaw, ok := d.GetOk("services"); ok {
+		serviceScopes, err := scopesForServices(servicesRaw.([]string))
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		scopes = appendUniqueScopes(scopes, serviceScopes...)
+	}
+
+	var ttl time.Duration
+	ttlRaw, hasTTL := d.GetOk("ttl")
+	if hasTTL {
+		ttl = time.Duration(ttlRaw.(int)) * time.Second
+	}
+	if lifetimeRaw, ok := d.GetOk("lifetime"); ok {
+		lifetime := time.Duration(lifetimeRaw.(int)) * time.Second
+		if hasTTL && lifetime != ttl {
+			return logical.ErrorResponse("ttl and lifetime are aliases and cannot be given different values"), nil
+		}
+		ttl = lifetime
+	}
+
+	forceNew := false
+	if forceNewRaw, ok := d.GetOk("force_new"); ok {
+		forceNew = forceNewRaw.(bool)
+	}
+
+	resp, err := b.accessTokenForRoleSet(ctx, req, rsName, scopes, ttl, forceNew)
+	if err != nil || resp == nil || resp.IsError() {
+		return resp, err
+	}
+	// Paths that reuse this handler may not have every field.
+	if wait, ok := d.GetOk("wait_for_propagation"); ok && wait.(bool) {
+		rs, err := getRoleSet(rsName, ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if rs != nil {
+			if warn := b.waitForPropagation(ctx, req.Storage, rs); warn != "" {
+				resp.AddWarning(warn)
+			}
+		}
+	}
+	formatExpiry(resp, timeFormat)
+	return resp, nil
+}
+
+func timeFormatSchema() *framework.FieldSchema {
+	return &framework.FieldSchema{
+		Type:        framework.TypeString,
+		Description: fmt.Sprintf(`How the token's expiry is presented. With %q, "expires_at" gives it as an RFC 3339 timestamp in addition to "expires_at_seconds". Defaults to %q.`, timeFormatRFC3339, timeFormatUnix),
+	}
+}
+
+// getTimeFormat returns the request's time_format, which paths without the
+// field don't have.
+func getTimeFormat(d *framework.FieldData) (string, error) {
+	raw, ok := d.GetOk("time_format")
+	if !ok {
+		return timeFormatUnix, nil
+	}
+	switch format := raw.(string); format {
+	case "", timeFormatUnix:
+		return timeFormatUnix, nil
+	case timeFormatRFC3339:
+		return format, nil
+	default:
+		return "", fmt.Errorf("invalid time_format %q, must be %q or %q", format, timeFormatUnix, timeFormatRFC3339)
+	}
+}
+
+// formatExpiry adds "expires_at" to a token response if timeFormat asks for
+// it. "expires_at_seconds" is always kept.
+func formatExpiry(resp *logical.Response, timeFormat string) {
+	expiresAt, ok := resp.Data["expires_at_seconds"].(int64)
+	if !ok || timeFormat != timeFormatRFC3339 {
+		return
+	}
+	resp.Data["expires_at"] = time.Unix(expiresAt, 0).UTC().Format(time.RFC3339)
+}
+
+// accessTokenForRoleSet generates an access token for the named role set,
+// restricted to scopes if given, which must be a subset of the role set's
+// token_scopes.
+func (b *backend) accessTokenForRoleSet(ctx context.Context, req *logical.Request, rsName string, scopes []string, ttl time.Duration, forceNew bool) (*logical.Response, error) {
 	rs, err := getRoleSet(rsName, ctx, req.Storage)
 	if err != nil {
 		return nil, err
@@ -46,40 +197,328 @@ func (b *backend) pathAccessToken(ctx context.Context, req *logical.Request, d *
 	if rs.SecretType != SecretTypeAccessToken {
 		return logical.ErrorResponse("role set '%s' cannot generate access tokens (has secret type %s)", rsName, rs.SecretType), nil
 	}
+	if resp, err := b.checkIssuanceAllowed(req, rs); resp != nil || err != nil {
+		return resp, err
+	}
+	if resp, err := b.checkRequiredMetadata(req, rs); resp != nil || err != nil {
+		return resp, err
+	}
+
+	if len(scopes) > 0 && rs.TokenGen != nil {
+		cfg, err := getConfig(ctx, req.Storage)
+		if err != nil {
+			return nil, errwrap.Wrapf("could not read backend config: {{err}}", err)
+		}
+		// Required scopes are always granted, so asking for them is fine.
+		allowed := util.ToSet(cfg.withRequiredScopes(rs.TokenGen.Scopes))
+		for _, scope := range scopes {
+			if !allowed.Includes(scope) {
+				return logical.ErrorResponse("scope %q is not in role set '%s' token_scopes", scope, rsName), nil
+			}
+		}
+	}
 
-	return b.secretAccessTokenResponse(ctx, req.Storage, rs)
+	tokenExpiry, err := b.tokenTTLCap(ctx, req)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	var capWarning string
+	if !tokenExpiry.IsZero() {
+		effectiveTTL, _ := accessTokenTTL(ttl, rs.MaxTokenTTL)
+		if capped, warning := capTTLToExpiry(effectiveTTL, tokenExpiry); capped < effectiveTTL {
+			ttl, capWarning = capped, warning
+		}
+	}
+
+	resp, err := b.secretAccessTokenResponse(ctx, req.Storage, rs, scopes, ttl, forceNew)
+	if err != nil || resp.IsError() {
+		return resp, err
+	}
+	if capWarning != "" {
+		resp.AddWarning(capWarning)
+	}
+	return resp, nil
+}
+
+func (b *backend) pathAccessTokenExecCredential(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	resp, err := b.pathAccessToken(ctx, req, d)
+	if err != nil || resp == nil {
+		return resp, err
+	}
+
+	if resp.IsError() {
+		return &logical.Response{
+			Data: map[string]interface{}{
+				"version": execCredentialVersion,
+				"success": false,
+				"code":    "VAULT_ERROR",
+				"message": resp.Error().Error(),
+			},
+		}, nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"version":         execCredentialVersion,
+			"success":         true,
+			"token_type":      "Bearer",
+			"access_token":    resp.Data["token"],
+			"expiration_time": resp.Data["expires_at_seconds"],
+		},
+		Warnings: resp.Warnings,
+	}, nil
 }
 
-func (b *backend) secretAccessTokenResponse(ctx context.Context, s logical.Storage, rs *RoleSet) (*logical.Response, error) {
+// secretAccessTokenResponse generates an access token for the role set. If
+// scopes is empty, the token has all of the role set's scopes. If ttl is
+// zero, the token gets the longest lifetime allowed. When token caching is
+// enabled, a cached token is returned instead unless forceNew is set.
+func (b *backend) secretAccessTokenResponse(ctx context.Context, s logical.Storage, rs *RoleSet, scopes []string, ttl time.Duration, forceNew bool) (*logical.Response, error) {
 	if rs.TokenGen == nil || rs.TokenGen.KeyName == "" {
 		return logical.ErrorResponse("invalid role set has no service account key, must be updated (path roleset/%s/rotate-key) before generating new secrets", rs.Name), nil
 	}
 
-	token, err := rs.TokenGen.getAccessToken(ctx)
+	cfg, err := getConfig(ctx, s)
+	if err != nil {
+		return nil, errwrap.Wrapf("could not read backend config: {{err}}", err)
+	}
+	var warnings []string
+	scopes, warn, err := b.accessTokenScopes(ctx, s, cfg, rs, scopes)
 	if err != nil {
-		return logical.ErrorResponse("unable to generate token - make sure your roleset service account and key are still valid: %v", err), nil
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if warn != "" {
+		warnings = append(warnings, warn)
+	}
+
+	effectiveTTL, reason := accessTokenTTL(ttl, rs.MaxTokenTTL)
+	release, err := b.claimEphemeralRoleSet(ctx, s, rs, effectiveTTL)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	// Ephemeral role sets issue a single token, so there is nothing to reuse.
+	cacheTokens := !rs.Ephemeral && b.cacheTokens(ctx, s)
+	cacheKey := tokenCacheKey(rs, scopes)
+	maxExpiry := time.Now().Add(effectiveTTL)
+
+	generate := b.roleSetTokenGenerator(ctx, s, rs, scopes, effectiveTTL)
+	// Concurrent requests for the same token share a single GCP call. The
+	// lifetime is part of the key so no request gets a longer-lived token
+	// than it asked for.
+	if cfg.coalesceTokenRequests() && !rs.Ephemeral {
+		// The call is shared with other requests, so it can't be canceled
+		// with the request that happens to make it.
+		generateOnce := func() (*oauth2.Token, error) {
+			callCtx, cancel := context.WithTimeout(context.Background(), cfg.apiTimeout())
+			defer cancel()
+			return b.roleSetTokenGenerator(callCtx, s, rs, scopes, effectiveTTL)()
+		}
+		callKey := fmt.Sprintf("%s\x00%d", cacheKey, effectiveTTL/time.Second)
+		generate = func() (*oauth2.Token, error) {
+			token, _, err := b.tokenCalls.do(ctx, callKey, generateOnce)
+			return token, err
+		}
 	}
 
+	var token *oauth2.Token
+	if cacheTokens && !forceNew {
+		token = b.tokens.get(cacheKey, maxExpiry)
+	}
+	cached := token != nil
+	if !cached {
+		if cacheTokens && cfg.sharedTokenCache() {
+			token, cached, err = b.sharedAccessToken(ctx, s, cacheKey, maxExpiry, forceNew, generate)
+		} else {
+			token, err = generate()
+		}
+		if err == logical.ErrReadOnly {
+			release()
+			return nil, err
+		}
+		if err != nil {
+			release()
+			b.recordIssuance(ctx, s, rs, err)
+			return logical.ErrorResponse("unable to generate token - make sure your roleset service account and key are still valid: %v", err), nil
+		}
+		if cacheTokens {
+			b.tokens.put(cacheKey, token)
+		}
+	}
+	grantedTTL := token.Expiry.UTC().Sub(time.Now().UTC()).Round(time.Second)
+
+	requestedTTL := ttl
+	if requestedTTL <= 0 && rs.MaxTokenTTL > 0 {
+		requestedTTL = gcpMaxAccessTokenTTL
+	}
+	if requestedTTL > 0 && requestedTTL-grantedTTL > tokenTTLWarnSlack {
+		switch {
+		case cached:
+			reason = "a cached token was returned, pass force_new to generate a new one"
+		case reason == "" || effectiveTTL-grantedTTL > tokenTTLWarnSlack:
+			// The expiry is the one GCP returned, which may be shorter than
+			// what was asked for, whatever capped the request.
+			reason = fmt.Sprintf("GCP granted a shorter lifetime than the %s asked for", effectiveTTL)
+		}
+		warnings = append(warnings, fmt.Sprintf("requested token TTL of %s was reduced to %s: %s", requestedTTL, grantedTTL, reason))
+	}
+	if warn := rs.bindingsPendingWarning(); warn != "" {
+		warnings = append(warnings, warn)
+	}
+
+	expiry := alignExpiry(token.Expiry, b.tokenExpiryAlignment(ctx, s), time.Now())
+	data := map[string]interface{}{
+		"token":              token.AccessToken,
+		"token_ttl":          expiry.UTC().Sub(time.Now().UTC()) / (time.Second),
+		"expires_at_seconds": expiry.Unix(),
+		"cached":             cached,
+		"scopes":             scopes,
+	}
+	if rs.AccountUniqueId != "" {
+		data["service_account_unique_id"] = rs.AccountUniqueId
+	}
+
+	b.recordIssuance(ctx, s, rs, nil)
 	return &logical.Response{
-		Data: map[string]interface{}{
-			"token":              token.AccessToken,
-			"token_ttl":          token.Expiry.UTC().Sub(time.Now().UTC()) / (time.Second),
-			"expires_at_seconds": token.Expiry.Unix(),
-		},
+		Data:     data,
+		Warnings: warnings,
+	}, nil
+}
+
+// alignExpiry rounds a token expiry down to a multiple of alignment, so that
+// it is never later than the real one. If that would put it at or before now,
+// the exact expiry is returned instead.
+func alignExpiry(expiry time.Time, alignment time.Duration, now time.Time) time.Time {
+	if alignment <= 0 {
+		return expiry
+	}
+	if aligned := expiry.Truncate(alignment); aligned.After(now) {
+		return aligned
+	}
+	return expiry
+}
+
+// roleSetTokenScopes returns the token_scopes of an access token role set. If
+// it has none, which only role sets stored before they were required can,
+// the defaults per the config's empty_scopes_behavior are returned with a
+// warning, or an error if there are none.
+func (b *backend) roleSetTokenScopes(ctx context.Context, s logical.Storage, rs *RoleSet) ([]string, string, error) {
+	if rs.TokenGen != nil && len(rs.TokenGen.Scopes) > 0 {
+		return rs.TokenGen.Scopes, "", nil
+	}
+	cfg, err := getConfig(ctx, s)
+	if err != nil {
+		return nil, "", errwrap.Wrapf("could not read backend config: {{err}}", err)
+	}
+	scopes, warn, err := cfg.defaultTokenScopes()
+	if err != nil {
+		return nil, "", fmt.Errorf("role set '%s' has no token_scopes, update it with some before generating tokens (%v)", rs.Name, err)
+	}
+	return scopes, warn, nil
+}
+
+// accessTokenScopes returns the scopes to generate a role set's access token
+// with, and cache it under: the requested scopes, or the role set's own per
+// roleSetTokenScopes if none are, plus the config's required scopes.
+func (b *backend) accessTokenScopes(ctx context.Context, s logical.Storage, cfg *config, rs *RoleSet, scopes []string) ([]string, string, error) {
+	var warn string
+	if len(scopes) == 0 {
+		var err error
+		if scopes, warn, err = b.roleSetTokenScopes(ctx, s, rs); err != nil {
+			return nil, "", err
+		}
+	}
+	return cfg.withRequiredScopes(scopes), warn, nil
+}
+
+// roleSetTokenGenerator returns a function generating access tokens for the
+// role set with the given scopes and lifetime, counting each generation in
+// the role set's usage.
+func (b *backend) roleSetTokenGenerator(ctx context.Context, s logical.Storage, rs *RoleSet, scopes []string, ttl time.Duration) func() (*oauth2.Token, error) {
+	return func() (*oauth2.Token, error) {
+		token, err := b.generateRoleSetToken(ctx, s, rs, scopes, ttl)
+		b.usage.record(rs.Name, usageTokenGenerations, 1)
+		return token, err
+	}
+}
+
+// accessTokenTTL returns the lifetime to request for an access token given
+// the requested ttl (zero for the longest allowed) and the role set's
+// max_token_ttl, with the reason if it is shorter than requested.
+func accessTokenTTL(ttl, maxTokenTTL time.Duration) (time.Duration, string) {
+	effectiveTTL, reason := ttl, ""
+	if effectiveTTL <= 0 {
+		effectiveTTL = gcpMaxAccessTokenTTL
+	}
+	if maxTokenTTL > 0 && effectiveTTL > maxTokenTTL {
+		effectiveTTL, reason = maxTokenTTL, fmt.Sprintf("role set max_token_ttl is %s", maxTokenTTL)
+	}
+	if effectiveTTL > gcpMaxAccessTokenTTL {
+		effectiveTTL, reason = gcpMaxAccessTokenTTL, fmt.Sprintf("GCP access tokens last at most %s", gcpMaxAccessTokenTTL)
+	}
+	return effectiveTTL, reason
+}
+
+// generateRoleSetToken generates an access token for the role set that lives
+// for ttl. Google's token endpoint always grants tokens minted with the role
+// set's key an hour, so tokens that must expire sooner are generated through
+// the IAM Credentials API with the requested lifetime instead, using the
+// backend's credentials. The returned expiry is the one GCP granted.
+func (b *backend) generateRoleSetToken(ctx context.Context, s logical.Storage, rs *RoleSet, scopes []string, ttl time.Duration) (*oauth2.Token, error) {
+	if ttl <= 0 || ttl >= gcpMaxAccessTokenTTL {
+		ctx, err := proxyContext(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+		return rs.TokenGen.getAccessToken(ctx, scopes)
+	}
+
+	// Lifetimes are whole seconds; round down so the token never outlives
+	// the cap.
+	lifetime := int64(ttl / time.Second)
+	if lifetime <= 0 {
+		return nil, fmt.Errorf("token lifetime %s is shorter than one second", ttl)
+	}
+	if len(scopes) == 0 {
+		scopes = rs.TokenGen.Scopes
+	}
+	credsC, err := b.IAMCredentialsClient(s)
+	if err != nil {
+		return nil, errwrap.Wrapf("could not create IAM Credentials client: {{err}}", err)
+	}
+	resp, err := credsC.Projects.ServiceAccounts.GenerateAccessToken(signingAccountName(rs), &iamcredentials.GenerateAccessTokenRequest{
+		Scope:    scopes,
+		Lifetime: fmt.Sprintf("%ds", lifetime),
+	}).Context(ctx).Do()
+	if err != nil {
+		return nil, errwrap.Wrapf("unable to generate a token with a lifetime under one hour through the IAM Credentials API: {{err}}", err)
+	}
+	expiry, err := time.Parse(time.RFC3339, resp.ExpireTime)
+	if err != nil {
+		return nil, errwrap.Wrapf("invalid token expiry from IAM Credentials API: {{err}}", err)
+	}
+	return &oauth2.Token{
+		AccessToken: resp.AccessToken,
+		TokenType:   "Bearer",
+		Expiry:      expiry,
 	}, nil
 }
 
-func (tg *TokenGenerator) getAccessToken(ctx context.Context) (*oauth2.Token, error) {
+// getAccessToken mints an access token with the role set's key. Google's
+// token endpoint grants these tokens an hour.
+func (tg *TokenGenerator) getAccessToken(ctx context.Context, scopes []string) (*oauth2.Token, error) {
 	jsonBytes, err := base64.StdEncoding.DecodeString(tg.B64KeyJSON)
 	if err != nil {
 		return nil, errwrap.Wrapf("could not b64-decode key data: {{err}}", err)
 	}
 
-	cfg, err := google.JWTConfigFromJSON(jsonBytes, tg.Scopes...)
+	if len(scopes) == 0 {
+		scopes = tg.Scopes
+	}
+	cfg, err := google.JWTConfigFromJSON(jsonBytes, scopes...)
 	if err != nil {
 		return nil, errwrap.Wrapf("could not generate token JWT config: {{err}}", err)
 	}
-
 	tkn, err := cfg.TokenSource(ctx).Token()
 	if err != nil {
 		return nil, errwrap.Wrapf("got error while creating OAuth2 token: {{err}}", err)
@@ -94,6 +533,10 @@ engine and will be cleaned up now. Note that there is the chance that this acces
 will still be valid up to one hour.
 `
 
+// execCredentialVersion is the version of the executable credential
+// response format returned by token/:roleset/exec-credential.
+const execCredentialVersion = 1
+
 const pathTokenHelpSyn = `Generate an OAuth2 access token under a specific role set.`
 const pathTokenHelpDesc = `
 This path will generate a new OAuth2 access token for accessing GCP APIs.
@@ -102,13 +545,73 @@ by name - for example, if this backend is mounted at "gcp",
 then "gcp/token/deploy" would generate tokens for the "deploy" role set.
 
 On the backend, each roleset is associated with a service account.
-The token will be associated with this service account. Tokens have a
-short-term lease (1-hour) associated with them but cannot be renewed.
+The token will be associated with this service account.
+
+Tokens are minted by the backend itself: each access token role set holds
+a single key for its service account, created with the role set, and signs
+a JWT assertion with it that Google's token endpoint exchanges for a token.
+No key is created per token. The key is only replaced by writing to
+roleset/:name/rotate-key, or when the role set gets a new service account.
+
+Tokens are returned as plain data, with their expiry in "token_ttl" and
+"expires_at_seconds", and no Vault lease is created for them: GCP access
+tokens can't be revoked before they expire, so a lease would add nothing.
+With "time_format" set to "rfc3339", the expiry is also returned as an
+RFC 3339 timestamp in "expires_at".
+The caller is fully responsible for the token once it is issued, including
+not using it past its expiry and requesting a new one when it expires.
+
+By default a token has all of the role set's token_scopes. Passing "scopes"
+restricts it to a subset of them; any scope not in token_scopes is rejected.
+The config's "required_scopes" are added to every token either way.
+GCP services can be named in "services" instead, such as "storage-read",
+and their scopes are added to "scopes"; see the role set's token_services.
+The scopes the token was generated with, after all of the above, are
+returned in "scopes".
+
+A lifetime can be requested with "ttl", or its alias "lifetime". It is
+capped to the role set's "max_token_ttl", if set, and to the one hour GCP
+allows, and to the remaining TTL of the requesting Vault token if
+"cap_ttl_to_token" is set on the config endpoint. Tokens minted with the
+role set's key always last an hour, so tokens that must expire sooner are
+generated through the IAM Credentials API with that lifetime instead. This
+requires the backend's credentials to be allowed to create tokens for the
+role set's service account (roles/iam.serviceAccountTokenCreator). Whenever the token
+lives shorter than requested (or than one hour, for role sets with a
+"max_token_ttl"), a warning gives the requested and granted lifetimes and
+the reason.
+
+If "cache_tokens" is enabled on the config endpoint, a token generated
+earlier for the same role set and scopes is returned while it has at least
+five minutes left, and "cached" is true. Passing "force_new" always generates
+a new token, which then replaces the cached one.
+
+IAM bindings can take a while to take effect, so a token issued right after
+a role set is created or its bindings change may fail its first calls.
+Passing "wait_for_propagation" makes the request call testIamPermissions as
+the service account, with backoff for up to two minutes, until it has the
+permissions of the roles bound on each resource, and only then return the
+token. Only the permissions testable on each resource are checked, and
+permissions still missing when the wait ends are returned as a warning.
 
 Please see backend documentation for more information:
 https://www.vaultproject.io/docs/secrets/gcp/index.html
 `
 
+const pathTokenExecCredentialHelpSyn = `Generate an OAuth2 access token in gcloud's executable credential format.`
+const pathTokenExecCredentialHelpDesc = `
+This path generates a new OAuth2 access token under a role set, like the
+token/ path, but returns it in the JSON structure expected from an executable
+credential source, so gcloud and Application Default Credentials can consume
+Vault-issued tokens directly:
+
+	{"version": 1, "success": true, "token_type": "Bearer",
+	 "access_token": "...", "expiration_time": 1234567890}
+
+Failures to generate a token are reported with "success" set to false and a
+"message" describing the error.
+`
+
 // EVERYTHING USING THIS SECRET TYPE IS CURRENTLY DEPRECATED.
 // We keep it to allow for clean up of access_token secrets/leases that may have be left over
 // by older versions of Vault.
@@ -0,0 +1,174 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	// tokenBatchWorkers bounds how many tokens a batch request generates
+	// concurrently.
+	tokenBatchWorkers = 8
+
+	// maxTokenBatchSize is the most role sets a batch request may name.
+	maxTokenBatchSize = 100
+)
+
+func pathSecretAccessTokenBatch(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "token-batch",
+		Fields: map[string]*framework.FieldSchema{
+			"rolesets": {
+				Type:        framework.TypeCommaStringSlice,
+				Description: fmt.Sprintf("Required. Names of the role sets to generate tokens for, at most %d.", maxTokenBatchSize),
+			},
+			"scopes": {
+				Type:        framework.TypeKVPairs,
+				Description: "Map of role set name to a comma-separated subset of its token_scopes to restrict its token to. Role sets not listed get all of their scopes.",
+			},
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Requested lifetime of the tokens, capped per role set as for token/:roleset. Defaults to one hour.",
+			},
+			"time_format": timeFormatSchema(),
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{Callback: b.pathAccessTokenBatch},
+		},
+		HelpSynopsis:    pathTokenBatchHelpSyn,
+		HelpDescription: pathTokenBatchHelpDesc,
+	}
+}
+
+func (b *backend) pathAccessTokenBatch(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	rsNames := d.Get("rolesets").([]string)
+	scopesByRoleSet := d.Get("scopes").(map[string]string)
+	ttl := time.Duration(d.Get("ttl").(int)) * time.Second
+
+	timeFormat, err := getTimeFormat(d)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	if len(rsNames) == 0 {
+		return logical.ErrorResponse("rolesets is required"), nil
+	}
+	if len(rsNames) > maxTokenBatchSize {
+		return logical.ErrorResponse(fmt.Sprintf("at most %d role sets may be given, got %d", maxTokenBatchSize, len(rsNames))), nil
+	}
+	seen := make(map[string]bool, len(rsNames))
+	for _, rsName := range rsNames {
+		if seen[rsName] {
+			return logical.ErrorResponse(fmt.Sprintf("role set '%s' is given more than once", rsName)), nil
+		}
+		seen[rsName] = true
+	}
+	for rsName := range scopesByRoleSet {
+		if !seen[rsName] {
+			return logical.ErrorResponse(fmt.Sprintf("scopes given for role set '%s', which is not in rolesets", rsName)), nil
+		}
+	}
+
+	var mu sync.Mutex
+	tokens := make(map[string]interface{}, len(rsNames))
+	errs := make(map[string]string)
+	var warnings []string
+	readOnly := false
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, tokenBatchWorkers)
+	for _, rsName := range rsNames {
+		var scopes []string
+		if raw := scopesByRoleSet[rsName]; raw != "" {
+			for _, scope := range strings.Split(raw, ",") {
+				if scope = strings.TrimSpace(scope); scope != "" {
+					scopes = append(scopes, scope)
+				}
+			}
+		}
+
+		wg.Add(1)
+		go func(rsName string, scopes []string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			resp, err := b.batchAccessToken(ctx, req, rsName, scopes, ttl)
+
+			mu.Lock()
+			defer mu.Unlock()
+			switch {
+			case err == logical.ErrReadOnly:
+				readOnly = true
+			case err != nil:
+				errs[rsName] = err.Error()
+			case resp == nil:
+				errs[rsName] = "no token generated"
+			case resp.IsError():
+				errs[rsName] = resp.Error().Error()
+			default:
+				formatExpiry(resp, timeFormat)
+				tokens[rsName] = resp.Data
+				for _, w := range resp.Warnings {
+					warnings = append(warnings, fmt.Sprintf("role set '%s': %s", rsName, w))
+				}
+			}
+		}(rsName, scopes)
+	}
+	wg.Wait()
+
+	// On a performance standby, tokens missing from the shared token cache
+	// can only be generated by the active node, so the whole request is
+	// forwarded there.
+	if readOnly {
+		return nil, logical.ErrReadOnly
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"tokens": tokens,
+			"errors": errs,
+		},
+		Warnings: warnings,
+	}, nil
+}
+
+// batchAccessToken generates the token of a role set for token-batch, which
+// only role sets with allow_token_batch set give tokens to.
+func (b *backend) batchAccessToken(ctx context.Context, req *logical.Request, rsName string, scopes []string, ttl time.Duration) (*logical.Response, error) {
+	rs, err := getRoleSet(rsName, ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if rs != nil && !rs.AllowTokenBatch {
+		return logical.ErrorResponse("role set '%s' does not allow token-batch, read token/%s instead", rsName, rsName), nil
+	}
+	return b.accessTokenForRoleSet(ctx, req, rsName, scopes, ttl, false)
+}
+
+const pathTokenBatchHelpSyn = `Generate OAuth2 access tokens for several role sets in one request.`
+const pathTokenBatchHelpDesc = `
+This path generates an access token for each role set in "rolesets", as if
+token/:roleset were read for each, and returns them in "tokens" keyed by role
+set name. Tokens are generated concurrently, a few at a time.
+
+"scopes" optionally maps role set names to a comma-separated subset of their
+token_scopes. "ttl" applies to every token, capped per role set by its
+"max_token_ttl" and GCP's one hour limit.
+
+Only role sets with "allow_token_batch" set are given tokens. Access to this
+path is access to tokens for all of those role sets, as powerful as read
+access to each of their token/:roleset paths, since Vault policies can't
+restrict which role sets a request names. Grant it only to callers that may
+get tokens for every such role set.
+
+A role set that fails to generate a token doesn't fail the batch; its error
+is returned in "errors" instead. On a performance standby, the request is
+forwarded to the active node if any token has to be generated there.
+`
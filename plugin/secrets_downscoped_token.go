@@ -0,0 +1,212 @@
+package gcpsecrets
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-cleanhttp"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+const (
+	stsTokenURL = "https://sts.googleapis.com/v1/token"
+
+	stsGrantTypeTokenExchange = "urn:ietf:params:oauth:grant-type:token-exchange"
+	stsTokenTypeAccessToken   = "urn:ietf:params:oauth:token-type:access_token"
+
+	// maxAccessBoundaryRules is the number of rules GCP allows in a single
+	// Credential Access Boundary.
+	maxAccessBoundaryRules = 10
+)
+
+// accessBoundaryRule is a single rule of a Credential Access Boundary.
+type accessBoundaryRule struct {
+	AvailableResource     string                 `json:"availableResource"`
+	AvailablePermissions  []string               `json:"availablePermissions"`
+	AvailabilityCondition *availabilityCondition `json:"availabilityCondition,omitempty"`
+}
+
+type availabilityCondition struct {
+	Expression  string `json:"expression"`
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+func pathSecretAccessTokenDownscoped(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: fmt.Sprintf("token/%s/downscoped", framework.GenericNameRegex("roleset")),
+		Fields: map[string]*framework.FieldSchema{
+			"roleset": {
+				Type:        framework.TypeString,
+				Description: "Required. Name of the role set.",
+			},
+			"access_boundary_rules": {
+				Type: framework.TypeString,
+				Description: `Required. JSON list of Credential Access Boundary rules, each with "availableResource", ` +
+					`"availablePermissions" and an optional "availabilityCondition".`,
+			},
+			"time_format": timeFormatSchema(),
+		},
+		ExistenceCheck: b.pathRoleSetExistenceCheck("roleset"),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{Callback: b.pathAccessTokenDownscoped},
+		},
+		HelpSynopsis:    pathTokenDownscopedHelpSyn,
+		HelpDescription: pathTokenDownscopedHelpDesc,
+	}
+}
+
+func (b *backend) pathAccessTokenDownscoped(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	rulesRaw := d.Get("access_boundary_rules").(string)
+	if rulesRaw == "" {
+		return logical.ErrorResponse("access_boundary_rules is required"), nil
+	}
+	rules, err := parseAccessBoundaryRules(rulesRaw)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid access_boundary_rules: %v", err)), nil
+	}
+
+	timeFormat, err := getTimeFormat(d)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	resp, err := b.pathAccessToken(ctx, req, d)
+	if err != nil || resp == nil || resp.IsError() {
+		return resp, err
+	}
+
+	token, err := exchangeDownscopedToken(ctx, resp.Data["token"].(string), rules)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("unable to downscope token: %v", b.withGoogleRequestID(req.Path, err))), nil
+	}
+	now := time.Now()
+	expiry := alignExpiry(now.Add(time.Duration(token.ExpiresIn)*time.Second), b.tokenExpiryAlignment(ctx, req.Storage), now)
+	downscoped := &logical.Response{
+		Data: map[string]interface{}{
+			"token":              token.AccessToken,
+			"token_ttl":          int64(expiry.Sub(now) / time.Second),
+			"expires_at_seconds": expiry.Unix(),
+		},
+		Warnings: resp.Warnings,
+	}
+	formatExpiry(downscoped, timeFormat)
+	return downscoped, nil
+}
+
+// parseAccessBoundaryRules parses and validates a JSON list of Credential
+// Access Boundary rules.
+func parseAccessBoundaryRules(raw string) ([]*accessBoundaryRule, error) {
+	var rules []*accessBoundaryRule
+	if err := json.Unmarshal([]byte(raw), &rules); err != nil {
+		return nil, errwrap.Wrapf("unable to parse JSON: {{err}}", err)
+	}
+	if len(rules) == 0 {
+		return nil, errors.New("at least one rule is required")
+	}
+	if len(rules) > maxAccessBoundaryRules {
+		return nil, fmt.Errorf("at most %d rules are allowed, got %d", maxAccessBoundaryRules, len(rules))
+	}
+
+	for i, rule := range rules {
+		if rule == nil || rule.AvailableResource == "" {
+			return nil, fmt.Errorf("rule %d: availableResource is required", i)
+		}
+		if len(rule.AvailablePermissions) == 0 {
+			return nil, fmt.Errorf("rule %d: availablePermissions is required", i)
+		}
+		for _, perm := range rule.AvailablePermissions {
+			if !strings.HasPrefix(perm, "inRole:") {
+				return nil, fmt.Errorf("rule %d: permission %q must be of the form \"inRole:<role>\"", i, perm)
+			}
+		}
+		if rule.AvailabilityCondition != nil && rule.AvailabilityCondition.Expression == "" {
+			return nil, fmt.Errorf("rule %d: availabilityCondition must have an expression", i)
+		}
+	}
+	return rules, nil
+}
+
+type stsTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// exchangeDownscopedToken exchanges the given access token for one restricted
+// by the given access boundary rules, using the Security Token Service.
+func exchangeDownscopedToken(ctx context.Context, token string, rules []*accessBoundaryRule) (*stsTokenResponse, error) {
+	options, err := json.Marshal(map[string]interface{}{
+		"accessBoundary": map[string]interface{}{
+			"accessBoundaryRules": rules,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":           {stsGrantTypeTokenExchange},
+		"subject_token_type":   {stsTokenTypeAccessToken},
+		"requested_token_type": {stsTokenTypeAccessToken},
+		"subject_token":        {token},
+		"options":              {string(options)},
+	}
+	httpReq, err := http.NewRequest(http.MethodPost, stsTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	httpResp, err := cleanhttp.DefaultClient().Do(httpReq.WithContext(ctx))
+	if err != nil {
+		return nil, errwrap.Wrapf("STS request failed: {{err}}", err)
+	}
+	defer httpResp.Body.Close()
+
+	body, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, errwrap.Wrapf("unable to read STS response: {{err}}", err)
+	}
+
+	var stsResp stsTokenResponse
+	if err := json.Unmarshal(body, &stsResp); err != nil {
+		return nil, fmt.Errorf("unable to parse STS response (HTTP %d): %v", httpResp.StatusCode, err)
+	}
+	if httpResp.StatusCode != http.StatusOK || stsResp.AccessToken == "" {
+		return nil, fmt.Errorf("STS returned HTTP %d: %s: %s", httpResp.StatusCode, stsResp.Error, stsResp.ErrorDescription)
+	}
+	return &stsResp, nil
+}
+
+const pathTokenDownscopedHelpSyn = `Generate an OAuth2 access token downscoped with a Credential Access Boundary.`
+const pathTokenDownscopedHelpDesc = `
+This path generates an access token for the role set, as "token/:roleset"
+does, and exchanges it with the Security Token Service for a token that is
+further restricted by a Credential Access Boundary. This lets a client get
+a token limited to, for example, read access to a single Cloud Storage
+bucket.
+
+The boundary is given as "access_boundary_rules", a JSON list of rules:
+
+  [{
+    "availableResource": "//storage.googleapis.com/projects/_/buckets/my-bucket",
+    "availablePermissions": ["inRole:roles/storage.objectViewer"],
+    "availabilityCondition": {
+      "expression": "resource.name.startsWith('projects/_/buckets/my-bucket/objects/logs/')"
+    }
+  }]
+
+Downscoped tokens cannot grant more access than the role set's own token.
+`
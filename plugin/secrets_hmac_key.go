@@ -0,0 +1,249 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/storage/v1"
+)
+
+const (
+	SecretTypeHMACKey    = "hmac_key"
+	hmacKeyStateInactive = "INACTIVE"
+)
+
+func secretHMACKey(b *backend) *framework.Secret {
+	return &framework.Secret{
+		Type: SecretTypeHMACKey,
+		Fields: map[string]*framework.FieldSchema{
+			"access_id": {
+				Type:        framework.TypeString,
+				Description: "Access ID of the HMAC key",
+			},
+			"secret": {
+				Type:        framework.TypeString,
+				Description: "Base-64 encoded HMAC key secret",
+			},
+		},
+
+		Renew:  b.secretHMACKeyRenew,
+		Revoke: b.secretHMACKeyRevoke,
+	}
+}
+
+func pathSecretHMACKey(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: fmt.Sprintf("hmac-key/%s", framework.GenericNameRegex("roleset")),
+		Fields: map[string]*framework.FieldSchema{
+			"roleset": {
+				Type:        framework.TypeString,
+				Description: "Required. Name of the role set.",
+			},
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Lifetime of the HMAC key",
+			},
+		},
+		ExistenceCheck: b.pathRoleSetExistenceCheck("roleset"),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation:   &framework.PathOperation{Callback: b.pathHMACKey},
+			logical.UpdateOperation: &framework.PathOperation{Callback: b.pathHMACKey},
+		},
+		HelpSynopsis:    pathHMACKeySyn,
+		HelpDescription: pathHMACKeyDesc,
+	}
+}
+
+func (b *backend) pathHMACKey(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	rsName := d.Get("roleset").(string)
+	ttl := d.Get("ttl").(int)
+
+	rs, err := getRoleSet(rsName, ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if rs == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role set '%s' does not exist", rsName)), nil
+	}
+
+	if rs.SecretType != SecretTypeHMACKey {
+		return logical.ErrorResponse(fmt.Sprintf("role set '%s' cannot generate HMAC keys (has secret type %s)", rsName, rs.SecretType)), nil
+	}
+	if rs.AccountId == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role set '%s' is invalid, has no associated service account", rsName)), nil
+	}
+	if resp, err := b.checkIssuanceAllowed(req, rs); resp != nil || err != nil {
+		return resp, err
+	}
+
+	ttl, tokenExpiry, capWarning, err := b.capLeaseTTLToToken(ctx, req, ttl)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	resp, err := b.getSecretHMACKey(ctx, req.Storage, rs, ttl)
+	if err != nil || resp.IsError() {
+		return resp, err
+	}
+	recordTokenTTLCap(resp, tokenExpiry, capWarning)
+	return resp, nil
+}
+
+func (b *backend) getSecretHMACKey(ctx context.Context, s logical.Storage, rs *RoleSet, ttl int) (*logical.Response, error) {
+	cfg, err := getConfig(ctx, s)
+	if err != nil {
+		return nil, errwrap.Wrapf("could not read backend config: {{err}}", err)
+	}
+	if cfg == nil {
+		cfg = &config{}
+	}
+
+	storageC, err := b.StorageClient(s)
+	if err != nil {
+		return nil, errwrap.Wrapf("could not create Cloud Storage client: {{err}}", err)
+	}
+
+	release, err := b.claimEphemeralRoleSet(ctx, s, rs, b.secretLeaseTTL(cfg, ttl))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	key, err := storageC.Projects.HmacKeys.Create(rs.AccountId.Project, rs.AccountId.EmailOrId).Context(ctx).Do()
+	if err != nil {
+		release()
+		return logical.ErrorResponse(fmt.Sprintf("unable to create HMAC key: %v", b.withGoogleRequestID("hmac-key/"+rs.Name, err))), nil
+	}
+
+	secretD := map[string]interface{}{
+		"access_id": key.Metadata.AccessId,
+		"secret":    key.Secret,
+	}
+	internalD := map[string]interface{}{
+		"access_id":         key.Metadata.AccessId,
+		"project":           rs.AccountId.Project,
+		"role_set":          rs.Name,
+		"role_set_bindings": rs.bindingHash(),
+	}
+
+	resp := b.Secret(SecretTypeHMACKey).Response(secretD, internalD)
+	resp.Secret.Renewable = !rs.Ephemeral
+
+	resp.Secret.MaxTTL = cfg.MaxTTL
+	resp.Secret.TTL = cfg.TTL
+
+	// If the request came with a TTL value, overwrite the config default
+	if ttl > 0 {
+		resp.Secret.TTL = time.Duration(ttl) * time.Second
+	}
+	if warn := rs.bindingsPendingWarning(); warn != "" {
+		resp.AddWarning(warn)
+	}
+
+	return resp, nil
+}
+
+func (b *backend) secretHMACKeyRenew(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	rsName, err := secretRoleSetName(ctx, req.Storage, req.Secret)
+	if err != nil {
+		return nil, err
+	}
+	if rsName == "" {
+		return nil, fmt.Errorf("invalid secret, internal data is missing role set name")
+	}
+	bindingSum, ok := req.Secret.InternalData["role_set_bindings"]
+	if !ok {
+		return nil, fmt.Errorf("invalid secret, internal data is missing role set checksum")
+	}
+
+	rs, err := getRoleSet(rsName, ctx, req.Storage)
+	if err != nil || rs == nil {
+		return logical.ErrorResponse(fmt.Sprintf("could not find role set '%v' for secret", rsName)), nil
+	}
+	if rs.bindingHash() != bindingSum.(string) {
+		return logical.ErrorResponse(fmt.Sprintf("role set '%v' bindings were updated since secret was generated, cannot renew", rsName)), nil
+	}
+
+	cfg, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		cfg = &config{}
+	}
+
+	resp := &logical.Response{Secret: req.Secret}
+	resp.Secret.TTL = cfg.TTL
+	resp.Secret.MaxTTL = cfg.MaxTTL
+	if _, ok := req.Secret.InternalData[tokenExpireTimeKey]; ok {
+		ttl := b.secretLeaseTTL(cfg, 0)
+		ttl, capWarning, err := capRenewalToToken(req, ttl)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+		resp.Secret.TTL = ttl
+		if capWarning != "" {
+			resp.AddWarning(capWarning)
+		}
+	}
+	return resp, nil
+}
+
+func (b *backend) secretHMACKeyRevoke(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	accessId, ok := req.Secret.InternalData["access_id"].(string)
+	if !ok {
+		return nil, fmt.Errorf("secret is missing access_id internal data")
+	}
+	project, ok := req.Secret.InternalData["project"].(string)
+	if !ok {
+		return nil, fmt.Errorf("secret is missing project internal data")
+	}
+
+	storageC, err := b.StorageClient(req.Storage)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	// HMAC keys must be deactivated before they can be deleted.
+	_, err = storageC.Projects.HmacKeys.Update(project, accessId, &storage.HmacKeyMetadata{
+		State: hmacKeyStateInactive,
+	}).Context(ctx).Do()
+	if err != nil {
+		if isGoogleApiErrorWithCodes(err, http.StatusNotFound) {
+			return nil, nil
+		}
+		return logical.ErrorResponse(fmt.Sprintf("unable to deactivate HMAC key: %v", b.withGoogleRequestID(req.Path, err))), nil
+	}
+
+	err = storageC.Projects.HmacKeys.Delete(project, accessId).Context(ctx).Do()
+	if err != nil && !isGoogleApiErrorWithCodes(err, http.StatusNotFound) {
+		return logical.ErrorResponse(fmt.Sprintf("unable to delete HMAC key: %v", b.withGoogleRequestID(req.Path, err))), nil
+	}
+	return nil, nil
+}
+
+// validateStorageAPI checks that HMAC keys can be managed in the given
+// project, i.e. that the Cloud Storage API is enabled and reachable with the
+// configured credentials.
+func (b *backend) validateStorageAPI(ctx context.Context, s logical.Storage, project string) error {
+	storageC, err := b.StorageClient(s)
+	if err != nil {
+		return err
+	}
+	if _, err := storageC.Projects.HmacKeys.List(project).MaxResults(1).Context(ctx).Do(); err != nil {
+		return fmt.Errorf("unable to use Cloud Storage API in project %q, HMAC keys cannot be generated: %v", project, err)
+	}
+	return nil
+}
+
+const pathHMACKeySyn = `Generate a Cloud Storage HMAC key under a specific role set.`
+const pathHMACKeyDesc = `
+This path will generate a new Cloud Storage HMAC key for the role set's
+service account. HMAC keys are used to access Cloud Storage through its
+S3-compatible XML API. The role set must have secret type "hmac_key".
+
+The response contains the key's access ID and secret. When the lease is
+revoked the key is deactivated and then deleted.
+`
@@ -0,0 +1,127 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/helper/strutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/iamcredentials/v1"
+)
+
+// audienceHostRegex matches audiences given as a bare host name, such as an
+// OAuth client ID ("1234-abc.apps.googleusercontent.com").
+var audienceHostRegex = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([a-zA-Z0-9-]*[a-zA-Z0-9])?)+$`)
+
+func pathSecretIdentityToken(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: fmt.Sprintf("identity-token/%s", framework.GenericNameRegex("roleset")),
+		Fields: map[string]*framework.FieldSchema{
+			"roleset": {
+				Type:        framework.TypeString,
+				Description: "Required. Name of the role set.",
+			},
+			"audience": {
+				Type:        framework.TypeString,
+				Description: "Audience of the ID token, such as the URL of a Cloud Run service. Defaults to the role set's default_audience.",
+			},
+			"include_email": {
+				Type:        framework.TypeBool,
+				Description: `If true, the token includes the service account's email in the "email" and "email_verified" claims.`,
+			},
+		},
+		ExistenceCheck: b.pathRoleSetExistenceCheck("roleset"),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation:   &framework.PathOperation{Callback: b.pathIdentityToken},
+			logical.UpdateOperation: &framework.PathOperation{Callback: b.pathIdentityToken},
+		},
+		HelpSynopsis:    pathIdentityTokenHelpSyn,
+		HelpDescription: pathIdentityTokenHelpDesc,
+	}
+}
+
+func (b *backend) pathIdentityToken(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	rs, resp, err := b.getSigningRoleSet(ctx, req, d.Get("roleset").(string))
+	if rs == nil {
+		return resp, err
+	}
+
+	audience := d.Get("audience").(string)
+	if audience == "" {
+		audience = rs.DefaultAudience
+	}
+	if audience == "" {
+		return logical.ErrorResponse(fmt.Sprintf("audience is required, role set '%s' has no default_audience", rs.Name)), nil
+	}
+	if err := validateAudience(audience); err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if !audienceAllowed(rs.AllowedAudiences, audience) {
+		return logical.ErrorResponse(fmt.Sprintf("audience %q is not allowed for role set '%s', must be one of: %s", audience, rs.Name, strings.Join(rs.AllowedAudiences, ", "))), nil
+	}
+
+	credsC, err := b.IAMCredentialsClient(req.Storage)
+	if err != nil {
+		return nil, errwrap.Wrapf("could not create IAM Credentials client: {{err}}", err)
+	}
+
+	token, err := credsC.Projects.ServiceAccounts.GenerateIdToken(signingAccountName(rs), &iamcredentials.GenerateIdTokenRequest{
+		Audience:     audience,
+		IncludeEmail: d.Get("include_email").(bool),
+	}).Context(ctx).Do()
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("unable to generate ID token: %v", b.withGoogleRequestID(req.Path, err))), nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"token":    token.Token,
+			"audience": audience,
+		},
+	}, nil
+}
+
+// validateAudience checks that an ID token audience is either an absolute
+// URL or a host name.
+func validateAudience(audience string) error {
+	if strings.TrimSpace(audience) == "" {
+		return fmt.Errorf("audience cannot be empty")
+	}
+	if strings.ContainsAny(audience, " \t\r\n") {
+		return fmt.Errorf("invalid audience %q, cannot contain whitespace", audience)
+	}
+	if u, err := url.Parse(audience); err == nil && u.Scheme != "" && u.Host != "" {
+		return nil
+	}
+	if audienceHostRegex.MatchString(audience) {
+		return nil
+	}
+	return fmt.Errorf("invalid audience %q, must be an absolute URL such as \"https://my-service.a.run.app\" or a host name", audience)
+}
+
+// audienceAllowed returns whether audience is in allowed. An empty list
+// allows any audience.
+func audienceAllowed(allowed []string, audience string) bool {
+	return len(allowed) == 0 || strutil.StrListContains(allowed, audience)
+}
+
+const pathIdentityTokenHelpSyn = `Generate a Google-signed OpenID Connect ID token for a role set's service account.`
+const pathIdentityTokenHelpDesc = `
+This path generates an ID token for the role set's service account through
+the IAM Credentials API, for authenticating to services such as Cloud Run,
+Cloud Functions or Identity-Aware Proxy. The token is not leased and
+expires after an hour. Since nothing leased is issued, ephemeral role sets,
+which may only issue a single secret, can't be used.
+
+The token's audience is taken from "audience", or from the role set's
+"default_audience" if not given. It must be an absolute URL or a host name.
+If the role set has "allowed_audiences", the audience must be one of them.
+
+The backend's credentials must have roles/iam.serviceAccountTokenCreator on
+the role set's service account.
+`
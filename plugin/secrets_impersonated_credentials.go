@@ -0,0 +1,102 @@
+package gcpsecrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// impersonationPathFormat is the path, under the IAM Credentials API
+// endpoint, that client libraries call to generate access tokens for an
+// impersonated account.
+const impersonationPathFormat = "v1/projects/-/serviceAccounts/%s:generateAccessToken"
+
+func pathSecretImpersonatedCredentials(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: fmt.Sprintf("token/%s/impersonated-credentials", framework.GenericNameRegex("roleset")),
+		Fields: map[string]*framework.FieldSchema{
+			"roleset": {
+				Type:        framework.TypeString,
+				Description: "Required. Name of the role set.",
+			},
+			"source_credentials": {
+				Type:        framework.TypeString,
+				Description: `JSON credentials the client impersonates the role set's service account with, such as its own "authorized_user" or "service_account" credentials. They are embedded as-is and not stored.`,
+			},
+		},
+		ExistenceCheck: b.pathRoleSetExistenceCheck("roleset"),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation:   &framework.PathOperation{Callback: b.pathImpersonatedCredentials},
+			logical.UpdateOperation: &framework.PathOperation{Callback: b.pathImpersonatedCredentials},
+		},
+		HelpSynopsis:    pathImpersonatedCredentialsHelpSyn,
+		HelpDescription: pathImpersonatedCredentialsHelpDesc,
+	}
+}
+
+func (b *backend) pathImpersonatedCredentials(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	rs, resp, err := b.getSigningRoleSet(ctx, req, d.Get("roleset").(string))
+	if rs == nil {
+		return resp, err
+	}
+	cfg, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := map[string]interface{}{
+		"type":                              "impersonated_service_account",
+		"service_account_impersonation_url": cfg.credentialsEndpoint() + fmt.Sprintf(impersonationPathFormat, rs.AccountId.EmailOrId),
+		"delegates":                         []string{},
+	}
+
+	resp = &logical.Response{}
+	if raw := d.Get("source_credentials").(string); raw != "" {
+		var source map[string]interface{}
+		if err := json.Unmarshal([]byte(raw), &source); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid source_credentials, must be a JSON object: %v", err)), nil
+		}
+		if t, _ := source["type"].(string); t == "" {
+			return logical.ErrorResponse(`invalid source_credentials, missing "type"`), nil
+		}
+		creds["source_credentials"] = source
+	} else {
+		resp.AddWarning(`no source_credentials given; client libraries require "source_credentials" in the file, set to the credentials of a member of the role set's token_creators`)
+	}
+	if len(rs.TokenCreators) == 0 {
+		resp.AddWarning(fmt.Sprintf("role set '%s' has no token_creators, so no client can impersonate its service account unless granted roles/iam.serviceAccountTokenCreator on it outside of Vault", rs.Name))
+	}
+
+	credsJSON, err := json.Marshal(creds)
+	if err != nil {
+		return nil, err
+	}
+	resp.Data = map[string]interface{}{
+		"credentials":           string(credsJSON),
+		"service_account_email": rs.AccountId.EmailOrId,
+	}
+	return resp, nil
+}
+
+const pathImpersonatedCredentialsHelpSyn = `Generate a credential file that impersonates a role set's service account.`
+const pathImpersonatedCredentialsHelpDesc = `
+This path returns, in "credentials", a credential configuration of type
+"impersonated_service_account" for the role set's service account, which
+can be saved to the file named by GOOGLE_APPLICATION_CREDENTIALS. Client
+libraries and gcloud then generate short-lived access tokens for the
+service account themselves, for as long as the client may impersonate it,
+instead of being handed a single token.
+
+The file holds no secret of the role set. Tokens are generated with the
+client's own credentials, given in "source_credentials" and embedded in
+the file, which must belong to a member of the role set's token_creators
+(or otherwise have roles/iam.serviceAccountTokenCreator on the service
+account). Without "source_credentials" the file is returned without them,
+for the client to add.
+
+Tokens generated this way are not issued by Vault, so they aren't counted,
+cached or capped by the role set's token settings.
+`
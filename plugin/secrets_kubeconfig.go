@@ -0,0 +1,217 @@
+package gcpsecrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/util"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// gkeAuthScopes are the scopes GKE accepts access tokens for; a token needs
+// one of them to authenticate to a cluster.
+var gkeAuthScopes = []string{cloudPlatformScope, scopePrefix + "userinfo.email"}
+
+// gkeNameRe matches GKE cluster names and locations, so they can be put in
+// the cluster's resource name as they are.
+var gkeNameRe = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+func pathSecretAccessTokenKubeconfig(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: fmt.Sprintf("token/%s/kubeconfig", framework.GenericNameRegex("roleset")),
+		Fields: map[string]*framework.FieldSchema{
+			"roleset": {
+				Type:        framework.TypeString,
+				Description: "Required. Name of the role set.",
+			},
+			"cluster": {
+				Type:        framework.TypeString,
+				Description: "Required. Name of the GKE cluster.",
+			},
+			"location": {
+				Type:        framework.TypeString,
+				Description: "Required. Region or zone of the GKE cluster, e.g. us-central1 or us-central1-a.",
+			},
+			"project": {
+				Type:        framework.TypeString,
+				Description: "Project of the GKE cluster. Defaults to the role set's project.",
+			},
+			"private_endpoint": {
+				Type:        framework.TypeBool,
+				Description: "If true, the kubeconfig uses the cluster's private endpoint instead of its public one.",
+			},
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Description: "Requested lifetime of the token, which GCP grants it. Capped to the role set's max_token_ttl and to the one hour GCP allows. Defaults to one hour.",
+			},
+			"time_format": timeFormatSchema(),
+		},
+		ExistenceCheck: b.pathRoleSetExistenceCheck("roleset"),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation:   &framework.PathOperation{Callback: b.pathAccessTokenKubeconfig},
+			logical.UpdateOperation: &framework.PathOperation{Callback: b.pathAccessTokenKubeconfig},
+		},
+		HelpSynopsis:    pathTokenKubeconfigHelpSyn,
+		HelpDescription: pathTokenKubeconfigHelpDesc,
+	}
+}
+
+func (b *backend) pathAccessTokenKubeconfig(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	rsName := d.Get("roleset").(string)
+	clusterName := d.Get("cluster").(string)
+	location := d.Get("location").(string)
+	var fe fieldErrors
+	switch {
+	case clusterName == "":
+		fe.add("cluster", "cluster is required")
+	case !gkeNameRe.MatchString(clusterName):
+		fe.add("cluster", "invalid cluster %q, must be lowercase letters, digits and hyphens", clusterName)
+	}
+	switch {
+	case location == "":
+		fe.add("location", "location is required")
+	case !gkeNameRe.MatchString(location):
+		fe.add("location", "invalid location %q, must be a region or zone such as us-central1 or us-central1-a", location)
+	}
+	if project := d.Get("project").(string); strings.Contains(project, "/") {
+		fe.add("project", "invalid project %q", project)
+	}
+	if resp := fe.response(); resp != nil {
+		return resp, nil
+	}
+
+	project := d.Get("project").(string)
+	if project == "" {
+		rs, err := getRoleSet(rsName, ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if rs == nil {
+			return logical.ErrorResponse("role set '%s' does not exist", rsName), nil
+		}
+		project = rs.project()
+	}
+
+	containerC, err := b.ContainerClient(req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("projects/%s/locations/%s/clusters/%s", project, location, clusterName)
+	cluster, err := containerC.Projects.Locations.Clusters.Get(name).Context(ctx).Do()
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("unable to get cluster %s: %v", name, b.withGoogleRequestID(name, err))), nil
+	}
+
+	endpoint := cluster.Endpoint
+	if d.Get("private_endpoint").(bool) {
+		endpoint = ""
+		if cluster.PrivateClusterConfig != nil {
+			endpoint = cluster.PrivateClusterConfig.PrivateEndpoint
+		}
+		if endpoint == "" {
+			return logical.ErrorResponse(fmt.Sprintf("cluster %s has no private endpoint", name)), nil
+		}
+	}
+	if endpoint == "" {
+		return logical.ErrorResponse(fmt.Sprintf("cluster %s has no endpoint yet, it may still be provisioning (status %s)", name, cluster.Status)), nil
+	}
+	var caData string
+	if cluster.MasterAuth != nil {
+		caData = cluster.MasterAuth.ClusterCaCertificate
+	}
+
+	timeFormat, err := getTimeFormat(d)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	resp, err := b.pathAccessToken(ctx, req, d)
+	if err != nil || resp == nil || resp.IsError() {
+		return resp, err
+	}
+
+	token := resp.Data["token"].(string)
+	expiry := time.Unix(resp.Data["expires_at_seconds"].(int64), 0).UTC()
+	contextName := fmt.Sprintf("gke_%s_%s_%s", project, location, clusterName)
+	data := map[string]interface{}{
+		"kubeconfig":             gkeKubeconfig(contextName, "https://"+endpoint, caData, token, expiry),
+		"context":                contextName,
+		"server":                 "https://" + endpoint,
+		"certificate_authority":  caData,
+		"token":                  token,
+		"token_ttl":              resp.Data["token_ttl"],
+		"expires_at_seconds":     resp.Data["expires_at_seconds"],
+		"cluster_resource_name":  name,
+		"cluster_master_version": cluster.CurrentMasterVersion,
+	}
+	kubeconfig := &logical.Response{
+		Data:     data,
+		Warnings: resp.Warnings,
+	}
+	if scopes, ok := resp.Data["scopes"].([]string); ok && len(util.ToSet(scopes).Intersection(util.ToSet(gkeAuthScopes))) == 0 {
+		kubeconfig.AddWarning(fmt.Sprintf("the token has none of the scopes GKE requires to authenticate (%s); add one to the role set's token_scopes", strings.Join(gkeAuthScopes, " or ")))
+	}
+	formatExpiry(kubeconfig, timeFormat)
+	return kubeconfig, nil
+}
+
+// gkeKubeconfig returns a kubeconfig with a single cluster, user and context,
+// all named contextName, authenticating with token. Strings are quoted as
+// JSON, which YAML accepts as double-quoted scalars.
+func gkeKubeconfig(contextName, server, caData, token string, expiry time.Time) string {
+	q := func(s string) string {
+		quoted, _ := json.Marshal(s)
+		return string(quoted)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# The token expires at %s; request a new kubeconfig after that.\n", expiry.Format(time.RFC3339))
+	sb.WriteString("apiVersion: v1\n")
+	sb.WriteString("kind: Config\n")
+	sb.WriteString("clusters:\n")
+	fmt.Fprintf(&sb, "- name: %s\n", q(contextName))
+	sb.WriteString("  cluster:\n")
+	fmt.Fprintf(&sb, "    server: %s\n", q(server))
+	if caData != "" {
+		fmt.Fprintf(&sb, "    certificate-authority-data: %s\n", q(caData))
+	}
+	sb.WriteString("users:\n")
+	fmt.Fprintf(&sb, "- name: %s\n", q(contextName))
+	sb.WriteString("  user:\n")
+	fmt.Fprintf(&sb, "    token: %s\n", q(token))
+	sb.WriteString("contexts:\n")
+	fmt.Fprintf(&sb, "- name: %s\n", q(contextName))
+	sb.WriteString("  context:\n")
+	fmt.Fprintf(&sb, "    cluster: %s\n", q(contextName))
+	fmt.Fprintf(&sb, "    user: %s\n", q(contextName))
+	fmt.Fprintf(&sb, "current-context: %s\n", q(contextName))
+	return sb.String()
+}
+
+const pathTokenKubeconfigHelpSyn = `Generate a kubeconfig for a GKE cluster using an access token of a role set.`
+const pathTokenKubeconfigHelpDesc = `
+This path generates an access token for the role set, as token/:roleset
+does, and returns it in "kubeconfig", a ready-to-use kubeconfig for the GKE
+cluster named by "cluster" and "location" (a region or zone), in "project"
+or else the role set's project. The cluster's endpoint and CA certificate
+are looked up with the Kubernetes Engine API using the backend's
+credentials, which need container.clusters.get on the cluster. Set
+"private_endpoint" to use the cluster's private endpoint.
+
+The kubeconfig has a single cluster, user and context, named like gcloud's
+"gke_<project>_<location>_<cluster>", and is the current context, so it can
+be written to a file and used with "kubectl --kubeconfig". The token is also
+returned on its own with its expiry, in "token_ttl" and
+"expires_at_seconds", and the expiry is noted in a comment at the top of the
+kubeconfig. kubectl doesn't refresh the token, so a new kubeconfig has to be
+requested once it expires.
+
+GKE only accepts tokens with the cloud-platform or userinfo.email scope, so
+a warning is returned if the role set's token_scopes have neither. What the
+token can do in the cluster depends on the role set's IAM roles and on the
+cluster's Kubernetes RBAC for its service account.
+`
@@ -0,0 +1,115 @@
+package gcpsecrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/container/v1"
+)
+
+func TestSecrets_Kubeconfig(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	tokenSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token": "gke-token", "token_type": "Bearer", "expires_in": 3600}`))
+	}))
+	defer tokenSrv.Close()
+
+	// Point the key's token endpoint at the test server.
+	var creds map[string]string
+	keyJSON, err := base64.StdEncoding.DecodeString(testKeyMaterial(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(keyJSON, &creds); err != nil {
+		t.Fatal(err)
+	}
+	creds["token_uri"] = tokenSrv.URL + "/token"
+	if keyJSON, err = json.Marshal(creds); err != nil {
+		t.Fatal(err)
+	}
+
+	b, storage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/projects/my-project/locations/us-central1/clusters/prod":
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(&container.Cluster{
+				Name:                 "prod",
+				Endpoint:             "203.0.113.10",
+				MasterAuth:           &container.MasterAuth{ClusterCaCertificate: "Y2EtY2VydA=="},
+				PrivateClusterConfig: &container.PrivateClusterConfig{PrivateEndpoint: "10.0.0.2"},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error": {"code": 404, "message": "not found"}}`))
+		}
+	}))
+	rs := testStoredKeyRoleSet(t, storage, "test-kubeconfig")
+	rs.SecretType = SecretTypeAccessToken
+	rs.TokenGen = &TokenGenerator{
+		KeyName:    rs.AccountId.ResourceName() + "/keys/key1",
+		B64KeyJSON: base64.StdEncoding.EncodeToString(keyJSON),
+		Scopes:     []string{cloudPlatformScope},
+	}
+	if err := rs.save(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+
+	getKubeconfig := func(data map[string]interface{}) *logical.Response {
+		resp, err := b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "token/" + rs.Name + "/kubeconfig",
+			Data:      data,
+			Storage:   storage,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	resp := getKubeconfig(map[string]interface{}{"cluster": "prod", "location": "us-central1"})
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+	if resp.Data["server"] != "https://203.0.113.10" || resp.Data["token"] != "gke-token" || resp.Data["context"] != "gke_my-project_us-central1_prod" {
+		t.Fatalf("unexpected response data: %v", resp.Data)
+	}
+	kubeconfig := resp.Data["kubeconfig"].(string)
+	for _, line := range []string{
+		`    server: "https://203.0.113.10"`,
+		`    certificate-authority-data: "Y2EtY2VydA=="`,
+		`    token: "gke-token"`,
+		`current-context: "gke_my-project_us-central1_prod"`,
+	} {
+		if !strings.Contains(kubeconfig, line+"\n") {
+			t.Errorf("expected kubeconfig to contain %q, got:\n%s", line, kubeconfig)
+		}
+	}
+	if len(resp.Warnings) != 0 {
+		t.Errorf("unexpected warnings: %v", resp.Warnings)
+	}
+
+	resp = getKubeconfig(map[string]interface{}{"cluster": "prod", "location": "us-central1", "private_endpoint": true})
+	if resp == nil || resp.IsError() || resp.Data["server"] != "https://10.0.0.2" {
+		t.Fatalf("expected the private endpoint to be used, got %#v", resp)
+	}
+
+	for name, data := range map[string]map[string]interface{}{
+		"missing cluster":  {"location": "us-central1"},
+		"invalid location": {"cluster": "prod", "location": "us-central1/clusters/other"},
+		"unknown cluster":  {"cluster": "staging", "location": "us-central1"},
+	} {
+		if resp := getKubeconfig(data); resp == nil || !resp.IsError() {
+			t.Errorf("%s: expected an error, got %#v", name, resp)
+		}
+	}
+}
@@ -1,13 +1,28 @@
 package gcpsecrets
 
 import (
+	"bytes"
 	"context"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/errwrap"
 	"github.com/hashicorp/vault/sdk/framework"
 	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/crypto/pkcs12"
+	"golang.org/x/oauth2/google"
 	"google.golang.org/api/iam/v1"
 )
 
@@ -15,8 +30,43 @@ const (
 	SecretTypeKey      = "service_account_key"
 	keyAlgorithmRSA2k  = "KEY_ALG_RSA_2048"
 	privateKeyTypeJson = "TYPE_GOOGLE_CREDENTIALS_FILE"
+	privateKeyTypeP12  = "TYPE_PKCS12_FILE"
+
+	// p12KeyPassword is the password GCP protects every P12 key with.
+	p12KeyPassword = "notasecret"
+
+	// keyTypeUserManaged is the type of keys created through the API, as
+	// opposed to the Google-managed keys GCP uses internally.
+	keyTypeUserManaged = "USER_MANAGED"
+
+	// publicKeyTypeX509 asks GCP for a key's public key as a PEM-encoded
+	// X.509 certificate.
+	publicKeyTypeX509 = "TYPE_X509_PEM_FILE"
+
+	// Values of the output_format field of key/:roleset.
+	keyOutputFormatJSON = "json"
+	keyOutputFormatPEM  = "pem"
+
+	// Values of the output_encoding field of key/:roleset.
+	keyOutputEncodingBase64 = "base64"
+	keyOutputEncodingRaw    = "raw"
+
+	// Values of the format field of key/:roleset/leases.
+	keyLeasesFormatJSON = "json"
+	keyLeasesFormatCSV  = "csv"
+
+	// keyCreateAttempts is how many times to create a key whose returned
+	// key material fails validation before giving up.
+	keyCreateAttempts = 2
+
+	// maxKeysPerServiceAccount is GCP's limit on the user-managed keys of a
+	// service account.
+	maxKeysPerServiceAccount = 10
 )
 
+// keyNameRegex matches full service account key names.
+var keyNameRegex = regexp.MustCompile(`^projects/[^/]+/serviceAccounts/[^/]+/keys/[^/]+$`)
+
 func secretServiceAccountKey(b *backend) *framework.Secret {
 	return &framework.Secret{
 		Type: SecretTypeKey,
@@ -33,6 +83,10 @@ func secretServiceAccountKey(b *backend) *framework.Secret {
 				Type:        framework.TypeString,
 				Description: "Type of the private key (i.e. whether it is JSON or P12). Valid values are GCP enum(ServiceAccountPrivateKeyType)",
 			},
+			"key_fingerprint": {
+				Type:        framework.TypeString,
+				Description: "Hex-encoded SHA-256 digest of the key's DER-encoded public key",
+			},
 		},
 
 		Renew:  b.secretKeyRenew,
@@ -40,40 +94,179 @@ func secretServiceAccountKey(b *backend) *framework.Secret {
 	}
 }
 
+// serviceAccountKeyFields are the fields of key/:roleset, shared with
+// key/override-lease-limit/:roleset.
+func serviceAccountKeyFields() map[string]*framework.FieldSchema {
+	return map[string]*framework.FieldSchema{
+		"roleset": {
+			Type:        framework.TypeString,
+			Description: "Required. Name of the role set.",
+		},
+		"key_algorithm": {
+			Type:        framework.TypeString,
+			Description: fmt.Sprintf(`Private key algorithm for service account key - defaults to %s"`, keyAlgorithmRSA2k),
+			Default:     keyAlgorithmRSA2k,
+		},
+		"key_type": {
+			Type:        framework.TypeString,
+			Description: fmt.Sprintf(`Private key type for service account key - defaults to %s"`, privateKeyTypeJson),
+			Default:     privateKeyTypeJson,
+		},
+		"ttl": {
+			Type:        framework.TypeDurationSecond,
+			Description: "Lifetime of the service account key",
+		},
+		"metadata": {
+			Type:        framework.TypeKVPairs,
+			Description: fmt.Sprintf("Key-value metadata to attach to the key's lease, searchable with key/:roleset/leases. At most %d pairs.", maxKeyMetadataPairs),
+		},
+		"scopes": {
+			Type:        framework.TypeCommaStringSlice,
+			Description: "OAuth scopes the key is intended to be used with, recorded with its lease and returned with the key. They don't restrict the key, which can be used with any scope.",
+		},
+		"output_format": {
+			Type:        framework.TypeString,
+			Description: fmt.Sprintf(`Format to return the key in, "%s" for the base64-encoded credentials file or "%s" for its private key PEM, client email, private key ID and token URI as separate fields. Defaults to "%s".`, keyOutputFormatJSON, keyOutputFormatPEM, keyOutputFormatJSON),
+			Default:     keyOutputFormatJSON,
+		},
+		"output_encoding": {
+			Type:        framework.TypeString,
+			Description: fmt.Sprintf(`Encoding of private_key_data, "%s" as returned by GCP or "%s" for the decoded credentials JSON. "%s" requires a JSON key_type and the "%s" output_format. Defaults to "%s".`, keyOutputEncodingBase64, keyOutputEncodingRaw, keyOutputEncodingRaw, keyOutputFormatJSON, keyOutputEncodingBase64),
+			Default:     keyOutputEncodingBase64,
+		},
+		"key_valid_for": {
+			Type:        framework.TypeDurationSecond,
+			Description: "If set, the key is only returned if GCP expires it at most this long after it's created. GCP can't be asked for an expiry when creating a key, so this requires the iam.serviceAccountKeyExpiryHours org policy constraint; keys GCP gives a later expiry, or none, are deleted and an error is returned.",
+		},
+		"count": {
+			Type:        framework.TypeInt,
+			Description: fmt.Sprintf("Number of keys to create, at most %d. More than one are returned in \"keys\" under a single lease, and the keys that could be created are returned if the service account's key limit is reached or creation fails. Defaults to 1.", maxKeysPerServiceAccount),
+			Default:     1,
+		},
+		"include_project_number": {
+			Type:        framework.TypeBool,
+			Description: "If true, the number of the service account's project is returned in project_number. The lookup is best-effort: if it fails, the field is omitted with a warning.",
+		},
+		"kms_key_name": {
+			Type:        framework.TypeString,
+			Description: "Not supported. GCP can't encrypt service account key material with a customer-managed key, and setting this returns an error rather than issuing a key without it.",
+		},
+	}
+}
+
 func pathSecretServiceAccountKey(b *backend) *framework.Path {
 	return &framework.Path{
-		Pattern: fmt.Sprintf("key/%s", framework.GenericNameRegex("roleset")),
+		Pattern:        fmt.Sprintf("key/%s", framework.GenericNameRegex("roleset")),
+		Fields:         serviceAccountKeyFields(),
+		ExistenceCheck: b.pathRoleSetExistenceCheck("roleset"),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.ReadOperation:   &framework.PathOperation{Callback: b.pathServiceAccountKey},
+			logical.UpdateOperation: &framework.PathOperation{Callback: b.pathServiceAccountKey},
+		},
+		HelpSynopsis:    pathServiceAccountKeySyn,
+		HelpDescription: pathServiceAccountKeyDesc,
+	}
+}
+
+func pathSecretServiceAccountKeyOverrideLeaseLimit(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern:        fmt.Sprintf("key/override-lease-limit/%s", framework.GenericNameRegex("roleset")),
+		Fields:         serviceAccountKeyFields(),
+		ExistenceCheck: b.pathRoleSetExistenceCheck("roleset"),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{Callback: b.pathServiceAccountKeyOverrideLeaseLimit},
+		},
+		HelpSynopsis:    pathServiceAccountKeyOverrideLeaseLimitSyn,
+		HelpDescription: pathServiceAccountKeyOverrideLeaseLimitDesc,
+	}
+}
+
+func pathSecretServiceAccountKeyRotate(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: fmt.Sprintf("key/%s/rotate", framework.GenericNameRegex("roleset")),
 		Fields: map[string]*framework.FieldSchema{
 			"roleset": {
 				Type:        framework.TypeString,
 				Description: "Required. Name of the role set.",
 			},
-			"key_algorithm": {
+			"private_key_data": {
 				Type:        framework.TypeString,
-				Description: fmt.Sprintf(`Private key algorithm for service account key - defaults to %s"`, keyAlgorithmRSA2k),
-				Default:     keyAlgorithmRSA2k,
+				Description: `Required. The "private_key_data" of the key to rotate, as returned when it was issued. Raw credentials JSON is accepted too.`,
 			},
-			"key_type": {
+			"key_name": {
 				Type:        framework.TypeString,
-				Description: fmt.Sprintf(`Private key type for service account key - defaults to %s"`, privateKeyTypeJson),
-				Default:     privateKeyTypeJson,
+				Description: `Key to rotate, either the full GCP key name or the key ID. Only needed for P12 keys; for JSON keys it is read from the credentials.`,
 			},
-			"ttl": {
-				Type:        framework.TypeDurationSecond,
-				Description: "Lifetime of the service account key",
+		},
+		ExistenceCheck: b.pathRoleSetExistenceCheck("roleset"),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{Callback: b.pathServiceAccountKeyRotate},
+		},
+		HelpSynopsis:    pathServiceAccountKeyRotateSyn,
+		HelpDescription: pathServiceAccountKeyRotateDesc,
+	}
+}
+
+func pathSecretServiceAccountKeyRevokeByName(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: "key/revoke-by-name",
+		Fields: map[string]*framework.FieldSchema{
+			"key_name": {
+				Type:        framework.TypeString,
+				Description: `Required. Full GCP name of the key, "projects/<project>/serviceAccounts/<email>/keys/<key ID>".`,
+			},
+			"delete_untracked": {
+				Type:        framework.TypeBool,
+				Description: "If true, the key is deleted even if no key lease issued by this backend is found for it.",
+			},
+		},
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{Callback: b.pathServiceAccountKeyRevokeByName},
+		},
+		HelpSynopsis:    pathServiceAccountKeyRevokeByNameSyn,
+		HelpDescription: pathServiceAccountKeyRevokeByNameDesc,
+	}
+}
+
+func pathSecretServiceAccountKeyLeases(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: fmt.Sprintf("key/%s/leases", framework.GenericNameRegex("roleset")),
+		Fields: map[string]*framework.FieldSchema{
+			"roleset": {
+				Type:        framework.TypeString,
+				Description: "Required. Name of the role set.",
+			},
+			"metadata": {
+				Type:        framework.TypeKVPairs,
+				Description: "Only return leases whose metadata contains all of these key-value pairs.",
+			},
+			"format": {
+				Type:        framework.TypeString,
+				Description: fmt.Sprintf("Format of the leases, %q (the default) or %q to return them as a CSV document.", keyLeasesFormatJSON, keyLeasesFormatCSV),
+				Default:     keyLeasesFormatJSON,
 			},
 		},
 		ExistenceCheck: b.pathRoleSetExistenceCheck("roleset"),
 		Operations: map[logical.Operation]framework.OperationHandler{
-			logical.ReadOperation:   &framework.PathOperation{Callback: b.pathServiceAccountKey},
-			logical.UpdateOperation: &framework.PathOperation{Callback: b.pathServiceAccountKey},
+			logical.ReadOperation:   &framework.PathOperation{Callback: b.pathServiceAccountKeyLeases},
+			logical.UpdateOperation: &framework.PathOperation{Callback: b.pathServiceAccountKeyLeases},
 		},
-		HelpSynopsis:    pathServiceAccountKeySyn,
-		HelpDescription: pathServiceAccountKeyDesc,
+		HelpSynopsis:    pathServiceAccountKeyLeasesSyn,
+		HelpDescription: pathServiceAccountKeyLeasesDesc,
 	}
 }
 
 func (b *backend) pathServiceAccountKey(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	return b.serviceAccountKey(ctx, req, d, true)
+}
+
+func (b *backend) pathServiceAccountKeyOverrideLeaseLimit(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	return b.serviceAccountKey(ctx, req, d, false)
+}
+
+// serviceAccountKey issues keys for key/:roleset, refusing them once the
+// config's max_active_key_leases is reached if enforceLeaseLimit is set.
+func (b *backend) serviceAccountKey(ctx context.Context, req *logical.Request, d *framework.FieldData, enforceLeaseLimit bool) (*logical.Response, error) {
 	rsName := d.Get("roleset").(string)
 	keyType := d.Get("key_type").(string)
 	keyAlg := d.Get("key_algorithm").(string)
@@ -90,11 +283,419 @@ func (b *backend) pathServiceAccountKey(ctx context.Context, req *logical.Reques
 	if rs.SecretType != SecretTypeKey {
 		return logical.ErrorResponse(fmt.Sprintf("role set '%s' cannot generate service account keys (has secret type %s)", rsName, rs.SecretType)), nil
 	}
+	if resp, err := b.checkIssuanceAllowed(req, rs); resp != nil || err != nil {
+		return resp, err
+	}
+
+	if kmsKeyName := d.Get("kms_key_name").(string); kmsKeyName != "" {
+		return logical.ErrorResponse(fmt.Sprintf("kms_key_name %q can't be used: the GCP API for creating service account keys has no customer-managed encryption option, and keys can only be created with Google-managed encryption", kmsKeyName)), nil
+	}
 
-	return b.getSecretKey(ctx, req.Storage, rs, keyType, keyAlg, ttl)
+	outputFormat := d.Get("output_format").(string)
+	switch outputFormat {
+	case keyOutputFormatJSON:
+	case keyOutputFormatPEM:
+		if keyType != privateKeyTypeJson {
+			return logical.ErrorResponse(fmt.Sprintf("output_format %q requires key_type %s, got %s", keyOutputFormatPEM, privateKeyTypeJson, keyType)), nil
+		}
+		if !strings.HasPrefix(keyAlg, "KEY_ALG_RSA_") {
+			return logical.ErrorResponse(fmt.Sprintf("output_format %q requires an RSA key_algorithm, got %s", keyOutputFormatPEM, keyAlg)), nil
+		}
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("invalid output_format %q, must be %q or %q", outputFormat, keyOutputFormatJSON, keyOutputFormatPEM)), nil
+	}
+
+	outputEncoding := d.Get("output_encoding").(string)
+	switch outputEncoding {
+	case keyOutputEncodingBase64:
+	case keyOutputEncodingRaw:
+		if keyType != privateKeyTypeJson {
+			return logical.ErrorResponse(fmt.Sprintf("output_encoding %q requires key_type %s, got %s", keyOutputEncodingRaw, privateKeyTypeJson, keyType)), nil
+		}
+		if outputFormat != keyOutputFormatJSON {
+			return logical.ErrorResponse(fmt.Sprintf("output_encoding %q requires output_format %q, got %q", keyOutputEncodingRaw, keyOutputFormatJSON, outputFormat)), nil
+		}
+	default:
+		return logical.ErrorResponse(fmt.Sprintf("invalid output_encoding %q, must be %q or %q", outputEncoding, keyOutputEncodingBase64, keyOutputEncodingRaw)), nil
+	}
+
+	validFor := time.Duration(d.Get("key_valid_for").(int)) * time.Second
+
+	keyCount := d.Get("count").(int)
+	switch {
+	case keyCount < 1 || keyCount > maxKeysPerServiceAccount:
+		return logical.ErrorResponse(fmt.Sprintf("count must be between 1 and %d, the GCP limit of keys per service account", maxKeysPerServiceAccount)), nil
+	case keyCount > 1 && rs.KeyRotationPeriod > 0:
+		return logical.ErrorResponse(fmt.Sprintf("count can't be more than 1 for role set '%s', which has a key_rotation_period: rotated keys are returned one per lease", rsName)), nil
+	}
+
+	var metadata map[string]string
+	if metadataRaw, ok := d.GetOk("metadata"); ok {
+		metadata = metadataRaw.(map[string]string)
+		if err := validateKeyMetadata(metadata); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("invalid metadata: %v", err)), nil
+		}
+	}
+
+	scopes := d.Get("scopes").([]string)
+	for _, scope := range scopes {
+		if scope == "" || strings.ContainsAny(scope, " \t\n") {
+			return logical.ErrorResponse(fmt.Sprintf("invalid scope %q", scope)), nil
+		}
+	}
+
+	ttl, tokenExpiry, capWarning, err := b.capLeaseTTLToToken(ctx, req, ttl)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	// Keys are issued and their leases saved under rolesetLock, so that a
+	// role set deletion listing its leases doesn't miss them, and the role
+	// set is read again in case it was deleted in the meantime.
+	b.rolesetLock.Lock()
+	defer b.rolesetLock.Unlock()
+	if rs, err = getRoleSet(rsName, ctx, req.Storage); err != nil {
+		return nil, err
+	}
+	if rs == nil || rs.SecretType != SecretTypeKey {
+		return logical.ErrorResponse(fmt.Sprintf("role set '%s' does not exist", rsName)), nil
+	}
+	if enforceLeaseLimit {
+		if resp, err := b.checkKeyLeaseLimit(ctx, req.Storage, keyCount); resp != nil || err != nil {
+			return resp, err
+		}
+	}
+
+	var resp *logical.Response
+	if keyCount > 1 {
+		resp, err = b.getSecretKeys(ctx, req.Storage, rs, keyType, keyAlg, ttl, metadata, scopes, validFor, keyCount, outputFormat, outputEncoding)
+		if err != nil || resp.IsError() {
+			return resp, err
+		}
+	} else {
+		resp, err = b.getSecretKey(ctx, req.Storage, rs, keyType, keyAlg, ttl, metadata, scopes, validFor)
+		if err != nil || resp.IsError() {
+			return resp, err
+		}
+		setKeyOutput(resp, outputFormat, outputEncoding)
+	}
+	recordTokenTTLCap(resp, tokenExpiry, capWarning)
+
+	if d.Get("include_project_number").(bool) {
+		number, err := b.projectNumber(ctx, req.Storage, rs.AccountId.Project)
+		if err != nil {
+			resp.AddWarning(fmt.Sprintf("unable to look up the number of project %q, project_number is omitted: %v", rs.AccountId.Project, err))
+		} else {
+			resp.Data["project_number"] = number
+		}
+	}
+	return resp, nil
+}
+
+// checkKeyLeaseLimit returns an error response if issuing keyCount more keys
+// would exceed the config's max_active_key_leases.
+func (b *backend) checkKeyLeaseLimit(ctx context.Context, s logical.Storage, keyCount int) (*logical.Response, error) {
+	cfg, err := getConfig(ctx, s)
+	if err != nil {
+		return nil, errwrap.Wrapf("could not read backend config: {{err}}", err)
+	}
+	limit := cfg.maxActiveKeyLeases()
+	if limit <= 0 {
+		return nil, nil
+	}
+	count, err := countActiveKeyLeases(ctx, s)
+	if err != nil {
+		return nil, errwrap.Wrapf("unable to count active key leases: {{err}}", err)
+	}
+	if count >= limit {
+		return logical.ErrorResponse(fmt.Sprintf("refusing to issue key: %d active key leases reached the max_active_key_leases limit of %d; revoke leases or raise the limit", count, limit)), nil
+	}
+	if count+keyCount > limit {
+		return logical.ErrorResponse(fmt.Sprintf("refusing to issue %d keys: with %d active key leases, they would exceed the max_active_key_leases limit of %d; request fewer keys, revoke leases or raise the limit", keyCount, count, limit)), nil
+	}
+	return nil, nil
+}
+
+func (b *backend) pathServiceAccountKeyRotate(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	rsName := d.Get("roleset").(string)
+	keyData := strings.TrimSpace(d.Get("private_key_data").(string))
+	if keyData == "" {
+		return logical.ErrorResponse("private_key_data is required"), nil
+	}
+
+	rs, err := getRoleSet(rsName, ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if rs == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role set '%s' does not exist", rsName)), nil
+	}
+	if rs.SecretType != SecretTypeKey {
+		return logical.ErrorResponse(fmt.Sprintf("role set '%s' cannot generate service account keys (has secret type %s)", rsName, rs.SecretType)), nil
+	}
+	if rs.AccountId == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role set '%s' is invalid, has no associated service account", rsName)), nil
+	}
+	if resp, err := b.checkIssuanceAllowed(req, rs); resp != nil || err != nil {
+		return resp, err
+	}
+
+	// Raw credentials JSON is what consumers using output_encoding=raw hold.
+	if strings.HasPrefix(keyData, "{") {
+		keyData = base64.StdEncoding.EncodeToString([]byte(keyData))
+	}
+	keyID := d.Get("key_name").(string)
+	if keyID == "" {
+		keyID = credentialsKeyID(keyData)
+	}
+	if keyID == "" {
+		return logical.ErrorResponse("key_name is required for keys that aren't JSON credentials"), nil
+	}
+	keyName := roleSetKeyName(rs, keyID)
+	if keyName == "" {
+		return logical.ErrorResponse(fmt.Sprintf("key %q does not belong to role set '%s'", keyID, rsName)), nil
+	}
+
+	// Only the holder of a key's lease can rotate it: the lease must be
+	// tracked and the caller must present the key's private key. The lease
+	// is read and saved under rolesetLock, like on issuance.
+	b.rolesetLock.Lock()
+	defer b.rolesetLock.Unlock()
+	kl, err := getKeyLease(ctx, req.Storage, rs.Name, keyName)
+	if err != nil {
+		return nil, err
+	}
+	if kl == nil {
+		return logical.ErrorResponse(fmt.Sprintf("key %q has no key lease under role set '%s'", keyName, rsName)), nil
+	}
+	if kl.PendingKeyName != "" {
+		return logical.ErrorResponse(fmt.Sprintf("key %q was already rotated, renew its lease to get the new key", keyName)), nil
+	}
+	keyType := kl.KeyType
+	if keyType == "" {
+		keyType = privateKeyTypeJson
+	}
+	fingerprint, err := keyFingerprint(keyData, keyType)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	iamC, err := b.IAMAdminClient(req.Storage)
+	if err != nil {
+		return nil, errwrap.Wrapf("could not create IAM Admin client: {{err}}", err)
+	}
+	key, err := iamC.Projects.ServiceAccounts.Keys.Get(keyName).PublicKeyType(publicKeyTypeX509).Context(ctx).Do()
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("could not find key %q to rotate: %v", keyName, err)), nil
+	}
+	publicFingerprint, err := publicKeyFingerprint(key.PublicKeyData)
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("unable to read public key of key %q: {{err}}", keyName), err)
+	}
+	if fingerprint != publicFingerprint {
+		return logical.ErrorResponse(fmt.Sprintf("private_key_data is not the private key of key %q", keyName)), nil
+	}
+
+	if err := b.rotateLeaseKey(ctx, req.Storage, iamC, rs, kl); err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("unable to rotate key %q: {{err}}", keyName), err)
+	}
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"key_name":  keyName,
+			"rotations": kl.Rotations,
+		},
+		Warnings: []string{
+			fmt.Sprintf("the new key is returned by the next renewal of the key's lease; the old key is deleted about %s after that", keyRotationOverlap),
+		},
+	}, nil
+}
+
+func (b *backend) pathServiceAccountKeyLeases(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	rsName := d.Get("roleset").(string)
+	selector := d.Get("metadata").(map[string]string)
+	format := d.Get("format").(string)
+	if format != keyLeasesFormatJSON && format != keyLeasesFormatCSV {
+		return logical.ErrorResponse(fmt.Sprintf("invalid format %q, must be %q or %q", format, keyLeasesFormatJSON, keyLeasesFormatCSV)), nil
+	}
+
+	rs, err := getRoleSet(rsName, ctx, req.Storage)
+	if err != nil {
+		return nil, err
+	}
+	if rs == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role set '%s' does not exist", rsName)), nil
+	}
+
+	leases, err := listKeyLeases(ctx, req.Storage, rsName)
+	if err != nil {
+		return nil, errwrap.Wrapf("unable to list key leases: {{err}}", err)
+	}
+
+	matching := make([]*keyLease, 0, len(leases))
+	for _, kl := range leases {
+		if kl.matches(selector) {
+			matching = append(matching, kl)
+		}
+	}
+	if format == keyLeasesFormatCSV {
+		body, err := keyLeasesCSV(matching)
+		if err != nil {
+			return nil, err
+		}
+		return &logical.Response{
+			Data: map[string]interface{}{
+				logical.HTTPContentType: "text/csv",
+				logical.HTTPRawBody:     body,
+				logical.HTTPStatusCode:  http.StatusOK,
+			},
+		}, nil
+	}
+
+	// Only lease bookkeeping is returned; key material is never stored with
+	// the lease.
+	out := make([]map[string]interface{}, 0, len(matching))
+	for _, kl := range matching {
+		klOut := map[string]interface{}{
+			"key_name":              kl.KeyName,
+			"service_account_email": keyServiceAccountEmail(kl.KeyName),
+			"issue_time":            kl.IssueTime.Format(time.RFC3339),
+			"metadata":              kl.Metadata,
+		}
+		if !kl.ExpireTime.IsZero() {
+			klOut["expire_time"] = kl.ExpireTime.Format(time.RFC3339)
+		}
+		if kl.Rotations > 0 {
+			klOut["rotations"] = kl.Rotations
+			klOut["last_rotated"] = kl.LastRotated.Format(time.RFC3339)
+		}
+		if kl.PendingKeyName != "" {
+			klOut["pending_key_name"] = kl.PendingKeyName
+		}
+		if len(kl.Scopes) > 0 {
+			klOut["scopes"] = kl.Scopes
+		}
+		out = append(out, klOut)
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"leases": out,
+		},
+	}, nil
+}
+
+// keyLeasesCSV returns the leases as a CSV document with a header row, with
+// one row per lease of its role set, service account email, key name, issue
+// time and expire time. Times are RFC 3339; the expire time is empty if not
+// known.
+func keyLeasesCSV(leases []*keyLease) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"roleset", "service_account_email", "key_name", "issue_time", "expire_time"})
+	for _, kl := range leases {
+		var expireTime string
+		if !kl.ExpireTime.IsZero() {
+			expireTime = kl.ExpireTime.Format(time.RFC3339)
+		}
+		w.Write([]string{kl.RoleSet, keyServiceAccountEmail(kl.KeyName), kl.KeyName, kl.IssueTime.Format(time.RFC3339), expireTime})
+	}
+	w.Flush()
+	return buf.Bytes(), w.Error()
+}
+
+// keyServiceAccountEmail returns the email of the service account a key
+// belongs to, from the key's full name. Keys may outlive the role set's
+// current service account, so this isn't necessarily the role set's.
+func keyServiceAccountEmail(keyName string) string {
+	parts := strings.Split(keyName, "/")
+	if len(parts) != 6 {
+		return ""
+	}
+	return parts[3]
+}
+
+func (b *backend) pathServiceAccountKeyRevokeByName(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	keyName := d.Get("key_name").(string)
+	if keyName == "" {
+		return logical.ErrorResponse("key_name is required"), nil
+	}
+	if !keyNameRegex.MatchString(keyName) {
+		return logical.ErrorResponse(fmt.Sprintf("invalid key_name %q, must be a full key name of the form \"projects/<project>/serviceAccounts/<email>/keys/<key ID>\"", keyName)), nil
+	}
+
+	kl, err := findKeyLease(ctx, req.Storage, keyName)
+	if err != nil {
+		return nil, errwrap.Wrapf("unable to look up key lease: {{err}}", err)
+	}
+	if kl == nil && !d.Get("delete_untracked").(bool) {
+		return logical.ErrorResponse(fmt.Sprintf("no key lease found for key %q, pass delete_untracked=true to delete it anyway", keyName)), nil
+	}
+
+	iamAdmin, err := b.IAMAdminClient(req.Storage)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	_, err = iamAdmin.Projects.ServiceAccounts.Keys.Delete(keyName).Context(ctx).Do()
+	if err != nil && !isGoogleAccountKeyNotFoundErr(err) {
+		return logical.ErrorResponse(fmt.Sprintf("unable to delete service account key: %v", b.withGoogleRequestID(req.Path, err))), nil
+	}
+
+	resp := &logical.Response{
+		Data: map[string]interface{}{
+			"key_name": keyName,
+			"tracked":  kl != nil,
+		},
+	}
+	if kl == nil {
+		b.Logger().Warn("deleted service account key not issued by this backend", "key_name", keyName)
+		return resp, nil
+	}
+
+	if err := deleteKeyLease(ctx, req.Storage, kl.RoleSet, kl.KeyName); err != nil {
+		return nil, errwrap.Wrapf("unable to delete key lease: {{err}}", err)
+	}
+	b.Logger().Info("deleted service account key by name", "key_name", keyName, "roleset", kl.RoleSet)
+	if err := b.cleanupOrphanedRoleSet(ctx, req.Storage, kl.RoleSet); err != nil {
+		b.Logger().Warn("unable to clean up orphaned role set", "roleset", kl.RoleSet, "error", err)
+	}
+
+	resp.Data["role_set"] = kl.RoleSet
+	resp.AddWarning("the key is deleted, but its Vault lease remains until it expires or is revoked with sys/leases/revoke")
+	return resp, nil
+}
+
+// roleSetKeyName returns the full GCP name of the given key under the role
+// set's service account. The key may be given as either a key ID or a full
+// key name. An empty string is returned if the key does not belong to the
+// role set's service account.
+func roleSetKeyName(rs *RoleSet, key string) string {
+	prefix := rs.AccountId.ResourceName() + "/keys/"
+	if !strings.Contains(key, "/") {
+		return prefix + key
+	}
+	if !strings.HasPrefix(key, prefix) {
+		return ""
+	}
+	return key
 }
 
 func (b *backend) secretKeyRenew(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	// Leases issued before key_renewable was unset remain renewable as far
+	// as Vault knows, so renewing them is refused here.
+	rsName, err := secretRoleSetName(ctx, req.Storage, req.Secret)
+	if err != nil {
+		return nil, err
+	}
+	if rsName != "" {
+		rs, err := getRoleSet(rsName, ctx, req.Storage)
+		if err != nil {
+			return nil, err
+		}
+		if rs != nil && !rs.keyRenewable() {
+			return logical.ErrorResponse(fmt.Sprintf("keys of role set '%s' are not renewable (key_renewable is false), generate a new key instead", rsName)), nil
+		}
+	}
+
 	resp, err := b.verifySecretServiceKeyExists(ctx, req)
 	if err != nil {
 		return resp, err
@@ -110,20 +711,121 @@ func (b *backend) secretKeyRenew(ctx context.Context, req *logical.Request, d *f
 		cfg = &config{}
 	}
 
+	// Vault caps the lease at its max TTL as well, but refusing here keeps
+	// a rotated key from being delivered on a renewal past it.
+	ttl, ttlWarnings, err := framework.CalculateTTL(b.System(), 0, cfg.TTL, 0, cfg.MaxTTL, 0, req.Secret.IssueTime)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("key lease cannot be renewed: %v", err)), nil
+	}
+	if cfg.renewPastMaxTTL() == renewPastMaxTTLError {
+		requested := cfg.TTL
+		if requested <= 0 {
+			requested = b.System().DefaultLeaseTTL()
+		}
+		if ttl < requested {
+			return logical.ErrorResponse(fmt.Sprintf("key lease cannot be renewed for %s, it would pass its max TTL in %s; request a new key instead", requested, ttl)), nil
+		}
+	}
+	ttl, capWarning, err := capRenewalToToken(req, ttl)
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+	if capWarning != "" {
+		ttlWarnings = append(ttlWarnings, capWarning)
+	}
+	for _, w := range ttlWarnings {
+		resp.AddWarning(w)
+	}
+
 	resp.Secret = req.Secret
-	resp.Secret.TTL = cfg.TTL
+	resp.Secret.TTL = ttl
 	resp.Secret.MaxTTL = cfg.MaxTTL
+
+	if err := b.deliverRotatedKey(ctx, req, resp); err != nil {
+		return nil, err
+	}
+	if err := b.updateKeyLeaseOnRenew(ctx, req); err != nil {
+		b.Logger().Warn("unable to update key lease", "error", err)
+	}
 	return resp, nil
 }
 
+// updateKeyLeaseOnRenew records the new expiry of a renewed key.
+func (b *backend) updateKeyLeaseOnRenew(ctx context.Context, req *logical.Request) error {
+	rsName, err := secretRoleSetName(ctx, req.Storage, req.Secret)
+	if err != nil || rsName == "" {
+		return err
+	}
+
+	for _, keyName := range secretKeyNames(req.Secret) {
+		kl, err := getKeyLease(ctx, req.Storage, rsName, keyName)
+		if err != nil {
+			return err
+		}
+		if kl == nil {
+			continue
+		}
+		if req.Secret.TTL > 0 {
+			kl.ExpireTime = time.Now().UTC().Add(req.Secret.TTL)
+		}
+		if err := kl.save(ctx, req.Storage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// keySecretInternalData returns the internal data of a key secret issued
+// for the role set, without the key names. Key names are full GCP resource
+// names, and the service account and project are recorded along with them,
+// so revoking and auditing the lease don't depend on the role set still
+// existing.
+func keySecretInternalData(rs *RoleSet, metadata map[string]string) map[string]interface{} {
+	internalD := map[string]interface{}{
+		"role_set":              rs.Name,
+		"role_set_bindings":     rs.bindingHash(),
+		"service_account_email": rs.AccountId.EmailOrId,
+		"project":               rs.AccountId.Project,
+	}
+	if len(metadata) > 0 {
+		internalD["metadata"] = metadata
+	}
+	return internalD
+}
+
+// secretKeyNames returns the names of the keys of a key secret: the single
+// key_name of most secrets, or the key_names of secrets issued with a count.
+func secretKeyNames(secret *logical.Secret) []string {
+	if keyName, ok := secret.InternalData["key_name"].(string); ok {
+		return []string{keyName}
+	}
+	// Internal data read back from Vault's storage is decoded from JSON.
+	switch keyNames := secret.InternalData["key_names"].(type) {
+	case []string:
+		return keyNames
+	case []interface{}:
+		names := make([]string, 0, len(keyNames))
+		for _, name := range keyNames {
+			if s, ok := name.(string); ok {
+				names = append(names, s)
+			}
+		}
+		return names
+	}
+	return nil
+}
+
 func (b *backend) verifySecretServiceKeyExists(ctx context.Context, req *logical.Request) (*logical.Response, error) {
-	keyName, ok := req.Secret.InternalData["key_name"]
-	if !ok {
+	keyNames := secretKeyNames(req.Secret)
+	if len(keyNames) == 0 {
 		return nil, fmt.Errorf("invalid secret, internal data is missing key name")
 	}
 
-	rsName, ok := req.Secret.InternalData["role_set"]
-	if !ok {
+	rsName, err := secretRoleSetName(ctx, req.Storage, req.Secret)
+	if err != nil {
+		return nil, err
+	}
+	if rsName == "" {
 		return nil, fmt.Errorf("invalid secret, internal data is missing role set name")
 	}
 
@@ -133,47 +835,136 @@ func (b *backend) verifySecretServiceKeyExists(ctx context.Context, req *logical
 	}
 
 	// Verify role set was not deleted.
-	rs, err := getRoleSet(rsName.(string), ctx, req.Storage)
+	rs, err := getRoleSet(rsName, ctx, req.Storage)
 	if err != nil {
 		return logical.ErrorResponse(fmt.Sprintf("could not find role set '%v' for secret", rsName)), nil
 	}
+	if rs == nil {
+		return logical.ErrorResponse(fmt.Sprintf("role set '%v' was deleted since secret was generated, cannot renew", rsName)), nil
+	}
 
 	// Verify role set bindings have not changed since secret was generated.
 	if rs.bindingHash() != bindingSum.(string) {
 		return logical.ErrorResponse(fmt.Sprintf("role set '%v' bindings were updated since secret was generated, cannot renew", rsName)), nil
 	}
 
-	// Verify service account key still exists.
+	// Verify service account keys still exist.
 	iamAdmin, err := b.IAMAdminClient(req.Storage)
 	if err != nil {
 		return logical.ErrorResponse("could not confirm key still exists in GCP"), nil
 	}
-	if k, err := iamAdmin.Projects.ServiceAccounts.Keys.Get(keyName.(string)).Do(); err != nil || k == nil {
-		return logical.ErrorResponse(fmt.Sprintf("could not confirm key still exists in GCP: %v", err)), nil
+	for _, keyName := range keyNames {
+		if k, err := iamAdmin.Projects.ServiceAccounts.Keys.Get(keyName).Context(ctx).Do(); err != nil || k == nil {
+			return logical.ErrorResponse(fmt.Sprintf("could not confirm key still exists in GCP: %v", b.withGoogleRequestID(req.Path, err))), nil
+		}
 	}
 	return nil, nil
 }
 
 func (b *backend) secretKeyRevoke(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
-	keyNameRaw, ok := req.Secret.InternalData["key_name"]
-	if !ok {
+	keyNames := secretKeyNames(req.Secret)
+	if len(keyNames) == 0 {
 		return nil, fmt.Errorf("secret is missing key_name internal data")
 	}
+	rsName, err := secretRoleSetName(ctx, req.Storage, req.Secret)
+	if err != nil {
+		return nil, err
+	}
 
-	iamAdmin, err := b.IAMAdminClient(req.Storage)
+	cfg, err := getConfig(ctx, req.Storage)
 	if err != nil {
-		return logical.ErrorResponse(err.Error()), nil
+		return nil, err
 	}
 
-	_, err = iamAdmin.Projects.ServiceAccounts.Keys.Delete(keyNameRaw.(string)).Do()
-	if err != nil && !isGoogleAccountKeyNotFoundErr(err) {
-		return logical.ErrorResponse(fmt.Sprintf("unable to delete service account key: %v", err)), nil
+	// Each key is deleted on its own, so that one failing doesn't keep the
+	// others of a lease issued with a count. Revoking again skips the keys
+	// already deleted.
+	var failed []string
+	for _, keyName := range keyNames {
+		resp, err := b.revokeKey(ctx, req, cfg, rsName, keyName)
+		if err != nil {
+			return nil, err
+		}
+		if resp != nil && resp.IsError() {
+			if len(keyNames) == 1 {
+				return resp, nil
+			}
+			failed = append(failed, fmt.Sprintf("%s: %v", keyName, resp.Error()))
+		}
+	}
+	if len(failed) > 0 {
+		return logical.ErrorResponse(fmt.Sprintf("unable to revoke %d of the %d keys of the lease: %s", len(failed), len(keyNames), strings.Join(failed, "; "))), nil
 	}
 
+	if rsName != "" {
+		if err := b.cleanupOrphanedRoleSet(ctx, req.Storage, rsName); err != nil {
+			b.Logger().Warn("unable to clean up orphaned role set", "roleset", rsName, "error", err)
+		}
+	}
 	return nil, nil
 }
 
-func (b *backend) getSecretKey(ctx context.Context, s logical.Storage, rs *RoleSet, keyType, keyAlgorithm string, ttl int) (*logical.Response, error) {
+// revokeKey deletes a key of a revoked lease, or schedules its deletion, and
+// stops tracking it.
+func (b *backend) revokeKey(ctx context.Context, req *logical.Request, cfg *config, rsName, keyName string) (*logical.Response, error) {
+	if grace := cfg.keyRevocationGrace(); grace > 0 {
+		// Revoke the lease now but keep the key valid for the grace period;
+		// WAL rollback deletes it afterwards.
+		if err := deferKeyDeletion(ctx, req.Storage, rsName, keyName, time.Now().Add(grace)); err != nil {
+			return nil, errwrap.Wrapf("unable to schedule deletion of service account key: {{err}}", err)
+		}
+	} else {
+		iamAdmin, err := b.IAMAdminClient(req.Storage)
+		if err != nil {
+			return logical.ErrorResponse(err.Error()), nil
+		}
+
+		_, err = iamAdmin.Projects.ServiceAccounts.Keys.Delete(keyName).Context(ctx).Do()
+		if err != nil && !isGoogleAccountKeyNotFoundErr(err) {
+			if cfg.revocationPolicy() != revocationPolicyBestEffort {
+				return logical.ErrorResponse(fmt.Sprintf("unable to delete service account key: %v", b.withGoogleRequestID(req.Path, err))), nil
+			}
+
+			// Best effort: leave deletion of the key to WAL rollback so the
+			// lease can be revoked now.
+			if walErr := deferKeyDeletion(ctx, req.Storage, rsName, keyName, time.Time{}); walErr != nil {
+				return logical.ErrorResponse(fmt.Sprintf("unable to delete service account key: %v", b.withGoogleRequestID(req.Path, err))), nil
+			}
+			b.Logger().Warn("unable to delete service account key, deletion will be retried", "key_name", keyName, "error", err)
+		}
+	}
+
+	if rsName != "" {
+		if err := b.deletePendingRotatedKey(ctx, req.Storage, rsName, keyName); err != nil {
+			b.Logger().Warn("unable to delete undelivered rotated key", "key_name", keyName, "error", err)
+		}
+		if err := deleteKeyLease(ctx, req.Storage, rsName, keyName); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
+
+// deferKeyDeletion adds a WAL entry so the given key is deleted by WAL
+// rollback, no earlier than deleteAfter.
+func deferKeyDeletion(ctx context.Context, s logical.Storage, rsName, keyName string, deleteAfter time.Time) error {
+	saName := keyName
+	if i := strings.LastIndex(keyName, "/keys/"); i >= 0 {
+		saName = keyName[:i]
+	}
+	_, err := framework.PutWAL(ctx, s, walTypeAccountKey, &walAccountKey{
+		RoleSet:            rsName,
+		ServiceAccountName: saName,
+		KeyName:            keyName,
+		DeleteAfter:        deleteAfter,
+	})
+	return err
+}
+
+// getSecretKey issues a key for the role set under a new lease. Callers must
+// hold rolesetLock.
+func (b *backend) getSecretKey(ctx context.Context, s logical.Storage, rs *RoleSet, keyType, keyAlgorithm string, ttl int, metadata map[string]string, scopes []string, validFor time.Duration) (*logical.Response, error) {
 	cfg, err := getConfig(ctx, s)
 	if err != nil {
 		return nil, errwrap.Wrapf("could not read backend config: {{err}}", err)
@@ -187,33 +978,66 @@ func (b *backend) getSecretKey(ctx context.Context, s logical.Storage, rs *RoleS
 		return nil, errwrap.Wrapf("could not create IAM Admin client: {{err}}", err)
 	}
 
-	account, err := rs.getServiceAccount(iamC)
+	account, err := rs.getServiceAccount(ctx, iamC)
 	if err != nil {
+		b.recordIssuance(ctx, s, rs, err)
 		return logical.ErrorResponse(fmt.Sprintf("roleset service account was removed - role set must be updated (write to roleset/%s/rotate) before generating new secrets", rs.Name)), nil
 	}
+	if account.Disabled && cfg.rejectDisabledServiceAccountKeys() {
+		b.recordIssuance(ctx, s, rs, fmt.Errorf("service account is disabled"))
+		return logical.ErrorResponse(fmt.Sprintf("service account %q of role set '%s' is disabled; enable it before issuing keys", rs.AccountId.EmailOrId, rs.Name)), nil
+	}
 
-	key, err := iamC.Projects.ServiceAccounts.Keys.Create(
-		account.Name, &iam.CreateServiceAccountKeyRequest{
-			KeyAlgorithm:   keyAlgorithm,
-			PrivateKeyType: keyType,
-		}).Do()
+	release, err := b.claimEphemeralRoleSetLocked(ctx, s, rs, b.secretLeaseTTL(cfg, ttl))
 	if err != nil {
 		return logical.ErrorResponse(err.Error()), nil
 	}
 
+	key, err := b.createValidKey(ctx, iamC, rs, keyType, keyAlgorithm)
+	if err == nil {
+		err = b.checkKeyValidFor(ctx, iamC, key, validFor)
+	}
+	if err != nil {
+		release()
+		b.recordIssuance(ctx, s, rs, err)
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	kl := &keyLease{
+		RoleSet:      rs.Name,
+		KeyName:      key.Name,
+		IssueTime:    time.Now().UTC(),
+		Metadata:     metadata,
+		KeyType:      keyType,
+		KeyAlgorithm: keyAlgorithm,
+		Scopes:       scopes,
+	}
+	if err := kl.save(ctx, s); err != nil {
+		if _, delErr := iamC.Projects.ServiceAccounts.Keys.Delete(key.Name).Context(ctx).Do(); delErr != nil && !isGoogleAccountKeyNotFoundErr(delErr) {
+			b.Logger().Warn("unable to delete untracked key", "key_name", key.Name, "error", delErr)
+		}
+		release()
+		b.recordIssuance(ctx, s, rs, err)
+		return nil, errwrap.Wrapf("unable to save key lease: {{err}}", err)
+	}
+
 	secretD := map[string]interface{}{
 		"private_key_data": key.PrivateKeyData,
 		"key_algorithm":    key.KeyAlgorithm,
 		"key_type":         key.PrivateKeyType,
 	}
-	internalD := map[string]interface{}{
-		"key_name":          key.Name,
-		"role_set":          rs.Name,
-		"role_set_bindings": rs.bindingHash(),
+	if len(scopes) > 0 {
+		secretD["scopes"] = scopes
+	}
+	if validFor > 0 {
+		secretD["valid_before"] = key.ValidBeforeTime
 	}
+	internalD := keySecretInternalData(rs, metadata)
+	internalD["key_name"] = key.Name
 
 	resp := b.Secret(SecretTypeKey).Response(secretD, internalD)
-	resp.Secret.Renewable = true
+	resp.Secret.Renewable = rs.keyRenewable()
+	setKeyFingerprint(resp)
 
 	resp.Secret.MaxTTL = cfg.MaxTTL
 	resp.Secret.TTL = cfg.TTL
@@ -222,10 +1046,451 @@ func (b *backend) getSecretKey(ctx context.Context, s logical.Storage, rs *RoleS
 	if ttl > 0 {
 		resp.Secret.TTL = time.Duration(ttl) * time.Second
 	}
+	if warn := rs.bindingsPendingWarning(); warn != "" {
+		resp.AddWarning(warn)
+	}
+	if warn := keyExpiryWarning(key, validFor, resp.Secret.TTL); warn != "" {
+		resp.AddWarning(warn)
+	}
+
+	if resp.Secret.TTL > 0 {
+		kl.ExpireTime = kl.IssueTime.Add(resp.Secret.TTL)
+		if err := kl.save(ctx, s); err != nil {
+			b.Logger().Warn("unable to update key lease expiry", "key_name", key.Name, "error", err)
+		}
+	}
 
+	b.recordIssuance(ctx, s, rs, nil)
 	return resp, nil
 }
 
+// getSecretKeys issues up to count keys for the role set. Vault gives each
+// response a single lease, so the keys share it, but each is tracked by its
+// own key lease and deleted on its own when the lease is revoked. Keys are
+// created until count is reached, the service account's key limit is, or
+// creation fails; the keys created by then are returned, with a warning
+// explaining why the rest weren't. Callers must hold rolesetLock.
+func (b *backend) getSecretKeys(ctx context.Context, s logical.Storage, rs *RoleSet, keyType, keyAlgorithm string, ttl int, metadata map[string]string, scopes []string, validFor time.Duration, count int, outputFormat, outputEncoding string) (*logical.Response, error) {
+	cfg, err := getConfig(ctx, s)
+	if err != nil {
+		return nil, errwrap.Wrapf("could not read backend config: {{err}}", err)
+	}
+	if cfg == nil {
+		cfg = &config{}
+	}
+
+	iamC, err := b.IAMAdminClient(s)
+	if err != nil {
+		return nil, errwrap.Wrapf("could not create IAM Admin client: {{err}}", err)
+	}
+
+	account, err := rs.getServiceAccount(ctx, iamC)
+	if err != nil {
+		b.recordIssuance(ctx, s, rs, err)
+		return logical.ErrorResponse(fmt.Sprintf("roleset service account was removed - role set must be updated (write to roleset/%s/rotate) before generating new secrets", rs.Name)), nil
+	}
+	if account.Disabled && cfg.rejectDisabledServiceAccountKeys() {
+		b.recordIssuance(ctx, s, rs, fmt.Errorf("service account is disabled"))
+		return logical.ErrorResponse(fmt.Sprintf("service account %q of role set '%s' is disabled; enable it before issuing keys", rs.AccountId.EmailOrId, rs.Name)), nil
+	}
+
+	existing, err := listUserManagedKeys(ctx, iamC, rs.AccountId.ResourceName())
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("unable to list keys of service account %q: %v", rs.AccountId.EmailOrId, err)), nil
+	}
+	available := maxKeysPerServiceAccount - len(existing)
+	if available <= 0 {
+		return logical.ErrorResponse(fmt.Sprintf("service account %q already has the GCP limit of %d keys; revoke keys before issuing more", rs.AccountId.EmailOrId, maxKeysPerServiceAccount)), nil
+	}
+
+	leaseTTL := cfg.TTL
+	if ttl > 0 {
+		leaseTTL = time.Duration(ttl) * time.Second
+	}
+	release, err := b.claimEphemeralRoleSetLocked(ctx, s, rs, b.secretLeaseTTL(cfg, ttl))
+	if err != nil {
+		return logical.ErrorResponse(err.Error()), nil
+	}
+
+	issueTime := time.Now().UTC()
+	var keys []*iam.ServiceAccountKey
+	var failure string
+	for len(keys) < count {
+		if len(keys) == available {
+			failure = fmt.Sprintf("service account %q reached the GCP limit of %d keys", rs.AccountId.EmailOrId, maxKeysPerServiceAccount)
+			break
+		}
+		key, err := b.createValidKey(ctx, iamC, rs, keyType, keyAlgorithm)
+		if err == nil {
+			err = b.checkKeyValidFor(ctx, iamC, key, validFor)
+		}
+		if err != nil {
+			b.recordIssuance(ctx, s, rs, err)
+			failure = err.Error()
+			break
+		}
+		kl := &keyLease{
+			RoleSet:      rs.Name,
+			KeyName:      key.Name,
+			IssueTime:    issueTime,
+			Metadata:     metadata,
+			KeyType:      keyType,
+			KeyAlgorithm: keyAlgorithm,
+			Scopes:       scopes,
+		}
+		if leaseTTL > 0 {
+			kl.ExpireTime = issueTime.Add(leaseTTL)
+		}
+		if err := kl.save(ctx, s); err != nil {
+			if _, delErr := iamC.Projects.ServiceAccounts.Keys.Delete(key.Name).Context(ctx).Do(); delErr != nil && !isGoogleAccountKeyNotFoundErr(delErr) {
+				b.Logger().Warn("unable to delete untracked key", "key_name", key.Name, "error", delErr)
+			}
+			b.recordIssuance(ctx, s, rs, err)
+			failure = fmt.Sprintf("unable to save key lease: %v", err)
+			break
+		}
+		b.recordIssuance(ctx, s, rs, nil)
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		release()
+		return logical.ErrorResponse(failure), nil
+	}
+
+	keyNames := make([]string, 0, len(keys))
+	for _, key := range keys {
+		keyNames = append(keyNames, key.Name)
+	}
+	internalD := keySecretInternalData(rs, metadata)
+	internalD["key_names"] = keyNames
+	resp := b.Secret(SecretTypeKey).Response(map[string]interface{}{}, internalD)
+	resp.Secret.Renewable = rs.keyRenewable()
+	resp.Secret.MaxTTL = cfg.MaxTTL
+	resp.Secret.TTL = leaseTTL
+
+	// Each key is put in its own response data, sharing the secret, to
+	// reuse the output formatting of single keys.
+	keysOut := make([]map[string]interface{}, 0, len(keys))
+	for _, key := range keys {
+		keyResp := &logical.Response{
+			Data: map[string]interface{}{
+				"private_key_data": key.PrivateKeyData,
+				"key_algorithm":    key.KeyAlgorithm,
+				"key_type":         key.PrivateKeyType,
+			},
+			Secret: resp.Secret,
+		}
+		if len(scopes) > 0 {
+			keyResp.Data["scopes"] = scopes
+		}
+		if validFor > 0 {
+			keyResp.Data["valid_before"] = key.ValidBeforeTime
+		}
+		setKeyFingerprint(keyResp)
+		setKeyOutput(keyResp, outputFormat, outputEncoding)
+		for _, w := range keyResp.Warnings {
+			resp.AddWarning(w)
+		}
+		keysOut = append(keysOut, keyResp.Data)
+	}
+	resp.Data["keys"] = keysOut
+	if warn := keyExpiryWarning(keys[0], validFor, leaseTTL); warn != "" {
+		resp.AddWarning(warn)
+	}
+	if len(keys) < count {
+		resp.Data["failed"] = count - len(keys)
+		resp.AddWarning(fmt.Sprintf("only %d of the %d requested keys were created: %s", len(keys), count, failure))
+	}
+	if warn := rs.bindingsPendingWarning(); warn != "" {
+		resp.AddWarning(warn)
+	}
+	return resp, nil
+}
+
+// checkKeyValidFor checks that GCP expires a newly created key at most
+// validFor after it became valid, deleting the key otherwise. GCP's key
+// creation API takes no expiry, and keys only get one from the
+// iam.serviceAccountKeyExpiryHours org policy constraint, so this fails
+// unless the constraint is at most validFor. A zero validFor always passes.
+func (b *backend) checkKeyValidFor(ctx context.Context, iamC *iam.Service, key *iam.ServiceAccountKey, validFor time.Duration) error {
+	if validFor <= 0 {
+		return nil
+	}
+	validAfter, err := time.Parse(time.RFC3339, key.ValidAfterTime)
+	if err != nil {
+		validAfter = time.Now()
+	}
+	validBefore, err := time.Parse(time.RFC3339, key.ValidBeforeTime)
+	if err == nil && !validBefore.After(validAfter.Add(validFor)) {
+		return nil
+	}
+
+	if _, delErr := iamC.Projects.ServiceAccounts.Keys.Delete(key.Name).Context(ctx).Do(); delErr != nil && !isGoogleAccountKeyNotFoundErr(delErr) {
+		b.Logger().Warn("unable to delete key valid for longer than key_valid_for", "key_name", key.Name, "error", delErr)
+	}
+	expiry := "no expiry"
+	if key.ValidBeforeTime != "" {
+		expiry = fmt.Sprintf("an expiry of %s", key.ValidBeforeTime)
+	}
+	return fmt.Errorf("GCP gave the key %s, later than the key_valid_for of %s, so it was deleted: GCP can't be asked for a key expiry, keys only expire under the iam.serviceAccountKeyExpiryHours org policy constraint, which must be at most key_valid_for", expiry, validFor)
+}
+
+// keyExpiryWarning returns a warning if a key checked with key_valid_for
+// expires before its lease does.
+func keyExpiryWarning(key *iam.ServiceAccountKey, validFor, leaseTTL time.Duration) string {
+	if validFor <= 0 || leaseTTL <= 0 {
+		return ""
+	}
+	validBefore, err := time.Parse(time.RFC3339, key.ValidBeforeTime)
+	if err != nil || !validBefore.Before(time.Now().Add(leaseTTL)) {
+		return ""
+	}
+	return fmt.Sprintf("the key expires at %s, before its lease does; renewing the lease won't keep the key valid", key.ValidBeforeTime)
+}
+
+// createValidKey creates a key for the role set's service account, checking
+// that the returned key material can be used. A key that fails the check is
+// deleted and created again, up to keyCreateAttempts times.
+func (b *backend) createValidKey(ctx context.Context, iamC *iam.Service, rs *RoleSet, keyType, keyAlgorithm string) (*iam.ServiceAccountKey, error) {
+	var err error
+	for attempt := 1; attempt <= keyCreateAttempts; attempt++ {
+		// The service account may be in a different project than the
+		// backend's credentials, so always use its fully-qualified name.
+		var key *iam.ServiceAccountKey
+		key, err = iamC.Projects.ServiceAccounts.Keys.Create(
+			rs.AccountId.ResourceName(), &iam.CreateServiceAccountKeyRequest{
+				KeyAlgorithm:   keyAlgorithm,
+				PrivateKeyType: keyType,
+			}).Context(ctx).Do()
+		b.usage.record(rs.Name, usageKeyCreations, 1)
+		if err != nil {
+			return nil, keyCreationError(rs.AccountId, err)
+		}
+
+		err = validateKeyMaterial(key)
+		if err == nil {
+			return key, nil
+		}
+		b.Logger().Warn("created key has invalid key material, deleting it", "key_name", key.Name, "attempt", attempt, "error", err)
+		if _, delErr := iamC.Projects.ServiceAccounts.Keys.Delete(key.Name).Context(ctx).Do(); delErr != nil && !isGoogleAccountKeyNotFoundErr(delErr) {
+			b.Logger().Warn("unable to delete key with invalid key material", "key_name", key.Name, "error", delErr)
+		}
+	}
+	return nil, errwrap.Wrapf(fmt.Sprintf("GCP returned unusable key material %d times: {{err}}", keyCreateAttempts), err)
+}
+
+// validateKeyMaterial checks that a created key's private_key_data decodes,
+// and for JSON keys, that it is a service account credentials file with a
+// parseable private key.
+func validateKeyMaterial(key *iam.ServiceAccountKey) error {
+	data, err := base64.StdEncoding.DecodeString(key.PrivateKeyData)
+	if err != nil {
+		return errwrap.Wrapf("private key data is not valid base64: {{err}}", err)
+	}
+	if len(data) == 0 {
+		return errors.New("private key data is empty")
+	}
+	if key.PrivateKeyType != privateKeyTypeJson {
+		return nil
+	}
+
+	_, err = credentialsPrivateKey(data)
+	return err
+}
+
+// credentialsPrivateKey parses the private key of a service account
+// credentials file.
+func credentialsPrivateKey(data []byte) (crypto.Signer, error) {
+	jwtCfg, err := google.JWTConfigFromJSON(data)
+	if err != nil {
+		return nil, errwrap.Wrapf("private key data is not valid service account credentials: {{err}}", err)
+	}
+	block, _ := pem.Decode(jwtCfg.PrivateKey)
+	if block == nil {
+		return nil, errors.New("credentials private key is not PEM-encoded")
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+		return nil, fmt.Errorf("credentials private key of type %T is not supported", key)
+	}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errwrap.Wrapf("unable to parse credentials private key: {{err}}", err)
+	}
+	return key, nil
+}
+
+// keyFingerprint returns the hex-encoded SHA-256 digest of the DER-encoded
+// public key (SubjectPublicKeyInfo) of a key's base64-encoded private key
+// data, of either key type. It identifies the key pair whatever the format
+// the key is returned in, and matches the public key of the certificate GCP
+// publishes for the key.
+func keyFingerprint(privateKeyData, keyType string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(privateKeyData)
+	if err != nil {
+		return "", errwrap.Wrapf("private key data is not valid base64: {{err}}", err)
+	}
+
+	var signer crypto.Signer
+	switch keyType {
+	case privateKeyTypeJson:
+		if signer, err = credentialsPrivateKey(data); err != nil {
+			return "", err
+		}
+	case privateKeyTypeP12:
+		key, _, err := pkcs12.Decode(data, p12KeyPassword)
+		if err != nil {
+			return "", errwrap.Wrapf("unable to decode P12 key: {{err}}", err)
+		}
+		var ok bool
+		if signer, ok = key.(crypto.Signer); !ok {
+			return "", fmt.Errorf("P12 private key of type %T is not supported", key)
+		}
+	default:
+		return "", fmt.Errorf("unsupported key type %s", keyType)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return "", errwrap.Wrapf("unable to encode public key: {{err}}", err)
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// publicKeyFingerprint returns the fingerprint, as keyFingerprint computes
+// it, of the base64-encoded X.509 certificate GCP returns as a key's public
+// key data.
+func publicKeyFingerprint(publicKeyData string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(publicKeyData)
+	if err != nil {
+		return "", errwrap.Wrapf("public key data is not valid base64: {{err}}", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return "", errors.New("public key data is not PEM-encoded")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", errwrap.Wrapf("unable to parse public key certificate: {{err}}", err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return "", errwrap.Wrapf("unable to encode public key: {{err}}", err)
+	}
+	sum := sha256.Sum256(der)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// credentialsKeyID returns the private_key_id of base64-encoded credentials
+// JSON, or "" if the data isn't credentials JSON.
+func credentialsKeyID(privateKeyData string) string {
+	data, err := base64.StdEncoding.DecodeString(privateKeyData)
+	if err != nil {
+		return ""
+	}
+	var creds struct {
+		PrivateKeyID string `json:"private_key_id"`
+	}
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return ""
+	}
+	return creds.PrivateKeyID
+}
+
+// setKeyFingerprint adds the key_fingerprint of the private_key_data in a key
+// response's data. If it can't be computed, a warning is added instead of
+// failing after the key was created.
+func setKeyFingerprint(resp *logical.Response) {
+	data, _ := resp.Data["private_key_data"].(string)
+	keyType, _ := resp.Data["key_type"].(string)
+	fingerprint, err := keyFingerprint(data, keyType)
+	if err != nil {
+		resp.AddWarning(fmt.Sprintf("unable to compute key_fingerprint: %v", err))
+		return
+	}
+	resp.Data["key_fingerprint"] = fingerprint
+}
+
+// setKeyOutput puts the key in a key response's data in the given output
+// format and encoding, and records them in the secret so that renewals
+// returning a rotated key use them too.
+func setKeyOutput(resp *logical.Response, format, encoding string) {
+	switch {
+	case format == keyOutputFormatPEM:
+		resp.Secret.InternalData["output_format"] = format
+		setKeyOutputPEM(resp)
+	case encoding == keyOutputEncodingRaw:
+		resp.Secret.InternalData["output_encoding"] = encoding
+		setKeyOutputRaw(resp)
+	}
+}
+
+// setKeyOutputRaw replaces the base64-encoded credentials file in a key
+// response's data with the credentials JSON. If it can't be decoded, it is
+// left in place with a warning rather than failing after the key was created.
+func setKeyOutputRaw(resp *logical.Response) {
+	data, _ := resp.Data["private_key_data"].(string)
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		resp.AddWarning(fmt.Sprintf("unable to return private_key_data with %q encoding, returning it base64-encoded instead: %v", keyOutputEncodingRaw, err))
+		return
+	}
+	resp.Data["private_key_data"] = string(raw)
+}
+
+// setKeyOutputPEM replaces the credentials file in a key response's data
+// with its private key PEM, client email, private key ID and token URI. If
+// the credentials can't be decomposed, the file is left in place with a
+// warning rather than failing after the key was created.
+func setKeyOutputPEM(resp *logical.Response) {
+	data, _ := resp.Data["private_key_data"].(string)
+	fields, err := decomposeKeyCredentials(data)
+	if err != nil {
+		resp.AddWarning(fmt.Sprintf("unable to return the key in %q format, returning private_key_data instead: %v", keyOutputFormatPEM, err))
+		return
+	}
+	delete(resp.Data, "private_key_data")
+	for k, v := range fields {
+		resp.Data[k] = v
+	}
+}
+
+// decomposeKeyCredentials decodes base64-encoded service account credentials
+// JSON into its private key PEM, client email, private key ID and token URI.
+func decomposeKeyCredentials(privateKeyData string) (map[string]interface{}, error) {
+	data, err := base64.StdEncoding.DecodeString(privateKeyData)
+	if err != nil {
+		return nil, errwrap.Wrapf("private key data is not valid base64: {{err}}", err)
+	}
+
+	var creds struct {
+		Type         string `json:"type"`
+		PrivateKeyID string `json:"private_key_id"`
+		PrivateKey   string `json:"private_key"`
+		ClientEmail  string `json:"client_email"`
+		TokenURI     string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &creds); err != nil {
+		return nil, errwrap.Wrapf("private key data is not a credentials file: {{err}}", err)
+	}
+	if creds.Type != "service_account" {
+		return nil, fmt.Errorf("credentials are of type %q, not service_account", creds.Type)
+	}
+	if block, _ := pem.Decode([]byte(creds.PrivateKey)); block == nil {
+		return nil, errors.New("credentials private key is not PEM-encoded")
+	}
+
+	return map[string]interface{}{
+		"private_key":    creds.PrivateKey,
+		"client_email":   creds.ClientEmail,
+		"private_key_id": creds.PrivateKeyID,
+		"token_uri":      creds.TokenURI,
+	}, nil
+}
+
 const pathServiceAccountKeySyn = `Generate an service account private key under a specific role set.`
 const pathServiceAccountKeyDesc = `
 This path will generate a new service account private key for accessing GCP APIs.
@@ -235,4 +1500,127 @@ would generate service account keys for the "deploy" role set.
 
 On the backend, each roleset is associated with a service account under
 which secrets/keys are created.
+
+Passing "output_format=pem" returns the key's "private_key" (PEM),
+"client_email", "private_key_id" and "token_uri" as separate fields instead
+of the base64-encoded credentials file in "private_key_data". It requires a
+JSON key_type and an RSA key_algorithm. Renewals that return a rotated key
+use the same format.
+
+GCP returns "private_key_data" base64-encoded, and by default it is passed
+on as is. Client libraries loading it as a credentials file, such as
+google.CredentialsFromJSON in Go, google.oauth2.service_account in Python
+or GOOGLE_APPLICATION_CREDENTIALS, expect the decoded JSON, while Terraform's
+google_service_account_key and most tooling storing keys expect base64.
+Passing "output_encoding=raw" returns the credentials JSON string itself, for
+clients that can't decode it. It requires a JSON key_type and the default
+output_format; P12 keys are binary and are always base64-encoded.
+"key_type" and "key_algorithm" describe the key either way. Renewals that
+return a rotated key use the same encoding.
+
+Service account keys can't be created with customer-managed encryption
+keys (CMEK): GCP's key creation API only takes the key algorithm and
+private key type, and the private key data it returns is not encrypted at
+rest by GCP, which keeps only the public key. Passing "kms_key_name" returns
+an error instead of issuing a key without the requested encryption. To keep
+key material under a customer-managed key, encrypt it client-side, or use an
+access_token role set so no key material leaves Vault.
+
+Optional "scopes" record the OAuth scopes the key is meant to be used with.
+They are returned with the key and listed with its lease, but are purely
+informational: the key material is the same and works with any scope.
+
+Optional "metadata" key-value pairs are stored with the key's lease and can
+be used to find it later with key/:roleset/leases.
+
+The response includes "key_fingerprint", the hex-encoded SHA-256 digest of
+the key's DER-encoded public key (SubjectPublicKeyInfo), computed from the
+returned key material. It is the same whatever the output format, so
+clients can pin the key pair or check they received the expected key, and
+it matches the public key of the certificate GCP publishes for the key at
+the credentials' client_x509_cert_url. Renewals that return a rotated key
+include the new key's fingerprint.
+
+The key material GCP returns is checked before being handed out. If it can't
+be decoded and parsed as credentials, the key is deleted and created once
+more, and an error is returned if that key is unusable too.
+
+If the role set has a "key_rotation_period", outstanding keys are replaced
+in the background once they are that old. The new key is returned in the
+data of the lease's next renewal, which also points the lease at it, and
+the old key is deleted an hour later. Rotations are listed with the lease
+in key/:roleset/leases.
+
+Passing "count" creates several independent keys at once, returned in
+"keys" with the fields of single keys. A Vault response has a single lease,
+so the keys share it, but each is tracked as its own key lease and deleted
+on its own when the lease is revoked. GCP allows a service account at most
+10 keys: if that limit is reached, or creating a key fails, the keys
+created so far are returned along with the number that "failed" and a
+warning saying why. Role sets with a key_rotation_period only issue one key
+per lease.
+
+Passing "key_valid_for" requires the key itself to expire, independently of
+its lease. GCP's key creation API takes no expiry: keys only get one from
+the iam.serviceAccountKeyExpiryHours organization policy constraint. The
+key's expiry is checked once it is created, and it is returned, with its
+expiry in "valid_before", only if it expires at most key_valid_for after it
+became valid. Otherwise the key is deleted and the request fails saying
+what expiry GCP gave it. A warning is returned if the key expires before
+its lease.
+
+Passing "include_project_number" adds the numeric "project_number" of the
+service account's project to the response, for systems that identify
+projects by number rather than by the ID in the credentials JSON. Numbers
+are looked up once per project and cached. If the lookup fails, the key is
+still returned, without project_number and with a warning.
+`
+
+const pathServiceAccountKeyRevokeByNameSyn = `Delete a service account key by its GCP key name, for incident response.`
+const pathServiceAccountKeyRevokeByNameDesc = `
+This path deletes a service account key given its full GCP key name, for
+when a leaked key is identified by its key ID rather than a Vault lease. The
+key lease this backend tracks for the key, under any role set, is found and
+removed, and its role set is returned.
+
+Secrets engines cannot revoke Vault leases themselves, so the lease itself
+remains until it expires or is revoked through sys/leases/revoke; revoking
+it afterwards is safe. Keys with no tracked lease are only deleted if
+"delete_untracked" is set, in which case the deletion is logged.
+`
+
+const pathServiceAccountKeyLeasesSyn = `List the active key leases of a role set, optionally filtered by metadata.`
+const pathServiceAccountKeyLeasesDesc = `
+This path lists the service account keys issued under a role set that still
+have active leases, with the key name, issue and expiry times, and the
+metadata given when the key was issued. Passing "metadata" only returns leases whose metadata contains all of
+the given key-value pairs. Key material is never returned.
+
+With "format" set to "csv", the leases are returned as a text/csv document
+instead of JSON, with a header row and a row per lease of its role set,
+service account email, key name, and issue and expiry times.
+`
+
+const pathServiceAccountKeyOverrideLeaseLimitSyn = `Generate a service account key under a role set, ignoring max_active_key_leases.`
+const pathServiceAccountKeyOverrideLeaseLimitDesc = `
+This path issues keys like key/:roleset and takes the same parameters, but
+issues them even if the config's "max_active_key_leases" has been reached.
+It is a root-protected path: callers need the "sudo" capability on it, so
+the override stays with administrators while anyone able to write
+key/:roleset remains bound by the limit.
+`
+
+const pathServiceAccountKeyRotateSyn = `Rotate a service account key issued under a specific role set.`
+const pathServiceAccountKeyRotateDesc = `
+This path replaces a service account key issued under a role set with a new
+one, under the same lease. The caller proves it holds the key by giving its
+"private_key_data", which is checked against the public key GCP has for it;
+the key must have a tracked key lease under the role set.
+
+The new key is returned by the next renewal of the lease
+("sys/leases/renew"), as for keys rotated under the role set's
+"key_rotation_period". The old key stays valid for an hour after that so
+consumers can switch over without a gap in access, and is then deleted. No
+new lease is issued, so rotation doesn't count against
+"max_active_key_leases".
 `
@@ -0,0 +1,317 @@
+package gcpsecrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/framework"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/iamcredentials/v1"
+)
+
+const (
+	// maxSignPayloadBytes bounds the (decoded) size of payloads accepted by
+	// the sign-blob and sign-jwt paths.
+	maxSignPayloadBytes = 64 * 1024
+
+	// maxSignJwtTTL is the furthest in the future GCP accepts the exp claim
+	// of a JWT signed with signJwt.
+	maxSignJwtTTL     = 12 * time.Hour
+	defaultSignJwtTTL = time.Hour
+)
+
+func pathSecretSignBlob(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: fmt.Sprintf("sign-blob/%s", framework.GenericNameRegex("roleset")),
+		Fields: map[string]*framework.FieldSchema{
+			"roleset": {
+				Type:        framework.TypeString,
+				Description: "Required. Name of the role set.",
+			},
+			"payload": {
+				Type:        framework.TypeString,
+				Description: fmt.Sprintf("Required. Base64-encoded bytes to sign, at most %d bytes once decoded.", maxSignPayloadBytes),
+			},
+		},
+		ExistenceCheck: b.pathRoleSetExistenceCheck("roleset"),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{Callback: b.pathSignBlob},
+		},
+		HelpSynopsis:    pathSignBlobHelpSyn,
+		HelpDescription: pathSignBlobHelpDesc,
+	}
+}
+
+func pathSecretSignJwt(b *backend) *framework.Path {
+	return &framework.Path{
+		Pattern: fmt.Sprintf("sign-jwt/%s", framework.GenericNameRegex("roleset")),
+		Fields: map[string]*framework.FieldSchema{
+			"roleset": {
+				Type:        framework.TypeString,
+				Description: "Required. Name of the role set.",
+			},
+			"payload": {
+				Type:        framework.TypeString,
+				Description: fmt.Sprintf("Raw JSON object of JWT claims to sign, at most %d bytes. One of payload or claims is required.", maxSignPayloadBytes),
+			},
+			"claims": {
+				Type:        framework.TypeMap,
+				Description: "JWT claims to sign, as an object. One of payload or claims is required.",
+			},
+			"ttl": {
+				Type:        framework.TypeDurationSecond,
+				Default:     int(defaultSignJwtTTL / time.Second),
+				Description: "Lifetime of the JWT, used to set exp when the claims don't have it. Defaults to 1h, at most 12h.",
+			},
+		},
+		ExistenceCheck: b.pathRoleSetExistenceCheck("roleset"),
+		Operations: map[logical.Operation]framework.OperationHandler{
+			logical.UpdateOperation: &framework.PathOperation{Callback: b.pathSignJwt},
+		},
+		HelpSynopsis:    pathSignJwtHelpSyn,
+		HelpDescription: pathSignJwtHelpDesc,
+	}
+}
+
+func (b *backend) pathSignBlob(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	payload := d.Get("payload").(string)
+	if payload == "" {
+		return logical.ErrorResponse("payload is required"), nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("payload must be base64-encoded: %v", err)), nil
+	}
+	if len(decoded) > maxSignPayloadBytes {
+		return logical.ErrorResponse(fmt.Sprintf("payload is %d bytes, must be at most %d bytes", len(decoded), maxSignPayloadBytes)), nil
+	}
+
+	rs, resp, err := b.getSigningRoleSet(ctx, req, d.Get("roleset").(string))
+	if rs == nil {
+		return resp, err
+	}
+
+	credsC, err := b.IAMCredentialsClient(req.Storage)
+	if err != nil {
+		return nil, errwrap.Wrapf("could not create IAM Credentials client: {{err}}", err)
+	}
+
+	signed, err := credsC.Projects.ServiceAccounts.SignBlob(signingAccountName(rs), &iamcredentials.SignBlobRequest{
+		Payload: payload,
+	}).Context(ctx).Do()
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("unable to sign blob: %v", b.withGoogleRequestID(req.Path, err))), nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"key_id":    signed.KeyId,
+			"signature": signed.SignedBlob,
+		},
+	}, nil
+}
+
+func (b *backend) pathSignJwt(ctx context.Context, req *logical.Request, d *framework.FieldData) (*logical.Response, error) {
+	payload := d.Get("payload").(string)
+	claims := d.Get("claims").(map[string]interface{})
+	if payload == "" && len(claims) == 0 {
+		return logical.ErrorResponse("one of payload or claims is required"), nil
+	}
+	if payload != "" && len(claims) > 0 {
+		return logical.ErrorResponse("only one of payload or claims can be given"), nil
+	}
+	if payload != "" {
+		if len(payload) > maxSignPayloadBytes {
+			return logical.ErrorResponse(fmt.Sprintf("payload is %d bytes, must be at most %d bytes", len(payload), maxSignPayloadBytes)), nil
+		}
+		dec := json.NewDecoder(bytes.NewReader([]byte(payload)))
+		dec.UseNumber()
+		claims = nil
+		if err := dec.Decode(&claims); err != nil {
+			return logical.ErrorResponse(fmt.Sprintf("payload must be a JSON object of claims: %v", err)), nil
+		}
+		if claims == nil {
+			return logical.ErrorResponse("payload must be a JSON object of claims"), nil
+		}
+	}
+	ttl := time.Duration(d.Get("ttl").(int)) * time.Second
+	if ttl <= 0 || ttl > maxSignJwtTTL {
+		return logical.ErrorResponse(fmt.Sprintf("ttl must be positive and at most %s", maxSignJwtTTL)), nil
+	}
+
+	rs, resp, err := b.getSigningRoleSet(ctx, req, d.Get("roleset").(string))
+	if rs == nil {
+		return resp, err
+	}
+
+	credsC, err := b.IAMCredentialsClient(req.Storage)
+	if err != nil {
+		return nil, errwrap.Wrapf("could not create IAM Credentials client: {{err}}", err)
+	}
+
+	if err := completeJwtClaims(claims, rs.AccountId.EmailOrId, time.Now(), ttl); err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("invalid claims: %v", err)), nil
+	}
+	signedPayload, err := json.Marshal(claims)
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("unable to encode claims: %v", err)), nil
+	}
+	if len(signedPayload) > maxSignPayloadBytes {
+		return logical.ErrorResponse(fmt.Sprintf("claims are %d bytes encoded, must be at most %d bytes", len(signedPayload), maxSignPayloadBytes)), nil
+	}
+
+	signed, err := credsC.Projects.ServiceAccounts.SignJwt(signingAccountName(rs), &iamcredentials.SignJwtRequest{
+		Payload: string(signedPayload),
+	}).Context(ctx).Do()
+	if err != nil {
+		return logical.ErrorResponse(fmt.Sprintf("unable to sign JWT: %v", b.withGoogleRequestID(req.Path, err))), nil
+	}
+
+	return &logical.Response{
+		Data: map[string]interface{}{
+			"key_id":     signed.KeyId,
+			"signed_jwt": signed.SignedJwt,
+		},
+	}, nil
+}
+
+// completeJwtClaims validates the standard claims of a JWT to be signed by the
+// given service account and fills in the missing ones: iss and sub default to
+// the service account, iat to now and exp to now plus the ttl.
+func completeJwtClaims(claims map[string]interface{}, email string, now time.Time, ttl time.Duration) error {
+	for _, name := range []string{"iss", "sub", "jti"} {
+		if v, ok := claims[name]; ok {
+			if _, ok := v.(string); !ok {
+				return fmt.Errorf("%s must be a string", name)
+			}
+		}
+	}
+	if aud, ok := claims["aud"]; ok {
+		switch aud := aud.(type) {
+		case string:
+		case []interface{}:
+			for _, a := range aud {
+				if _, ok := a.(string); !ok {
+					return fmt.Errorf("aud must be a string or a list of strings")
+				}
+			}
+		default:
+			return fmt.Errorf("aud must be a string or a list of strings")
+		}
+	}
+	for _, name := range []string{"iat", "nbf", "exp"} {
+		if v, ok := claims[name]; ok {
+			if _, ok := numericDate(v); !ok {
+				return fmt.Errorf("%s must be a number of seconds since the epoch", name)
+			}
+		}
+	}
+
+	if _, ok := claims["iss"]; !ok {
+		claims["iss"] = email
+	}
+	if _, ok := claims["sub"]; !ok {
+		claims["sub"] = email
+	}
+	if _, ok := claims["iat"]; !ok {
+		claims["iat"] = now.Unix()
+	}
+	if _, ok := claims["exp"]; !ok {
+		claims["exp"] = now.Add(ttl).Unix()
+	}
+
+	exp, _ := numericDate(claims["exp"])
+	if exp <= now.Unix() {
+		return fmt.Errorf("exp must be in the future")
+	}
+	if exp > now.Add(maxSignJwtTTL).Unix() {
+		return fmt.Errorf("exp must be at most %s in the future", maxSignJwtTTL)
+	}
+	return nil
+}
+
+// numericDate returns a JWT NumericDate claim as whole seconds since the
+// epoch. Claims can come from decoded JSON or from request data, so several
+// number types are accepted.
+func numericDate(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i, true
+		}
+		f, err := v.Float64()
+		return int64(f), err == nil
+	case float64:
+		return int64(v), true
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	}
+	return 0, false
+}
+
+// getSigningRoleSet returns the named role set if it can be used for signing
+// and issues credentials for the request, per checkIssuanceAllowed.
+// Otherwise it returns a nil role set and the response and error to return.
+func (b *backend) getSigningRoleSet(ctx context.Context, req *logical.Request, rsName string) (*RoleSet, *logical.Response, error) {
+	rs, err := getRoleSet(rsName, ctx, req.Storage)
+	if err != nil {
+		return nil, nil, err
+	}
+	if rs == nil {
+		return nil, logical.ErrorResponse(fmt.Sprintf("role set '%s' does not exist", rsName)), nil
+	}
+	if rs.AccountId == nil {
+		return nil, logical.ErrorResponse(fmt.Sprintf("role set '%s' is invalid, has no associated service account", rsName)), nil
+	}
+	// Signing issues nothing with a lease that an ephemeral role set's
+	// single secret could be claimed for, so it would be unlimited.
+	if rs.Ephemeral {
+		return nil, logical.ErrorResponse(fmt.Sprintf("role set '%s' is ephemeral and can only issue its single leased secret, not sign", rsName)), nil
+	}
+	if resp, err := b.checkIssuanceAllowed(req, rs); resp != nil || err != nil {
+		return nil, resp, err
+	}
+	return rs, nil, nil
+}
+
+// signingAccountName returns the IAM Credentials resource name of the role
+// set's service account.
+func signingAccountName(rs *RoleSet) string {
+	return fmt.Sprintf("projects/-/serviceAccounts/%s", rs.AccountId.EmailOrId)
+}
+
+const pathSignBlobHelpSyn = `Sign a blob with a role set's service account.`
+const pathSignBlobHelpDesc = `
+This path signs arbitrary bytes (for example, the string-to-sign of a Cloud
+Storage signed URL) with a Google-managed key of the role set's service
+account, without issuing a key. The payload must be base64-encoded.
+
+Signing is done through the IAM Credentials API using the backend's
+credentials, which must be allowed to create tokens for the role set's
+service account (roles/iam.serviceAccountTokenCreator).
+`
+
+const pathSignJwtHelpSyn = `Sign a JWT with a role set's service account.`
+const pathSignJwtHelpDesc = `
+This path signs a JWT with a Google-managed key of the role set's service
+account, without issuing a key. The claims are given either as a raw JSON
+object in "payload" or as an object in "claims".
+
+Standard claims are validated before signing: iss, sub and jti must be
+strings, aud a string or list of strings, and iat, nbf and exp numbers of
+seconds since the epoch. Missing claims are filled in: iss and sub with the
+service account's email, iat with the current time, and exp with the current
+time plus "ttl". GCP rejects JWTs that expire more than 12 hours in the
+future, so exp must be within that bound.
+
+Signing is done through the IAM Credentials API using the backend's
+credentials, which must be allowed to create tokens for the role set's
+service account (roles/iam.serviceAccountTokenCreator).
+`
@@ -0,0 +1,40 @@
+package gcpsecrets
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+const serviceAccountKeyAdminRole = "roles/iam.serviceAccountKeyAdmin"
+
+// selfEscalationRoles are the roles that, granted to a role set's service
+// account on itself, let it mint keys or tokens for itself outside of Vault.
+var selfEscalationRoles = []string{serviceAccountKeyAdminRole, serviceAccountTokenCreatorRole}
+
+// bindingProjectResourceRegex matches a bound resource that is a project
+// itself, rather than a resource in it.
+var bindingProjectResourceRegex = regexp.MustCompile(`^(?://cloudresourcemanager\.googleapis\.com/)?projects/([a-z][-a-z0-9]{4,28}[a-z0-9])$`)
+
+// selfEscalatingBindings returns the bindings that would grant the role set's
+// service account, created in project, a role of selfEscalationRoles on
+// itself, as "<role> on <resource>", sorted. Role sets get a new service
+// account whenever their bindings change, so only bindings on the project
+// itself can reach it; grants inherited from folders or organizations are
+// not detected.
+func selfEscalatingBindings(project string, bindings ResourceBindings) []string {
+	var found []string
+	for rName, roles := range bindings {
+		m := bindingProjectResourceRegex.FindStringSubmatch(rName)
+		if m == nil || m[1] != project {
+			continue
+		}
+		for _, role := range selfEscalationRoles {
+			if roles.Includes(role) {
+				found = append(found, fmt.Sprintf("%s on %s", role, rName))
+			}
+		}
+	}
+	sort.Strings(found)
+	return found
+}
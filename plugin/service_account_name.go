@@ -0,0 +1,102 @@
+package gcpsecrets
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// Variables of sa_name_template.
+	saNameVarRoleSet = "roleset"
+	saNameVarRandom  = "random"
+	saNameVarProject = "project"
+
+	serviceAccountMinLen = 6
+
+	// saNameTemplateCheckProject is the project ID templates are rendered
+	// with when validated at config time. It is as short as project IDs get.
+	saNameTemplateCheckProject = "abcdef"
+)
+
+var (
+	saNameTemplateVarRegex = regexp.MustCompile(`\{\{\s*([a-zA-Z_]*)\s*\}\}`)
+	serviceAccountIdRegex  = regexp.MustCompile(`^[a-z]([-a-z0-9]*[a-z0-9])?$`)
+)
+
+// validateServiceAccountNameTemplate checks that the template only uses
+// known variables, includes {{random}} so generated IDs are unique, and
+// renders to a valid service account ID.
+func validateServiceAccountNameTemplate(tmpl string, suffixLen int) error {
+	if !strings.Contains(tmpl, "{{") {
+		return fmt.Errorf("sa_name_template %q has no variables, must include {{%s}} so generated IDs are unique", tmpl, saNameVarRandom)
+	}
+	hasRandom := false
+	for _, m := range saNameTemplateVarRegex.FindAllStringSubmatch(tmpl, -1) {
+		if m[1] == saNameVarRandom {
+			hasRandom = true
+		}
+	}
+	if !hasRandom {
+		return fmt.Errorf("sa_name_template %q must include {{%s}} so generated IDs are unique", tmpl, saNameVarRandom)
+	}
+
+	// Random characters may be letters or digits, so check both.
+	for _, c := range []string{"a", "0"} {
+		if _, err := renderServiceAccountName(tmpl, "a", saNameTemplateCheckProject, strings.Repeat(c, suffixLen)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderServiceAccountName renders a sa_name_template into a service account
+// ID, with random as the value of {{random}}. The role set name is
+// lowercased, sanitized and truncated to fit; the rest of the template must
+// fit on its own.
+func renderServiceAccountName(tmpl, rsName, project, random string) (string, error) {
+	var unknown []string
+	rsCount := 0
+	render := func(rsValue string) string {
+		return saNameTemplateVarRegex.ReplaceAllStringFunc(tmpl, func(v string) string {
+			switch name := saNameTemplateVarRegex.FindStringSubmatch(v)[1]; name {
+			case saNameVarRoleSet:
+				rsCount++
+				return rsValue
+			case saNameVarRandom:
+				return random
+			case saNameVarProject:
+				return project
+			default:
+				unknown = append(unknown, v)
+				return ""
+			}
+		})
+	}
+
+	fixed := render("")
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("sa_name_template has unknown variables %s, must be one of {{%s}}, {{%s}} or {{%s}}",
+			strings.Join(unknown, ", "), saNameVarRoleSet, saNameVarRandom, saNameVarProject)
+	}
+	if len(fixed) > serviceAccountMaxLen {
+		return "", fmt.Errorf("sa_name_template renders to %q for project %q, longer than the %d characters allowed in service account IDs", fixed, project, serviceAccountMaxLen)
+	}
+
+	id := fixed
+	if rsCount > 0 {
+		rsValue := strings.ToLower(rsName)
+		rsValue = regexp.MustCompile("[^a-z0-9-]+").ReplaceAllString(rsValue, "-")
+		if maxLen := (serviceAccountMaxLen - len(fixed)) / rsCount; len(rsValue) > maxLen {
+			rsValue = rsValue[:maxLen]
+		}
+		rsCount = 0
+		id = render(strings.Trim(rsValue, "-"))
+	}
+
+	if len(id) < serviceAccountMinLen || !serviceAccountIdRegex.MatchString(id) {
+		return "", fmt.Errorf("sa_name_template renders to %q, which is not a valid service account ID: IDs must be %d to %d lowercase letters, digits or hyphens, start with a letter and not end with a hyphen",
+			id, serviceAccountMinLen, serviceAccountMaxLen)
+	}
+	return id, nil
+}
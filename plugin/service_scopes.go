@@ -0,0 +1,188 @@
+package gcpsecrets
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const scopePrefix = "https://www.googleapis.com/auth/"
+
+// serviceScopes maps friendly GCP service names to the OAuth scopes that
+// grant access to their APIs. Only services with scopes of their own are
+// listed; the rest only accept cloud-platform.
+var serviceScopes = map[string][]string{
+	"bigquery":         {scopePrefix + "bigquery"},
+	"bigquery-read":    {scopePrefix + "bigquery.readonly"},
+	"bigtable":         {scopePrefix + "bigtable.data"},
+	"bigtable-admin":   {scopePrefix + "bigtable.admin"},
+	"bigtable-read":    {scopePrefix + "bigtable.data.readonly"},
+	"cloudkms":         {scopePrefix + "cloudkms"},
+	"compute":          {scopePrefix + "compute"},
+	"compute-read":     {scopePrefix + "compute.readonly"},
+	"datastore":        {scopePrefix + "datastore"},
+	"firestore":        {scopePrefix + "datastore"},
+	"logging":          {scopePrefix + "logging.admin"},
+	"logging-read":     {scopePrefix + "logging.read"},
+	"logging-write":    {scopePrefix + "logging.write"},
+	"monitoring":       {scopePrefix + "monitoring"},
+	"monitoring-read":  {scopePrefix + "monitoring.read"},
+	"monitoring-write": {scopePrefix + "monitoring.write"},
+	"pubsub":           {scopePrefix + "pubsub"},
+	"spanner":          {scopePrefix + "spanner.data"},
+	"spanner-admin":    {scopePrefix + "spanner.admin"},
+	"sqladmin":         {scopePrefix + "sqlservice.admin"},
+	"storage":          {scopePrefix + "devstorage.full_control"},
+	"storage-read":     {scopePrefix + "devstorage.read_only"},
+	"storage-write":    {scopePrefix + "devstorage.read_write"},
+	"trace":            {scopePrefix + "trace.append"},
+	"userinfo-email":   {scopePrefix + "userinfo.email"},
+}
+
+// scopesForServices returns the scopes of the given services, in order and
+// without duplicates. An error naming the unknown services is returned if
+// any aren't in serviceScopes.
+func scopesForServices(services []string) ([]string, error) {
+	var scopes, unknown []string
+	for _, service := range services {
+		s, ok := serviceScopes[strings.ToLower(strings.TrimSpace(service))]
+		if !ok {
+			unknown = append(unknown, service)
+			continue
+		}
+		scopes = appendUniqueScopes(scopes, s...)
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown services %q, must be one of: %s", unknown, strings.Join(knownServices(), ", "))
+	}
+	return scopes, nil
+}
+
+// appendUniqueScopes appends the scopes not already in scopes.
+func appendUniqueScopes(scopes []string, add ...string) []string {
+	for _, scope := range add {
+		found := false
+		for _, s := range scopes {
+			if s == scope {
+				found = true
+				break
+			}
+		}
+		if !found {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// openIDScopes are the OAuth scopes that aren't URLs.
+var openIDScopes = map[string]bool{
+	"openid":  true,
+	"email":   true,
+	"profile": true,
+}
+
+// normalizeScopes trims the scopes and drops duplicates and empty ones. An
+// error is returned for scopes that are neither https URLs nor one of
+// openIDScopes.
+func normalizeScopes(scopes []string) ([]string, error) {
+	var out []string
+	for _, scope := range scopes {
+		scope = strings.TrimSpace(scope)
+		if scope == "" {
+			continue
+		}
+		if !openIDScopes[scope] {
+			u, err := url.Parse(scope)
+			if err != nil || u.Scheme != "https" || u.Host == "" || strings.ContainsAny(scope, " \t") {
+				return nil, fmt.Errorf("scope %q must be an https URL, such as %q", scope, scopePrefix+"userinfo.email")
+			}
+		}
+		out = appendUniqueScopes(out, scope)
+	}
+	return out, nil
+}
+
+// knownServices returns the names in serviceScopes, sorted.
+func knownServices() []string {
+	names := make([]string, 0, len(serviceScopes))
+	for name := range serviceScopes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// scopeRolePrefixes maps the scopes of serviceScopes to the prefixes of the
+// predefined roles that grant permissions on their APIs. It's a heuristic:
+// a scope is only useful if the service account also has a role on the
+// service, but which roles grant what isn't checked.
+var scopeRolePrefixes = map[string][]string{
+	scopePrefix + "bigquery":                {"roles/bigquery."},
+	scopePrefix + "bigquery.readonly":       {"roles/bigquery."},
+	scopePrefix + "bigtable.data":           {"roles/bigtable."},
+	scopePrefix + "bigtable.admin":          {"roles/bigtable."},
+	scopePrefix + "bigtable.data.readonly":  {"roles/bigtable."},
+	scopePrefix + "cloudkms":                {"roles/cloudkms."},
+	scopePrefix + "compute":                 {"roles/compute."},
+	scopePrefix + "compute.readonly":        {"roles/compute."},
+	scopePrefix + "datastore":               {"roles/datastore."},
+	scopePrefix + "logging.admin":           {"roles/logging."},
+	scopePrefix + "logging.read":            {"roles/logging."},
+	scopePrefix + "logging.write":           {"roles/logging."},
+	scopePrefix + "monitoring":              {"roles/monitoring."},
+	scopePrefix + "monitoring.read":         {"roles/monitoring."},
+	scopePrefix + "monitoring.write":        {"roles/monitoring."},
+	scopePrefix + "pubsub":                  {"roles/pubsub."},
+	scopePrefix + "spanner.data":            {"roles/spanner."},
+	scopePrefix + "spanner.admin":           {"roles/spanner."},
+	scopePrefix + "sqlservice.admin":        {"roles/cloudsql."},
+	scopePrefix + "devstorage.full_control": {"roles/storage."},
+	scopePrefix + "devstorage.read_only":    {"roles/storage."},
+	scopePrefix + "devstorage.read_write":   {"roles/storage."},
+	scopePrefix + "trace.append":            {"roles/cloudtrace."},
+}
+
+// basicRoles grant permissions on most services.
+var basicRoles = map[string]bool{
+	"roles/owner":  true,
+	"roles/editor": true,
+	"roles/viewer": true,
+}
+
+// unmatchedScopes returns the scopes of scopeRolePrefixes for which none of
+// the bound roles is a role of the scope's service, in order. Nothing is
+// returned if a basic or custom role is bound, since they may grant
+// permissions on any service.
+func unmatchedScopes(scopes []string, bindings ResourceBindings) []string {
+	var roles []string
+	for _, rs := range bindings {
+		for role := range rs {
+			if basicRoles[role] || !strings.HasPrefix(role, "roles/") {
+				return nil
+			}
+			roles = append(roles, role)
+		}
+	}
+
+	var unmatched []string
+	for _, scope := range scopes {
+		prefixes, ok := scopeRolePrefixes[scope]
+		if !ok {
+			continue
+		}
+		matched := false
+		for _, role := range roles {
+			for _, prefix := range prefixes {
+				if strings.HasPrefix(role, prefix) {
+					matched = true
+				}
+			}
+		}
+		if !matched {
+			unmatched = append(unmatched, scope)
+		}
+	}
+	return unmatched
+}
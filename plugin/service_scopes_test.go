@@ -0,0 +1,108 @@
+package gcpsecrets
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/util"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestScopesForServices(t *testing.T) {
+	t.Parallel()
+
+	scopes, err := scopesForServices([]string{"storage-read", "BigQuery", "datastore", "firestore"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"https://www.googleapis.com/auth/devstorage.read_only",
+		"https://www.googleapis.com/auth/bigquery",
+		"https://www.googleapis.com/auth/datastore",
+	}
+	if !reflect.DeepEqual(scopes, expected) {
+		t.Fatalf("expected scopes %v, got %v", expected, scopes)
+	}
+
+	if _, err := scopesForServices([]string{"storage", "secretmanager"}); err == nil || !strings.Contains(err.Error(), "secretmanager") {
+		t.Fatalf("expected error naming the unknown service, got %v", err)
+	}
+}
+
+func TestPathRoleSet_UnknownTokenServices(t *testing.T) {
+	t.Parallel()
+
+	b, storage := getTestBackend(t)
+
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roleset/test-tokenservices",
+		Data: map[string]interface{}{
+			"project":        "my-project",
+			"secret_type":    SecretTypeAccessToken,
+			"bindings":       `resource "projects/my-project" { roles = ["roles/viewer"] }`,
+			"token_services": "storage,nope",
+		},
+		Storage: storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "token_services") {
+		t.Fatalf("expected unknown token_services to be rejected, got: %#v", resp)
+	}
+}
+
+func TestUnmatchedScopes(t *testing.T) {
+	t.Parallel()
+
+	scopes := []string{
+		scopePrefix + "bigquery",
+		scopePrefix + "devstorage.read_only",
+		scopePrefix + "userinfo.email",
+		cloudPlatformScope,
+	}
+	bindings := ResourceBindings{
+		"projects/my-project": util.ToSet([]string{"roles/storage.objectViewer"}),
+	}
+	expected := []string{scopePrefix + "bigquery"}
+	if actual := unmatchedScopes(scopes, bindings); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected %v, got %v", expected, actual)
+	}
+
+	for _, role := range []string{"roles/editor", "projects/my-project/roles/custom"} {
+		bindings["projects/other-project"] = util.ToSet([]string{role})
+		if actual := unmatchedScopes(scopes, bindings); len(actual) != 0 {
+			t.Fatalf("expected no unmatched scopes with %s bound, got %v", role, actual)
+		}
+	}
+
+	b, storage := getTestBackend(t)
+	testConfigUpdate(t, b, storage, map[string]interface{}{
+		"warn_unmatched_scopes": true,
+	})
+	rs := testStoredKeyRoleSet(t, storage, "test-unmatchedscopes")
+	rs.SecretType = SecretTypeAccessToken
+	rs.TokenGen = &TokenGenerator{Scopes: []string{cloudPlatformScope}}
+	rs.Bindings = bindings
+	delete(rs.Bindings, "projects/other-project")
+	if err := rs.save(context.Background(), storage); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "roleset/test-unmatchedscopes",
+		Data: map[string]interface{}{
+			"token_scopes": []string{scopePrefix + "bigquery", scopePrefix + "devstorage.read_only"},
+		},
+		Storage: storage,
+	})
+	if err != nil || (resp != nil && resp.IsError()) {
+		t.Fatalf("unexpected error: %v, %#v", err, resp)
+	}
+	if resp == nil || len(resp.Warnings) != 1 || !strings.Contains(resp.Warnings[0], scopePrefix+"bigquery") {
+		t.Fatalf("expected a warning naming the bigquery scope, got %#v", resp)
+	}
+}
@@ -0,0 +1,148 @@
+package gcpsecrets
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/helper/locksutil"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
+)
+
+// sharedTokenStoragePrefix is where access tokens are cached in storage with
+// shared_token_cache, so every node of a cluster returns the same token.
+const sharedTokenStoragePrefix = "token_cache"
+
+// sharedToken is an access token cached in storage.
+type sharedToken struct {
+	AccessToken string    `json:"access_token"`
+	TokenType   string    `json:"token_type"`
+	Expiry      time.Time `json:"expiry"`
+}
+
+// sharedTokenPath returns the storage path of the token cached under the
+// token cache key. Keys contain role set and key names, so they are hashed
+// into a fixed-length path.
+func sharedTokenPath(cacheKey string) string {
+	sum := sha256.Sum256([]byte(cacheKey))
+	return fmt.Sprintf("%s/%s", sharedTokenStoragePrefix, hex.EncodeToString(sum[:]))
+}
+
+// getSharedToken returns the token cached in storage under the cache key if,
+// as with the in-memory cache, it is valid for at least
+// tokenCacheMinRemaining and expires no later than maxExpiry. The cache only
+// saves GCP calls, so entries that can't be read are logged and treated as
+// missing.
+func (b *backend) getSharedToken(ctx context.Context, s logical.Storage, cacheKey string, maxExpiry time.Time) *oauth2.Token {
+	entry, err := s.Get(ctx, sharedTokenPath(cacheKey))
+	if err != nil {
+		b.Logger().Warn("unable to read shared token cache", "error", err)
+		return nil
+	}
+	if entry == nil {
+		return nil
+	}
+	var st sharedToken
+	if err := entry.DecodeJSON(&st); err != nil {
+		b.Logger().Warn("unable to decode shared token cache entry", "error", err)
+		return nil
+	}
+	if time.Until(st.Expiry) < tokenCacheMinRemaining || st.Expiry.After(maxExpiry) {
+		return nil
+	}
+	return &oauth2.Token{
+		AccessToken: st.AccessToken,
+		TokenType:   st.TokenType,
+		Expiry:      st.Expiry,
+	}
+}
+
+// putSharedToken caches the token in storage under the cache key.
+func putSharedToken(ctx context.Context, s logical.Storage, cacheKey string, tkn *oauth2.Token) error {
+	entry, err := logical.StorageEntryJSON(sharedTokenPath(cacheKey), &sharedToken{
+		AccessToken: tkn.AccessToken,
+		TokenType:   tkn.TokenType,
+		Expiry:      tkn.Expiry,
+	})
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, entry)
+}
+
+// cannotWriteSharedTokens returns whether this node can't write to the
+// shared token cache. Requests that need to are forwarded by returning
+// logical.ErrReadOnly, so only the active node generates shared tokens.
+func (b *backend) cannotWriteSharedTokens() bool {
+	return b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby)
+}
+
+// sharedAccessToken returns the token for the cache key from the shared
+// token cache, or generates one with generate and caches it. Generation is
+// serialized per cache key, and the cache is checked again once the lock is
+// held, so concurrent requests on a node don't each generate a token. On
+// performance standbys, cache misses return logical.ErrReadOnly for Vault to
+// forward the request to the active node.
+func (b *backend) sharedAccessToken(ctx context.Context, s logical.Storage, cacheKey string, maxExpiry time.Time, forceNew bool, generate func() (*oauth2.Token, error)) (*oauth2.Token, bool, error) {
+	if !forceNew {
+		if tkn := b.getSharedToken(ctx, s, cacheKey, maxExpiry); tkn != nil {
+			return tkn, true, nil
+		}
+	}
+	if b.cannotWriteSharedTokens() {
+		return nil, false, logical.ErrReadOnly
+	}
+
+	lock := locksutil.LockForKey(b.sharedTokenLocks, cacheKey)
+	lock.Lock()
+	defer lock.Unlock()
+
+	if !forceNew {
+		if tkn := b.getSharedToken(ctx, s, cacheKey, maxExpiry); tkn != nil {
+			return tkn, true, nil
+		}
+	}
+
+	tkn, err := generate()
+	if err != nil {
+		return nil, false, err
+	}
+	if err := putSharedToken(ctx, s, cacheKey, tkn); err != nil {
+		b.Logger().Warn("unable to write shared token cache", "error", err)
+	}
+	return tkn, false, nil
+}
+
+// cleanupSharedTokens deletes expired tokens from the shared token cache, or
+// all of them if all is set.
+func cleanupSharedTokens(ctx context.Context, s logical.Storage, all bool) error {
+	names, err := s.List(ctx, sharedTokenStoragePrefix+"/")
+	if err != nil {
+		return err
+	}
+	var merr *multierror.Error
+	now := time.Now()
+	for _, name := range names {
+		path := fmt.Sprintf("%s/%s", sharedTokenStoragePrefix, name)
+		if !all {
+			entry, err := s.Get(ctx, path)
+			if err != nil {
+				merr = multierror.Append(merr, err)
+				continue
+			}
+			var st sharedToken
+			if entry == nil || entry.DecodeJSON(&st) == nil && st.Expiry.After(now) {
+				continue
+			}
+		}
+		if err := s.Delete(ctx, path); err != nil {
+			merr = multierror.Append(merr, err)
+		}
+	}
+	return merr.ErrorOrNil()
+}
@@ -0,0 +1,74 @@
+package gcpsecrets
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// tokenCacheMinRemaining is how long a cached access token must still be
+// valid for to be returned instead of generating a new one.
+const tokenCacheMinRemaining = 5 * time.Minute
+
+// tokenCache holds the most recent access token generated for each role set
+// key and set of scopes, so repeated requests don't each call GCP.
+type tokenCache struct {
+	l      sync.Mutex
+	tokens map[string]*oauth2.Token
+}
+
+func newTokenCache() *tokenCache {
+	return &tokenCache{
+		tokens: make(map[string]*oauth2.Token),
+	}
+}
+
+// tokenCacheKey identifies tokens generated with the role set's current key
+// and the given scopes, so rotating the key never returns an older token.
+func tokenCacheKey(rs *RoleSet, scopes []string) string {
+	if len(scopes) == 0 {
+		scopes = rs.TokenGen.Scopes
+	}
+	sorted := append([]string(nil), scopes...)
+	sort.Strings(sorted)
+	return rs.Name + "\x00" + rs.TokenGen.KeyName + "\x00" + strings.Join(sorted, " ")
+}
+
+// get returns the cached token for key if it is valid for at least
+// tokenCacheMinRemaining and expires no later than maxExpiry.
+func (c *tokenCache) get(key string, maxExpiry time.Time) *oauth2.Token {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	tkn, ok := c.tokens[key]
+	if !ok || time.Until(tkn.Expiry) < tokenCacheMinRemaining || tkn.Expiry.After(maxExpiry) {
+		return nil
+	}
+	return tkn
+}
+
+// put caches tkn under key, replacing any previous token and dropping
+// tokens that have expired.
+func (c *tokenCache) put(key string, tkn *oauth2.Token) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	now := time.Now()
+	for k, cached := range c.tokens {
+		if !cached.Expiry.After(now) {
+			delete(c.tokens, k)
+		}
+	}
+	c.tokens[key] = tkn
+}
+
+// clear drops all cached tokens.
+func (c *tokenCache) clear() {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	c.tokens = make(map[string]*oauth2.Token)
+}
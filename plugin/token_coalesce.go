@@ -0,0 +1,64 @@
+package gcpsecrets
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"golang.org/x/oauth2"
+)
+
+// errTokenCallAborted is returned to requests waiting on a token generation
+// that ended without a result, which only happens if it panicked.
+var errTokenCallAborted = errors.New("shared token generation did not complete")
+
+// tokenCall is a token generation in flight, shared by every request for the
+// same key that arrives before it completes.
+type tokenCall struct {
+	done  chan struct{}
+	token *oauth2.Token
+	err   error
+}
+
+// tokenCalls coalesces concurrent generations of access tokens for the same
+// key into a single GCP call, when coalesce_token_requests is set.
+type tokenCalls struct {
+	l     sync.Mutex
+	calls map[string]*tokenCall
+}
+
+func newTokenCalls() *tokenCalls {
+	return &tokenCalls{
+		calls: make(map[string]*tokenCall),
+	}
+}
+
+// do calls generate for key, unless a call for key is already in flight, in
+// which case it waits for that call and returns its result, with shared set.
+// Calls are forgotten as soon as they complete, so an error is returned to
+// the requests that shared the call but not to later ones. Waiting stops if
+// ctx is done.
+func (c *tokenCalls) do(ctx context.Context, key string, generate func() (*oauth2.Token, error)) (token *oauth2.Token, shared bool, err error) {
+	c.l.Lock()
+	if call, ok := c.calls[key]; ok {
+		c.l.Unlock()
+		select {
+		case <-call.done:
+			return call.token, true, call.err
+		case <-ctx.Done():
+			return nil, true, ctx.Err()
+		}
+	}
+	call := &tokenCall{done: make(chan struct{}), err: errTokenCallAborted}
+	c.calls[key] = call
+	c.l.Unlock()
+
+	defer func() {
+		c.l.Lock()
+		delete(c.calls, key)
+		c.l.Unlock()
+		close(call.done)
+	}()
+	call.token, call.err = generate()
+	return call.token, false, call.err
+}
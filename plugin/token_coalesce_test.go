@@ -0,0 +1,245 @@
+package gcpsecrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/iamcredentials/v1"
+)
+
+func TestTokenCalls(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	calls := newTokenCalls()
+
+	var generations int32
+	release := make(chan struct{})
+	generate := func() (*oauth2.Token, error) {
+		atomic.AddInt32(&generations, 1)
+		<-release
+		return nil, errors.New("quota exceeded")
+	}
+
+	const waiters = 5
+	var wg sync.WaitGroup
+	errs := make(chan error, waiters+1)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, _, err := calls.do(ctx, "key", generate)
+		errs <- err
+	}()
+	for {
+		calls.l.Lock()
+		_, started := calls.calls["key"]
+		calls.l.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	for i := 0; i < waiters; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, shared, err := calls.do(ctx, "key", generate)
+			if !shared {
+				err = errors.New("expected the call to be shared")
+			}
+			errs <- err
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err == nil || err.Error() != "quota exceeded" {
+			t.Errorf("expected every request to get the shared error, got %v", err)
+		}
+	}
+	if n := atomic.LoadInt32(&generations); n != 1 {
+		t.Fatalf("expected a single generation, got %d", n)
+	}
+
+	// A failed call doesn't affect later ones.
+	token, shared, err := calls.do(ctx, "key", func() (*oauth2.Token, error) {
+		return &oauth2.Token{AccessToken: "tkn"}, nil
+	})
+	if err != nil || shared || token.AccessToken != "tkn" {
+		t.Fatalf("expected a new call to succeed, got %v, %t, %v", token, shared, err)
+	}
+}
+
+func TestSecrets_CoalesceTokenRequests(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var generations int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&generations, 1)
+		time.Sleep(100 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token": "token%d", "token_type": "Bearer", "expires_in": 3600}`, n)
+	}))
+	defer srv.Close()
+
+	// Point the key's token endpoint at the test server.
+	var creds map[string]string
+	keyJSON, err := base64.StdEncoding.DecodeString(testKeyMaterial(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(keyJSON, &creds); err != nil {
+		t.Fatal(err)
+	}
+	creds["token_uri"] = srv.URL + "/token"
+	if keyJSON, err = json.Marshal(creds); err != nil {
+		t.Fatal(err)
+	}
+
+	b, storage := getTestBackend(t)
+	rs := testStoredKeyRoleSet(t, storage, "test-coalesce")
+	rs.SecretType = SecretTypeAccessToken
+	rs.TokenGen = &TokenGenerator{
+		KeyName:    rs.AccountId.ResourceName() + "/keys/key1",
+		B64KeyJSON: base64.StdEncoding.EncodeToString(keyJSON),
+		Scopes:     []string{cloudPlatformScope},
+	}
+	if err := rs.save(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	// With the cache, requests arriving after the token was generated get it
+	// from there, so only concurrent cache misses could call GCP again.
+	testConfigUpdate(t, b, storage, map[string]interface{}{
+		"cache_tokens":            true,
+		"coalesce_token_requests": true,
+	})
+
+	const requests = 10
+	var wg sync.WaitGroup
+	tokens := make(chan interface{}, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := b.HandleRequest(ctx, &logical.Request{
+				Operation: logical.ReadOperation,
+				Path:      "token/" + rs.Name,
+				Storage:   storage,
+			})
+			if err != nil || resp == nil || resp.IsError() {
+				t.Errorf("unexpected response: %#v, %v", resp, err)
+				return
+			}
+			tokens <- resp.Data["token"]
+		}()
+	}
+	wg.Wait()
+	close(tokens)
+
+	for token := range tokens {
+		if token != "token1" {
+			t.Errorf("expected every request to get the shared token, got %v", token)
+		}
+	}
+	if n := atomic.LoadInt32(&generations); n != 1 {
+		t.Fatalf("expected concurrent requests to share a single token generation, got %d", n)
+	}
+}
+
+func TestSecrets_CoalesceTokenRequestsCanceled(t *testing.T) {
+	t.Parallel()
+
+	var generations int32
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	b, storage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&generations, 1)
+		started <- struct{}{}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&iamcredentials.GenerateAccessTokenResponse{
+			AccessToken: fmt.Sprintf("token%d", n),
+			ExpireTime:  time.Now().Add(30 * time.Minute).Format(time.RFC3339),
+		})
+	}))
+
+	rs := testStoredKeyRoleSet(t, storage, "test-coalesce-canceled")
+	rs.SecretType = SecretTypeAccessToken
+	rs.TokenGen = &TokenGenerator{
+		KeyName:    rs.AccountId.ResourceName() + "/keys/key1",
+		B64KeyJSON: testKeyMaterial(t),
+		Scopes:     []string{cloudPlatformScope},
+	}
+	if err := rs.save(context.Background(), storage); err != nil {
+		t.Fatal(err)
+	}
+	testConfigUpdate(t, b, storage, map[string]interface{}{
+		"coalesce_token_requests": true,
+	})
+
+	// Tokens shorter than an hour come from the IAM Credentials API, whose
+	// calls are canceled with their context.
+	getToken := func(ctx context.Context) (*logical.Response, error) {
+		return b.HandleRequest(ctx, &logical.Request{
+			Operation: logical.ReadOperation,
+			Path:      "token/" + rs.Name,
+			Data:      map[string]interface{}{"ttl": "30m"},
+			Storage:   storage,
+		})
+	}
+
+	// The request making the shared call is canceled once the call is in
+	// flight. The call isn't, so a request joining it still gets the token.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	firstDone := make(chan struct{})
+	go func() {
+		defer close(firstDone)
+		getToken(ctx)
+	}()
+	<-started
+	cancel()
+
+	type result struct {
+		resp *logical.Response
+		err  error
+	}
+	second := make(chan result, 1)
+	go func() {
+		resp, err := getToken(context.Background())
+		second <- result{resp, err}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	<-firstDone
+
+	res := <-second
+	if res.err != nil || res.resp == nil || res.resp.IsError() {
+		t.Fatalf("unexpected response: %#v, %v", res.resp, res.err)
+	}
+	if token := res.resp.Data["token"]; token != "token1" {
+		t.Errorf("expected the shared token, got %v", token)
+	}
+	if n := atomic.LoadInt32(&generations); n != 1 {
+		t.Fatalf("expected a single token generation, got %d", n)
+	}
+}
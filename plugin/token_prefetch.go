@@ -0,0 +1,113 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/vault/sdk/helper/consts"
+	"github.com/hashicorp/vault/sdk/logical"
+	"golang.org/x/oauth2"
+)
+
+// tokenPrefetchTimeout bounds prefetching tokens on initialization, so a
+// slow or unreachable GCP doesn't keep it running indefinitely.
+const tokenPrefetchTimeout = 5 * time.Minute
+
+// initialize records when key lease tracking started and starts prefetching
+// tokens, if configured, in the background so the backend is ready without
+// waiting on GCP.
+func (b *backend) initialize(ctx context.Context, req *logical.InitializationRequest) error {
+	// Record when key leases started being tracked, so keys issued before
+	// then are never reaped as untracked. Standbys leave it to the active
+	// node.
+	if !b.System().ReplicationState().HasState(consts.ReplicationPerformanceStandby) {
+		if _, err := keyLeaseTrackingStart(ctx, req.Storage); err != nil {
+			b.Logger().Warn("unable to record when key lease tracking started", "error", err)
+		}
+	}
+
+	cfg, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		b.Logger().Warn("unable to read config, not prefetching access tokens", "error", err)
+		return nil
+	}
+	if cfg == nil || !cfg.PrefetchTokens || !cfg.CacheTokens {
+		return nil
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), tokenPrefetchTimeout)
+		defer cancel()
+
+		count, err := b.prefetchTokens(ctx, req.Storage)
+		if err != nil {
+			b.Logger().Warn("unable to prefetch some access tokens", "prefetched", count, "error", err)
+			return
+		}
+		b.Logger().Info("prefetched access tokens", "prefetched", count)
+	}()
+	return nil
+}
+
+// prefetchTokens generates and caches a token with the default scopes and
+// lifetime for every access token role set, returning how many it cached.
+// Failures for one role set don't stop the others.
+func (b *backend) prefetchTokens(ctx context.Context, s logical.Storage) (int, error) {
+	rsNames, err := s.List(ctx, fmt.Sprintf("%s/", rolesetStoragePrefix))
+	if err != nil {
+		return 0, err
+	}
+
+	cfg, err := getConfig(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+
+	var merr *multierror.Error
+	count := 0
+	for _, rsName := range rsNames {
+		rs, err := getRoleSet(rsName, ctx, s)
+		if err != nil {
+			merr = multierror.Append(merr, err)
+			continue
+		}
+		// Ephemeral role sets issue a single token, which isn't cached.
+		if rs == nil || rs.SecretType != SecretTypeAccessToken || rs.Ephemeral || rs.TokenGen == nil || rs.TokenGen.KeyName == "" {
+			continue
+		}
+
+		// Tokens are cached under the scopes token/:roleset requests them
+		// with by default.
+		scopes, _, err := b.accessTokenScopes(ctx, s, cfg, rs, nil)
+		if err != nil {
+			merr = multierror.Append(merr, errwrap.Wrapf(fmt.Sprintf("role set '%s': {{err}}", rsName), err))
+			continue
+		}
+		ttl, _ := accessTokenTTL(0, rs.MaxTokenTTL)
+		maxExpiry := time.Now().Add(ttl)
+		generate := b.roleSetTokenGenerator(ctx, s, rs, scopes, ttl)
+
+		// With the shared cache, a token another node cached is reused, and
+		// performance standbys leave generating tokens to the active node.
+		cacheKey := tokenCacheKey(rs, scopes)
+		var token *oauth2.Token
+		if cfg.sharedTokenCache() {
+			token, _, err = b.sharedAccessToken(ctx, s, cacheKey, maxExpiry, false, generate)
+			if err == logical.ErrReadOnly {
+				continue
+			}
+		} else {
+			token, err = generate()
+		}
+		if err != nil {
+			merr = multierror.Append(merr, errwrap.Wrapf(fmt.Sprintf("role set '%s': {{err}}", rsName), err))
+			continue
+		}
+		b.tokens.put(cacheKey, token)
+		count++
+	}
+	return count, merr.ErrorOrNil()
+}
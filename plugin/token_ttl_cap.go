@@ -0,0 +1,123 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+// tokenExpireTimeKey is the secret internal data key recording when the
+// Vault token that requested the secret expires, if its lease was capped to
+// it. Renewals are capped to it as well.
+const tokenExpireTimeKey = "token_expire_time"
+
+// callerTokenExpiry returns when the Vault token making the request expires,
+// from its creation time, TTL and explicit max TTL, or false if the token
+// isn't known to the backend or doesn't expire. Token renewals don't update
+// the token entry, so a renewed token may expire later than returned.
+func callerTokenExpiry(req *logical.Request) (time.Time, bool) {
+	te := req.TokenEntry()
+	if te == nil || te.CreationTime <= 0 {
+		return time.Time{}, false
+	}
+	created := time.Unix(te.CreationTime, 0)
+
+	var expiry time.Time
+	if te.TTL > 0 {
+		expiry = created.Add(te.TTL)
+	}
+	if te.ExplicitMaxTTL > 0 {
+		if maxExpiry := created.Add(te.ExplicitMaxTTL); expiry.IsZero() || maxExpiry.Before(expiry) {
+			expiry = maxExpiry
+		}
+	}
+	return expiry, !expiry.IsZero()
+}
+
+// tokenTTLCap returns when the calling Vault token expires if
+// cap_ttl_to_token is set and that is known, or the zero time if secrets
+// aren't capped. It is an error for the token to have expired already.
+func (b *backend) tokenTTLCap(ctx context.Context, req *logical.Request) (time.Time, error) {
+	cfg, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if cfg == nil || !cfg.CapTTLToToken {
+		return time.Time{}, nil
+	}
+	expiry, ok := callerTokenExpiry(req)
+	if !ok {
+		return time.Time{}, nil
+	}
+	if !expiry.After(time.Now()) {
+		return time.Time{}, fmt.Errorf("cap_ttl_to_token is set and the Vault token making the request has no TTL left")
+	}
+	return expiry, nil
+}
+
+// capTTLToExpiry caps ttl so that it ends no later than expiry, returning the
+// capped TTL, truncated to whole seconds, and a warning if it was shortened.
+func capTTLToExpiry(ttl time.Duration, expiry time.Time) (time.Duration, string) {
+	remaining := time.Until(expiry).Truncate(time.Second)
+	if ttl > 0 && ttl <= remaining {
+		return ttl, ""
+	}
+	if remaining < time.Second {
+		remaining = time.Second
+	}
+	return remaining, fmt.Sprintf("TTL of %s was reduced to %s, the remaining TTL of the Vault token making the request, because cap_ttl_to_token is set", ttl, remaining)
+}
+
+// capLeaseTTLToToken caps the TTL of a secret lease, given as requested in
+// seconds with zero for the default, to the remaining TTL of the calling
+// Vault token when cap_ttl_to_token is set. It returns the TTL to issue the
+// lease with, when the token expires if the lease is capped, and a warning if
+// the lease is shorter than it would otherwise be.
+func (b *backend) capLeaseTTLToToken(ctx context.Context, req *logical.Request, ttl int) (int, time.Time, string, error) {
+	expiry, err := b.tokenTTLCap(ctx, req)
+	if err != nil || expiry.IsZero() {
+		return ttl, time.Time{}, "", err
+	}
+	cfg, err := getConfig(ctx, req.Storage)
+	if err != nil {
+		return ttl, time.Time{}, "", err
+	}
+	capped, warning := capTTLToExpiry(b.secretLeaseTTL(cfg, ttl), expiry)
+	return int(capped / time.Second), expiry, warning, nil
+}
+
+// recordTokenTTLCap adds the expiry the lease of a new secret was capped to
+// to its internal data, and the warning to the response, if it was capped.
+func recordTokenTTLCap(resp *logical.Response, expiry time.Time, warning string) {
+	if expiry.IsZero() || resp == nil || resp.Secret == nil {
+		return
+	}
+	if resp.Secret.InternalData == nil {
+		resp.Secret.InternalData = make(map[string]interface{})
+	}
+	resp.Secret.InternalData[tokenExpireTimeKey] = expiry.UTC().Format(time.RFC3339)
+	if warning != "" {
+		resp.AddWarning(warning)
+	}
+}
+
+// capRenewalToToken caps the TTL of a lease renewal to the expiry of the
+// Vault token that requested the secret, if its lease was capped when it was
+// issued. It returns the TTL to renew for and a warning if it was shortened.
+func capRenewalToToken(req *logical.Request, ttl time.Duration) (time.Duration, string, error) {
+	raw, ok := req.Secret.InternalData[tokenExpireTimeKey].(string)
+	if !ok {
+		return ttl, "", nil
+	}
+	expiry, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return ttl, "", fmt.Errorf("invalid secret, internal data has invalid %s %q", tokenExpireTimeKey, raw)
+	}
+	if !expiry.After(time.Now()) {
+		return 0, "", fmt.Errorf("lease cannot be renewed past the expiry of the Vault token that requested it, since cap_ttl_to_token was set")
+	}
+	capped, warning := capTTLToExpiry(ttl, expiry)
+	return capped, warning, nil
+}
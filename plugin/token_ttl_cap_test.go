@@ -0,0 +1,223 @@
+package gcpsecrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/iam/v1"
+	"google.golang.org/api/iamcredentials/v1"
+)
+
+func TestCallerTokenExpiry(t *testing.T) {
+	t.Parallel()
+
+	created := time.Now().Add(-time.Hour).Truncate(time.Second)
+	cases := map[string]struct {
+		te       *logical.TokenEntry
+		expected time.Time
+	}{
+		"no token entry": {},
+		"no TTL":         {te: &logical.TokenEntry{CreationTime: created.Unix()}},
+		"ttl":            {te: &logical.TokenEntry{CreationTime: created.Unix(), TTL: 2 * time.Hour}, expected: created.Add(2 * time.Hour)},
+		"explicit max ttl": {
+			te:       &logical.TokenEntry{CreationTime: created.Unix(), TTL: 2 * time.Hour, ExplicitMaxTTL: 90 * time.Minute},
+			expected: created.Add(90 * time.Minute),
+		},
+		"explicit max ttl only": {
+			te:       &logical.TokenEntry{CreationTime: created.Unix(), ExplicitMaxTTL: 3 * time.Hour},
+			expected: created.Add(3 * time.Hour),
+		},
+	}
+	for name, tc := range cases {
+		req := &logical.Request{}
+		if tc.te != nil {
+			req.SetTokenEntry(tc.te)
+		}
+		expiry, ok := callerTokenExpiry(req)
+		if ok != !tc.expected.IsZero() || !expiry.Equal(tc.expected) {
+			t.Errorf("%s: expected expiry %v, got %v (%t)", name, tc.expected, expiry, ok)
+		}
+	}
+}
+
+func TestCapRenewalToToken(t *testing.T) {
+	t.Parallel()
+
+	req := &logical.Request{Secret: &logical.Secret{InternalData: map[string]interface{}{}}}
+	if ttl, warning, err := capRenewalToToken(req, time.Hour); err != nil || ttl != time.Hour || warning != "" {
+		t.Fatalf("expected uncapped leases to renew as requested, got %s, %q, %v", ttl, warning, err)
+	}
+
+	req.Secret.InternalData[tokenExpireTimeKey] = time.Now().Add(10 * time.Minute).UTC().Format(time.RFC3339)
+	ttl, warning, err := capRenewalToToken(req, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl > 10*time.Minute || ttl < 9*time.Minute || warning == "" {
+		t.Fatalf("expected the renewal to be capped to about 10m with a warning, got %s, %q", ttl, warning)
+	}
+
+	req.Secret.InternalData[tokenExpireTimeKey] = time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)
+	if _, _, err := capRenewalToToken(req, time.Hour); err == nil {
+		t.Fatal("expected an error renewing past the token's expiry")
+	}
+}
+
+func TestSecrets_CapTTLToToken(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	keyData := testKeyMaterial(t)
+	var rs *RoleSet
+	b, storage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		saName := rs.AccountId.ResourceName()
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/"+saName:
+			json.NewEncoder(w).Encode(&iam.ServiceAccount{Name: saName, Email: rs.AccountId.EmailOrId})
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/"+saName+"/keys":
+			json.NewEncoder(w).Encode(&iam.ServiceAccountKey{
+				Name:           fmt.Sprintf("%s/keys/key%d", saName, time.Now().UnixNano()),
+				PrivateKeyType: privateKeyTypeJson,
+				PrivateKeyData: keyData,
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	rs = testStoredKeyRoleSet(t, storage, "test-capttl")
+	testConfigUpdate(t, b, storage, map[string]interface{}{
+		"ttl": "2h",
+	})
+
+	getKey := func(te *logical.TokenEntry) *logical.Response {
+		req := &logical.Request{
+			Operation: logical.UpdateOperation,
+			Path:      "key/" + rs.Name,
+			Storage:   storage,
+		}
+		if te != nil {
+			req.SetTokenEntry(te)
+		}
+		resp, err := b.HandleRequest(ctx, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	shortToken := &logical.TokenEntry{CreationTime: time.Now().Unix(), TTL: 10 * time.Minute}
+
+	// Leases aren't capped unless cap_ttl_to_token is set.
+	resp := getKey(shortToken)
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+	if resp.Secret.TTL != 2*time.Hour {
+		t.Fatalf("expected the configured lease TTL, got %s", resp.Secret.TTL)
+	}
+
+	testConfigUpdate(t, b, storage, map[string]interface{}{
+		"cap_ttl_to_token": true,
+	})
+
+	resp = getKey(shortToken)
+	if resp == nil || resp.IsError() {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+	if resp.Secret.TTL > 10*time.Minute || resp.Secret.TTL < 9*time.Minute {
+		t.Fatalf("expected the lease TTL to be capped to the token's, got %s", resp.Secret.TTL)
+	}
+	if _, ok := resp.Secret.InternalData[tokenExpireTimeKey]; !ok {
+		t.Fatalf("expected the token expiry to be recorded in the secret")
+	}
+	if len(resp.Warnings) == 0 {
+		t.Fatalf("expected a warning that the lease was capped")
+	}
+
+	// Tokens that outlive the lease, or whose TTL isn't known, don't cap it.
+	for _, te := range []*logical.TokenEntry{{CreationTime: time.Now().Unix(), TTL: 24 * time.Hour}, nil} {
+		resp = getKey(te)
+		if resp == nil || resp.IsError() {
+			t.Fatalf("unexpected response: %#v", resp)
+		}
+		if resp.Secret.TTL != 2*time.Hour || len(resp.Warnings) != 0 {
+			t.Fatalf("expected an uncapped lease without warnings, got %s, %v", resp.Secret.TTL, resp.Warnings)
+		}
+	}
+
+	resp = getKey(&logical.TokenEntry{CreationTime: time.Now().Add(-time.Hour).Unix(), TTL: time.Minute})
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected an error for an expired token, got %#v", resp)
+	}
+}
+
+func TestSecrets_CapTTLToTokenAccessToken(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var rs *RoleSet
+	var lifetimes []string
+	b, storage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/projects/-/serviceAccounts/"+rs.AccountId.EmailOrId+":generateAccessToken" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		var req iamcredentials.GenerateAccessTokenRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Error(err)
+		}
+		lifetimes = append(lifetimes, req.Lifetime)
+		lifetime, err := time.ParseDuration(req.Lifetime)
+		if err != nil {
+			t.Error(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&iamcredentials.GenerateAccessTokenResponse{
+			AccessToken: "capped",
+			ExpireTime:  time.Now().Add(lifetime).UTC().Format(time.RFC3339),
+		})
+	}))
+	rs = testStoredKeyRoleSet(t, storage, "test-capttl-token")
+	rs.SecretType = SecretTypeAccessToken
+	rs.TokenGen = &TokenGenerator{
+		KeyName: rs.AccountId.ResourceName() + "/keys/key1",
+		Scopes:  []string{cloudPlatformScope},
+	}
+	if err := rs.save(ctx, storage); err != nil {
+		t.Fatal(err)
+	}
+	testConfigUpdate(t, b, storage, map[string]interface{}{
+		"cap_ttl_to_token": true,
+	})
+
+	req := &logical.Request{
+		Operation: logical.ReadOperation,
+		Path:      "token/" + rs.Name,
+		Storage:   storage,
+	}
+	req.SetTokenEntry(&logical.TokenEntry{CreationTime: time.Now().Unix(), TTL: 10 * time.Minute})
+	resp, err := b.HandleRequest(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || resp.IsError() || resp.Data["token"] != "capped" {
+		t.Fatalf("unexpected response: %#v", resp)
+	}
+
+	// The token GCP issues expires with the Vault token, not just the
+	// expiry reported for it.
+	if len(lifetimes) != 1 || (lifetimes[0] != "600s" && lifetimes[0] != "599s") {
+		t.Fatalf("expected a lifetime of about 600s to be requested, got %v", lifetimes)
+	}
+	if ttl := resp.Data["token_ttl"].(time.Duration); ttl > 600 || ttl < 590 {
+		t.Fatalf("expected a token TTL of about 600s, got %d", ttl)
+	}
+	if len(resp.Warnings) == 0 {
+		t.Fatalf("expected a warning that the token lifetime was capped")
+	}
+}
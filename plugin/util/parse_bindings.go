@@ -31,6 +31,15 @@ func BindingsHCL(bindings map[string]StringSet) (string, error) {
 }
 
 func ParseBindings(bindingsStr string) (map[string]StringSet, error) {
+	bindings, _, err := ParseBindingsWithMembers(bindingsStr)
+	return bindings, err
+}
+
+// ParseBindingsWithMembers parses bindings like ParseBindings, and also
+// returns the additional members listed in each resource's optional
+// "members" list, by resource name. Resources without members are omitted.
+// Member formats are not validated.
+func ParseBindingsWithMembers(bindingsStr string) (map[string]StringSet, map[string]StringSet, error) {
 	// Try to base64 decode
 	decoder := base64.NewDecoder(base64.StdEncoding, strings.NewReader(bindingsStr))
 	decoded, b64err := ioutil.ReadAll(decoder)
@@ -45,43 +54,44 @@ func ParseBindings(bindingsStr string) (map[string]StringSet, error) {
 	root, err := hcl.Parse(bindsString)
 	if err != nil {
 		if b64err == nil {
-			return nil, errwrap.Wrapf("unable to parse base64-encoded bindings as valid HCL: {{err}}", err)
+			return nil, nil, errwrap.Wrapf("unable to parse base64-encoded bindings as valid HCL: {{err}}", err)
 		} else {
-			return nil, errwrap.Wrapf("unable to parse raw string bindings as valid HCL: {{err}}", err)
+			return nil, nil, errwrap.Wrapf("unable to parse raw string bindings as valid HCL: {{err}}", err)
 		}
 	}
 
 	bindingLst, ok := root.Node.(*ast.ObjectList)
 	if !ok {
-		return nil, errors.New("unable to parse bindings: does not contain a root object")
+		return nil, nil, errors.New("unable to parse bindings: does not contain a root object")
 	}
 
-	bindingsMap, err := parseBindingObjList(bindingLst)
+	bindingsMap, membersMap, err := parseBindingObjList(bindingLst)
 	if err != nil {
-		return nil, errwrap.Wrapf("unable to parse bindings: {{err}}", err)
+		return nil, nil, errwrap.Wrapf("unable to parse bindings: {{err}}", err)
 	}
-	return bindingsMap, nil
+	return bindingsMap, membersMap, nil
 }
 
-func parseBindingObjList(topList *ast.ObjectList) (map[string]StringSet, error) {
+func parseBindingObjList(topList *ast.ObjectList) (map[string]StringSet, map[string]StringSet, error) {
 	var merr *multierror.Error
 
 	bindings := make(map[string]StringSet)
+	members := make(map[string]StringSet)
 
 	for _, item := range topList.Items {
-		err := parseResourceObject(item, bindings)
+		err := parseResourceObject(item, bindings, members)
 		if err != nil {
 			merr = multierror.Append(merr, fmt.Errorf("(line %d) %v", item.Assign.Line, err))
 		}
 	}
 	err := merr.ErrorOrNil()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return bindings, nil
+	return bindings, members, nil
 }
 
-func parseResourceObject(item *ast.ObjectItem, bindings map[string]StringSet) error {
+func parseResourceObject(item *ast.ObjectItem, bindings map[string]StringSet, members map[string]StringSet) error {
 	if len(item.Keys) != 2 || item.Keys[0] == nil || item.Keys[1] == nil {
 		return fmt.Errorf(`top-level items must have format "resource" "$resource_name"`)
 	}
@@ -111,11 +121,51 @@ func parseResourceObject(item *ast.ObjectItem, bindings map[string]StringSet) er
 	}
 
 	var merr *multierror.Error
-	for _, rolesObj := range resourceItemList.Items {
-		err := parseRolesObject(rolesObj, boundRoles)
+	for _, obj := range resourceItemList.Items {
+		if isObjectKey(obj, "members") {
+			if _, ok := members[resourceName]; !ok {
+				members[resourceName] = make(StringSet)
+			}
+			if err := parseMembersObject(obj, members[resourceName]); err != nil {
+				merr = multierror.Append(merr, fmt.Errorf("member list (line %d): %v", obj.Assign.Line, err))
+			}
+			continue
+		}
+		err := parseRolesObject(obj, boundRoles)
 		if err != nil {
-			merr = multierror.Append(merr, fmt.Errorf("role list (line %d): %v", rolesObj.Assign.Line, err))
+			merr = multierror.Append(merr, fmt.Errorf("role list (line %d): %v", obj.Assign.Line, err))
+		}
+	}
+	return merr.ErrorOrNil()
+}
+
+// isObjectKey returns whether the object item has the single key k.
+func isObjectKey(obj *ast.ObjectItem, k string) bool {
+	if obj == nil || len(obj.Keys) != 1 || obj.Keys[0] == nil {
+		return false
+	}
+	v, err := parseStringFromObjectKey(obj, obj.Keys[0])
+	return err == nil && v == k
+}
+
+func parseMembersObject(membersObj *ast.ObjectItem, parsedMembers StringSet) error {
+	memberList, ok := membersObj.Val.(*ast.ListType)
+	if !ok {
+		return fmt.Errorf("parsing error, expected list of members for key 'members'")
+	}
+	var merr *multierror.Error
+	for _, memberNode := range memberList.List {
+		lit, ok := memberNode.(*ast.LiteralType)
+		if !ok || lit == nil {
+			merr = multierror.Append(merr, fmt.Errorf(`unexpected nil item in members list (line %d)`, membersObj.Assign.Line))
+			continue
+		}
+		member, ok := lit.Token.Value().(string)
+		if !ok || member == "" {
+			merr = multierror.Append(merr, fmt.Errorf(`unexpected item %v in members list is not a non-empty string (line %d)`, lit.Token.Value(), membersObj.Assign.Line))
+			continue
 		}
+		parsedMembers.Add(member)
 	}
 	return merr.ErrorOrNil()
 }
@@ -129,7 +179,7 @@ func parseRolesObject(rolesObj *ast.ObjectItem, parsedRoles StringSet) error {
 		return err
 	}
 	if k != "roles" {
-		return fmt.Errorf(`invalid key %q in resource, expected "roles"`, k)
+		return fmt.Errorf(`invalid key %q in resource, expected "roles" or "members"`, k)
 	}
 
 	if rolesObj.Val == nil {
@@ -87,3 +87,27 @@ func checkParseBindings(t *testing.T, encodeB64 bool) {
 		}
 	}
 }
+
+func TestParseBindingsWithMembers(t *testing.T) {
+	binds, members, err := ParseBindingsWithMembers(`
+		resource "projects/X" {
+			roles = ["roles/viewer"]
+			members = ["group:admins@example.com", "user:me@example.com"]
+		}
+		resource "projects/Y" {
+			roles = ["roles/viewer"]
+		}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(binds) != 2 {
+		t.Fatalf("expected bindings for 2 resources, got %v", binds)
+	}
+	if len(members) != 1 || !members["projects/X"].Equals(ToSet([]string{"group:admins@example.com", "user:me@example.com"})) {
+		t.Fatalf("expected members for projects/X only, got %v", members)
+	}
+
+	if _, _, err := ParseBindingsWithMembers(`resource "projects/X" { roles = ["roles/viewer"] members = [""] }`); err == nil {
+		t.Fatal("expected error for empty member")
+	}
+}
@@ -50,15 +50,13 @@ func (ss StringSet) Union(other StringSet) StringSet {
 func (ss StringSet) Intersection(other StringSet) StringSet {
 	inter := make(StringSet)
 
-	var s StringSet
+	s, t := ss, other
 	if len(ss) > len(other) {
-		s = other
-	} else {
-		s = ss
+		s, t = other, ss
 	}
 
 	for v := range s {
-		if other.Includes(v) {
+		if t.Includes(v) {
 			inter[v] = struct{}{}
 		}
 	}
@@ -0,0 +1,103 @@
+package gcpsecrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	// vpcServiceControlsViolationType is the type of the
+	// google.rpc.PreconditionFailure violation of errors for requests
+	// blocked by a VPC Service Controls perimeter. Its description is the
+	// unique identifier of the denial.
+	vpcServiceControlsViolationType = "VPC_SERVICE_CONTROLS"
+
+	// securityPolicyViolatedReason is the reason of the
+	// google.rpc.ErrorInfo detail of the same errors.
+	securityPolicyViolatedReason = "SECURITY_POLICY_VIOLATED"
+
+	// vpcServiceControlsHint starts the explanation added to errors for
+	// requests blocked by a VPC Service Controls perimeter.
+	vpcServiceControlsHint = "blocked by a VPC Service Controls perimeter"
+)
+
+// vpcServiceControlsReasons are the reasons GCP gives in the legacy "errors"
+// list of errors for requests blocked by a VPC Service Controls perimeter.
+var vpcServiceControlsReasons = map[string]bool{
+	"vpcServiceControls":     true,
+	"securityPolicyViolated": true,
+}
+
+// vpcServiceControlsDenial returns whether err is a GCP error for a request
+// blocked by a VPC Service Controls perimeter, and the unique identifier GCP
+// gave the denial, if any, which perimeter administrators can look up in
+// the audit logs.
+func vpcServiceControlsDenial(err error) (bool, string) {
+	if err == nil {
+		return false, ""
+	}
+	gErr, ok := errwrap.GetType(err, &googleapi.Error{}).(*googleapi.Error)
+	if !ok || gErr == nil || gErr.Code != http.StatusForbidden {
+		return false, ""
+	}
+
+	denied := false
+	for _, item := range gErr.Errors {
+		if vpcServiceControlsReasons[item.Reason] {
+			denied = true
+		}
+	}
+
+	var body struct {
+		Error struct {
+			Details []struct {
+				Type       string `json:"@type"`
+				Reason     string `json:"reason"`
+				Violations []struct {
+					Type        string `json:"type"`
+					Description string `json:"description"`
+				} `json:"violations"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(gErr.Body), &body); err != nil {
+		return denied, ""
+	}
+	var id string
+	for _, d := range body.Error.Details {
+		switch {
+		case strings.HasSuffix(d.Type, "google.rpc.ErrorInfo") && d.Reason == securityPolicyViolatedReason:
+			denied = true
+		case strings.HasSuffix(d.Type, "google.rpc.PreconditionFailure"):
+			for _, v := range d.Violations {
+				if v.Type == vpcServiceControlsViolationType {
+					denied = true
+					id = v.Description
+				}
+			}
+		}
+	}
+	return denied, id
+}
+
+// explainVPCServiceControls adds to err, if it is for a request blocked by a
+// VPC Service Controls perimeter, what the denial means and what to give
+// the perimeter's administrators, since GCP only reports it as permission
+// denied. The GCP error is still retrievable from the returned error.
+func explainVPCServiceControls(err error) error {
+	denied, id := vpcServiceControlsDenial(err)
+	if !denied || strings.Contains(err.Error(), vpcServiceControlsHint) {
+		return err
+	}
+	msg := fmt.Sprintf("%s (%s: the project is inside a service perimeter that doesn't allow this request from the backend's credentials or network; "+
+		"IAM permissions can't fix this, the perimeter needs an access level or ingress rule for it", err, vpcServiceControlsHint)
+	if id != "" {
+		msg += fmt.Sprintf(", which its administrators can find in the audit logs by the VPC Service Controls unique identifier %s", id)
+	}
+	return errwrap.Wrap(fmt.Errorf("%s)", msg), err)
+}
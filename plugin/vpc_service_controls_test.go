@@ -0,0 +1,92 @@
+package gcpsecrets
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/googleapi"
+)
+
+const testVPCServiceControlsBody = `{"error": {"code": 403, "message": "Request is prohibited by organization's policy. vpcServiceControlsUniqueIdentifier: vpcsc-abc123", "status": "PERMISSION_DENIED", "details": [` +
+	`{"@type": "type.googleapis.com/google.rpc.PreconditionFailure", "violations": [{"type": "VPC_SERVICE_CONTROLS", "description": "vpcsc-abc123"}]},` +
+	`{"@type": "type.googleapis.com/google.rpc.ErrorInfo", "reason": "SECURITY_POLICY_VIOLATED", "domain": "googleapis.com"}]}}`
+
+func TestVPCServiceControlsDenial(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		err    error
+		denied bool
+		id     string
+	}{
+		"not a GCP error": {
+			err: errwrap.Wrapf("failed: {{err}}", http.ErrHandlerTimeout),
+		},
+		"permission denied": {
+			err: &googleapi.Error{Code: http.StatusForbidden, Body: `{"error": {"code": 403, "status": "PERMISSION_DENIED"}}`},
+		},
+		"details": {
+			err:    errwrap.Wrapf("unable to create key: {{err}}", &googleapi.Error{Code: http.StatusForbidden, Body: testVPCServiceControlsBody}),
+			denied: true,
+			id:     "vpcsc-abc123",
+		},
+		"legacy reason": {
+			err: &googleapi.Error{
+				Code:   http.StatusForbidden,
+				Errors: []googleapi.ErrorItem{{Reason: "vpcServiceControls"}},
+			},
+			denied: true,
+		},
+		"not forbidden": {
+			err: &googleapi.Error{Code: http.StatusBadRequest, Body: testVPCServiceControlsBody},
+		},
+	}
+
+	for name, tc := range cases {
+		denied, id := vpcServiceControlsDenial(tc.err)
+		if denied != tc.denied || id != tc.id {
+			t.Errorf("%s: expected denied %v with ID %q, got %v with %q", name, tc.denied, tc.id, denied, id)
+		}
+	}
+}
+
+func TestVPCServiceControls_ErrorResponse(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	b, storage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte(testVPCServiceControlsBody))
+	}))
+
+	rs := testStoredKeyRoleSet(t, storage, "test-vpcsc")
+	resp, err := b.HandleRequest(ctx, &logical.Request{
+		Operation: logical.UpdateOperation,
+		Path:      "key/revoke-by-name",
+		Storage:   storage,
+		Data: map[string]interface{}{
+			"key_name":         rs.AccountId.ResourceName() + "/keys/abc",
+			"delete_untracked": true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() {
+		t.Fatalf("expected error response, got %#v", resp)
+	}
+	msg := resp.Error().Error()
+	for _, exp := range []string{vpcServiceControlsHint, "vpcsc-abc123"} {
+		if !strings.Contains(msg, exp) {
+			t.Errorf("expected error to contain %q, got %q", exp, msg)
+		}
+	}
+	if n := strings.Count(msg, vpcServiceControlsHint); n != 1 {
+		t.Errorf("expected the denial to be explained once, got %d times in %q", n, msg)
+	}
+}
@@ -0,0 +1,152 @@
+package gcpsecrets
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/util"
+	"github.com/hashicorp/vault/sdk/logical"
+	"google.golang.org/api/cloudresourcemanager/v1"
+)
+
+var (
+	// wildcardProjectPrefixes are the forms of project resource names whose
+	// project ID can be a wildcard pattern.
+	wildcardProjectPrefixes = []string{"//cloudresourcemanager.googleapis.com/projects/", "projects/"}
+
+	// wildcardProjectPatternRe matches project ID patterns: project ID
+	// characters, with "*" matching any run of them.
+	wildcardProjectPatternRe = regexp.MustCompile(`^[a-z0-9*-]+$`)
+
+	// wildcardProjectParentRe matches values of wildcard_project_parent.
+	wildcardProjectParentRe = regexp.MustCompile(`^(folders|organizations)/[0-9]+$`)
+)
+
+// wildcardProject returns the resource name prefix and project ID pattern of
+// a bound resource naming projects with a wildcard, or ok false if the
+// resource has no wildcard.
+func wildcardProject(rName string) (prefix, pattern string, ok bool, err error) {
+	if !strings.Contains(rName, "*") {
+		return "", "", false, nil
+	}
+	for _, prefix := range wildcardProjectPrefixes {
+		if pattern := strings.TrimPrefix(rName, prefix); pattern != rName && wildcardProjectPatternRe.MatchString(pattern) {
+			return prefix, pattern, true, nil
+		}
+	}
+	return "", "", false, fmt.Errorf(`resource %q has a wildcard, which is only supported in the project ID of a project resource, e.g. "projects/team-a-*"`, rName)
+}
+
+// hasWildcardProjects returns whether any bound resource names projects with
+// a wildcard.
+func hasWildcardProjects(rb ResourceBindings) bool {
+	for rName := range rb {
+		if strings.Contains(rName, "*") {
+			return true
+		}
+	}
+	return false
+}
+
+// expandWildcardProjects replaces the bound resources naming projects with a
+// wildcard by every matching project under the config's
+// wildcard_project_parent, merging their roles and members into any the
+// project is already bound with. It returns the concrete bindings and
+// members, and the projects each pattern expanded to, by pattern.
+func (b *backend) expandWildcardProjects(ctx context.Context, s logical.Storage, cfg *config, rb ResourceBindings, members map[string]util.StringSet) (ResourceBindings, map[string]util.StringSet, map[string][]string, error) {
+	if !hasWildcardProjects(rb) {
+		return rb, members, nil, nil
+	}
+	if cfg == nil || cfg.WildcardProjectParent == "" {
+		return nil, nil, nil, fmt.Errorf("bindings have wildcard resources, which require wildcard_project_parent to be set on the config")
+	}
+
+	projects, err := b.listParentProjects(ctx, s, cfg.WildcardProjectParent)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	expanded := make(ResourceBindings)
+	expandedMembers := make(map[string]util.StringSet)
+	expansions := make(map[string][]string)
+	add := func(rName, from string) {
+		if _, ok := expanded[rName]; !ok {
+			expanded[rName] = make(util.StringSet)
+		}
+		expanded[rName] = expanded[rName].Union(rb[from])
+		if m := members[from]; len(m) > 0 {
+			if _, ok := expandedMembers[rName]; !ok {
+				expandedMembers[rName] = make(util.StringSet)
+			}
+			expandedMembers[rName] = expandedMembers[rName].Union(m)
+		}
+	}
+
+	rNames := make([]string, 0, len(rb))
+	for rName := range rb {
+		rNames = append(rNames, rName)
+	}
+	sort.Strings(rNames)
+	for _, rName := range rNames {
+		prefix, pattern, ok, err := wildcardProject(rName)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if !ok {
+			add(rName, rName)
+			continue
+		}
+
+		var matched []string
+		for _, project := range projects {
+			if m, _ := path.Match(pattern, project); m {
+				matched = append(matched, prefix+project)
+				add(prefix+project, rName)
+			}
+		}
+		if len(matched) == 0 {
+			return nil, nil, nil, fmt.Errorf("resource %q matches no active projects under %s", rName, cfg.WildcardProjectParent)
+		}
+		expansions[rName] = matched
+	}
+
+	if len(expandedMembers) == 0 {
+		expandedMembers = nil
+	}
+	return expanded, expandedMembers, expansions, nil
+}
+
+// listParentProjects returns the IDs of the active projects directly under
+// the given folder or organization, sorted.
+func (b *backend) listParentProjects(ctx context.Context, s logical.Storage, parent string) ([]string, error) {
+	crmC, err := b.ResourceManagerClient(s)
+	if err != nil {
+		return nil, err
+	}
+
+	parentType := "folder"
+	if strings.HasPrefix(parent, "organizations/") {
+		parentType = "organization"
+	}
+	filter := fmt.Sprintf("parent.type:%s parent.id:%s lifecycleState:ACTIVE", parentType, parent[strings.Index(parent, "/")+1:])
+
+	var projects []string
+	err = crmC.Projects.List().Filter(filter).Pages(ctx, func(resp *cloudresourcemanager.ListProjectsResponse) error {
+		for _, p := range resp.Projects {
+			if p.LifecycleState == "" || p.LifecycleState == "ACTIVE" {
+				projects = append(projects, p.ProjectId)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errwrap.Wrapf(fmt.Sprintf("unable to list projects under %s: {{err}}", parent), b.withGoogleRequestID(parent, err))
+	}
+	sort.Strings(projects)
+	return projects, nil
+}
@@ -0,0 +1,136 @@
+package gcpsecrets
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/vault-plugin-secrets-gcp/plugin/util"
+	"github.com/hashicorp/vault/sdk/logical"
+)
+
+func TestWildcardProject(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		rName   string
+		prefix  string
+		pattern string
+		ok      bool
+		err     bool
+	}{
+		{rName: "projects/team-a-dev"},
+		{rName: "projects/team-a-*", prefix: "projects/", pattern: "team-a-*", ok: true},
+		{rName: "//cloudresourcemanager.googleapis.com/projects/*-dev", prefix: "//cloudresourcemanager.googleapis.com/projects/", pattern: "*-dev", ok: true},
+		{rName: "projects/team-a-*/topics/events", err: true},
+		{rName: "projects/team-a-[ab]*", err: true},
+		{rName: "//pubsub.googleapis.com/projects/team-a-*", err: true},
+		{rName: "folders/*", err: true},
+	}
+	for _, tc := range cases {
+		prefix, pattern, ok, err := wildcardProject(tc.rName)
+		if (err != nil) != tc.err {
+			t.Errorf("%s: expected error %t, got %v", tc.rName, tc.err, err)
+			continue
+		}
+		if prefix != tc.prefix || pattern != tc.pattern || ok != tc.ok {
+			t.Errorf("%s: expected (%q, %q, %t), got (%q, %q, %t)", tc.rName, tc.prefix, tc.pattern, tc.ok, prefix, pattern, ok)
+		}
+	}
+}
+
+func TestExpandWildcardProjects(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	var filter string
+	gb, storage := getTestBackendWithIAMServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || r.URL.Path != "/v1/projects" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		filter = r.URL.Query().Get("filter")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"projects": [
+			{"projectId": "team-a-dev", "lifecycleState": "ACTIVE"},
+			{"projectId": "team-a-staging", "lifecycleState": "ACTIVE"},
+			{"projectId": "team-a-old", "lifecycleState": "DELETE_REQUESTED"},
+			{"projectId": "team-b-dev", "lifecycleState": "ACTIVE"}
+		]}`))
+	}))
+	b := gb.(*backend)
+	cfg := &config{WildcardProjectParent: "folders/123"}
+
+	rb := ResourceBindings{
+		"projects/team-a-*":            util.ToSet([]string{"roles/viewer"}),
+		"projects/team-a-dev":          util.ToSet([]string{"roles/editor"}),
+		"projects/team-b-dev/topics/x": util.ToSet([]string{"roles/pubsub.publisher"}),
+	}
+	members := map[string]util.StringSet{
+		"projects/team-a-*": util.ToSet([]string{"group:team-a@example.com"}),
+	}
+	expanded, expandedMembers, expansions, err := b.expandWildcardProjects(ctx, storage, cfg, rb, members)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "parent.type:folder parent.id:123 lifecycleState:ACTIVE"; filter != expected {
+		t.Fatalf("expected filter %q, got %q", expected, filter)
+	}
+
+	expectedBindings := map[string][]string{
+		"projects/team-a-dev":          {"roles/editor", "roles/viewer"},
+		"projects/team-a-staging":      {"roles/viewer"},
+		"projects/team-b-dev/topics/x": {"roles/pubsub.publisher"},
+	}
+	if out := expanded.asOutput(); !reflect.DeepEqual(out, expectedBindings) {
+		t.Fatalf("expected bindings %v, got %v", expectedBindings, out)
+	}
+	expectedMembers := map[string][]string{
+		"projects/team-a-dev":     {"group:team-a@example.com"},
+		"projects/team-a-staging": {"group:team-a@example.com"},
+	}
+	if out := bindingMembersOutput(expandedMembers); !reflect.DeepEqual(out, expectedMembers) {
+		t.Fatalf("expected members %v, got %v", expectedMembers, out)
+	}
+	expectedExpansions := map[string][]string{
+		"projects/team-a-*": {"projects/team-a-dev", "projects/team-a-staging"},
+	}
+	if !reflect.DeepEqual(expansions, expectedExpansions) {
+		t.Fatalf("expected expansions %v, got %v", expectedExpansions, expansions)
+	}
+
+	// Bindings without wildcards are returned as they are.
+	plain := ResourceBindings{"projects/team-a-dev": util.ToSet([]string{"roles/viewer"})}
+	if got, _, expansions, err := b.expandWildcardProjects(ctx, storage, &config{}, plain, nil); err != nil || !reflect.DeepEqual(got, plain) || expansions != nil {
+		t.Fatalf("expected bindings without wildcards to be unchanged, got %v, %v, %v", got, expansions, err)
+	}
+
+	// A pattern must match at least one project.
+	if _, _, _, err := b.expandWildcardProjects(ctx, storage, cfg, ResourceBindings{"projects/team-c-*": util.ToSet([]string{"roles/viewer"})}, nil); err == nil || !strings.Contains(err.Error(), "matches no active projects") {
+		t.Fatalf("expected an error for a pattern matching no projects, got %v", err)
+	}
+}
+
+func TestPathRoleSet_WildcardRequiresParent(t *testing.T) {
+	t.Parallel()
+
+	b, storage := getTestBackend(t)
+	resp, err := b.HandleRequest(context.Background(), &logical.Request{
+		Operation: logical.CreateOperation,
+		Path:      "roleset/test-wildcard",
+		Data: map[string]interface{}{
+			"project":     "my-project",
+			"secret_type": SecretTypeKey,
+			"bindings":    `resource "projects/team-a-*" { roles = ["roles/viewer"] }`,
+		},
+		Storage: storage,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil || !resp.IsError() || !strings.Contains(resp.Error().Error(), "wildcard_project_parent") {
+		t.Fatalf("expected an error requiring wildcard_project_parent, got %#v", resp)
+	}
+}